@@ -12,6 +12,10 @@ pub struct OutputConfig {
 
     /// Organization strategy for output files
     pub organization: OrganizationStrategy,
+
+    /// Packaging of each output directory
+    #[serde(default)]
+    pub preset: OutputPreset,
 }
 
 impl OutputConfig {
@@ -28,6 +32,7 @@ impl Default for OutputConfig {
         Self {
             base_path: PathBuf::from("./generated"),
             organization: OrganizationStrategy::ApiVersion,
+            preset: OutputPreset::default(),
         }
     }
 }
@@ -45,3 +50,21 @@ pub enum OrganizationStrategy {
     /// Hierarchical organization (nested directories)
     Hierarchical,
 }
+
+/// Packaging of the generated files of an output directory
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum OutputPreset {
+    /// Generated files at the top of the output directory
+    #[default]
+    Plain,
+
+    /// Tanka library: `main.libsonnet` over the generated files in `gen/`,
+    /// with the hand-written mixins of `_custom/`
+    Tanka,
+
+    /// Plain output with an Argo CD config management plugin in `argocd/`:
+    /// the `ConfigManagementPlugin` manifest and the entry point rendering
+    /// applications with the generated libraries
+    Argocd,
+}
@@ -0,0 +1,173 @@
+//! Packaging of output directories as Argo CD config management plugins
+//!
+//! With the `argocd` preset, each output directory keeps the generated
+//! libraries at its top and gets the two files an Argo CD sidecar plugin
+//! needs:
+//!
+//! ```text
+//! widgets/
+//!   index.libsonnet     generated libraries, as with the plain preset
+//!   argocd/
+//!     plugin.yaml       ConfigManagementPlugin of the repo-server sidecar
+//!     generate.sh       entry point rendering an application to manifests
+//! ```
+//!
+//! `generate.sh` evaluates the `main.jsonnet` of the application, or the file
+//! named by its `MAIN` plugin environment variable, with the parent of the
+//! output directory on the library path, so applications import the library
+//! by its directory name (`import 'widgets/index.libsonnet'`). The Kubernetes
+//! objects of the result, found in nested objects and arrays as Tanka does,
+//! are printed as a YAML stream.
+
+use anyhow::Result;
+use std::path::Path;
+
+use crate::output::write_if_changed;
+
+/// Directory of the plugin files, inside the output directory
+pub const PLUGIN_DIR: &str = "argocd";
+
+/// Manifest of the plugin
+pub const PLUGIN_FILE: &str = "plugin.yaml";
+
+/// Entry point of the plugin
+pub const GENERATE_FILE: &str = "generate.sh";
+
+/// Name of the plugin of an output directory, a DNS label
+///
+/// `gensonnet-` followed by the directory name, lowercased, with every
+/// other character than letters and digits replaced by `-`.
+pub fn plugin_name(dir: &Path) -> String {
+    let name: String = dir
+        .file_name()
+        .map(|name| name.to_string_lossy().to_lowercase())
+        .unwrap_or_default()
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '-' })
+        .collect();
+    let name = format!("gensonnet-{}", name.trim_matches('-'));
+    name[..name.len().min(63)].trim_end_matches('-').to_string()
+}
+
+/// Render the `ConfigManagementPlugin` of an output directory
+///
+/// The plugin handles applications with a `main.jsonnet`; the sidecar
+/// mounts the library at `/home/argocd/gensonnet/<directory name>`, as the
+/// entry point expects.
+pub fn plugin_manifest(dir: &Path) -> String {
+    let library = dir
+        .file_name()
+        .map(|name| name.to_string_lossy().to_string())
+        .unwrap_or_default();
+    format!(
+        r#"# Generated by gensonnet: Argo CD config management plugin of {library}
+apiVersion: argoproj.io/v1alpha1
+kind: ConfigManagementPlugin
+metadata:
+  name: {name}
+spec:
+  generate:
+    command: ["/home/argocd/gensonnet/{library}/{PLUGIN_DIR}/{GENERATE_FILE}"]
+  discover:
+    fileName: "./main.jsonnet"
+  parameters:
+    static:
+      - name: main
+        title: Jsonnet file rendered, relative to the application
+        string: main.jsonnet
+"#,
+        name = plugin_name(dir),
+    )
+}
+
+/// Entry point of the plugin, run in the directory of the application
+const GENERATE_SCRIPT: &str = r#"#!/bin/sh
+# Generated by gensonnet: renders an Argo CD application with the generated library
+#
+# Argo CD runs this in the directory of the application. The file evaluated is
+# main.jsonnet, or the MAIN plugin environment variable (ARGOCD_ENV_MAIN) or
+# the main parameter (PARAM_MAIN). The library is imported from the parent of
+# its directory, and the application's vendor/ is on the library path too.
+set -eu
+
+library_path="$(cd "$(dirname "$0")/../.." && pwd)"
+main="${ARGOCD_ENV_MAIN:-${PARAM_MAIN:-main.jsonnet}}"
+
+case "$main" in
+  *\'*)
+    echo "generate.sh: $main: file names with quotes are not supported" >&2
+    exit 1
+    ;;
+esac
+
+set -- -J "$library_path"
+if [ -d vendor ]; then
+  set -- "$@" -J vendor
+fi
+
+exec jsonnet "$@" --yaml-stream -e "
+local objects(value) =
+  if std.isArray(value) then std.flatMap(objects, value)
+  else if std.isObject(value) && std.objectHas(value, 'apiVersion') && std.objectHas(value, 'kind') then [value]
+  else if std.isObject(value) then std.flatMap(function(field) objects(value[field]), std.objectFields(value))
+  else [];
+objects(import '$main')
+"
+"#;
+
+/// Add the plugin files to a freshly generated output directory
+pub fn package_directory(dir: &Path) -> Result<()> {
+    let plugin_dir = dir.join(PLUGIN_DIR);
+    std::fs::create_dir_all(&plugin_dir)?;
+    write_if_changed(&plugin_dir.join(PLUGIN_FILE), plugin_manifest(dir))?;
+
+    let script = plugin_dir.join(GENERATE_FILE);
+    write_if_changed(&script, GENERATE_SCRIPT)?;
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        std::fs::set_permissions(&script, std::fs::Permissions::from_mode(0o755))?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_package_directory() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path().join("User_API");
+        std::fs::create_dir_all(&dir).unwrap();
+        std::fs::write(dir.join("index.libsonnet"), "{}\n").unwrap();
+
+        package_directory(&dir).unwrap();
+        assert!(dir.join("index.libsonnet").is_file());
+
+        let manifest: serde_yaml::Value =
+            serde_yaml::from_str(&std::fs::read_to_string(dir.join("argocd/plugin.yaml")).unwrap())
+                .unwrap();
+        assert_eq!(manifest["kind"], "ConfigManagementPlugin");
+        assert_eq!(manifest["metadata"]["name"], "gensonnet-user-api");
+        assert_eq!(
+            manifest["spec"]["generate"]["command"][0],
+            "/home/argocd/gensonnet/User_API/argocd/generate.sh"
+        );
+
+        let script = std::fs::read_to_string(dir.join("argocd/generate.sh")).unwrap();
+        assert!(script.starts_with("#!/bin/sh\n"));
+        assert!(script.contains("--yaml-stream"));
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            let mode = std::fs::metadata(dir.join("argocd/generate.sh"))
+                .unwrap()
+                .permissions()
+                .mode();
+            assert_eq!(mode & 0o111, 0o111);
+        }
+
+        assert_eq!(plugin_name(Path::new("-widgets-")), "gensonnet-widgets");
+    }
+}
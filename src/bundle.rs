@@ -0,0 +1,404 @@
+//! Self-contained bundles of generated libraries
+//!
+//! A bundle holds the generated libraries together with every file they
+//! import from the library path (the `vendor` directory `jb` installs to), so
+//! they can be evaluated where `jb` cannot run. It is written either as a tar
+//! archive keeping the directory layout, with the imported libraries under
+//! `vendor/`, or as a single Jsonnet file in which every import is replaced
+//! by a local binding.
+
+use anyhow::{anyhow, Result};
+use std::collections::{BTreeMap, HashMap};
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::compat::token_spans;
+use crate::rename::{canonical, imports, resolve_import, string_literal};
+use crate::utils::is_jsonnet;
+
+/// Directory of the bundle holding the files imported from the library path
+pub const VENDOR_DIR: &str = "vendor";
+
+/// Bundled files with the imports between them
+#[derive(Debug, Clone, Default)]
+pub struct Bundle {
+    /// Content of each file, keyed by its path in the bundle
+    files: BTreeMap<String, Vec<u8>>,
+
+    /// Jsonnet files of the bundled directories, exported by the single-file
+    /// bundle
+    libraries: Vec<String>,
+
+    /// Bundle path of each import, keyed by importing file, import kind and
+    /// imported path
+    imports: BTreeMap<String, BTreeMap<(String, String), String>>,
+}
+
+impl Bundle {
+    /// Collect the files of output directories and the files they import
+    ///
+    /// Each directory is placed in the bundle under its name. Imports are
+    /// resolved relative to the importing file, then against the `jpath`
+    /// directories, the way `jsonnet -J` does.
+    pub fn collect(dirs: &[PathBuf], jpath: &[PathBuf]) -> Result<Self> {
+        let mut bundle = Bundle::default();
+        let mut bundled: HashMap<PathBuf, String> = HashMap::new();
+        let mut pending = Vec::new();
+
+        for dir in dirs {
+            let name = dir
+                .canonicalize()
+                .ok()
+                .and_then(|dir| dir.file_name().map(|n| n.to_string_lossy().to_string()))
+                .ok_or_else(|| anyhow!("Not a directory: {}", dir.display()))?;
+            if bundle
+                .files
+                .keys()
+                .any(|file| file.starts_with(&format!("{name}/")))
+            {
+                return Err(anyhow!(
+                    "Two bundled directories are named {}; bundle them separately",
+                    name
+                ));
+            }
+
+            let mut entries: Vec<PathBuf> = WalkDir::new(dir)
+                .into_iter()
+                .collect::<std::result::Result<Vec<_>, _>>()?
+                .into_iter()
+                .filter(|entry| entry.file_type().is_file())
+                .map(|entry| entry.into_path())
+                .collect();
+            entries.sort();
+            for path in entries {
+                let relative = path.strip_prefix(dir).unwrap_or(&path);
+                let bundle_path = format!("{}/{}", name, slash_path(relative));
+                if is_jsonnet(&path) || has_extension(&path, "json") {
+                    bundle.libraries.push(bundle_path.clone());
+                }
+                bundle
+                    .files
+                    .insert(bundle_path.clone(), std::fs::read(&path)?);
+                bundled.insert(canonical(&path), bundle_path.clone());
+                pending.push((path, bundle_path));
+            }
+        }
+
+        let roots: Vec<PathBuf> = jpath.iter().map(|dir| canonical(dir)).collect();
+        while let Some((path, bundle_path)) = pending.pop() {
+            if !is_jsonnet(&path) {
+                continue;
+            }
+            let code = String::from_utf8_lossy(&bundle.files[&bundle_path]).to_string();
+            let dir = path.parent().unwrap_or(Path::new("."));
+
+            for (kind, import) in imports(&code) {
+                let resolved = resolve_import(&import, dir, jpath).ok_or_else(|| {
+                    anyhow!(
+                        "{} imports {}, which is not found next to it or in the library path",
+                        bundle_path,
+                        import
+                    )
+                })?;
+                let target = match bundled.get(&resolved) {
+                    Some(target) => target.clone(),
+                    None => {
+                        let relative = roots
+                            .iter()
+                            .find_map(|root| resolved.strip_prefix(root).ok())
+                            .ok_or_else(|| {
+                                anyhow!(
+                                    "{} imports {}, which is outside the bundled directories and the library path",
+                                    bundle_path,
+                                    import
+                                )
+                            })?;
+                        let target = format!("{}/{}", VENDOR_DIR, slash_path(relative));
+                        if bundle.files.contains_key(&target) {
+                            return Err(anyhow!(
+                                "{} is found in two library path directories",
+                                target
+                            ));
+                        }
+                        bundle
+                            .files
+                            .insert(target.clone(), std::fs::read(&resolved)?);
+                        bundled.insert(resolved.clone(), target.clone());
+                        pending.push((resolved, target.clone()));
+                        target
+                    }
+                };
+                bundle
+                    .imports
+                    .entry(bundle_path.clone())
+                    .or_default()
+                    .insert((kind, import), target);
+            }
+        }
+
+        Ok(bundle)
+    }
+
+    /// Get the paths of the bundled files
+    pub fn files(&self) -> impl Iterator<Item = &str> {
+        self.files.keys().map(String::as_str)
+    }
+
+    /// Build a tar archive of the bundled files
+    ///
+    /// Entries are sorted and carry no timestamps or owners, so the same
+    /// files always give the same archive. Consumers evaluate the libraries
+    /// with `-J vendor`.
+    pub fn to_tar(&self) -> Result<Vec<u8>> {
+        let mut archive = Vec::new();
+        for (path, content) in &self.files {
+            archive.extend(tar_header(path, content.len())?);
+            archive.extend(content);
+            archive.resize(archive.len().div_ceil(512) * 512, 0);
+        }
+        // Two empty blocks end the archive
+        archive.resize(archive.len() + 1024, 0);
+        Ok(archive)
+    }
+
+    /// Build a single Jsonnet file evaluating to the bundled libraries
+    ///
+    /// Every file is bound to a local, with its imports replaced by the
+    /// locals of the imported files; the result maps the path of each
+    /// library to its value.
+    pub fn to_libsonnet(&self) -> Result<String> {
+        let mut bindings: BTreeMap<(String, String), String> = BTreeMap::new();
+        for library in &self.libraries {
+            bindings.insert(("import".to_string(), library.clone()), String::new());
+        }
+        for imports in self.imports.values() {
+            for ((kind, _), target) in imports {
+                bindings.insert((kind.clone(), target.clone()), String::new());
+            }
+        }
+        for (idx, name) in bindings.values_mut().enumerate() {
+            *name = format!("_file{idx}");
+        }
+
+        let mut code = String::new();
+        code.push_str(&format!(
+            "// Generated by gensonnet: bundle of {} libraries\n",
+            self.libraries.len()
+        ));
+        let mut locals = Vec::new();
+        for ((kind, path), name) in &bindings {
+            let content = &self.files[path];
+            let value = match kind.as_str() {
+                "importstr" => serde_json::to_string(&String::from_utf8_lossy(content))?,
+                "importbin" => serde_json::to_string(content)?,
+                _ => {
+                    let imports = self.imports.get(path);
+                    let rewritten =
+                        rewrite_imports(&String::from_utf8_lossy(content), |kind, import| {
+                            let target = imports?.get(&(kind.to_string(), import.to_string()))?;
+                            bindings.get(&(kind.to_string(), target.clone())).cloned()
+                        });
+                    format!("(\n{}\n)", rewritten.trim_end())
+                }
+            };
+            locals.push(format!("  // {path}\n  {name} = {value}"));
+        }
+        code.push_str(&format!("local\n{};\n\n{{\n", locals.join(",\n")));
+        for library in &self.libraries {
+            let name = &bindings[&("import".to_string(), library.clone())];
+            code.push_str(&format!(
+                "  {}: {},\n",
+                serde_json::to_string(library)?,
+                name
+            ));
+        }
+        code.push_str("}\n");
+
+        Ok(code)
+    }
+}
+
+/// Build the ustar header of a regular file
+fn tar_header(path: &str, size: usize) -> Result<[u8; 512]> {
+    let mut header = [0u8; 512];
+
+    // Long paths are split into a prefix and a name at a directory separator
+    let (prefix, name) = if path.len() <= 100 {
+        ("", path)
+    } else {
+        path.char_indices()
+            .filter(|(idx, c)| *c == '/' && *idx <= 155 && path.len() - idx - 1 <= 100)
+            .map(|(idx, _)| (&path[..idx], &path[idx + 1..]))
+            .next()
+            .ok_or_else(|| anyhow!("Path too long for a tar archive: {}", path))?
+    };
+
+    header[..name.len()].copy_from_slice(name.as_bytes());
+    header[100..108].copy_from_slice(b"0000644\0");
+    header[108..116].copy_from_slice(b"0000000\0");
+    header[116..124].copy_from_slice(b"0000000\0");
+    header[124..136].copy_from_slice(format!("{size:011o}\0").as_bytes());
+    header[136..148].copy_from_slice(b"00000000000\0");
+    header[148..156].copy_from_slice(b"        ");
+    header[156] = b'0';
+    header[257..263].copy_from_slice(b"ustar\0");
+    header[263..265].copy_from_slice(b"00");
+    header[345..345 + prefix.len()].copy_from_slice(prefix.as_bytes());
+
+    let checksum: u32 = header.iter().map(|b| *b as u32).sum();
+    header[148..156].copy_from_slice(format!("{checksum:06o}\0 ").as_bytes());
+
+    Ok(header)
+}
+
+/// Replace the imports of Jsonnet code
+///
+/// `replace` gets the kind (`import`, `importstr` or `importbin`) and the
+/// path of each import, and returns the expression replacing it, or `None`
+/// to keep it. Strings and comments are left alone.
+fn rewrite_imports(code: &str, mut replace: impl FnMut(&str, &str) -> Option<String>) -> String {
+    let spans = token_spans(code);
+    let mut result = String::with_capacity(code.len());
+    let mut copied = 0;
+
+    for pair in spans.windows(2) {
+        let (kind, literal) = (&code[pair[0].clone()], &code[pair[1].clone()]);
+        if !matches!(kind, "import" | "importstr" | "importbin") {
+            continue;
+        }
+        let Some(replacement) = string_literal(literal).and_then(|path| replace(kind, path)) else {
+            continue;
+        };
+        result.push_str(&code[copied..pair[0].start]);
+        result.push_str(&replacement);
+        copied = pair[1].end;
+    }
+    result.push_str(&code[copied..]);
+
+    result
+}
+
+fn has_extension(path: &Path, extension: &str) -> bool {
+    path.extension().is_some_and(|ext| ext == extension)
+}
+
+/// Join the components of a relative path with `/`
+fn slash_path(path: &Path) -> String {
+    path.components()
+        .map(|component| component.as_os_str().to_string_lossy())
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn fixture() -> (tempfile::TempDir, PathBuf, PathBuf) {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let lib = temp_dir.path().join("my-types");
+        let vendor = temp_dir.path().join("vendor");
+        std::fs::create_dir_all(&lib).unwrap();
+        std::fs::create_dir_all(vendor.join("github.com/jsonnet-libs/k/1.30")).unwrap();
+        std::fs::write(
+            lib.join("user.libsonnet"),
+            "local k = import 'github.com/jsonnet-libs/k/1.30/main.libsonnet';\n{ new():: k.meta('user'), doc:: importstr 'README.md' }\n",
+        )
+        .unwrap();
+        std::fs::write(lib.join("README.md"), "# User\n").unwrap();
+        std::fs::write(
+            lib.join("index.libsonnet"),
+            "// import 'nothing.libsonnet'\n{ user: import \"user.libsonnet\" }\n",
+        )
+        .unwrap();
+        std::fs::write(
+            vendor.join("github.com/jsonnet-libs/k/1.30/main.libsonnet"),
+            "(import 'meta.libsonnet')\n",
+        )
+        .unwrap();
+        std::fs::write(
+            vendor.join("github.com/jsonnet-libs/k/1.30/meta.libsonnet"),
+            "{ meta(name):: { metadata: { name: name } } }\n",
+        )
+        .unwrap();
+        (temp_dir, lib, vendor)
+    }
+
+    #[test]
+    fn test_collect() {
+        let (_temp_dir, lib, vendor) = fixture();
+        let bundle = Bundle::collect(std::slice::from_ref(&lib), &[vendor]).unwrap();
+
+        assert_eq!(
+            bundle.files().collect::<Vec<_>>(),
+            vec![
+                "my-types/README.md",
+                "my-types/index.libsonnet",
+                "my-types/user.libsonnet",
+                "vendor/github.com/jsonnet-libs/k/1.30/main.libsonnet",
+                "vendor/github.com/jsonnet-libs/k/1.30/meta.libsonnet",
+            ]
+        );
+        assert_eq!(
+            bundle.libraries,
+            vec!["my-types/index.libsonnet", "my-types/user.libsonnet"]
+        );
+
+        // Imports missing from the library path fail the bundle
+        let error = Bundle::collect(&[lib], &[]).unwrap_err();
+        assert!(error.to_string().contains(
+            "my-types/user.libsonnet imports github.com/jsonnet-libs/k/1.30/main.libsonnet"
+        ));
+    }
+
+    #[test]
+    fn test_to_tar() {
+        let (_temp_dir, lib, vendor) = fixture();
+        let bundle = Bundle::collect(&[lib], &[vendor]).unwrap();
+        let archive = bundle.to_tar().unwrap();
+
+        assert_eq!(archive.len() % 512, 0);
+        assert_eq!(&archive[..18], b"my-types/README.md");
+        assert_eq!(&archive[257..263], b"ustar\0");
+        assert_eq!(&archive[124..136], b"00000000007\0");
+        assert_eq!(&archive[512..519], b"# User\n");
+        assert_eq!(archive, bundle.to_tar().unwrap());
+
+        let long = format!("{}/{}", "a".repeat(120), "b.libsonnet");
+        let header = tar_header(&long, 0).unwrap();
+        assert_eq!(&header[..11], b"b.libsonnet");
+        assert_eq!(&header[345..465], "a".repeat(120).as_bytes());
+    }
+
+    #[test]
+    fn test_to_libsonnet() {
+        let (_temp_dir, lib, vendor) = fixture();
+        let bundle = Bundle::collect(&[lib], &[vendor]).unwrap();
+        let code = bundle.to_libsonnet().unwrap();
+
+        assert!(!code.contains("import 'github.com"));
+        assert!(code.contains("local k = _file"));
+        assert!(code.contains("doc:: _file"));
+        assert!(code.contains("= \"# User\\n\""));
+        // Commented imports are kept
+        assert!(code.contains("// import 'nothing.libsonnet'"));
+        assert!(code.contains("\"my-types/user.libsonnet\": _file"));
+        assert!(!code.contains("\"vendor/"));
+    }
+
+    #[test]
+    fn test_rewrite_imports() {
+        let code = "local a = import 'a.libsonnet';\n// import 'b.libsonnet'\n{ s: importstr \"c.txt\", t: 'import x' }\n";
+        assert_eq!(
+            imports(code),
+            vec![
+                ("import".to_string(), "a.libsonnet".to_string()),
+                ("importstr".to_string(), "c.txt".to_string()),
+            ]
+        );
+        assert_eq!(
+            rewrite_imports(code, |kind, path| (kind == "import").then(|| format!("<{path}>"))),
+            "local a = <a.libsonnet>;\n// import 'b.libsonnet'\n{ s: importstr \"c.txt\", t: 'import x' }\n"
+        );
+    }
+}
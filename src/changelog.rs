@@ -0,0 +1,158 @@
+//! Changelog entries of regenerated libraries
+//!
+//! With `changelog` in the `generation` section, regenerating an output
+//! directory compares the new snapshot of its types (`types.json`) with the
+//! previous one, or with the one committed at `changelog_since`, and adds an
+//! entry listing the changes to the `CHANGELOG.md` of the directory, newest
+//! first:
+//!
+//! ```markdown
+//! ## 2026-10-14
+//!
+//! ### Breaking changes
+//!
+//! - `User.email`: field removed
+//!
+//! ### Added
+//!
+//! - `User.contacts`: optional field added
+//! - `Role`: value "auditor" added
+//!
+//! ### Changed
+//!
+//! - `User.role`: default changed from "viewer" to "member"
+//! ```
+//!
+//! Runs without changes add no entry, and the entries of earlier runs,
+//! including edits to them, are kept.
+
+use anyhow::Result;
+use std::path::Path;
+
+use crate::schemadiff::{Change, ChangeKind, Report};
+
+/// File name of the changelog, inside the output directory
+pub const CHANGELOG_FILE: &str = "CHANGELOG.md";
+
+/// First line of a new changelog
+const TITLE: &str = "# Changelog";
+
+/// Render the entry of the changes of a report under a heading
+pub fn render_entry(report: &Report, heading: &str) -> String {
+    let section = |change: &Change| match (change.breaking, change.kind) {
+        (true, _) => "Breaking changes",
+        (false, ChangeKind::Added) => "Added",
+        (false, _) => "Changed",
+    };
+
+    let mut entry = format!("## {heading}\n");
+    for title in ["Breaking changes", "Added", "Changed"] {
+        let changes: Vec<&Change> = report
+            .changes
+            .iter()
+            .filter(|change| section(change) == title)
+            .collect();
+        if changes.is_empty() {
+            continue;
+        }
+        entry.push_str(&format!("\n### {title}\n\n"));
+        for change in changes {
+            entry.push_str(&format!("- `{}`: {}\n", change.subject, change.detail));
+        }
+    }
+    entry
+}
+
+/// Put an entry at the top of a changelog, below its title
+pub fn prepend_entry(changelog: &str, entry: &str) -> String {
+    let rest = match changelog.strip_prefix(TITLE) {
+        Some(rest) if rest.is_empty() || rest.starts_with('\n') => rest.trim_start_matches('\n'),
+        _ => changelog,
+    };
+    match rest.is_empty() {
+        true => format!("{TITLE}\n\n{entry}"),
+        false => format!("{TITLE}\n\n{entry}\n{rest}"),
+    }
+}
+
+/// Add the entry of a report to the changelog of an output directory
+///
+/// Returns whether an entry was added; reports without changes add none.
+pub fn write_entry(dir: &Path, report: &Report, heading: &str) -> Result<bool> {
+    if report.changes.is_empty() {
+        return Ok(false);
+    }
+    let file = dir.join(CHANGELOG_FILE);
+    let changelog = match file.is_file() {
+        true => std::fs::read_to_string(&file)?,
+        false => String::new(),
+    };
+    std::fs::write(
+        &file,
+        prepend_entry(&changelog, &render_entry(report, heading)),
+    )?;
+    Ok(true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::schemadiff::{FieldShape, Snapshot, TypeShape};
+
+    fn report() -> Report {
+        let field = |field_type: &str, default: Option<serde_json::Value>| FieldShape {
+            field_type: field_type.to_string(),
+            default,
+            ..Default::default()
+        };
+        let mut old = Snapshot::default();
+        old.types.insert(
+            "User".to_string(),
+            TypeShape {
+                file: "user.libsonnet".to_string(),
+                fields: [
+                    ("email".to_string(), field("string", None)),
+                    ("role".to_string(), field("string", Some("viewer".into()))),
+                ]
+                .into(),
+                ..Default::default()
+            },
+        );
+        let mut new = old.clone();
+        let user = new.types.get_mut("User").unwrap();
+        user.fields.remove("email");
+        user.fields
+            .insert("contacts".to_string(), field("array<string>", None));
+        user.fields.get_mut("role").unwrap().default = Some("member".into());
+        old.diff(&new)
+    }
+
+    #[test]
+    fn test_render_entry() {
+        assert_eq!(
+            render_entry(&report(), "2026-10-14"),
+            "## 2026-10-14\n\n### Breaking changes\n\n- `User.email`: field removed\n\n### Added\n\n- `User.contacts`: optional field added\n\n### Changed\n\n- `User.role`: default changed from \"viewer\" to \"member\"\n"
+        );
+        assert_eq!(render_entry(&Report::default(), "v1"), "## v1\n");
+    }
+
+    #[test]
+    fn test_write_entry() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        assert!(!write_entry(dir, &Report::default(), "2026-10-13").unwrap());
+        assert!(!dir.join(CHANGELOG_FILE).exists());
+
+        assert!(write_entry(dir, &report(), "2026-10-13").unwrap());
+        assert!(write_entry(dir, &report(), "2026-10-14").unwrap());
+        let changelog = std::fs::read_to_string(dir.join(CHANGELOG_FILE)).unwrap();
+        assert!(changelog.starts_with("# Changelog\n\n## 2026-10-14\n"));
+        assert!(changelog.contains("\n\n## 2026-10-13\n"));
+
+        // Hand-written changelogs keep their text below the new entry
+        assert_eq!(
+            prepend_entry("Notes\n", "## v2\n"),
+            "# Changelog\n\n## v2\n\nNotes\n"
+        );
+    }
+}
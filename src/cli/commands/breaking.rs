@@ -0,0 +1,100 @@
+//! Breaking command implementation
+
+use crate::cli::commands::diff::snapshot_at;
+use crate::schemadiff::{self, AllowedBreak, Snapshot};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::{Path, PathBuf};
+
+pub fn command() -> Command {
+    Command::new("breaking")
+        .about("Fail when the generated types break their consumers, except for allowed breaks")
+        .arg(
+            clap::Arg::new("against")
+                .long("against")
+                .help("Git ref of the sources, output directory or types.json to compare with")
+                .value_name("REF")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("new")
+                .long("new")
+                .help("Output directory or types.json to check (default: the configured sources)")
+                .value_name("NEW"),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Only check the types of the named source")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("allowlist")
+                .long("allowlist")
+                .help("File of intentional breaks (default: gensonnet-breaking.yaml if present)")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let against = matches.get_one::<String>("against").unwrap();
+    let old = match Path::new(against).exists() {
+        true => Snapshot::load(Path::new(against))?,
+        false => snapshot_at(matches, Some(against)).await?,
+    };
+    let new = match matches.get_one::<String>("new") {
+        Some(path) => Snapshot::load(&PathBuf::from(path))?,
+        None => snapshot_at(matches, None).await?,
+    };
+
+    let allowlist = match matches.get_one::<String>("allowlist") {
+        Some(path) => schemadiff::load_allowlist(Path::new(path))?,
+        None if Path::new(schemadiff::ALLOWLIST_FILE).is_file() => {
+            schemadiff::load_allowlist(Path::new(schemadiff::ALLOWLIST_FILE))?
+        }
+        None => Vec::new(),
+    };
+
+    let report = old.diff(&new);
+    let mut used = vec![false; allowlist.len()];
+    let mut failures = 0;
+    for change in report.changes.iter().filter(|change| change.breaking) {
+        match allowlist.iter().position(|entry| entry.allows(change)) {
+            Some(idx) => {
+                used[idx] = true;
+                println!("allowed {change}");
+            }
+            None => {
+                println!("{change}");
+                failures += 1;
+            }
+        }
+    }
+    for (entry, _) in allowlist.iter().zip(&used).filter(|(_, used)| !**used) {
+        println!("note: allowed break {} matches no change", describe(entry));
+    }
+
+    if failures > 0 {
+        return Err(anyhow!(
+            "{} breaking changes against {}; add intentional ones to the allowlist",
+            failures,
+            against
+        ));
+    }
+    println!("no breaking changes against {against}");
+    Ok(())
+}
+
+fn describe(entry: &AllowedBreak) -> String {
+    match entry.kind {
+        Some(kind) => format!("{} ({})", entry.subject, kind.as_str()),
+        None => entry.subject.clone(),
+    }
+}
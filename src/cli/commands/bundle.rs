@@ -0,0 +1,99 @@
+//! Bundle command implementation
+
+use crate::bundle::{Bundle, VENDOR_DIR};
+use crate::cli::utils;
+use crate::utils::is_jsonnet;
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+
+pub fn command() -> Command {
+    Command::new("bundle")
+        .about("Package generated libraries and their imports for offline evaluation")
+        .arg(
+            clap::Arg::new("dir")
+                .help("Output directories to bundle (default: those of the configured sources)")
+                .value_name("DIR")
+                .num_args(0..),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Bundle file to write")
+                .value_name("FILE")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("format")
+                .long("format")
+                .help("Bundle format (default: libsonnet for .libsonnet and .jsonnet files, tar otherwise)")
+                .value_name("FORMAT")
+                .value_parser(["tar", "libsonnet"]),
+        )
+        .arg(
+            clap::Arg::new("jpath")
+                .short('J')
+                .long("jpath")
+                .help("Library search directory used to resolve imports (default: ./vendor)")
+                .value_name("DIR")
+                .action(clap::ArgAction::Append),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let dirs: Vec<PathBuf> = match matches.get_many::<String>("dir") {
+        Some(dirs) => dirs.map(PathBuf::from).collect(),
+        None => {
+            let config = utils::load_config(matches)?;
+            config
+                .sources
+                .iter()
+                .map(|source| source.output_path().to_path_buf())
+                .collect()
+        }
+    };
+    for dir in &dirs {
+        if !dir.is_dir() {
+            return Err(anyhow!(
+                "Output directory {} does not exist; run `gensonnet generate` first",
+                dir.display()
+            ));
+        }
+    }
+
+    let jpath: Vec<PathBuf> = match matches.get_many::<String>("jpath") {
+        Some(dirs) => dirs.map(PathBuf::from).collect(),
+        None => Some(PathBuf::from(VENDOR_DIR))
+            .filter(|vendor| vendor.is_dir())
+            .into_iter()
+            .collect(),
+    };
+
+    let output = PathBuf::from(matches.get_one::<String>("output").unwrap());
+    let format = match matches.get_one::<String>("format") {
+        Some(format) => format.as_str(),
+        None if is_jsonnet(&output) => "libsonnet",
+        None => "tar",
+    };
+
+    let bundle = Bundle::collect(&dirs, &jpath)?;
+    match format {
+        "libsonnet" => std::fs::write(&output, bundle.to_libsonnet()?)?,
+        _ => std::fs::write(&output, bundle.to_tar()?)?,
+    }
+
+    println!(
+        "Bundled {} files into {}",
+        bundle.files().count(),
+        output.display()
+    );
+    Ok(())
+}
@@ -0,0 +1,65 @@
+//! Check command implementation
+
+use crate::cli::utils;
+use crate::integrity::Manifest;
+use crate::source_output_paths;
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("check")
+        .about("Compare the output directories with their manifests to detect hand edits")
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    info!("Checking generated output against manifests");
+
+    let config = utils::load_config(matches)?;
+
+    let mut failures = 0;
+    for source in &config.sources {
+        for dir in source_output_paths(source) {
+            let Some(manifest) = Manifest::read(dir)? else {
+                println!("missing manifest {}", dir.display());
+                failures += 1;
+                continue;
+            };
+
+            if manifest.generator != env!("CARGO_PKG_VERSION") {
+                println!(
+                    "note {}: generated by gensonnet {}, this is {}",
+                    dir.display(),
+                    manifest.generator,
+                    env!("CARGO_PKG_VERSION")
+                );
+            }
+
+            let tampering = manifest.check(dir)?;
+            if tampering.is_empty() {
+                println!("ok {} ({} files)", dir.display(), manifest.outputs.len());
+                continue;
+            }
+            println!("tampered {}", dir.display());
+            for change in &tampering {
+                println!("  {change}");
+            }
+            failures += 1;
+        }
+    }
+
+    if failures > 0 {
+        return Err(anyhow!(
+            "{} output directories do not match their manifest",
+            failures
+        ));
+    }
+    Ok(())
+}
@@ -0,0 +1,145 @@
+//! Compat-test command implementation
+
+use crate::compat::{self, ChangeKind, CompatReport, CompatRunner};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("compat-test")
+        .about("Check the current release against a corpus of recorded outputs")
+        .arg(
+            clap::Arg::new("corpus")
+                .help("Corpus directory containing one directory per case")
+                .value_name("DIR")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("case")
+                .long("case")
+                .help("Only run the named cases (comma-separated)")
+                .value_name("NAMES"),
+        )
+        .arg(
+            clap::Arg::new("record")
+                .long("record")
+                .help("Record the current output as the expected output")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("strict")
+                .long("strict")
+                .help("Treat compatible additions as failures")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("format")
+                .long("format")
+                .help("Report format (text, json, yaml)")
+                .value_name("FORMAT")
+                .default_value("text"),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Write the report to a file")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let corpus_dir = PathBuf::from(matches.get_one::<String>("corpus").unwrap());
+    info!("Running compatibility corpus {:?}", corpus_dir);
+
+    let mut cases = compat::load_corpus(&corpus_dir)?;
+    if let Some(names) = matches.get_one::<String>("case") {
+        let names: Vec<&str> = names.split(',').map(str::trim).collect();
+        cases.retain(|c| names.contains(&c.name.as_str()));
+    }
+
+    if cases.is_empty() {
+        return Err(anyhow!(
+            "No compatibility cases found in {}",
+            corpus_dir.display()
+        ));
+    }
+
+    let runner = CompatRunner::new().await?;
+
+    if matches.get_flag("record") {
+        for case in &mut cases {
+            let files = runner.record_case(case).await?;
+            println!("Recorded {}: {} files", case.name, files);
+        }
+        return Ok(());
+    }
+
+    let strict = matches.get_flag("strict");
+    let report = runner.run_corpus(&cases).await;
+
+    let output = match matches.get_one::<String>("format").map(String::as_str) {
+        Some("json") => serde_json::to_string_pretty(&report)?,
+        Some("yaml") => serde_yaml::to_string(&report)?,
+        Some("text") | None => format_report_text(&report, strict),
+        Some(other) => return Err(anyhow!("Unsupported format: {}", other)),
+    };
+
+    if let Some(output_path) = matches.get_one::<String>("output") {
+        tokio::fs::write(output_path, output).await?;
+    } else {
+        println!("{output}");
+    }
+
+    if report.failed(strict) > 0 {
+        std::process::exit(1);
+    }
+
+    Ok(())
+}
+
+/// Format a compatibility report as text
+fn format_report_text(report: &CompatReport, strict: bool) -> String {
+    let mut output = String::new();
+
+    output.push_str(&format!("gensonnet {}\n\n", report.version));
+
+    for case in &report.cases {
+        let status = if case.passed(strict) { "PASS" } else { "FAIL" };
+        let recorded = case
+            .recorded_with
+            .as_deref()
+            .map(|v| format!(" (recorded with {v})"))
+            .unwrap_or_default();
+        output.push_str(&format!("{} {}{}\n", status, case.name, recorded));
+
+        if let Some(error) = &case.error {
+            output.push_str(&format!("  Error: {error}\n"));
+        }
+
+        for change in &case.changes {
+            let label = match change.kind {
+                ChangeKind::RemovedFile => "removed file",
+                ChangeKind::AddedFile => "new file",
+                ChangeKind::Breaking => "breaking",
+                ChangeKind::Addition => "addition",
+                ChangeKind::Changed => "changed",
+            };
+            output.push_str(&format!("  {}: {}\n", label, change.file.display()));
+            for detail in &change.details {
+                output.push_str(&format!("    {detail}\n"));
+            }
+        }
+    }
+
+    let failed = report.failed(strict);
+    output.push_str(&format!(
+        "\nCases: {}, Passed: {}, Failed: {}\n",
+        report.cases.len(),
+        report.cases.len() - failed,
+        failed
+    ));
+
+    output
+}
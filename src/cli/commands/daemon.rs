@@ -0,0 +1,43 @@
+//! Daemon command implementation
+
+use crate::cli::utils;
+use crate::daemon::Daemon;
+use crate::quota::Quotas;
+use anyhow::Result;
+use clap::{ArgMatches, Command};
+use std::path::Path;
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("daemon")
+        .about("Serve schema queries and regeneration requests as JSON-RPC on stdin and stdout")
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("quotas")
+                .long("quotas")
+                .help("Quotas of the project, which its configuration can lower but not raise")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let config_path = utils::get_config_path(matches)?;
+    let config = crate::Config::from_file(&config_path)?;
+    let mut app = utils::create_app(config)?;
+    if let Some(quotas) = matches.get_one::<String>("quotas") {
+        app = app.with_quotas(Quotas::load(Path::new(quotas))?);
+    }
+    app.initialize().await?;
+
+    info!("Serving JSON-RPC on stdio");
+    Daemon::new(app)
+        .with_config_file(&config_path)?
+        .serve_stdio()
+        .await
+}
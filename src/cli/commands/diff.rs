@@ -0,0 +1,133 @@
+//! Diff command implementation
+
+use crate::cli::utils;
+use crate::config::Source;
+use crate::schemadiff::{Report, Snapshot};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("diff")
+        .about("Report the field, default and validation changes between two versions of the types")
+        .arg(
+            clap::Arg::new("old")
+                .long("old")
+                .help("Old output directory or types.json")
+                .value_name("OLD")
+                .conflicts_with("old-ref"),
+        )
+        .arg(
+            clap::Arg::new("new")
+                .long("new")
+                .help("New output directory or types.json")
+                .value_name("NEW")
+                .conflicts_with("new-ref"),
+        )
+        .arg(
+            clap::Arg::new("old-ref")
+                .long("old-ref")
+                .help("Git ref of the sources to compare from")
+                .value_name("REF"),
+        )
+        .arg(
+            clap::Arg::new("new-ref")
+                .long("new-ref")
+                .help("Git ref of the sources to compare to (default: the configured ref)")
+                .value_name("REF"),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Only compare the types of the named source")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("format")
+                .long("format")
+                .help("Report format")
+                .value_parser(["text", "json"])
+                .default_value("text"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let report = report(matches).await?;
+    match matches.get_one::<String>("format").map(String::as_str) {
+        Some("json") => print!("{}", report.to_json()?),
+        _ => {
+            for change in &report.changes {
+                println!("{change}");
+            }
+            let breaking = report.changes.iter().filter(|c| c.breaking).count();
+            println!("{} changes, {} breaking", report.changes.len(), breaking);
+        }
+    }
+    Ok(())
+}
+
+/// Compare the snapshots given by the arguments
+///
+/// Each side is an output directory or snapshot file, or a git ref of the
+/// configured sources whose types are parsed at that ref.
+pub async fn report(matches: &ArgMatches) -> Result<Report> {
+    let old = snapshot(matches, "old", "old-ref").await?;
+    let new = snapshot(matches, "new", "new-ref").await?;
+    Ok(old.diff(&new))
+}
+
+/// Load one side of the comparison
+async fn snapshot(matches: &ArgMatches, path_arg: &str, ref_arg: &str) -> Result<Snapshot> {
+    if let Some(path) = matches.get_one::<String>(path_arg) {
+        return Snapshot::load(&PathBuf::from(path));
+    }
+    let git_ref = matches.get_one::<String>(ref_arg);
+    if git_ref.is_none() && ref_arg == "old-ref" {
+        return Err(anyhow!("Give --old or --old-ref"));
+    }
+    snapshot_at(matches, git_ref.map(String::as_str)).await
+}
+
+/// Take the snapshot of the configured sources at a git ref
+///
+/// The sources are parsed at their configured ref when none is given.
+pub async fn snapshot_at(matches: &ArgMatches, git_ref: Option<&str>) -> Result<Snapshot> {
+    let config = utils::load_config(matches)?;
+    let source_name = matches.get_one::<String>("source");
+    let mut sources: Vec<Source> = config
+        .sources
+        .iter()
+        .filter(|source| source_name.is_none_or(|name| source.name() == name))
+        .filter(|source| !matches!(source, Source::Crd(_)))
+        .cloned()
+        .collect();
+    if sources.is_empty() {
+        return Err(anyhow!(
+            "No Go AST, OpenAPI, Terraform or GraphQL source to compare"
+        ));
+    }
+
+    let app = utils::create_app(config)?;
+    app.initialize_plugins().await?;
+    let mut schemas = Vec::new();
+    for source in &mut sources {
+        if let Some(git_ref) = git_ref {
+            source.set_git_ref(git_ref.to_string());
+        }
+        info!(
+            "Parsing {} at {}",
+            source.name(),
+            source.git_ref().unwrap_or("the default branch")
+        );
+        schemas.extend(app.extract_source_schemas(source).await?);
+    }
+    Ok(Snapshot::from_schemas(&schemas))
+}
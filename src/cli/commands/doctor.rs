@@ -0,0 +1,79 @@
+//! Doctor command implementation
+
+use crate::cli::utils;
+use crate::doctor::{self, CheckStatus};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("doctor")
+        .about("Check the environment and suggest fixes for problems found")
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    info!("Checking environment");
+
+    // A missing configuration is reported, but does not stop the other checks
+    let config = match utils::get_config_path(matches) {
+        Ok(path) => match crate::Config::from_file(&path) {
+            Ok(config) => {
+                println!("Configuration: {}", path.display());
+                Some(config)
+            }
+            Err(e) => {
+                println!("Configuration: {} is invalid: {}", path.display(), e);
+                println!("  Fix: Run `gensonnet validate` for details");
+                return Err(anyhow!("Configuration is invalid"));
+            }
+        },
+        Err(_) => {
+            println!("Configuration: not found, skipping source checks");
+            println!("  Fix: Create one with `gensonnet init`");
+            None
+        }
+    };
+
+    let checks = doctor::run_checks(config.as_ref()).await;
+
+    println!("\nEnvironment Checks:");
+    for check in &checks {
+        let label = match check.status {
+            CheckStatus::Ok => "ok",
+            CheckStatus::Warn => "warn",
+            CheckStatus::Fail => "FAIL",
+        };
+        println!("  [{}] {}: {}", label, check.name, check.detail);
+        if let Some(fix) = &check.fix {
+            println!("         Fix: {fix}");
+        }
+    }
+
+    let failed = checks
+        .iter()
+        .filter(|c| c.status == CheckStatus::Fail)
+        .count();
+    let warnings = checks
+        .iter()
+        .filter(|c| c.status == CheckStatus::Warn)
+        .count();
+    println!(
+        "\nChecks: {}, Warnings: {}, Failed: {}",
+        checks.len(),
+        warnings,
+        failed
+    );
+
+    if failed > 0 {
+        return Err(anyhow!("{} environment checks failed", failed));
+    }
+
+    Ok(())
+}
@@ -0,0 +1,137 @@
+//! Fixtures command implementation
+
+use crate::cli::utils;
+use crate::config::Source;
+use crate::fixtures::Fixtures;
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use serde_json::Value;
+use std::path::PathBuf;
+use std::time::{SystemTime, UNIX_EPOCH};
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("fixtures")
+        .about("Generate random JSON fixtures that satisfy the schemas of types")
+        .arg(
+            clap::Arg::new("types")
+                .help("Types to generate fixtures of")
+                .value_name("TYPE")
+                .required(true)
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Only look for the types in the named source")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("count")
+                .short('n')
+                .long("count")
+                .help("Number of fixtures of each type, written as an array when more than one")
+                .value_name("N")
+                .value_parser(clap::value_parser!(usize))
+                .default_value("1"),
+        )
+        .arg(
+            clap::Arg::new("seed")
+                .long("seed")
+                .help("Seed of the random values, to reproduce fixtures")
+                .value_name("SEED")
+                .value_parser(clap::value_parser!(u64)),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Write the fixtures of each type to <type>.json in a directory")
+                .value_name("DIR"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let config = utils::load_config(matches)?;
+    let source_name = matches.get_one::<String>("source");
+    let count = *matches.get_one::<usize>("count").unwrap();
+    let seed = matches.get_one::<u64>("seed").copied().unwrap_or_else(|| {
+        SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map(|elapsed| elapsed.as_nanos() as u64)
+            .unwrap_or_default()
+    });
+    let types: Vec<&String> = matches.get_many::<String>("types").unwrap().collect();
+
+    let sources: Vec<Source> = config
+        .sources
+        .iter()
+        .filter(|source| source_name.is_none_or(|name| source.name() == name))
+        .cloned()
+        .collect();
+    if let Some(name) = source_name {
+        match sources.first() {
+            None => return Err(anyhow!("Unknown source: {}", name)),
+            Some(Source::Crd(_)) => {
+                return Err(anyhow!(
+                    "Fixtures are not supported for CRD source {}",
+                    name
+                ))
+            }
+            Some(_) => {}
+        }
+    }
+
+    let app = utils::create_app(config)?;
+    app.initialize_plugins().await?;
+    let mut schemas = Vec::new();
+    // CRD schemas are not in the form of the generated types
+    for source in &sources {
+        if matches!(source, Source::Crd(_)) {
+            continue;
+        }
+        schemas.extend(app.extract_source_schemas(source).await?);
+    }
+
+    info!("Generating fixtures with seed {}", seed);
+    let mut fixtures = Fixtures::new(&schemas, seed);
+    let missing: Vec<&str> = types
+        .iter()
+        .filter(|name| !fixtures.contains(name))
+        .map(|name| name.as_str())
+        .collect();
+    if !missing.is_empty() {
+        return Err(anyhow!("Unknown types: {}", missing.join(", ")));
+    }
+
+    let output = matches.get_one::<String>("output").map(PathBuf::from);
+    if let Some(dir) = &output {
+        std::fs::create_dir_all(dir)?;
+    }
+    for name in types {
+        let values = (0..count)
+            .map(|_| fixtures.generate(name))
+            .collect::<Result<Vec<_>>>()?;
+        let value = match values.len() {
+            1 => values.into_iter().next().unwrap(),
+            _ => Value::Array(values),
+        };
+        let json = format!("{}\n", serde_json::to_string_pretty(&value)?);
+        match &output {
+            Some(dir) => {
+                let file = dir.join(format!("{}.json", name.to_lowercase()));
+                std::fs::write(&file, json)?;
+                println!("wrote {}", file.display());
+            }
+            None => print!("{json}"),
+        }
+    }
+    Ok(())
+}
@@ -1,8 +1,14 @@
 //! Generate command implementation
 
 use crate::cli::utils;
-use anyhow::Result;
+use crate::config::{GoAstSource, Source};
+use crate::plugin::ast::memory::STDIN_FILE;
+use crate::plugin::ast::{generate_source, AnyPolicy, GoAstOptions};
+use crate::{Config, DryRunSourceResult, LockfileManager};
+use anyhow::{anyhow, Result};
 use clap::{ArgMatches, Command};
+use std::collections::BTreeMap;
+use std::io::Read;
 use std::path::PathBuf;
 use tracing::info;
 
@@ -16,6 +22,13 @@ pub fn command() -> Command {
                 .help("Configuration file path")
                 .value_name("FILE"),
         )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Generate from a remote repository instead of the configured sources (git+https://host/repo//dir?ref=REF)")
+                .value_name("ADDRESS")
+                .action(clap::ArgAction::Append),
+        )
         .arg(
             clap::Arg::new("output")
                 .short('o')
@@ -33,7 +46,7 @@ pub fn command() -> Command {
         .arg(
             clap::Arg::new("dry-run")
                 .long("dry-run")
-                .help("Don't write files")
+                .help("Print the packages, types and output files a run would process, without writing files")
                 .action(clap::ArgAction::SetTrue),
         )
         .arg(
@@ -42,24 +55,327 @@ pub fn command() -> Command {
                 .help("Stop on first error")
                 .action(clap::ArgAction::SetTrue),
         )
+        .arg(
+            clap::Arg::new("format")
+                .long("format")
+                .help("Formatter for emitted Jsonnet files (none, builtin, jsonnetfmt)")
+                .value_name("FORMATTER"),
+        )
+        .arg(
+            clap::Arg::new("output-format")
+                .long("output-format")
+                .help("Also write the types of Go AST sources in this format (jsonschema, cue, typescript)")
+                .value_name("FORMAT")
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("dependency-graph")
+                .long("dependency-graph")
+                .help("Only parse the changed Go files and regenerate the types they affect, using the graph of the last run")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("jobs")
+                .short('j')
+                .long("jobs")
+                .help("Number of workers parsing Go files, the number of CPUs by default; the output does not depend on it")
+                .value_name("N")
+                .value_parser(clap::value_parser!(usize)),
+        )
+        .arg(
+            clap::Arg::new("no-hooks")
+                .long("no-hooks")
+                .help("Do not run the configured pre- and post-generation hooks")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("strict")
+                .long("strict")
+                .help("Fail on warnings; with --strict=CODES (GS0201,interface), only on the warnings of these codes or categories")
+                .value_name("CODES")
+                .num_args(0..=1)
+                .require_equals(true)
+                .default_missing_value("all")
+                .value_delimiter(','),
+        )
+        .arg(
+            clap::Arg::new("verify")
+                .long("verify")
+                .help("Evaluate the generated files with jsonnet and fail on errors")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("manifest")
+                .long("manifest")
+                .help("Write a manifest of the input and output hashes to each output directory")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("any-policy")
+                .long("any-policy")
+                .help("How Go interface{}, any and json.RawMessage fields are generated (object, skip, require)")
+                .value_name("POLICY"),
+        )
+        .arg(
+            clap::Arg::new("trace-mapping")
+                .long("trace-mapping")
+                .help("Log which mapping rule decides the schema of a Go field (pkg.Type.Field)")
+                .value_name("FIELD"),
+        )
+        .arg(
+            clap::Arg::new("types")
+                .long("types")
+                .help("Generate only the Go types matching these patterns, '!' before patterns of types left out ('User*,!UserRepository')")
+                .value_name("PATTERNS")
+                .value_delimiter(','),
+        )
+        .arg(
+            clap::Arg::new("roots")
+                .long("roots")
+                .help("Generate only these Go types and the types they reference (User,CreateUserRequest)")
+                .value_name("TYPES")
+                .value_delimiter(','),
+        )
+        .arg(
+            clap::Arg::new("changelog")
+                .long("changelog")
+                .help("Add the changes of the types to the CHANGELOG.md of each output directory")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("changelog-since")
+                .long("changelog-since")
+                .help("Write the changelog entry against the output committed at this Git reference")
+                .value_name("REF"),
+        )
+        .arg(
+            clap::Arg::new("unsupported-report")
+                .long("unsupported-report")
+                .help("Write the constructs that could not be represented to a JSON or CSV file")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("report")
+                .long("report")
+                .help("Write the types processed, fields skipped, untranslated tags and warnings to a JSON file")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("lib-version")
+                .long("lib-version")
+                .help("Write each library to a directory of this version, with a jsonnetfile.json for jb")
+                .value_name("VERSION"),
+        )
+        .arg(
+            clap::Arg::new("at")
+                .long("at")
+                .help("Generate from the sources as of a Git reference or Go module version, into version-stamped directories")
+                .value_name("VERSION"),
+        )
+        .arg(
+            clap::Arg::new("stdin")
+                .long("stdin")
+                .help("Generate from Go source read from stdin, printing the libraries as a JSON object by file name unless --output is given")
+                .action(clap::ArgAction::SetTrue)
+                // Only --output, --any-policy, --types and --roots apply to Go source
+                // generated in memory
+                .conflicts_with_all([
+                    "config",
+                    "source",
+                    "dry-run",
+                    "at",
+                    "force",
+                    "fail-fast",
+                    "format",
+                    "output-format",
+                    "dependency-graph",
+                    "jobs",
+                    "no-hooks",
+                    "strict",
+                    "verify",
+                    "manifest",
+                    "trace-mapping",
+                    "changelog",
+                    "changelog-since",
+                    "unsupported-report",
+                    "report",
+                    "lib-version",
+                ]),
+        )
 }
 
 pub async fn run(matches: &ArgMatches) -> Result<()> {
     info!("Starting Jsonnet library generation");
 
-    let mut config = utils::load_config(matches)?;
+    // Go source piped in needs no configuration file
+    if matches.get_flag("stdin") {
+        return run_stdin(matches).await;
+    }
+
+    // Remote repositories given on the command line need no configuration file
+    let addresses: Vec<&String> = matches
+        .get_many::<String>("source")
+        .map(|addresses| addresses.collect())
+        .unwrap_or_default();
+    let mut config = if addresses.is_empty() || utils::get_config_path(matches).is_ok() {
+        utils::load_config(matches)?
+    } else {
+        Config::default()
+    };
 
     // Override output path if specified
     if let Some(output_path) = matches.get_one::<String>("output") {
         config.output.base_path = PathBuf::from(output_path);
     }
 
+    // Replace the configured sources by the remote repositories
+    if !addresses.is_empty() {
+        config.sources = addresses
+            .into_iter()
+            .map(|address| {
+                GoAstSource::from_url(address, &config.output.base_path).map(Source::GoAst)
+            })
+            .collect::<Result<_>>()?;
+    }
+
     // Override fail_fast setting if specified
     if matches.get_flag("fail-fast") {
         config.generation.fail_fast = true;
     }
 
-    let app = utils::create_app(config)?;
+    // Override the formatter if specified
+    if let Some(formatter) = matches.get_one::<String>("format") {
+        config.generation.formatter = formatter.parse()?;
+    }
+
+    // Write the types in other formats next to the libraries
+    for format in matches
+        .get_many::<String>("output-format")
+        .into_iter()
+        .flatten()
+    {
+        let format = format.parse()?;
+        if !config.generation.output_formats.contains(&format) {
+            config.generation.output_formats.push(format);
+        }
+    }
+
+    // Keep the type dependency graph of Go AST sources if requested
+    if matches.get_flag("dependency-graph") {
+        config.generation.dependency_graph = true;
+    }
+
+    // Cap the number of workers, e.g. to the CPUs of a CI container
+    if let Some(jobs) = matches.get_one::<usize>("jobs") {
+        config.generation.jobs = Some(*jobs);
+        config.generation.validate()?;
+    }
+
+    // Generate without the configured hooks if requested
+    if matches.get_flag("no-hooks") {
+        config.generation.hooks = Default::default();
+    }
+
+    // Turn warnings, or the warnings of some codes, into errors
+    if let Some(codes) = matches.get_many::<String>("strict") {
+        let diagnostics = &mut config.generation.diagnostics;
+        for code in codes {
+            if code == "all" {
+                diagnostics.strict = true;
+            } else {
+                diagnostics
+                    .levels
+                    .insert(code.clone(), crate::diagnostics::Level::Error);
+            }
+        }
+        diagnostics.validate()?;
+    }
+
+    // Evaluate the output after generation if requested
+    if matches.get_flag("verify") {
+        config.generation.verify = true;
+    }
+
+    // Record the hashes of the inputs and outputs if requested
+    if matches.get_flag("manifest") {
+        config.generation.manifest = true;
+    }
+
+    // Record the changes of the types in the changelog of each output directory
+    if matches.get_flag("changelog") {
+        config.generation.changelog = true;
+    }
+    if let Some(git_ref) = matches.get_one::<String>("changelog-since") {
+        config.generation.changelog = true;
+        config.generation.changelog_since = Some(git_ref.clone());
+    }
+
+    // Report the constructs that could not be represented
+    if let Some(report) = matches.get_one::<String>("unsupported-report") {
+        config.generation.unsupported_report = Some(PathBuf::from(report));
+    }
+    if let Some(report) = matches.get_one::<String>("report") {
+        config.generation.report = Some(PathBuf::from(report));
+    }
+
+    // Override the any policy of Go AST sources if specified
+    if let Some(policy) = matches.get_one::<String>("any-policy") {
+        let policy: AnyPolicy = policy.parse()?;
+        for source in &mut config.sources {
+            if let Source::GoAst(go_ast) = source {
+                go_ast.options.any_policy = Some(policy);
+            }
+        }
+    }
+
+    // Log how the schema of a field is chosen
+    if let Some(field) = matches.get_one::<String>("trace-mapping") {
+        for source in &mut config.sources {
+            if let Source::GoAst(go_ast) = source {
+                go_ast.options.trace_mapping = Some(field.clone());
+            }
+        }
+    }
+
+    // Generate a selection of the types of Go AST sources
+    let types: Vec<String> = matches
+        .get_many::<String>("types")
+        .map(|patterns| patterns.cloned().collect())
+        .unwrap_or_default();
+    let roots: Vec<String> = matches
+        .get_many::<String>("roots")
+        .map(|roots| roots.cloned().collect())
+        .unwrap_or_default();
+    if !types.is_empty() || !roots.is_empty() {
+        for source in &mut config.sources {
+            if let Source::GoAst(go_ast) = source {
+                if !types.is_empty() {
+                    go_ast.options.types = types.clone();
+                }
+                if !roots.is_empty() {
+                    go_ast.options.roots = roots.clone();
+                }
+                source.validate()?;
+            }
+        }
+    }
+
+    // Backfill the libraries of a historical version next to the current ones
+    let lockfile = match matches.get_one::<String>("at") {
+        Some(at) => Some(pin_sources(&mut config, at)?),
+        None => None,
+    };
+
+    // Versioned layout for publishing with jsonnet-bundler
+    if let Some(version) = matches.get_one::<String>("lib-version") {
+        stamp_lib_version(&mut config, version);
+    }
+
+    let verify = config.generation.verify;
+    let mut app = utils::create_app(config)?;
+    if let Some(lockfile) = lockfile {
+        app = app.with_lockfile(lockfile);
+    }
     app.initialize().await?;
 
     if matches.get_flag("dry-run") {
@@ -119,6 +435,7 @@ pub async fn run(matches: &ArgMatches) -> Result<()> {
                 source_result.source_type,
                 source_result.files_would_generate
             );
+            print_plan(&source_result);
             if !source_result.errors.is_empty() {
                 for error in source_result.errors {
                     eprintln!("    Error: {error}");
@@ -130,13 +447,34 @@ pub async fn run(matches: &ArgMatches) -> Result<()> {
                 }
             }
         }
+        for namespace_result in result.namespaces {
+            println!(
+                "  {} (namespace): {} files would change",
+                namespace_result.name,
+                namespace_result.files.len()
+            );
+            for planned in &namespace_result.files {
+                println!(
+                    "    {} {}",
+                    planned.action,
+                    namespace_result.output_path.join(&planned.file).display()
+                );
+            }
+            for error in namespace_result.errors {
+                eprintln!("    Error: {error}");
+            }
+        }
 
         return Ok(());
     }
 
     let result = app.generate().await?;
 
-    println!("Generation completed successfully!");
+    if result.statistics.error_count == 0 {
+        println!("Generation completed successfully!");
+    } else {
+        println!("Generation completed with errors");
+    }
     println!(
         "Sources processed: {}/{}",
         result.sources_processed, result.total_sources
@@ -147,17 +485,181 @@ pub async fn run(matches: &ArgMatches) -> Result<()> {
         result.statistics.total_processing_time_ms
     );
 
+    let mut failed_sources = 0;
+    let mut errors = 0;
     for source_result in result.results {
         println!(
             "  {}: {} files generated",
             source_result.source_type, source_result.files_generated
         );
         if !source_result.errors.is_empty() {
+            failed_sources += 1;
+            errors += source_result.errors.len();
             for error in source_result.errors {
                 eprintln!("    Error: {error}");
             }
         }
     }
 
+    // Generated code that does not evaluate must not be shipped
+    if verify && failed_sources > 0 {
+        return Err(anyhow!(
+            "Verification failed: {} sources have errors",
+            failed_sources
+        ));
+    }
+    // Every error of the run is listed above before failing
+    if failed_sources > 0 {
+        return Err(anyhow!(
+            "Generation failed with {} errors in {} sources",
+            errors,
+            failed_sources
+        ));
+    }
+
+    Ok(())
+}
+
+/// Generate from the Go source of stdin, with the options of the command line
+async fn run_stdin(matches: &ArgMatches) -> Result<()> {
+    let mut content = String::new();
+    std::io::stdin().read_to_string(&mut content)?;
+
+    let mut options = GoAstOptions::default();
+    if let Some(policy) = matches.get_one::<String>("any-policy") {
+        options.any_policy = Some(policy.parse()?);
+    }
+    if let Some(types) = matches.get_many::<String>("types") {
+        options.types = types.cloned().collect();
+    }
+    if let Some(roots) = matches.get_many::<String>("roots") {
+        options.roots = roots.cloned().collect();
+    }
+    options.validate_patterns()?;
+
+    let generated = generate_source(&[(PathBuf::from(STDIN_FILE), content)], &options).await?;
+    for warning in &generated.warnings {
+        eprintln!("warning: {}", warning);
+    }
+
+    match matches.get_one::<String>("output") {
+        Some(output) => {
+            let output = PathBuf::from(output);
+            std::fs::create_dir_all(&output)?;
+            for (file, code) in &generated.files {
+                std::fs::write(output.join(file), code)?;
+            }
+            println!(
+                "Generated {} files in {}",
+                generated.files.len(),
+                output.display()
+            );
+        }
+        None => println!("{}", serde_json::to_string_pretty(&generated.files)?),
+    }
     Ok(())
 }
+
+/// Print the types and output files a run would process for a source
+fn print_plan(source_result: &DryRunSourceResult) {
+    let mut packages: BTreeMap<&str, Vec<&str>> = BTreeMap::new();
+    for type_record in &source_result.types {
+        packages
+            .entry(type_record.package.as_str())
+            .or_default()
+            .push(type_record.name.as_str());
+    }
+    for (package, types) in packages {
+        println!("    package {}: {}", package, types.join(", "));
+    }
+    for skipped in &source_result.skipped_types {
+        println!(
+            "    skip type {}.{} ({}:{}): {}",
+            skipped.package,
+            skipped.name,
+            skipped.file.display(),
+            skipped.line,
+            skipped.reason
+        );
+    }
+    for skipped in &source_result.skipped_fields {
+        println!(
+            "    skip field {}.{}: {}",
+            skipped.type_name, skipped.field, skipped.reason
+        );
+    }
+    for planned in &source_result.files {
+        println!(
+            "    {} {}",
+            planned.action,
+            source_result.output_path.join(&planned.file).display()
+        );
+    }
+}
+
+/// Point the sources at a historical version and stamp their output directories
+///
+/// `./generated/widgets` becomes `./generated/widgets@v1.2.3`. The commits
+/// are recorded in a lockfile of the version, `gensonnet@v1.2.3.lock`, so
+/// generating the same version again checks out the same commits even if a
+/// tag was moved.
+fn pin_sources(config: &mut Config, at: &str) -> Result<PathBuf> {
+    let stamp = version_stamp(at);
+    let lockfile_path = PathBuf::from(format!("gensonnet@{stamp}.lock"));
+    let locked = LockfileManager::new(lockfile_path.clone()).load_or_create()?;
+    let git_ref = crate::git::version_ref(at);
+
+    for source in &mut config.sources {
+        let commit = locked
+            .sources
+            .get(source.name())
+            .filter(|entry| entry.url == source.git_url())
+            .map(|entry| entry.commit_sha.clone());
+        source.set_git_ref(commit.unwrap_or_else(|| git_ref.clone()));
+
+        let output_path = source.output_path();
+        let name = output_path
+            .file_name()
+            .map(|name| name.to_string_lossy().to_string())
+            .unwrap_or_else(|| source.name().to_string());
+        let stamped = output_path.with_file_name(format!("{name}@{stamp}"));
+        source.set_output_path(stamped);
+    }
+
+    Ok(lockfile_path)
+}
+
+/// Write the libraries to a directory of their version, next to other versions
+///
+/// `./generated/widgets` becomes `./generated/widgets/1.2`, which consumers
+/// install with `jb install <repository>/generated/widgets/1.2@<ref>`.
+fn stamp_lib_version(config: &mut Config, version: &str) {
+    config.generation.jsonnetfile = true;
+    for source in &mut config.sources {
+        let stamped = source.output_path().join(version_stamp(version));
+        source.set_output_path(stamped);
+    }
+}
+
+/// Turn a version into a directory and file name component
+fn version_stamp(at: &str) -> String {
+    at.replace(['/', '\\', ':'], "-")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_stdin_options() {
+        let parse = |args: &[&str]| {
+            command().try_get_matches_from(std::iter::once("generate").chain(args.iter().copied()))
+        };
+        assert!(parse(&["--stdin", "--types", "User", "-o", "./out"]).is_ok());
+
+        // Options of configured sources are rejected rather than ignored
+        for option in ["--strict", "--verify", "--report=report.json", "--jobs=4"] {
+            assert!(parse(&["--stdin", option]).is_err(), "{option}");
+        }
+    }
+}
@@ -0,0 +1,176 @@
+//! Hermetic command implementation
+
+use crate::config::{GitSource, GoAstSource, Source};
+use crate::plugin::ast::GoAstOptions;
+use crate::worker;
+use crate::{Config, JsonnetGen};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+
+pub fn command() -> Command {
+    Command::new("hermetic")
+        .about("Generate one library from an explicit list of Go files, for build systems such as Bazel")
+        .arg(
+            clap::Arg::new("name")
+                .long("name")
+                .help("Name of the library")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("src")
+                .long("src")
+                .help("Go file to parse, relative to the working directory")
+                .value_name("FILE")
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Output directory")
+                .value_name("DIR"),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file with the generation and output options")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Go AST source of the configuration whose options are used")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("persistent_worker")
+                .long("persistent_worker")
+                .alias("persistent-worker")
+                .help("Serve work requests of the Bazel JSON worker protocol on stdin")
+                .action(clap::ArgAction::SetTrue),
+        )
+        .arg(
+            clap::Arg::new("flagfile")
+                .help("Flag files holding the arguments, one per line")
+                .value_name("@FLAGFILE")
+                .num_args(0..),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    if matches.get_flag("persistent_worker") {
+        let stdin = tokio::io::BufReader::new(tokio::io::stdin());
+        return worker::serve(stdin, tokio::io::stdout(), |arguments| async move {
+            generate(&parse_arguments(arguments)?).await
+        })
+        .await;
+    }
+
+    // Arguments in flag files replace the others, as Bazel passes them all there
+    let flagfiles: Vec<String> = matches
+        .get_many::<String>("flagfile")
+        .map(|flagfiles| flagfiles.cloned().collect())
+        .unwrap_or_default();
+    let output = if flagfiles.is_empty() {
+        generate(matches).await?
+    } else {
+        generate(&parse_arguments(flagfiles)?).await?
+    };
+    print!("{output}");
+    Ok(())
+}
+
+/// Parse the arguments of one action, expanding flag files
+fn parse_arguments(arguments: Vec<String>) -> Result<ArgMatches> {
+    let arguments = worker::expand_flagfiles(arguments)?;
+    Ok(command().try_get_matches_from(std::iter::once("hermetic".to_string()).chain(arguments))?)
+}
+
+/// Generate the library of one action and return its messages
+///
+/// No repository is cloned, no lockfile is written and the Go files are
+/// the ones given: references to other modules are left unresolved and Go
+/// tests, which would be written next to the sources, are not generated.
+async fn generate(matches: &ArgMatches) -> Result<String> {
+    let name = matches
+        .get_one::<String>("name")
+        .ok_or_else(|| anyhow!("--name is required"))?;
+    let output = matches
+        .get_one::<String>("output")
+        .ok_or_else(|| anyhow!("--output is required"))?;
+    let root = std::env::current_dir()?;
+    let go_files: Vec<PathBuf> = matches
+        .get_many::<String>("src")
+        .ok_or_else(|| anyhow!("At least one --src is required"))?
+        .map(|file| root.join(file))
+        .collect();
+
+    let config = match matches.get_one::<String>("config") {
+        Some(path) => Config::from_file(&PathBuf::from(path))?,
+        None => Config::default(),
+    };
+    let mut options = match matches.get_one::<String>("source") {
+        Some(source_name) => config
+            .sources
+            .iter()
+            .find_map(|source| match source {
+                Source::GoAst(go_ast) if go_ast.name == *source_name => {
+                    Some(go_ast.options.clone())
+                }
+                _ => None,
+            })
+            .ok_or_else(|| {
+                anyhow!(
+                    "No Go AST source named {} in the configuration",
+                    source_name
+                )
+            })?,
+        None => GoAstOptions::default(),
+    };
+    options.fetch_dependencies = false;
+    options.go_tests = false;
+
+    let source = GoAstSource {
+        name: name.clone(),
+        git: GitSource {
+            url: String::new(),
+            ref_name: None,
+            auth: None,
+        },
+        include_patterns: matches
+            .get_many::<String>("src")
+            .into_iter()
+            .flatten()
+            .cloned()
+            .collect(),
+        exclude_patterns: Vec::new(),
+        output_path: PathBuf::from(output),
+        package_filters: None,
+        options,
+    };
+
+    let app = JsonnetGen::new(config)?;
+    app.initialize_plugins().await?;
+    let result = app.process_go_files_at(&source, &root, &go_files).await?;
+
+    let mut messages = String::new();
+    for warning in &result.warnings {
+        messages.push_str(&format!("warning: {warning}\n"));
+    }
+    if !result.errors.is_empty() {
+        return Err(anyhow!(
+            "{}{} errors generating {}:\n{}",
+            messages,
+            result.errors.len(),
+            name,
+            result.errors.join("\n")
+        ));
+    }
+    messages.push_str(&format!(
+        "Generated {} files for {}\n",
+        result.files_generated, name
+    ));
+    Ok(messages)
+}
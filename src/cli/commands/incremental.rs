@@ -286,9 +286,9 @@ async fn process_sources_parallel(
         }
     });
 
-    // Collect results, allowing some failures
+    // Collect results in the order of the sources, allowing some failures
     let results = stream::iter(futures)
-        .buffer_unordered(max_workers)
+        .buffered(max_workers)
         .collect::<Vec<_>>()
         .await;
 
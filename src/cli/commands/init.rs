@@ -1,9 +1,12 @@
 //! Init command implementation
 
+use crate::config::{GitSource, Source};
+use crate::wizard::{self, PackageScan};
 use crate::Config;
-use anyhow::Result;
+use anyhow::{anyhow, Result};
 use clap::{ArgMatches, Command};
-use std::path::PathBuf;
+use std::io::{BufRead, IsTerminal, Write};
+use std::path::{Path, PathBuf};
 use tracing::info;
 
 pub fn command() -> Command {
@@ -24,12 +27,40 @@ pub fn command() -> Command {
                 .help("Create example configuration")
                 .action(clap::ArgAction::SetTrue),
         )
+        .arg(
+            clap::Arg::new("scan")
+                .long("scan")
+                .help("Scan a Go module and write a starter configuration for its packages")
+                .value_name("DIR")
+                .num_args(0..=1)
+                .default_missing_value(".")
+                .conflicts_with("example"),
+        )
+        .arg(
+            clap::Arg::new("url")
+                .long("url")
+                .help("Repository of the scanned module, when its checkout has no origin remote")
+                .value_name("URL")
+                .requires("scan"),
+        )
+        .arg(
+            clap::Arg::new("yes")
+                .short('y')
+                .long("yes")
+                .help("Include every proposed package without asking")
+                .action(clap::ArgAction::SetTrue)
+                .requires("scan"),
+        )
 }
 
 pub async fn run(matches: &ArgMatches) -> Result<()> {
     let output_path = PathBuf::from(matches.get_one::<String>("output").unwrap());
     let example = matches.get_flag("example");
 
+    if let Some(dir) = matches.get_one::<String>("scan") {
+        return run_scan(matches, Path::new(dir), &output_path);
+    }
+
     info!("Initializing configuration file: {:?}", output_path);
 
     let config = if example {
@@ -53,6 +84,81 @@ pub async fn run(matches: &ArgMatches) -> Result<()> {
     Ok(())
 }
 
+/// Propose the packages of a Go module, asking for each one on a terminal,
+/// and write the configuration generating those chosen
+fn run_scan(matches: &ArgMatches, dir: &Path, output_path: &PathBuf) -> Result<()> {
+    info!("Scanning Go module: {:?}", dir);
+    let scan = wizard::scan_module(dir)?;
+    if scan.packages.is_empty() {
+        return Err(anyhow!(
+            "No package of {} declares exported struct types",
+            dir.display()
+        ));
+    }
+
+    let interactive = !matches.get_flag("yes") && std::io::stdin().is_terminal();
+    println!(
+        "Found {} package(s) with exported types in {}:",
+        scan.packages.len(),
+        scan.module.as_deref().unwrap_or(&dir.display().to_string())
+    );
+    let stdin = std::io::stdin();
+    let mut lines = stdin.lock().lines();
+    let mut chosen: Vec<&PackageScan> = Vec::new();
+    for package in &scan.packages {
+        println!("  {}", package.summary());
+        if interactive {
+            print!("  Include it? [Y/n] ");
+            std::io::stdout().flush()?;
+            let answer = lines.next().transpose()?.unwrap_or_default();
+            if matches!(answer.trim().to_lowercase().as_str(), "n" | "no") {
+                continue;
+            }
+        }
+        chosen.push(package);
+    }
+    if chosen.is_empty() {
+        return Err(anyhow!("No package chosen; no configuration written"));
+    }
+
+    let located = wizard::checkout_repository(dir)
+        .or_else(|| scan.module.as_deref().and_then(wizard::module_repository));
+    let url = match (matches.get_one::<String>("url"), &located) {
+        (Some(url), _) => url.clone(),
+        (None, Some((url, _))) => url.clone(),
+        (None, None) => {
+            return Err(anyhow!(
+                "Cannot tell the repository of {}; give it with --url",
+                dir.display()
+            ))
+        }
+    };
+    let module_dir = located.map(|(_, dir)| dir).unwrap_or_default();
+    let git = GitSource {
+        url,
+        ref_name: None,
+        auth: None,
+    };
+    let source = wizard::propose_source(&scan, &chosen, git, &module_dir, Path::new("./generated"));
+    source.validate()?;
+
+    for note in wizard::notes(&chosen) {
+        println!("{note}");
+    }
+    let mut config = Config::default();
+    config.sources.push(Source::GoAst(source));
+    config.save_to_file(output_path)?;
+
+    info!("Configuration file created: {:?}", output_path);
+    println!(
+        "Configuration for {} package(s) written to {}.",
+        chosen.len(),
+        output_path.display()
+    );
+    println!("Run `gensonnet generate` to generate the libraries.");
+    Ok(())
+}
+
 fn create_example_config() -> Config {
     let mut config = Config::default();
 
@@ -233,6 +233,86 @@ async fn update_lockfile(lockfile_manager: &LockfileManager) -> Result<()> {
                     openapi_source.include_patterns.clone(),
                 );
 
+                current_sources.insert(source_name.clone(), commit_sha);
+                source_entries.insert(source_name, entry);
+            }
+            crate::config::Source::Terraform(terraform_source) => {
+                // Get repository path and current commit
+                let repo_path = match git_manager.ensure_repository(&terraform_source.git).await {
+                    Ok(path) => path,
+                    Err(e) => {
+                        warn!(
+                            "Failed to access repository {}: {}",
+                            terraform_source.git.url, e
+                        );
+                        println!("Skipping source '{source_name}' due to repository access error");
+                        continue;
+                    }
+                };
+                let commit_sha = match git_manager.get_current_commit(&repo_path) {
+                    Ok(sha) => sha,
+                    Err(e) => {
+                        warn!(
+                            "Failed to get commit SHA for {}: {}",
+                            terraform_source.git.url, e
+                        );
+                        println!("Skipping source '{source_name}' due to commit access error");
+                        continue;
+                    }
+                };
+
+                // Create lockfile entry
+                let entry = jsonnet_lockfile::LockfileEntry::new(
+                    terraform_source.git.url.clone(),
+                    terraform_source
+                        .git
+                        .ref_name
+                        .clone()
+                        .unwrap_or_else(|| "main".to_string()),
+                    commit_sha.clone(),
+                    terraform_source.include_patterns.clone(),
+                );
+
+                current_sources.insert(source_name.clone(), commit_sha);
+                source_entries.insert(source_name, entry);
+            }
+            crate::config::Source::Graphql(graphql_source) => {
+                // Get repository path and current commit
+                let repo_path = match git_manager.ensure_repository(&graphql_source.git).await {
+                    Ok(path) => path,
+                    Err(e) => {
+                        warn!(
+                            "Failed to access repository {}: {}",
+                            graphql_source.git.url, e
+                        );
+                        println!("Skipping source '{source_name}' due to repository access error");
+                        continue;
+                    }
+                };
+                let commit_sha = match git_manager.get_current_commit(&repo_path) {
+                    Ok(sha) => sha,
+                    Err(e) => {
+                        warn!(
+                            "Failed to get commit SHA for {}: {}",
+                            graphql_source.git.url, e
+                        );
+                        println!("Skipping source '{source_name}' due to commit access error");
+                        continue;
+                    }
+                };
+
+                // Create lockfile entry
+                let entry = jsonnet_lockfile::LockfileEntry::new(
+                    graphql_source.git.url.clone(),
+                    graphql_source
+                        .git
+                        .ref_name
+                        .clone()
+                        .unwrap_or_else(|| "main".to_string()),
+                    commit_sha.clone(),
+                    graphql_source.include_patterns.clone(),
+                );
+
                 current_sources.insert(source_name.clone(), commit_sha);
                 source_entries.insert(source_name, entry);
             }
@@ -0,0 +1,90 @@
+//! Migrate command implementation
+
+use crate::migrate::{self, Migrations};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+
+pub fn command() -> Command {
+    Command::new("migrate")
+        .about("Rewrite consumer Jsonnet to the renamed functions of regenerated libraries")
+        .arg(
+            clap::Arg::new("lib")
+                .help("Output directory of the generated libraries")
+                .value_name("LIB")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("consumer")
+                .help("Jsonnet trees to migrate")
+                .value_name("DIR")
+                .required(true)
+                .num_args(1..),
+        )
+        .arg(
+            clap::Arg::new("jpath")
+                .short('J')
+                .long("jpath")
+                .help("Library search directory used to resolve imports in consumer trees")
+                .value_name("DIR")
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("dry-run")
+                .long("dry-run")
+                .help("Show the files that would be rewritten without changing them")
+                .action(clap::ArgAction::SetTrue),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let lib = PathBuf::from(matches.get_one::<String>("lib").unwrap());
+    if !lib.join(migrate::MIGRATIONS_FILE).is_file() {
+        return Err(anyhow!(
+            "No {} in {}; the libraries have nothing to migrate",
+            migrate::MIGRATIONS_FILE,
+            lib.display()
+        ));
+    }
+    let migrations = Migrations::load(&lib)?;
+
+    let consumers: Vec<PathBuf> = matches
+        .get_many::<String>("consumer")
+        .map(|dirs| dirs.map(PathBuf::from).collect())
+        .unwrap_or_default();
+    let jpath: Vec<PathBuf> = matches
+        .get_many::<String>("jpath")
+        .map(|dirs| dirs.map(PathBuf::from).collect())
+        .unwrap_or_default();
+    let dry_run = matches.get_flag("dry-run");
+
+    let rewritten = migrate::migrate_consumers(&migrations, &lib, &consumers, &jpath, dry_run)?;
+    let total: usize = rewritten.iter().map(|(_, rewrite)| rewrite.count).sum();
+    for (file, rewrite) in &rewritten {
+        if rewrite.count > 0 {
+            println!("  {}: {} usages rewritten", file.display(), rewrite.count);
+        }
+        for (line, function) in &rewrite.unresolved {
+            println!(
+                "  {}:{}: {} not rewritten: its receiver is not known to come from the library defining it",
+                file.display(),
+                line,
+                function
+            );
+        }
+    }
+    if dry_run {
+        println!("Would rewrite {total} usages");
+    } else {
+        println!("Rewrote {total} usages");
+    }
+
+    // Removed functions have to be replaced by hand
+    for (file, library) in &migrations.libraries {
+        for removed in &library.removed {
+            println!("Note: {file}: {removed} was removed and has no replacement");
+        }
+    }
+
+    Ok(())
+}
@@ -1,12 +1,30 @@
 //! CLI command modules
 
+pub mod breaking;
+pub mod bundle;
+pub mod check;
 pub mod cleanup;
+pub mod compat_test;
+pub mod daemon;
+pub mod diff;
+pub mod doctor;
+pub mod fixtures;
 pub mod generate;
+pub mod hermetic;
 pub mod incremental;
 pub mod info;
 pub mod init;
 pub mod lock;
+pub mod migrate;
+pub mod natives;
 pub mod plugins;
+pub mod publish;
+pub mod pull;
+pub mod rename;
+pub mod repl;
 pub mod status;
+pub mod surface;
+pub mod template;
 pub mod test;
 pub mod validate;
+pub mod watch;
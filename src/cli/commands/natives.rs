@@ -0,0 +1,32 @@
+//! Natives command implementation
+
+use anyhow::Result;
+use clap::{ArgMatches, Command};
+
+pub fn command() -> Command {
+    Command::new("natives")
+        .about("Print a Go package registering the native functions of the generated libraries with go-jsonnet")
+        .arg(
+            clap::Arg::new("package")
+                .long("package")
+                .help("Name of the Go package")
+                .value_name("NAME")
+                .default_value("natives"),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Write the package to a file")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let source = crate::natives::go_source(matches.get_one::<String>("package").unwrap())?;
+    match matches.get_one::<String>("output") {
+        Some(output) => std::fs::write(output, source)?,
+        None => print!("{source}"),
+    }
+    Ok(())
+}
@@ -0,0 +1,89 @@
+//! Publish command implementation
+
+use crate::cli::utils;
+use crate::oci::{self, Reference};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::{Path, PathBuf};
+
+pub fn command() -> Command {
+    Command::new("publish")
+        .about("Push generated libraries to an OCI registry as a versioned artifact")
+        .arg(
+            clap::Arg::new("oci")
+                .long("oci")
+                .help("Reference of the artifact (oci://registry/repository:tag)")
+                .value_name("REFERENCE")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("dir")
+                .help("Output directory to publish (default: that of the configured source)")
+                .value_name("DIR"),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Name of the source whose output directory is published")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("oras")
+                .long("oras")
+                .help("Path of the oras binary, looked up in PATH by default")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let reference: Reference = matches.get_one::<String>("oci").unwrap().parse()?;
+
+    let dir = match matches.get_one::<String>("dir") {
+        Some(dir) => PathBuf::from(dir),
+        None => {
+            let config = utils::load_config(matches)?;
+            let name = matches.get_one::<String>("source");
+            let mut sources = config
+                .sources
+                .iter()
+                .filter(|source| name.is_none_or(|name| source.name() == name));
+            match (sources.next(), sources.next()) {
+                (Some(source), None) => source.output_path().to_path_buf(),
+                (None, _) => {
+                    return Err(anyhow!(
+                        "No source named {}",
+                        name.map_or("", |name| name.as_str())
+                    ))
+                }
+                (Some(_), Some(_)) => {
+                    return Err(anyhow!(
+                        "The configuration has several sources; pick one with --source or give the directory"
+                    ))
+                }
+            }
+        }
+    };
+    if !dir.is_dir() {
+        return Err(anyhow!(
+            "Output directory {} does not exist; run `gensonnet generate` first",
+            dir.display()
+        ));
+    }
+
+    let oras = matches.get_one::<String>("oras").map(Path::new);
+    let files = oci::push(&dir, &reference, oras)?;
+    println!(
+        "Published {} files of {} to {}",
+        files.len(),
+        dir.display(),
+        reference
+    );
+    Ok(())
+}
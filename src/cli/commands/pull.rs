@@ -0,0 +1,43 @@
+//! Pull command implementation
+
+use crate::oci::{self, Reference};
+use anyhow::Result;
+use clap::{ArgMatches, Command};
+use std::path::{Path, PathBuf};
+
+pub fn command() -> Command {
+    Command::new("pull")
+        .about("Fetch generated libraries published to an OCI registry")
+        .arg(
+            clap::Arg::new("reference")
+                .help("Reference of the artifact (oci://registry/repository:tag)")
+                .value_name("REFERENCE")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Directory the library is written to (default: vendor/<repository name>)")
+                .value_name("DIR"),
+        )
+        .arg(
+            clap::Arg::new("oras")
+                .long("oras")
+                .help("Path of the oras binary, looked up in PATH by default")
+                .value_name("FILE"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let reference: Reference = matches.get_one::<String>("reference").unwrap().parse()?;
+    let dir = match matches.get_one::<String>("output") {
+        Some(dir) => PathBuf::from(dir),
+        None => oci::default_pull_dir(&reference),
+    };
+
+    let oras = matches.get_one::<String>("oras").map(Path::new);
+    oci::pull(&reference, &dir, oras)?;
+    println!("Pulled {} into {}", reference, dir.display());
+    Ok(())
+}
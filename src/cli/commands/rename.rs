@@ -0,0 +1,179 @@
+//! Rename command implementation
+
+use crate::cli::utils;
+use crate::config::Source;
+use crate::plugin::ast::generator::{renamed_setters, GoJsonnetGenerator};
+use crate::rename::{FieldPath, RenamedLibrary, Rewriter};
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::collections::HashMap;
+use std::path::PathBuf;
+use tracing::info;
+
+pub fn command() -> Command {
+    Command::new("rename")
+        .about("Rename a Go struct field, keeping the old setters as deprecated aliases")
+        .arg(
+            clap::Arg::new("from")
+                .help("Field to rename (Type.Field or pkg.Type.Field)")
+                .value_name("FROM")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("to")
+                .help("New name of the field (Type.Field or pkg.Type.Field)")
+                .value_name("TO")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("source")
+                .long("source")
+                .help("Only record the rename for the named Go AST source")
+                .value_name("NAME"),
+        )
+        .arg(
+            clap::Arg::new("consumer")
+                .long("consumer")
+                .help("Jsonnet tree whose usages of the old setters are rewritten")
+                .value_name("DIR")
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("jpath")
+                .short('J')
+                .long("jpath")
+                .help("Library search directory used to resolve imports in consumer trees")
+                .value_name("DIR")
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("no-generate")
+                .long("no-generate")
+                .help("Only record the rename, without regenerating or rewriting consumers")
+                .action(clap::ArgAction::SetTrue),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let from: FieldPath = matches.get_one::<String>("from").unwrap().parse()?;
+    let to: FieldPath = matches.get_one::<String>("to").unwrap().parse()?;
+    if !from.same_type(&to) {
+        return Err(anyhow!(
+            "Only fields can be renamed: {} and {} must name the same type",
+            from.key(),
+            to.key()
+        ));
+    }
+    info!("Renaming {} to {}", from.key(), to.field);
+
+    let config_path = utils::get_config_path(matches)?;
+    let mut config = crate::Config::from_file(&config_path)?;
+    let source_name = matches.get_one::<String>("source");
+
+    let mut output_paths = Vec::new();
+    for source in &mut config.sources {
+        if let Source::GoAst(go_ast) = source {
+            if source_name.is_some_and(|name| *name != go_ast.name) {
+                continue;
+            }
+            go_ast.options.renames.insert(from.key(), to.field.clone());
+            output_paths.push(go_ast.output_path.clone());
+        }
+    }
+    if output_paths.is_empty() {
+        return Err(anyhow!("No Go AST source to record the rename in"));
+    }
+
+    config.save_to_file(&config_path)?;
+    println!(
+        "Recorded rename of {} to {} in {}",
+        from.key(),
+        to.field,
+        config_path.display()
+    );
+
+    if matches.get_flag("no-generate") {
+        return Ok(());
+    }
+
+    let app = utils::create_app(config)?;
+    app.initialize().await?;
+    let result = app.generate().await?;
+    println!(
+        "Regenerated {} files with aliases for the old setters",
+        result.statistics.files_generated
+    );
+
+    let consumers: Vec<PathBuf> = matches
+        .get_many::<String>("consumer")
+        .map(|dirs| dirs.map(PathBuf::from).collect())
+        .unwrap_or_default();
+    if consumers.is_empty() {
+        return Ok(());
+    }
+    let jpath: Vec<PathBuf> = matches
+        .get_many::<String>("jpath")
+        .map(|dirs| dirs.map(PathBuf::from).collect())
+        .unwrap_or_default();
+
+    // The aliases in the regenerated library name the setters to rewrite
+    let file_name = GoJsonnetGenerator::new().type_file_name(&to.type_name);
+    let mut libraries = Vec::new();
+    for output_path in &output_paths {
+        let file = output_path.join(&file_name);
+        if let Ok(code) = std::fs::read_to_string(&file) {
+            let renames: HashMap<String, String> = renamed_setters(&code).into_iter().collect();
+            if !renames.is_empty() {
+                libraries.push(RenamedLibrary {
+                    type_name: Some(to.type_name.clone()),
+                    file,
+                    renames,
+                });
+            }
+        }
+    }
+    if libraries.is_empty() {
+        println!("No renamed setters found in {file_name}; consumers were not changed");
+        return Ok(());
+    }
+
+    // Only calls on values of the library are rewritten; the others are
+    // listed, since a setter of another type may have the same name
+    let rewriter = Rewriter::new(libraries, &jpath);
+    let mut total = 0;
+    let mut unresolved = 0;
+    for consumer in &consumers {
+        for file in rewriter.dependent_files(consumer)? {
+            let code = std::fs::read_to_string(&file)?;
+            let rewrite = rewriter.rewrite(&file, &code);
+            if rewrite.count > 0 {
+                std::fs::write(&file, &rewrite.code)?;
+                println!("  {}: {} usages rewritten", file.display(), rewrite.count);
+                total += rewrite.count;
+            }
+            for (line, function) in &rewrite.unresolved {
+                println!(
+                    "  {}:{}: {} not rewritten: its receiver is not known to be a {}",
+                    file.display(),
+                    line,
+                    function,
+                    to.type_name
+                );
+            }
+            unresolved += rewrite.unresolved.len();
+        }
+    }
+    println!("Rewrote {total} usages");
+    if unresolved > 0 {
+        println!("{unresolved} usages need to be checked by hand");
+    }
+
+    Ok(())
+}
@@ -0,0 +1,119 @@
+//! REPL command implementation
+
+use crate::cli::utils;
+use crate::evaluate::Evaluator;
+use crate::repl::{self, Input, Session};
+use anyhow::Result;
+use clap::{ArgMatches, Command};
+use std::io::{BufRead, Write};
+use std::path::PathBuf;
+use tracing::info;
+
+const HELP: &str = "\
+Enter an expression to evaluate it, or `local name = ...` to define a name.
+  :libs   List the bound libraries and definitions
+  :reset  Forget the definitions
+  :quit   Leave the REPL";
+
+pub fn command() -> Command {
+    Command::new("repl")
+        .about("Evaluate Jsonnet interactively with the generated libraries imported")
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("output")
+                .short('o')
+                .long("output")
+                .help("Generated output directory (defaults to the output of every source)")
+                .value_name("DIR")
+                .action(clap::ArgAction::Append),
+        )
+        .arg(
+            clap::Arg::new("jsonnet")
+                .long("jsonnet")
+                .help("jsonnet binary used for evaluation")
+                .value_name("PATH"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let dirs: Vec<PathBuf> = match matches.get_many::<String>("output") {
+        Some(dirs) => dirs.map(PathBuf::from).collect(),
+        None => {
+            let config = utils::load_config(matches)?;
+            config
+                .sources
+                .iter()
+                .map(|source| source.output_path().to_path_buf())
+                .collect()
+        }
+    };
+    info!("Starting REPL for {:?}", dirs);
+
+    let evaluator = Evaluator::new(
+        matches
+            .get_one::<String>("jsonnet")
+            .map(PathBuf::from)
+            .as_deref(),
+        dirs.clone(),
+    );
+    // There is no embedded evaluator, so fail before the first input
+    evaluator.check()?;
+    let mut session = Session::new(&dirs);
+
+    let names: Vec<&str> = session.bindings().iter().map(|(n, _)| n.as_str()).collect();
+    println!("Libraries: {}", names.join(", "));
+    println!("Type :help for help");
+
+    let stdin = std::io::stdin();
+    let mut lines = stdin.lock().lines();
+    loop {
+        let mut input = String::new();
+        let mut prompt = "> ";
+        loop {
+            print!("{prompt}");
+            std::io::stdout().flush()?;
+            match lines.next() {
+                Some(line) => input.push_str(&line?),
+                None => return Ok(()),
+            }
+            if repl::is_complete(&input) {
+                break;
+            }
+            input.push('\n');
+            prompt = "... ";
+        }
+
+        match Input::parse(&input) {
+            Input::Quit => return Ok(()),
+            Input::Help => println!("{HELP}"),
+            Input::Reset => session.reset(),
+            Input::List => {
+                for (name, path) in session.bindings() {
+                    println!("  {name} = import '{path}'");
+                }
+                for local in session.locals() {
+                    println!("  {local}");
+                }
+            }
+            Input::Local(definition) => {
+                match evaluator.evaluate(&session.check_program(&definition)) {
+                    Ok(_) => session.add_local(definition),
+                    Err(e) => println!("{e}"),
+                }
+            }
+            Input::Expression(expression) if expression.is_empty() => {}
+            Input::Expression(expression) => {
+                match evaluator.evaluate(&session.program(&expression)) {
+                    Ok(output) => println!("{output}"),
+                    Err(e) => println!("{e}"),
+                }
+            }
+        }
+    }
+}
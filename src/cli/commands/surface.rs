@@ -0,0 +1,96 @@
+//! Surface command implementation
+
+use crate::surface::Surface;
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+
+pub fn command() -> Command {
+    Command::new("surface")
+        .about("Export and compare the API surface of generated libraries")
+        .subcommand_negates_reqs(true)
+        .subcommand(
+            Command::new("export")
+                .about("Print the API surface of an output directory")
+                .arg(
+                    clap::Arg::new("dir")
+                        .help("Output directory of the generated libraries")
+                        .value_name("DIR")
+                        .required(true),
+                )
+                .arg(
+                    clap::Arg::new("output")
+                        .short('o')
+                        .long("output")
+                        .help("Write the surface to a file")
+                        .value_name("FILE"),
+                ),
+        )
+        .subcommand(
+            Command::new("diff")
+                .about("Compare two API surfaces and fail on breaking changes")
+                .arg(
+                    clap::Arg::new("old")
+                        .help("Old surface file or output directory")
+                        .value_name("OLD")
+                        .required(true),
+                )
+                .arg(
+                    clap::Arg::new("new")
+                        .help("New surface file or output directory")
+                        .value_name("NEW")
+                        .required(true),
+                ),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    match matches.subcommand() {
+        Some(("export", sub_matches)) => {
+            let dir = PathBuf::from(sub_matches.get_one::<String>("dir").unwrap());
+            if !dir.is_dir() {
+                return Err(anyhow!("Not a directory: {}", dir.display()));
+            }
+
+            let json = Surface::from_directory(&dir)?.to_json()?;
+            match sub_matches.get_one::<String>("output") {
+                Some(output) => std::fs::write(output, json)?,
+                None => print!("{json}"),
+            }
+            Ok(())
+        }
+        Some(("diff", sub_matches)) => {
+            let old = Surface::load(&PathBuf::from(
+                sub_matches.get_one::<String>("old").unwrap(),
+            ))?;
+            let new = Surface::load(&PathBuf::from(
+                sub_matches.get_one::<String>("new").unwrap(),
+            ))?;
+            let diff = old.diff(&new);
+
+            for removed in &diff.removed {
+                println!("- {removed}");
+            }
+            for changed in &diff.changed {
+                println!("~ {changed}");
+            }
+            for added in &diff.added {
+                println!("+ {added}");
+            }
+
+            if diff.is_breaking() {
+                return Err(anyhow!(
+                    "{} functions were removed and {} changed",
+                    diff.removed.len(),
+                    diff.changed.len()
+                ));
+            }
+            println!("No breaking changes");
+            Ok(())
+        }
+        _ => {
+            let _ = command().print_help();
+            Ok(())
+        }
+    }
+}
@@ -0,0 +1,103 @@
+//! Template command implementation
+
+use crate::evaluate::Evaluator;
+use crate::template::TemplateTester;
+use anyhow::{anyhow, Result};
+use clap::{ArgMatches, Command};
+use std::path::PathBuf;
+
+pub fn command() -> Command {
+    Command::new("template")
+        .about("Work with custom code generation templates")
+        .subcommand_negates_reqs(true)
+        .subcommand(
+            Command::new("test")
+                .about(
+                    "Render templates against fixture type graphs and compare them to golden files",
+                )
+                .arg(
+                    clap::Arg::new("templates")
+                        .help("Directory of the templates (*.jsonnet)")
+                        .value_name("DIR")
+                        .required(true),
+                )
+                .arg(
+                    clap::Arg::new("fixture")
+                        .long("fixture")
+                        .help("Directory of the fixtures and their golden files")
+                        .value_name("DIR")
+                        .required(true),
+                )
+                .arg(
+                    clap::Arg::new("update")
+                        .long("update")
+                        .help("Rewrite the golden files from the output")
+                        .action(clap::ArgAction::SetTrue),
+                )
+                .arg(
+                    clap::Arg::new("jpath")
+                        .short('J')
+                        .long("jpath")
+                        .help("Library search directory for imports of the templates")
+                        .value_name("DIR")
+                        .action(clap::ArgAction::Append),
+                )
+                .arg(
+                    clap::Arg::new("jsonnet")
+                        .long("jsonnet")
+                        .help("Path of the jsonnet binary (default: jsonnet from PATH)")
+                        .value_name("PATH"),
+                ),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    match matches.subcommand() {
+        Some(("test", sub_matches)) => {
+            let templates = PathBuf::from(sub_matches.get_one::<String>("templates").unwrap());
+            let fixtures = PathBuf::from(sub_matches.get_one::<String>("fixture").unwrap());
+            let jpath: Vec<PathBuf> = sub_matches
+                .get_many::<String>("jpath")
+                .map(|dirs| dirs.map(PathBuf::from).collect())
+                .unwrap_or_default();
+            let evaluator = Evaluator::new(
+                sub_matches
+                    .get_one::<String>("jsonnet")
+                    .map(PathBuf::from)
+                    .as_deref(),
+                jpath,
+            );
+
+            let update = sub_matches.get_flag("update");
+            let results = TemplateTester::new(evaluator, &templates, &fixtures).run(update)?;
+
+            let mut failed = 0;
+            for result in &results {
+                if result.passed() {
+                    let status = if update { "updated" } else { "ok" };
+                    println!("{} {}/{}", status, result.fixture, result.template);
+                } else {
+                    failed += 1;
+                    println!("FAILED {}/{}", result.fixture, result.template);
+                    for mismatch in &result.mismatches {
+                        println!("  {mismatch}");
+                    }
+                }
+            }
+
+            if failed > 0 {
+                return Err(anyhow!(
+                    "{} of {} template cases failed; run with --update if the changes are expected",
+                    failed,
+                    results.len()
+                ));
+            }
+            println!("{} template cases passed", results.len());
+            Ok(())
+        }
+        _ => {
+            let _ = command().print_help();
+            Ok(())
+        }
+    }
+}
@@ -35,6 +35,8 @@ pub async fn run(matches: &ArgMatches) -> Result<()> {
                 crate::config::Source::Crd(_) => "CRD",
                 crate::config::Source::GoAst(_) => "Go AST",
                 crate::config::Source::OpenApi(_) => "OpenAPI",
+                crate::config::Source::Terraform(_) => "Terraform",
+                crate::config::Source::Graphql(_) => "GraphQL",
             }
         );
     }
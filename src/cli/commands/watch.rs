@@ -0,0 +1,225 @@
+//! Watch command implementation
+
+use crate::cli::utils;
+use crate::config::Source;
+use crate::utils::calculate_file_hash;
+use crate::watch::{reload_config, ConfigDiff, Inputs, Watcher};
+use anyhow::Result;
+use clap::{ArgMatches, Command};
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+use tracing::{info, warn};
+
+pub fn command() -> Command {
+    Command::new("watch")
+        .about(
+            "Regenerate libraries whenever files in a local checkout or the configuration change",
+        )
+        .arg(
+            clap::Arg::new("dir")
+                .help("Local checkout used in place of the Git repository of each source")
+                .value_name("DIR")
+                .required(true),
+        )
+        .arg(
+            clap::Arg::new("config")
+                .short('c')
+                .long("config")
+                .help("Configuration file path")
+                .value_name("FILE"),
+        )
+        .arg(
+            clap::Arg::new("interval")
+                .long("interval")
+                .help("Polling interval in milliseconds")
+                .value_name("MS")
+                .value_parser(clap::value_parser!(u64))
+                .default_value("500"),
+        )
+        .arg(
+            clap::Arg::new("debounce")
+                .long("debounce")
+                .help("Time in milliseconds files must stay unchanged before regenerating")
+                .value_name("MS")
+                .value_parser(clap::value_parser!(u64))
+                .default_value("300"),
+        )
+}
+
+pub async fn run(matches: &ArgMatches) -> Result<()> {
+    let dir = PathBuf::from(matches.get_one::<String>("dir").unwrap());
+    let interval = Duration::from_millis(*matches.get_one::<u64>("interval").unwrap());
+    let debounce = Duration::from_millis(*matches.get_one::<u64>("debounce").unwrap());
+
+    let config_path = utils::get_config_path(matches)?;
+    let mut config = crate::Config::from_file(&config_path)?;
+    let mut config_hash = calculate_file_hash(&config_path)?;
+    let mut inputs = Inputs::take(&config);
+
+    let mut app = utils::create_app(config.clone())?;
+    app.initialize().await?;
+
+    info!("Watching {:?}", dir);
+    let mut watcher = Watcher::new(
+        &dir,
+        &ignored_paths(&config, &config_path, &inputs),
+        interval,
+        debounce,
+    )?;
+    regenerate(&app, &config.sources, &dir).await;
+    println!("Watching {} for changes (Ctrl-C to stop)", dir.display());
+
+    loop {
+        let new_hash = tokio::select! {
+            changes = watcher.wait_for_changes() => {
+                let changes = changes?;
+                println!("\n{} files changed, regenerating", changes.len());
+                regenerate(&app, &config.sources, &dir).await;
+                continue;
+            }
+            hash = wait_for_config_change(
+                &config_path, &config_hash, &config, &inputs, interval, debounce,
+            ) => hash,
+            _ = tokio::signal::ctrl_c() => return Ok(()),
+        };
+        let mut config_changed = new_hash != config_hash;
+        config_hash = new_hash;
+
+        // An invalid configuration is reported and the running one kept, so a
+        // typo does not stop the watch; changed inputs are still applied
+        let (new_config, new_inputs, diff) = match reload_config(&config_path, &config, &inputs) {
+            Ok(reloaded) => reloaded,
+            Err(e) => {
+                println!(
+                    "\nConfiguration {} is invalid, keeping the running one: {}",
+                    config_path.display(),
+                    e
+                );
+                config_changed = false;
+                let new_inputs = Inputs::take(&config);
+                let diff = ConfigDiff {
+                    inputs: new_inputs.changes_since(&inputs),
+                    ..ConfigDiff::default()
+                };
+                (config.clone(), new_inputs, diff)
+            }
+        };
+        if diff.is_empty() {
+            if config_changed {
+                println!("\nConfiguration reloaded, nothing changed");
+            }
+            inputs = new_inputs;
+            continue;
+        }
+
+        let new_app = utils::create_app(new_config.clone())?;
+        if let Err(e) = new_app.initialize().await {
+            println!("\nConfiguration could not be applied, keeping the running one: {e}");
+            inputs = Inputs::take(&config);
+            continue;
+        }
+
+        match config_changed {
+            true => println!("\nConfiguration reloaded:"),
+            false => println!("\nInputs changed:"),
+        }
+        for line in diff.summary() {
+            println!("  {line}");
+        }
+        let names = diff.invalidated(&new_config);
+        let invalidated: Vec<Source> = new_config
+            .sources
+            .iter()
+            .filter(|source| names.contains(&source.name()))
+            .cloned()
+            .collect();
+        if invalidated.is_empty() {
+            println!("  No outputs invalidated");
+        } else {
+            println!("  Invalidated outputs:");
+            for source in &invalidated {
+                println!("    {} ({})", source.name(), source.output_path().display());
+            }
+        }
+
+        app = new_app;
+        config = new_config;
+        inputs = new_inputs;
+        watcher = Watcher::new(
+            &dir,
+            &ignored_paths(&config, &config_path, &inputs),
+            interval,
+            debounce,
+        )?;
+        regenerate(&app, &invalidated, &dir).await;
+    }
+}
+
+/// Paths of the checkout that changes must not trigger a regeneration for
+///
+/// Outputs inside the checkout are written by the regeneration itself, and
+/// the configuration file and the inputs of the sources are reloaded on
+/// their own.
+fn ignored_paths(config: &crate::Config, config_path: &Path, inputs: &Inputs) -> Vec<PathBuf> {
+    config
+        .sources
+        .iter()
+        .map(|source| source.output_path().to_path_buf())
+        .chain([config.output.base_path.clone(), config_path.to_path_buf()])
+        .chain(inputs.files().cloned())
+        .collect()
+}
+
+/// Wait until the content of the configuration file or the inputs of its
+/// sources change and have settled
+///
+/// Returns the content hash of the configuration file, which is unchanged
+/// when only inputs changed. A missing configuration file, as seen while an
+/// editor replaces it, is not a change.
+async fn wait_for_config_change(
+    path: &Path,
+    hash: &str,
+    config: &crate::Config,
+    inputs: &Inputs,
+    interval: Duration,
+    debounce: Duration,
+) -> String {
+    let read = || {
+        let current = calculate_file_hash(path).unwrap_or_else(|_| hash.to_string());
+        (current, Inputs::take(config))
+    };
+    loop {
+        tokio::time::sleep(interval).await;
+        let current = read();
+        if current.0 == hash && current.1 == *inputs {
+            continue;
+        }
+
+        tokio::time::sleep(debounce).await;
+        if read() == current {
+            return current.0;
+        }
+    }
+}
+
+/// Regenerate all sources from the checkout, reporting errors without stopping
+async fn regenerate(app: &crate::JsonnetGen, sources: &[Source], dir: &Path) {
+    for source in sources {
+        match app.process_source_at(source, dir).await {
+            Ok(result) => {
+                println!(
+                    "  {}: {} files generated",
+                    source.name(),
+                    result.files_generated
+                );
+                for error in &result.errors {
+                    println!("    Error: {error}");
+                }
+            }
+            Err(e) => {
+                warn!("Failed to regenerate source {}: {}", source.name(), e);
+                println!("  {}: failed: {}", source.name(), e);
+            }
+        }
+    }
+}
@@ -15,6 +15,21 @@ impl CliApp {
             .version(env!("CARGO_PKG_VERSION"))
             .about("Generate type-safe Jsonnet libraries from schema sources")
             .subcommand_negates_reqs(true)
+            .arg(
+                clap::Arg::new("log-format")
+                    .long("log-format")
+                    .help("Format of the log lines")
+                    .value_parser(["text", "json"])
+                    .default_value("text")
+                    .global(true),
+            )
+            .arg(
+                clap::Arg::new("profile")
+                    .long("profile")
+                    .value_name("FILE")
+                    .help("Write timings and allocation statistics of the run to FILE as JSON")
+                    .global(true),
+            )
             .subcommand(commands::init::command())
             .subcommand(commands::generate::command())
             .subcommand(commands::validate::command())
@@ -25,6 +40,24 @@ impl CliApp {
             .subcommand(commands::incremental::command())
             .subcommand(commands::plugins::command())
             .subcommand(commands::test::command())
+            .subcommand(commands::compat_test::command())
+            .subcommand(commands::doctor::command())
+            .subcommand(commands::watch::command())
+            .subcommand(commands::rename::command())
+            .subcommand(commands::repl::command())
+            .subcommand(commands::surface::command())
+            .subcommand(commands::migrate::command())
+            .subcommand(commands::natives::command())
+            .subcommand(commands::bundle::command())
+            .subcommand(commands::publish::command())
+            .subcommand(commands::pull::command())
+            .subcommand(commands::template::command())
+            .subcommand(commands::check::command())
+            .subcommand(commands::daemon::command())
+            .subcommand(commands::hermetic::command())
+            .subcommand(commands::fixtures::command())
+            .subcommand(commands::diff::command())
+            .subcommand(commands::breaking::command())
     }
 
     /// Run the CLI application
@@ -40,6 +73,24 @@ impl CliApp {
             Some(("incremental", sub_matches)) => commands::incremental::run(sub_matches).await,
             Some(("plugins", sub_matches)) => commands::plugins::run(sub_matches).await,
             Some(("test", sub_matches)) => commands::test::run(sub_matches).await,
+            Some(("compat-test", sub_matches)) => commands::compat_test::run(sub_matches).await,
+            Some(("doctor", sub_matches)) => commands::doctor::run(sub_matches).await,
+            Some(("watch", sub_matches)) => commands::watch::run(sub_matches).await,
+            Some(("rename", sub_matches)) => commands::rename::run(sub_matches).await,
+            Some(("repl", sub_matches)) => commands::repl::run(sub_matches).await,
+            Some(("surface", sub_matches)) => commands::surface::run(sub_matches).await,
+            Some(("migrate", sub_matches)) => commands::migrate::run(sub_matches).await,
+            Some(("natives", sub_matches)) => commands::natives::run(sub_matches).await,
+            Some(("bundle", sub_matches)) => commands::bundle::run(sub_matches).await,
+            Some(("publish", sub_matches)) => commands::publish::run(sub_matches).await,
+            Some(("pull", sub_matches)) => commands::pull::run(sub_matches).await,
+            Some(("template", sub_matches)) => commands::template::run(sub_matches).await,
+            Some(("check", sub_matches)) => commands::check::run(sub_matches).await,
+            Some(("daemon", sub_matches)) => commands::daemon::run(sub_matches).await,
+            Some(("hermetic", sub_matches)) => commands::hermetic::run(sub_matches).await,
+            Some(("fixtures", sub_matches)) => commands::fixtures::run(sub_matches).await,
+            Some(("diff", sub_matches)) => commands::diff::run(sub_matches).await,
+            Some(("breaking", sub_matches)) => commands::breaking::run(sub_matches).await,
             _ => {
                 // No subcommand provided, show help
                 let _ = Self::app().print_help();
@@ -0,0 +1,746 @@
+//! Backward-compatibility testing against recorded generator output
+//!
+//! A compatibility corpus is a directory of cases. Each case is a directory
+//! containing a `case.yaml` file with the source definition, an `input/`
+//! directory holding the source tree that was fed to the generator, and an
+//! `expected/` directory holding the output recorded by a previous release:
+//!
+//! ```text
+//! corpus/
+//!   user-types/
+//!     case.yaml
+//!     input/types.go
+//!     expected/user.libsonnet
+//! ```
+//!
+//! Outputs are compared semantically: changes to comments, whitespace and
+//! trailing commas are ignored, and differences in the generated function
+//! surface are classified as breaking changes or additions.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::{Config, JsonnetGen, Source};
+
+/// Name of the case definition file inside a case directory
+pub const CASE_FILE: &str = "case.yaml";
+
+/// Directory holding the source tree of a case
+pub const INPUT_DIR: &str = "input";
+
+/// Directory holding the recorded output of a case
+pub const EXPECTED_DIR: &str = "expected";
+
+/// A single compatibility case
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CompatCase {
+    /// Case name (the case directory name)
+    #[serde(skip)]
+    pub name: String,
+
+    /// Case directory
+    #[serde(skip)]
+    pub path: PathBuf,
+
+    /// Human readable description
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+
+    /// Version of gensonnet that recorded the expected output
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub recorded_with: Option<String>,
+
+    /// Source definition; the Git settings are ignored in favour of `input/`
+    pub source: Source,
+}
+
+impl CompatCase {
+    /// Load a case from its directory
+    pub fn load(path: &Path) -> Result<Self> {
+        let case_file = path.join(CASE_FILE);
+        let content = std::fs::read_to_string(&case_file)
+            .map_err(|e| anyhow!("Failed to read {}: {}", case_file.display(), e))?;
+        let mut case: CompatCase = serde_yaml::from_str(&content)
+            .map_err(|e| anyhow!("Invalid case file {}: {}", case_file.display(), e))?;
+
+        case.name = path
+            .file_name()
+            .map(|n| n.to_string_lossy().to_string())
+            .unwrap_or_default();
+        case.path = path.to_path_buf();
+
+        Ok(case)
+    }
+
+    /// Save the case definition back to its directory
+    pub fn save(&self) -> Result<()> {
+        let content = serde_yaml::to_string(self)?;
+        std::fs::write(self.path.join(CASE_FILE), content)?;
+        Ok(())
+    }
+
+    /// Get the input directory
+    pub fn input_dir(&self) -> PathBuf {
+        self.path.join(INPUT_DIR)
+    }
+
+    /// Get the expected output directory
+    pub fn expected_dir(&self) -> PathBuf {
+        self.path.join(EXPECTED_DIR)
+    }
+}
+
+/// Load all cases from a corpus directory, sorted by name
+pub fn load_corpus(corpus_dir: &Path) -> Result<Vec<CompatCase>> {
+    if !corpus_dir.is_dir() {
+        return Err(anyhow!(
+            "Compatibility corpus not found: {}",
+            corpus_dir.display()
+        ));
+    }
+
+    let mut cases = Vec::new();
+    for entry in std::fs::read_dir(corpus_dir)? {
+        let path = entry?.path();
+        if path.join(CASE_FILE).is_file() {
+            cases.push(CompatCase::load(&path)?);
+        }
+    }
+
+    cases.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(cases)
+}
+
+/// Kind of difference found between recorded and current output
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ChangeKind {
+    /// A recorded file is no longer generated
+    RemovedFile,
+
+    /// A new file is generated
+    AddedFile,
+
+    /// Functions were removed or their parameters changed
+    Breaking,
+
+    /// Only new functions were added
+    Addition,
+
+    /// The function surface is unchanged but the generated values differ
+    Changed,
+}
+
+impl ChangeKind {
+    /// Whether this change is a regression (as opposed to a compatible addition)
+    pub fn is_regression(&self) -> bool {
+        !matches!(self, ChangeKind::AddedFile | ChangeKind::Addition)
+    }
+}
+
+/// A difference in a single output file
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FileChange {
+    /// File path relative to the output directory
+    pub file: PathBuf,
+
+    /// Kind of change
+    pub kind: ChangeKind,
+
+    /// Details such as removed or added function signatures
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub details: Vec<String>,
+}
+
+/// Result of running a single case
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CaseReport {
+    /// Case name
+    pub name: String,
+
+    /// Version that recorded the expected output
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub recorded_with: Option<String>,
+
+    /// Number of recorded files compared
+    pub files_compared: usize,
+
+    /// Differences found
+    pub changes: Vec<FileChange>,
+
+    /// Error that prevented the case from running
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+impl CaseReport {
+    /// Whether the case passed, optionally treating additions as failures
+    pub fn passed(&self, strict: bool) -> bool {
+        self.error.is_none()
+            && self
+                .changes
+                .iter()
+                .all(|c| !(strict || c.kind.is_regression()))
+    }
+}
+
+/// Result of running a whole corpus
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct CompatReport {
+    /// Version of the binary under test
+    pub version: String,
+
+    /// Per-case results
+    pub cases: Vec<CaseReport>,
+}
+
+impl CompatReport {
+    /// Number of failing cases
+    pub fn failed(&self, strict: bool) -> usize {
+        self.cases.iter().filter(|c| !c.passed(strict)).count()
+    }
+}
+
+/// Runs compatibility cases with the current generator
+pub struct CompatRunner {
+    app: JsonnetGen,
+}
+
+impl CompatRunner {
+    /// Create a new runner with the built-in plugins loaded
+    pub async fn new() -> Result<Self> {
+        let app = JsonnetGen::new(Config::default())?;
+        app.initialize_plugins().await?;
+        Ok(Self { app })
+    }
+
+    /// Generate the output of a case into `output_dir`
+    pub async fn generate(&self, case: &CompatCase, output_dir: &Path) -> Result<()> {
+        let input_dir = case.input_dir();
+        if !input_dir.is_dir() {
+            return Err(anyhow!("Missing input directory: {}", input_dir.display()));
+        }
+
+        let mut source = case.source.clone();
+        source.set_output_path(output_dir.to_path_buf());
+
+        let result = self.app.process_source_at(&source, &input_dir).await?;
+        if !result.errors.is_empty() {
+            return Err(anyhow!("Generation failed: {}", result.errors.join("; ")));
+        }
+
+        Ok(())
+    }
+
+    /// Run a case and compare its output with the recorded output
+    pub async fn run_case(&self, case: &CompatCase) -> CaseReport {
+        let mut report = CaseReport {
+            name: case.name.clone(),
+            recorded_with: case.recorded_with.clone(),
+            files_compared: 0,
+            changes: Vec::new(),
+            error: None,
+        };
+
+        let result = async {
+            let output_dir = tempfile::tempdir()?;
+            self.generate(case, output_dir.path()).await?;
+
+            let expected = read_tree(&case.expected_dir())?;
+            let actual = read_tree(output_dir.path())?;
+            Ok::<_, anyhow::Error>((expected, actual))
+        }
+        .await;
+
+        match result {
+            Ok((expected, actual)) => {
+                report.files_compared = expected.len();
+                report.changes = compare_trees(&expected, &actual);
+            }
+            Err(e) => report.error = Some(e.to_string()),
+        }
+
+        report
+    }
+
+    /// Run all cases of a corpus
+    pub async fn run_corpus(&self, cases: &[CompatCase]) -> CompatReport {
+        let mut reports = Vec::new();
+        for case in cases {
+            reports.push(self.run_case(case).await);
+        }
+
+        CompatReport {
+            version: env!("CARGO_PKG_VERSION").to_string(),
+            cases: reports,
+        }
+    }
+
+    /// Record the current output of a case as its expected output
+    pub async fn record_case(&self, case: &mut CompatCase) -> Result<usize> {
+        let expected_dir = case.expected_dir();
+        if expected_dir.exists() {
+            std::fs::remove_dir_all(&expected_dir)?;
+        }
+        std::fs::create_dir_all(&expected_dir)?;
+
+        self.generate(case, &expected_dir).await?;
+
+        case.recorded_with = Some(env!("CARGO_PKG_VERSION").to_string());
+        case.save()?;
+
+        Ok(read_tree(&expected_dir)?.len())
+    }
+}
+
+/// Read all files below a directory, keyed by relative path
+///
+/// The header blocks of the files are left out, as they are not content.
+pub(crate) fn read_tree(dir: &Path) -> Result<BTreeMap<PathBuf, String>> {
+    let mut files = BTreeMap::new();
+    if !dir.exists() {
+        return Ok(files);
+    }
+
+    for entry in WalkDir::new(dir)
+        .into_iter()
+        .filter_map(|e| e.ok())
+        .filter(|e| e.file_type().is_file())
+    {
+        let relative = entry.path().strip_prefix(dir)?.to_path_buf();
+        let content = std::fs::read_to_string(entry.path())?;
+        files.insert(relative, crate::header::without_block(&content));
+    }
+
+    Ok(files)
+}
+
+/// Compare recorded and current output trees
+pub fn compare_trees(
+    expected: &BTreeMap<PathBuf, String>,
+    actual: &BTreeMap<PathBuf, String>,
+) -> Vec<FileChange> {
+    let mut changes = Vec::new();
+
+    for (file, expected_content) in expected {
+        match actual.get(file) {
+            None => changes.push(FileChange {
+                file: file.clone(),
+                kind: ChangeKind::RemovedFile,
+                details: Vec::new(),
+            }),
+            Some(actual_content) => {
+                if let Some(change) = compare_file(file, expected_content, actual_content) {
+                    changes.push(change);
+                }
+            }
+        }
+    }
+
+    for file in actual.keys().filter(|f| !expected.contains_key(*f)) {
+        changes.push(FileChange {
+            file: file.clone(),
+            kind: ChangeKind::AddedFile,
+            details: Vec::new(),
+        });
+    }
+
+    changes
+}
+
+/// Compare a single file, returning `None` when both versions are equivalent
+pub fn compare_file(file: &Path, expected: &str, actual: &str) -> Option<FileChange> {
+    let is_jsonnet = matches!(
+        file.extension().and_then(|e| e.to_str()),
+        Some("libsonnet") | Some("jsonnet") | Some("json")
+    );
+
+    if !is_jsonnet {
+        return (expected.trim_end() != actual.trim_end()).then(|| FileChange {
+            file: file.to_path_buf(),
+            kind: ChangeKind::Changed,
+            details: Vec::new(),
+        });
+    }
+
+    let expected_tokens = tokenize(expected);
+    let actual_tokens = tokenize(actual);
+    if expected_tokens == actual_tokens {
+        return None;
+    }
+
+    let expected_surface = function_surface(&expected_tokens);
+    let actual_surface = function_surface(&actual_tokens);
+
+    let mut details = Vec::new();
+    for (name, params) in &expected_surface {
+        match actual_surface.get(name) {
+            None => details.push(format!("removed {name}({params})")),
+            Some(actual_params) if actual_params != params => details.push(format!(
+                "changed {name}({params}) -> {name}({actual_params})"
+            )),
+            Some(_) => {}
+        }
+    }
+
+    let kind = if !details.is_empty() {
+        ChangeKind::Breaking
+    } else if actual_surface.len() > expected_surface.len() {
+        let added: BTreeSet<_> = actual_surface
+            .iter()
+            .filter(|(name, _)| !expected_surface.contains_key(*name))
+            .map(|(name, params)| format!("added {name}({params})"))
+            .collect();
+        details.extend(added);
+
+        // New functions are compatible only if everything else is untouched
+        if strip_functions(&expected_tokens, &expected_surface)
+            == strip_functions(&actual_tokens, &expected_surface)
+        {
+            ChangeKind::Addition
+        } else {
+            ChangeKind::Changed
+        }
+    } else {
+        ChangeKind::Changed
+    };
+
+    Some(FileChange {
+        file: file.to_path_buf(),
+        kind,
+        details,
+    })
+}
+
+/// Find the tokens of Jsonnet source, as byte ranges, skipping comments and whitespace
+///
+/// String literals and text blocks are single tokens, as are identifiers
+/// with their dotted fields.
+pub fn token_spans(code: &str) -> Vec<std::ops::Range<usize>> {
+    let chars: Vec<(usize, char)> = code.char_indices().collect();
+    let at = |i: usize| chars.get(i).map(|(_, c)| *c);
+    let offset = |i: usize| chars.get(i).map_or(code.len(), |(offset, _)| *offset);
+    let starts_block =
+        |i: usize| at(i) == Some('|') && at(i + 1) == Some('|') && at(i + 2) == Some('|');
+    let mut spans = Vec::new();
+    let mut i = 0;
+
+    while i < chars.len() {
+        let c = chars[i].1;
+        let start = i;
+
+        if c.is_whitespace() {
+            i += 1;
+            continue;
+        } else if c == '#' || (c == '/' && at(i + 1) == Some('/')) {
+            while i < chars.len() && at(i) != Some('\n') {
+                i += 1;
+            }
+            continue;
+        } else if c == '/' && at(i + 1) == Some('*') {
+            i += 2;
+            while i < chars.len() && !(at(i) == Some('*') && at(i + 1) == Some('/')) {
+                i += 1;
+            }
+            i = (i + 2).min(chars.len());
+            continue;
+        } else if starts_block(i) {
+            i += 3;
+            while i < chars.len() && !starts_block(i) {
+                i += 1;
+            }
+            i = (i + 3).min(chars.len());
+        } else if c == '"' || c == '\'' {
+            i += 1;
+            while i < chars.len() && at(i) != Some(c) {
+                if at(i) == Some('\\') {
+                    i += 1;
+                }
+                i += 1;
+            }
+            i = (i + 1).min(chars.len());
+        } else if c.is_alphanumeric() || c == '_' || c == '$' {
+            while at(i).is_some_and(|c| c.is_alphanumeric() || matches!(c, '_' | '$' | '.')) {
+                i += 1;
+            }
+        } else if c == ':' {
+            // Keep `:`, `::` and `:::` as single tokens
+            while at(i) == Some(':') {
+                i += 1;
+            }
+        } else {
+            i += 1;
+        }
+        spans.push(offset(start)..offset(i));
+    }
+
+    spans
+}
+
+/// Split Jsonnet source into tokens, dropping comments, whitespace and trailing commas
+pub fn tokenize(code: &str) -> Vec<String> {
+    let tokens: Vec<&str> = token_spans(code)
+        .into_iter()
+        .map(|span| &code[span])
+        .collect();
+
+    // Trailing commas are insignificant
+    let mut result: Vec<String> = Vec::with_capacity(tokens.len());
+    for (idx, token) in tokens.iter().enumerate() {
+        let next = tokens.get(idx + 1).copied();
+        if *token == "," && matches!(next, Some("}") | Some("]") | Some(")") | None) {
+            continue;
+        }
+        result.push(token.to_string());
+    }
+
+    result
+}
+
+/// Extract `name(params)::` function definitions from a token stream
+pub fn function_surface(tokens: &[String]) -> BTreeMap<String, String> {
+    let mut surface = BTreeMap::new();
+
+    for (idx, token) in tokens.iter().enumerate() {
+        if tokens.get(idx + 1).map(|t| t.as_str()) != Some("(") || !is_name(token) {
+            continue;
+        }
+
+        if let Some(close) = matching_paren(tokens, idx + 1) {
+            let is_field = tokens.get(close + 1).is_some_and(|t| t.starts_with(':'));
+            if is_field {
+                let params = tokens[idx + 2..close].join(" ").replace(" ,", ",");
+                surface.insert(token.clone(), params);
+            }
+        }
+    }
+
+    surface
+}
+
+/// Extract `name(params)::` function definitions from a token stream, keyed
+/// by their path through the object fields holding them (`spec.withImage`)
+pub fn function_paths(tokens: &[String]) -> BTreeMap<String, String> {
+    let mut surface = BTreeMap::new();
+    let mut fields: Vec<Option<&str>> = Vec::new();
+
+    for (idx, token) in tokens.iter().enumerate() {
+        match token.as_str() {
+            "{" => fields.push(object_field(tokens, idx)),
+            "[" | "(" => fields.push(None),
+            "}" | "]" | ")" => {
+                fields.pop();
+            }
+            _ => {}
+        }
+        if tokens.get(idx + 1).map(|t| t.as_str()) != Some("(") || !is_name(token) {
+            continue;
+        }
+
+        if let Some(close) = matching_paren(tokens, idx + 1) {
+            if tokens.get(close + 1).is_some_and(|t| t.starts_with(':')) {
+                let params = tokens[idx + 2..close].join(" ").replace(" ,", ",");
+                let path: Vec<&str> = fields
+                    .iter()
+                    .flatten()
+                    .copied()
+                    .chain([token.as_str()])
+                    .collect();
+                surface.insert(path.join("."), params);
+            }
+        }
+    }
+
+    surface
+}
+
+/// Get the name of the field whose value is the object opened at `open`,
+/// as in `spec:: {` or `spec+: {`
+fn object_field(tokens: &[String], open: usize) -> Option<&str> {
+    let mut idx = open.checked_sub(1)?;
+    if !tokens[idx].starts_with(':') {
+        return None;
+    }
+    idx = idx.checked_sub(1)?;
+    if tokens[idx] == "+" {
+        idx = idx.checked_sub(1)?;
+    }
+    Some(tokens[idx].as_str()).filter(|name| is_name(name))
+}
+
+/// Remove the definitions of the given functions from a token stream
+fn strip_functions(tokens: &[String], keep: &BTreeMap<String, String>) -> Vec<String> {
+    let mut result = Vec::new();
+    let mut idx = 0;
+
+    while idx < tokens.len() {
+        let token = &tokens[idx];
+        let is_def = tokens.get(idx + 1).map(|t| t.as_str()) == Some("(")
+            && is_name(token)
+            && !keep.contains_key(token);
+
+        if is_def {
+            if let Some(close) = matching_paren(tokens, idx + 1) {
+                if tokens.get(close + 1).is_some_and(|t| t.starts_with(':')) {
+                    // Skip to the next top-level separator of this field
+                    let mut depth = 0i32;
+                    let mut end = close + 2;
+                    while end < tokens.len() {
+                        match tokens[end].as_str() {
+                            "{" | "[" | "(" => depth += 1,
+                            "}" | "]" | ")" if depth == 0 => break,
+                            "}" | "]" | ")" => depth -= 1,
+                            "," if depth == 0 => {
+                                end += 1;
+                                break;
+                            }
+                            _ => {}
+                        }
+                        end += 1;
+                    }
+                    idx = end;
+                    continue;
+                }
+            }
+        }
+
+        result.push(token.clone());
+        idx += 1;
+    }
+
+    // Removing the last field can leave a trailing comma behind
+    if let Some(pos) = result.iter().rposition(|t| t == ",") {
+        if matches!(result.get(pos + 1).map(|t| t.as_str()), Some("}") | None) {
+            result.remove(pos);
+        }
+    }
+
+    result
+}
+
+/// Find the index of the parenthesis closing the one at `open`
+fn matching_paren(tokens: &[String], open: usize) -> Option<usize> {
+    let mut depth = 0;
+    for (idx, token) in tokens.iter().enumerate().skip(open) {
+        match token.as_str() {
+            "(" => depth += 1,
+            ")" => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(idx);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// Whether a token is a plain identifier
+fn is_name(token: &str) -> bool {
+    token
+        .chars()
+        .next()
+        .is_some_and(|c| c.is_alphabetic() || c == '_')
+        && token.chars().all(|c| c.is_alphanumeric() || c == '_')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const RECORDED: &str = r#"// Generated from Go AST: User
+// Source: types.go
+
+{
+  // Create a new User
+  new():: self + {
+    active: true,
+  },
+
+  // Set the name field
+  withName(name):: self + { name: name },
+}
+"#;
+
+    #[test]
+    fn test_function_paths() {
+        let code = "{ new(name):: self + { name: name }, spec:: { withImage(image):: { spec+: { image: image } }, validate(obj):: obj }, validate(obj):: obj }";
+        let paths = function_paths(&tokenize(code));
+        assert_eq!(
+            paths.keys().collect::<Vec<_>>(),
+            vec!["new", "spec.validate", "spec.withImage", "validate"]
+        );
+        assert_eq!(paths["spec.withImage"], "image");
+    }
+
+    #[test]
+    fn test_tokenize() {
+        let code = "{ a:: 'x\\'y', // c\n b: |||\n  import 'z'\n|||, c: [1,], }";
+        assert_eq!(
+            tokenize(code),
+            vec![
+                "{",
+                "a",
+                "::",
+                "'x\\'y'",
+                ",",
+                "b",
+                ":",
+                "|||\n  import 'z'\n|||",
+                ",",
+                "c",
+                ":",
+                "[",
+                "1",
+                "]",
+                "}"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_comment_and_whitespace_changes_are_ignored() {
+        let current = "// Generated by a newer release\n{ new():: self + { active: true }, withName(name):: self + { name: name } }";
+        assert!(compare_file(Path::new("user.libsonnet"), RECORDED, current).is_none());
+    }
+
+    #[test]
+    fn test_removed_setter_is_breaking() {
+        let current = "{ new():: self + { active: true } }";
+        let change = compare_file(Path::new("user.libsonnet"), RECORDED, current).unwrap();
+        assert_eq!(change.kind, ChangeKind::Breaking);
+        assert_eq!(change.details, vec!["removed withName(name)".to_string()]);
+    }
+
+    #[test]
+    fn test_new_setter_is_addition() {
+        let current = "{ new():: self + { active: true }, withName(name):: self + { name: name }, withId(id):: self + { id: id } }";
+        let change = compare_file(Path::new("user.libsonnet"), RECORDED, current).unwrap();
+        assert_eq!(change.kind, ChangeKind::Addition);
+        assert!(!change.kind.is_regression());
+    }
+
+    #[test]
+    fn test_changed_default_is_regression() {
+        let current =
+            "{ new():: self + { active: false }, withName(name):: self + { name: name } }";
+        let change = compare_file(Path::new("user.libsonnet"), RECORDED, current).unwrap();
+        assert_eq!(change.kind, ChangeKind::Changed);
+        assert!(change.kind.is_regression());
+    }
+
+    #[test]
+    fn test_compare_trees_reports_missing_and_new_files() {
+        let expected = BTreeMap::from([(PathBuf::from("a.libsonnet"), "{}".to_string())]);
+        let actual = BTreeMap::from([(PathBuf::from("b.libsonnet"), "{}".to_string())]);
+
+        let changes = compare_trees(&expected, &actual);
+        assert_eq!(changes.len(), 2);
+        assert_eq!(changes[0].kind, ChangeKind::RemovedFile);
+        assert_eq!(changes[1].kind, ChangeKind::AddedFile);
+    }
+}
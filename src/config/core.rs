@@ -5,6 +5,7 @@ use serde::{Deserialize, Serialize};
 use std::path::PathBuf;
 
 use super::{GenerationConfig, PluginConfig, Source};
+use crate::namespace::Namespace;
 use jsonnet_generator::config::OutputConfig;
 
 /// Main configuration structure
@@ -24,6 +25,10 @@ pub struct Config {
 
     /// Plugin configuration
     pub plugins: PluginConfig,
+
+    /// Libraries exporting the types of several Go AST sources
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub namespaces: Vec<Namespace>,
 }
 
 impl Config {
@@ -63,9 +68,15 @@ impl Config {
         // Validate output configuration
         self.output.validate()?;
 
+        // Validate generation configuration
+        self.generation.validate()?;
+
+        for namespace in &self.namespaces {
+            namespace.validate(&self.sources)?;
+        }
+
         Ok(())
     }
-
 }
 
 impl Default for Config {
@@ -76,6 +87,7 @@ impl Default for Config {
             output: OutputConfig::default(),
             generation: GenerationConfig::default(),
             plugins: PluginConfig::default(),
+            namespaces: Vec::new(),
         }
     }
 }
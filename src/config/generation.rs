@@ -1,7 +1,14 @@
 //! Generation configuration and merge strategies
 
-use anyhow::Result;
+use anyhow::{anyhow, Result};
 use serde::{Deserialize, Serialize};
+use std::path::PathBuf;
+use std::str::FromStr;
+
+use crate::diagnostics::Diagnostics;
+use crate::goembed::GoEmbed;
+use crate::hooks::Hooks;
+use crate::quota::Quotas;
 
 /// Generation configuration
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -11,12 +18,128 @@ pub struct GenerationConfig {
 
     /// Deep merge strategy
     pub deep_merge_strategy: MergeStrategy,
+
+    /// Formatter applied to emitted Jsonnet files
+    #[serde(default)]
+    pub formatter: Formatter,
+
+    /// Path of the `jsonnetfmt` binary, looked up in PATH by default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub formatter_command: Option<PathBuf>,
+
+    /// Formats written next to the Jsonnet libraries of Go AST sources
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub output_formats: Vec<OutputFormat>,
+
+    /// Oldest `jsonnet` release the output must evaluate on
+    ///
+    /// Standard library functions added later are replaced by polyfills.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub jsonnet_version: Option<String>,
+
+    /// Whether to write a `jsonnetfile.json` to each output directory, so it
+    /// can be installed with `jb install`
+    #[serde(default)]
+    pub jsonnetfile: bool,
+
+    /// Whether to add the changes of the types to the `CHANGELOG.md` of each
+    /// output directory on regeneration
+    #[serde(default)]
+    pub changelog: bool,
+
+    /// Git reference of the output to compare with for the changelog,
+    /// instead of the output being replaced
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub changelog_since: Option<String>,
+
+    /// Go package embedding the Jsonnet files of each output directory, as
+    /// an `embed.go` next to them
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub go_embed: Option<GoEmbed>,
+
+    /// Whether to write a `gensonnet.manifest.json` with the hashes of the
+    /// inputs and outputs to each output directory, for `gensonnet check`
+    #[serde(default)]
+    pub manifest: bool,
+
+    /// Whether the header of generated files records the time of the run
+    ///
+    /// `gensonnet check` ignores it, but every run rewrites every file.
+    #[serde(default)]
+    pub header_timestamp: bool,
+
+    /// Text put at the top of every generated file that has comments, such
+    /// as a license or a "do not edit" notice
+    ///
+    /// `gensonnet check` and plans ignore it, as they do the timestamp.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub header_block: Option<String>,
+
+    /// Whether to evaluate the generated files after writing them
+    #[serde(default)]
+    pub verify: bool,
+
+    /// Path of the `jsonnet` binary used by `verify`, looked up in PATH by default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub jsonnet_command: Option<PathBuf>,
+
+    /// Limits on the processing of each source, applied within the quotas
+    /// of the operator (see `quota::Quotas::within`)
+    #[serde(default, skip_serializing_if = "Quotas::is_empty")]
+    pub quotas: Quotas,
+
+    /// Report of the Go constructs that could not be represented, as JSON or
+    /// as CSV for a `.csv` path
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub unsupported_report: Option<PathBuf>,
+
+    /// Machine-readable JSON report of the types processed, fields skipped,
+    /// untranslated tags and warnings of each source
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub report: Option<PathBuf>,
+
+    /// Commands run before and after generation
+    #[serde(default, skip_serializing_if = "Hooks::is_empty")]
+    pub hooks: Hooks,
+
+    /// Whether to keep the dependency graph of the types of Go AST sources,
+    /// so that later runs only parse the changed files and only regenerate
+    /// the types they affect
+    #[serde(default)]
+    pub dependency_graph: bool,
+
+    /// Number of workers parsing Go files, the number of CPUs by default
+    ///
+    /// The output does not depend on it.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub jobs: Option<usize>,
+
+    /// Levels of the warnings of each code, and whether warnings are errors
+    #[serde(default, skip_serializing_if = "Diagnostics::is_empty")]
+    pub diagnostics: Diagnostics,
 }
 
 impl GenerationConfig {
     pub fn validate(&self) -> Result<()> {
+        if let Some(version) = &self.jsonnet_version {
+            version.parse::<crate::dialect::Version>()?;
+        }
+        self.diagnostics.validate()?;
+        if let Some(go_embed) = &self.go_embed {
+            go_embed.validate()?;
+        }
+        if self.jobs == Some(0) {
+            return Err(anyhow!("generation.jobs must be at least 1"));
+        }
         Ok(())
     }
+
+    /// Number of workers parsing Go files
+    pub fn jobs(&self) -> usize {
+        self.jobs.unwrap_or_else(|| {
+            std::thread::available_parallelism().map_or(1, std::num::NonZeroUsize::get)
+        })
+    }
 }
 
 impl Default for GenerationConfig {
@@ -24,6 +147,26 @@ impl Default for GenerationConfig {
         Self {
             fail_fast: false,
             deep_merge_strategy: MergeStrategy::Default,
+            formatter: Formatter::default(),
+            formatter_command: None,
+            output_formats: Vec::new(),
+            jsonnet_version: None,
+            jsonnetfile: false,
+            changelog: false,
+            changelog_since: None,
+            go_embed: None,
+            manifest: false,
+            header_timestamp: false,
+            header_block: None,
+            verify: false,
+            jsonnet_command: None,
+            quotas: Quotas::default(),
+            unsupported_report: None,
+            report: None,
+            hooks: Hooks::default(),
+            dependency_graph: false,
+            jobs: None,
+            diagnostics: Diagnostics::default(),
         }
     }
 }
@@ -41,3 +184,66 @@ pub enum MergeStrategy {
     /// Append strategy (add to existing arrays)
     Append,
 }
+
+/// Formatter for emitted Jsonnet files
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Formatter {
+    /// Write files as generated
+    #[default]
+    None,
+
+    /// Built-in formatter applying the `jsonnetfmt` default style
+    Builtin,
+
+    /// External `jsonnetfmt` binary
+    Jsonnetfmt,
+}
+
+impl FromStr for Formatter {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "none" => Ok(Formatter::None),
+            "builtin" => Ok(Formatter::Builtin),
+            "jsonnetfmt" => Ok(Formatter::Jsonnetfmt),
+            other => Err(anyhow!(
+                "Unknown formatter '{}' (expected none, builtin or jsonnetfmt)",
+                other
+            )),
+        }
+    }
+}
+
+/// Format of the schemas written next to the Jsonnet libraries
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum OutputFormat {
+    /// Draft 2020-12 JSON Schema of each type
+    #[serde(rename = "jsonschema")]
+    JsonSchema,
+
+    /// CUE definition of each type
+    Cue,
+
+    /// TypeScript declarations of the types
+    #[serde(rename = "typescript")]
+    TypeScript,
+}
+
+impl FromStr for OutputFormat {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "jsonschema" => Ok(OutputFormat::JsonSchema),
+            "cue" => Ok(OutputFormat::Cue),
+            "typescript" => Ok(OutputFormat::TypeScript),
+            other => Err(anyhow!(
+                "Unknown output format '{}' (expected jsonschema, cue or typescript)",
+                other
+            )),
+        }
+    }
+}
@@ -10,6 +10,6 @@ mod tests;
 
 // Re-export main types for convenience
 pub use core::Config;
-pub use generation::{GenerationConfig, MergeStrategy};
+pub use generation::{Formatter, GenerationConfig, MergeStrategy, OutputFormat};
 pub use plugins::{PluginConfig, PluginValidationConfig};
 pub use source::*;
@@ -2,9 +2,14 @@
 
 use anyhow::{anyhow, Result};
 use serde::{Deserialize, Serialize};
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
+
+use crate::plugin::ast::GoAstOptions;
 
 /// Source types that can be processed
+// Sources are few and read once from configuration, so the size of the Go
+// AST options does not matter
+#[allow(clippy::large_enum_variant)]
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(tag = "type", rename_all = "snake_case")]
 pub enum Source {
@@ -16,6 +21,12 @@ pub enum Source {
 
     /// OpenAPI specification source for processing OpenAPI/Swagger files
     OpenApi(OpenApiSource),
+
+    /// Terraform provider schemas from `terraform providers schema -json`
+    Terraform(TerraformSource),
+
+    /// GraphQL schema (SDL) files
+    Graphql(GraphqlSource),
 }
 
 impl Source {
@@ -25,6 +36,8 @@ impl Source {
             Source::Crd(crd) => &crd.name,
             Source::GoAst(go_ast) => &go_ast.name,
             Source::OpenApi(openapi) => &openapi.name,
+            Source::Terraform(terraform) => &terraform.name,
+            Source::Graphql(graphql) => &graphql.name,
         }
     }
 
@@ -34,6 +47,8 @@ impl Source {
             Source::Crd(crd) => crd.validate(),
             Source::GoAst(go_ast) => go_ast.validate(),
             Source::OpenApi(openapi) => openapi.validate(),
+            Source::Terraform(terraform) => terraform.validate(),
+            Source::Graphql(graphql) => graphql.validate(),
         }
     }
 }
@@ -91,8 +106,11 @@ impl GitSource {
             return Err(anyhow!("Git URL cannot be empty"));
         }
 
-        // Basic URL validation
-        if !self.url.starts_with("http") && !self.url.starts_with("git@") {
+        // Basic URL validation; file:// names a local repository, such as a mirror
+        if !self.url.starts_with("http")
+            && !self.url.starts_with("git@")
+            && !self.url.starts_with("file://")
+        {
             return Err(anyhow!("Invalid Git URL format: {}", self.url));
         }
 
@@ -158,9 +176,90 @@ pub struct GoAstSource {
 
     /// Package filters (optional, for specific packages)
     pub package_filters: Option<Vec<String>>,
+
+    /// Schema extraction options (type mappings, ...)
+    #[serde(default)]
+    pub options: GoAstOptions,
 }
 
 impl GoAstSource {
+    /// Create a source from a remote repository address
+    ///
+    /// The address is `git+<url>[//<dir>][?ref=<ref>&name=<name>]`, as in
+    /// `git+https://github.com/acme/operator//api/v1?ref=v0.14.2`. The Go files
+    /// below `dir` are included, tests excluded. Without `name`, the source is
+    /// named after the repository and the directory, and written to that
+    /// directory of `output_base`.
+    pub fn from_url(address: &str, output_base: &Path) -> Result<Self> {
+        let rest = address.strip_prefix("git+").ok_or_else(|| {
+            anyhow!(
+                "Invalid source '{}': expected git+https://host/repo[//dir][?ref=REF]",
+                address
+            )
+        })?;
+        let (location, query) = rest.split_once('?').unwrap_or((rest, ""));
+
+        // `//` after the scheme separates the repository from the directory
+        let scheme_end = location.find("://").map_or(0, |idx| idx + 3);
+        let (url, dir) = match location[scheme_end..].find("//") {
+            Some(idx) => (
+                &location[..scheme_end + idx],
+                location[scheme_end + idx + 2..].trim_matches('/'),
+            ),
+            None => (location, ""),
+        };
+
+        let mut ref_name = None;
+        let mut name = None;
+        for pair in query.split('&').filter(|pair| !pair.is_empty()) {
+            match pair.split_once('=') {
+                Some(("ref", value)) => ref_name = Some(value.to_string()),
+                Some(("name", value)) => name = Some(value.to_string()),
+                _ => {
+                    return Err(anyhow!(
+                        "Invalid source '{}': unknown parameter '{}' (expected ref or name)",
+                        address,
+                        pair
+                    ))
+                }
+            }
+        }
+
+        let name = name.unwrap_or_else(|| {
+            let repository = url
+                .trim_end_matches('/')
+                .trim_end_matches(".git")
+                .rsplit('/')
+                .next()
+                .unwrap_or(url);
+            std::iter::once(repository)
+                .chain(dir.split('/').filter(|segment| !segment.is_empty()))
+                .collect::<Vec<_>>()
+                .join("-")
+        });
+        let include = if dir.is_empty() {
+            "**/*.go".to_string()
+        } else {
+            format!("{dir}/**/*.go")
+        };
+
+        let source = Self {
+            output_path: output_base.join(&name),
+            name,
+            git: GitSource {
+                url: url.to_string(),
+                ref_name,
+                auth: None,
+            },
+            include_patterns: vec![include],
+            exclude_patterns: vec!["**/*_test.go".to_string(), "vendor/**".to_string()],
+            package_filters: None,
+            options: GoAstOptions::default(),
+        };
+        source.git.validate()?;
+        Ok(source)
+    }
+
     pub fn validate(&self) -> Result<()> {
         if self.name.is_empty() {
             return Err(anyhow!("Go AST source name cannot be empty"));
@@ -178,6 +277,71 @@ impl GoAstSource {
             ));
         }
 
+        self.options.validate_patterns()?;
+
+        for (field, field_override) in &self.options.field_overrides {
+            if field_override.schema.is_some() && field_override.go_type.is_some() {
+                return Err(anyhow!(
+                    "field_overrides[\"{}\"] sets both schema and go_type",
+                    field
+                ));
+            }
+        }
+
+        if let Some(obfuscate) = &self.options.obfuscate {
+            let output_path = normalize_path(&obfuscate.output_path);
+            if output_path == normalize_path(&self.output_path) {
+                return Err(anyhow!(
+                    "Obfuscated output path must differ from the Go AST output path"
+                ));
+            }
+            if normalize_path(&obfuscate.mapping_file).starts_with(&output_path) {
+                return Err(anyhow!(
+                    "Obfuscation mapping file must not be written into the distributed output"
+                ));
+            }
+            if obfuscate.salt.is_empty() {
+                return Err(anyhow!("Obfuscation salt cannot be empty"));
+            }
+        }
+
+        if let Some(imports) = &self.options.imports {
+            imports.validate()?;
+        }
+
+        if self.options.go_tests && self.options.go_checkout.is_none() {
+            return Err(anyhow!(
+                "go_tests needs go_checkout, the local checkout the Go tests are written to"
+            ));
+        }
+
+        if self.options.streaming {
+            let whole_source = [
+                ("obfuscate", self.options.obfuscate.is_some()),
+                ("helm_values", self.options.helm_values.is_some()),
+                ("fetch_dependencies", self.options.fetch_dependencies),
+                ("roots", !self.options.roots.is_empty()),
+                ("templates", !self.options.templates.is_empty()),
+            ];
+            if let Some((option, _)) = whole_source.iter().find(|(_, set)| *set) {
+                return Err(anyhow!(
+                    "The {} option needs every type of the source and cannot be combined with streaming",
+                    option
+                ));
+            }
+        }
+
+        for output in &self.options.package_outputs {
+            if output.packages.is_empty() {
+                return Err(anyhow!("Package output must list at least one package"));
+            }
+            if output.output_path == self.output_path {
+                return Err(anyhow!(
+                    "Package output path must differ from the Go AST output path"
+                ));
+            }
+        }
+
         Ok(())
     }
 }
@@ -242,6 +406,120 @@ impl OpenApiSource {
     }
 }
 
+/// Terraform provider schema source configuration
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TerraformSource {
+    /// Name of the source
+    pub name: String,
+
+    /// Git repository configuration
+    pub git: GitSource,
+
+    /// Provider schema files to include (e.g., ["schemas/*.json"])
+    pub include_patterns: Vec<String>,
+
+    /// Files to exclude
+    #[serde(default)]
+    pub exclude_patterns: Vec<String>,
+
+    /// Output path for generated files
+    pub output_path: PathBuf,
+
+    /// Providers to generate, by source address or name (e.g.,
+    /// ["hashicorp/aws"]); all providers of the schemas when empty
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub providers: Vec<String>,
+}
+
+impl TerraformSource {
+    pub fn validate(&self) -> Result<()> {
+        if self.name.is_empty() {
+            return Err(anyhow!("Terraform source name cannot be empty"));
+        }
+
+        self.git.validate()?;
+
+        if self.output_path.to_string_lossy().is_empty() {
+            return Err(anyhow!("Terraform output path cannot be empty"));
+        }
+
+        if self.include_patterns.is_empty() {
+            return Err(anyhow!(
+                "Terraform source must have at least one include pattern"
+            ));
+        }
+
+        Ok(())
+    }
+}
+
+/// GraphQL schema source configuration
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct GraphqlSource {
+    /// Name of the source, which also names the schema in the index
+    pub name: String,
+
+    /// Git repository configuration
+    pub git: GitSource,
+
+    /// SDL files of the schema (e.g., ["schema/**/*.graphql"])
+    pub include_patterns: Vec<String>,
+
+    /// Files to exclude
+    #[serde(default)]
+    pub exclude_patterns: Vec<String>,
+
+    /// Output path for generated files
+    pub output_path: PathBuf,
+}
+
+impl GraphqlSource {
+    pub fn validate(&self) -> Result<()> {
+        if self.name.is_empty() {
+            return Err(anyhow!("GraphQL source name cannot be empty"));
+        }
+
+        self.git.validate()?;
+
+        if self.output_path.to_string_lossy().is_empty() {
+            return Err(anyhow!("GraphQL output path cannot be empty"));
+        }
+
+        if self.include_patterns.is_empty() {
+            return Err(anyhow!(
+                "GraphQL source must have at least one include pattern"
+            ));
+        }
+
+        Ok(())
+    }
+}
+
+/// Normalize a path lexically, so `./dist/x` and `out/../dist/x` are both
+/// within `dist`
+///
+/// The paths of a configuration need not exist yet, so they are not
+/// canonicalized; `..` past the start of a relative path is kept.
+fn normalize_path(path: &Path) -> PathBuf {
+    use std::path::Component;
+
+    let mut normalized = PathBuf::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir => match normalized.components().next_back() {
+                Some(Component::Normal(_)) => {
+                    normalized.pop();
+                }
+                Some(Component::RootDir | Component::Prefix(_)) => {}
+                _ => normalized.push(".."),
+            },
+            component => normalized.push(component),
+        }
+    }
+    normalized
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -285,6 +563,109 @@ mod tests {
         assert_eq!(git.ref_name(), "main");
     }
 
+    #[test]
+    fn test_go_ast_obfuscate_validation() {
+        let mut source = GoAstSource {
+            name: "test".to_string(),
+            git: GitSource {
+                url: "https://github.com/test/repo.git".to_string(),
+                ref_name: None,
+                auth: None,
+            },
+            include_patterns: vec!["**/*.go".to_string()],
+            exclude_patterns: vec![],
+            output_path: PathBuf::from("./output"),
+            package_filters: None,
+            options: GoAstOptions::default(),
+        };
+        source.options.obfuscate = Some(crate::plugin::ast::ObfuscateOptions {
+            output_path: PathBuf::from("./dist"),
+            mapping_file: PathBuf::from("./private/names.json"),
+            salt: "secret".to_string(),
+        });
+        assert!(source.validate().is_ok());
+
+        if let Some(obfuscate) = source.options.obfuscate.as_mut() {
+            obfuscate.mapping_file = PathBuf::from("./dist/names.json");
+        }
+        assert!(source.validate().is_err());
+
+        // Paths are compared however they are written
+        for (output_path, mapping_file) in [
+            ("dist", "./dist/names.json"),
+            ("./dist/", "dist/names.json"),
+            ("dist", "private/../dist/names.json"),
+        ] {
+            if let Some(obfuscate) = source.options.obfuscate.as_mut() {
+                obfuscate.output_path = PathBuf::from(output_path);
+                obfuscate.mapping_file = PathBuf::from(mapping_file);
+            }
+            assert!(
+                source.validate().is_err(),
+                "{mapping_file} in {output_path}"
+            );
+        }
+        if let Some(obfuscate) = source.options.obfuscate.as_mut() {
+            obfuscate.output_path = PathBuf::from("output/");
+            obfuscate.mapping_file = PathBuf::from("names.json");
+        }
+        assert!(source.validate().is_err());
+    }
+
+    #[test]
+    fn test_normalize_path() {
+        assert_eq!(normalize_path(Path::new("./dist/x")), Path::new("dist/x"));
+        assert_eq!(normalize_path(Path::new("a/../b/./c/")), Path::new("b/c"));
+        assert_eq!(normalize_path(Path::new("../a/..")), Path::new(".."));
+        assert_eq!(normalize_path(Path::new("/../a")), Path::new("/a"));
+    }
+
+    #[test]
+    fn test_go_ast_go_tests_validation() {
+        let mut source =
+            GoAstSource::from_url("git+https://github.com/acme/operator", Path::new("out"))
+                .unwrap();
+        source.options.go_tests = true;
+        assert!(source.validate().is_err());
+
+        source.options.go_checkout = Some(PathBuf::from("../operator"));
+        assert!(source.validate().is_ok());
+    }
+
+    #[test]
+    fn test_go_ast_source_from_url() {
+        let source = GoAstSource::from_url(
+            "git+https://github.com/acme/operator//api/v1?ref=v0.14.2",
+            Path::new("./generated"),
+        )
+        .unwrap();
+        assert_eq!(source.name, "operator-api-v1");
+        assert_eq!(source.git.url, "https://github.com/acme/operator");
+        assert_eq!(source.git.ref_name(), "v0.14.2");
+        assert_eq!(source.include_patterns, vec!["api/v1/**/*.go"]);
+        assert_eq!(
+            source.output_path,
+            PathBuf::from("./generated/operator-api-v1")
+        );
+
+        let source = GoAstSource::from_url(
+            "git+https://github.com/acme/operator.git?name=operator",
+            Path::new("out"),
+        )
+        .unwrap();
+        assert_eq!(source.git.url, "https://github.com/acme/operator.git");
+        assert_eq!(source.git.ref_name, None);
+        assert_eq!(source.include_patterns, vec!["**/*.go"]);
+        assert_eq!(source.output_path, PathBuf::from("out/operator"));
+
+        assert!(GoAstSource::from_url("https://github.com/acme/operator", Path::new(".")).is_err());
+        assert!(GoAstSource::from_url(
+            "git+https://github.com/acme/operator?branch=main",
+            Path::new(".")
+        )
+        .is_err());
+    }
+
     #[test]
     fn test_invalid_git_url() {
         let invalid_git = GitSource {
@@ -0,0 +1,591 @@
+//! Long-running JSON-RPC server
+//!
+//! `gensonnet daemon` keeps the configuration, the plugins and the parsed
+//! types of each source in memory, so editors and build systems can ask for
+//! the schema of a type or regenerate a source without a cold start per
+//! request. Requests are JSON-RPC 2.0, one JSON object per line of stdin,
+//! answered on stdout:
+//!
+//! ```text
+//! {"jsonrpc":"2.0","id":1,"method":"schema","params":{"source":"api","type":"User"}}
+//! {"jsonrpc":"2.0","id":1,"result":{"name":"User","schema_type":"go_struct",...}}
+//! ```
+//!
+//! The types of a source are parsed on the first query and kept until the
+//! source is regenerated or `invalidate` is called, e.g. when an editor
+//! saves a Go file.
+//!
+//! Before each request, the configuration file and the inputs of its sources
+//! written by hand are checked for changes, as in watch mode. A new
+//! configuration replaces the running one and drops the types of the sources
+//! it invalidates; a `config_reloaded` notification tells what changed:
+//!
+//! ```text
+//! {"jsonrpc":"2.0","method":"config_reloaded","params":{"changes":["source api: changed options.type_overrides"],"invalidated":["api"]}}
+//! ```
+
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use tokio::io::{AsyncBufReadExt, AsyncWriteExt, BufReader};
+use tracing::{info, warn};
+
+use crate::plugin::ExtractedSchema;
+use crate::quota::QuotaExceeded;
+use crate::utils::calculate_file_hash;
+use crate::watch::{reload_config, Inputs};
+use crate::{JsonnetGen, Source};
+
+/// Invalid JSON was received
+pub const PARSE_ERROR: i64 = -32700;
+
+/// The JSON is not a request
+pub const INVALID_REQUEST: i64 = -32600;
+
+/// The method does not exist
+pub const METHOD_NOT_FOUND: i64 = -32601;
+
+/// The parameters of the method are invalid
+pub const INVALID_PARAMS: i64 = -32602;
+
+/// The method failed
+pub const SERVER_ERROR: i64 = -32000;
+
+/// A source went over a quota of the installation
+pub const QUOTA_EXCEEDED: i64 = -32001;
+
+/// A JSON-RPC request, or a notification when it has no `id`
+#[derive(Debug, Clone, Deserialize)]
+pub struct Request {
+    #[serde(default)]
+    pub id: Option<Value>,
+
+    pub method: String,
+
+    #[serde(default)]
+    pub params: Value,
+}
+
+/// A JSON-RPC response
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Response {
+    pub jsonrpc: &'static str,
+    pub id: Value,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub result: Option<Value>,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<RpcError>,
+}
+
+/// A JSON-RPC notification of the server
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Notification {
+    pub jsonrpc: &'static str,
+    pub method: &'static str,
+    pub params: Value,
+}
+
+impl Notification {
+    fn new(method: &'static str, params: Value) -> Self {
+        Self {
+            jsonrpc: "2.0",
+            method,
+            params,
+        }
+    }
+}
+
+/// Error of a JSON-RPC response
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct RpcError {
+    pub code: i64,
+    pub message: String,
+
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub data: Option<Value>,
+}
+
+impl RpcError {
+    fn new(code: i64, message: impl Into<String>) -> Self {
+        Self {
+            code,
+            message: message.into(),
+            data: None,
+        }
+    }
+
+    /// Error of a failed method, with the limit reached by a source over a quota
+    fn server(error: anyhow::Error) -> Self {
+        match error.downcast_ref::<QuotaExceeded>() {
+            Some(exceeded) => Self {
+                code: QUOTA_EXCEEDED,
+                message: exceeded.to_string(),
+                data: serde_json::to_value(exceeded).ok(),
+            },
+            None => Self::new(SERVER_ERROR, error.to_string()),
+        }
+    }
+}
+
+impl Response {
+    fn result(id: Value, result: Value) -> Self {
+        Self {
+            jsonrpc: "2.0",
+            id,
+            result: Some(result),
+            error: None,
+        }
+    }
+
+    fn error(id: Value, error: RpcError) -> Self {
+        Self {
+            jsonrpc: "2.0",
+            id,
+            result: None,
+            error: Some(error),
+        }
+    }
+}
+
+/// State of a running server
+pub struct Daemon {
+    app: JsonnetGen,
+
+    /// Parsed types by source name
+    schemas: HashMap<String, Vec<ExtractedSchema>>,
+
+    /// Configuration file reloaded when it changes, with its content hash
+    config_file: Option<(PathBuf, String)>,
+
+    /// Inputs of the sources written by hand, reloaded when they change
+    inputs: Inputs,
+
+    shutdown: bool,
+}
+
+impl Daemon {
+    /// Create a server answering for the sources of an application
+    pub fn new(app: JsonnetGen) -> Self {
+        Self {
+            app,
+            schemas: HashMap::new(),
+            config_file: None,
+            inputs: Inputs::default(),
+            shutdown: false,
+        }
+    }
+
+    /// Reload the configuration from `path` when it or the inputs of its
+    /// sources change
+    pub fn with_config_file(mut self, path: &Path) -> anyhow::Result<Self> {
+        self.config_file = Some((path.to_path_buf(), calculate_file_hash(path)?));
+        self.inputs = Inputs::take(self.app.config());
+        Ok(self)
+    }
+
+    /// Whether `shutdown` was requested
+    pub fn is_shutdown(&self) -> bool {
+        self.shutdown
+    }
+
+    /// Answer the requests of stdin until it is closed or `shutdown` is called
+    pub async fn serve_stdio(&mut self) -> anyhow::Result<()> {
+        let mut lines = BufReader::new(tokio::io::stdin()).lines();
+        let mut stdout = tokio::io::stdout();
+        while let Some(line) = lines.next_line().await? {
+            if let Some(notification) = self.reload().await {
+                stdout
+                    .write_all((serde_json::to_string(&notification)? + "\n").as_bytes())
+                    .await?;
+                stdout.flush().await?;
+            }
+            if let Some(response) = self.handle_line(&line).await {
+                stdout
+                    .write_all((serde_json::to_string(&response)? + "\n").as_bytes())
+                    .await?;
+                stdout.flush().await?;
+            }
+            if self.shutdown {
+                break;
+            }
+        }
+        Ok(())
+    }
+
+    /// Apply changes of the configuration file and of the inputs of its sources
+    ///
+    /// Returns a `config_reloaded` notification with the changes and the
+    /// sources they invalidate, or a `config_error` notification when the
+    /// configuration cannot be applied and the running one is kept.
+    pub async fn reload(&mut self) -> Option<Notification> {
+        let (path, hash) = self.config_file.clone()?;
+        let current = calculate_file_hash(&path).unwrap_or_else(|_| hash.clone());
+        if current == hash && Inputs::take(self.app.config()) == self.inputs {
+            return None;
+        }
+        self.config_file = Some((path.clone(), current));
+
+        let reloaded = reload_config(&path, self.app.config(), &self.inputs);
+        let (config, inputs, diff) = match reloaded {
+            Ok(reloaded) => reloaded,
+            Err(e) => {
+                // The running configuration stays, with the inputs seen now
+                self.inputs = Inputs::take(self.app.config());
+                return Some(self.config_error(&path, e));
+            }
+        };
+        self.inputs = inputs;
+        if diff.is_empty() {
+            return None;
+        }
+        let app = match self.app.with_config(config) {
+            Ok(app) => app,
+            Err(e) => return Some(self.config_error(&path, e)),
+        };
+        if let Err(e) = app.initialize().await {
+            return Some(self.config_error(&path, e));
+        }
+
+        let invalidated: Vec<String> = diff
+            .invalidated(app.config())
+            .into_iter()
+            .map(str::to_string)
+            .collect();
+        self.schemas
+            .retain(|source, _| !invalidated.contains(source) && !diff.removed.contains(source));
+        self.app = app;
+        for line in diff.summary() {
+            info!("Configuration reloaded: {}", line);
+        }
+        Some(Notification::new(
+            "config_reloaded",
+            json!({"changes": diff.summary(), "invalidated": invalidated}),
+        ))
+    }
+
+    /// Notification of a configuration that cannot be applied
+    fn config_error(&self, path: &Path, error: anyhow::Error) -> Notification {
+        warn!(
+            "Configuration {} could not be applied, keeping the running one: {}",
+            path.display(),
+            error
+        );
+        Notification::new(
+            "config_error",
+            json!({"path": path, "message": error.to_string()}),
+        )
+    }
+
+    /// Answer one line of input
+    ///
+    /// Notifications and blank lines get no response.
+    pub async fn handle_line(&mut self, line: &str) -> Option<Response> {
+        if line.trim().is_empty() {
+            return None;
+        }
+        let value: Value = match serde_json::from_str(line) {
+            Ok(value) => value,
+            Err(e) => {
+                return Some(Response::error(
+                    Value::Null,
+                    RpcError::new(PARSE_ERROR, format!("Invalid JSON: {e}")),
+                ))
+            }
+        };
+        let request: Request = match serde_json::from_value(value) {
+            Ok(request) => request,
+            Err(e) => {
+                return Some(Response::error(
+                    Value::Null,
+                    RpcError::new(INVALID_REQUEST, format!("Invalid request: {e}")),
+                ))
+            }
+        };
+
+        let result = self.dispatch(&request.method, &request.params).await;
+        let id = request.id?;
+        Some(match result {
+            Ok(result) => Response::result(id, result),
+            Err(error) => Response::error(id, error),
+        })
+    }
+
+    /// Run a method
+    async fn dispatch(&mut self, method: &str, params: &Value) -> Result<Value, RpcError> {
+        match method {
+            "sources" => Ok(Value::Array(
+                self.app
+                    .config()
+                    .sources
+                    .iter()
+                    .map(|source| {
+                        json!({
+                            "name": source.name(),
+                            "type": source.source_type(),
+                            "output_path": source.output_path(),
+                        })
+                    })
+                    .collect(),
+            )),
+            "types" => {
+                let source = self.source(params)?.name().to_string();
+                let schemas = self.schemas(&source).await?;
+                Ok(Value::Array(
+                    schemas
+                        .iter()
+                        .map(|schema| {
+                            json!({
+                                "name": schema.name,
+                                "schema_type": schema.schema_type,
+                                "package": schema.metadata.get("package"),
+                                "file": schema.source_file,
+                            })
+                        })
+                        .collect(),
+                ))
+            }
+            "schema" => {
+                let source = self.source(params)?.name().to_string();
+                let type_name = string_param(params, "type")?;
+                let package = params.get("package").and_then(Value::as_str);
+                let schemas = self.schemas(&source).await?;
+                let schema = schemas
+                    .iter()
+                    .find(|schema| {
+                        schema.name == type_name
+                            && package.is_none_or(|package| {
+                                schema.metadata.get("package").and_then(|p| p.as_str())
+                                    == Some(package)
+                            })
+                    })
+                    .ok_or_else(|| {
+                        RpcError::new(
+                            INVALID_PARAMS,
+                            format!("No type {type_name} in source {source}"),
+                        )
+                    })?;
+                serde_json::to_value(schema).map_err(|e| RpcError::new(SERVER_ERROR, e.to_string()))
+            }
+            "generate" => {
+                let sources = self.sources_to_generate(params).await?;
+                let mut results = Vec::new();
+                for source in sources {
+                    let result = self
+                        .app
+                        .process_source_with_recovery(&source)
+                        .await
+                        .map_err(RpcError::server)?;
+                    self.schemas.remove(source.name());
+                    results.push(json!({
+                        "source": source.name(),
+                        "files_generated": result.files_generated,
+                        "errors": result.errors,
+                        "warnings": result.warnings,
+                    }));
+                }
+                Ok(Value::Array(results))
+            }
+            "invalidate" => {
+                match params.get("source").and_then(Value::as_str) {
+                    Some(source) => {
+                        self.schemas.remove(source);
+                    }
+                    None => self.schemas.clear(),
+                }
+                Ok(Value::Null)
+            }
+            "shutdown" => {
+                self.shutdown = true;
+                Ok(Value::Null)
+            }
+            _ => Err(RpcError::new(
+                METHOD_NOT_FOUND,
+                format!("Unknown method: {method}"),
+            )),
+        }
+    }
+
+    /// Find the source named by the `source` parameter
+    fn source(&self, params: &Value) -> Result<&Source, RpcError> {
+        let name = string_param(params, "source")?;
+        self.app
+            .config()
+            .sources
+            .iter()
+            .find(|source| source.name() == name)
+            .ok_or_else(|| RpcError::new(INVALID_PARAMS, format!("Unknown source: {name}")))
+    }
+
+    /// Get the parsed types of a source, parsing them on first use
+    async fn schemas(&mut self, source: &str) -> Result<&[ExtractedSchema], RpcError> {
+        if !self.schemas.contains_key(source) {
+            let config_source = self
+                .app
+                .config()
+                .sources
+                .iter()
+                .find(|candidate| candidate.name() == source)
+                .ok_or_else(|| {
+                    RpcError::new(INVALID_PARAMS, format!("Unknown source: {source}"))
+                })?;
+            let schemas = self
+                .app
+                .extract_source_schemas(config_source)
+                .await
+                .map_err(RpcError::server)?;
+            self.schemas.insert(source.to_string(), schemas);
+        }
+        Ok(&self.schemas[source])
+    }
+
+    /// Get the sources a `generate` request asks for
+    ///
+    /// A `package` is regenerated with its whole source, since the index and
+    /// the references between packages span the source.
+    async fn sources_to_generate(&mut self, params: &Value) -> Result<Vec<Source>, RpcError> {
+        if params.get("source").is_some() {
+            return Ok(vec![self.source(params)?.clone()]);
+        }
+        let Some(package) = params.get("package").and_then(Value::as_str) else {
+            return Ok(self.app.config().sources.clone());
+        };
+
+        let names: Vec<String> = self
+            .app
+            .config()
+            .sources
+            .iter()
+            .map(|source| source.name().to_string())
+            .collect();
+        let mut sources = Vec::new();
+        for name in names {
+            let defines_package = self.schemas(&name).await?.iter().any(|schema| {
+                schema.metadata.get("package").and_then(|p| p.as_str()) == Some(package)
+            });
+            if defines_package {
+                sources.extend(
+                    self.app
+                        .config()
+                        .sources
+                        .iter()
+                        .find(|source| source.name() == name)
+                        .cloned(),
+                );
+            }
+        }
+        if sources.is_empty() {
+            return Err(RpcError::new(
+                INVALID_PARAMS,
+                format!("No source defines package {package}"),
+            ));
+        }
+        Ok(sources)
+    }
+}
+
+/// Get a required string parameter
+fn string_param<'a>(params: &'a Value, name: &str) -> Result<&'a str, RpcError> {
+    params
+        .get(name)
+        .and_then(Value::as_str)
+        .ok_or_else(|| RpcError::new(INVALID_PARAMS, format!("Missing string parameter: {name}")))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::Config;
+
+    fn daemon() -> Daemon {
+        let mut config = Config::default();
+        config.sources = vec![Source::GoAst(
+            crate::config::GoAstSource::from_url(
+                "git+https://github.com/acme/api//v1",
+                std::path::Path::new("./generated"),
+            )
+            .unwrap(),
+        )];
+        Daemon::new(JsonnetGen::new(config).unwrap())
+    }
+
+    #[tokio::test]
+    async fn test_handle_line() {
+        let mut daemon = daemon();
+
+        let response = daemon
+            .handle_line(r#"{"jsonrpc":"2.0","id":1,"method":"sources"}"#)
+            .await
+            .unwrap();
+        assert_eq!(response.id, json!(1));
+        let sources = response.result.unwrap();
+        assert_eq!(sources[0]["name"], "api-v1");
+        assert_eq!(sources[0]["type"], "go_ast");
+
+        let response = daemon
+            .handle_line(r#"{"jsonrpc":"2.0","id":2,"method":"schema","params":{"source":"other","type":"User"}}"#)
+            .await
+            .unwrap();
+        assert_eq!(response.error.unwrap().code, INVALID_PARAMS);
+
+        let response = daemon
+            .handle_line(r#"{"jsonrpc":"2.0","id":3,"method":"compile"}"#)
+            .await
+            .unwrap();
+        assert_eq!(response.error.unwrap().code, METHOD_NOT_FOUND);
+
+        let response = daemon.handle_line("{not json").await.unwrap();
+        assert_eq!(response.id, Value::Null);
+        assert_eq!(response.error.unwrap().code, PARSE_ERROR);
+
+        // Notifications get no response
+        assert!(daemon
+            .handle_line(r#"{"jsonrpc":"2.0","method":"invalidate"}"#)
+            .await
+            .is_none());
+        assert!(daemon.handle_line("").await.is_none());
+
+        daemon
+            .handle_line(r#"{"jsonrpc":"2.0","id":4,"method":"shutdown"}"#)
+            .await
+            .unwrap();
+        assert!(daemon.is_shutdown());
+
+        // A source over a quota answers with the limit it reached
+        let Some((_repository, app)) = crate::quota::test_app_over_quota() else {
+            return;
+        };
+        let response = Daemon::new(app)
+            .handle_line(r#"{"jsonrpc":"2.0","id":5,"method":"generate"}"#)
+            .await
+            .unwrap();
+        let error = response.error.unwrap();
+        assert_eq!(error.code, QUOTA_EXCEEDED);
+        assert_eq!(
+            error.data.unwrap(),
+            json!({"source": "api", "quota": "types", "limit": 1, "actual": 2})
+        );
+    }
+
+    #[tokio::test]
+    async fn test_reload_invalid_config() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let path = temp_dir.path().join("gensonnet.yaml");
+        let config = daemon().app.config().clone();
+        config.save_to_file(&path).unwrap();
+        let mut daemon = Daemon::new(JsonnetGen::new(config).unwrap())
+            .with_config_file(&path)
+            .unwrap();
+        assert!(daemon.reload().await.is_none());
+
+        // The running configuration is kept, and the error reported once
+        std::fs::write(&path, "sources: [").unwrap();
+        let notification = daemon.reload().await.unwrap();
+        assert_eq!(notification.method, "config_error");
+        assert_eq!(notification.params["path"], json!(path));
+        assert!(daemon.reload().await.is_none());
+        assert_eq!(daemon.app.config().sources[0].name(), "api-v1");
+    }
+}
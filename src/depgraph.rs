@@ -0,0 +1,419 @@
+//! Type dependency graph for incremental generation of Go AST sources
+//!
+//! With `generation.dependency_graph`, each Go AST source keeps a
+//! `gensonnet.graph.json` in its output directory. For every Go file it
+//! records the content hash and what the parser extracted from it, and for
+//! every type a fingerprint of its resolved schema and the types it
+//! references. The next run only parses the files whose content changed,
+//! and only writes the libraries of the types whose schema changed and of
+//! their transitive dependents; the index and the other files covering all
+//! types are written as usual.
+//!
+//! The graph is only reused by the same gensonnet version with the same
+//! configuration of the source and of the generation; otherwise the source
+//! is generated in full and the graph rebuilt.
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::{Path, PathBuf};
+
+use crate::plugin::ast::aliases::STRUCT_REF_KEY;
+use crate::plugin::ExtractedSchema;
+
+/// File name of the graph written to the output directory of a source
+pub const GRAPH_FILE: &str = "gensonnet.graph.json";
+
+/// Version of the graph layout
+pub const GRAPH_VERSION: u32 = 1;
+
+/// Files and types of a source, as of its last generation
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct DepGraph {
+    /// Layout version, increased on incompatible changes
+    pub version: u32,
+
+    /// Hash of the generator version and the configuration of the run
+    pub key: String,
+
+    /// Parsed Go files, keyed by path relative to the repository
+    pub files: BTreeMap<String, ParsedFile>,
+
+    /// Generated types, keyed by name
+    pub types: BTreeMap<String, TypeNode>,
+}
+
+/// What the parser extracted from a Go file
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ParsedFile {
+    /// SHA-256 of the content of the file
+    pub hash: String,
+
+    /// Schemas of the file, with source files relative to the repository
+    pub schemas: Vec<ExtractedSchema>,
+
+    /// Warnings of the parser
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
+
+/// A type of the graph
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TypeNode {
+    /// SHA-256 of the resolved schema
+    pub fingerprint: String,
+
+    /// Types whose schema the type references
+    #[serde(default, skip_serializing_if = "BTreeSet::is_empty")]
+    pub references: BTreeSet<String>,
+}
+
+impl DepGraph {
+    /// Load the graph of an output directory
+    ///
+    /// A missing graph, or one written by another version or configuration,
+    /// gives an empty graph, so everything is parsed and generated.
+    pub fn load(output_path: &Path, key: &str) -> Self {
+        let path = output_path.join(GRAPH_FILE);
+        let Ok(content) = std::fs::read_to_string(&path) else {
+            return Self::default();
+        };
+        match serde_json::from_str::<DepGraph>(&content) {
+            Ok(graph) if graph.version == GRAPH_VERSION && graph.key == key => graph,
+            Ok(_) => {
+                tracing::info!(
+                    "Dependency graph {} is out of date; generating in full",
+                    path.display()
+                );
+                Self::default()
+            }
+            Err(e) => {
+                tracing::warn!("Ignoring dependency graph {}: {}", path.display(), e);
+                Self::default()
+            }
+        }
+    }
+
+    /// Build the graph of the resolved types of a run
+    pub fn new(
+        key: String,
+        files: BTreeMap<String, ParsedFile>,
+        schemas: &[ExtractedSchema],
+    ) -> Result<Self> {
+        let mut types = BTreeMap::new();
+        for schema in schemas {
+            types.insert(
+                schema.name.clone(),
+                TypeNode {
+                    fingerprint: fingerprint(schema)?,
+                    references: references(&schema.content),
+                },
+            );
+        }
+        Ok(Self {
+            version: GRAPH_VERSION,
+            key,
+            files,
+            types,
+        })
+    }
+
+    /// Whether the graph has no types, as for a first run
+    pub fn is_empty(&self) -> bool {
+        self.types.is_empty()
+    }
+
+    /// Extracted schemas of a file, if its content is unchanged
+    pub fn cached(&self, repo_path: &Path, relative: &str, hash: &str) -> Option<ParsedFile> {
+        let parsed = self.files.get(relative).filter(|file| file.hash == hash)?;
+        let mut parsed = parsed.clone();
+        for schema in &mut parsed.schemas {
+            schema.source_file = repo_path.join(&schema.source_file);
+        }
+        Some(parsed)
+    }
+
+    /// Types to regenerate: the types whose schema differs between the
+    /// graphs, and the types of `new` depending on them
+    pub fn affected(&self, new: &DepGraph) -> BTreeSet<String> {
+        let mut affected: BTreeSet<String> = new
+            .types
+            .iter()
+            .filter(|(name, node)| {
+                self.types
+                    .get(*name)
+                    .is_none_or(|old| old.fingerprint != node.fingerprint)
+            })
+            .map(|(name, _)| name.clone())
+            .collect();
+        affected.extend(
+            self.types
+                .keys()
+                .filter(|name| !new.types.contains_key(*name))
+                .cloned(),
+        );
+
+        // Dependents of the changed types, to a fixed point
+        let mut pending: Vec<String> = affected.iter().cloned().collect();
+        while let Some(name) = pending.pop() {
+            for (dependent, node) in &new.types {
+                if node.references.contains(&name) && affected.insert(dependent.clone()) {
+                    pending.push(dependent.clone());
+                }
+            }
+        }
+        affected
+    }
+
+    /// Write the graph to an output directory
+    pub fn write(&self, output_path: &Path) -> Result<PathBuf> {
+        let path = output_path.join(GRAPH_FILE);
+        std::fs::write(&path, serde_json::to_string(self)? + "\n")?;
+        Ok(path)
+    }
+}
+
+/// Extractions of the Go files of a run, reusing those of a previous graph
+pub struct FileCache<'a> {
+    previous: &'a DepGraph,
+    repo_path: PathBuf,
+
+    /// Extraction of each file of the run, keyed by path relative to the repository
+    pub files: BTreeMap<String, ParsedFile>,
+
+    /// Number of files whose extraction was reused
+    pub reused: usize,
+}
+
+impl<'a> FileCache<'a> {
+    pub fn new(previous: &'a DepGraph, repo_path: &Path) -> Self {
+        Self {
+            previous,
+            repo_path: repo_path.to_path_buf(),
+            files: BTreeMap::new(),
+            reused: 0,
+        }
+    }
+
+    /// Hash a Go file, with its extraction if it is unchanged since the
+    /// previous graph
+    pub fn lookup(&mut self, file: &Path) -> Result<(String, Option<ParsedFile>)> {
+        let hash = crate::utils::calculate_file_hash(file)?;
+        let relative = self.relative(file);
+        let cached = self.previous.cached(&self.repo_path, &relative, &hash);
+        if let Some(parsed) = &cached {
+            self.files.insert(
+                relative,
+                ParsedFile::new(
+                    hash.clone(),
+                    &parsed.schemas,
+                    &parsed.warnings,
+                    &self.repo_path,
+                ),
+            );
+            self.reused += 1;
+        }
+        Ok((hash, cached))
+    }
+
+    /// Record the extraction of a parsed file
+    pub fn record(
+        &mut self,
+        file: &Path,
+        hash: String,
+        schemas: &[ExtractedSchema],
+        warnings: &[String],
+    ) {
+        self.files.insert(
+            self.relative(file),
+            ParsedFile::new(hash, schemas, warnings, &self.repo_path),
+        );
+    }
+
+    fn relative(&self, file: &Path) -> String {
+        file.strip_prefix(&self.repo_path)
+            .unwrap_or(file)
+            .to_string_lossy()
+            .replace('\\', "/")
+    }
+}
+
+impl ParsedFile {
+    /// Record the extraction of a file, with source files relative to `repo_path`
+    pub fn new(
+        hash: String,
+        schemas: &[ExtractedSchema],
+        warnings: &[String],
+        repo_path: &Path,
+    ) -> Self {
+        let schemas = schemas
+            .iter()
+            .cloned()
+            .map(|mut schema| {
+                if let Ok(relative) = schema.source_file.strip_prefix(repo_path) {
+                    schema.source_file = relative.to_path_buf();
+                }
+                schema
+            })
+            .collect();
+        Self {
+            hash,
+            schemas,
+            warnings: warnings.to_vec(),
+        }
+    }
+}
+
+/// Hash a resolved schema
+///
+/// The schema goes through a JSON value first, which sorts the keys of its
+/// metadata.
+fn fingerprint(schema: &ExtractedSchema) -> Result<String> {
+    crate::integrity::config_sha256(&serde_json::to_value(schema)?)
+}
+
+/// Names of the types a schema references, through fields, items,
+/// additional properties and union variants
+pub fn references(content: &serde_yaml::Value) -> BTreeSet<String> {
+    let mut names = BTreeSet::new();
+    collect_references(content, &mut names);
+    names
+}
+
+fn collect_references(value: &serde_yaml::Value, names: &mut BTreeSet<String>) {
+    match value {
+        serde_yaml::Value::Mapping(map) => {
+            for key in [STRUCT_REF_KEY, "x-go-interface-ref"] {
+                if let Some(name) = map.get(key).and_then(|name| name.as_str()) {
+                    names.insert(name.to_string());
+                }
+            }
+            if let Some(name) = map
+                .get("x-go-ref")
+                .and_then(|reference| reference.get("type"))
+                .and_then(|name| name.as_str())
+            {
+                names.insert(name.to_string());
+            }
+            if let Some(variants) = map
+                .get("x-go-union")
+                .and_then(|union| union.get("variants"))
+                .and_then(|variants| variants.as_sequence())
+            {
+                names.extend(
+                    variants
+                        .iter()
+                        .filter_map(|variant| variant.get("name").and_then(|name| name.as_str()))
+                        .map(str::to_string),
+                );
+            }
+            for value in map.values() {
+                collect_references(value, names);
+            }
+        }
+        serde_yaml::Value::Sequence(items) => {
+            for item in items {
+                collect_references(item, names);
+            }
+        }
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_affected_types() {
+        let user = test_schema(
+            "User",
+            "go_struct",
+            "api/types.go",
+            "{properties: {home: {x-go-struct-ref: Address}}, type: object}",
+        );
+        let team = test_schema(
+            "Team",
+            "go_struct",
+            "api/types.go",
+            "{properties: {members: {items: {x-go-struct-ref: User}, type: array}}, type: object}",
+        );
+        let group = test_schema(
+            "Group",
+            "go_struct",
+            "api/types.go",
+            "{properties: {name: {type: string}}, type: object}",
+        );
+        let old = DepGraph::new(
+            "key".to_string(),
+            BTreeMap::new(),
+            &[
+                test_schema(
+                    "Address",
+                    "go_struct",
+                    "api/types.go",
+                    "{properties: {city: {type: string}}, type: object}",
+                ),
+                user.clone(),
+                team.clone(),
+                group.clone(),
+            ],
+        )
+        .unwrap();
+        assert_eq!(
+            old.types["Team"].references,
+            BTreeSet::from(["User".to_string()])
+        );
+
+        let new = DepGraph::new(
+            "key".to_string(),
+            BTreeMap::new(),
+            &[
+                test_schema(
+                    "Address",
+                    "go_struct",
+                    "api/types.go",
+                    "{properties: {city: {type: string}, zip: {type: string}}, type: object}",
+                ),
+                user,
+                team,
+                group,
+            ],
+        )
+        .unwrap();
+        let affected: Vec<String> = old.affected(&new).into_iter().collect();
+        assert_eq!(affected, vec!["Address", "Team", "User"]);
+        assert!(new.affected(&new).is_empty());
+        assert_eq!(DepGraph::default().affected(&new).len(), 4);
+    }
+
+    #[test]
+    fn test_cached_files() {
+        let repo = Path::new("/repo");
+        let mut user = test_schema("User", "go_struct", "api/types.go", "{type: object}");
+        user.source_file = repo.join("api/user.go");
+        let parsed = ParsedFile::new("abc".to_string(), &[user], &[], repo);
+        assert_eq!(parsed.schemas[0].source_file, PathBuf::from("api/user.go"));
+
+        let graph = DepGraph::new(
+            "key".to_string(),
+            BTreeMap::from([("api/user.go".to_string(), parsed)]),
+            &[],
+        )
+        .unwrap();
+        let dir = tempfile::tempdir().unwrap();
+        graph.write(dir.path()).unwrap();
+
+        let loaded = DepGraph::load(dir.path(), "key");
+        let cached = loaded
+            .cached(Path::new("/checkout"), "api/user.go", "abc")
+            .unwrap();
+        assert_eq!(
+            cached.schemas[0].source_file,
+            PathBuf::from("/checkout/api/user.go")
+        );
+        assert!(loaded.cached(repo, "api/user.go", "def").is_none());
+        assert!(DepGraph::load(dir.path(), "other").files.is_empty());
+    }
+}
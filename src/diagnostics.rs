@@ -0,0 +1,328 @@
+//! Codes and severity levels of the warnings of a run
+//!
+//! Every construct the generator cannot translate faithfully produces a
+//! warning starting with a stable code (`GS0201: api.User.Team references
+//! ...`), grouped by category. The `diagnostics` section of the generation
+//! configuration turns codes or whole categories off or into errors:
+//!
+//! ```yaml
+//! generation:
+//!   diagnostics:
+//!     strict: true
+//!     levels:
+//!       GS0302: off
+//!       constant: warning
+//! ```
+//!
+//! With `strict`, every warning that is not given a level of its own fails
+//! the source like an error.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::fmt::Display;
+use std::path::Path;
+
+/// Kind of warning, with a stable code
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Code {
+    /// Type parameters or instantiations of generic types
+    Generic,
+
+    /// Types encoding themselves with a `MarshalJSON`-style method
+    CustomMarshaler,
+
+    /// `validate` rules without a Jsonnet check
+    ValidateRule,
+
+    /// `json` and `yaml` tag options that change the encoding
+    TagOption,
+
+    /// Map keys encoding/json cannot encode
+    MapKey,
+
+    /// Types using C types of cgo
+    Cgo,
+
+    /// Unexported fields generated with `unexported_fields: include`
+    UnexportedField,
+
+    /// Fields referencing excluded types
+    ExcludedReference,
+
+    /// `gensonnet:` comments that are not directives
+    UnknownDirective,
+
+    /// References to workspace modules whose types are not generated
+    UngeneratedReference,
+
+    /// Packages no module of go.mod provides
+    UnknownModule,
+
+    /// Modules that could not be downloaded
+    ModuleDownload,
+
+    /// Types not declared in the packages referencing them
+    UndeclaredType,
+
+    /// Interfaces embedding interfaces declared outside the module
+    ExternalInterface,
+
+    /// Interfaces without implementations in the module
+    NoImplementations,
+
+    /// Unions of the configuration that cannot be generated as declared
+    DeclaredUnion,
+
+    /// Constants declared with different values by several packages
+    ConflictingConstant,
+
+    /// Defaults naming something else than a constant of their package
+    UnresolvedDefault,
+
+    /// Constants without an integer type declaration in their package
+    UntypedEnum,
+
+    /// Errors declared with different messages or codes by several packages
+    ConflictingError,
+
+    /// Packages registering kinds without a GroupVersion
+    MissingGroupVersion,
+
+    /// Kubernetes resources not registered with their package's scheme
+    UnregisteredKind,
+}
+
+impl Code {
+    /// Every code, in the order of their numbers
+    pub const ALL: [Code; 22] = [
+        Code::Generic,
+        Code::CustomMarshaler,
+        Code::ValidateRule,
+        Code::TagOption,
+        Code::MapKey,
+        Code::Cgo,
+        Code::UnexportedField,
+        Code::ExcludedReference,
+        Code::UnknownDirective,
+        Code::UngeneratedReference,
+        Code::UnknownModule,
+        Code::ModuleDownload,
+        Code::UndeclaredType,
+        Code::ExternalInterface,
+        Code::NoImplementations,
+        Code::DeclaredUnion,
+        Code::ConflictingConstant,
+        Code::UnresolvedDefault,
+        Code::UntypedEnum,
+        Code::ConflictingError,
+        Code::MissingGroupVersion,
+        Code::UnregisteredKind,
+    ];
+
+    /// Stable code of warnings of this kind
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Code::Generic => "GS0001",
+            Code::CustomMarshaler => "GS0002",
+            Code::ValidateRule => "GS0003",
+            Code::TagOption => "GS0004",
+            Code::MapKey => "GS0005",
+            Code::Cgo => "GS0006",
+            Code::UnexportedField => "GS0101",
+            Code::ExcludedReference => "GS0102",
+            Code::UnknownDirective => "GS0103",
+            Code::UngeneratedReference => "GS0201",
+            Code::UnknownModule => "GS0202",
+            Code::ModuleDownload => "GS0203",
+            Code::UndeclaredType => "GS0204",
+            Code::ExternalInterface => "GS0301",
+            Code::NoImplementations => "GS0302",
+            Code::DeclaredUnion => "GS0303",
+            Code::ConflictingConstant => "GS0401",
+            Code::UnresolvedDefault => "GS0402",
+            Code::UntypedEnum => "GS0403",
+            Code::ConflictingError => "GS0404",
+            Code::MissingGroupVersion => "GS0501",
+            Code::UnregisteredKind => "GS0502",
+        }
+    }
+
+    /// Category of the code, which levels can be set for as a whole
+    pub fn category(&self) -> &'static str {
+        match &self.as_str()[..4] {
+            "GS00" => "unsupported",
+            "GS01" => "field",
+            "GS02" => "reference",
+            "GS03" => "interface",
+            "GS04" => "constant",
+            _ => "scheme",
+        }
+    }
+
+    /// Warning of this kind
+    pub fn warning(&self, message: impl Display) -> String {
+        format!("{}: {}", self.as_str(), message)
+    }
+
+    /// Code a warning starts with
+    pub fn of(warning: &str) -> Option<Code> {
+        let code = warning.split(':').next()?;
+        Code::ALL.into_iter().find(|c| c.as_str() == code)
+    }
+}
+
+/// Severity of the warnings of a code
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Level {
+    /// Not logged nor counted; unsupported constructs stay in the
+    /// unsupported report
+    Off,
+    Warning,
+
+    /// Fails the source
+    Error,
+}
+
+/// Levels of the warnings of a run
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Diagnostics {
+    /// Whether warnings without a level of their own are errors
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub strict: bool,
+
+    /// Levels of codes (`GS0201`) or categories (`reference`); the level of
+    /// a code takes precedence over the level of its category
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub levels: BTreeMap<String, Level>,
+}
+
+impl Diagnostics {
+    /// Whether all warnings are warnings
+    pub fn is_empty(&self) -> bool {
+        !self.strict && self.levels.is_empty()
+    }
+
+    /// Check that levels are set for known codes and categories
+    pub fn validate(&self) -> Result<()> {
+        for selector in self.levels.keys() {
+            if !Code::ALL
+                .iter()
+                .any(|code| code.as_str() == selector || code.category() == selector)
+            {
+                return Err(anyhow!(
+                    "Unknown diagnostic code or category '{}' in generation.diagnostics",
+                    selector
+                ));
+            }
+        }
+        Ok(())
+    }
+
+    /// Level of a warning; warnings without a known code are warnings
+    /// unless `strict` is set
+    pub fn level(&self, warning: &str) -> Level {
+        let default = if self.strict {
+            Level::Error
+        } else {
+            Level::Warning
+        };
+        let Some(code) = Code::of(warning) else {
+            return default;
+        };
+        self.levels
+            .get(code.as_str())
+            .or_else(|| self.levels.get(code.category()))
+            .copied()
+            .unwrap_or(default)
+    }
+
+    /// Record a warning as a warning or an error, or drop it, by its level
+    pub fn record(&self, warning: String, warnings: &mut Vec<String>, errors: &mut Vec<String>) {
+        match self.level(&warning) {
+            Level::Off => {}
+            Level::Warning => {
+                tracing::warn!("{}", warning);
+                warnings.push(warning);
+            }
+            Level::Error => {
+                tracing::error!("{}", warning);
+                errors.push(warning);
+            }
+        }
+    }
+
+    /// Record a warning about a file, which errors are prefixed with
+    pub fn record_in(
+        &self,
+        file: &Path,
+        warning: String,
+        warnings: &mut Vec<String>,
+        errors: &mut Vec<String>,
+    ) {
+        match self.level(&warning) {
+            Level::Off => {}
+            Level::Warning => {
+                tracing::warn!("{}: {}", file.display(), warning);
+                warnings.push(warning);
+            }
+            Level::Error => {
+                let error = format!("{}: {}", file.display(), warning);
+                tracing::error!("{}", error);
+                errors.push(error);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_levels() {
+        let diagnostics: Diagnostics =
+            serde_yaml::from_str("{levels: {GS0302: off, reference: error}}").unwrap();
+        diagnostics.validate().unwrap();
+
+        let ungenerated = Code::UngeneratedReference.warning("api.User.Team references ...");
+        assert_eq!(Code::of(&ungenerated), Some(Code::UngeneratedReference));
+        assert_eq!(diagnostics.level(&ungenerated), Level::Error);
+        assert_eq!(
+            diagnostics.level(&Code::NoImplementations.warning("No type implements Handler")),
+            Level::Off
+        );
+        assert_eq!(
+            diagnostics.level(&Code::ExternalInterface.warning("Handler embeds io.Closer")),
+            Level::Warning
+        );
+
+        let strict = Diagnostics {
+            strict: true,
+            ..diagnostics
+        };
+        let (mut warnings, mut errors) = (Vec::new(), Vec::new());
+        for warning in [
+            Code::NoImplementations.warning("No type implements Handler"),
+            Code::ExternalInterface.warning("Handler embeds io.Closer"),
+            "Something without a code".to_string(),
+        ] {
+            strict.record(warning, &mut warnings, &mut errors);
+        }
+        assert!(warnings.is_empty());
+        assert_eq!(errors.len(), 2);
+
+        let unknown: Diagnostics = serde_yaml::from_str("{levels: {GS9999: off}}").unwrap();
+        assert!(unknown.validate().is_err());
+    }
+
+    #[test]
+    fn test_codes() {
+        let codes: Vec<&str> = Code::ALL.iter().map(Code::as_str).collect();
+        let mut sorted = codes.clone();
+        sorted.sort();
+        sorted.dedup();
+        assert_eq!(codes, sorted);
+    }
+}
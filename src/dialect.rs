@@ -0,0 +1,409 @@
+//! Output for older releases of the Jsonnet standard library
+//!
+//! Generated code uses standard library functions whenever they fit, some of
+//! them only added in recent `jsonnet` releases. When the configured
+//! `jsonnet_version` predates a function, its calls in the emitted files are
+//! replaced by a polyfill from the shared `polyfills.libsonnet` of the output
+//! directory, and every substitution is listed in `dialect.json`, so the
+//! libraries evaluate on the target release instead of failing there.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::compat::token_spans;
+use crate::output::write_if_changed;
+use crate::overrides::is_user_file;
+use crate::utils::is_jsonnet;
+
+/// Shared library of the polyfills, inside the output directory
+pub const POLYFILLS_FILE: &str = "polyfills.libsonnet";
+
+/// Report of the substitutions, inside the output directory
+pub const DIALECT_REPORT_FILE: &str = "dialect.json";
+
+/// A standard library function with a replacement for older releases
+struct Polyfill {
+    function: &'static str,
+    since: Version,
+    code: &'static str,
+
+    /// How the polyfill differs from the function, if it does
+    caveat: Option<&'static str>,
+}
+
+/// Polyfills of the standard library functions used by generated code
+///
+/// Each is written with functions of every supported release.
+const POLYFILLS: &[Polyfill] = &[
+    Polyfill {
+        function: "trace",
+        since: Version(0, 16, 0),
+        code: "  trace(str, rest):: rest,\n",
+        caveat: Some("messages are not printed"),
+    },
+    Polyfill {
+        function: "objectValues",
+        since: Version(0, 17, 0),
+        code: "  objectValues(o):: [o[k] for k in std.objectFields(o)],\n",
+        caveat: None,
+    },
+    Polyfill {
+        function: "member",
+        since: Version(0, 17, 0),
+        code: "  member(arr, x)::\n    if std.isArray(arr) then std.length(std.find(x, arr)) > 0\n    else if std.isString(arr) then std.length(std.findSubstr(x, arr)) > 0\n    else error 'std.member first argument must be an array or a string',\n",
+        caveat: None,
+    },
+    Polyfill {
+        function: "get",
+        since: Version(0, 18, 0),
+        code: "  get(o, f, default=null, inc_hidden=true)::\n    if std.objectHasEx(o, f, inc_hidden) then o[f] else default,\n",
+        caveat: None,
+    },
+    Polyfill {
+        function: "objectRemoveKey",
+        since: Version(0, 20, 0),
+        code: "  objectRemoveKey(obj, key):: { [k]: obj[k] for k in std.objectFields(obj) if k != key },\n",
+        caveat: None,
+    },
+];
+
+/// Release of `jsonnet`, as major, minor and patch numbers
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub struct Version(pub u32, pub u32, pub u32);
+
+impl std::str::FromStr for Version {
+    type Err = anyhow::Error;
+
+    /// Parse `0.17`, `0.17.0` or `v0.17.0`
+    fn from_str(s: &str) -> Result<Self> {
+        let invalid = || anyhow!("Invalid Jsonnet version '{}' (expected e.g. 0.17.0)", s);
+        let parts: Vec<u32> = s
+            .trim_start_matches('v')
+            .split('.')
+            .map(|part| part.parse().map_err(|_| invalid()))
+            .collect::<Result<_>>()?;
+        match parts.as_slice() {
+            [major, minor] => Ok(Version(*major, *minor, 0)),
+            [major, minor, patch] => Ok(Version(*major, *minor, *patch)),
+            _ => Err(invalid()),
+        }
+    }
+}
+
+impl std::fmt::Display for Version {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}.{}.{}", self.0, self.1, self.2)
+    }
+}
+
+/// Calls of a function replaced in a file
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Substitution {
+    /// Function replaced, as `std.get`
+    pub function: String,
+
+    /// Release that added the function
+    pub since: String,
+
+    /// File, relative to the output directory
+    pub file: String,
+
+    /// Number of calls replaced
+    pub calls: usize,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub caveat: Option<String>,
+}
+
+/// Report of the substitutions made in an output directory
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DialectReport {
+    pub jsonnet_version: String,
+    pub substitutions: Vec<Substitution>,
+}
+
+/// Replace the functions `version` lacks in every Jsonnet file below `dir`
+///
+/// `written` holds the files already written downgraded, with the calls
+/// replaced in them, which are reported without reading them again.
+/// Writes the polyfills used to `polyfills.libsonnet` and the report to
+/// `dialect.json`; both are removed when nothing is replaced, so a stale
+/// report does not outlive a version change.
+pub fn downgrade_directory(
+    dir: &Path,
+    version: Version,
+    written: &HashMap<PathBuf, Vec<(&'static str, usize)>>,
+) -> Result<Vec<Substitution>> {
+    let polyfills_path = dir.join(POLYFILLS_FILE);
+    let report_path = dir.join(DIALECT_REPORT_FILE);
+    if !dir.is_dir() {
+        return Ok(Vec::new());
+    }
+
+    let mut files = Vec::new();
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        if entry.file_type().is_file()
+            && is_jsonnet(entry.path())
+            && entry.path() != polyfills_path
+            && !is_user_file(dir, entry.path())
+        {
+            files.push(entry.into_path());
+        }
+    }
+    files.sort();
+
+    let mut substitutions = Vec::new();
+    for file in &files {
+        let relative = file.strip_prefix(dir).unwrap_or(file);
+        let depth = relative.components().count() - 1;
+        let replaced = match written.get(file) {
+            Some(replaced) => replaced.clone(),
+            None => {
+                let code = std::fs::read_to_string(file)?;
+                let (downgraded, replaced) = downgrade_code(&code, version, &"../".repeat(depth));
+                if downgraded != code {
+                    std::fs::write(file, downgraded)?;
+                }
+                replaced
+            }
+        };
+        for (function, calls) in replaced {
+            let polyfill = POLYFILLS.iter().find(|p| p.function == function).unwrap();
+            substitutions.push(Substitution {
+                function: format!("std.{function}"),
+                since: polyfill.since.to_string(),
+                file: relative.to_string_lossy().replace('\\', "/"),
+                calls,
+                caveat: polyfill.caveat.map(str::to_string),
+            });
+        }
+    }
+
+    if substitutions.is_empty() {
+        for path in [&polyfills_path, &report_path] {
+            if path.exists() {
+                std::fs::remove_file(path)?;
+            }
+        }
+        return Ok(substitutions);
+    }
+
+    let mut library = format!(
+        "// Polyfills of standard library functions missing from jsonnet {version}\n// Generated by gensonnet; see {DIALECT_REPORT_FILE} for where they are used\n{{\n"
+    );
+    for polyfill in POLYFILLS {
+        if substitutions
+            .iter()
+            .any(|s| s.function == format!("std.{}", polyfill.function))
+        {
+            library.push_str(polyfill.code);
+        }
+    }
+    library.push_str("}\n");
+    write_if_changed(&polyfills_path, library)?;
+
+    let report = DialectReport {
+        jsonnet_version: version.to_string(),
+        substitutions: substitutions.clone(),
+    };
+    write_if_changed(&report_path, serde_json::to_string_pretty(&report)? + "\n")?;
+
+    Ok(substitutions)
+}
+
+/// Replace the calls of functions `version` lacks in Jsonnet code
+///
+/// The polyfills are imported from `prefix` + `polyfills.libsonnet`, after
+/// the leading comments. Returns the code and the number of calls of each
+/// polyfill; calls replaced by an earlier run are counted too, so running
+/// it again on its output gives the same report.
+pub fn downgrade_code(
+    code: &str,
+    version: Version,
+    prefix: &str,
+) -> (String, Vec<(&'static str, usize)>) {
+    let mut replaced = Vec::new();
+    let mut result = code.to_string();
+    for polyfill in POLYFILLS.iter().filter(|p| version < p.since) {
+        result = replace_calls(&result, polyfill.function);
+        let count = calls(&result, &format!("polyfills.{}", polyfill.function)).len();
+        if count > 0 {
+            replaced.push((polyfill.function, count));
+        }
+    }
+    // The import of an earlier run may have been reformatted since
+    let imported = result
+        .lines()
+        .any(|line| line.starts_with("local polyfills = import "));
+    if replaced.is_empty() || imported {
+        return (result, replaced);
+    }
+    let import = format!("local polyfills = import \"{prefix}{POLYFILLS_FILE}\";\n");
+
+    let header_len: usize = result
+        .lines()
+        .take_while(|line| line.trim_start().starts_with("//"))
+        .map(|line| line.len() + 1)
+        .sum();
+    let header_len = header_len.min(result.len());
+    result.insert_str(header_len, &import);
+    (result, replaced)
+}
+
+/// Replace `std.<function>(` by `polyfills.<function>(`
+///
+/// Only calls in code are replaced; strings and comments are left alone.
+fn replace_calls(code: &str, function: &str) -> String {
+    let mut result = String::with_capacity(code.len());
+    let mut copied = 0;
+    for span in calls(code, &format!("std.{function}")) {
+        result.push_str(&code[copied..span.start]);
+        result.push_str(&format!("polyfills.{function}"));
+        copied = span.end;
+    }
+    result.push_str(&code[copied..]);
+    result
+}
+
+/// Find the calls of a function in Jsonnet code, as the byte ranges of its name
+///
+/// The name must be directly followed by `(`; names in strings and comments,
+/// and fields of other objects (`mystd.get`), are not calls of it.
+fn calls(code: &str, name: &str) -> Vec<std::ops::Range<usize>> {
+    token_spans(code)
+        .windows(2)
+        .filter(|pair| &code[pair[0].clone()] == name && &code[pair[1].clone()] == "(")
+        .filter(|pair| pair[0].end == pair[1].start)
+        .map(|pair| pair[0].clone())
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_downgrade_code_skips_strings_and_comments() {
+        let code = concat!(
+            "// Reads the spec with std.get(obj, 'spec')\n",
+            "{\n",
+            "  // Falls back to std.get(obj, 'status')\n",
+            "  spec: std.get(obj, 'spec', {}),\n",
+            "  doc: 'use std.get(obj, key) to read a field',\n",
+            "  help: |||\n    std.get(obj, key)\n  |||,\n",
+            "}\n",
+        );
+
+        let (downgraded, replaced) = downgrade_code(code, Version(0, 17, 0), "");
+        assert_eq!(replaced, vec![("get", 1)]);
+        assert!(downgraded.contains("spec: polyfills.get(obj, 'spec', {}),"));
+        assert!(downgraded.contains("// Reads the spec with std.get(obj, 'spec')\n"));
+        assert!(downgraded.contains("// Falls back to std.get(obj, 'status')\n"));
+        assert!(downgraded.contains("doc: 'use std.get(obj, key) to read a field',"));
+        assert!(downgraded.contains("    std.get(obj, key)\n"));
+
+        // Code calling nothing to replace is kept as it is
+        let (kept, replaced) = downgrade_code(
+            "// std.get(obj, key)\n{ doc: \"std.get(\" }\n",
+            Version(0, 17, 0),
+            "",
+        );
+        assert_eq!(kept, "// std.get(obj, key)\n{ doc: \"std.get(\" }\n");
+        assert!(replaced.is_empty());
+    }
+
+    #[test]
+    fn test_version() {
+        assert_eq!("0.17".parse::<Version>().unwrap(), Version(0, 17, 0));
+        assert_eq!("v0.20.1".parse::<Version>().unwrap(), Version(0, 20, 1));
+        assert!("latest".parse::<Version>().is_err());
+        assert!(Version(0, 17, 0) < Version(0, 18, 0));
+    }
+
+    #[test]
+    fn test_downgrade_code() {
+        let code = "// Generated from Go AST: User\n{\n  spec: std.get(obj, 'spec', {}),\n  roles: std.member(['a'], role),\n  names: mystd.get(x),\n}\n";
+
+        let (downgraded, replaced) = downgrade_code(code, Version(0, 17, 0), "");
+        assert_eq!(replaced, vec![("get", 1)]);
+        assert!(downgraded.starts_with(
+            "// Generated from Go AST: User\nlocal polyfills = import \"polyfills.libsonnet\";\n{\n"
+        ));
+        assert!(downgraded.contains("spec: polyfills.get(obj, 'spec', {}),"));
+        assert!(downgraded.contains("roles: std.member(['a'], role),"));
+        assert!(downgraded.contains("mystd.get(x)"));
+
+        // Running again keeps the code and the report
+        let (again, replaced) = downgrade_code(&downgraded, Version(0, 17, 0), "");
+        assert_eq!(again, downgraded);
+        assert_eq!(replaced, vec![("get", 1)]);
+        let formatted = crate::format::format_code(&downgraded);
+        assert_eq!(
+            downgrade_code(&formatted, Version(0, 17, 0), "").0,
+            formatted
+        );
+
+        let (unchanged, replaced) = downgrade_code(code, Version(0, 20, 0), "");
+        assert_eq!(unchanged, code);
+        assert!(replaced.is_empty());
+    }
+
+    #[test]
+    fn test_downgrade_directory() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        std::fs::create_dir_all(dir.join("tests")).unwrap();
+        std::fs::write(dir.join("user.libsonnet"), "{ a: std.get({}, 'a') }\n").unwrap();
+        std::fs::write(
+            dir.join("tests/user_test.jsonnet"),
+            "std.trace('x', std.objectValues({}))\n",
+        )
+        .unwrap();
+
+        let substitutions = downgrade_directory(dir, Version(0, 15, 0), &HashMap::new()).unwrap();
+        let listed: Vec<(&str, &str)> = substitutions
+            .iter()
+            .map(|s| (s.file.as_str(), s.function.as_str()))
+            .collect();
+        assert_eq!(
+            listed,
+            vec![
+                ("tests/user_test.jsonnet", "std.trace"),
+                ("tests/user_test.jsonnet", "std.objectValues"),
+                ("user.libsonnet", "std.get"),
+            ]
+        );
+        let test = std::fs::read_to_string(dir.join("tests/user_test.jsonnet")).unwrap();
+        assert!(test.starts_with("local polyfills = import \"../polyfills.libsonnet\";\n"));
+
+        let polyfills = std::fs::read_to_string(dir.join(POLYFILLS_FILE)).unwrap();
+        assert!(polyfills.contains("  get(o, f, default=null, inc_hidden=true)::"));
+        assert!(!polyfills.contains("objectRemoveKey"));
+        assert!(dir.join(DIALECT_REPORT_FILE).exists());
+
+        // Nothing left to replace: the shared files go away
+        std::fs::write(dir.join("user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("tests/user_test.jsonnet"), "{}\n").unwrap();
+        assert!(downgrade_directory(dir, Version(0, 15, 0), &HashMap::new())
+            .unwrap()
+            .is_empty());
+        assert!(!dir.join(POLYFILLS_FILE).exists());
+        assert!(!dir.join(DIALECT_REPORT_FILE).exists());
+
+        // Files written downgraded are reported as written, not read again
+        std::fs::write(dir.join("user.libsonnet"), "{ a: std.get({}, 'a') }\n").unwrap();
+        let written = HashMap::from([(dir.join("user.libsonnet"), vec![("get", 2)])]);
+        let substitutions = downgrade_directory(dir, Version(0, 15, 0), &written).unwrap();
+        assert_eq!(substitutions.len(), 1);
+        assert_eq!(substitutions[0].calls, 2);
+        assert_eq!(
+            std::fs::read_to_string(dir.join("user.libsonnet")).unwrap(),
+            "{ a: std.get({}, 'a') }\n"
+        );
+        assert!(dir.join(POLYFILLS_FILE).exists());
+    }
+}
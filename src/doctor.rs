@@ -0,0 +1,430 @@
+//! Environment checks
+//!
+//! Probes the local environment for the tools and paths generation relies on
+//! and suggests a fix for each problem found. Checks only inspect the local
+//! machine; nothing is sent anywhere.
+
+use serde::Serialize;
+use std::ffi::OsString;
+use std::path::{Path, PathBuf};
+
+use crate::config::{Config, Formatter, GenerationConfig, Source};
+
+/// Outcome of a single check
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum CheckStatus {
+    /// Nothing to fix
+    Ok,
+
+    /// Generation works, but some features are unavailable
+    Warn,
+
+    /// Generation is expected to fail
+    Fail,
+}
+
+/// Result of a single environment check
+#[derive(Debug, Clone, Serialize)]
+pub struct Check {
+    /// Name of the check
+    pub name: String,
+
+    /// Outcome
+    pub status: CheckStatus,
+
+    /// What was found
+    pub detail: String,
+
+    /// Suggested fix when the check did not pass
+    pub fix: Option<String>,
+}
+
+impl Check {
+    fn ok(name: &str, detail: impl Into<String>) -> Self {
+        Self {
+            name: name.to_string(),
+            status: CheckStatus::Ok,
+            detail: detail.into(),
+            fix: None,
+        }
+    }
+
+    fn warn(name: &str, detail: impl Into<String>, fix: impl Into<String>) -> Self {
+        Self {
+            name: name.to_string(),
+            status: CheckStatus::Warn,
+            detail: detail.into(),
+            fix: Some(fix.into()),
+        }
+    }
+
+    fn fail(name: &str, detail: impl Into<String>, fix: impl Into<String>) -> Self {
+        Self {
+            name: name.to_string(),
+            status: CheckStatus::Fail,
+            detail: detail.into(),
+            fix: Some(fix.into()),
+        }
+    }
+}
+
+/// Run all environment checks
+///
+/// Checks depending on the configuration are skipped when no configuration
+/// is given.
+pub async fn run_checks(config: Option<&Config>) -> Vec<Check> {
+    let mut checks = Vec::new();
+    let path = std::env::var_os("PATH");
+
+    let uses_go = config.is_some_and(|config| {
+        config
+            .sources
+            .iter()
+            .any(|source| matches!(source, Source::GoAst(_)))
+    });
+    if uses_go {
+        checks.push(check_go_toolchain(path.clone()));
+        checks.push(check_module_cache());
+    }
+
+    checks.push(check_formatter(path.clone(), config.map(|c| &c.generation)));
+    checks.push(check_evaluator(path.clone(), config.map(|c| &c.generation)));
+    checks.push(check_jsonnet_path(std::env::var_os("JSONNET_PATH")));
+
+    if let Some(config) = config {
+        checks.push(check_writable("Output directory", &config.output.base_path));
+        for source in &config.sources {
+            let name = format!("Output of {}", source.name());
+            checks.push(check_writable(&name, source.output_path()));
+        }
+        checks.push(check_plugin_directories(config));
+        checks.push(check_plugins(config).await);
+    }
+
+    checks
+}
+
+/// Check that `go` is installed
+///
+/// Parsing does not need the toolchain, but resolving modules from the
+/// module cache does.
+fn check_go_toolchain(path: Option<OsString>) -> Check {
+    const NAME: &str = "Go toolchain";
+
+    let Some(go) = find_executable("go", path) else {
+        return Check::warn(
+            NAME,
+            "go was not found in PATH",
+            "Install Go from https://go.dev/dl/ to resolve modules from the module cache",
+        );
+    };
+
+    match std::process::Command::new(&go).arg("version").output() {
+        Ok(output) if output.status.success() => {
+            Check::ok(NAME, String::from_utf8_lossy(&output.stdout).trim())
+        }
+        _ => Check::warn(
+            NAME,
+            format!("{} version failed", go.display()),
+            "Reinstall Go or fix the GOROOT environment variable",
+        ),
+    }
+}
+
+/// Check that the Go module cache exists
+fn check_module_cache() -> Check {
+    const NAME: &str = "Go module cache";
+
+    let Some(cache) = module_cache_dir() else {
+        return Check::warn(
+            NAME,
+            "Could not determine the module cache location",
+            "Set GOMODCACHE or GOPATH",
+        );
+    };
+
+    if cache.is_dir() {
+        Check::ok(NAME, cache.display().to_string())
+    } else {
+        Check::warn(
+            NAME,
+            format!("{} does not exist", cache.display()),
+            "Run `go mod download` in the Go module being processed",
+        )
+    }
+}
+
+/// Get the Go module cache directory
+fn module_cache_dir() -> Option<PathBuf> {
+    if let Some(cache) = std::env::var_os("GOMODCACHE").filter(|c| !c.is_empty()) {
+        return Some(PathBuf::from(cache));
+    }
+
+    let gopath = std::env::var_os("GOPATH")
+        .filter(|p| !p.is_empty())
+        .and_then(|p| std::env::split_paths(&p).next())
+        .or_else(|| dirs::home_dir().map(|home| home.join("go")))?;
+    Some(gopath.join("pkg").join("mod"))
+}
+
+/// Check that a Jsonnet formatter is installed
+///
+/// A missing `jsonnetfmt` only fails the check when the configuration uses it.
+fn check_formatter(path: Option<OsString>, generation: Option<&GenerationConfig>) -> Check {
+    const NAME: &str = "Jsonnet formatter";
+    const FIX: &str =
+        "Install it with `go install github.com/google/go-jsonnet/cmd/jsonnetfmt@latest`";
+
+    let required = generation.is_some_and(|g| g.formatter == Formatter::Jsonnetfmt);
+    let command = generation.and_then(|g| g.formatter_command.as_deref());
+    let found = match command {
+        Some(command) if command.components().count() > 1 => {
+            command.is_file().then(|| command.to_path_buf())
+        }
+        Some(command) => find_executable(&command.to_string_lossy(), path),
+        None => find_executable("jsonnetfmt", path),
+    };
+
+    match found {
+        Some(formatter) => Check::ok(NAME, formatter.display().to_string()),
+        None if required => Check::fail(
+            NAME,
+            "The configured jsonnetfmt was not found",
+            format!("{FIX}, or set generation.formatter to builtin"),
+        ),
+        None => Check::warn(NAME, "jsonnetfmt was not found in PATH", FIX),
+    }
+}
+
+/// Check that a `jsonnet` binary is installed, for evaluating generated code
+///
+/// A missing `jsonnet` only fails the check when generation verifies its output.
+fn check_evaluator(path: Option<OsString>, generation: Option<&GenerationConfig>) -> Check {
+    const NAME: &str = "Jsonnet evaluator";
+    const FIX: &str =
+        "Install it with `go install github.com/google/go-jsonnet/cmd/jsonnet@latest`";
+
+    let required = generation.is_some_and(|g| g.verify);
+    let command = generation.and_then(|g| g.jsonnet_command.as_deref());
+    let found = match command {
+        Some(command) if command.components().count() > 1 => {
+            command.is_file().then(|| command.to_path_buf())
+        }
+        Some(command) => find_executable(&command.to_string_lossy(), path),
+        None => find_executable("jsonnet", path),
+    };
+
+    match found {
+        Some(jsonnet) => Check::ok(NAME, jsonnet.display().to_string()),
+        None if required => Check::fail(
+            NAME,
+            "The jsonnet binary used by generation.verify was not found",
+            format!("{FIX}, or set generation.verify to false"),
+        ),
+        None => Check::warn(
+            NAME,
+            "jsonnet was not found in PATH; `gensonnet repl` and `gensonnet template test` are unavailable",
+            FIX,
+        ),
+    }
+}
+
+/// Check that all JSONNET_PATH entries are directories
+fn check_jsonnet_path(jsonnet_path: Option<OsString>) -> Check {
+    const NAME: &str = "JSONNET_PATH";
+
+    let Some(jsonnet_path) = jsonnet_path.filter(|p| !p.is_empty()) else {
+        return Check::ok(NAME, "Not set");
+    };
+
+    let entries: Vec<PathBuf> = std::env::split_paths(&jsonnet_path).collect();
+    let invalid: Vec<String> = entries
+        .iter()
+        .filter(|entry| !entry.is_dir())
+        .map(|entry| entry.display().to_string())
+        .collect();
+
+    if invalid.is_empty() {
+        Check::ok(NAME, format!("{} entries", entries.len()))
+    } else {
+        Check::warn(
+            NAME,
+            format!("Not a directory: {}", invalid.join(", ")),
+            "Remove the entries from JSONNET_PATH or create the directories",
+        )
+    }
+}
+
+/// Check that files can be created in an output directory
+///
+/// Missing directories are not created; the nearest existing parent is
+/// checked instead, since generation creates the rest.
+fn check_writable(name: &str, path: &Path) -> Check {
+    let existing = path
+        .ancestors()
+        .map(|dir| {
+            if dir.as_os_str().is_empty() {
+                Path::new(".")
+            } else {
+                dir
+            }
+        })
+        .find(|dir| dir.exists());
+    let Some(existing) = existing else {
+        return Check::fail(
+            name,
+            format!("{} has no existing parent directory", path.display()),
+            "Use an output path inside an existing directory",
+        );
+    };
+
+    if !existing.is_dir() {
+        return Check::fail(
+            name,
+            format!("{} is not a directory", existing.display()),
+            format!("Remove {} or change the output path", existing.display()),
+        );
+    }
+
+    match tempfile::NamedTempFile::new_in(existing) {
+        Ok(_) => Check::ok(name, path.display().to_string()),
+        Err(e) => Check::fail(
+            name,
+            format!("Cannot write to {}: {}", existing.display(), e),
+            format!("Fix the permissions of {}", existing.display()),
+        ),
+    }
+}
+
+/// Check that at least one configured plugin directory exists
+fn check_plugin_directories(config: &Config) -> Check {
+    const NAME: &str = "Plugin directories";
+
+    if !config.plugins.enable_external_discovery {
+        return Check::ok(NAME, "External plugin discovery is disabled");
+    }
+
+    let missing: Vec<String> = config
+        .plugins
+        .plugin_directories
+        .iter()
+        .filter(|dir| !expand_path(dir).is_dir())
+        .map(|dir| dir.display().to_string())
+        .collect();
+
+    if missing.len() < config.plugins.plugin_directories.len() {
+        Check::ok(
+            NAME,
+            format!(
+                "{} of {} found",
+                config.plugins.plugin_directories.len() - missing.len(),
+                config.plugins.plugin_directories.len()
+            ),
+        )
+    } else {
+        Check::warn(
+            NAME,
+            format!("None found ({})", missing.join(", ")),
+            "Create a plugin directory or set plugins.enable_external_discovery to false",
+        )
+    }
+}
+
+/// Check that the built-in plugins load
+async fn check_plugins(config: &Config) -> Check {
+    const NAME: &str = "Plugins";
+
+    let app = match crate::JsonnetGen::new(config.clone()) {
+        Ok(app) => app,
+        Err(e) => {
+            return Check::fail(
+                NAME,
+                e.to_string(),
+                "Check that the lockfile and cache directories are readable",
+            )
+        }
+    };
+
+    match app.initialize_plugins().await {
+        Ok(()) => Check::ok(NAME, "Built-in plugins loaded"),
+        Err(e) => Check::fail(
+            NAME,
+            e.to_string(),
+            "Run `gensonnet plugins list` to find the failing plugin",
+        ),
+    }
+}
+
+/// Find an executable in the directories of a PATH value
+fn find_executable(name: &str, path: Option<OsString>) -> Option<PathBuf> {
+    let path = path?;
+    std::env::split_paths(&path).find_map(|dir| {
+        let candidate = dir.join(name);
+        if candidate.is_file() {
+            return Some(candidate);
+        }
+        let candidate = candidate.with_extension("exe");
+        candidate.is_file().then_some(candidate)
+    })
+}
+
+/// Expand `~` and environment variables in a configured path
+fn expand_path(path: &Path) -> PathBuf {
+    let path_str = path.to_string_lossy();
+    match shellexpand::full(&path_str) {
+        Ok(expanded) => PathBuf::from(expanded.as_ref()),
+        Err(_) => path.to_path_buf(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_find_executable() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        std::fs::write(temp_dir.path().join("jsonnetfmt"), "").unwrap();
+        let path = std::env::join_paths([Path::new("/nonexistent"), temp_dir.path()]).unwrap();
+
+        assert_eq!(
+            find_executable("jsonnetfmt", Some(path.clone())),
+            Some(temp_dir.path().join("jsonnetfmt"))
+        );
+        assert_eq!(find_executable("go", Some(path)), None);
+        assert_eq!(find_executable("go", None), None);
+    }
+
+    #[test]
+    fn test_check_jsonnet_path() {
+        let temp_dir = tempfile::tempdir().unwrap();
+
+        assert_eq!(check_jsonnet_path(None).status, CheckStatus::Ok);
+
+        let valid = std::env::join_paths([temp_dir.path()]).unwrap();
+        assert_eq!(check_jsonnet_path(Some(valid)).status, CheckStatus::Ok);
+
+        let missing = temp_dir.path().join("vendor");
+        let invalid = std::env::join_paths([temp_dir.path(), &missing]).unwrap();
+        let check = check_jsonnet_path(Some(invalid));
+        assert_eq!(check.status, CheckStatus::Warn);
+        assert!(check.detail.contains("vendor"));
+        assert!(check.fix.is_some());
+    }
+
+    #[test]
+    fn test_check_writable() {
+        let temp_dir = tempfile::tempdir().unwrap();
+
+        let missing = temp_dir.path().join("out/lib");
+        assert_eq!(check_writable("Output", &missing).status, CheckStatus::Ok);
+        assert!(!missing.exists());
+
+        let file = temp_dir.path().join("file");
+        std::fs::write(&file, "").unwrap();
+        let check = check_writable("Output", &file.join("lib"));
+        assert_eq!(check.status, CheckStatus::Fail);
+        assert!(check.fix.is_some());
+    }
+}
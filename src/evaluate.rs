@@ -0,0 +1,93 @@
+//! Evaluation of Jsonnet code
+//!
+//! Code is evaluated with an external `jsonnet` binary (go-jsonnet or the C++
+//! implementation), with generated output directories on the import path.
+//! No evaluator is embedded, so the commands evaluating code require one to be
+//! installed; `gensonnet doctor` reports whether it is found.
+
+use anyhow::{anyhow, Result};
+use std::path::{Path, PathBuf};
+
+/// Evaluates Jsonnet snippets with an external `jsonnet` binary
+#[derive(Debug, Clone)]
+pub struct Evaluator {
+    command: PathBuf,
+    jpath: Vec<PathBuf>,
+}
+
+impl Evaluator {
+    /// Create an evaluator running `command`, or `jsonnet` from PATH
+    pub fn new(command: Option<&Path>, jpath: Vec<PathBuf>) -> Self {
+        Self {
+            command: command.map_or_else(|| PathBuf::from("jsonnet"), Path::to_path_buf),
+            jpath,
+        }
+    }
+
+    /// Get the library search directories
+    pub fn jpath(&self) -> &[PathBuf] {
+        &self.jpath
+    }
+
+    /// Check that the `jsonnet` binary can be run
+    pub fn check(&self) -> Result<()> {
+        std::process::Command::new(&self.command)
+            .arg("--version")
+            .output()
+            .map(|_| ())
+            .map_err(|e| self.spawn_error(e))
+    }
+
+    /// Describe a failure to run the `jsonnet` binary
+    fn spawn_error(&self, error: std::io::Error) -> anyhow::Error {
+        if error.kind() == std::io::ErrorKind::NotFound {
+            anyhow!(
+                "{} was not found; evaluating Jsonnet requires a jsonnet binary in PATH or given with --jsonnet (install it with `go install github.com/google/go-jsonnet/cmd/jsonnet@latest`, and run `gensonnet doctor` to check the environment)",
+                self.command.display()
+            )
+        } else {
+            anyhow!("Failed to run {}: {}", self.command.display(), error)
+        }
+    }
+
+    /// Evaluate a snippet and return its JSON output
+    ///
+    /// Errors carry the message printed by `jsonnet`.
+    pub fn evaluate(&self, code: &str) -> Result<String> {
+        let mut command = std::process::Command::new(&self.command);
+        for dir in &self.jpath {
+            command.arg("-J").arg(dir);
+        }
+        let output = command
+            .arg("-e")
+            .arg(code)
+            .output()
+            .map_err(|e| self.spawn_error(e))?;
+
+        if output.status.success() {
+            Ok(String::from_utf8_lossy(&output.stdout)
+                .trim_end()
+                .to_string())
+        } else {
+            Err(anyhow!(
+                "{}",
+                String::from_utf8_lossy(&output.stderr).trim_end()
+            ))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_missing_evaluator() {
+        let evaluator = Evaluator::new(Some(Path::new("/nonexistent/jsonnet")), Vec::new());
+
+        let error = evaluator.check().unwrap_err().to_string();
+        assert!(error.starts_with("/nonexistent/jsonnet was not found;"));
+        assert!(error.contains("gensonnet doctor"));
+        assert_eq!(evaluator.evaluate("1").unwrap_err().to_string(), error);
+    }
+}
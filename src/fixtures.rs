@@ -0,0 +1,382 @@
+//! Randomized fixtures of generated types
+//!
+//! `gensonnet fixtures` builds JSON values of the types of a source for
+//! seeding the tests of systems that consume them. Values are random but
+//! satisfy the schema: required fields are always set, optional ones only
+//! sometimes, and enums, formats, lengths, bounds and item counts are
+//! respected. References to other types of the source are followed, and
+//! interfaces with a union of implementations get one of the variants with
+//! its discriminator set.
+//!
+//! A fixture is reproducible from its seed. Patterns cannot be generated
+//! from, so a field with a pattern takes its example or default when it has
+//! one.
+
+use anyhow::{anyhow, Result};
+use serde_json::{Map, Number, Value};
+use std::collections::HashMap;
+
+use crate::plugin::ast::aliases::STRUCT_REF_KEY;
+use crate::plugin::ExtractedSchema;
+
+/// Depth after which references are no longer followed
+const MAX_DEPTH: usize = 6;
+
+/// Words of generated strings
+const WORDS: &[&str] = &[
+    "alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet",
+];
+
+/// Random number generator of fixtures (SplitMix64)
+#[derive(Debug, Clone)]
+pub struct Rng(u64);
+
+impl Rng {
+    /// Create a generator from a seed
+    pub fn new(seed: u64) -> Self {
+        Self(seed)
+    }
+
+    /// Next random number
+    pub fn next_u64(&mut self) -> u64 {
+        self.0 = self.0.wrapping_add(0x9E37_79B9_7F4A_7C15);
+        let mut z = self.0;
+        z = (z ^ (z >> 30)).wrapping_mul(0xBF58_476D_1CE4_E5B9);
+        z = (z ^ (z >> 27)).wrapping_mul(0x94D0_49BB_1331_11EB);
+        z ^ (z >> 31)
+    }
+
+    /// Random number in `min..=max`
+    pub fn range(&mut self, min: i64, max: i64) -> i64 {
+        if max <= min {
+            return min;
+        }
+        let span = (max as i128 - min as i128 + 1) as u128;
+        (min as i128 + (self.next_u64() as u128 % span) as i128) as i64
+    }
+
+    /// True one time in `n`
+    pub fn one_in(&mut self, n: u64) -> bool {
+        self.range(1, n.max(1) as i64) == 1
+    }
+
+    /// Pick an element of a slice
+    fn pick<'a, T>(&mut self, items: &'a [T]) -> Option<&'a T> {
+        if items.is_empty() {
+            return None;
+        }
+        items.get(self.range(0, items.len() as i64 - 1) as usize)
+    }
+}
+
+/// Generator of fixtures for the types of a source
+pub struct Fixtures<'a> {
+    types: HashMap<&'a str, &'a ExtractedSchema>,
+    rng: Rng,
+}
+
+impl<'a> Fixtures<'a> {
+    /// Create a generator of fixtures for a set of types
+    pub fn new(schemas: &'a [ExtractedSchema], seed: u64) -> Self {
+        let mut types = HashMap::new();
+        for schema in schemas {
+            types.entry(schema.name.as_str()).or_insert(schema);
+        }
+        Self {
+            types,
+            rng: Rng::new(seed),
+        }
+    }
+
+    /// Check whether a type is known
+    pub fn contains(&self, name: &str) -> bool {
+        self.types.contains_key(name)
+    }
+
+    /// Generate a fixture of a type
+    pub fn generate(&mut self, name: &str) -> Result<Value> {
+        let schema = self
+            .types
+            .get(name)
+            .copied()
+            .ok_or_else(|| anyhow!("Unknown type: {}", name))?;
+        Ok(self.type_value(schema, 0))
+    }
+
+    /// Generate a value of a type, or of one of its variants for a union
+    fn type_value(&mut self, schema: &ExtractedSchema, depth: usize) -> Value {
+        let Some(union) = schema.content.get("x-go-union") else {
+            return self.value(&schema.content, depth);
+        };
+        let variants: Vec<&serde_yaml::Value> = union
+            .get("variants")
+            .and_then(|v| v.as_sequence())
+            .into_iter()
+            .flatten()
+            .collect();
+        let Some(variant) = self.rng.pick(&variants).copied() else {
+            return Value::Null;
+        };
+        let Some(implementation) = variant
+            .get("name")
+            .and_then(|n| n.as_str())
+            .and_then(|n| self.types.get(n).copied())
+        else {
+            return Value::Null;
+        };
+
+        let mut value = self.value(&implementation.content, depth + 1);
+        let discriminator = union
+            .get("discriminator")
+            .and_then(|d| d.as_str())
+            .filter(|d| !d.is_empty());
+        if let (Some(discriminator), Some(object), Some(tag)) = (
+            discriminator,
+            value.as_object_mut(),
+            variant.get("value").and_then(json),
+        ) {
+            object.insert(discriminator.to_string(), tag);
+        }
+        value
+    }
+
+    /// Generate a value satisfying a schema
+    fn value(&mut self, schema: &serde_yaml::Value, depth: usize) -> Value {
+        let nullable = schema.get("nullable").and_then(|n| n.as_bool()) == Some(true);
+        if nullable && (depth >= MAX_DEPTH || self.rng.one_in(5)) {
+            return Value::Null;
+        }
+
+        if let Some(values) = schema.get("enum").and_then(|e| e.as_sequence()) {
+            if let Some(value) = self.rng.pick(values).and_then(json) {
+                return value;
+            }
+        }
+        for key in [STRUCT_REF_KEY, "x-go-interface-ref"] {
+            if let Some(name) = schema.get(key).and_then(|n| n.as_str()) {
+                return match self.types.get(name).copied() {
+                    Some(referenced) if depth < MAX_DEPTH => self.type_value(referenced, depth + 1),
+                    _ => Value::Object(Map::new()),
+                };
+            }
+        }
+        if schema.get("pattern").is_some() {
+            if let Some(value) = ["example", "default"]
+                .iter()
+                .find_map(|key| schema.get(*key))
+                .and_then(json)
+            {
+                return value;
+            }
+        }
+
+        let count = |key: &str| schema.get(key).and_then(|v| v.as_u64());
+        match schema.get("type").and_then(|t| t.as_str()) {
+            Some("string") => Value::String(self.string(schema)),
+            Some("integer") => {
+                let (min, max) = bounds(schema);
+                Value::from(self.rng.range(min.ceil() as i64, max.floor() as i64))
+            }
+            Some("number") => {
+                let (min, max) = bounds(schema);
+                let hundredths = self
+                    .rng
+                    .range((min * 100.0).ceil() as i64, (max * 100.0).floor() as i64);
+                Number::from_f64(hundredths as f64 / 100.0)
+                    .map(Value::Number)
+                    .unwrap_or(Value::Null)
+            }
+            Some("boolean") => Value::Bool(self.rng.one_in(2)),
+            Some("array") => {
+                let min = count("minItems").unwrap_or(0);
+                let max = count("maxItems").unwrap_or(min + 3).max(min);
+                let length = self.rng.range(min as i64, max as i64);
+                let items = schema.get("items").cloned().unwrap_or_default();
+                Value::Array((0..length).map(|_| self.value(&items, depth + 1)).collect())
+            }
+            Some("object") => self.object(schema, depth),
+            _ => Value::Null,
+        }
+    }
+
+    /// Generate an object, with its required fields and some optional ones
+    fn object(&mut self, schema: &serde_yaml::Value, depth: usize) -> Value {
+        let mut object = Map::new();
+        if let Some(properties) = schema.get("properties").and_then(|p| p.as_mapping()) {
+            let required: Vec<&str> = schema
+                .get("required")
+                .and_then(|r| r.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|name| name.as_str())
+                .collect();
+            for (name, property) in properties {
+                let Some(name) = name.as_str() else {
+                    continue;
+                };
+                let is_required = required.contains(&name)
+                    || property
+                        .get("x-go-constructor-param")
+                        .and_then(|p| p.as_bool())
+                        == Some(true);
+                if is_required || (depth < MAX_DEPTH && self.rng.one_in(2)) {
+                    object.insert(name.to_string(), self.value(property, depth + 1));
+                }
+            }
+            return Value::Object(object);
+        }
+
+        if let Some(values) = schema
+            .get("additionalProperties")
+            .filter(|p| p.is_mapping())
+        {
+            let min = schema
+                .get("minProperties")
+                .and_then(|v| v.as_u64())
+                .unwrap_or(0);
+            let max = schema
+                .get("maxProperties")
+                .and_then(|v| v.as_u64())
+                .unwrap_or(min + 2)
+                .max(min);
+            for i in 0..self.rng.range(min as i64, max as i64) {
+                let key = format!("{}{}", self.word(), i);
+                let value = self.value(values, depth + 1);
+                object.insert(key, value);
+            }
+        }
+        Value::Object(object)
+    }
+
+    /// Generate a string in the format and length bounds of a schema
+    fn string(&mut self, schema: &serde_yaml::Value) -> String {
+        let length = |key: &str| schema.get(key).and_then(|v| v.as_u64());
+        match schema.get("format").and_then(|f| f.as_str()) {
+            Some("email") => {
+                return format!("{}{}@example.com", self.word(), self.rng.range(1, 999))
+            }
+            Some("uri" | "url") => return format!("https://example.com/{}", self.word()),
+            Some("uuid") => {
+                let hex = format!("{:016x}{:016x}", self.rng.next_u64(), self.rng.next_u64());
+                return format!(
+                    "{}-{}-4{}-a{}-{}",
+                    &hex[0..8],
+                    &hex[8..12],
+                    &hex[13..16],
+                    &hex[17..20],
+                    &hex[20..32]
+                );
+            }
+            Some("date") => {
+                return format!(
+                    "{}-{:02}-{:02}",
+                    self.rng.range(2000, 2030),
+                    self.rng.range(1, 12),
+                    self.rng.range(1, 28)
+                )
+            }
+            Some("date-time") => {
+                return format!(
+                    "{}-{:02}-{:02}T{:02}:{:02}:{:02}Z",
+                    self.rng.range(2000, 2030),
+                    self.rng.range(1, 12),
+                    self.rng.range(1, 28),
+                    self.rng.range(0, 23),
+                    self.rng.range(0, 59),
+                    self.rng.range(0, 59)
+                )
+            }
+            Some("duration") => return format!("{}s", self.rng.range(1, 3600)),
+            _ => {}
+        }
+
+        let min = length("minLength").unwrap_or(1);
+        let max = length("maxLength").unwrap_or(min.max(12)).max(min);
+        let target = self.rng.range(min as i64, max as i64) as usize;
+        let mut value = String::new();
+        while value.len() < target {
+            value.push_str(self.word());
+        }
+        value.truncate(target);
+        value
+    }
+
+    fn word(&mut self) -> &'static str {
+        self.rng.pick(WORDS).copied().unwrap_or("x")
+    }
+}
+
+/// Inclusive bounds of a number
+fn bounds(schema: &serde_yaml::Value) -> (f64, f64) {
+    let bound = |key: &str| schema.get(key).and_then(|v| v.as_f64());
+    let min = bound("minimum")
+        .or_else(|| bound("exclusiveMinimum").map(|m| m + 1.0))
+        .unwrap_or(0.0);
+    let max = bound("maximum")
+        .or_else(|| bound("exclusiveMaximum").map(|m| m - 1.0))
+        .unwrap_or(min.max(0.0) + 100.0)
+        .max(min);
+    (min, max)
+}
+
+/// Convert a YAML value to JSON
+fn json(value: &serde_yaml::Value) -> Option<Value> {
+    serde_json::to_value(value).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    fn schemas() -> Vec<ExtractedSchema> {
+        vec![
+            test_schema("User", "go_struct", "types.go", r#"
+properties:
+  age: {type: integer, maximum: 120, minimum: 18}
+  email: {type: string, format: email, x-go-constructor-param: true}
+  name: {type: string, maxLength: 8, minLength: 3}
+  role: {type: string, enum: [admin, member], x-go-constructor-param: true}
+  shape: {type: object, x-go-interface-ref: Shape}
+  tags: {type: array, items: {type: string}, maxItems: 2, minItems: 1}
+required: [age]
+type: object
+"#),
+            test_schema("Shape", "go_struct", "types.go", "{type: object, x-go-union: {discriminator: kind, variants: [{name: Circle, value: circle}]}}"),
+            test_schema("Circle", "go_struct", "types.go", "{properties: {radius: {type: number, exclusiveMinimum: 0, x-go-constructor-param: true}}, type: object}"),
+        ]
+    }
+
+    #[test]
+    fn test_fixtures_respect_constraints() {
+        let schemas = schemas();
+        let mut fixtures = Fixtures::new(&schemas, 7);
+        for _ in 0..200 {
+            let user = fixtures.generate("User").unwrap();
+            let age = user["age"].as_i64().unwrap();
+            assert!((18..=120).contains(&age));
+            assert!(user["email"].as_str().unwrap().ends_with("@example.com"));
+            assert!(["admin", "member"].contains(&user["role"].as_str().unwrap()));
+            if let Some(name) = user.get("name") {
+                assert!((3..=8).contains(&name.as_str().unwrap().len()));
+            }
+            if let Some(tags) = user.get("tags") {
+                assert!((1..=2).contains(&tags.as_array().unwrap().len()));
+            }
+            if let Some(shape) = user.get("shape") {
+                assert_eq!(shape["kind"], "circle");
+                assert!(shape["radius"].as_f64().unwrap() >= 1.0);
+            }
+        }
+
+        assert!(fixtures.generate("Order").is_err());
+    }
+
+    #[test]
+    fn test_fixtures_are_reproducible() {
+        let schemas = schemas();
+        let first = Fixtures::new(&schemas, 42).generate("User").unwrap();
+        let second = Fixtures::new(&schemas, 42).generate("User").unwrap();
+        assert_eq!(first, second);
+    }
+}
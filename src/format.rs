@@ -0,0 +1,340 @@
+//! Formatting of emitted Jsonnet files
+//!
+//! The built-in formatter applies the parts of the `jsonnetfmt` default style
+//! that generated code can differ in: single-quoted strings where possible,
+//! `//` comments, no trailing whitespace and a single final newline. The
+//! layout of the generators is already the one `jsonnetfmt` produces. For
+//! full formatting, files are passed to an external `jsonnetfmt`.
+
+use anyhow::{anyhow, Result};
+use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::config::Formatter;
+use crate::overrides::is_user_file;
+use crate::utils::is_jsonnet;
+
+/// Format every Jsonnet file below `dir`, except the files written by hand
+/// and the files in `written`, which were written formatted
+///
+/// Returns the number of files formatted.
+pub fn format_directory(
+    dir: &Path,
+    formatter: Formatter,
+    command: Option<&Path>,
+    written: &HashSet<PathBuf>,
+) -> Result<usize> {
+    if formatter == Formatter::None || !dir.is_dir() {
+        return Ok(0);
+    }
+
+    let mut files = Vec::new();
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        if entry.file_type().is_file()
+            && is_jsonnet(entry.path())
+            && !is_user_file(dir, entry.path())
+            && !written.contains(entry.path())
+        {
+            files.push(entry.into_path());
+        }
+    }
+    if files.is_empty() {
+        return Ok(0);
+    }
+
+    match formatter {
+        Formatter::None => {}
+        Formatter::Builtin => {
+            for file in &files {
+                let code = std::fs::read_to_string(file)?;
+                let formatted = format_code(&code);
+                if formatted != code {
+                    std::fs::write(file, formatted)?;
+                }
+            }
+        }
+        Formatter::Jsonnetfmt => {
+            run_jsonnetfmt(command.unwrap_or(Path::new("jsonnetfmt")), &files)?;
+        }
+    }
+
+    Ok(files.len())
+}
+
+/// Format Jsonnet code as `format_directory` formats the files
+pub fn format_jsonnet(code: &str, formatter: Formatter, command: Option<&Path>) -> Result<String> {
+    match formatter {
+        Formatter::None => Ok(code.to_string()),
+        Formatter::Builtin => Ok(format_code(code)),
+        Formatter::Jsonnetfmt => jsonnetfmt_code(command.unwrap_or(Path::new("jsonnetfmt")), code),
+    }
+}
+
+/// Format code with an external `jsonnetfmt`, through its standard input
+fn jsonnetfmt_code(command: &Path, code: &str) -> Result<String> {
+    use std::io::Write;
+    use std::process::Stdio;
+
+    let mut child = std::process::Command::new(command)
+        .arg("-")
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(|e| anyhow!("Failed to run {}: {}", command.display(), e))?;
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin.write_all(code.as_bytes())?;
+    }
+    let output = child.wait_with_output()?;
+
+    if !output.status.success() {
+        return Err(anyhow!(
+            "{} failed: {}",
+            command.display(),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+
+    Ok(String::from_utf8(output.stdout)?)
+}
+
+/// Format files in place with an external `jsonnetfmt`
+fn run_jsonnetfmt(command: &Path, files: &[PathBuf]) -> Result<()> {
+    if files.is_empty() {
+        return Ok(());
+    }
+
+    let output = std::process::Command::new(command)
+        .arg("-i")
+        .arg("--")
+        .args(files)
+        .output()
+        .map_err(|e| anyhow!("Failed to run {}: {}", command.display(), e))?;
+
+    if !output.status.success() {
+        return Err(anyhow!(
+            "{} failed: {}",
+            command.display(),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+
+    Ok(())
+}
+
+/// Format Jsonnet code with the built-in formatter
+pub fn format_code(code: &str) -> String {
+    let chars: Vec<char> = code.chars().collect();
+    let mut result = String::with_capacity(code.len());
+    let mut i = 0;
+
+    while i < chars.len() {
+        let c = chars[i];
+
+        if c == '#' {
+            // Hash comments become `//` comments
+            result.push_str("//");
+            i += 1;
+            while i < chars.len() && chars[i] != '\n' {
+                result.push(chars[i]);
+                i += 1;
+            }
+        } else if c == '/' && chars.get(i + 1) == Some(&'/') {
+            while i < chars.len() && chars[i] != '\n' {
+                result.push(chars[i]);
+                i += 1;
+            }
+        } else if c == '/' && chars.get(i + 1) == Some(&'*') {
+            let start = i;
+            i += 2;
+            while i < chars.len() && !(chars[i] == '*' && chars.get(i + 1) == Some(&'/')) {
+                i += 1;
+            }
+            i = (i + 2).min(chars.len());
+            result.extend(&chars[start..i]);
+        } else if chars[i..].starts_with(&['|', '|', '|']) {
+            let start = i;
+            i += 3;
+            while i < chars.len() && !chars[i..].starts_with(&['|', '|', '|']) {
+                i += 1;
+            }
+            i = (i + 3).min(chars.len());
+            result.extend(&chars[start..i]);
+        } else if c == '@' && matches!(chars.get(i + 1), Some('"' | '\'')) {
+            // Verbatim strings are kept as written
+            let quote = chars[i + 1];
+            let start = i;
+            i += 2;
+            while i < chars.len() {
+                if chars[i] == quote {
+                    if chars.get(i + 1) == Some(&quote) {
+                        i += 2;
+                        continue;
+                    }
+                    break;
+                }
+                i += 1;
+            }
+            i = (i + 1).min(chars.len());
+            result.extend(&chars[start..i]);
+        } else if c == '"' || c == '\'' {
+            let start = i;
+            i += 1;
+            while i < chars.len() && chars[i] != c {
+                if chars[i] == '\\' {
+                    i += 1;
+                }
+                i += 1;
+            }
+            i = (i + 1).min(chars.len());
+            let literal: String = chars[start..i].iter().collect();
+            if c == '"' {
+                result.push_str(&single_quoted(&literal));
+            } else {
+                result.push_str(&literal);
+            }
+        } else {
+            // Trailing whitespace is dropped, except inside text blocks
+            if c == '\n' {
+                let trimmed = result.trim_end_matches([' ', '\t']).len();
+                result.truncate(trimmed);
+            }
+            result.push(c);
+            i += 1;
+        }
+    }
+
+    let mut formatted = result.trim_end().to_string();
+    formatted.push('\n');
+    formatted
+}
+
+/// Rewrite a double-quoted string literal with single quotes
+///
+/// Strings containing a single quote keep their double quotes, as
+/// `jsonnetfmt` does.
+fn single_quoted(literal: &str) -> String {
+    let inner = match literal.strip_prefix('"').and_then(|l| l.strip_suffix('"')) {
+        Some(inner) if !inner.contains('\'') => inner,
+        _ => return literal.to_string(),
+    };
+
+    let mut result = String::from("'");
+    let mut chars = inner.chars();
+    while let Some(c) = chars.next() {
+        if c == '\\' {
+            match chars.next() {
+                Some('"') => result.push('"'),
+                Some(escaped) => {
+                    result.push('\\');
+                    result.push(escaped);
+                }
+                None => result.push('\\'),
+            }
+        } else {
+            result.push(c);
+        }
+    }
+    result.push('\'');
+    result
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_code() {
+        let code = concat!(
+            "# Generated  \n",
+            "{\n",
+            "  name: \"user\",   \n",
+            "  quote: \"it's\",\n",
+            "  escaped: \"say \\\"hi\\\"\\n\",\n",
+            "  verbatim: @\"C:\\dir\",\n",
+            "  text: |||\n",
+            "    keep \"this\"  \n",
+            "  |||,\n",
+            "  // \"comment\"\n",
+            "}\n",
+            "\n\n",
+        );
+
+        assert_eq!(
+            format_code(code),
+            concat!(
+                "// Generated\n",
+                "{\n",
+                "  name: 'user',\n",
+                "  quote: \"it's\",\n",
+                "  escaped: 'say \"hi\"\\n',\n",
+                "  verbatim: @\"C:\\dir\",\n",
+                "  text: |||\n",
+                "    keep \"this\"  \n",
+                "  |||,\n",
+                "  // \"comment\"\n",
+                "}\n",
+            )
+        );
+    }
+
+    #[test]
+    fn test_format_code_is_idempotent() {
+        let code = "{\n  withName(name):: self + { name: name },\n  kind: \"User\",\n}\n";
+        let formatted = format_code(code);
+        assert_eq!(format_code(&formatted), formatted);
+    }
+
+    #[test]
+    fn test_format_directory() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let file = temp_dir.path().join("user.libsonnet");
+        std::fs::write(&file, "{ kind: \"User\" }").unwrap();
+        std::fs::write(temp_dir.path().join("index.json"), "{\"a\": 1}").unwrap();
+        let overrides = temp_dir.path().join(crate::overrides::OVERRIDES_FILE);
+        std::fs::write(&overrides, "{ kind+: \"!\" }").unwrap();
+
+        let none = HashSet::new();
+        let formatted = format_directory(temp_dir.path(), Formatter::Builtin, None, &none).unwrap();
+        assert_eq!(formatted, 1);
+        assert_eq!(
+            std::fs::read_to_string(&file).unwrap(),
+            "{ kind: 'User' }\n"
+        );
+        assert_eq!(
+            std::fs::read_to_string(temp_dir.path().join("index.json")).unwrap(),
+            "{\"a\": 1}"
+        );
+        assert_eq!(
+            std::fs::read_to_string(&overrides).unwrap(),
+            "{ kind+: \"!\" }"
+        );
+
+        assert_eq!(
+            format_directory(temp_dir.path(), Formatter::None, None, &none).unwrap(),
+            0
+        );
+
+        // Files written formatted are not formatted again, so no jsonnetfmt
+        // runs when every file was
+        std::fs::write(&file, "{ kind: \"User\" }").unwrap();
+        let written = HashSet::from([file.clone()]);
+        let command = Path::new("/nonexistent/jsonnetfmt");
+        assert_eq!(
+            format_directory(
+                temp_dir.path(),
+                Formatter::Jsonnetfmt,
+                Some(command),
+                &written
+            )
+            .unwrap(),
+            0
+        );
+        assert_eq!(
+            std::fs::read_to_string(&file).unwrap(),
+            "{ kind: \"User\" }"
+        );
+    }
+}
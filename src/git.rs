@@ -98,7 +98,10 @@ impl GitManager {
         // Fetch from origin
         let mut remote = repo.find_remote("origin")?;
         remote.fetch(
-            &["refs/heads/*:refs/remotes/origin/*"],
+            &[
+                "refs/heads/*:refs/remotes/origin/*",
+                "refs/tags/*:refs/tags/*",
+            ],
             Some(&mut fetch_options),
             None,
         )?;
@@ -122,15 +125,23 @@ impl GitManager {
             repo.find_reference(ref_name)
                 .and_then(|r| r.peel_to_commit())
         } else {
-            // Try as a commit SHA first
-            if let Ok(oid) = ref_name.parse::<git2::Oid>() {
-                repo.find_commit(oid)
-            } else {
-                // Try as a branch
-                repo.find_branch(ref_name, git2::BranchType::Local)
-                    .or_else(|_| repo.find_branch(ref_name, git2::BranchType::Remote))
-                    .and_then(|branch| branch.get().peel_to_commit())
-            }
+            // Try as a commit SHA first, then as a branch, a tag or any revision
+            ref_name
+                .parse::<git2::Oid>()
+                .and_then(|oid| repo.find_commit(oid))
+                .or_else(|_| {
+                    repo.find_branch(ref_name, git2::BranchType::Local)
+                        .or_else(|_| repo.find_branch(ref_name, git2::BranchType::Remote))
+                        .and_then(|branch| branch.get().peel_to_commit())
+                })
+                .or_else(|_| {
+                    repo.find_reference(&format!("refs/tags/{ref_name}"))
+                        .and_then(|r| r.peel_to_commit())
+                })
+                .or_else(|_| {
+                    repo.revparse_single(ref_name)
+                        .and_then(|object| object.peel_to_commit())
+                })
         }?;
 
         // Checkout the reference
@@ -189,10 +200,108 @@ impl GitManager {
     }
 }
 
+/// Read a file as of a Git reference of the repository holding it
+///
+/// The repository is found from the file's directory, which must exist;
+/// `None` when the file is not in the tree of the reference.
+pub fn read_file_at(path: &Path, git_ref: &str) -> Result<Option<String>> {
+    let dir = path.parent().unwrap_or(Path::new("."));
+    let repo = Repository::discover(dir)?;
+    let workdir = repo
+        .workdir()
+        .ok_or_else(|| anyhow!("Repository of {:?} has no working tree", path))?
+        .canonicalize()?;
+    let file = dir
+        .canonicalize()?
+        .join(path.file_name().unwrap_or_default());
+    let relative = file
+        .strip_prefix(&workdir)
+        .map_err(|_| anyhow!("{:?} is not in the working tree {:?}", path, workdir))?;
+
+    let tree = repo
+        .revparse_single(git_ref)
+        .map_err(|e| anyhow!("Unknown Git reference {}: {}", git_ref, e))?
+        .peel_to_tree()?;
+    let entry = match tree.get_path(relative) {
+        Ok(entry) => entry,
+        Err(e) if e.code() == git2::ErrorCode::NotFound => return Ok(None),
+        Err(e) => return Err(e.into()),
+    };
+    let blob = entry.to_object(&repo)?.peel_to_blob()?;
+    Ok(Some(String::from_utf8_lossy(blob.content()).into_owned()))
+}
+
+/// Get the Git reference of a Go module version
+///
+/// Releases are tags of the same name; pseudo-versions such as
+/// `v0.0.0-20240101120000-abcdef123456` end with the commit they were taken
+/// from, and `+incompatible` only matters to the Go toolchain.
+pub fn version_ref(version: &str) -> String {
+    let version = version.trim_end_matches("+incompatible");
+    if let Some((rest, commit)) = version.rsplit_once('-') {
+        let timestamp = rest.rsplit(['-', '.']).next().unwrap_or("");
+        if commit.len() == 12
+            && commit.chars().all(|c| c.is_ascii_hexdigit())
+            && timestamp.len() == 14
+            && timestamp.chars().all(|c| c.is_ascii_digit())
+        {
+            return commit.to_string();
+        }
+    }
+    version.to_string()
+}
+
+/// Create a repository with one commit of `files`, for tests
+///
+/// The commit is made with the `git` command; returns `None` when it is not
+/// installed.
+#[cfg(test)]
+pub(crate) fn test_repository(files: &[(&str, &str)]) -> Option<tempfile::TempDir> {
+    let dir = tempfile::tempdir().unwrap();
+    for (file, content) in files {
+        let path = dir.path().join(file);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, content).unwrap();
+    }
+    let git = |args: &[&str]| {
+        std::process::Command::new("git")
+            .args(["-c", "user.name=test", "-c", "user.email=test@example.com"])
+            .args(args)
+            .current_dir(dir.path())
+            .output()
+            .is_ok_and(|output| output.status.success())
+    };
+    if !git(&["-c", "init.defaultBranch=main", "init"]) {
+        eprintln!("git not found, skipping");
+        return None;
+    }
+    assert!(git(&["add", "."]) && git(&["commit", "-m", "test"]));
+    Some(dir)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_version_ref() {
+        assert_eq!(version_ref("v1.2.3"), "v1.2.3");
+        assert_eq!(version_ref("release-1.28"), "release-1.28");
+        assert_eq!(version_ref("v2.0.0+incompatible"), "v2.0.0");
+        assert_eq!(
+            version_ref("v0.0.0-20240101120000-abcdef123456"),
+            "abcdef123456"
+        );
+        assert_eq!(
+            version_ref("v1.3.0-rc.1.0.20240101120000-abcdef123456"),
+            "abcdef123456"
+        );
+        assert_eq!(
+            version_ref("v1.0.0-beta-abcdef123456"),
+            "v1.0.0-beta-abcdef123456"
+        );
+    }
+
     #[test]
     fn test_hash_repo_url() {
         let manager = GitManager::new().unwrap();
@@ -0,0 +1,185 @@
+//! Go package embedding generated libraries
+//!
+//! With `go_embed` in the `generation` section, each output directory gets an
+//! `embed.go` declaring its Jsonnet files as an `embed.FS`, so a Go program
+//! rendering Jsonnet at runtime, such as an operator, imports the directory
+//! as a package and ships the libraries inside its binary:
+//!
+//! ```go
+//! import widgets "github.com/acme/operator/generated/widgets"
+//!
+//! code, err := fs.ReadFile(widgets.FS(), "index.libsonnet")
+//! ```
+//!
+//! The patterns of the `//go:embed` directive are those of the files written,
+//! since the directive fails on a pattern matching no file.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeSet;
+use std::path::Path;
+use walkdir::WalkDir;
+
+use crate::plugin::ast::generator::is_identifier;
+use crate::utils::is_jsonnet;
+
+/// File name of the Go source, inside the output directory
+pub const EMBED_FILE: &str = "embed.go";
+
+/// Package name used when the output directory gives none
+const DEFAULT_PACKAGE: &str = "jsonnetlib";
+
+/// Options of the Go package of each output directory
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct GoEmbed {
+    /// Name of the Go package, the name of the output directory by default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub package: Option<String>,
+}
+
+impl GoEmbed {
+    pub fn validate(&self) -> Result<()> {
+        match &self.package {
+            Some(package) if !is_identifier(package) => Err(anyhow!(
+                "go_embed.package is not a Go package name: {}",
+                package
+            )),
+            _ => Ok(()),
+        }
+    }
+
+    /// Package name of an output directory
+    ///
+    /// Derived from the directory name, lowercased and without the characters
+    /// Go package names cannot hold (`user-api` becomes `userapi`).
+    pub fn package_name(&self, dir: &Path) -> String {
+        if let Some(package) = &self.package {
+            return package.clone();
+        }
+        let name: String = dir
+            .file_name()
+            .map(|name| name.to_string_lossy().to_lowercase())
+            .unwrap_or_default()
+            .chars()
+            .filter(|c| c.is_ascii_alphanumeric() || *c == '_')
+            .collect();
+        if is_identifier(&name) {
+            name
+        } else {
+            DEFAULT_PACKAGE.to_string()
+        }
+    }
+}
+
+/// Patterns of the `//go:embed` directive of a directory
+///
+/// One per extension of the Jsonnet files of each directory, relative to
+/// `dir` and sorted (`*.libsonnet`, `_custom/*.libsonnet`).
+pub fn embed_patterns(dir: &Path) -> Result<Vec<String>> {
+    let mut patterns = BTreeSet::new();
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        let path = entry.path();
+        if !entry.file_type().is_file() || !is_jsonnet(path) {
+            continue;
+        }
+        let relative = path.strip_prefix(dir)?;
+        let extension = path.extension().unwrap_or_default().to_string_lossy();
+        let pattern = match relative.parent().filter(|p| !p.as_os_str().is_empty()) {
+            Some(parent) => format!("{}/*.{}", parent.to_string_lossy(), extension),
+            None => format!("*.{extension}"),
+        };
+        patterns.insert(pattern.replace('\\', "/"));
+    }
+    Ok(patterns.into_iter().collect())
+}
+
+/// Render the Go source embedding the Jsonnet files matched by `patterns`
+pub fn go_source(package: &str, patterns: &[String]) -> String {
+    format!(
+        r#"// Code generated by gensonnet. DO NOT EDIT.
+
+// Package {package} embeds the Jsonnet libraries generated by gensonnet.
+package {package}
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed {patterns}
+var files embed.FS
+
+// FS returns the generated libraries, by their path in the output directory.
+func FS() fs.FS {{
+	return files
+}}
+
+// ReadFile returns the code of a generated library, such as "index.libsonnet".
+func ReadFile(name string) ([]byte, error) {{
+	return files.ReadFile(name)
+}}
+"#,
+        patterns = patterns.join(" ")
+    )
+}
+
+/// Write the `embed.go` of an output directory
+///
+/// Returns the patterns embedded; a directory without Jsonnet files gets no
+/// file, since Go rejects a directive matching nothing.
+pub fn write_embed(dir: &Path, options: &GoEmbed) -> Result<Vec<String>> {
+    let patterns = embed_patterns(dir)?;
+    if patterns.is_empty() {
+        return Ok(patterns);
+    }
+    let source = go_source(&options.package_name(dir), &patterns);
+    crate::output::write_if_changed(&dir.join(EMBED_FILE), source)?;
+    Ok(patterns)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_write_embed() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path().join("user-api");
+        std::fs::create_dir_all(dir.join("_custom")).unwrap();
+        std::fs::write(dir.join("index.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("user_test.jsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("_custom/user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("jsonnetfile.json"), "{}\n").unwrap();
+
+        let patterns = write_embed(&dir, &GoEmbed::default()).unwrap();
+        assert_eq!(
+            patterns,
+            vec!["*.jsonnet", "*.libsonnet", "_custom/*.libsonnet"]
+        );
+        let source = std::fs::read_to_string(dir.join(EMBED_FILE)).unwrap();
+        assert!(source.contains("\npackage userapi\n"));
+        assert!(source.contains("\n//go:embed *.jsonnet *.libsonnet _custom/*.libsonnet\n"));
+        assert!(source.contains("func FS() fs.FS {\n"));
+
+        let named = GoEmbed {
+            package: Some("widgets".to_string()),
+        };
+        assert_eq!(named.package_name(&dir), "widgets");
+        assert_eq!(
+            GoEmbed::default().package_name(Path::new("1.2")),
+            "jsonnetlib"
+        );
+
+        let empty = temp_dir.path().join("empty");
+        std::fs::create_dir_all(&empty).unwrap();
+        assert!(write_embed(&empty, &GoEmbed::default()).unwrap().is_empty());
+        assert!(!empty.join(EMBED_FILE).exists());
+
+        let invalid = GoEmbed {
+            package: Some("user-api".to_string()),
+        };
+        assert!(invalid.validate().is_err());
+    }
+}
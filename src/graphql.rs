@@ -0,0 +1,990 @@
+//! GraphQL schemas as a source
+//!
+//! A `graphql` source reads the SDL files of a schema and generates a
+//! library per object, input, enum, interface and union type, like the Go
+//! types: fields that are non-null and have no default are the parameters
+//! of `new()`, the others have setters, and nullable fields accept `null`.
+//!
+//! ```graphql
+//! enum Role { ADMIN MEMBER }
+//!
+//! input CreateUserInput {
+//!   name: String!
+//!   role: Role = MEMBER
+//! }
+//!
+//! union SearchResult = User | Post
+//! ```
+//!
+//! Enums hold their values by name (`role.admin == "ADMIN"`). Unions and
+//! interfaces get the constructors of their member types, which set the
+//! `__typename` discriminator. All files of a source form one schema, so
+//! `extend` definitions may add to types of another file.
+
+use anyhow::{anyhow, Result};
+use std::collections::{HashMap, HashSet};
+use std::path::{Path, PathBuf};
+
+use crate::plugin::ast::aliases::STRUCT_REF_KEY;
+use crate::plugin::ast::GoJsonnetGenerator;
+use crate::plugin::ExtractedSchema;
+
+/// Schema type of the types of a GraphQL schema
+pub const GRAPHQL_SCHEMA_TYPE: &str = "graphql_type";
+
+/// Field telling the member types of unions and interfaces apart
+const DISCRIMINATOR: &str = "__typename";
+
+/// Reason of `@deprecated` without one, as in the GraphQL specification
+const DEFAULT_DEPRECATION: &str = "No longer supported";
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Name(String),
+    String(String),
+    Number(String),
+    Punct(char),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Kind {
+    Object,
+    Input,
+    Interface,
+    Enum,
+    Union,
+    Scalar,
+}
+
+impl Kind {
+    /// Category of the types of this kind in the index
+    fn category(self) -> &'static str {
+        match self {
+            Kind::Object => "types",
+            Kind::Input => "inputs",
+            Kind::Enum => "enums",
+            Kind::Interface | Kind::Union => "unions",
+            Kind::Scalar => "scalars",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum TypeRef {
+    Named(String),
+    List(Box<TypeRef>),
+    NonNull(Box<TypeRef>),
+}
+
+#[derive(Debug)]
+struct Field {
+    name: String,
+    description: Option<String>,
+    field_type: TypeRef,
+    default: Option<serde_yaml::Value>,
+    deprecated: Option<String>,
+}
+
+#[derive(Debug)]
+struct Definition {
+    kind: Kind,
+    name: String,
+    description: Option<String>,
+    deprecated: Option<String>,
+    implements: Vec<String>,
+    fields: Vec<Field>,
+    values: Vec<String>,
+    members: Vec<String>,
+    file: PathBuf,
+    line: usize,
+}
+
+impl Definition {
+    /// Add the fields, values and members of an extension
+    fn extend(&mut self, extension: Definition) {
+        self.implements.extend(extension.implements);
+        self.fields.extend(extension.fields);
+        self.values.extend(extension.values);
+        self.members.extend(extension.members);
+    }
+}
+
+/// Parse the types of the SDL files of a schema
+///
+/// `package` names the schema in the index.
+pub fn parse_schema(files: &[(PathBuf, String)], package: &str) -> Result<Vec<ExtractedSchema>> {
+    let mut definitions: Vec<Definition> = Vec::new();
+    let mut extended: HashSet<String> = HashSet::new();
+    for (file, sdl) in files {
+        let parsed = Parser::new(sdl)
+            .and_then(|mut parser| parser.document(file))
+            .map_err(|e| anyhow!("{}: {}", file.display(), e))?;
+        for (extend, definition) in parsed {
+            match definitions.iter_mut().find(|d| d.name == definition.name) {
+                Some(existing) if extend => existing.extend(definition),
+                // Extensions read before the type are merged into it
+                Some(existing) if extended.remove(&definition.name) => {
+                    let extensions = std::mem::replace(existing, definition);
+                    existing.extend(extensions);
+                }
+                Some(_) => {
+                    return Err(anyhow!(
+                        "{}:{}: type {} is declared twice",
+                        definition.file.display(),
+                        definition.line,
+                        definition.name
+                    ))
+                }
+                None => {
+                    if extend {
+                        extended.insert(definition.name.clone());
+                    }
+                    definitions.push(definition);
+                }
+            }
+        }
+    }
+
+    let kinds: HashMap<&str, Kind> = definitions
+        .iter()
+        .map(|d| (d.name.as_str(), d.kind))
+        .collect();
+    let enums: HashMap<&str, &Definition> = definitions
+        .iter()
+        .filter(|d| d.kind == Kind::Enum)
+        .map(|d| (d.name.as_str(), d))
+        .collect();
+
+    let mut schemas: Vec<ExtractedSchema> = definitions
+        .iter()
+        .filter(|d| d.kind != Kind::Scalar)
+        .map(|definition| {
+            let content = match definition.kind {
+                Kind::Enum => enum_schema(definition),
+                _ => object_schema(definition, &kinds, &enums),
+            };
+            let mut metadata = HashMap::new();
+            metadata.insert("package".to_string(), package.into());
+            metadata.insert(
+                "categories".to_string(),
+                vec![definition.kind.category()].into(),
+            );
+            metadata.insert("line".to_string(), (definition.line as u64).into());
+            if let Some(summary) = definition
+                .description
+                .as_deref()
+                .and_then(|d| d.lines().next())
+            {
+                metadata.insert("summary".to_string(), summary.into());
+            }
+            ExtractedSchema {
+                name: definition.name.clone(),
+                schema_type: GRAPHQL_SCHEMA_TYPE.to_string(),
+                content,
+                source_file: definition.file.clone(),
+                metadata,
+            }
+        })
+        .collect();
+
+    // Unions and interfaces are built with the constructors of their members
+    let generator = GoJsonnetGenerator::new();
+    let mut unions = Vec::new();
+    for (idx, schema) in schemas.iter().enumerate() {
+        let Some(definition) = definitions.iter().find(|d| d.name == schema.name) else {
+            continue;
+        };
+        let members: Vec<&str> = match definition.kind {
+            Kind::Union => definition.members.iter().map(String::as_str).collect(),
+            Kind::Interface => {
+                let mut members: Vec<&str> = definitions
+                    .iter()
+                    .filter(|d| d.kind == Kind::Object && d.implements.contains(&definition.name))
+                    .map(|d| d.name.as_str())
+                    .collect();
+                members.sort();
+                members
+            }
+            _ => continue,
+        };
+        let variants: Vec<serde_yaml::Value> = members
+            .iter()
+            .filter_map(|member| schemas.iter().find(|s| s.name == *member))
+            .map(|member| {
+                let mut variant = serde_yaml::Mapping::new();
+                variant.insert("name".into(), member.name.as_str().into());
+                variant.insert("file".into(), generator.file_name(member).into());
+                variant.insert("value".into(), member.name.as_str().into());
+                variant.insert(
+                    "params".into(),
+                    serde_yaml::Value::Sequence(
+                        generator
+                            .new_params(member)
+                            .into_iter()
+                            .map(serde_yaml::Value::String)
+                            .collect(),
+                    ),
+                );
+                serde_yaml::Value::Mapping(variant)
+            })
+            .collect();
+        if variants.is_empty() {
+            continue;
+        }
+        let mut union = serde_yaml::Mapping::new();
+        union.insert("discriminator".into(), DISCRIMINATOR.into());
+        union.insert("variants".into(), variants.into());
+        unions.push((idx, union));
+    }
+    for (idx, union) in unions {
+        if let Some(content) = schemas[idx].content.as_mapping_mut() {
+            content.insert("x-go-union".into(), union.into());
+        }
+    }
+
+    Ok(schemas)
+}
+
+/// Convert an object, input, interface or union type to a schema
+fn object_schema(
+    definition: &Definition,
+    kinds: &HashMap<&str, Kind>,
+    enums: &HashMap<&str, &Definition>,
+) -> serde_yaml::Value {
+    let mut properties = serde_yaml::Mapping::new();
+    let mut required = Vec::new();
+    for field in &definition.fields {
+        let mut property = type_schema(&field.field_type, kinds, enums);
+        if let Some(description) = &field.description {
+            property.insert("description".into(), description.as_str().into());
+        }
+        if let Some(default) = &field.default {
+            property.insert("default".into(), default.clone());
+        }
+        if let Some(reason) = &field.deprecated {
+            property.insert("x-go-deprecated".into(), reason.as_str().into());
+        }
+        if matches!(field.field_type, TypeRef::NonNull(_)) && field.default.is_none() {
+            property.insert("x-go-constructor-param".into(), true.into());
+            required.push(serde_yaml::Value::from(field.name.as_str()));
+        }
+        properties.insert(field.name.as_str().into(), property.into());
+    }
+
+    let mut schema = serde_yaml::Mapping::new();
+    schema.insert("type".into(), "object".into());
+    describe(&mut schema, definition);
+    if !required.is_empty() {
+        schema.insert("required".into(), required.into());
+    }
+    schema.insert("properties".into(), properties.into());
+    schema.into()
+}
+
+/// Convert an enum type to a schema, with the values named as Go constants
+fn enum_schema(definition: &Definition) -> serde_yaml::Value {
+    let values: Vec<serde_yaml::Value> = definition
+        .values
+        .iter()
+        .enumerate()
+        .map(|(idx, value)| {
+            let mut entry = serde_yaml::Mapping::new();
+            entry.insert("name".into(), pascal_case(value).into());
+            entry.insert("value".into(), (idx as i64).into());
+            entry.insert("string".into(), value.as_str().into());
+            entry.into()
+        })
+        .collect();
+    let mut enumeration = serde_yaml::Mapping::new();
+    enumeration.insert("flags".into(), false.into());
+    enumeration.insert("names".into(), true.into());
+    enumeration.insert("values".into(), values.into());
+
+    let mut schema = serde_yaml::Mapping::new();
+    schema.insert("type".into(), "string".into());
+    describe(&mut schema, definition);
+    schema.insert(
+        "enum".into(),
+        definition
+            .values
+            .iter()
+            .map(|v| serde_yaml::Value::from(v.as_str()))
+            .collect::<Vec<_>>()
+            .into(),
+    );
+    schema.insert("x-go-enum".into(), enumeration.into());
+    schema.into()
+}
+
+fn describe(schema: &mut serde_yaml::Mapping, definition: &Definition) {
+    if let Some(description) = &definition.description {
+        schema.insert("description".into(), description.as_str().into());
+    }
+    if let Some(reason) = &definition.deprecated {
+        schema.insert("x-go-deprecated".into(), reason.as_str().into());
+    }
+}
+
+/// Convert the type of a field to a schema
+///
+/// Custom scalars take any value and have no type.
+fn type_schema(
+    field_type: &TypeRef,
+    kinds: &HashMap<&str, Kind>,
+    enums: &HashMap<&str, &Definition>,
+) -> serde_yaml::Mapping {
+    let (inner, nullable) = match field_type {
+        TypeRef::NonNull(inner) => (inner.as_ref(), false),
+        other => (other, true),
+    };
+    let mut schema = serde_yaml::Mapping::new();
+    match inner {
+        TypeRef::List(items) => {
+            schema.insert("type".into(), "array".into());
+            schema.insert("items".into(), type_schema(items, kinds, enums).into());
+        }
+        TypeRef::Named(name) => match name.as_str() {
+            "Int" => {
+                schema.insert("type".into(), "integer".into());
+            }
+            "Float" => {
+                schema.insert("type".into(), "number".into());
+            }
+            "String" | "ID" => {
+                schema.insert("type".into(), "string".into());
+            }
+            "Boolean" => {
+                schema.insert("type".into(), "boolean".into());
+            }
+            _ => match kinds.get(name.as_str()) {
+                Some(Kind::Enum) => {
+                    schema.insert("type".into(), "string".into());
+                    if let Some(definition) = enums.get(name.as_str()) {
+                        let values: Vec<serde_yaml::Value> = definition
+                            .values
+                            .iter()
+                            .map(|v| v.as_str().into())
+                            .collect();
+                        schema.insert("enum".into(), values.into());
+                    }
+                }
+                Some(Kind::Object | Kind::Input) => {
+                    schema.insert("type".into(), "object".into());
+                    schema.insert(STRUCT_REF_KEY.into(), name.as_str().into());
+                }
+                Some(Kind::Interface | Kind::Union) => {
+                    schema.insert("type".into(), "object".into());
+                    schema.insert("x-go-interface-ref".into(), name.as_str().into());
+                }
+                Some(Kind::Scalar) | None => {}
+            },
+        },
+        TypeRef::NonNull(_) => {}
+    }
+    if nullable {
+        schema.insert("nullable".into(), true.into());
+    }
+    schema
+}
+
+/// Convert an enum value (`IN_PROGRESS`) to the name of a Go constant (`InProgress`)
+fn pascal_case(name: &str) -> String {
+    name.split('_')
+        .filter(|word| !word.is_empty())
+        .map(|word| {
+            let lower = word.to_ascii_lowercase();
+            let mut chars = lower.chars();
+            match chars.next() {
+                Some(first) => first.to_ascii_uppercase().to_string() + chars.as_str(),
+                None => String::new(),
+            }
+        })
+        .collect()
+}
+
+/// Recursive descent parser of the type system definitions of SDL
+struct Parser {
+    tokens: Vec<(Token, usize)>,
+    pos: usize,
+}
+
+impl Parser {
+    fn new(sdl: &str) -> Result<Self> {
+        Ok(Self {
+            tokens: tokenize(sdl)?,
+            pos: 0,
+        })
+    }
+
+    /// Parse the definitions of a file, flagging extensions
+    fn document(&mut self, file: &Path) -> Result<Vec<(bool, Definition)>> {
+        let mut definitions = Vec::new();
+        while self.pos < self.tokens.len() {
+            let description = self.description();
+            let line = self.line();
+            let mut keyword = self.name()?;
+            let extend = keyword == "extend";
+            if extend {
+                keyword = self.name()?;
+            }
+            let kind = match keyword.as_str() {
+                "type" => Kind::Object,
+                "input" => Kind::Input,
+                "interface" => Kind::Interface,
+                "enum" => Kind::Enum,
+                "union" => Kind::Union,
+                "scalar" => Kind::Scalar,
+                "schema" => {
+                    self.directives()?;
+                    self.skip_group('{', '}')?;
+                    continue;
+                }
+                "directive" => {
+                    self.directive_definition()?;
+                    continue;
+                }
+                other => return Err(anyhow!("line {}: unexpected {}", line, other)),
+            };
+
+            let name = self.name()?;
+            let mut implements = Vec::new();
+            if self.peek_name("implements") {
+                self.pos += 1;
+                self.eat('&');
+                implements.push(self.name()?);
+                while self.eat('&') {
+                    implements.push(self.name()?);
+                }
+            }
+            let deprecated = self.directives()?;
+
+            let mut fields = Vec::new();
+            let mut values = Vec::new();
+            let mut members = Vec::new();
+            match kind {
+                Kind::Object | Kind::Input | Kind::Interface if self.eat('{') => {
+                    while !self.eat('}') {
+                        fields.push(self.field()?);
+                    }
+                }
+                Kind::Enum if self.eat('{') => {
+                    // Descriptions and directives of values are not kept
+                    while !self.eat('}') {
+                        self.description();
+                        values.push(self.name()?);
+                        self.directives()?;
+                    }
+                }
+                Kind::Union if self.eat('=') => {
+                    self.eat('|');
+                    members.push(self.name()?);
+                    while self.eat('|') {
+                        members.push(self.name()?);
+                    }
+                }
+                _ => {}
+            }
+
+            definitions.push((
+                extend,
+                Definition {
+                    kind,
+                    name,
+                    description,
+                    deprecated,
+                    implements,
+                    fields,
+                    values,
+                    members,
+                    file: file.to_path_buf(),
+                    line,
+                },
+            ));
+        }
+        Ok(definitions)
+    }
+
+    /// Parse a field of an object, input or interface type
+    ///
+    /// Arguments of fields are skipped: they are not part of the values.
+    fn field(&mut self) -> Result<Field> {
+        let description = self.description();
+        let name = self.name()?;
+        if self.peek('(') {
+            self.skip_group('(', ')')?;
+        }
+        self.expect(':')?;
+        let field_type = self.type_ref()?;
+        let default = if self.eat('=') {
+            Some(self.value()?)
+        } else {
+            None
+        };
+        let deprecated = self.directives()?;
+        Ok(Field {
+            name,
+            description,
+            field_type,
+            default,
+            deprecated,
+        })
+    }
+
+    fn type_ref(&mut self) -> Result<TypeRef> {
+        let inner = if self.eat('[') {
+            let items = self.type_ref()?;
+            self.expect(']')?;
+            TypeRef::List(Box::new(items))
+        } else {
+            TypeRef::Named(self.name()?)
+        };
+        if self.eat('!') {
+            return Ok(TypeRef::NonNull(Box::new(inner)));
+        }
+        Ok(inner)
+    }
+
+    /// Parse a constant value; enum values are their names
+    fn value(&mut self) -> Result<serde_yaml::Value> {
+        let line = self.line();
+        match self.next() {
+            Some(Token::String(value)) => Ok(value.into()),
+            Some(Token::Number(number)) => match number.parse::<i64>() {
+                Ok(integer) => Ok(integer.into()),
+                Err(_) => number
+                    .parse::<f64>()
+                    .map(Into::into)
+                    .map_err(|_| anyhow!("line {}: invalid number {}", line, number)),
+            },
+            Some(Token::Name(name)) => Ok(match name.as_str() {
+                "true" => true.into(),
+                "false" => false.into(),
+                "null" => serde_yaml::Value::Null,
+                _ => name.into(),
+            }),
+            Some(Token::Punct('[')) => {
+                let mut items = Vec::new();
+                while !self.eat(']') {
+                    items.push(self.value()?);
+                }
+                Ok(items.into())
+            }
+            Some(Token::Punct('{')) => {
+                let mut object = serde_yaml::Mapping::new();
+                while !self.eat('}') {
+                    let key = self.name()?;
+                    self.expect(':')?;
+                    object.insert(key.into(), self.value()?);
+                }
+                Ok(object.into())
+            }
+            other => Err(anyhow!(
+                "line {}: expected a value, found {:?}",
+                line,
+                other
+            )),
+        }
+    }
+
+    /// Parse the directives of a definition, returning the `@deprecated` reason
+    fn directives(&mut self) -> Result<Option<String>> {
+        let mut deprecated = None;
+        while self.eat('@') {
+            let name = self.name()?;
+            let mut reason = None;
+            if self.eat('(') {
+                while !self.eat(')') {
+                    let argument = self.name()?;
+                    self.expect(':')?;
+                    let value = self.value()?;
+                    if argument == "reason" {
+                        reason = value.as_str().map(str::to_string);
+                    }
+                }
+            }
+            if name == "deprecated" {
+                deprecated = Some(reason.unwrap_or_else(|| DEFAULT_DEPRECATION.to_string()));
+            }
+        }
+        Ok(deprecated)
+    }
+
+    /// Skip `@name(arguments) repeatable on LOCATION | ...`
+    fn directive_definition(&mut self) -> Result<()> {
+        self.expect('@')?;
+        self.name()?;
+        if self.peek('(') {
+            self.skip_group('(', ')')?;
+        }
+        if self.peek_name("repeatable") {
+            self.pos += 1;
+        }
+        if self.name()? != "on" {
+            return Err(anyhow!("line {}: expected on", self.line()));
+        }
+        self.eat('|');
+        self.name()?;
+        while self.eat('|') {
+            self.name()?;
+        }
+        Ok(())
+    }
+
+    /// Skip a balanced group of tokens
+    fn skip_group(&mut self, open: char, close: char) -> Result<()> {
+        self.expect(open)?;
+        let mut depth = 1;
+        while depth > 0 {
+            match self.next() {
+                Some(Token::Punct(c)) if c == open => depth += 1,
+                Some(Token::Punct(c)) if c == close => depth -= 1,
+                Some(_) => {}
+                None => return Err(anyhow!("unterminated {}", open)),
+            }
+        }
+        Ok(())
+    }
+
+    fn description(&mut self) -> Option<String> {
+        match self.tokens.get(self.pos) {
+            Some((Token::String(description), _)) => {
+                let description = description.trim().to_string();
+                self.pos += 1;
+                Some(description).filter(|d| !d.is_empty())
+            }
+            _ => None,
+        }
+    }
+
+    fn name(&mut self) -> Result<String> {
+        let line = self.line();
+        match self.next() {
+            Some(Token::Name(name)) => Ok(name),
+            other => Err(anyhow!("line {}: expected a name, found {:?}", line, other)),
+        }
+    }
+
+    fn expect(&mut self, punct: char) -> Result<()> {
+        if self.eat(punct) {
+            return Ok(());
+        }
+        Err(anyhow!("line {}: expected {}", self.line(), punct))
+    }
+
+    fn eat(&mut self, punct: char) -> bool {
+        if self.peek(punct) {
+            self.pos += 1;
+            return true;
+        }
+        false
+    }
+
+    fn peek(&self, punct: char) -> bool {
+        matches!(self.tokens.get(self.pos), Some((Token::Punct(c), _)) if *c == punct)
+    }
+
+    fn peek_name(&self, name: &str) -> bool {
+        matches!(self.tokens.get(self.pos), Some((Token::Name(n), _)) if n == name)
+    }
+
+    fn next(&mut self) -> Option<Token> {
+        let token = self.tokens.get(self.pos).map(|(token, _)| token.clone());
+        self.pos += 1;
+        token
+    }
+
+    fn line(&self) -> usize {
+        self.tokens
+            .get(self.pos)
+            .or(self.tokens.last())
+            .map(|(_, line)| *line)
+            .unwrap_or(1)
+    }
+}
+
+/// Split SDL into tokens with their line; commas and comments are ignored
+fn tokenize(sdl: &str) -> Result<Vec<(Token, usize)>> {
+    let chars: Vec<char> = sdl.chars().collect();
+    let mut tokens = Vec::new();
+    let mut line = 1;
+    let mut i = 0;
+    while i < chars.len() {
+        let c = chars[i];
+        match c {
+            '\n' => {
+                line += 1;
+                i += 1;
+            }
+            c if c.is_whitespace() || c == ',' || c == '\u{feff}' => i += 1,
+            '#' => {
+                while i < chars.len() && chars[i] != '\n' {
+                    i += 1;
+                }
+            }
+            '"' if chars[i..].starts_with(&['"', '"', '"']) => {
+                let start = line;
+                i += 3;
+                let mut text = String::new();
+                loop {
+                    if i >= chars.len() {
+                        return Err(anyhow!("line {}: unterminated block string", start));
+                    }
+                    if chars[i..].starts_with(&['"', '"', '"']) {
+                        i += 3;
+                        break;
+                    }
+                    if chars[i..].starts_with(&['\\', '"', '"', '"']) {
+                        text.push_str("\"\"\"");
+                        i += 4;
+                        continue;
+                    }
+                    if chars[i] == '\n' {
+                        line += 1;
+                    }
+                    text.push(chars[i]);
+                    i += 1;
+                }
+                tokens.push((Token::String(dedent(&text)), start));
+            }
+            '"' => {
+                i += 1;
+                let mut text = String::new();
+                loop {
+                    match chars.get(i) {
+                        None | Some('\n') => {
+                            return Err(anyhow!("line {}: unterminated string", line))
+                        }
+                        Some('"') => {
+                            i += 1;
+                            break;
+                        }
+                        Some('\\') => {
+                            match chars.get(i + 1) {
+                                Some('n') => text.push('\n'),
+                                Some('t') => text.push('\t'),
+                                Some('u') => {
+                                    let code: String = chars.iter().skip(i + 2).take(4).collect();
+                                    let decoded = u32::from_str_radix(&code, 16)
+                                        .ok()
+                                        .and_then(char::from_u32)
+                                        .ok_or_else(|| {
+                                            anyhow!("line {}: invalid escape \\u{}", line, code)
+                                        })?;
+                                    text.push(decoded);
+                                    i += 4;
+                                }
+                                Some(other) => text.push(*other),
+                                None => {}
+                            }
+                            i += 2;
+                        }
+                        Some(other) => {
+                            text.push(*other);
+                            i += 1;
+                        }
+                    }
+                }
+                tokens.push((Token::String(text), line));
+            }
+            c if c.is_ascii_alphabetic() || c == '_' => {
+                let start = i;
+                while i < chars.len() && (chars[i].is_ascii_alphanumeric() || chars[i] == '_') {
+                    i += 1;
+                }
+                tokens.push((Token::Name(chars[start..i].iter().collect()), line));
+            }
+            c if c.is_ascii_digit() || c == '-' => {
+                let start = i;
+                i += 1;
+                while i < chars.len()
+                    && (chars[i].is_ascii_alphanumeric() || matches!(chars[i], '.' | '+' | '-'))
+                {
+                    i += 1;
+                }
+                tokens.push((Token::Number(chars[start..i].iter().collect()), line));
+            }
+            '{' | '}' | '(' | ')' | '[' | ']' | ':' | '=' | '!' | '|' | '&' | '@' | '$' => {
+                tokens.push((Token::Punct(c), line));
+                i += 1;
+            }
+            other => return Err(anyhow!("line {}: unexpected character {}", line, other)),
+        }
+    }
+    Ok(tokens)
+}
+
+/// Remove the common indentation of a block string and its blank first
+/// and last lines
+fn dedent(text: &str) -> String {
+    let lines: Vec<&str> = text.lines().collect();
+    let indent = lines
+        .iter()
+        .skip(1)
+        .filter(|line| !line.trim().is_empty())
+        .map(|line| line.len() - line.trim_start().len())
+        .min()
+        .unwrap_or(0);
+    let lines: Vec<String> = lines
+        .iter()
+        .enumerate()
+        .map(|(idx, line)| match idx {
+            0 => line.trim_start().to_string(),
+            _ => line.get(indent..).unwrap_or("").to_string(),
+        })
+        .collect();
+    lines.join("\n").trim_matches('\n').to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SCHEMA: &str = r#"
+"A member of a workspace"
+type User implements Node {
+  id: ID!
+  name: String!
+  email: String @deprecated(reason: "Use contact")
+  role: Role!
+  friends(first: Int = 10): [User!]!
+}
+
+interface Node {
+  id: ID!
+}
+
+input CreateUserInput {
+  name: String!
+  role: Role = MEMBER
+  tags: [String!]
+}
+
+"""
+Roles of users
+"""
+enum Role {
+  ADMIN
+  MEMBER
+  READ_ONLY
+}
+
+type Post implements Node { id: ID!, title: String }
+
+union SearchResult = User | Post
+
+scalar DateTime
+
+directive @auth(requires: Role = ADMIN) on OBJECT | FIELD_DEFINITION
+"#;
+
+    fn parse(files: &[(&str, &str)]) -> Result<Vec<ExtractedSchema>> {
+        let files: Vec<(PathBuf, String)> = files
+            .iter()
+            .map(|(file, sdl)| (PathBuf::from(file), sdl.to_string()))
+            .collect();
+        parse_schema(&files, "api")
+    }
+
+    #[test]
+    fn test_parse_schema() {
+        let schemas = parse(&[("schema.graphql", SCHEMA)]).unwrap();
+        let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(
+            names,
+            vec![
+                "User",
+                "Node",
+                "CreateUserInput",
+                "Role",
+                "Post",
+                "SearchResult"
+            ]
+        );
+
+        let user = &schemas[0].content;
+        assert_eq!(
+            user["description"],
+            serde_yaml::Value::from("A member of a workspace")
+        );
+        let properties = &user["properties"];
+        assert_eq!(
+            properties["id"]["x-go-constructor-param"],
+            serde_yaml::Value::from(true)
+        );
+        assert_eq!(
+            properties["email"]["nullable"],
+            serde_yaml::Value::from(true)
+        );
+        assert_eq!(
+            properties["email"]["x-go-deprecated"],
+            serde_yaml::Value::from("Use contact")
+        );
+        assert_eq!(
+            properties["role"]["enum"][2],
+            serde_yaml::Value::from("READ_ONLY")
+        );
+        assert_eq!(
+            properties["friends"]["items"][STRUCT_REF_KEY],
+            serde_yaml::Value::from("User")
+        );
+
+        let input = &schemas[2].content["properties"];
+        assert_eq!(input["role"]["default"], serde_yaml::Value::from("MEMBER"));
+        assert!(input["role"].get("x-go-constructor-param").is_none());
+        assert!(input["tags"]["items"].get("nullable").is_none());
+
+        let role = &schemas[3].content;
+        assert_eq!(
+            role["description"],
+            serde_yaml::Value::from("Roles of users")
+        );
+        assert_eq!(
+            role["x-go-enum"]["values"][2]["name"],
+            serde_yaml::Value::from("ReadOnly")
+        );
+
+        let union = &schemas[5].content["x-go-union"];
+        assert_eq!(
+            union["discriminator"],
+            serde_yaml::Value::from("__typename")
+        );
+        assert_eq!(
+            union["variants"][0]["file"],
+            serde_yaml::Value::from("user.libsonnet")
+        );
+        assert_eq!(
+            union["variants"][1]["params"][0],
+            serde_yaml::Value::from("id")
+        );
+
+        // Interfaces are unions of the types implementing them
+        let node = &schemas[1].content["x-go-union"]["variants"];
+        assert_eq!(node[0]["name"], serde_yaml::Value::from("Post"));
+        assert_eq!(node[1]["name"], serde_yaml::Value::from("User"));
+    }
+
+    #[test]
+    fn test_parse_schema_extensions() {
+        let schemas = parse(&[
+            ("user.graphql", "type User { id: ID! }"),
+            ("profile.graphql", "extend type User { bio: String }"),
+        ])
+        .unwrap();
+        assert_eq!(schemas.len(), 1);
+        assert!(schemas[0].content["properties"].get("bio").is_some());
+
+        let error = parse(&[("a.graphql", "type A { id: ID! }\ntype A { id: ID! }")])
+            .unwrap_err()
+            .to_string();
+        assert!(error.contains("a.graphql:2: type A is declared twice"));
+        assert!(parse(&[("b.graphql", "type B { id: }")]).is_err());
+    }
+
+    #[test]
+    fn test_generate_graphql_enum() {
+        let schemas = parse(&[("schema.graphql", SCHEMA)]).unwrap();
+        let code = GoJsonnetGenerator::new().generate(&schemas[3]).unwrap();
+        assert!(code.contains("  readOnly:: \"READ_ONLY\",\n"));
+        assert!(code.contains("  values:: [\"ADMIN\", \"MEMBER\", \"READ_ONLY\"],\n"));
+    }
+}
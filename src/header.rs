@@ -0,0 +1,410 @@
+//! Headers recording what produced each generated file
+//!
+//! After generation, the leading comment of every Jsonnet file of an output
+//! directory gets the lines of a `Header`:
+//!
+//! ```jsonnet
+//! // Generated from Go AST: User
+//! // Generator: gensonnet 0.1.0
+//! // Config: sha256:3f1c...
+//! // Module: github.com/acme/api v1.4.0
+//! // Commit: 9b2e...
+//! ```
+//!
+//! so consumers can tell which inputs produced a library. With
+//! `generation.header_timestamp`, a `// Generated at:` line follows; it is left
+//! out of the hashes of the manifest, so `gensonnet check` ignores it.
+//!
+//! `generation.header_block` is text every generated file with comments
+//! starts with, between two marker lines, in the comment syntax of the file:
+//!
+//! ```jsonnet
+//! // BEGIN gensonnet header
+//! // Copyright 2026 Acme Corp. SPDX-License-Identifier: Apache-2.0
+//! // DO NOT EDIT: regenerate with `make generate`
+//! // END gensonnet header
+//! ```
+//!
+//! The block is not content either: the manifest hashes and plans leave it
+//! out, and a run changing only the block rewrites only the block.
+
+use anyhow::Result;
+use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::overrides::is_user_file;
+use crate::utils::is_jsonnet;
+
+/// Header lines, as their prefixes
+const GENERATOR_PREFIX: &str = "// Generator: ";
+const CONFIG_PREFIX: &str = "// Config: ";
+const MODULE_PREFIX: &str = "// Module: ";
+const COMMIT_PREFIX: &str = "// Commit: ";
+const TIMESTAMP_PREFIX: &str = "// Generated at: ";
+
+/// Comment lines around the header block, after the comment syntax
+const BLOCK_BEGIN: &str = "BEGIN gensonnet header";
+const BLOCK_END: &str = "END gensonnet header";
+
+/// Inputs of the files of an output directory
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Header {
+    /// Version of gensonnet
+    pub generator: String,
+
+    /// SHA-256 of the configuration of the source, as in the manifest
+    pub config_sha256: String,
+
+    /// Go module path, or another name of the input
+    pub module: Option<String>,
+
+    /// Version of the input, such as the configured git ref
+    pub version: Option<String>,
+
+    /// Commit of the checkout of the source
+    pub commit: Option<String>,
+
+    /// Time of the run, as RFC 3339
+    pub timestamp: Option<String>,
+}
+
+impl Header {
+    /// Header of the current generator
+    pub fn new(config_sha256: String) -> Self {
+        Self {
+            generator: env!("CARGO_PKG_VERSION").to_string(),
+            config_sha256,
+            ..Self::default()
+        }
+    }
+
+    /// Comment lines of the header
+    pub fn lines(&self) -> Vec<String> {
+        let mut lines = vec![
+            format!("{GENERATOR_PREFIX}gensonnet {}", self.generator),
+            format!("{CONFIG_PREFIX}sha256:{}", self.config_sha256),
+        ];
+        match (&self.module, &self.version) {
+            (Some(module), Some(version)) => {
+                lines.push(format!("{MODULE_PREFIX}{module} {version}"))
+            }
+            (Some(module), None) => lines.push(format!("{MODULE_PREFIX}{module}")),
+            (None, Some(version)) => lines.push(format!("{MODULE_PREFIX}{version}")),
+            (None, None) => {}
+        }
+        if let Some(commit) = &self.commit {
+            lines.push(format!("{COMMIT_PREFIX}{commit}"));
+        }
+        if let Some(timestamp) = &self.timestamp {
+            lines.push(format!("{TIMESTAMP_PREFIX}{timestamp}"));
+        }
+        lines
+    }
+}
+
+/// Whether a line of a leading comment is a header line
+fn is_header_line(line: &str) -> bool {
+    [
+        GENERATOR_PREFIX,
+        CONFIG_PREFIX,
+        MODULE_PREFIX,
+        COMMIT_PREFIX,
+        TIMESTAMP_PREFIX,
+    ]
+    .iter()
+    .any(|prefix| line.starts_with(prefix))
+}
+
+/// Put a header into the leading comment of Jsonnet code
+///
+/// The header replaces the header lines of the comment, in place of the
+/// first of them, or ends the comment; code without a leading comment gets
+/// the header as its comment.
+pub fn stamp_code(code: &str, header: &Header) -> String {
+    let lines: Vec<&str> = code.split_inclusive('\n').collect();
+    let comment = lines
+        .iter()
+        .take_while(|line| line.starts_with("//"))
+        .count();
+    let position = lines[..comment]
+        .iter()
+        .position(|line| is_header_line(line))
+        .unwrap_or(comment);
+
+    let mut stamped = String::with_capacity(code.len() + 256);
+    for (idx, line) in lines.iter().enumerate() {
+        if idx == position {
+            for header_line in header.lines() {
+                stamped.push_str(&header_line);
+                stamped.push('\n');
+            }
+        }
+        if idx >= comment || !is_header_line(line) {
+            stamped.push_str(line);
+        }
+    }
+    if position == lines.len() {
+        for header_line in header.lines() {
+            stamped.push_str(&header_line);
+            stamped.push('\n');
+        }
+    }
+    stamped
+}
+
+/// Code without the timestamp of its header
+pub fn without_timestamp(code: &str) -> String {
+    let mut in_comment = true;
+    code.split_inclusive('\n')
+        .filter(|line| {
+            in_comment &= line.starts_with("//");
+            !(in_comment && line.starts_with(TIMESTAMP_PREFIX))
+        })
+        .collect()
+}
+
+/// Start of the line comments of a generated file, for the files that
+/// have comments
+pub fn comment_prefix(path: &Path) -> Option<&'static str> {
+    match path.extension()?.to_str()? {
+        "jsonnet" | "libsonnet" | "go" | "ts" | "cue" => Some("//"),
+        "yaml" | "yml" | "sh" => Some("#"),
+        _ => None,
+    }
+}
+
+/// Whether a line is a marker of the header block
+fn is_block_marker(line: &str, marker: &str) -> bool {
+    line.trim_end()
+        .strip_suffix(marker)
+        .is_some_and(|prefix| matches!(prefix, "// " | "# "))
+}
+
+/// Split code into its `#!` line, its header block and the rest
+fn split_block(code: &str) -> (&str, &str, &str) {
+    let shebang = match code.starts_with("#!") {
+        true => code.find('\n').map_or(code.len(), |end| end + 1),
+        false => 0,
+    };
+    let rest = &code[shebang..];
+    let first = rest.split_inclusive('\n').next().unwrap_or_default();
+    if !is_block_marker(first, BLOCK_BEGIN) {
+        return (&code[..shebang], "", rest);
+    }
+    let mut end = 0;
+    for line in rest.split_inclusive('\n') {
+        end += line.len();
+        if is_block_marker(line, BLOCK_END) {
+            return (&code[..shebang], &rest[..end], &rest[end..]);
+        }
+    }
+    // A block without its end is left as it is
+    (&code[..shebang], "", rest)
+}
+
+/// Code without its header block
+pub fn without_block(code: &str) -> String {
+    let (shebang, _, rest) = split_block(code);
+    format!("{shebang}{rest}")
+}
+
+/// Put the header block at the top of code, after a `#!` line, replacing
+/// the block it has
+///
+/// Without a block, the one of the code is removed.
+pub fn insert_block(code: &str, block: Option<&str>, prefix: &str) -> String {
+    let (shebang, _, rest) = split_block(code);
+    let Some(block) = block else {
+        return format!("{shebang}{rest}");
+    };
+    let mut code = format!("{shebang}{prefix} {BLOCK_BEGIN}\n");
+    for line in block.trim_end().lines() {
+        match line.is_empty() {
+            true => code.push_str(&format!("{prefix}\n")),
+            false => code.push_str(&format!("{prefix} {line}\n")),
+        }
+    }
+    code.push_str(&format!("{prefix} {BLOCK_END}\n{rest}"));
+    code
+}
+
+/// Put a header into the generated Jsonnet files of an output directory,
+/// except the files in `written`, which were written stamped
+///
+/// Returns the number of files rewritten.
+pub fn stamp_directory(dir: &Path, header: &Header, written: &HashSet<PathBuf>) -> Result<usize> {
+    if !dir.is_dir() {
+        return Ok(0);
+    }
+    let mut stamped = 0;
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        let path = entry.path();
+        if !entry.file_type().is_file()
+            || !is_jsonnet(path)
+            || is_user_file(dir, path)
+            || written.contains(path)
+        {
+            continue;
+        }
+        let code = std::fs::read_to_string(path)?;
+        let new_code = stamp_code(&code, header);
+        if new_code != code {
+            std::fs::write(path, new_code)?;
+            stamped += 1;
+        }
+    }
+    Ok(stamped)
+}
+
+/// Put the header block into the generated files of an output directory
+/// that have comments, or remove it without a block
+///
+/// The files in `written` were written with the block and are left alone.
+/// Returns the number of files rewritten.
+pub fn block_directory(
+    dir: &Path,
+    block: Option<&str>,
+    written: &HashSet<PathBuf>,
+) -> Result<usize> {
+    if !dir.is_dir() {
+        return Ok(0);
+    }
+    let mut rewritten = 0;
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        let path = entry.path();
+        let Some(prefix) = comment_prefix(path) else {
+            continue;
+        };
+        if !entry.file_type().is_file() || is_user_file(dir, path) || written.contains(path) {
+            continue;
+        }
+        let code = std::fs::read_to_string(path)?;
+        let new_code = insert_block(&code, block, prefix);
+        if new_code != code {
+            std::fs::write(path, new_code)?;
+            rewritten += 1;
+        }
+    }
+    Ok(rewritten)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn header() -> Header {
+        Header {
+            module: Some("github.com/acme/api".to_string()),
+            version: Some("v1.4.0".to_string()),
+            commit: Some("9b2e".to_string()),
+            ..Header::new("3f1c".to_string())
+        }
+    }
+
+    #[test]
+    fn test_stamp_code() {
+        let generator = env!("CARGO_PKG_VERSION");
+        let code = format!(
+            "// Generated from Go AST: User\n// Generator: gensonnet {generator}\n// Note: see user.go\n{{\n  // Generator: kept\n}}\n"
+        );
+        let stamped = stamp_code(&code, &header());
+        assert_eq!(
+            stamped,
+            format!("// Generated from Go AST: User\n// Generator: gensonnet {generator}\n// Config: sha256:3f1c\n// Module: github.com/acme/api v1.4.0\n// Commit: 9b2e\n// Note: see user.go\n{{\n  // Generator: kept\n}}\n")
+        );
+        assert_eq!(stamp_code(&stamped, &header()), stamped);
+
+        // Formatting keeps the header, so files written stamped and
+        // formatted are left as they are by the steps after generation
+        let formatted = crate::format::format_code(&stamped);
+        assert_eq!(stamp_code(&formatted, &header()), formatted);
+
+        // Code without a header or a comment
+        assert!(stamp_code("// Index\n{}\n", &header())
+            .starts_with("// Index\n// Generator: gensonnet"));
+        assert!(stamp_code("{}\n", &header()).ends_with("// Commit: 9b2e\n{}\n"));
+        assert!(stamp_code("", &header()).ends_with("// Commit: 9b2e\n"));
+    }
+
+    #[test]
+    fn test_insert_block() {
+        let block = "Copyright 2026 Acme Corp.\n\nDO NOT EDIT: regenerate with `make generate`\n";
+        let code = "// Generated from Go AST: User\n{}\n";
+        let with_block = insert_block(code, Some(block), "//");
+        assert_eq!(
+            with_block,
+            "// BEGIN gensonnet header\n// Copyright 2026 Acme Corp.\n//\n// DO NOT EDIT: regenerate with `make generate`\n// END gensonnet header\n// Generated from Go AST: User\n{}\n"
+        );
+        assert_eq!(insert_block(&with_block, Some(block), "//"), with_block);
+        assert_eq!(without_block(&with_block), code);
+        assert_eq!(insert_block(&with_block, None, "//"), code);
+
+        // The block replaces the previous one, after the `#!` line of scripts
+        let script = insert_block("#!/bin/sh\nexit 0\n", Some("Old"), "#");
+        assert_eq!(
+            insert_block(&script, Some("New"), "#"),
+            "#!/bin/sh\n# BEGIN gensonnet header\n# New\n# END gensonnet header\nexit 0\n"
+        );
+
+        // The header and the formatting keep the block in place
+        let stamped = stamp_code(&with_block, &header());
+        assert!(stamped.starts_with("// BEGIN gensonnet header\n"));
+        assert_eq!(
+            stamped,
+            insert_block(&stamp_code(code, &header()), Some(block), "//")
+        );
+        assert_eq!(crate::format::format_code(&stamped), stamped);
+    }
+
+    #[test]
+    fn test_block_directory() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        std::fs::create_dir_all(dir.join("_custom")).unwrap();
+        std::fs::write(dir.join("user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("values.yaml"), "replicas: 1\n").unwrap();
+        std::fs::write(dir.join("index.json"), "{}\n").unwrap();
+        std::fs::write(dir.join("_custom/user.libsonnet"), "{}\n").unwrap();
+
+        let none = HashSet::new();
+        assert_eq!(block_directory(dir, Some("Apache-2.0"), &none).unwrap(), 2);
+        let read = |file: &str| std::fs::read_to_string(dir.join(file)).unwrap();
+        assert!(read("user.libsonnet").starts_with("// BEGIN gensonnet header\n// Apache-2.0\n"));
+        assert!(read("values.yaml").starts_with("# BEGIN gensonnet header\n# Apache-2.0\n"));
+        assert_eq!(read("index.json"), "{}\n");
+        assert_eq!(read("_custom/user.libsonnet"), "{}\n");
+
+        assert_eq!(block_directory(dir, Some("Apache-2.0"), &none).unwrap(), 0);
+        assert_eq!(block_directory(dir, None, &none).unwrap(), 2);
+        assert_eq!(read("user.libsonnet"), "{}\n");
+
+        // Files written with the block are not read again
+        let written = HashSet::from([dir.join("values.yaml")]);
+        assert_eq!(
+            block_directory(dir, Some("Apache-2.0"), &written).unwrap(),
+            1
+        );
+        assert_eq!(read("values.yaml"), "replicas: 1\n");
+    }
+
+    #[test]
+    fn test_without_timestamp() {
+        let header = Header {
+            timestamp: Some("2026-10-14T12:00:00Z".to_string()),
+            ..header()
+        };
+        let stamped = stamp_code("{}\n", &header);
+        assert!(stamped.contains("// Generated at: 2026-10-14T12:00:00Z\n"));
+        assert_eq!(
+            without_timestamp(&stamped),
+            stamp_code("{}\n", &self::header())
+        );
+        assert_eq!(
+            without_timestamp("{}\n// Generated at: x\n"),
+            "{}\n// Generated at: x\n"
+        );
+    }
+}
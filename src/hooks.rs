@@ -0,0 +1,214 @@
+//! Commands run around generation
+//!
+//! Hooks are shell commands (`sh -c`) run in a directory with variables
+//! describing the run, so publishing steps can be configured rather than
+//! wrapped around the generator:
+//!
+//! - `GENSONNET_VERSION`: version of the generator
+//! - `GENSONNET_SOURCE`: name of the source, when the hook belongs to one
+//! - `GENSONNET_OUTPUT_DIR`: output directory of the generated files
+//! - `GENSONNET_CHANGED_FILES`: generated files, one per line
+//!
+//! A hook exiting with a non-zero status fails with its output.
+//!
+//! `generation.hooks` runs commands before and after `gensonnet generate`,
+//! in the working directory; the post hooks get the files whose content the
+//! run changed in the output directories of the sources.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+/// Commands run before and after generation
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Hooks {
+    /// Commands run before the sources are processed, such as `jb install`
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub pre: Vec<String>,
+
+    /// Commands run after a generation without errors, such as opening a
+    /// pull request
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub post: Vec<String>,
+}
+
+impl Hooks {
+    /// Whether no hook is configured
+    pub fn is_empty(&self) -> bool {
+        self.pre.is_empty() && self.post.is_empty()
+    }
+}
+
+/// Description of a run, passed to hooks as environment variables
+#[derive(Debug, Clone, Default)]
+pub struct HookEnv {
+    pub source: Option<String>,
+    pub output_dir: Option<PathBuf>,
+    pub changed_files: Vec<PathBuf>,
+}
+
+impl HookEnv {
+    /// Environment variables of the run
+    pub fn vars(&self) -> Vec<(&'static str, String)> {
+        let mut vars = vec![("GENSONNET_VERSION", env!("CARGO_PKG_VERSION").to_string())];
+        if let Some(source) = &self.source {
+            vars.push(("GENSONNET_SOURCE", source.clone()));
+        }
+        if let Some(output_dir) = &self.output_dir {
+            vars.push(("GENSONNET_OUTPUT_DIR", output_dir.display().to_string()));
+        }
+        let files: Vec<String> = self
+            .changed_files
+            .iter()
+            .map(|file| file.display().to_string())
+            .collect();
+        vars.push(("GENSONNET_CHANGED_FILES", files.join("\n")));
+        vars
+    }
+}
+
+/// Hashes of the files of output directories, to find the files a run changed
+#[derive(Debug, Clone, Default)]
+pub struct OutputSnapshot {
+    hashes: BTreeMap<PathBuf, String>,
+}
+
+impl OutputSnapshot {
+    /// Hash the files of directories; missing directories have no files
+    pub fn take<'a>(dirs: impl IntoIterator<Item = &'a Path>) -> Result<Self> {
+        let mut hashes = BTreeMap::new();
+        for dir in dirs {
+            if !dir.is_dir() {
+                continue;
+            }
+            for entry in WalkDir::new(dir) {
+                let entry = entry?;
+                if entry.file_type().is_file() {
+                    let path = entry.path().to_path_buf();
+                    let hash = crate::integrity::file_sha256(&path)?;
+                    hashes.insert(path, hash);
+                }
+            }
+        }
+        Ok(Self { hashes })
+    }
+
+    /// Files added or modified since this snapshot
+    pub fn changed_files(&self, after: &OutputSnapshot) -> Vec<PathBuf> {
+        after
+            .hashes
+            .iter()
+            .filter(|(path, hash)| self.hashes.get(*path) != Some(*hash))
+            .map(|(path, _)| path.clone())
+            .collect()
+    }
+
+    /// Count the files created, changed, unchanged and deleted since this
+    /// snapshot
+    pub fn write_counts(&self, after: &OutputSnapshot) -> crate::output::WriteCounts {
+        let mut counts = crate::output::WriteCounts::default();
+        for (path, hash) in &after.hashes {
+            match self.hashes.get(path) {
+                None => counts.created += 1,
+                Some(previous) if previous != hash => counts.changed += 1,
+                Some(_) => counts.unchanged += 1,
+            }
+        }
+        counts.deleted = self
+            .hashes
+            .keys()
+            .filter(|path| !after.hashes.contains_key(*path))
+            .count();
+        counts
+    }
+}
+
+/// Run a hook in a directory
+pub fn run_hook(command: &str, dir: &Path, env: &HookEnv) -> Result<()> {
+    tracing::info!("Running hook in {}: {}", dir.display(), command);
+    let output = std::process::Command::new("sh")
+        .arg("-c")
+        .arg(command)
+        .current_dir(dir)
+        .envs(env.vars())
+        .output()
+        .map_err(|e| anyhow!("Failed to run hook '{}': {}", command, e))?;
+    if !output.status.success() {
+        return Err(anyhow!(
+            "Hook '{}' failed with {}: {}{}",
+            command,
+            output.status,
+            String::from_utf8_lossy(&output.stdout),
+            String::from_utf8_lossy(&output.stderr).trim_end()
+        ));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_run_hook() {
+        let dir = tempfile::tempdir().unwrap();
+        let env = HookEnv {
+            source: Some("users".to_string()),
+            output_dir: Some(dir.path().to_path_buf()),
+            changed_files: vec!["a.libsonnet".into(), "b.libsonnet".into()],
+        };
+
+        run_hook(
+            "printf '%s|%s' \"$GENSONNET_SOURCE\" \"$GENSONNET_CHANGED_FILES\" > hook.txt",
+            dir.path(),
+            &env,
+        )
+        .unwrap();
+        assert_eq!(
+            std::fs::read_to_string(dir.path().join("hook.txt")).unwrap(),
+            "users|a.libsonnet\nb.libsonnet"
+        );
+
+        let error = run_hook("echo nope >&2; exit 3", dir.path(), &env).unwrap_err();
+        assert!(error.to_string().contains("nope"));
+    }
+
+    #[test]
+    fn test_changed_files() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("kept.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.path().join("user.libsonnet"), "{}\n").unwrap();
+        let before = OutputSnapshot::take([dir.path()]).unwrap();
+
+        std::fs::write(dir.path().join("kept.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.path().join("user.libsonnet"), "{ a: 1 }\n").unwrap();
+        std::fs::write(dir.path().join("order.libsonnet"), "{}\n").unwrap();
+        let after = OutputSnapshot::take([dir.path(), &dir.path().join("missing")]).unwrap();
+
+        assert_eq!(
+            before.changed_files(&after),
+            vec![
+                dir.path().join("order.libsonnet"),
+                dir.path().join("user.libsonnet")
+            ]
+        );
+
+        std::fs::remove_file(dir.path().join("kept.libsonnet")).unwrap();
+        let last = OutputSnapshot::take([dir.path()]).unwrap();
+        assert_eq!(
+            after.write_counts(&last),
+            crate::output::WriteCounts {
+                created: 0,
+                changed: 0,
+                unchanged: 2,
+                deleted: 1,
+            }
+        );
+        assert_eq!(
+            before.write_counts(&after).to_string(),
+            "1 created, 1 changed, 1 unchanged, 0 deleted"
+        );
+    }
+}
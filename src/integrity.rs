@@ -0,0 +1,265 @@
+//! Manifests of generated output for reproducibility and tamper checks
+//!
+//! With `generation.manifest`, each output directory gets a
+//! `gensonnet.manifest.json` recording what produced it: the generator
+//! version, a hash of the configuration of the source, and the SHA-256 of
+//! every input file and of every output file. Two builds from the same inputs
+//! and configuration must have the same output hashes, and `gensonnet check`
+//! compares the files of an output directory with its manifest to detect
+//! generated files that were edited, removed or added by hand.
+//!
+//! User files (`_overrides.libsonnet` and the `_custom/` mixins) are meant to
+//! be edited and are left out of the manifest.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::header::{comment_prefix, without_block, without_timestamp};
+use crate::overrides::is_user_file;
+use crate::utils::is_jsonnet;
+
+/// File name of the manifest written to each output directory
+pub const MANIFEST_FILE: &str = "gensonnet.manifest.json";
+
+/// Version of the manifest layout
+pub const MANIFEST_VERSION: u32 = 1;
+
+/// Record of what produced an output directory
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Manifest {
+    /// Layout version, increased on incompatible changes
+    pub version: u32,
+
+    /// Version of gensonnet that generated the output
+    pub generator: String,
+
+    /// Name of the configured source
+    pub source: String,
+
+    /// SHA-256 of the configuration of the source and of the output
+    pub config_sha256: String,
+
+    /// SHA-256 of each input file, keyed by path relative to the repository
+    pub inputs: BTreeMap<String, String>,
+
+    /// SHA-256 of each output file, keyed by path relative to the output directory
+    pub outputs: BTreeMap<String, String>,
+}
+
+/// A difference between an output directory and its manifest
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Tampering {
+    /// A generated file was edited
+    Modified(String),
+
+    /// A generated file was removed
+    Missing(String),
+
+    /// A file was added that was not generated
+    Unexpected(String),
+}
+
+impl std::fmt::Display for Tampering {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Tampering::Modified(file) => write!(f, "modified: {file}"),
+            Tampering::Missing(file) => write!(f, "missing: {file}"),
+            Tampering::Unexpected(file) => write!(f, "unexpected: {file}"),
+        }
+    }
+}
+
+impl Manifest {
+    /// Create a manifest of the current generator for a source
+    pub fn new(source: &str, config_sha256: String) -> Self {
+        Self {
+            version: MANIFEST_VERSION,
+            generator: env!("CARGO_PKG_VERSION").to_string(),
+            source: source.to_string(),
+            config_sha256,
+            inputs: BTreeMap::new(),
+            outputs: BTreeMap::new(),
+        }
+    }
+
+    /// Record the hashes of the input files below `root`
+    pub fn add_inputs(&mut self, root: &Path, files: &[PathBuf]) -> Result<()> {
+        for file in files {
+            self.inputs
+                .insert(relative_name(root, file), file_sha256(file)?);
+        }
+        Ok(())
+    }
+
+    /// Record the hashes of the files of an output directory
+    pub fn record_outputs(&mut self, dir: &Path) -> Result<()> {
+        self.outputs = output_hashes(dir)?;
+        Ok(())
+    }
+
+    /// Write the manifest to an output directory
+    pub fn write(&self, dir: &Path) -> Result<()> {
+        crate::output::write_if_changed(
+            &dir.join(MANIFEST_FILE),
+            serde_json::to_string_pretty(self)? + "\n",
+        )?;
+        Ok(())
+    }
+
+    /// Read the manifest of an output directory, if it has one
+    pub fn read(dir: &Path) -> Result<Option<Self>> {
+        let path = dir.join(MANIFEST_FILE);
+        if !path.is_file() {
+            return Ok(None);
+        }
+        let manifest = serde_json::from_str(&std::fs::read_to_string(&path)?)
+            .map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?;
+        Ok(Some(manifest))
+    }
+
+    /// Compare the files of an output directory with the manifest
+    pub fn check(&self, dir: &Path) -> Result<Vec<Tampering>> {
+        let actual = output_hashes(dir)?;
+        let mut tampering = Vec::new();
+        for (file, sha256) in &self.outputs {
+            match actual.get(file) {
+                None => tampering.push(Tampering::Missing(file.clone())),
+                Some(actual) if actual != sha256 => {
+                    tampering.push(Tampering::Modified(file.clone()))
+                }
+                Some(_) => {}
+            }
+        }
+        for file in actual
+            .keys()
+            .filter(|file| !self.outputs.contains_key(*file))
+        {
+            tampering.push(Tampering::Unexpected(file.clone()));
+        }
+        Ok(tampering)
+    }
+}
+
+/// Hash a configuration value, as its JSON serialization
+pub fn config_sha256(config: &impl Serialize) -> Result<String> {
+    Ok(hex::encode(Sha256::digest(serde_json::to_vec(config)?)))
+}
+
+/// Hash the content of a file
+pub fn file_sha256(path: &Path) -> Result<String> {
+    let content =
+        std::fs::read(path).map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?;
+    Ok(hex::encode(Sha256::digest(content)))
+}
+
+/// Hash the content of a generated file
+///
+/// The header block and the timestamp of the header of Jsonnet files are
+/// left out, so files are only tampered with when something else changed.
+fn output_sha256(path: &Path) -> Result<String> {
+    if comment_prefix(path).is_none() {
+        return file_sha256(path);
+    }
+    let content = std::fs::read_to_string(path)
+        .map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?;
+    let mut content = without_block(&content);
+    if is_jsonnet(path) {
+        content = without_timestamp(&content);
+    }
+    Ok(hex::encode(Sha256::digest(content)))
+}
+
+/// Hash the generated files of an output directory
+fn output_hashes(dir: &Path) -> Result<BTreeMap<String, String>> {
+    let mut hashes = BTreeMap::new();
+    if !dir.is_dir() {
+        return Ok(hashes);
+    }
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        let path = entry.path();
+        if !entry.file_type().is_file()
+            || path == dir.join(MANIFEST_FILE)
+            || is_user_file(dir, path)
+        {
+            continue;
+        }
+        hashes.insert(relative_name(dir, path), output_sha256(path)?);
+    }
+    Ok(hashes)
+}
+
+/// Get the path of a file relative to `root`, with `/` separators
+fn relative_name(root: &Path, file: &Path) -> String {
+    file.strip_prefix(root)
+        .unwrap_or(file)
+        .to_string_lossy()
+        .replace('\\', "/")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_manifest_check() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let repo = temp_dir.path().join("repo");
+        let output = temp_dir.path().join("output");
+        std::fs::create_dir_all(repo.join("api")).unwrap();
+        std::fs::create_dir_all(output.join("_custom")).unwrap();
+        std::fs::write(repo.join("api/user.go"), "package api\n").unwrap();
+        std::fs::write(output.join("user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(
+            output.join("index.libsonnet"),
+            "// Generated at: 2026-10-14T12:00:00Z\n{}\n",
+        )
+        .unwrap();
+        std::fs::write(output.join("_custom/user.libsonnet"), "{}\n").unwrap();
+
+        let mut manifest = Manifest::new("api", config_sha256(&"config").unwrap());
+        manifest
+            .add_inputs(&repo, &[repo.join("api/user.go")])
+            .unwrap();
+        manifest.record_outputs(&output).unwrap();
+        manifest.write(&output).unwrap();
+        assert_eq!(
+            manifest.inputs.keys().collect::<Vec<_>>(),
+            vec!["api/user.go"]
+        );
+        assert_eq!(
+            manifest.outputs.keys().collect::<Vec<_>>(),
+            vec!["index.libsonnet", "user.libsonnet"]
+        );
+
+        let read = Manifest::read(&output).unwrap().unwrap();
+        assert_eq!(read, manifest);
+        assert!(read.check(&output).unwrap().is_empty());
+
+        // The time of another run does not change the file
+        std::fs::write(
+            output.join("index.libsonnet"),
+            "// Generated at: 2026-10-15T08:30:00Z\n{}\n",
+        )
+        .unwrap();
+        assert!(read.check(&output).unwrap().is_empty());
+
+        // User files may change, generated files may not
+        std::fs::write(output.join("_custom/user.libsonnet"), "{ a: 1 }\n").unwrap();
+        std::fs::write(output.join("user.libsonnet"), "{ patched: true }\n").unwrap();
+        std::fs::remove_file(output.join("index.libsonnet")).unwrap();
+        std::fs::write(output.join("extra.libsonnet"), "{}\n").unwrap();
+        assert_eq!(
+            read.check(&output).unwrap(),
+            vec![
+                Tampering::Missing("index.libsonnet".to_string()),
+                Tampering::Modified("user.libsonnet".to_string()),
+                Tampering::Unexpected("extra.libsonnet".to_string()),
+            ]
+        );
+    }
+}
@@ -0,0 +1,153 @@
+//! `jsonnetfile.json` of generated libraries, for `jsonnet-bundler`
+//!
+//! An output directory with a `jsonnetfile.json` can be installed with `jb
+//! install` straight from the repository it is committed to. The file lists
+//! the libraries the generated code imports from the library path, such as
+//! `k8s-libsonnet` for external Go packages, so `jb` installs them as well.
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeSet;
+use std::path::Path;
+use walkdir::WalkDir;
+
+use crate::rename;
+use crate::utils::is_jsonnet;
+
+/// File name of the manifest, inside the output directory
+pub const JSONNETFILE: &str = "jsonnetfile.json";
+
+/// A `jsonnetfile.json`
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Jsonnetfile {
+    pub version: u32,
+    pub dependencies: Vec<Dependency>,
+    pub legacy_imports: bool,
+}
+
+/// A library installed by `jb`
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+pub struct Dependency {
+    pub source: DependencySource,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+pub struct DependencySource {
+    pub git: GitDependency,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+pub struct GitDependency {
+    pub remote: String,
+    pub subdir: String,
+}
+
+impl Dependency {
+    /// Get the library providing an import of the library path
+    ///
+    /// Imports are written as `jb` installs them: the host, owner and
+    /// repository, then the path inside it
+    /// (`github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet`).
+    pub fn from_import(import: &str) -> Option<Self> {
+        let segments: Vec<&str> = import.split('/').collect();
+        let [host, owner, repository, path @ .., _file] = segments.as_slice() else {
+            return None;
+        };
+        if !host.contains('.') {
+            return None;
+        }
+        Some(Self {
+            source: DependencySource {
+                git: GitDependency {
+                    remote: format!("https://{host}/{owner}/{repository}.git"),
+                    subdir: path.join("/"),
+                },
+            },
+        })
+    }
+}
+
+/// Write the `jsonnetfile.json` of an output directory
+///
+/// Dependencies are the libraries of the imports that are not files of the
+/// directory. Their version is left to `jb`, which records the one
+/// installed in `jsonnetfile.lock.json`.
+pub fn write_jsonnetfile(dir: &Path) -> Result<Jsonnetfile> {
+    let mut dependencies = BTreeSet::new();
+    for entry in WalkDir::new(dir) {
+        let entry = entry?;
+        let path = entry.path();
+        if !entry.file_type().is_file() || !is_jsonnet(path) {
+            continue;
+        }
+
+        let code = std::fs::read_to_string(path)?;
+        let file_dir = path.parent().unwrap_or(dir);
+        for (_, import) in rename::imports(&code) {
+            if import.starts_with('.') || file_dir.join(&import).exists() {
+                continue;
+            }
+            dependencies.extend(Dependency::from_import(&import));
+        }
+    }
+
+    let jsonnetfile = Jsonnetfile {
+        version: 1,
+        dependencies: dependencies.into_iter().collect(),
+        legacy_imports: true,
+    };
+    crate::output::write_if_changed(
+        &dir.join(JSONNETFILE),
+        serde_json::to_string_pretty(&jsonnetfile)? + "\n",
+    )?;
+    Ok(jsonnetfile)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_dependency_from_import() {
+        let dependency =
+            Dependency::from_import("github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet")
+                .unwrap();
+        assert_eq!(
+            dependency.source.git.remote,
+            "https://github.com/jsonnet-libs/k8s-libsonnet.git"
+        );
+        assert_eq!(dependency.source.git.subdir, "1.29");
+
+        let root = Dependency::from_import("github.com/grafana/jsonnet-libs/main.libsonnet");
+        assert_eq!(root.unwrap().source.git.subdir, "");
+        assert!(Dependency::from_import("lib/util.libsonnet").is_none());
+        assert!(Dependency::from_import("address.libsonnet").is_none());
+    }
+
+    #[test]
+    fn test_write_jsonnetfile() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        let k8s = "github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet";
+        std::fs::write(dir.join("address.libsonnet"), "{}\n").unwrap();
+        std::fs::write(
+            dir.join("job.libsonnet"),
+            format!("{{\n  imports:: {{\n    Address: import \"address.libsonnet\",\n    Pod: (import \"{k8s}\").core.v1.pod,\n  }},\n}}\n"),
+        )
+        .unwrap();
+        std::fs::write(dir.join("cron.libsonnet"), format!("(import '{k8s}')\n")).unwrap();
+
+        let jsonnetfile = write_jsonnetfile(dir).unwrap();
+        assert_eq!(jsonnetfile.dependencies.len(), 1);
+
+        let written: serde_json::Value =
+            serde_json::from_str(&std::fs::read_to_string(dir.join(JSONNETFILE)).unwrap()).unwrap();
+        assert_eq!(written["version"], 1);
+        assert_eq!(written["legacyImports"], true);
+        assert_eq!(
+            written["dependencies"][0]["source"]["git"]["remote"],
+            "https://github.com/jsonnet-libs/k8s-libsonnet.git"
+        );
+    }
+}
@@ -3,11 +3,51 @@
 //! A Rust library for generating type-safe Jsonnet libraries from various schema sources,
 //! starting with Kubernetes CustomResourceDefinitions (CRDs).
 
+pub mod argocd;
+pub mod bundle;
+pub mod changelog;
 pub mod cli;
+pub mod compat;
 pub mod config;
+pub mod daemon;
+pub mod depgraph;
+pub mod diagnostics;
+pub mod dialect;
+pub mod doctor;
+pub mod evaluate;
+pub mod fixtures;
+pub mod format;
 pub mod git;
+pub mod goembed;
+pub mod graphql;
+pub mod header;
+pub mod hooks;
+pub mod integrity;
+pub mod jsonnetfile;
+pub mod manifest;
+pub mod migrate;
+pub mod namespace;
+pub mod natives;
+pub mod oci;
+pub mod output;
+pub mod overrides;
+pub mod plan;
 pub mod plugin;
+pub mod profile;
+pub mod quota;
+pub mod rename;
+pub mod repl;
+pub mod report;
+pub mod schemadiff;
+pub mod surface;
+pub mod tanka;
+pub mod template;
+pub mod terraform;
 pub mod utils;
+pub mod verify;
+pub mod watch;
+pub mod wizard;
+pub mod worker;
 
 pub use config::{Config, GenerationConfig, Source};
 pub use git::GitManager;
@@ -19,7 +59,8 @@ pub use plugin::{ExtractedSchema, PluginConfig, PluginContext, PluginManager, Pl
 
 use anyhow::Result;
 use chrono::Utc;
-use std::collections::HashMap;
+use futures::StreamExt;
+use std::collections::{HashMap, HashSet};
 use std::path::{Path, PathBuf};
 use std::sync::Arc;
 use std::time::Instant;
@@ -33,6 +74,32 @@ pub struct JsonnetGen {
     generator: JsonnetGenerator,
     lockfile_manager: LockfileManager,
     plugin_manager: Arc<PluginManager>,
+
+    /// Constructs of the processed sources that could not be represented
+    unsupported: std::sync::Mutex<Vec<plugin::ast::unsupported::Unsupported>>,
+
+    /// Machine-readable report of the processed sources
+    report: std::sync::Mutex<report::RunReport>,
+
+    /// Header of the source being processed, stamped on the files written for it
+    header: std::sync::Mutex<Option<header::Header>>,
+
+    /// Output directories of the source being processed
+    output_dirs: std::sync::Mutex<Vec<PathBuf>>,
+
+    /// Files written through `write_output` for the source being processed,
+    /// with the polyfill calls replaced in them
+    written: std::sync::Mutex<HashMap<PathBuf, Vec<(&'static str, usize)>>>,
+
+    /// Content the files written for the source being processed had before,
+    /// `None` for new files, restored when the source goes over a quota
+    previous: std::sync::Mutex<HashMap<PathBuf, Option<Vec<u8>>>>,
+
+    /// Quotas of the source being processed, checked between its types
+    tracker: std::sync::Mutex<Option<quota::QuotaTracker>>,
+
+    /// Quotas of the installation, within which the configuration's apply
+    operator_quotas: quota::Quotas,
 }
 
 impl JsonnetGen {
@@ -51,9 +118,48 @@ impl JsonnetGen {
             generator,
             lockfile_manager,
             plugin_manager,
+            unsupported: std::sync::Mutex::new(Vec::new()),
+            report: std::sync::Mutex::new(report::RunReport::default()),
+            header: std::sync::Mutex::new(None),
+            output_dirs: std::sync::Mutex::new(Vec::new()),
+            written: std::sync::Mutex::new(HashMap::new()),
+            previous: std::sync::Mutex::new(HashMap::new()),
+            tracker: std::sync::Mutex::new(None),
+            operator_quotas: quota::Quotas::default(),
         })
     }
 
+    /// Get the configuration of the application
+    pub fn config(&self) -> &Config {
+        &self.config
+    }
+
+    /// Create an instance for another configuration, with the same quotas
+    /// of the installation
+    pub fn with_config(&self, config: Config) -> Result<Self> {
+        Ok(Self::new(config)?.with_quotas(self.operator_quotas.clone()))
+    }
+
+    /// Apply the quotas set by the operator of the installation
+    ///
+    /// The `generation.quotas` of the configuration can lower these limits
+    /// but not raise them.
+    pub fn with_quotas(mut self, quotas: quota::Quotas) -> Self {
+        self.operator_quotas = quotas;
+        self
+    }
+
+    /// Get the quotas applied to each source
+    fn quotas(&self) -> quota::Quotas {
+        self.config.generation.quotas.within(&self.operator_quotas)
+    }
+
+    /// Record the generation in another lockfile than `gensonnet.lock`
+    pub fn with_lockfile(mut self, lockfile_path: PathBuf) -> Self {
+        self.lockfile_manager = LockfileManager::new(lockfile_path);
+        self
+    }
+
     /// Initialize the plugin system
     pub async fn initialize_plugins(&self) -> Result<()> {
         info!("Initializing plugin system");
@@ -211,6 +317,31 @@ impl JsonnetGen {
         let mut total_errors = 0;
         let total_warnings = 0;
 
+        let hooks = &self.config.generation.hooks;
+        let hook_env = hooks::HookEnv {
+            output_dir: Some(self.config.output.base_path.clone()),
+            ..Default::default()
+        };
+        for hook in &hooks.pre {
+            let _phase = profile::package_phase("hook", hook.as_str());
+            hooks::run_hook(hook, Path::new("."), &hook_env)?;
+        }
+        let before = if hooks.post.is_empty() {
+            hooks::OutputSnapshot::default()
+        } else {
+            hooks::OutputSnapshot::take(self.hook_output_dirs())?
+        };
+
+        // Types exported by several sources of a namespace fail the run
+        // before anything is written
+        let snapshots = self.namespace_snapshots().await?;
+        for namespace in &self.config.namespaces {
+            let dirs = namespace.source_dirs(&self.config.sources);
+            namespace::collect_types(namespace, &dirs, |name, _| {
+                Ok(snapshots.get(name).cloned().unwrap_or_default())
+            })?;
+        }
+
         // Check if incremental generation is possible
         let current_sources = self.get_current_source_commits().await?;
         let incremental_plan = self
@@ -254,12 +385,136 @@ impl JsonnetGen {
             },
         };
 
+        // Indexes of the namespaces, from the outputs of their sources
+        for namespace in &self.config.namespaces {
+            let index =
+                self.write_namespace_output(namespace, &namespace.output_path, |_, dir| {
+                    schemadiff::Snapshot::load(dir)
+                })?;
+            info!("Wrote namespace {}: {:?}", namespace.name, index);
+        }
+
         // Update lockfile with new generation data
         self.update_lockfile(&result).await?;
 
+        if let Some(report) = &self.config.generation.unsupported_report {
+            self.write_unsupported_report(report).await?;
+        }
+        if let Some(report) = &self.config.generation.report {
+            self.write_run_report(report).await?;
+        }
+
+        if !hooks.post.is_empty() {
+            if total_errors > 0 || result.sources_processed < result.total_sources {
+                warn!("Skipping the post-generation hooks of a run with errors");
+            } else {
+                let after = hooks::OutputSnapshot::take(self.hook_output_dirs())?;
+                let env = hooks::HookEnv {
+                    changed_files: before.changed_files(&after),
+                    ..hook_env
+                };
+                for hook in &hooks.post {
+                    let _phase = profile::package_phase("hook", hook.as_str());
+                    hooks::run_hook(hook, Path::new("."), &env)?;
+                }
+            }
+        }
+
         Ok(result)
     }
 
+    /// Output directories whose files are reported to post-generation hooks
+    fn hook_output_dirs(&self) -> Vec<&Path> {
+        let mut dirs = vec![self.config.output.base_path.as_path()];
+        for source in &self.config.sources {
+            for dir in source_output_paths(source) {
+                if !dirs.iter().any(|known| dir.starts_with(known)) {
+                    dirs.push(dir);
+                }
+            }
+        }
+        dirs
+    }
+
+    /// Snapshots of the types of the sources of the namespaces, by source name
+    ///
+    /// The schemas are extracted from the sources, so the types are known
+    /// before any output is written.
+    async fn namespace_snapshots(&self) -> Result<HashMap<String, schemadiff::Snapshot>> {
+        let _phase = profile::phase("namespaces");
+        let mut snapshots = HashMap::new();
+        for namespace in &self.config.namespaces {
+            for source in namespace.members(&self.config.sources) {
+                if snapshots.contains_key(source.name()) {
+                    continue;
+                }
+                let mut schemas = self.extract_source_schemas(source).await?;
+                // Types of package outputs are not in the output directory of the source
+                if let Source::GoAst(go_ast) = source {
+                    schemas.retain(|schema| {
+                        let package = schema
+                            .metadata
+                            .get("package")
+                            .and_then(|p| p.as_str())
+                            .unwrap_or_default();
+                        !go_ast
+                            .options
+                            .package_outputs
+                            .iter()
+                            .any(|output| output.matches(package, None))
+                    });
+                }
+                snapshots.insert(
+                    source.name().to_string(),
+                    schemadiff::Snapshot::from_schemas(&schemas),
+                );
+            }
+        }
+        Ok(snapshots)
+    }
+
+    /// Write the index of a namespace to `output_dir`
+    ///
+    /// `snapshot` gives the types of a source of the namespace from its name
+    /// and output directory. The index is written like the libraries of a
+    /// source, with a header of the configuration of the namespace, and the
+    /// steps after generation run on `output_dir`.
+    fn write_namespace_output(
+        &self,
+        namespace: &namespace::Namespace,
+        output_dir: &Path,
+        snapshot: impl Fn(&str, &Path) -> Result<schemadiff::Snapshot>,
+    ) -> Result<PathBuf> {
+        let dirs = namespace.source_dirs(&self.config.sources);
+        let types = namespace::collect_types(namespace, &dirs, snapshot)?;
+
+        let mut unplaced = namespace.clone();
+        unplaced.output_path = PathBuf::new();
+        let config_sha256 = integrity::config_sha256(&(
+            &unplaced,
+            &self.config.output,
+            self.config.generation.formatter,
+            &self.config.generation.jsonnet_version,
+        ))?;
+        let output_paths = [output_dir];
+        self.start_output(header::Header::new(config_sha256.clone()), &output_paths);
+
+        std::fs::create_dir_all(output_dir)?;
+        let file = output_dir.join(namespace::INDEX_FILE);
+        self.write_output(&file, namespace::generate_index(namespace, &dirs, &types))?;
+
+        self.downgrade_output(&output_paths)?;
+        self.stamp_output(&output_paths)?;
+        self.format_output(&output_paths)?;
+        self.block_output(&output_paths)?;
+        if self.config.generation.manifest {
+            let mut manifest = integrity::Manifest::new(&namespace.name, config_sha256);
+            manifest.record_outputs(output_dir)?;
+            manifest.write(output_dir)?;
+        }
+        Ok(file)
+    }
+
     /// Generate libraries incrementally
     async fn generate_incremental(&self, plan: &IncrementalPlan) -> Result<Vec<SourceResult>> {
         let mut results = Vec::new();
@@ -274,7 +529,7 @@ impl JsonnetGen {
                     }
                     Err(e) => {
                         error!("Failed to process changed source {}: {}", source_id, e);
-                        if self.config.generation.fail_fast {
+                        if self.config.generation.fail_fast || e.is::<quota::QuotaExceeded>() {
                             return Err(e);
                         }
                     }
@@ -290,6 +545,7 @@ impl JsonnetGen {
                         info!("Successfully processed dependent source: {}", source_id);
                         results.push(result);
                     }
+                    Err(e) if e.is::<quota::QuotaExceeded>() => return Err(e),
                     Err(e) => {
                         warn!("Failed to process dependent source {}: {}", source_id, e);
                         // Don't fail fast for dependent sources
@@ -313,7 +569,7 @@ impl JsonnetGen {
                 }
                 Err(e) => {
                     error!("Failed to process source {}: {}", source.name(), e);
-                    if self.config.generation.fail_fast {
+                    if self.config.generation.fail_fast || e.is::<quota::QuotaExceeded>() {
                         return Err(e);
                     }
                 }
@@ -324,8 +580,12 @@ impl JsonnetGen {
     }
 
     /// Process a single source with error recovery
+    ///
+    /// A source over its quotas is not recovered: the `QuotaExceeded` error
+    /// is returned, so callers can report the limit it reached.
     pub async fn process_source_with_recovery(&self, source: &Source) -> Result<SourceResult> {
         let start_time = Instant::now();
+        let _phase = profile::phase("source");
 
         match self.process_source(source).await {
             Ok(mut result) => {
@@ -333,6 +593,7 @@ impl JsonnetGen {
                 result.processing_time_ms = processing_time.as_millis() as u64;
                 Ok(result)
             }
+            Err(e) if e.is::<quota::QuotaExceeded>() => Err(e),
             Err(e) => {
                 // Try to recover by generating partial results
                 warn!(
@@ -364,30 +625,580 @@ impl JsonnetGen {
 
     /// Process a single source
     async fn process_source(&self, source: &Source) -> Result<SourceResult> {
+        let checkout = profile::phase("checkout");
+        let repo_path = self.git_manager.ensure_repository(source.git()).await?;
+        drop(checkout);
+        self.process_source_at(source, &repo_path).await
+    }
+
+    /// Process a single source whose files are already checked out at `repo_path`
+    pub async fn process_source_at(
+        &self,
+        source: &Source,
+        repo_path: &Path,
+    ) -> Result<SourceResult> {
+        let before = self.start_source_output(source, repo_path)?;
+        let (result, samples) = quota::with_deadline(
+            &self.quotas(),
+            source.name(),
+            self.generate_source_at(source, repo_path),
+        )
+        .await
+        .or_else(|e| self.restore_over_quota(e))?;
+        self.finish_source_output(source, repo_path, &samples, &before)
+            .await?;
+        Ok(result)
+    }
+
+    /// Generate a Go AST source from an explicit list of Go files
+    ///
+    /// Nothing is discovered: the files are parsed as given, relative to
+    /// `repo_path`, as for hermetic builds where the build system lists the
+    /// inputs of each action.
+    pub async fn process_go_files_at(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        repo_path: &Path,
+        go_files: &[PathBuf],
+    ) -> Result<SourceResult> {
+        let source = Source::GoAst(go_ast_source.clone());
+        let before = self.start_source_output(&source, repo_path)?;
+        let (result, samples) = quota::with_deadline(
+            &self.quotas(),
+            &go_ast_source.name,
+            self.process_go_files(go_ast_source, repo_path, go_files),
+        )
+        .await
+        .or_else(|e| self.restore_over_quota(e))?;
+        self.finish_source_output(&source, repo_path, &samples, &before)
+            .await?;
+        Ok(result)
+    }
+
+    /// Prepare the output of a source for generation
+    ///
+    /// Returns the files its output directories had before, to count what
+    /// the run changed.
+    fn start_source_output(
+        &self,
+        source: &Source,
+        repo_path: &Path,
+    ) -> Result<hooks::OutputSnapshot> {
+        let stamp = self.source_header(source, repo_path)?;
+        self.start_output(stamp, &source_output_paths(source));
+        if let Ok(mut tracker) = self.tracker.lock() {
+            *tracker = Some(quota::QuotaTracker::start(&self.quotas(), source.name()));
+        }
+        hooks::OutputSnapshot::take(source_output_paths(source))
+    }
+
+    /// Check the duration limit of the source being processed
+    fn check_deadline(&self) -> Result<()> {
+        match self.tracker.lock() {
+            Ok(tracker) => tracker
+                .as_ref()
+                .map_or(Ok(()), quota::QuotaTracker::check_duration),
+            Err(_) => Ok(()),
+        }
+    }
+
+    /// Restore the files written for a source that went over a quota
+    ///
+    /// Other errors are returned as they are, leaving the files of a partial
+    /// generation in place.
+    fn restore_over_quota<T>(&self, error: anyhow::Error) -> Result<T> {
+        if !error.is::<quota::QuotaExceeded>() {
+            return Err(error);
+        }
+        let previous = match self.previous.lock() {
+            Ok(mut previous) => std::mem::take(&mut *previous),
+            Err(_) => return Err(error),
+        };
+        for (path, content) in previous {
+            match content {
+                Some(content) => std::fs::write(&path, content)?,
+                None if path.is_file() => std::fs::remove_file(&path)?,
+                None => {}
+            }
+        }
+        Err(error)
+    }
+
+    /// Set the header and the output directories of the files written next,
+    /// and forget the files written before
+    fn start_output(&self, stamp: header::Header, output_paths: &[&Path]) {
+        if let Ok(mut header) = self.header.lock() {
+            *header = Some(stamp);
+        }
+        if let Ok(mut output_dirs) = self.output_dirs.lock() {
+            *output_dirs = output_paths.iter().map(|dir| dir.to_path_buf()).collect();
+        }
+        if let Ok(mut written) = self.written.lock() {
+            written.clear();
+        }
+        if let Ok(mut previous) = self.previous.lock() {
+            previous.clear();
+        }
+    }
+
+    /// Write a file of an output directory, unless it already has the content
+    ///
+    /// Jsonnet files are written downgraded for the `jsonnet_version`, with
+    /// the header of the source and formatted, and files with comments with
+    /// the header block, as the steps after generation would leave them, so
+    /// unchanged libraries are not rewritten. The steps after generation
+    /// leave the files written here alone.
+    fn write_output(&self, path: &Path, content: impl Into<String>) -> Result<()> {
+        let mut content = content.into();
+        let mut replaced = Vec::new();
+        if utils::is_jsonnet(path) {
+            if let Some(version) = &self.config.generation.jsonnet_version {
+                // Polyfills are imported from the output directory of the file
+                let depth = self.output_dirs.lock().ok().and_then(|dirs| {
+                    dirs.iter()
+                        .filter_map(|dir| path.strip_prefix(dir).ok())
+                        .map(|relative| relative.components().count() - 1)
+                        .min()
+                });
+                if let Some(depth) = depth {
+                    (content, replaced) =
+                        dialect::downgrade_code(&content, version.parse()?, &"../".repeat(depth));
+                }
+            }
+            if let Some(stamp) = self.header.lock().ok().and_then(|header| header.clone()) {
+                content = header::stamp_code(&content, &stamp);
+            }
+            content = format::format_jsonnet(
+                &content,
+                self.config.generation.formatter,
+                self.config.generation.formatter_command.as_deref(),
+            )?;
+        }
+        if let Some(prefix) = header::comment_prefix(path) {
+            let block = self.config.generation.header_block.as_deref();
+            content = header::insert_block(&content, block, prefix);
+        }
+        if !self.quotas().is_empty() {
+            if let Ok(mut previous) = self.previous.lock() {
+                previous
+                    .entry(path.to_path_buf())
+                    .or_insert_with(|| std::fs::read(path).ok());
+            }
+        }
+        output::write_if_changed(path, content)?;
+        if let Ok(mut written) = self.written.lock() {
+            written.insert(path.to_path_buf(), replaced);
+        }
+        Ok(())
+    }
+
+    /// Files written through `write_output` for the source being processed
+    fn written_output(&self) -> HashMap<PathBuf, Vec<(&'static str, usize)>> {
+        self.written
+            .lock()
+            .map(|written| written.clone())
+            .unwrap_or_default()
+    }
+
+    /// Post-process the freshly generated output of a source
+    ///
+    /// The directory passes only touch the files not written through
+    /// `write_output`, such as the CRD libraries and the package files.
+    async fn finish_source_output(
+        &self,
+        source: &Source,
+        repo_path: &Path,
+        samples: &HashMap<PathBuf, Vec<String>>,
+        before: &hooks::OutputSnapshot,
+    ) -> Result<()> {
+        let output_paths = source_output_paths(source);
+        {
+            let _phase = profile::phase("downgrade");
+            self.downgrade_output(&output_paths)?;
+        }
+        {
+            let _phase = profile::phase("header");
+            self.stamp_output(&output_paths)?;
+        }
+        {
+            let _phase = profile::phase("format");
+            self.format_output(&output_paths)?;
+        }
+        if self.config.generation.verify {
+            let _phase = profile::phase("verify");
+            self.verify_source_output(source, samples)?;
+        }
+        match self.config.output.preset {
+            jsonnet_generator::config::OutputPreset::Tanka => {
+                for output_path in source_output_paths(source) {
+                    if output_path.is_dir() {
+                        tanka::package_directory(output_path)?;
+                    }
+                }
+            }
+            jsonnet_generator::config::OutputPreset::Argocd => {
+                for output_path in source_output_paths(source) {
+                    if output_path.is_dir() {
+                        argocd::package_directory(output_path)?;
+                    }
+                }
+            }
+            jsonnet_generator::config::OutputPreset::Plain => {}
+        }
+        if self.config.generation.jsonnetfile {
+            self.write_source_jsonnetfile(source)?;
+        }
+        if let Some(go_embed) = &self.config.generation.go_embed {
+            self.write_source_embed(source, go_embed)?;
+        }
+        {
+            let _phase = profile::phase("header");
+            self.block_output(&output_paths)?;
+        }
+        if self.config.generation.manifest {
+            let _phase = profile::phase("manifest");
+            self.write_source_manifest(source, repo_path).await?;
+        }
+
+        let after = hooks::OutputSnapshot::take(source_output_paths(source))?;
+        let counts = before.write_counts(&after);
+        info!("Output files of {}: {}", source.name(), counts);
+        if let Ok(mut run_report) = self.report.lock() {
+            if let Some(source_report) = run_report
+                .sources
+                .iter_mut()
+                .find(|report| report.source == source.name())
+            {
+                source_report.files = counts;
+            }
+        }
+
+        // Publishing of the finished package outputs, such as a push to their repository
+        if let Source::GoAst(go_ast) = source {
+            let changed_files = before.changed_files(&after);
+            for output in &go_ast.options.package_outputs {
+                let Some(hook) = &output.post_hook else {
+                    continue;
+                };
+                let changed_files = changed_files
+                    .iter()
+                    .filter(|file| file.starts_with(&output.output_path))
+                    .cloned()
+                    .collect();
+                let env = hooks::HookEnv {
+                    source: Some(go_ast.name.clone()),
+                    output_dir: Some(output.output_path.clone()),
+                    changed_files,
+                };
+                let _phase = profile::package_phase("hook", hook.as_str());
+                hooks::run_hook(hook, &output.output_path, &env)?;
+            }
+        }
+        Ok(())
+    }
+
+    /// Write the manifest of the inputs and outputs of a source to its output directories
+    ///
+    /// The configuration hash leaves the output path out, so the same source
+    /// generated elsewhere has the same manifest.
+    async fn write_source_manifest(&self, source: &Source, repo_path: &Path) -> Result<()> {
+        let inputs = match source {
+            Source::GoAst(go_ast) => {
+                self.find_go_files(
+                    repo_path,
+                    &go_ast.include_patterns,
+                    &go_ast.exclude_patterns,
+                )
+                .await?
+            }
+            Source::OpenApi(openapi) => {
+                self.find_openapi_files(
+                    repo_path,
+                    &openapi.include_patterns,
+                    &openapi.exclude_patterns,
+                )
+                .await?
+            }
+            Source::Terraform(terraform) => {
+                self.find_openapi_files(
+                    repo_path,
+                    &terraform.include_patterns,
+                    &terraform.exclude_patterns,
+                )
+                .await?
+            }
+            Source::Graphql(graphql) => {
+                self.find_openapi_files(
+                    repo_path,
+                    &graphql.include_patterns,
+                    &graphql.exclude_patterns,
+                )
+                .await?
+            }
+            Source::Crd(_) => Vec::new(),
+        };
+
+        let config_sha256 = self.source_config_sha256(source)?;
+
+        for output_path in source_output_paths(source) {
+            if output_path.is_dir() {
+                let mut manifest = integrity::Manifest::new(source.name(), config_sha256.clone());
+                manifest.add_inputs(repo_path, &inputs)?;
+                manifest.record_outputs(output_path)?;
+                manifest.write(output_path)?;
+                info!(
+                    "Recorded {} inputs and {} outputs in {:?}",
+                    manifest.inputs.len(),
+                    manifest.outputs.len(),
+                    output_path.join(integrity::MANIFEST_FILE)
+                );
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Hash the configuration of a source, as recorded in manifests and headers
+    ///
+    /// The output path is left out, so the same source generated elsewhere
+    /// has the same hash.
+    fn source_config_sha256(&self, source: &Source) -> Result<String> {
+        let mut unplaced = source.clone();
+        unplaced.set_output_path(PathBuf::new());
+        integrity::config_sha256(&(
+            &unplaced,
+            &self.config.output,
+            self.config.generation.formatter,
+            &self.config.generation.jsonnet_version,
+            self.config.generation.jsonnetfile,
+        ))
+    }
+
+    /// Header of the files generated for a source checked out at `repo_path`
+    fn source_header(&self, source: &Source, repo_path: &Path) -> Result<header::Header> {
+        let mut stamp = header::Header::new(self.source_config_sha256(source)?);
+        let go_module = match source {
+            Source::GoAst(_) => std::fs::read_to_string(repo_path.join("go.mod"))
+                .ok()
+                .and_then(|go_mod| plugin::ast::gomod::module_path(&go_mod).map(str::to_string)),
+            _ => None,
+        };
+        stamp.module = go_module.or_else(|| Some(source.git_url().to_string()));
+        stamp.version = source.git_ref().map(str::to_string);
+        stamp.commit = self.git_manager.get_current_commit(repo_path).ok();
+        if self.config.generation.header_timestamp {
+            stamp.timestamp = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        }
+        Ok(stamp)
+    }
+
+    /// Put the header of the inputs of the output into the Jsonnet files of its directories
+    fn stamp_output(&self, output_paths: &[&Path]) -> Result<()> {
+        let stamp = match self.header.lock() {
+            Ok(header) => header.clone(),
+            Err(e) => return Err(anyhow::anyhow!("Failed to read the header: {}", e)),
+        };
+        let Some(stamp) = stamp else {
+            return Ok(());
+        };
+        let written: HashSet<PathBuf> = self.written_output().into_keys().collect();
+        for output_path in output_paths {
+            let stamped = header::stamp_directory(output_path, &stamp, &written)?;
+            if stamped > 0 {
+                info!(
+                    "Stamped the header of {} files in {:?}",
+                    stamped, output_path
+                );
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Put the configured header block into the files of output directories
+    ///
+    /// This runs after every other file of the output directories is
+    /// written; without a block, a block of an earlier run is removed.
+    fn block_output(&self, output_paths: &[&Path]) -> Result<()> {
+        let block = self.config.generation.header_block.as_deref();
+        let written: HashSet<PathBuf> = self.written_output().into_keys().collect();
+        for output_path in output_paths {
+            let rewritten = header::block_directory(output_path, block, &written)?;
+            if rewritten > 0 {
+                info!(
+                    "Updated the header block of {} files in {:?}",
+                    rewritten, output_path
+                );
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Evaluate the Jsonnet files written for a source
+    ///
+    /// `samples` holds the `new()` arguments of the generated type libraries;
+    /// other constructors are called with `null` for each parameter.
+    fn verify_source_output(
+        &self,
+        source: &Source,
+        samples: &HashMap<PathBuf, Vec<String>>,
+    ) -> Result<()> {
+        let command = self.config.generation.jsonnet_command.as_deref();
+
+        let output_paths = source_output_paths(source);
+
+        let mut failures = Vec::new();
+        for output_path in output_paths {
+            let evaluator = evaluate::Evaluator::new(command, vec![output_path.to_path_buf()]);
+            failures.extend(verify::verify_directory(&evaluator, output_path, samples)?);
+        }
+
+        if failures.is_empty() {
+            return Ok(());
+        }
+        let listed: Vec<String> = failures
+            .iter()
+            .map(|failure| format!("{}: {}", failure.file.display(), failure.message))
+            .collect();
+        Err(anyhow::anyhow!(
+            "Verification failed for {} files:\n{}",
+            failures.len(),
+            listed.join("\n")
+        ))
+    }
+
+    /// Replace the standard library functions the configured `jsonnet_version`
+    /// lacks in the Jsonnet files of output directories
+    fn downgrade_output(&self, output_paths: &[&Path]) -> Result<()> {
+        let Some(version) = &self.config.generation.jsonnet_version else {
+            return Ok(());
+        };
+        let version: dialect::Version = version.parse()?;
+
+        let written = self.written_output();
+        for output_path in output_paths {
+            for substitution in dialect::downgrade_directory(output_path, version, &written)? {
+                info!(
+                    "{}: replaced {} calls of {} (added in jsonnet {}) by a polyfill{}",
+                    output_path.join(&substitution.file).display(),
+                    substitution.calls,
+                    substitution.function,
+                    substitution.since,
+                    substitution
+                        .caveat
+                        .as_deref()
+                        .map(|caveat| format!("; {caveat}"))
+                        .unwrap_or_default()
+                );
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Write the `jsonnetfile.json` of the output directories of a source
+    fn write_source_jsonnetfile(&self, source: &Source) -> Result<()> {
+        let output_paths = source_output_paths(source);
+        for output_path in output_paths {
+            if output_path.is_dir() {
+                let written = jsonnetfile::write_jsonnetfile(output_path)?;
+                info!(
+                    "Wrote {} with {} dependencies to {:?}",
+                    jsonnetfile::JSONNETFILE,
+                    written.dependencies.len(),
+                    output_path
+                );
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Write the Go package embedding the output directories of a source
+    fn write_source_embed(&self, source: &Source, go_embed: &goembed::GoEmbed) -> Result<()> {
+        for output_path in source_output_paths(source) {
+            if output_path.is_dir() {
+                let patterns = goembed::write_embed(output_path, go_embed)?;
+                if !patterns.is_empty() {
+                    info!(
+                        "Wrote {} embedding {} to {:?}",
+                        goembed::EMBED_FILE,
+                        patterns.join(" "),
+                        output_path
+                    );
+                }
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Format the Jsonnet files of output directories with the configured formatter
+    fn format_output(&self, output_paths: &[&Path]) -> Result<()> {
+        let formatter = self.config.generation.formatter;
+        let command = self.config.generation.formatter_command.as_deref();
+
+        let written: HashSet<PathBuf> = self.written_output().into_keys().collect();
+        for output_path in output_paths {
+            let formatted = format::format_directory(output_path, formatter, command, &written)?;
+            if formatted > 0 {
+                info!("Formatted {} files in {:?}", formatted, output_path);
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate the libraries of a source checked out at `repo_path`
+    ///
+    /// Also returns the sample `new()` arguments of the generated files, when
+    /// the generator can derive them.
+    async fn generate_source_at(
+        &self,
+        source: &Source,
+        repo_path: &Path,
+    ) -> Result<(SourceResult, HashMap<PathBuf, Vec<String>>)> {
         match source {
             Source::Crd(crd_source) => {
                 // Try to use plugin first, fall back to built-in CRD parser
-                if let Ok(plugin_result) = self.process_with_plugins(crd_source).await {
-                    return Ok(plugin_result);
+                if let Ok(plugin_result) = self.process_with_plugins(crd_source, repo_path).await {
+                    return Ok((plugin_result, HashMap::new()));
                 }
 
                 // Fall back to built-in CRD processing
-                let repo_path = self.git_manager.ensure_repository(&crd_source.git).await?;
                 let schemas = self
                     .crd_parser
-                    .parse_from_directory(&repo_path, &crd_source.filters)?;
+                    .parse_from_directory(repo_path, &crd_source.filters)?;
                 let generator_schemas: Vec<_> = schemas.iter().map(convert_crd_schema).collect();
-                self.generator
+                let result = self
+                    .generator
                     .generate_crd_library(&generator_schemas, &crd_source.output_path)
-                    .await
+                    .await?;
+                Ok((result, HashMap::new()))
             }
             Source::GoAst(go_ast_source) => {
                 // Use Go AST plugin
-                self.process_go_source(go_ast_source).await
+                self.process_go_source(go_ast_source, repo_path).await
             }
             Source::OpenApi(openapi_source) => {
                 // Use OpenAPI plugin
-                self.process_openapi_source(openapi_source).await
+                let result = self
+                    .process_openapi_source(openapi_source, repo_path)
+                    .await?;
+                Ok((result, HashMap::new()))
+            }
+            Source::Terraform(terraform_source) => {
+                let result = self
+                    .process_terraform_source(terraform_source, repo_path)
+                    .await?;
+                Ok((result, HashMap::new()))
+            }
+            Source::Graphql(graphql_source) => {
+                let result = self
+                    .process_graphql_source(graphql_source, repo_path)
+                    .await?;
+                Ok((result, HashMap::new()))
             }
         }
     }
@@ -396,6 +1207,7 @@ impl JsonnetGen {
     async fn process_with_plugins(
         &self,
         crd_source: &crate::config::CrdSource,
+        repo_path: &Path,
     ) -> Result<SourceResult> {
         // Create plugin context
         let plugin_config = PluginConfig {
@@ -414,10 +1226,9 @@ impl JsonnetGen {
         );
 
         // Process with plugin manager
-        let repo_path = self.git_manager.ensure_repository(&crd_source.git).await?;
         let plugin_result = self
             .plugin_manager
-            .process_source(&repo_path, &context)
+            .process_source(repo_path, &context)
             .await?;
 
         // Convert plugin result to source result
@@ -435,73 +1246,895 @@ impl JsonnetGen {
     async fn process_go_source(
         &self,
         go_ast_source: &crate::config::GoAstSource,
-    ) -> Result<SourceResult> {
+        repo_path: &Path,
+    ) -> Result<(SourceResult, HashMap<PathBuf, Vec<String>>)> {
+        let go_files = self.find_source_go_files(go_ast_source, repo_path).await?;
+        self.process_go_files(go_ast_source, repo_path, &go_files)
+            .await
+    }
+
+    /// Process the given Go files of a source with the AST plugin
+    async fn process_go_files(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        repo_path: &Path,
+        go_files: &[PathBuf],
+    ) -> Result<(SourceResult, HashMap<PathBuf, Vec<String>>)> {
+        if go_ast_source.options.streaming {
+            return self
+                .process_go_files_streaming(go_ast_source, repo_path, go_files)
+                .await;
+        }
+
         let start_time = std::time::Instant::now();
+        let quota = quota::QuotaTracker::start(&self.quotas(), &go_ast_source.name);
 
-        // Ensure repository is available
-        let repo_path = self
-            .git_manager
-            .ensure_repository(&go_ast_source.git)
-            .await?;
+        let mut errors = Vec::new();
+        let mut warnings = Vec::new();
 
-        // Find Go source files
-        let go_files = self
-            .find_go_files(
-                &repo_path,
-                &go_ast_source.include_patterns,
-                &go_ast_source.exclude_patterns,
+        // Extractions and types of the previous run, to skip unchanged files
+        // The number of workers does not change the output
+        let generation = GenerationConfig {
+            jobs: None,
+            ..self.config.generation.clone()
+        };
+        let graph_key =
+            integrity::config_sha256(&(env!("CARGO_PKG_VERSION"), go_ast_source, &generation))?;
+        let previous_graph = self
+            .config
+            .generation
+            .dependency_graph
+            .then(|| depgraph::DepGraph::load(&go_ast_source.output_path, &graph_key));
+        let mut file_cache = previous_graph
+            .as_ref()
+            .map(|graph| depgraph::FileCache::new(graph, repo_path));
+
+        let mut all_schemas = self
+            .parse_go_source(
+                go_ast_source,
+                go_files,
+                &quota,
+                file_cache.as_mut(),
+                &mut errors,
+                &mut warnings,
             )
             .await?;
 
-        if go_files.is_empty() {
-            return Err(anyhow::anyhow!(
-                "No Go source files found matching the patterns"
-            ));
+        // Constructs that could not be represented, for the report of the run
+        let plugin::ast::pipeline::Records {
+            unsupported,
+            mut skipped_types,
+            constants,
+            errors: error_catalog,
+            warnings: record_warnings,
+        } = plugin::ast::pipeline::take_records(&mut all_schemas, &go_ast_source.name, repo_path);
+        if let Ok(mut constructs) = self.unsupported.lock() {
+            constructs.retain(|construct| construct.source != go_ast_source.name);
+            constructs.extend(unsupported.iter().cloned());
+        }
+        for warning in record_warnings {
+            self.config
+                .generation
+                .diagnostics
+                .record(warning, &mut warnings, &mut errors);
         }
 
-        // Process each Go file with the plugin
-        let mut all_schemas = Vec::new();
-        let mut total_errors = 0;
-        let total_warnings = 0;
+        // Types the roots of the source do not depend on
+        let mut unreachable =
+            plugin::ast::roots::take_unreachable(&mut all_schemas, &go_ast_source.options)?;
+        skipped_types.extend(report::take_skipped_types(&mut unreachable, repo_path));
+
+        // Oversized sources are rejected before anything is written
+        quota.check_types(all_schemas.len())?;
+        if quota.limits_output() {
+            let generator = plugin::ast::GoJsonnetGenerator::new();
+            let mut bytes = 0;
+            for schema in &all_schemas {
+                quota.check_duration()?;
+                bytes += generator.generate(schema)?.len() as u64;
+            }
+            quota.check_output_bytes(bytes)?;
+        }
 
-        for go_file in &go_files {
-            match self
-                .process_go_file_with_plugin(go_file, go_ast_source)
+        // Record the Go import path of each type for the index
+        for schema in &mut all_schemas {
+            let dir = schema.source_file.parent().unwrap_or(repo_path);
+            if let Some(import_path) = plugin::ast::gomod::import_path(repo_path, dir) {
+                schema.metadata.insert(
+                    "import_path".to_string(),
+                    serde_yaml::Value::String(import_path),
+                );
+            }
+        }
+
+        // Fields typed with other packages, of this module or of the workspace
+        let workspace = plugin::ast::gowork::workspace_modules(repo_path);
+        if go_ast_source.options.fetch_dependencies {
+            for warning in self
+                .fetch_dependencies(&mut all_schemas, &workspace, repo_path, go_ast_source)
                 .await
             {
-                Ok(schemas) => {
-                    all_schemas.extend(schemas);
+                self.config
+                    .generation
+                    .diagnostics
+                    .record(warning, &mut warnings, &mut errors);
+            }
+            quota.check_types(all_schemas.len())?;
+        }
+
+        // Types of several packages written to the same file
+        for warning in plugin::ast::pipeline::link(
+            &mut all_schemas,
+            &go_ast_source.options,
+            &workspace,
+            |schema| {
+                let package = schema
+                    .metadata
+                    .get("package")
+                    .and_then(|p| p.as_str())
+                    .unwrap_or_default();
+                let import_path = schema.metadata.get("import_path").and_then(|p| p.as_str());
+                let outputs = &go_ast_source.options.package_outputs;
+                outputs
+                    .iter()
+                    .position(|output| output.matches(package, import_path))
+                    .unwrap_or(outputs.len())
+            },
+        )? {
+            self.config
+                .generation
+                .diagnostics
+                .record(warning, &mut warnings, &mut errors);
+        }
+
+        // Types whose schema changed since the previous run, and their dependents
+        let graph = match (&previous_graph, file_cache) {
+            (Some(_), Some(cache)) => {
+                info!(
+                    "Reused the extraction of {} of {} files of {}",
+                    cache.reused,
+                    go_files.len(),
+                    go_ast_source.name
+                );
+                Some(depgraph::DepGraph::new(
+                    graph_key,
+                    cache.files,
+                    &all_schemas,
+                )?)
+            }
+            _ => None,
+        };
+        let affected = match (&previous_graph, &graph) {
+            (Some(previous), Some(graph)) if !previous.is_empty() => {
+                let affected = previous.affected(graph);
+                info!(
+                    "Regenerating {} of {} types of {}",
+                    affected.len(),
+                    graph.types.len(),
+                    go_ast_source.name
+                );
+                Some(affected)
+            }
+            _ => None,
+        };
+
+        // Packages routed to output directories of their own
+        let package_outputs = &go_ast_source.options.package_outputs;
+        let route_of = |schema: &crate::plugin::ExtractedSchema| {
+            let package = schema
+                .metadata
+                .get("package")
+                .and_then(|p| p.as_str())
+                .unwrap_or_default();
+            let import_path = schema.metadata.get("import_path").and_then(|p| p.as_str());
+            package_outputs
+                .iter()
+                .position(|output| output.matches(package, import_path))
+        };
+        let output_of = |schema: &crate::plugin::ExtractedSchema| match route_of(schema) {
+            Some(idx) => package_outputs[idx].output_path.as_path(),
+            None => go_ast_source.output_path.as_path(),
+        };
+        let route_of_package = |package: &str| {
+            package_outputs
+                .iter()
+                .position(|output| output.matches(package, None))
+        };
+        let main_schemas: Vec<_> = all_schemas
+            .iter()
+            .filter(|schema| route_of(schema).is_none())
+            .cloned()
+            .collect();
+        let values = PackageValues {
+            constants,
+            errors: error_catalog,
+        };
+        let main_values = values.filter(|package| route_of_package(package).is_none());
+
+        // Generate Jsonnet code from schemas
+        let mut generated_files = self
+            .generate_go_jsonnet(
+                &main_schemas,
+                &main_values,
+                &go_ast_source.output_path,
+                &go_ast_source.options,
+                affected.as_ref(),
+                &mut errors,
+            )
+            .await?;
+        for (idx, output) in package_outputs.iter().enumerate() {
+            let schemas: Vec<_> = all_schemas
+                .iter()
+                .filter(|schema| route_of(schema) == Some(idx))
+                .cloned()
+                .collect();
+            let output_values = values.filter(|package| route_of_package(package) == Some(idx));
+            generated_files.extend(
+                self.generate_go_jsonnet(
+                    &schemas,
+                    &output_values,
+                    &output.output_path,
+                    &go_ast_source.options,
+                    affected.as_ref(),
+                    &mut errors,
+                )
+                .await?,
+            );
+        }
+
+        // Schema and defaults of the values of a Helm chart
+        if let Some(values) = &go_ast_source.options.helm_values {
+            match plugin::ast::helm::find_values(&all_schemas, values) {
+                Ok(idx) => {
+                    let schema_file = go_ast_source
+                        .output_path
+                        .join(plugin::ast::helm::VALUES_SCHEMA_FILE);
+                    self.write_output(
+                        &schema_file,
+                        plugin::ast::helm::generate_values_schema(&all_schemas, idx)?,
+                    )?;
+                    let values_file = go_ast_source
+                        .output_path
+                        .join(plugin::ast::helm::VALUES_FILE);
+                    self.write_output(
+                        &values_file,
+                        plugin::ast::helm::generate_values(&all_schemas, idx),
+                    )?;
+                    generated_files.push(schema_file);
+                    generated_files.push(values_file);
                 }
                 Err(e) => {
-                    total_errors += 1;
-                    tracing::warn!("Failed to process Go file {}: {}", go_file.display(), e);
+                    tracing::error!("{}", e);
+                    errors.push(e.to_string());
                 }
             }
         }
 
-        // Generate Jsonnet code from schemas
-        let generated_files = self
-            .generate_jsonnet_from_schemas(&all_schemas, &go_ast_source.output_path)
-            .await?;
+        // Go tests rendering the libraries into the original types
+        if go_ast_source.options.go_tests {
+            generated_files.extend(self.write_go_tests(go_ast_source, &main_schemas, repo_path)?);
+        }
+
+        // Custom templates, rendered with the types of the output path
+        if !go_ast_source.options.templates.is_empty() {
+            let _phase = profile::phase("template");
+            let evaluator = evaluate::Evaluator::new(
+                self.config.generation.jsonnet_command.as_deref(),
+                vec![go_ast_source.output_path.clone()],
+            );
+            let types = template::type_graph(&main_schemas)?;
+            for template_file in &go_ast_source.options.templates {
+                let rendered = template::render(&evaluator, template_file, &types)?;
+                for (file, code) in rendered.into_files(template_file) {
+                    let output_file = go_ast_source.output_path.join(file);
+                    if let Some(parent) = output_file.parent() {
+                        std::fs::create_dir_all(parent)?;
+                    }
+                    self.write_output(&output_file, code)?;
+                    generated_files.push(output_file);
+                }
+            }
+        }
+
+        // Constructor arguments for evaluating the output with `--verify`
+        let generator = plugin::ast::GoJsonnetGenerator::new();
+        let mut samples: HashMap<PathBuf, Vec<String>> = all_schemas
+            .iter()
+            .map(|schema| {
+                (
+                    output_of(schema).join(generator.file_name(schema)),
+                    generator.sample_new_args(schema),
+                )
+            })
+            .collect();
+
+        // Variant with hashed names, for sharing without revealing internal naming
+        if let Some(obfuscate) = &go_ast_source.options.obfuscate {
+            let obfuscator = plugin::ast::obfuscate::Obfuscator::new(obfuscate.salt.as_str());
+            let (schemas, mapping) = obfuscator.obfuscate(&all_schemas);
+            for schema in &schemas {
+                samples.insert(
+                    obfuscate.output_path.join(generator.file_name(schema)),
+                    generator.sample_new_args(schema),
+                );
+            }
+            generated_files.extend(
+                // Constant and error names are not hashed, so the variant has neither
+                self.generate_go_jsonnet(
+                    &schemas,
+                    &PackageValues::default(),
+                    &obfuscate.output_path,
+                    &go_ast_source.options,
+                    None,
+                    &mut errors,
+                )
+                .await?,
+            );
+
+            if let Some(parent) = obfuscate.mapping_file.parent() {
+                tokio::fs::create_dir_all(parent).await?;
+            }
+            self.write_output(
+                &obfuscate.mapping_file,
+                serde_json::to_string_pretty(&mapping)? + "\n",
+            )?;
+        }
 
         let processing_time = start_time.elapsed();
+        if !errors.is_empty() {
+            error!(
+                "Source {} has {} errors; the affected types were not generated",
+                go_ast_source.name,
+                errors.len()
+            );
+        }
 
-        Ok(SourceResult {
+        // Types that failed are regenerated by the next run, which compares
+        // with the last graph of a run without errors
+        if let Some(graph) = &graph {
+            if errors.is_empty() {
+                graph.write(&go_ast_source.output_path)?;
+            }
+        }
+
+        if let Ok(mut run_report) = self.report.lock() {
+            run_report.record(report::SourceReport {
+                skipped_types,
+                warnings: warnings.clone(),
+                errors: errors.clone(),
+                ..report::SourceReport::new(
+                    &go_ast_source.name,
+                    repo_path,
+                    &all_schemas,
+                    &unsupported,
+                )
+            });
+        }
+
+        let result = SourceResult {
             source_type: "go_ast".to_string(),
             files_generated: generated_files.len(),
-            errors: if total_errors > 0 {
-                vec![format!("{} files failed to process", total_errors)]
-            } else {
-                vec![]
-            },
+            errors,
             output_path: go_ast_source.output_path.clone(),
             processing_time_ms: processing_time.as_millis() as u64,
-            warnings: if total_warnings > 0 {
-                vec![format!("{} warnings generated", total_warnings)]
+            warnings,
+        };
+        Ok((result, samples))
+    }
+
+    /// Process the Go files of a source one package at a time
+    ///
+    /// The libraries of each package are written before the next package is
+    /// parsed, and only outlines of the types are kept for the index and the
+    /// other files covering every type (see `plugin::ast::stream`).
+    async fn process_go_files_streaming(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        repo_path: &Path,
+        go_files: &[PathBuf],
+    ) -> Result<(SourceResult, HashMap<PathBuf, Vec<String>>)> {
+        let start_time = std::time::Instant::now();
+        let quota = quota::QuotaTracker::start(&self.quotas(), &go_ast_source.name);
+        let options = &go_ast_source.options;
+        let generator = plugin::ast::GoJsonnetGenerator::new();
+        let workspace = plugin::ast::gowork::workspace_modules(repo_path);
+
+        let mut errors = Vec::new();
+        let mut warnings = Vec::new();
+        let mut unsupported = Vec::new();
+        let mut skipped_types = Vec::new();
+        let mut values = PackageValues::default();
+        let mut targets = plugin::ast::gowork::ReferenceTargets::new();
+        let mut samples = HashMap::new();
+        let mut go_tests = Vec::new();
+        let mut types = 0;
+        let mut bytes = 0;
+
+        // Libraries of each package output, then of the output path of the source
+        let package_outputs = &options.package_outputs;
+        let main = package_outputs.len();
+        let route_of = |package: &str, import_path: Option<&str>| {
+            package_outputs
+                .iter()
+                .position(|output| output.matches(package, import_path))
+                .unwrap_or(main)
+        };
+        let route_of_schema = |schema: &crate::plugin::ExtractedSchema| {
+            let package = schema
+                .metadata
+                .get("package")
+                .and_then(|p| p.as_str())
+                .unwrap_or_default();
+            let import_path = schema.metadata.get("import_path").and_then(|p| p.as_str());
+            route_of(package, import_path)
+        };
+        let mut files = HashMap::new();
+        let mut libraries: Vec<GoLibraries> = package_outputs
+            .iter()
+            .map(|output| output.output_path.as_path())
+            .chain([go_ast_source.output_path.as_path()])
+            .map(|output_path| GoLibraries::new(output_path, options))
+            .collect();
+        let mut outlines: Vec<Vec<crate::plugin::ExtractedSchema>> =
+            vec![Vec::new(); libraries.len()];
+
+        let batches = plugin::ast::stream::package_batches(repo_path, go_files);
+        info!(
+            "Processing the {} packages of {} one at a time",
+            batches.len(),
+            go_ast_source.name
+        );
+        for batch in batches {
+            let mut schemas = self
+                .parse_go_source(
+                    go_ast_source,
+                    &batch,
+                    &quota,
+                    None,
+                    &mut errors,
+                    &mut warnings,
+                )
+                .await?;
+            let records =
+                plugin::ast::pipeline::take_records(&mut schemas, &go_ast_source.name, repo_path);
+            for warning in records.warnings {
+                self.config
+                    .generation
+                    .diagnostics
+                    .record(warning, &mut warnings, &mut errors);
+            }
+            unsupported.extend(records.unsupported);
+            skipped_types.extend(records.skipped_types);
+            values.constants.extend(records.constants);
+            values.errors.extend(records.errors);
+
+            // A source over its quotas fails before the package reaching them is written
+            types += schemas.len();
+            quota.check_types(types)?;
+            if quota.limits_output() {
+                for schema in &schemas {
+                    quota.check_duration()?;
+                    bytes += generator.generate(schema)?.len() as u64;
+                }
+                quota.check_output_bytes(bytes)?;
+            }
+
+            // References to the packages processed before
+            if let Some(dir) = batch.first().and_then(|file| file.parent()) {
+                if let Some(import_path) = plugin::ast::gomod::import_path(repo_path, dir) {
+                    for schema in &mut schemas {
+                        schema.metadata.insert(
+                            "import_path".to_string(),
+                            serde_yaml::Value::String(import_path.clone()),
+                        );
+                    }
+                }
+            }
+            for (type_name, name) in plugin::ast::collisions::resolve_collisions(
+                &mut schemas,
+                options.name_collisions.unwrap_or_default(),
+                route_of_schema,
+                &mut files,
+            )? {
+                info!("Generating {} as {}", type_name, name);
+            }
+            plugin::ast::gowork::add_reference_targets(&mut targets, &schemas);
+            for warning in
+                plugin::ast::gowork::resolve_references_to(&mut schemas, &targets, &workspace)
+            {
+                self.config
+                    .generation
+                    .diagnostics
+                    .record(warning, &mut warnings, &mut errors);
+            }
+
+            let mut routed: Vec<Vec<crate::plugin::ExtractedSchema>> =
+                vec![Vec::new(); libraries.len()];
+            for schema in schemas {
+                routed[route_of_schema(&schema)].push(schema);
+            }
+            for (idx, schemas) in routed.into_iter().enumerate() {
+                if schemas.is_empty() {
+                    continue;
+                }
+                let output = &mut libraries[idx];
+                output.add_files(&schemas);
+                self.render_go_libraries(output, &schemas, options, None, &mut errors)
+                    .await?;
+                for schema in &schemas {
+                    samples.insert(
+                        output.output_path.join(generator.file_name(schema)),
+                        generator.sample_new_args(schema),
+                    );
+                }
+
+                // Go tests rendering the libraries into the original types
+                if options.go_tests && idx == main {
+                    go_tests.extend(self.write_go_tests(go_ast_source, &schemas, repo_path)?);
+                }
+                outlines[idx].extend(schemas.into_iter().map(plugin::ast::stream::outline));
+            }
+        }
+
+        // Files covering every type, from the outlines
+        let mut generated_files = go_tests;
+        for (idx, output) in libraries.into_iter().enumerate() {
+            let output_values = values.filter(|package| route_of(package, None) == idx);
+            generated_files.extend(
+                self.write_go_aggregates(output, &outlines[idx], &output_values, options)
+                    .await?,
+            );
+        }
+
+        if !errors.is_empty() {
+            error!(
+                "Source {} has {} errors; the affected types were not generated",
+                go_ast_source.name,
+                errors.len()
+            );
+        }
+        if let Ok(mut constructs) = self.unsupported.lock() {
+            constructs.retain(|construct| construct.source != go_ast_source.name);
+            constructs.extend(unsupported.iter().cloned());
+        }
+        if let Ok(mut run_report) = self.report.lock() {
+            run_report.record(report::SourceReport {
+                skipped_types,
+                warnings: warnings.clone(),
+                errors: errors.clone(),
+                ..report::SourceReport::new(
+                    &go_ast_source.name,
+                    repo_path,
+                    &outlines.concat(),
+                    &unsupported,
+                )
+            });
+        }
+
+        let result = SourceResult {
+            source_type: "go_ast".to_string(),
+            files_generated: generated_files.len(),
+            errors,
+            output_path: go_ast_source.output_path.clone(),
+            processing_time_ms: start_time.elapsed().as_millis() as u64,
+            warnings,
+        };
+        Ok((result, samples))
+    }
+
+    /// Find the Go files of a source matching its patterns
+    async fn find_source_go_files(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        repo_path: &Path,
+    ) -> Result<Vec<PathBuf>> {
+        let go_files = self
+            .find_go_files(
+                repo_path,
+                &go_ast_source.include_patterns,
+                &go_ast_source.exclude_patterns,
+            )
+            .await?;
+
+        if go_files.is_empty() {
+            return Err(anyhow::anyhow!(
+                "No Go source files found matching the patterns"
+            ));
+        }
+        Ok(go_files)
+    }
+
+    /// Parse the Go files of a source into schemas
+    ///
+    /// Errors of the files are added to `errors` unless `fail_fast` is set, and
+    /// interfaces and API versions are resolved across the files.
+    async fn parse_go_source(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        go_files: &[PathBuf],
+        quota: &quota::QuotaTracker,
+        mut file_cache: Option<&mut depgraph::FileCache<'_>>,
+        errors: &mut Vec<String>,
+        warnings: &mut Vec<String>,
+    ) -> Result<Vec<crate::plugin::ExtractedSchema>> {
+        // Files unchanged since the dependency graph was written are not parsed
+        let mut lookups = Vec::with_capacity(go_files.len());
+        let mut to_parse = Vec::new();
+        for go_file in go_files {
+            match file_cache.as_deref_mut() {
+                Some(cache) => {
+                    let (hash, cached) = cache.lookup(go_file)?;
+                    if cached.is_none() {
+                        to_parse.push(go_file.clone());
+                    }
+                    lookups.push((Some(hash), cached));
+                }
+                None => {
+                    to_parse.push(go_file.clone());
+                    lookups.push((None, None));
+                }
+            }
+        }
+        let mut parsed = self.parse_go_files(to_parse, go_ast_source)?;
+
+        // Merge the files in their order, so the output does not depend on
+        // which worker finished first; errors of every file are collected,
+        // so they can all be fixed at once
+        let mut all_schemas = Vec::new();
+        for (go_file, (hash, cached)) in go_files.iter().zip(lookups) {
+            quota.check_duration()?;
+
+            let diagnostics = &self.config.generation.diagnostics;
+            let mut file_errors = Vec::new();
+            if let Some(cached) = cached {
+                all_schemas.extend(cached.schemas);
+                for warning in cached.warnings {
+                    diagnostics.record_in(go_file, warning, warnings, &mut file_errors);
+                }
             } else {
-                vec![]
-            },
-        })
+                let result = parsed
+                    .next()
+                    .await
+                    .unwrap_or_else(|| Err(anyhow::anyhow!("File was not parsed")));
+                match result {
+                    Ok(result) => {
+                        if let (Some(cache), Some(hash), true) =
+                            (file_cache.as_deref_mut(), hash, result.errors.is_empty())
+                        {
+                            cache.record(go_file, hash, &result.schemas, &result.warnings);
+                        }
+                        all_schemas.extend(result.schemas);
+                        for error in &result.errors {
+                            tracing::error!("{}", error);
+                        }
+                        file_errors.extend(result.errors);
+                        for warning in result.warnings {
+                            diagnostics.record_in(go_file, warning, warnings, &mut file_errors);
+                        }
+                    }
+                    Err(e) => {
+                        let error = format!("{}: {}", go_file.display(), e);
+                        tracing::error!("{}", error);
+                        file_errors.push(error);
+                    }
+                }
+            }
+            if self.config.generation.fail_fast && !file_errors.is_empty() {
+                return Err(anyhow::anyhow!("{}", file_errors.join("\n")));
+            }
+            errors.extend(file_errors);
+        }
+
+        let _phase = profile::phase("resolve");
+        for warning in plugin::ast::pipeline::resolve(&mut all_schemas, &go_ast_source.options)? {
+            self.config
+                .generation
+                .diagnostics
+                .record(warning, warnings, errors);
+        }
+
+        Ok(all_schemas)
+    }
+
+    /// Extract the types of a source without generating it
+    ///
+    /// Go AST sources are parsed as for generation, without fetching the
+    /// types referenced from other modules; the records that are not types,
+    /// such as skipped types, are removed.
+    pub async fn extract_source_schemas(
+        &self,
+        source: &Source,
+    ) -> Result<Vec<crate::plugin::ExtractedSchema>> {
+        let repo_path = self.git_manager.ensure_repository(source.git()).await?;
+        match source {
+            Source::Crd(crd_source) => {
+                let schemas = self
+                    .crd_parser
+                    .parse_from_directory(&repo_path, &crd_source.filters)?;
+                schemas
+                    .iter()
+                    .map(|schema| {
+                        Ok(crate::plugin::ExtractedSchema {
+                            name: schema.kind.clone(),
+                            schema_type: "crd".to_string(),
+                            content: serde_yaml::to_value(schema)?,
+                            source_file: schema.source_path.clone(),
+                            metadata: HashMap::new(),
+                        })
+                    })
+                    .collect()
+            }
+            Source::GoAst(go_ast_source) => {
+                let quota = quota::QuotaTracker::start(&self.quotas(), &go_ast_source.name);
+                let go_files = self.find_source_go_files(go_ast_source, &repo_path).await?;
+                let mut schemas = self
+                    .parse_go_source(
+                        go_ast_source,
+                        &go_files,
+                        &quota,
+                        None,
+                        &mut Vec::new(),
+                        &mut Vec::new(),
+                    )
+                    .await?;
+                plugin::ast::pipeline::take_records(&mut schemas, &go_ast_source.name, &repo_path);
+                plugin::ast::roots::take_unreachable(&mut schemas, &go_ast_source.options)?;
+                Ok(schemas)
+            }
+            Source::OpenApi(openapi_source) => {
+                let mut schemas = Vec::new();
+                for openapi_file in self
+                    .find_openapi_files(
+                        &repo_path,
+                        &openapi_source.include_patterns,
+                        &openapi_source.exclude_patterns,
+                    )
+                    .await?
+                {
+                    schemas.extend(
+                        self.process_openapi_file_with_plugin(&openapi_file, openapi_source)
+                            .await?,
+                    );
+                }
+                Ok(schemas)
+            }
+            Source::Terraform(terraform_source) => {
+                let (schemas, _) = self
+                    .read_terraform_schemas(terraform_source, &repo_path)
+                    .await?;
+                Ok(schemas)
+            }
+            Source::Graphql(graphql_source) => {
+                self.read_graphql_schemas(graphql_source, &repo_path).await
+            }
+        }
+    }
+
+    /// Add the types referenced from packages of required modules
+    ///
+    /// Packages are taken from the repository's `vendor/` directory when it
+    /// has them; other modules are downloaded at the version required by the
+    /// repository's `go.mod`. The referenced types are parsed from their
+    /// packages until every reference is found. Returns warnings for
+    /// references that cannot be fetched.
+    async fn fetch_dependencies(
+        &self,
+        schemas: &mut Vec<crate::plugin::ExtractedSchema>,
+        workspace: &[plugin::ast::gowork::WorkspaceModule],
+        repo_path: &Path,
+        go_ast_source: &crate::config::GoAstSource,
+    ) -> Vec<String> {
+        use plugin::ast::goproxy;
+
+        let mut warnings = Vec::new();
+        let go_mod = tokio::fs::read_to_string(repo_path.join("go.mod"))
+            .await
+            .unwrap_or_default();
+        let requirements = goproxy::requirements(&go_mod);
+
+        let mut modules: HashMap<String, Option<PathBuf>> = HashMap::new();
+        let mut packages: HashMap<String, (String, Vec<crate::plugin::ExtractedSchema>)> =
+            HashMap::new();
+        let mut attempted = HashSet::new();
+        loop {
+            let missing: Vec<(String, String)> = goproxy::missing_references(schemas, workspace)
+                .into_iter()
+                .filter(|reference| attempted.insert(reference.clone()))
+                .collect();
+            if missing.is_empty() {
+                return warnings;
+            }
+
+            for (package, type_name) in missing {
+                if !packages.contains_key(&package) {
+                    // Vendored packages need neither go.mod nor the network
+                    let (dir, origin) = match goproxy::vendored_package(repo_path, &package) {
+                        Some(dir) => (dir, format!("vendor/{package}")),
+                        None => {
+                            let Some(requirement) = goproxy::module_of(&package, &requirements)
+                            else {
+                                warnings.push(diagnostics::Code::UnknownModule.warning(format!(
+                                    "{package}.{type_name} is referenced but no module of go.mod provides {package}"
+                                )));
+                                continue;
+                            };
+                            if !modules.contains_key(&requirement.path) {
+                                let dir = match goproxy::download(requirement) {
+                                    Ok(dir) => Some(dir),
+                                    Err(e) => {
+                                        warnings.push(diagnostics::Code::ModuleDownload.warning(e));
+                                        None
+                                    }
+                                };
+                                modules.insert(requirement.path.clone(), dir);
+                            }
+                            let Some(module_dir) = &modules[&requirement.path] else {
+                                continue;
+                            };
+                            (
+                                goproxy::package_dir(module_dir, requirement, &package),
+                                format!("{}@{}", requirement.path, requirement.version),
+                            )
+                        }
+                    };
+
+                    let mut parsed = Vec::new();
+                    let files = self
+                        .find_go_files(&dir, &["*.go".to_string()], &["*_test.go".to_string()])
+                        .await
+                        .unwrap_or_default();
+                    for go_file in &files {
+                        match self
+                            .process_go_file_with_plugin(go_file, go_ast_source)
+                            .await
+                        {
+                            Ok(result) => {
+                                for error in &result.errors {
+                                    tracing::warn!("{}", error);
+                                }
+                                parsed.extend(result.schemas);
+                            }
+                            Err(e) => tracing::warn!(
+                                "Failed to process Go file {}: {}",
+                                go_file.display(),
+                                e
+                            ),
+                        }
+                    }
+                    packages.insert(package.clone(), (origin, parsed));
+                }
+
+                let (origin, parsed) = &packages[&package];
+                let found = parsed.iter().find(|schema| {
+                    schema.name == type_name
+                        && ![
+                            plugin::ast::unions::METHODS_SCHEMA_TYPE,
+                            plugin::ast::scheme::GROUP_VERSION_SCHEMA_TYPE,
+                            plugin::ast::unsupported::UNSUPPORTED_SCHEMA_TYPE,
+                            report::SKIPPED_SCHEMA_TYPE,
+                            plugin::ast::constants::CONSTANTS_SCHEMA_TYPE,
+                            plugin::ast::enums::ENUM_SCHEMA_TYPE,
+                            plugin::ast::constants::CONSTANT_VALUES_SCHEMA_TYPE,
+                            plugin::ast::aliases::PRIMITIVE_TYPES_SCHEMA_TYPE,
+                        ]
+                        .contains(&schema.schema_type.as_str())
+                });
+                match found {
+                    Some(schema) => {
+                        let mut schema = schema.clone();
+                        schema.metadata.insert(
+                            "import_path".to_string(),
+                            serde_yaml::Value::String(package.clone()),
+                        );
+                        schemas.push(schema);
+                    }
+                    None => warnings.push(
+                        diagnostics::Code::UndeclaredType
+                            .warning(format!("{package}.{type_name} is not declared in {origin}")),
+                    ),
+                }
+            }
+        }
     }
 
     /// Find Go source files matching the patterns
@@ -545,39 +2178,468 @@ impl JsonnetGen {
             }
         }
 
-        Ok(go_files)
-    }
-
-    /// Process a single Go file with the plugin
-    async fn process_go_file_with_plugin(
-        &self,
-        go_file: &Path,
-        go_ast_source: &crate::config::GoAstSource,
-    ) -> Result<Vec<crate::plugin::ExtractedSchema>> {
-        // Create plugin context
-        let plugin_config = crate::plugin::PluginConfig {
-            plugin_id: "go-ast:builtin".to_string(),
-            config: serde_yaml::Value::Null,
-            enabled_capabilities: vec![
-                crate::plugin::PluginCapability::Parse,
-                crate::plugin::PluginCapability::SchemaExtraction,
-                crate::plugin::PluginCapability::AstProcessing,
-            ],
-        };
-
-        let context = crate::plugin::PluginContext::new(
-            go_file.parent().unwrap_or(Path::new(".")).to_path_buf(),
-            go_ast_source.output_path.clone(),
-            plugin_config,
-        );
-
-        // Process with plugin manager
-        let plugin_result = self
-            .plugin_manager
-            .process_source(go_file, &context)
-            .await?;
-
-        Ok(plugin_result.schemas)
+        Ok(go_files)
+    }
+
+    /// Parse Go files with the plugin on up to `generation.jobs` workers
+    ///
+    /// Results come in the order of `go_files`, whatever the number of
+    /// workers; no more files are parsed once the stream is dropped.
+    fn parse_go_files(
+        &self,
+        go_files: Vec<PathBuf>,
+        go_ast_source: &crate::config::GoAstSource,
+    ) -> Result<impl futures::Stream<Item = Result<crate::plugin::PluginResult>> + Unpin> {
+        let options = go_ast_source.options.to_value()?;
+        let output_path = go_ast_source.output_path.clone();
+        let plugin_manager = Arc::clone(&self.plugin_manager);
+        let mut packages: HashMap<PathBuf, String> = HashMap::new();
+
+        let tasks = go_files.into_iter().map(move |go_file| {
+            // Parse times are profiled by package import path
+            let package = profile::is_enabled().then(|| {
+                let dir = go_file.parent().unwrap_or(Path::new("."));
+                packages
+                    .entry(dir.to_path_buf())
+                    .or_insert_with(|| {
+                        plugin::ast::gomod::import_path(Path::new("/"), dir)
+                            .unwrap_or_else(|| dir.display().to_string())
+                    })
+                    .clone()
+            });
+            let context = crate::plugin::PluginContext::new(
+                go_file.parent().unwrap_or(Path::new(".")).to_path_buf(),
+                output_path.clone(),
+                go_plugin_config(options.clone()),
+            );
+            let plugin_manager = Arc::clone(&plugin_manager);
+            async move {
+                let task = tokio::spawn(async move {
+                    let _phase = package.map(|package| profile::package_phase("parse", package));
+                    plugin_manager.process_source(&go_file, &context).await
+                });
+                task.await
+                    .unwrap_or_else(|e| Err(anyhow::anyhow!("Parser task failed: {}", e)))
+            }
+        });
+        Ok(Box::pin(
+            futures::stream::iter(tasks).buffered(self.config.generation.jobs()),
+        ))
+    }
+
+    /// Process a single Go file with the plugin
+    async fn process_go_file_with_plugin(
+        &self,
+        go_file: &Path,
+        go_ast_source: &crate::config::GoAstSource,
+    ) -> Result<crate::plugin::PluginResult> {
+        // Create plugin context
+        let context = crate::plugin::PluginContext::new(
+            go_file.parent().unwrap_or(Path::new(".")).to_path_buf(),
+            go_ast_source.output_path.clone(),
+            go_plugin_config(go_ast_source.options.to_value()?),
+        );
+
+        // Process with plugin manager
+        self.plugin_manager.process_source(go_file, &context).await
+    }
+
+    /// Write the Go tests of the packages of `schemas` to the local checkout
+    /// of the source, referencing its output path
+    fn write_go_tests(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        schemas: &[crate::plugin::ExtractedSchema],
+        repo_path: &Path,
+    ) -> Result<Vec<PathBuf>> {
+        let Some(go_checkout) = &go_ast_source.options.go_checkout else {
+            return Err(anyhow::anyhow!(
+                "go_tests of source {} needs go_checkout",
+                go_ast_source.name
+            ));
+        };
+        let generator = plugin::ast::GoJsonnetGenerator::new();
+        let mut test_files = Vec::new();
+        for (dir, (package, schemas)) in plugin::ast::gotest::group_by_package(schemas) {
+            let dir = plugin::ast::gotest::checkout_dir(&dir, repo_path, go_checkout);
+            let lib_dir = plugin::ast::gotest::relative_path(&dir, &go_ast_source.output_path);
+            let test_file = dir.join(plugin::ast::gotest::GO_TEST_FILE);
+            let code =
+                plugin::ast::gotest::generate_go_test(&generator, &package, &schemas, &lib_dir);
+            self.write_output(&test_file, code)?;
+            test_files.push(test_file);
+        }
+        Ok(test_files)
+    }
+
+    /// Generate Jsonnet libraries from schemas extracted out of Go source
+    ///
+    /// Types that fail to generate are added to `errors` with the position of
+    /// their declaration and left out, so every failure of a run is reported.
+    async fn generate_go_jsonnet(
+        &self,
+        schemas: &[crate::plugin::ExtractedSchema],
+        values: &PackageValues,
+        output_path: &Path,
+        options: &plugin::ast::GoAstOptions,
+        affected: Option<&std::collections::BTreeSet<String>>,
+        errors: &mut Vec<String>,
+    ) -> Result<Vec<PathBuf>> {
+        let mut libraries = GoLibraries::new(output_path, options);
+        libraries.add_files(schemas);
+        self.render_go_libraries(&mut libraries, schemas, options, affected, errors)
+            .await?;
+        self.write_go_aggregates(libraries, schemas, values, options)
+            .await
+    }
+
+    /// Write the libraries of types, with their tests and examples
+    ///
+    /// The files of `libraries` must include those of `schemas`, so their
+    /// imports of each other are rewritten.
+    async fn render_go_libraries(
+        &self,
+        libraries: &mut GoLibraries,
+        schemas: &[crate::plugin::ExtractedSchema],
+        options: &plugin::ast::GoAstOptions,
+        affected: Option<&std::collections::BTreeSet<String>>,
+        errors: &mut Vec<String>,
+    ) -> Result<()> {
+        let _phase = profile::phase("render");
+        let generator = plugin::ast::GoJsonnetGenerator::new();
+        let output_path = libraries.output_path.clone();
+        let examples = options.examples.unwrap_or_default();
+        let examples_dir = output_path.join(plugin::ast::generator::EXAMPLES_DIR);
+
+        // Ensure output directory exists
+        tokio::fs::create_dir_all(&output_path).await?;
+
+        for schema in schemas {
+            self.check_deadline()?;
+            let output_file = output_path.join(generator.file_name(schema));
+            let mut code = match generator.generate(schema) {
+                Ok(code) => libraries.rewrite_imports(code, options),
+                Err(e) => {
+                    let line = schema
+                        .metadata
+                        .get("line")
+                        .and_then(|line| line.as_u64())
+                        .unwrap_or_default();
+                    let error = format!(
+                        "{}:{}: Failed to generate {}: {}",
+                        schema.source_file.display(),
+                        line,
+                        schema.name,
+                        e
+                    );
+                    tracing::error!("{}", error);
+                    errors.push(error);
+                    continue;
+                }
+            };
+
+            // Compare with the previous output to keep removed functions as tombstones
+            if options.tombstones {
+                if let Ok(previous) = tokio::fs::read_to_string(&output_file).await {
+                    code = generator.add_tombstones(
+                        schema,
+                        &code,
+                        &previous,
+                        options.release.as_deref(),
+                    );
+                }
+            }
+
+            libraries.api_surface.add_library(
+                &generator.file_name(schema),
+                &code,
+                &generator.param_types(schema),
+            );
+            libraries
+                .migrations
+                .add_library(&generator.file_name(schema), &code);
+
+            // Libraries of types the changes of a run do not affect are kept
+            let unchanged = affected.is_some_and(|affected| !affected.contains(&schema.name))
+                && output_file.is_file();
+            if !unchanged {
+                self.write_output(&output_file, code)?;
+                libraries.generated_files.push(output_file);
+            }
+
+            // jsonnetunit scaffolding; rejected inputs are rewritten from scratch
+            if options.unit_tests && !unchanged {
+                let tests_dir = output_path.join(plugin::ast::generator::TESTS_DIR);
+                let invalid_dir = tests_dir
+                    .join("invalid")
+                    .join(generator.file_name(schema).trim_end_matches(".libsonnet"));
+                if invalid_dir.is_dir() {
+                    tokio::fs::remove_dir_all(&invalid_dir).await?;
+                }
+                for (file, code) in generator.generate_tests(schema) {
+                    let test_file = tests_dir.join(file);
+                    if let Some(parent) = test_file.parent() {
+                        tokio::fs::create_dir_all(parent).await?;
+                    }
+                    self.write_output(&test_file, code)?;
+                    libraries.generated_files.push(test_file);
+                }
+            }
+
+            if examples != plugin::ast::ExampleOutput::None {
+                if let Some(example) = generator.generate_example(schema) {
+                    let example_file = examples_dir.join(
+                        generator
+                            .file_name(schema)
+                            .replace(".libsonnet", ".jsonnet"),
+                    );
+                    tokio::fs::create_dir_all(&examples_dir).await?;
+                    self.write_output(&example_file, example)?;
+                    if schema.content.get("x-go-kubernetes").is_some() {
+                        libraries.resource_examples.push(example_file.clone());
+                    }
+                    libraries.example_files.push(example_file);
+                }
+            }
+        }
+        Ok(())
+    }
+
+    /// Write the files covering every type of an output directory: the
+    /// rendered examples, the index, the constants, the errors and the other
+    /// formats
+    async fn write_go_aggregates(
+        &self,
+        libraries: GoLibraries,
+        schemas: &[crate::plugin::ExtractedSchema],
+        values: &PackageValues,
+        options: &plugin::ast::GoAstOptions,
+    ) -> Result<Vec<PathBuf>> {
+        let _phase = profile::phase("aggregate");
+        let constants = values.constants.as_slice();
+        let go_errors = values.errors.as_slice();
+        let generator = plugin::ast::GoJsonnetGenerator::new();
+        let rewrite_imports = |code: String| libraries.rewrite_imports(code, options);
+        let output_path = libraries.output_path.as_path();
+        let examples = options.examples.unwrap_or_default();
+        let examples_dir = output_path.join(plugin::ast::generator::EXAMPLES_DIR);
+        let mut generated_files = libraries.generated_files.clone();
+        let mut example_files = libraries.example_files.clone();
+        let resource_examples = &libraries.resource_examples;
+        let api_surface = &libraries.api_surface;
+        let migrations = &libraries.migrations;
+
+        // Examples rendered to JSON, or for kubectl, evaluated with the
+        // libraries just written
+        let evaluator = evaluate::Evaluator::new(
+            self.config.generation.jsonnet_command.as_deref(),
+            vec![output_path.to_path_buf()],
+        );
+        if examples == plugin::ast::ExampleOutput::Json {
+            for example_file in example_files.clone() {
+                let json_file = example_file.with_extension("json");
+                let json = manifest::render_example_json(&evaluator, &example_file)?;
+                self.write_output(&json_file, json)?;
+                example_files.push(json_file);
+            }
+        }
+        if examples == plugin::ast::ExampleOutput::Yaml && !resource_examples.is_empty() {
+            let manifests_file = examples_dir.join(manifest::MANIFESTS_FILE);
+            let stream = manifest::render_examples(&evaluator, resource_examples)?;
+            self.write_output(&manifests_file, stream)?;
+            example_files.push(manifests_file);
+        }
+        generated_files.extend(example_files);
+
+        // Index of every generated type, as Jsonnet and as JSON for catalogs;
+        // hand-written overrides are merged into the Jsonnet one
+        let index_file = output_path.join("index.libsonnet");
+        let mut index = generator.generate_index(schemas);
+        if !constants.is_empty() {
+            index = plugin::ast::constants::link_constants(&index);
+        }
+        if !go_errors.is_empty() {
+            index = plugin::ast::errors::link_errors(&index);
+        }
+        let api_file = output_path.join(plugin::ast::api::API_FILE);
+        match plugin::ast::api::generate_api(schemas) {
+            Some(api) => {
+                self.write_output(&api_file, rewrite_imports(api))?;
+                generated_files.push(api_file);
+                index = plugin::ast::api::link_api(&index);
+            }
+            None if api_file.is_file() => tokio::fs::remove_file(&api_file).await?,
+            None => {}
+        }
+        if output_path.join(overrides::OVERRIDES_FILE).is_file() {
+            // Tanka libraries have the index in gen/ and the overrides next to it
+            let import = match self.config.output.preset {
+                jsonnet_generator::config::OutputPreset::Tanka => {
+                    format!("../{}", overrides::OVERRIDES_FILE)
+                }
+                jsonnet_generator::config::OutputPreset::Plain
+                | jsonnet_generator::config::OutputPreset::Argocd => {
+                    format!("./{}", overrides::OVERRIDES_FILE)
+                }
+            };
+            index = overrides::merge_overrides(&index, &import);
+        }
+        self.write_output(&index_file, rewrite_imports(index))?;
+        generated_files.push(index_file);
+
+        let index_json_file = output_path.join("index.json");
+        self.write_output(&index_json_file, generator.generate_index_json(schemas)?)?;
+        generated_files.push(index_json_file);
+
+        // Exported constants of the packages
+        let constants_file = output_path.join(plugin::ast::constants::CONSTANTS_FILE);
+        if constants.is_empty() {
+            if constants_file.is_file() {
+                tokio::fs::remove_file(&constants_file).await?;
+            }
+        } else {
+            self.write_output(
+                &constants_file,
+                plugin::ast::constants::generate_constants(constants),
+            )?;
+            generated_files.push(constants_file);
+        }
+
+        // Exported errors and error codes of the packages
+        let errors_file = output_path.join(plugin::ast::errors::ERRORS_FILE);
+        if go_errors.is_empty() {
+            if errors_file.is_file() {
+                tokio::fs::remove_file(&errors_file).await?;
+            }
+        } else {
+            self.write_output(
+                &errors_file,
+                plugin::ast::errors::generate_errors(go_errors),
+            )?;
+            generated_files.push(errors_file);
+        }
+
+        // Request and response shapes of the methods of interfaces
+        if options.services {
+            let services_dir = output_path.join(plugin::ast::services::SERVICES_DIR);
+            for (file, code) in plugin::ast::services::generate_services(schemas) {
+                tokio::fs::create_dir_all(&services_dir).await?;
+                let service_file = services_dir.join(file);
+                self.write_output(&service_file, code)?;
+                generated_files.push(service_file);
+            }
+            if let Some(catalog) = plugin::ast::services::generate_catalog(schemas) {
+                let catalog_file = services_dir.join(plugin::ast::services::CATALOG_FILE);
+                self.write_output(&catalog_file, catalog)?;
+                generated_files.push(catalog_file);
+            }
+        }
+
+        // Defaults of the fields, a layer environments can replace
+        let defaults_file = output_path.join(plugin::ast::generator::DEFAULTS_FILE);
+        match generator.generate_defaults(schemas) {
+            Some(code) => {
+                self.write_output(&defaults_file, code)?;
+                generated_files.push(defaults_file);
+            }
+            None if defaults_file.is_file() => tokio::fs::remove_file(&defaults_file).await?,
+            None => {}
+        }
+
+        // Conversions between the API versions of Kubernetes kinds
+        let conversions_file = output_path.join(plugin::ast::generator::CONVERSIONS_FILE);
+        match generator.generate_conversions(schemas) {
+            Some(code) => {
+                self.write_output(&conversions_file, code)?;
+                generated_files.push(conversions_file);
+            }
+            None if conversions_file.is_file() => tokio::fs::remove_file(&conversions_file).await?,
+            None => {}
+        }
+
+        // Public functions and their parameters, for contract tests
+        let surface_file = output_path.join(surface::SURFACE_FILE);
+        self.write_output(&surface_file, api_surface.to_json()?)?;
+        generated_files.push(surface_file);
+
+        // Fields, defaults and checks of the types, for semantic diffs
+        let snapshot_file = output_path.join(schemadiff::SNAPSHOT_FILE);
+        let snapshot = schemadiff::Snapshot::from_schemas(schemas);
+        if self.config.generation.changelog {
+            let since = self.config.generation.changelog_since.as_deref();
+            let previous = match since {
+                Some(git_ref) => git::read_file_at(&snapshot_file, git_ref)?
+                    .map(|json| schemadiff::Snapshot::from_json(&json))
+                    .transpose()?,
+                None if snapshot_file.is_file() => {
+                    Some(schemadiff::Snapshot::load(&snapshot_file)?)
+                }
+                None => None,
+            };
+            if let Some(previous) = previous {
+                let mut heading = Utc::now().format("%Y-%m-%d").to_string();
+                if let Some(git_ref) = since {
+                    heading.push_str(&format!(" (since {git_ref})"));
+                }
+                if changelog::write_entry(output_path, &previous.diff(&snapshot), &heading)? {
+                    generated_files.push(output_path.join(changelog::CHANGELOG_FILE));
+                }
+            }
+        }
+        self.write_output(&snapshot_file, snapshot.to_json()?)?;
+        generated_files.push(snapshot_file);
+
+        // Rules migrating consumers off renamed and removed functions
+        let migrations_file = output_path.join(migrate::MIGRATIONS_FILE);
+        let script_file = output_path.join(migrate::MIGRATION_SCRIPT);
+        if migrations.is_empty() {
+            for stale in [&migrations_file, &script_file] {
+                if stale.is_file() {
+                    tokio::fs::remove_file(stale).await?;
+                }
+            }
+        } else {
+            self.write_output(&migrations_file, migrations.to_json()?)?;
+            self.write_output(&script_file, migrations.to_sed())?;
+            generated_files.push(migrations_file);
+            generated_files.push(script_file);
+        }
+
+        // The types in the other formats asked for
+        for format in &self.config.generation.output_formats {
+            match format {
+                crate::config::OutputFormat::JsonSchema => {
+                    let schemas_dir = output_path.join(plugin::ast::jsonschema::SCHEMAS_DIR);
+                    tokio::fs::create_dir_all(&schemas_dir).await?;
+                    for (file, json) in plugin::ast::jsonschema::generate_json_schemas(schemas)? {
+                        let schema_file = schemas_dir.join(file);
+                        self.write_output(&schema_file, json)?;
+                        generated_files.push(schema_file);
+                    }
+                }
+                crate::config::OutputFormat::Cue => {
+                    let cue_dir = output_path.join(plugin::ast::cue::CUE_DIR);
+                    tokio::fs::create_dir_all(&cue_dir).await?;
+                    let package = plugin::ast::cue::package_name(output_path);
+                    for (file, code) in plugin::ast::cue::generate_cue(schemas, &package) {
+                        let cue_file = cue_dir.join(file);
+                        self.write_output(&cue_file, code)?;
+                        generated_files.push(cue_file);
+                    }
+                }
+                crate::config::OutputFormat::TypeScript => {
+                    let declarations_file =
+                        output_path.join(plugin::ast::typescript::DECLARATIONS_FILE);
+                    self.write_output(
+                        &declarations_file,
+                        plugin::ast::typescript::generate_declarations(schemas),
+                    )?;
+                    generated_files.push(declarations_file);
+                }
+            }
+        }
+
+        Ok(generated_files)
     }
 
     /// Generate Jsonnet code from extracted schemas
@@ -596,7 +2658,7 @@ impl JsonnetGen {
 
             // Generate Jsonnet code from the schema
             let jsonnet_code = self.generate_jsonnet_code(schema)?;
-            tokio::fs::write(&output_file, jsonnet_code).await?;
+            self.write_output(&output_file, jsonnet_code)?;
 
             generated_files.push(output_file);
         }
@@ -608,7 +2670,7 @@ impl JsonnetGen {
     fn generate_jsonnet_code(&self, schema: &crate::plugin::ExtractedSchema) -> Result<String> {
         let mut code = String::new();
 
-        code.push_str(&format!("// Generated from Go AST: {}\n", schema.name));
+        code.push_str(&format!("// Generated from OpenAPI: {}\n", schema.name));
         code.push_str(&format!("// Source: {}\n\n", schema.source_file.display()));
 
         // Add imports
@@ -634,19 +2696,14 @@ impl JsonnetGen {
     async fn process_openapi_source(
         &self,
         openapi_source: &crate::config::OpenApiSource,
+        repo_path: &Path,
     ) -> Result<SourceResult> {
         let start_time = std::time::Instant::now();
 
-        // Ensure repository is available
-        let repo_path = self
-            .git_manager
-            .ensure_repository(&openapi_source.git)
-            .await?;
-
         // Find OpenAPI specification files
         let openapi_files = self
             .find_openapi_files(
-                &repo_path,
+                repo_path,
                 &openapi_source.include_patterns,
                 &openapi_source.exclude_patterns,
             )
@@ -707,6 +2764,165 @@ impl JsonnetGen {
         })
     }
 
+    /// Process a Terraform provider schema source
+    async fn process_terraform_source(
+        &self,
+        terraform_source: &crate::config::TerraformSource,
+        repo_path: &Path,
+    ) -> Result<SourceResult> {
+        let start_time = std::time::Instant::now();
+
+        let (schemas, mut errors) = self
+            .read_terraform_schemas(terraform_source, repo_path)
+            .await?;
+        let output_path = &terraform_source.output_path;
+
+        // Block bodies are built like Go types; the helpers place them
+        let mut generated_files = self
+            .generate_go_jsonnet(
+                &schemas,
+                &PackageValues::default(),
+                output_path,
+                &plugin::ast::GoAstOptions::default(),
+                None,
+                &mut errors,
+            )
+            .await?;
+        let blocks_file = output_path.join(terraform::BLOCKS_FILE);
+        self.write_output(&blocks_file, terraform::generate_blocks(&schemas))?;
+        generated_files.push(blocks_file);
+
+        let processing_time = start_time.elapsed();
+
+        Ok(SourceResult {
+            source_type: "terraform".to_string(),
+            files_generated: generated_files.len(),
+            errors,
+            output_path: output_path.clone(),
+            processing_time_ms: processing_time.as_millis() as u64,
+            warnings: vec![],
+        })
+    }
+
+    /// Read the resources and data sources of the provider schema files of a source
+    ///
+    /// A type in several files is taken from the first one. Files that
+    /// cannot be read are reported in the returned errors.
+    async fn read_terraform_schemas(
+        &self,
+        terraform_source: &crate::config::TerraformSource,
+        repo_path: &Path,
+    ) -> Result<(Vec<crate::plugin::ExtractedSchema>, Vec<String>)> {
+        let schema_files = self
+            .find_openapi_files(
+                repo_path,
+                &terraform_source.include_patterns,
+                &terraform_source.exclude_patterns,
+            )
+            .await?;
+
+        if schema_files.is_empty() {
+            return Err(anyhow::anyhow!(
+                "No Terraform provider schema files found matching the patterns"
+            ));
+        }
+
+        let mut schemas: Vec<crate::plugin::ExtractedSchema> = Vec::new();
+        let mut errors = Vec::new();
+        for schema_file in &schema_files {
+            let parsed = tokio::fs::read_to_string(schema_file)
+                .await
+                .map_err(anyhow::Error::from)
+                .and_then(|json| {
+                    terraform::parse_provider_schemas(
+                        &json,
+                        schema_file,
+                        &terraform_source.providers,
+                    )
+                });
+            match parsed {
+                Ok(parsed) => {
+                    for schema in parsed {
+                        if !schemas.iter().any(|s| {
+                            s.name == schema.name
+                                && s.metadata.get("import_path")
+                                    == schema.metadata.get("import_path")
+                        }) {
+                            schemas.push(schema);
+                        }
+                    }
+                }
+                Err(e) => {
+                    let error = format!("{}: {}", schema_file.display(), e);
+                    tracing::warn!("Failed to process Terraform provider schema {}", error);
+                    errors.push(error);
+                }
+            }
+        }
+        Ok((schemas, errors))
+    }
+
+    /// Process a GraphQL schema source
+    async fn process_graphql_source(
+        &self,
+        graphql_source: &crate::config::GraphqlSource,
+        repo_path: &Path,
+    ) -> Result<SourceResult> {
+        let start_time = std::time::Instant::now();
+
+        let schemas = self.read_graphql_schemas(graphql_source, repo_path).await?;
+        let mut errors = Vec::new();
+        let generated_files = self
+            .generate_go_jsonnet(
+                &schemas,
+                &PackageValues::default(),
+                &graphql_source.output_path,
+                &plugin::ast::GoAstOptions::default(),
+                None,
+                &mut errors,
+            )
+            .await?;
+
+        let processing_time = start_time.elapsed();
+
+        Ok(SourceResult {
+            source_type: "graphql".to_string(),
+            files_generated: generated_files.len(),
+            errors,
+            output_path: graphql_source.output_path.clone(),
+            processing_time_ms: processing_time.as_millis() as u64,
+            warnings: vec![],
+        })
+    }
+
+    /// Read the types of the SDL files of a source, as one schema
+    async fn read_graphql_schemas(
+        &self,
+        graphql_source: &crate::config::GraphqlSource,
+        repo_path: &Path,
+    ) -> Result<Vec<crate::plugin::ExtractedSchema>> {
+        let sdl_files = self
+            .find_openapi_files(
+                repo_path,
+                &graphql_source.include_patterns,
+                &graphql_source.exclude_patterns,
+            )
+            .await?;
+
+        if sdl_files.is_empty() {
+            return Err(anyhow::anyhow!(
+                "No GraphQL schema files found matching the patterns"
+            ));
+        }
+
+        let mut files = Vec::new();
+        for sdl_file in sdl_files {
+            let sdl = tokio::fs::read_to_string(&sdl_file).await?;
+            files.push((sdl_file, sdl));
+        }
+        graphql::parse_schema(&files, &graphql_source.name)
+    }
+
     /// Get current source commit information
     async fn get_current_source_commits(&self) -> Result<HashMap<String, String>> {
         let mut commits = HashMap::new();
@@ -734,6 +2950,22 @@ impl JsonnetGen {
                     let commit_sha = self.git_manager.get_current_commit(&repo_path)?;
                     commits.insert(source.name().to_string(), commit_sha);
                 }
+                Source::Terraform(terraform_source) => {
+                    let repo_path = self
+                        .git_manager
+                        .ensure_repository(&terraform_source.git)
+                        .await?;
+                    let commit_sha = self.git_manager.get_current_commit(&repo_path)?;
+                    commits.insert(source.name().to_string(), commit_sha);
+                }
+                Source::Graphql(graphql_source) => {
+                    let repo_path = self
+                        .git_manager
+                        .ensure_repository(&graphql_source.git)
+                        .await?;
+                    let commit_sha = self.git_manager.get_current_commit(&repo_path)?;
+                    commits.insert(source.name().to_string(), commit_sha);
+                }
             }
         }
 
@@ -756,6 +2988,50 @@ impl JsonnetGen {
         }
     }
 
+    /// Write the report of the constructs that could not be represented
+    async fn write_unsupported_report(&self, path: &Path) -> Result<()> {
+        let constructs = match self.unsupported.lock() {
+            Ok(constructs) => constructs.clone(),
+            Err(e) => {
+                return Err(anyhow::anyhow!(
+                    "Failed to collect unsupported constructs: {}",
+                    e
+                ))
+            }
+        };
+        let report = plugin::ast::unsupported::render_report(path, &constructs)?;
+        if let Some(parent) = path
+            .parent()
+            .filter(|parent| !parent.as_os_str().is_empty())
+        {
+            tokio::fs::create_dir_all(parent).await?;
+        }
+        tokio::fs::write(path, report).await?;
+        info!(
+            "Reported {} unsupported constructs in {:?}",
+            constructs.len(),
+            path
+        );
+        Ok(())
+    }
+
+    /// Write the machine-readable report of the run
+    async fn write_run_report(&self, path: &Path) -> Result<()> {
+        let json = match self.report.lock() {
+            Ok(run_report) => run_report.to_json()?,
+            Err(e) => return Err(anyhow::anyhow!("Failed to collect the run report: {}", e)),
+        };
+        if let Some(parent) = path
+            .parent()
+            .filter(|parent| !parent.as_os_str().is_empty())
+        {
+            tokio::fs::create_dir_all(parent).await?;
+        }
+        tokio::fs::write(path, json).await?;
+        info!("Wrote the report of the run to {:?}", path);
+        Ok(())
+    }
+
     /// Update lockfile with generation results
     async fn update_lockfile(&self, result: &GenerationResult) -> Result<()> {
         let mut lockfile = self.lockfile_manager.load_or_create()?;
@@ -996,17 +3272,51 @@ impl JsonnetGen {
                         errors: vec![e.to_string()],
                         warnings: Vec::new(),
                         output_path: source.output_path().to_path_buf(),
+                        types: Vec::new(),
+                        skipped_types: Vec::new(),
+                        skipped_fields: Vec::new(),
+                        files: Vec::new(),
                     });
                 }
             }
         }
 
+        // Indexes of the namespaces, from the types the sources would have
+        let mut namespaces = Vec::new();
+        if !self.config.namespaces.is_empty() {
+            let snapshots = self.namespace_snapshots().await;
+            for namespace in &self.config.namespaces {
+                let planned = match &snapshots {
+                    Ok(snapshots) => self.plan_namespace(namespace, snapshots),
+                    Err(e) => Err(anyhow::anyhow!("Failed to extract the types: {}", e)),
+                };
+                let (files, errors) = match planned {
+                    Ok(files) => (files, Vec::new()),
+                    Err(e) if self.config.generation.fail_fast => return Err(e),
+                    Err(e) => (Vec::new(), vec![e.to_string()]),
+                };
+                total_errors += errors.len();
+                namespaces.push(DryRunNamespaceResult {
+                    name: namespace.name.clone(),
+                    output_path: namespace.output_path.clone(),
+                    errors,
+                    files,
+                });
+            }
+        }
+
         // Calculate statistics
         for result in &results {
             total_errors += result.errors.len();
             total_warnings += result.warnings.len();
         }
 
+        let namespace_files = namespaces
+            .iter()
+            .flat_map(|namespace| &namespace.files)
+            .filter(|file| file.action != plan::FileAction::Delete)
+            .count();
+
         let generation_time = start_time.elapsed();
         info!("Dry run completed in {:?}", generation_time);
 
@@ -1017,7 +3327,11 @@ impl JsonnetGen {
             statistics: DryRunStatistics {
                 total_processing_time_ms: generation_time.as_millis() as u64,
                 sources_processed: results.len(),
-                files_would_generate: results.iter().map(|r| r.files_would_generate).sum(),
+                files_would_generate: results
+                    .iter()
+                    .map(|r| r.files_would_generate)
+                    .sum::<usize>()
+                    + namespace_files,
                 error_count: total_errors,
                 warning_count: total_warnings,
                 cache_hit_rate: self.calculate_cache_hit_rate(&incremental_plan),
@@ -1026,6 +3340,7 @@ impl JsonnetGen {
                 changed_sources_count: incremental_plan.changed_sources.len(),
                 dependent_sources_count: incremental_plan.dependent_sources.len(),
             },
+            namespaces,
         };
 
         Ok(result)
@@ -1041,7 +3356,9 @@ impl JsonnetGen {
         // Simulate the processing without actually writing files
         let mut files_would_generate = 0;
         let mut errors = Vec::new();
-        let warnings = Vec::new();
+        let mut warnings = Vec::new();
+        let mut source_report = report::SourceReport::default();
+        let mut files = Vec::new();
 
         match source {
             Source::Crd(crd_source) => {
@@ -1074,16 +3391,25 @@ impl JsonnetGen {
                 }
             }
             Source::GoAst(go_ast_source) => {
-                // Simulate Go AST processing
+                // Generate into a copy of the output to plan the changes
                 match self.git_manager.ensure_repository(&go_ast_source.git).await {
-                    Ok(_) => {
-                        // Estimate files based on Go files found
-                        files_would_generate = 2; // At least lib.jsonnet and metadata
-                        info!(
-                            "Dry run: Would generate {} files for Go AST source {}",
-                            files_would_generate, source_name
-                        );
-                    }
+                    Ok(repo_path) => match self.plan_go_source(go_ast_source, &repo_path).await {
+                        Ok((result, report, planned)) => {
+                            files_would_generate = planned
+                                .iter()
+                                .filter(|file| file.action != plan::FileAction::Delete)
+                                .count();
+                            errors.extend(result.errors);
+                            warnings.extend(result.warnings);
+                            source_report = report;
+                            files = planned;
+                            info!(
+                                "Dry run: Would create or update {} files for Go AST source {}",
+                                files_would_generate, source_name
+                            );
+                        }
+                        Err(e) => errors.push(format!("Failed to plan generation: {e}")),
+                    },
                     Err(e) => {
                         errors.push(format!("Failed to clone repository: {e}"));
                     }
@@ -1109,6 +3435,57 @@ impl JsonnetGen {
                     }
                 }
             }
+            Source::Terraform(terraform_source) => {
+                match self
+                    .git_manager
+                    .ensure_repository(&terraform_source.git)
+                    .await
+                {
+                    Ok(repo_path) => {
+                        match self
+                            .read_terraform_schemas(terraform_source, &repo_path)
+                            .await
+                        {
+                            // A library per block, with the index and the helpers
+                            Ok((schemas, _)) => {
+                                files_would_generate = schemas.len() + 2;
+                                info!(
+                                    "Dry run: Would generate {} files for Terraform source {}",
+                                    files_would_generate, source_name
+                                );
+                            }
+                            Err(e) => errors.push(format!("Failed to read provider schemas: {e}")),
+                        }
+                    }
+                    Err(e) => {
+                        errors.push(format!("Failed to clone repository: {e}"));
+                    }
+                }
+            }
+            Source::Graphql(graphql_source) => {
+                match self
+                    .git_manager
+                    .ensure_repository(&graphql_source.git)
+                    .await
+                {
+                    Ok(repo_path) => {
+                        match self.read_graphql_schemas(graphql_source, &repo_path).await {
+                            // A library per type, with the index
+                            Ok(schemas) => {
+                                files_would_generate = schemas.len() + 1;
+                                info!(
+                                    "Dry run: Would generate {} files for GraphQL source {}",
+                                    files_would_generate, source_name
+                                );
+                            }
+                            Err(e) => errors.push(format!("Failed to read schema: {e}")),
+                        }
+                    }
+                    Err(e) => {
+                        errors.push(format!("Failed to clone repository: {e}"));
+                    }
+                }
+            }
         }
 
         let processing_time = start_time.elapsed();
@@ -1124,9 +3501,86 @@ impl JsonnetGen {
             errors,
             warnings,
             output_path: source.output_path().to_path_buf(),
+            types: source_report.types,
+            skipped_types: source_report.skipped_types,
+            skipped_fields: source_report.skipped_fields,
+            files,
         })
     }
 
+    /// Plan the generation of a Go AST source
+    ///
+    /// The source is generated into a staged copy of its output directory,
+    /// which is compared with the output directory. Package outputs are
+    /// staged the same way, without their post hooks; their planned files
+    /// have absolute paths. Go tests, which are written next to the Go
+    /// packages, and obfuscated variants are not generated.
+    async fn plan_go_source(
+        &self,
+        go_ast_source: &crate::config::GoAstSource,
+        repo_path: &Path,
+    ) -> Result<(SourceResult, report::SourceReport, Vec<plan::PlannedFile>)> {
+        let staging = tempfile::tempdir()?;
+        let staged_dir = staging.path().join("output");
+        plan::copy_directory(&go_ast_source.output_path, &staged_dir)?;
+
+        let mut staged = go_ast_source.clone();
+        staged.output_path = staged_dir.clone();
+        staged.options.go_tests = false;
+        staged.options.obfuscate = None;
+        for (idx, output) in staged.options.package_outputs.iter_mut().enumerate() {
+            let staged_package = staging.path().join(format!("package-{idx}"));
+            plan::copy_directory(&output.output_path, &staged_package)?;
+            output.output_path = staged_package;
+            output.post_hook = None;
+        }
+        let result = self
+            .process_source_at(&Source::GoAst(staged), repo_path)
+            .await?;
+
+        let source_report = match self.report.lock() {
+            Ok(run_report) => run_report
+                .sources
+                .iter()
+                .find(|report| report.source == go_ast_source.name)
+                .cloned()
+                .unwrap_or_default(),
+            Err(e) => return Err(anyhow::anyhow!("Failed to collect the run report: {}", e)),
+        };
+        let mut planned = plan::planned_files(&go_ast_source.output_path, &staged_dir)?;
+        for (idx, output) in go_ast_source.options.package_outputs.iter().enumerate() {
+            let output_dir = std::path::absolute(&output.output_path)?;
+            let staged_package = staging.path().join(format!("package-{idx}"));
+            planned.extend(
+                plan::planned_files(&output.output_path, &staged_package)?
+                    .into_iter()
+                    .map(|file| plan::PlannedFile {
+                        file: output_dir.join(file.file),
+                        action: file.action,
+                    }),
+            );
+        }
+        Ok((result, source_report, planned))
+    }
+
+    /// Plan the index of a namespace from the snapshots of its sources
+    ///
+    /// The index is written into a staged copy of the output directory of
+    /// the namespace, as for the sources.
+    fn plan_namespace(
+        &self,
+        namespace: &namespace::Namespace,
+        snapshots: &HashMap<String, schemadiff::Snapshot>,
+    ) -> Result<Vec<plan::PlannedFile>> {
+        let staging = tempfile::tempdir()?;
+        let staged_dir = staging.path().join("output");
+        plan::copy_directory(&namespace.output_path, &staged_dir)?;
+        self.write_namespace_output(namespace, &staged_dir, |name, _| {
+            Ok(snapshots.get(name).cloned().unwrap_or_default())
+        })?;
+        plan::planned_files(&namespace.output_path, &staged_dir)
+    }
+
     /// Group schemas by API version (helper method for dry run)
     fn group_schemas_by_version<'a>(
         &self,
@@ -1387,6 +3841,111 @@ impl JsonnetGen {
     }
 }
 
+/// Package-level values of the Go packages of an output directory, written
+/// next to the libraries of their types
+#[derive(Default)]
+struct PackageValues {
+    constants: Vec<plugin::ast::constants::Constant>,
+    errors: Vec<plugin::ast::errors::GoError>,
+}
+
+impl PackageValues {
+    /// Values of the packages matching a predicate on their name
+    fn filter(&self, matches: impl Fn(&str) -> bool) -> Self {
+        Self {
+            constants: self
+                .constants
+                .iter()
+                .filter(|constant| matches(&constant.package))
+                .cloned()
+                .collect(),
+            errors: self
+                .errors
+                .iter()
+                .filter(|error| matches(&error.package))
+                .cloned()
+                .collect(),
+        }
+    }
+}
+
+/// Libraries written to an output directory, with what the files covering
+/// every type are built from besides the schemas
+struct GoLibraries {
+    output_path: PathBuf,
+
+    /// File names of the generated libraries, whose imports are rewritten
+    files: std::collections::BTreeSet<String>,
+
+    generated_files: Vec<PathBuf>,
+    api_surface: surface::Surface,
+    migrations: migrate::Migrations,
+    example_files: Vec<PathBuf>,
+    resource_examples: Vec<PathBuf>,
+}
+
+impl GoLibraries {
+    fn new(output_path: &Path, options: &plugin::ast::GoAstOptions) -> Self {
+        Self {
+            output_path: output_path.to_path_buf(),
+            files: [
+                plugin::ast::constants::CONSTANTS_FILE.to_string(),
+                plugin::ast::errors::ERRORS_FILE.to_string(),
+                plugin::ast::api::API_FILE.to_string(),
+            ]
+            .into(),
+            generated_files: Vec::new(),
+            api_surface: surface::Surface::default(),
+            migrations: migrate::Migrations::new(options.release.as_deref()),
+            example_files: Vec::new(),
+            resource_examples: Vec::new(),
+        }
+    }
+
+    /// Add the libraries of types to the files whose imports are rewritten
+    fn add_files(&mut self, schemas: &[crate::plugin::ExtractedSchema]) {
+        let generator = plugin::ast::GoJsonnetGenerator::new();
+        self.files
+            .extend(schemas.iter().map(|schema| generator.file_name(schema)));
+    }
+
+    /// Write the imports between the generated libraries in the configured form
+    fn rewrite_imports(&self, code: String, options: &plugin::ast::GoAstOptions) -> String {
+        match &options.imports {
+            Some(imports) => plugin::ast::imports::rewrite_imports(&code, &self.files, imports),
+            None => code,
+        }
+    }
+}
+
+/// Configuration of the built-in Go AST plugin with the options of a source
+fn go_plugin_config(options: serde_yaml::Value) -> crate::plugin::PluginConfig {
+    crate::plugin::PluginConfig {
+        plugin_id: "go-ast:builtin".to_string(),
+        config: options,
+        enabled_capabilities: vec![
+            crate::plugin::PluginCapability::Parse,
+            crate::plugin::PluginCapability::SchemaExtraction,
+            crate::plugin::PluginCapability::AstProcessing,
+        ],
+    }
+}
+
+/// Get the output directories of a source, with the obfuscated variant of
+/// Go AST sources
+pub fn source_output_paths(source: &Source) -> Vec<&Path> {
+    let mut output_paths = vec![source.output_path()];
+    if let Source::GoAst(go_ast) = source {
+        for output in &go_ast.options.package_outputs {
+            output_paths.push(&output.output_path);
+        }
+        if let Some(obfuscate) = &go_ast.options.obfuscate {
+            output_paths.push(&obfuscate.output_path);
+        }
+    }
+    output_paths
+}
+
 /// Application error types
 #[derive(thiserror::Error, Debug)]
 pub enum JsonnetGenError {
@@ -1440,6 +3999,16 @@ pub struct DryRunSourceResult {
     pub errors: Vec<String>,
     pub warnings: Vec<String>,
     pub output_path: PathBuf,
+
+    /// Types that would be generated
+    pub types: Vec<report::TypeRecord>,
+
+    /// Types and fields that would be left out, with the reason
+    pub skipped_types: Vec<report::SkippedType>,
+    pub skipped_fields: Vec<report::SkippedField>,
+
+    /// Output files that would be created, updated or deleted
+    pub files: Vec<plan::PlannedFile>,
 }
 
 /// Dry run statistics
@@ -1456,6 +4025,17 @@ pub struct DryRunStatistics {
     pub dependent_sources_count: usize,
 }
 
+/// Dry run result of the index of a namespace
+#[derive(Debug, Clone)]
+pub struct DryRunNamespaceResult {
+    pub name: String,
+    pub output_path: PathBuf,
+    pub errors: Vec<String>,
+
+    /// Output files that would be created, updated or deleted
+    pub files: Vec<plan::PlannedFile>,
+}
+
 /// Dry run result
 #[derive(Debug, Clone)]
 pub struct DryRunResult {
@@ -1463,6 +4043,9 @@ pub struct DryRunResult {
     pub total_sources: usize,
     pub results: Vec<DryRunSourceResult>,
     pub statistics: DryRunStatistics,
+
+    /// Indexes of the namespaces
+    pub namespaces: Vec<DryRunNamespaceResult>,
 }
 
 /// Cleanup dry run result for a single source entry
@@ -1503,6 +4086,18 @@ impl Source {
             Source::Crd(_) => "crd",
             Source::GoAst(_) => "go_ast",
             Source::OpenApi(_) => "openapi",
+            Source::Terraform(_) => "terraform",
+            Source::Graphql(_) => "graphql",
+        }
+    }
+
+    pub fn git(&self) -> &crate::config::GitSource {
+        match self {
+            Source::Crd(crd) => &crd.git,
+            Source::GoAst(go_ast) => &go_ast.git,
+            Source::OpenApi(openapi) => &openapi.git,
+            Source::Terraform(terraform) => &terraform.git,
+            Source::Graphql(graphql) => &graphql.git,
         }
     }
 
@@ -1511,6 +4106,8 @@ impl Source {
             Source::Crd(crd) => &crd.git.url,
             Source::GoAst(go_ast) => &go_ast.git.url,
             Source::OpenApi(openapi) => &openapi.git.url,
+            Source::Terraform(terraform) => &terraform.git.url,
+            Source::Graphql(graphql) => &graphql.git.url,
         }
     }
 
@@ -1519,6 +4116,8 @@ impl Source {
             Source::Crd(crd) => crd.git.ref_name.as_deref(),
             Source::GoAst(go_ast) => go_ast.git.ref_name.as_deref(),
             Source::OpenApi(openapi) => openapi.git.ref_name.as_deref(),
+            Source::Terraform(terraform) => terraform.git.ref_name.as_deref(),
+            Source::Graphql(graphql) => graphql.git.ref_name.as_deref(),
         }
     }
 
@@ -1527,6 +4126,8 @@ impl Source {
             Source::Crd(crd) => &crd.filters,
             Source::GoAst(go_ast) => &go_ast.include_patterns,
             Source::OpenApi(openapi) => &openapi.include_patterns,
+            Source::Terraform(terraform) => &terraform.include_patterns,
+            Source::Graphql(graphql) => &graphql.include_patterns,
         }
     }
 
@@ -1535,6 +4136,28 @@ impl Source {
             Source::Crd(crd) => &crd.output_path,
             Source::GoAst(go_ast) => &go_ast.output_path,
             Source::OpenApi(openapi) => &openapi.output_path,
+            Source::Terraform(terraform) => &terraform.output_path,
+            Source::Graphql(graphql) => &graphql.output_path,
+        }
+    }
+
+    pub fn set_git_ref(&mut self, ref_name: String) {
+        match self {
+            Source::Crd(crd) => crd.git.ref_name = Some(ref_name),
+            Source::GoAst(go_ast) => go_ast.git.ref_name = Some(ref_name),
+            Source::OpenApi(openapi) => openapi.git.ref_name = Some(ref_name),
+            Source::Terraform(terraform) => terraform.git.ref_name = Some(ref_name),
+            Source::Graphql(graphql) => graphql.git.ref_name = Some(ref_name),
+        }
+    }
+
+    pub fn set_output_path(&mut self, path: PathBuf) {
+        match self {
+            Source::Crd(crd) => crd.output_path = path,
+            Source::GoAst(go_ast) => go_ast.output_path = path,
+            Source::OpenApi(openapi) => openapi.output_path = path,
+            Source::Terraform(terraform) => terraform.output_path = path,
+            Source::Graphql(graphql) => graphql.output_path = path,
         }
     }
 }
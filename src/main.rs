@@ -1,22 +1,55 @@
 //! JsonnetGen CLI binary
 
 use anyhow::Result;
+use tracing_subscriber::fmt::writer::BoxMakeWriter;
 
 use gensonnet::cli::CliApp;
+use gensonnet::profile::{self, CountingAllocator, Profile};
+
+// Counts allocations for --profile
+#[global_allocator]
+static ALLOCATOR: CountingAllocator = CountingAllocator;
 
 #[tokio::main]
 async fn main() -> Result<()> {
-    // Initialize logging
-    tracing_subscriber::fmt()
+    // Parse command line arguments
+    let matches = CliApp::app().get_matches();
+
+    // Initialize logging, as JSON lines with --log-format json; the daemon,
+    // the persistent worker and generate --stdin answer on stdout, so their
+    // logs go to stderr
+    let answers_on_stdout = match matches.subcommand() {
+        Some(("daemon", _)) => true,
+        Some(("generate", sub_matches)) => sub_matches.get_flag("stdin"),
+        Some(("hermetic", sub_matches)) => sub_matches.get_flag("persistent_worker"),
+        _ => false,
+    };
+    let writer = if answers_on_stdout {
+        BoxMakeWriter::new(std::io::stderr)
+    } else {
+        BoxMakeWriter::new(std::io::stdout)
+    };
+    let subscriber = tracing_subscriber::fmt()
         .with_env_filter(
             tracing_subscriber::EnvFilter::try_from_default_env()
                 .unwrap_or_else(|_| "gensonnet=info".into()),
         )
-        .init();
-
-    // Parse command line arguments
-    let matches = CliApp::app().get_matches();
+        .with_writer(writer);
+    match matches.get_one::<String>("log-format").map(String::as_str) {
+        Some("json") => subscriber.json().init(),
+        _ => subscriber.init(),
+    }
 
-    // Run the CLI application
-    CliApp::run(&matches).await
+    // Run the CLI application, profiled with --profile
+    let profile_path = matches.get_one::<String>("profile");
+    if profile_path.is_some() {
+        profile::enable();
+    }
+    let result = CliApp::run(&matches).await;
+    if let Some(path) = profile_path {
+        let profile = Profile::take();
+        profile.write(std::path::Path::new(path))?;
+        eprintln!("{}", profile.summary());
+    }
+    result
 }
@@ -0,0 +1,133 @@
+//! Rendering of example programs to JSON and YAML
+//!
+//! The example programs written for Kubernetes resources are evaluated with
+//! `jsonnet` and written as one multi-document YAML file, which can be passed
+//! to `kubectl apply -f` for smoke tests. Keys are sorted and documents are
+//! ordered by file, so regenerating unchanged examples gives the same file.
+//! The examples of other types are rendered to a JSON file each.
+
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::path::{Path, PathBuf};
+
+use crate::evaluate::Evaluator;
+
+/// File name of the rendered examples, inside the examples directory
+pub const MANIFESTS_FILE: &str = "manifests.yaml";
+
+/// Evaluate example programs into a YAML stream
+///
+/// Each program yields a manifest, a list of manifests, or an object of
+/// manifests keyed by name.
+pub fn render_examples(evaluator: &Evaluator, files: &[PathBuf]) -> Result<String> {
+    let mut files = files.to_vec();
+    files.sort();
+
+    let mut documents = Vec::new();
+    for file in &files {
+        let value = evaluate_example(evaluator, file)?;
+        documents.extend(
+            manifests(value).map_err(|e| anyhow!("Failed to render {}: {}", file.display(), e))?,
+        );
+    }
+
+    yaml_stream(&documents)
+}
+
+/// Evaluate an example program into a JSON document with sorted keys
+pub fn render_example_json(evaluator: &Evaluator, file: &Path) -> Result<String> {
+    let value = evaluate_example(evaluator, file)?;
+    let mut json = serde_json::to_string_pretty(&sorted(value))?;
+    json.push('\n');
+    Ok(json)
+}
+
+/// Evaluate an example program
+fn evaluate_example(evaluator: &Evaluator, file: &Path) -> Result<Value> {
+    let path = file.canonicalize().unwrap_or_else(|_| file.to_path_buf());
+    let output = evaluator
+        .evaluate(&format!(
+            "import {}",
+            serde_json::to_string(&path.to_string_lossy())?
+        ))
+        .map_err(|e| anyhow!("Failed to render {}: {}", file.display(), e))?;
+    serde_json::from_str(&output)
+        .map_err(|e| anyhow!("Invalid output of {}: {}", file.display(), e))
+}
+
+/// Serialize documents as a multi-document YAML stream with sorted keys
+pub fn yaml_stream(documents: &[Value]) -> Result<String> {
+    let mut stream = String::new();
+    for document in documents {
+        let yaml = serde_yaml::to_string(&sorted(document.clone()))?;
+        stream.push_str("---\n");
+        stream.push_str(yaml.strip_prefix("---\n").unwrap_or(&yaml));
+    }
+    Ok(stream)
+}
+
+/// Get the manifests of an evaluated example
+fn manifests(value: Value) -> Result<Vec<Value>> {
+    match value {
+        Value::Array(items) => Ok(items),
+        Value::Object(object) if object.contains_key("kind") => Ok(vec![Value::Object(object)]),
+        Value::Object(object) => {
+            let mut entries: Vec<(String, Value)> = object.into_iter().collect();
+            entries.sort_by(|a, b| a.0.cmp(&b.0));
+            let mut documents = Vec::new();
+            for (_, entry) in entries {
+                documents.extend(manifests(entry)?);
+            }
+            Ok(documents)
+        }
+        other => Err(anyhow!("expected a manifest, got {}", other)),
+    }
+}
+
+/// Sort the keys of every object of a value
+fn sorted(value: Value) -> Value {
+    match value {
+        Value::Object(object) => {
+            let mut entries: Vec<(String, Value)> = object.into_iter().collect();
+            entries.sort_by(|a, b| a.0.cmp(&b.0));
+            Value::Object(entries.into_iter().map(|(k, v)| (k, sorted(v))).collect())
+        }
+        Value::Array(items) => Value::Array(items.into_iter().map(sorted).collect()),
+        other => other,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_manifests() {
+        let list = manifests(json!([{ "kind": "A" }, { "kind": "B" }])).unwrap();
+        assert_eq!(list.len(), 2);
+
+        let keyed = manifests(json!({
+            "web": { "kind": "Service" },
+            "app": { "kind": "Deployment" },
+        }))
+        .unwrap();
+        assert_eq!(keyed[0]["kind"], "Deployment");
+        assert_eq!(keyed[1]["kind"], "Service");
+
+        assert!(manifests(json!("text")).is_err());
+    }
+
+    #[test]
+    fn test_yaml_stream() {
+        let stream = yaml_stream(&[
+            json!({ "spec": { "replicas": 1 }, "kind": "Widget", "apiVersion": "example.com/v1" }),
+            json!({ "kind": "Gadget" }),
+        ])
+        .unwrap();
+
+        assert!(stream.starts_with("---\napiVersion: example.com/v1\nkind: Widget\n"));
+        assert!(stream.contains("\n---\nkind: Gadget\n"));
+        assert_eq!(stream.matches("---\n").count(), 2);
+    }
+}
@@ -0,0 +1,312 @@
+//! Migration rules for consumers of regenerated libraries
+//!
+//! When a regeneration renames or removes functions and the generator knows
+//! what replaces them (the deprecated aliases of renamed fields, and
+//! tombstones naming a replacement), the mapping is written next to the
+//! libraries. `gensonnet migrate` applies it to consumer trees with the same
+//! Jsonnet-aware rewrite as `gensonnet rename`; a sed script is written too,
+//! for consumers without gensonnet installed.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+
+use crate::plugin::ast::generator::{renamed_setters, tombstone_replacements};
+use crate::rename::{RenamedLibrary, Rewrite, Rewriter};
+
+/// File name of the migration rules written next to the generated libraries
+pub const MIGRATIONS_FILE: &str = "migrations.json";
+
+/// File name of the sed script applying the rules
+pub const MIGRATION_SCRIPT: &str = "migrate.sed";
+
+/// Version of the rules layout
+pub const MIGRATIONS_VERSION: u32 = 1;
+
+/// Migration rules of a set of libraries
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Migrations {
+    /// Layout version, increased on incompatible changes
+    pub version: u32,
+
+    /// Release the rules migrate to, when configured
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub release: Option<String>,
+
+    /// Rules of each library, keyed by file name
+    pub libraries: BTreeMap<String, LibraryMigrations>,
+}
+
+/// Migration rules of one library
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct LibraryMigrations {
+    /// Old function names mapped to the functions replacing them
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub renamed: BTreeMap<String, String>,
+
+    /// Functions removed without a replacement, to be migrated by hand
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub removed: Vec<String>,
+}
+
+impl Migrations {
+    /// Create empty rules for a release
+    pub fn new(release: Option<&str>) -> Self {
+        Self {
+            version: MIGRATIONS_VERSION,
+            release: release.map(str::to_string),
+            libraries: BTreeMap::new(),
+        }
+    }
+
+    /// Add the rules found in the generated code of a library
+    pub fn add_library(&mut self, file: &str, code: &str) {
+        let mut library = LibraryMigrations::default();
+        library.renamed.extend(renamed_setters(code));
+        for (name, replacement) in tombstone_replacements(code) {
+            match replacement {
+                Some(replacement) => {
+                    library.renamed.insert(name, replacement);
+                }
+                None => library.removed.push(name),
+            }
+        }
+
+        if !library.renamed.is_empty() || !library.removed.is_empty() {
+            self.libraries.insert(file.to_string(), library);
+        }
+    }
+
+    /// Whether there is nothing to migrate
+    pub fn is_empty(&self) -> bool {
+        self.libraries.is_empty()
+    }
+
+    /// Read a rules file, or the rules of an output directory
+    pub fn load(path: &Path) -> Result<Self> {
+        let path = if path.is_dir() {
+            path.join(MIGRATIONS_FILE)
+        } else {
+            path.to_path_buf()
+        };
+        let content = std::fs::read_to_string(&path)
+            .map_err(|e| anyhow!("Failed to read migration rules {:?}: {}", path, e))?;
+        let migrations: Migrations = serde_json::from_str(&content)
+            .map_err(|e| anyhow!("Invalid migration rules {:?}: {}", path, e))?;
+        if migrations.version > MIGRATIONS_VERSION {
+            return Err(anyhow!(
+                "Migration rules {:?} have version {}, newer than the supported {}",
+                path,
+                migrations.version,
+                MIGRATIONS_VERSION
+            ));
+        }
+        Ok(migrations)
+    }
+
+    /// Serialize the rules as pretty-printed JSON
+    pub fn to_json(&self) -> Result<String> {
+        Ok(serde_json::to_string_pretty(self)? + "\n")
+    }
+
+    /// Build a sed script rewriting calls to renamed functions
+    ///
+    /// The script matches `.name` followed by a non-identifier character.
+    /// Unlike `gensonnet migrate` it also rewrites strings and comments.
+    /// Removed functions are listed as comments.
+    pub fn to_sed(&self) -> String {
+        let mut script = String::new();
+        script.push_str("# Generated by gensonnet: rewrites calls to renamed functions\n");
+        match &self.release {
+            Some(release) => script.push_str(&format!(
+                "# Usage: sed -i -f {MIGRATION_SCRIPT} <files>  (migrates to {release})\n"
+            )),
+            None => script.push_str(&format!("# Usage: sed -i -f {MIGRATION_SCRIPT} <files>\n")),
+        }
+
+        for (file, library) in &self.libraries {
+            script.push_str(&format!("\n# {file}\n"));
+            for (old, new) in &library.renamed {
+                script.push_str(&format!("s/\\.{old}\\([^A-Za-z0-9_]\\)/.{new}\\1/g\n"));
+                script.push_str(&format!("s/\\.{old}$/.{new}/\n"));
+            }
+            for removed in &library.removed {
+                script.push_str(&format!("# {removed} was removed and has no replacement\n"));
+            }
+        }
+
+        script
+    }
+}
+
+/// Rewrite the files of consumer trees that use the migrated libraries
+///
+/// `output_path` is the directory of the libraries. Only calls on values of
+/// the library defining the renamed function are rewritten (see
+/// `rename::Rewriter`). Returns the files with rewritten or unresolved
+/// calls; with `dry_run` nothing is written.
+pub fn migrate_consumers(
+    migrations: &Migrations,
+    output_path: &Path,
+    consumers: &[PathBuf],
+    jpath: &[PathBuf],
+    dry_run: bool,
+) -> Result<Vec<(PathBuf, Rewrite)>> {
+    let libraries = migrations
+        .libraries
+        .iter()
+        .filter(|(_, library)| !library.renamed.is_empty())
+        .map(|(file, library)| {
+            let file = output_path.join(file);
+            RenamedLibrary {
+                type_name: library_type(&file),
+                file,
+                renames: library.renamed.clone().into_iter().collect(),
+            }
+        })
+        .collect();
+    let rewriter = Rewriter::new(libraries, jpath);
+
+    let mut rewritten_files = Vec::new();
+    for consumer in consumers {
+        for file in rewriter.dependent_files(consumer)? {
+            let code = std::fs::read_to_string(&file)?;
+            let rewrite = rewriter.rewrite(&file, &code);
+            if rewrite.count == 0 && rewrite.unresolved.is_empty() {
+                continue;
+            }
+            if !dry_run && rewrite.count > 0 {
+                std::fs::write(&file, &rewrite.code)?;
+            }
+            rewritten_files.push((file, rewrite));
+        }
+    }
+
+    Ok(rewritten_files)
+}
+
+/// Get the Go type of a generated library from its leading comment
+///
+/// The `// Generated from Go AST:` line may follow the header block.
+fn library_type(file: &Path) -> Option<String> {
+    let code = std::fs::read_to_string(file).ok()?;
+    code.lines()
+        .take_while(|line| line.starts_with("//"))
+        .find_map(|line| line.strip_prefix("// Generated from Go AST: "))
+        .map(str::to_string)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const USER: &str = r#"{
+  withPrimaryEmail(primaryEmail):: self + { primaryEmail: primaryEmail },
+
+  // Deprecated: Email was renamed to primaryEmail
+  withEmail(email):: self.withPrimaryEmail(email),
+
+  // Tombstone for v13: withNickname was removed
+  withNickname(nickname):: error "withNickname was removed in v13; use withDisplayName",
+
+  // Tombstone for v13: withAge was removed
+  withAge(age):: error "withAge was removed in v13",
+}
+"#;
+
+    #[test]
+    fn test_add_library() {
+        let mut migrations = Migrations::new(Some("v13"));
+        migrations.add_library("user.libsonnet", USER);
+        migrations.add_library("group.libsonnet", "{ new():: {} }");
+
+        assert_eq!(
+            migrations.libraries.keys().collect::<Vec<_>>(),
+            vec!["user.libsonnet"]
+        );
+        let user = &migrations.libraries["user.libsonnet"];
+        assert_eq!(user.renamed["withEmail"], "withPrimaryEmail");
+        assert_eq!(user.renamed["withNickname"], "withDisplayName");
+        assert_eq!(user.removed, vec!["withAge"]);
+
+        let json = migrations.to_json().unwrap();
+        assert_eq!(
+            serde_json::from_str::<Migrations>(&json).unwrap(),
+            migrations
+        );
+    }
+
+    #[test]
+    fn test_to_sed() {
+        let mut migrations = Migrations::new(None);
+        migrations.add_library("user.libsonnet", USER);
+
+        let script = migrations.to_sed();
+        assert!(script.contains("s/\\.withEmail\\([^A-Za-z0-9_]\\)/.withPrimaryEmail\\1/g\n"));
+        assert!(script.contains("s/\\.withNickname$/.withDisplayName/\n"));
+        assert!(script.contains("# withAge was removed and has no replacement\n"));
+    }
+
+    #[test]
+    fn test_library_type() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let file = temp_dir.path().join("user.libsonnet");
+        let code = crate::header::insert_block(
+            &format!("// Generated from Go AST: User\n{USER}"),
+            Some("Copyright 2026 Acme Corp.\n\nDO NOT EDIT"),
+            "//",
+        );
+        std::fs::write(&file, code).unwrap();
+        assert_eq!(library_type(&file).as_deref(), Some("User"));
+
+        std::fs::write(&file, USER).unwrap();
+        assert_eq!(library_type(&file), None);
+    }
+
+    #[test]
+    fn test_migrate_consumers() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let lib = temp_dir.path().join("lib");
+        let consumer = temp_dir.path().join("app");
+        std::fs::create_dir_all(&lib).unwrap();
+        std::fs::create_dir_all(&consumer).unwrap();
+        std::fs::write(lib.join("user.libsonnet"), USER).unwrap();
+        std::fs::write(
+            consumer.join("main.jsonnet"),
+            "local user = import '../lib/user.libsonnet';\nuser.withEmail('a').withNickname('b')\n",
+        )
+        .unwrap();
+        std::fs::write(consumer.join("other.jsonnet"), "{}.withEmail('a')\n").unwrap();
+
+        let mut migrations = Migrations::new(None);
+        migrations.add_library("user.libsonnet", USER);
+
+        let changed = migrate_consumers(
+            &migrations,
+            &lib,
+            std::slice::from_ref(&consumer),
+            &[],
+            true,
+        )
+        .unwrap();
+        assert_eq!(changed.len(), 1);
+        assert_eq!(changed[0].1.count, 2);
+        assert!(std::fs::read_to_string(consumer.join("main.jsonnet"))
+            .unwrap()
+            .contains("withEmail"));
+
+        migrate_consumers(
+            &migrations,
+            &lib,
+            std::slice::from_ref(&consumer),
+            &[],
+            false,
+        )
+        .unwrap();
+        assert_eq!(
+            std::fs::read_to_string(consumer.join("main.jsonnet")).unwrap(),
+            "local user = import '../lib/user.libsonnet';\nuser.withPrimaryEmail('a').withDisplayName('b')\n"
+        );
+    }
+}
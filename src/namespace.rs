@@ -0,0 +1,334 @@
+//! Libraries merging the types of several Go AST sources
+//!
+//! Platform teams assemble one company API from the types of many service
+//! repositories. A namespace names Go AST sources, each generated from its
+//! own module as usual, and gets an index exporting the types of all of them
+//! by name:
+//!
+//! ```yaml
+//! namespaces:
+//!   - name: company-api
+//!     output_path: ./generated/company-api
+//!     sources: [users, billing]
+//! ```
+//!
+//! ```jsonnet
+//! local api = import 'generated/company-api/index.libsonnet';
+//! [api.User.new('ada'), api.Invoice.new('2026-001')]
+//! ```
+//!
+//! The types are read from the `types.json` of each source, so the index is
+//! rewritten after the sources are generated, like a library of a source. A
+//! type name generated by several sources fails the run before any output is
+//! written, since only one of them could be exported; the `renames` option of
+//! a source gives one of the types another name.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::{Path, PathBuf};
+
+use crate::config::Source;
+use crate::plugin::ast::generator::{field_key, quote_string};
+use crate::plugin::ast::gotest::relative_path;
+use crate::schemadiff::Snapshot;
+
+/// File of the index of a namespace, inside its output directory
+pub const INDEX_FILE: &str = "index.libsonnet";
+
+/// Types of several Go AST sources exported by one library
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Namespace {
+    /// Name of the namespace
+    pub name: String,
+
+    /// Directory of the index of the namespace
+    pub output_path: PathBuf,
+
+    /// Names of the Go AST sources whose types the namespace exports
+    pub sources: Vec<String>,
+}
+
+/// A type exported by a namespace
+#[derive(Debug, Clone, PartialEq)]
+pub struct Member {
+    /// Name of the source generating the type
+    pub source: String,
+
+    /// Output directory of the source
+    pub dir: PathBuf,
+
+    /// Library of the type, relative to `dir`
+    pub file: String,
+}
+
+impl Namespace {
+    pub fn validate(&self, sources: &[Source]) -> Result<()> {
+        if self.name.is_empty() {
+            return Err(anyhow!("Namespace name cannot be empty"));
+        }
+        if self.output_path.to_string_lossy().is_empty() {
+            return Err(anyhow!(
+                "Namespace {} output path cannot be empty",
+                self.name
+            ));
+        }
+        if self.sources.is_empty() {
+            return Err(anyhow!("Namespace {} names no sources", self.name));
+        }
+        let mut seen = BTreeSet::new();
+        for name in &self.sources {
+            if !seen.insert(name) {
+                return Err(anyhow!(
+                    "Namespace {} names source {} twice",
+                    self.name,
+                    name
+                ));
+            }
+            match sources.iter().find(|source| source.name() == name) {
+                Some(Source::GoAst(_)) => {}
+                Some(_) => {
+                    return Err(anyhow!(
+                        "Namespace {}: source {} is not a Go AST source",
+                        self.name,
+                        name
+                    ))
+                }
+                None => {
+                    return Err(anyhow!(
+                        "Namespace {} names unknown source {}",
+                        self.name,
+                        name
+                    ))
+                }
+            }
+        }
+        Ok(())
+    }
+
+    /// Sources of the namespace, in its order
+    pub fn members<'a>(&self, sources: &'a [Source]) -> Vec<&'a Source> {
+        self.sources
+            .iter()
+            .filter_map(|name| sources.iter().find(|source| source.name() == name))
+            .collect()
+    }
+
+    /// Output directories of the sources of the namespace, by source name
+    pub fn source_dirs<'a>(&self, sources: &'a [Source]) -> Vec<(&'a str, &'a Path)> {
+        self.members(sources)
+            .into_iter()
+            .map(|source| (source.name(), source.output_path()))
+            .collect()
+    }
+}
+
+/// Collect the types of the sources of a namespace from their snapshots
+///
+/// `snapshot` gives the snapshot of a source from its name and output
+/// directory, such as the `types.json` there. Fails listing every type name
+/// generated by more than one source.
+pub fn collect_types(
+    namespace: &Namespace,
+    dirs: &[(&str, &Path)],
+    snapshot: impl Fn(&str, &Path) -> Result<Snapshot>,
+) -> Result<BTreeMap<String, Member>> {
+    let mut types: BTreeMap<String, Member> = BTreeMap::new();
+    let mut collisions: BTreeMap<String, Vec<String>> = BTreeMap::new();
+    for (source, dir) in dirs {
+        for (name, shape) in snapshot(source, dir)?.types {
+            match types.get(&name) {
+                Some(existing) => collisions
+                    .entry(name)
+                    .or_insert_with(|| vec![existing.source.clone()])
+                    .push(source.to_string()),
+                None => {
+                    types.insert(
+                        name,
+                        Member {
+                            source: source.to_string(),
+                            dir: dir.to_path_buf(),
+                            file: shape.file,
+                        },
+                    );
+                }
+            }
+        }
+    }
+    if !collisions.is_empty() {
+        let collisions: Vec<String> = collisions
+            .into_iter()
+            .map(|(name, sources)| format!("{} (sources {})", name, sources.join(", ")))
+            .collect();
+        return Err(anyhow!(
+            "Namespace {}: Types generated by several sources of the namespace: {}; rename them with the renames option of a source",
+            namespace.name,
+            collisions.join(", ")
+        ));
+    }
+    Ok(types)
+}
+
+/// Generate the index of a namespace in `output_path`
+///
+/// The types are fields by name; the indexes of the sources are hidden
+/// fields of `sources`.
+pub fn generate_index(
+    namespace: &Namespace,
+    dirs: &[(&str, &Path)],
+    types: &BTreeMap<String, Member>,
+) -> String {
+    // Directories are resolved, since the libraries may not be written yet
+    let import = |dir: &Path, file: &str| {
+        quote_string(&format!(
+            "{}/{}",
+            relative_path(&namespace.output_path, dir),
+            file
+        ))
+    };
+    let mut code = format!(
+        "// Generated by gensonnet: namespace {}\n{{\n",
+        namespace.name
+    );
+    for (name, member) in types {
+        code.push_str(&format!(
+            "  {}: import {},\n",
+            field_key(name),
+            import(&member.dir, &member.file)
+        ));
+    }
+    code.push_str("\n  // Indexes of the sources\n  sources:: {\n");
+    for (source, dir) in dirs {
+        code.push_str(&format!(
+            "    {}: import {},\n",
+            field_key(source),
+            import(dir, INDEX_FILE)
+        ));
+    }
+    code.push_str("  },\n}\n");
+    code
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::schemadiff::TypeShape;
+
+    fn write_snapshot(dir: &Path, types: &[(&str, &str)]) {
+        let mut snapshot = Snapshot::default();
+        for (name, file) in types {
+            snapshot.types.insert(
+                name.to_string(),
+                TypeShape {
+                    file: file.to_string(),
+                    ..Default::default()
+                },
+            );
+        }
+        std::fs::create_dir_all(dir).unwrap();
+        std::fs::write(dir.join("types.json"), snapshot.to_json().unwrap()).unwrap();
+    }
+
+    fn sources(dir: &Path) -> Vec<Source> {
+        serde_yaml::from_str(&format!(
+            "[{{type: go_ast, name: users, git: {{url: 'https://github.com/acme/users.git'}}, include_patterns: ['**/*.go'], exclude_patterns: [], output_path: {0}/users}}, {{type: go_ast, name: billing, git: {{url: 'https://github.com/acme/billing.git'}}, include_patterns: ['**/*.go'], exclude_patterns: [], output_path: {0}/billing}}]",
+            dir.display()
+        ))
+        .unwrap()
+    }
+
+    #[test]
+    fn test_generate_index() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        write_snapshot(&dir.join("users"), &[("User", "user.libsonnet")]);
+        write_snapshot(
+            &dir.join("billing"),
+            &[
+                ("Invoice", "invoice.libsonnet"),
+                ("InvoiceLine", "invoice_line.libsonnet"),
+            ],
+        );
+        let sources = sources(dir);
+        let namespace = Namespace {
+            name: "company-api".to_string(),
+            output_path: dir.join("company-api"),
+            sources: vec!["users".to_string(), "billing".to_string()],
+        };
+        namespace.validate(&sources).unwrap();
+
+        let dirs = namespace.source_dirs(&sources);
+        let load = |_: &str, dir: &Path| Snapshot::load(dir);
+        let types = collect_types(&namespace, &dirs, load).unwrap();
+        assert_eq!(
+            generate_index(&namespace, &dirs, &types),
+            "// Generated by gensonnet: namespace company-api\n{\n  Invoice: import \"../billing/invoice.libsonnet\",\n  InvoiceLine: import \"../billing/invoice_line.libsonnet\",\n  User: import \"../users/user.libsonnet\",\n\n  // Indexes of the sources\n  sources:: {\n    users: import \"../users/index.libsonnet\",\n    billing: import \"../billing/index.libsonnet\",\n  },\n}\n"
+        );
+
+        // A type of both sources could only be exported once
+        write_snapshot(
+            &dir.join("billing"),
+            &[("Invoice", "invoice.libsonnet"), ("User", "user.libsonnet")],
+        );
+        let error = collect_types(&namespace, &dirs, load).unwrap_err();
+        assert_eq!(
+            error.to_string(),
+            "Namespace company-api: Types generated by several sources of the namespace: User (sources users, billing); rename them with the renames option of a source"
+        );
+    }
+
+    #[test]
+    fn test_write_namespace_output() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        write_snapshot(&dir.join("users"), &[("User", "user.libsonnet")]);
+        write_snapshot(&dir.join("billing"), &[("Invoice", "invoice.libsonnet")]);
+        let namespace = Namespace {
+            name: "company-api".to_string(),
+            output_path: dir.join("company-api"),
+            sources: vec!["users".to_string(), "billing".to_string()],
+        };
+        let config = crate::Config {
+            sources: sources(dir),
+            namespaces: vec![namespace.clone()],
+            generation: crate::config::GenerationConfig {
+                manifest: true,
+                formatter: crate::config::Formatter::Builtin,
+                header_block: Some("Apache-2.0".to_string()),
+                ..Default::default()
+            },
+            ..Default::default()
+        };
+        let app = crate::JsonnetGen::new(config).unwrap();
+
+        // Written like the libraries of a source
+        let file = app
+            .write_namespace_output(&namespace, &namespace.output_path, |_, dir| {
+                Snapshot::load(dir)
+            })
+            .unwrap();
+        let index = std::fs::read_to_string(&file).unwrap();
+        assert!(index.starts_with("// BEGIN gensonnet header\n// Apache-2.0\n"));
+        assert!(index.contains("// Generator: gensonnet "));
+        assert!(index.contains("User: import '../users/user.libsonnet',"));
+        let manifest = crate::integrity::Manifest::read(&namespace.output_path)
+            .unwrap()
+            .unwrap();
+        assert_eq!(manifest.source, "company-api");
+        assert!(manifest.outputs.contains_key(INDEX_FILE));
+    }
+
+    #[test]
+    fn test_validate_namespace() {
+        let sources = sources(Path::new("/gen"));
+        let namespace = |names: &[&str]| Namespace {
+            name: "company-api".to_string(),
+            output_path: PathBuf::from("/gen/company-api"),
+            sources: names.iter().map(|name| name.to_string()).collect(),
+        };
+        assert!(namespace(&["users"]).validate(&sources).is_ok());
+        assert!(namespace(&[]).validate(&sources).is_err());
+        assert!(namespace(&["users", "users"]).validate(&sources).is_err());
+        assert!(namespace(&["orders"]).validate(&sources).is_err());
+    }
+}
@@ -0,0 +1,200 @@
+//! Native functions the generated libraries call when they are registered
+//!
+//! With `pattern_checks: auto`, the generated checks call these functions
+//! through `std.native()` when the evaluator registers them, and fall back
+//! to the checks written with the standard library otherwise:
+//!
+//! - `regexMatch(regex, string)` matches a Go regular expression, like the
+//!   function of the same name Tanka provides.
+//! - `semverCompare(a, b)` compares two semantic versions, as -1, 0 or 1,
+//!   and gives `null` when either is not one.
+//! - `parseCidr(cidr)` gives the `ip`, `network`, `prefix` and `version` (4
+//!   or 6) of a CIDR block, or `null` when it is not one.
+//!
+//! `gensonnet natives` writes them as a Go package registering them with a
+//! go-jsonnet VM, for tools embedding the evaluator.
+
+use anyhow::{anyhow, Result};
+
+use crate::plugin::ast::generator::{is_identifier, SEMVER_PATTERN};
+
+/// Names of the native functions
+pub const FUNCTIONS: &[&str] = &["regexMatch", "semverCompare", "parseCidr"];
+
+/// Go source of the native functions, registered by its `Register(vm)`
+const GO_SOURCE: &str = r#"// Code generated by gensonnet natives. DO NOT EDIT.
+
+// Package {package} registers the native functions that libraries generated
+// by gensonnet call when the evaluator provides them.
+package {package}
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Register adds the native functions to a VM.
+func Register(vm *jsonnet.VM) {
+	for _, f := range Functions() {
+		vm.NativeFunction(f)
+	}
+}
+
+// Functions returns the native functions.
+func Functions() []*jsonnet.NativeFunction {
+	return []*jsonnet.NativeFunction{
+		{
+			Name:   "regexMatch",
+			Params: ast.Identifiers{"regex", "string"},
+			Func: func(args []interface{}) (interface{}, error) {
+				regex, ok := args[0].(string)
+				if !ok {
+					return nil, fmt.Errorf("regexMatch: regex must be a string")
+				}
+				value, ok := args[1].(string)
+				if !ok {
+					return false, nil
+				}
+				return regexp.MatchString(regex, value)
+			},
+		},
+		{
+			Name:   "semverCompare",
+			Params: ast.Identifiers{"a", "b"},
+			Func: func(args []interface{}) (interface{}, error) {
+				a, okA := parseSemver(args[0])
+				b, okB := parseSemver(args[1])
+				if !okA || !okB {
+					return nil, nil
+				}
+				return float64(compareSemver(a, b)), nil
+			},
+		},
+		{
+			Name:   "parseCidr",
+			Params: ast.Identifiers{"cidr"},
+			Func: func(args []interface{}) (interface{}, error) {
+				value, ok := args[0].(string)
+				if !ok {
+					return nil, nil
+				}
+				ip, network, err := net.ParseCIDR(value)
+				if err != nil {
+					return nil, nil
+				}
+				prefix, _ := network.Mask.Size()
+				version := 6
+				if ip.To4() != nil && !strings.Contains(value, ":") {
+					version = 4
+				}
+				return map[string]interface{}{
+					"ip":      ip.String(),
+					"network": network.String(),
+					"prefix":  float64(prefix),
+					"version": float64(version),
+				}, nil
+			},
+		},
+	}
+}
+
+var semverPattern = regexp.MustCompile(`{semver}`)
+
+type semver struct {
+	core       [3]uint64
+	prerelease []string
+}
+
+func parseSemver(value interface{}) (semver, bool) {
+	s, ok := value.(string)
+	if !ok || !semverPattern.MatchString(s) {
+		return semver{}, false
+	}
+	s, _, _ = strings.Cut(s, "+")
+	core, prerelease, _ := strings.Cut(s, "-")
+	var v semver
+	for i, part := range strings.Split(core, ".") {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return semver{}, false
+		}
+		v.core[i] = n
+	}
+	if prerelease != "" {
+		v.prerelease = strings.Split(prerelease, ".")
+	}
+	return v, true
+}
+
+func compareSemver(a, b semver) int {
+	for i := range a.core {
+		if c := cmp.Compare(a.core[i], b.core[i]); c != 0 {
+			return c
+		}
+	}
+	// A version without a prerelease is greater than one with it
+	if len(a.prerelease) == 0 || len(b.prerelease) == 0 {
+		return cmp.Compare(len(b.prerelease), len(a.prerelease))
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := compareIdentifier(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a.prerelease), len(b.prerelease))
+}
+
+// compareIdentifier compares prerelease identifiers: numeric ones by value
+// and lower than alphanumeric ones, which compare as strings.
+func compareIdentifier(a, b string) int {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(x, y)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+"#;
+
+/// Render the Go package of the native functions
+pub fn go_source(package: &str) -> Result<String> {
+    if !is_identifier(package) {
+        return Err(anyhow!("Not a Go package name: {}", package));
+    }
+    Ok(GO_SOURCE
+        .replace("{package}", package)
+        .replace("{semver}", SEMVER_PATTERN))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_go_source() {
+        let source = go_source("jsonnetnatives").unwrap();
+        assert!(source.contains("\npackage jsonnetnatives\n"));
+        for function in FUNCTIONS {
+            assert!(
+                source.contains(&format!("Name:   \"{function}\",")),
+                "{function}"
+            );
+        }
+        assert!(source.contains(&format!("regexp.MustCompile(`{SEMVER_PATTERN}`)")));
+        assert!(!source.contains("{package}") && !source.contains("{semver}"));
+
+        assert!(go_source("json-natives").is_err());
+    }
+}
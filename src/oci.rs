@@ -0,0 +1,268 @@
+//! Generated libraries published as OCI artifacts
+//!
+//! `gensonnet publish --oci oci://registry/acme/user-lib:v1.2.3` pushes the
+//! files of an output directory to a registry, one layer per file titled
+//! with its path, and `gensonnet pull` restores them, so libraries can be
+//! versioned by tag without a Git repository of their own. The transfer goes
+//! through `oras`, so its credentials (`oras login`, the Docker credential
+//! store) and registry settings apply.
+
+use anyhow::{anyhow, Result};
+use std::fmt;
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use walkdir::WalkDir;
+
+/// Scheme of the references given on the command line
+pub const SCHEME: &str = "oci://";
+
+/// Artifact type of the manifests of published libraries
+pub const ARTIFACT_TYPE: &str = "application/vnd.gensonnet.library.v1";
+
+/// Media type of the layers, each holding a file of the library
+pub const LAYER_MEDIA_TYPE: &str = "application/vnd.gensonnet.library.file.v1";
+
+/// A tagged or pinned artifact of a registry
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Reference {
+    /// Host of the registry, with its port
+    pub registry: String,
+
+    /// Repository inside the registry (`acme/user-lib`)
+    pub repository: String,
+
+    /// Tag (`v1.2.3`) or digest (`sha256:...`)
+    pub version: Version,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Version {
+    Tag(String),
+    Digest(String),
+}
+
+impl FromStr for Reference {
+    type Err = anyhow::Error;
+
+    /// Parse `oci://registry/repository:tag` or `oci://registry/repository@digest`
+    ///
+    /// A version is required, since libraries are consumed by version.
+    fn from_str(s: &str) -> Result<Self> {
+        let invalid = |reason: &str| anyhow!("Invalid OCI reference '{}': {}", s, reason);
+        let address = s
+            .strip_prefix(SCHEME)
+            .ok_or_else(|| invalid("expected oci://registry/repository:tag"))?;
+        let (registry, rest) = address
+            .split_once('/')
+            .ok_or_else(|| invalid("missing the repository"))?;
+        if registry.is_empty() {
+            return Err(invalid("missing the registry"));
+        }
+
+        let (repository, version) = if let Some((repository, digest)) = rest.split_once('@') {
+            if !digest.contains(':') {
+                return Err(invalid("digests are written algorithm:hex"));
+            }
+            (repository, Version::Digest(digest.to_string()))
+        } else {
+            // A colon after the last slash starts the tag
+            let last = rest.rfind('/').map_or(0, |idx| idx + 1);
+            match rest[last..].split_once(':') {
+                Some((name, tag)) => (&rest[..last + name.len()], Version::Tag(tag.to_string())),
+                None => return Err(invalid("missing a tag or digest")),
+            }
+        };
+        let valid_repository = !repository.is_empty()
+            && repository.split('/').all(|segment| {
+                !segment.is_empty()
+                    && segment
+                        .chars()
+                        .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || "._-".contains(c))
+            });
+        if !valid_repository {
+            return Err(invalid("repositories are lowercase paths"));
+        }
+        if matches!(&version, Version::Tag(tag) if tag.is_empty()) {
+            return Err(invalid("empty tag"));
+        }
+
+        Ok(Self {
+            registry: registry.to_string(),
+            repository: repository.to_string(),
+            version,
+        })
+    }
+}
+
+impl Reference {
+    /// Reference as `oras` takes it, without the scheme
+    pub fn address(&self) -> String {
+        match &self.version {
+            Version::Tag(tag) => format!("{}/{}:{}", self.registry, self.repository, tag),
+            Version::Digest(digest) => format!("{}/{}@{}", self.registry, self.repository, digest),
+        }
+    }
+
+    /// Last segment of the repository, the name of the library
+    pub fn name(&self) -> &str {
+        self.repository
+            .rsplit('/')
+            .next()
+            .unwrap_or(&self.repository)
+    }
+}
+
+impl fmt::Display for Reference {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}{}", SCHEME, self.address())
+    }
+}
+
+/// Files of a library, relative to its directory and sorted
+///
+/// Hidden files and directories, such as caches, are left out.
+pub fn artifact_files(dir: &Path) -> Result<Vec<String>> {
+    let mut files = Vec::new();
+    let entries = WalkDir::new(dir).into_iter().filter_entry(|entry| {
+        entry.depth() == 0 || !entry.file_name().to_string_lossy().starts_with('.')
+    });
+    for entry in entries {
+        let entry = entry?;
+        if entry.file_type().is_file() {
+            let relative = entry.path().strip_prefix(dir)?;
+            files.push(relative.to_string_lossy().replace('\\', "/"));
+        }
+    }
+    files.sort();
+    Ok(files)
+}
+
+/// Arguments of the `oras push` of a library
+pub fn push_args(reference: &Reference, files: &[String]) -> Vec<String> {
+    let mut args = vec![
+        "push".to_string(),
+        reference.address(),
+        "--artifact-type".to_string(),
+        ARTIFACT_TYPE.to_string(),
+        "--annotation".to_string(),
+        format!("org.opencontainers.image.title={}", reference.name()),
+    ];
+    if let Version::Tag(tag) = &reference.version {
+        args.push("--annotation".to_string());
+        args.push(format!("org.opencontainers.image.version={tag}"));
+    }
+    args.extend(
+        files
+            .iter()
+            .map(|file| format!("{file}:{LAYER_MEDIA_TYPE}")),
+    );
+    args
+}
+
+/// Push the files of an output directory as an artifact
+///
+/// Returns the files pushed. `oras` is taken from PATH unless `command` is
+/// given.
+pub fn push(dir: &Path, reference: &Reference, command: Option<&Path>) -> Result<Vec<String>> {
+    let files = artifact_files(dir)?;
+    if files.is_empty() {
+        return Err(anyhow!(
+            "{} holds no files to publish; run `gensonnet generate` first",
+            dir.display()
+        ));
+    }
+    run_oras(command, dir, &push_args(reference, &files))?;
+    Ok(files)
+}
+
+/// Pull an artifact into a directory, restoring the paths of its files
+pub fn pull(reference: &Reference, dir: &Path, command: Option<&Path>) -> Result<()> {
+    std::fs::create_dir_all(dir)?;
+    run_oras(command, dir, &["pull".to_string(), reference.address()])
+}
+
+/// Directory a library is pulled to by default, below the library path
+pub fn default_pull_dir(reference: &Reference) -> PathBuf {
+    Path::new(crate::bundle::VENDOR_DIR).join(reference.name())
+}
+
+fn run_oras(command: Option<&Path>, dir: &Path, args: &[String]) -> Result<()> {
+    let command = command.unwrap_or(Path::new("oras"));
+    let output = std::process::Command::new(command)
+        .args(args)
+        .current_dir(dir)
+        .output()
+        .map_err(|e| anyhow!("Failed to run {}: {}", command.display(), e))?;
+
+    if !output.status.success() {
+        return Err(anyhow!(
+            "{} {} failed: {}",
+            command.display(),
+            args[0],
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_reference() {
+        let reference: Reference = "oci://ghcr.io/acme/user-lib:v1.2.3".parse().unwrap();
+        assert_eq!(reference.registry, "ghcr.io");
+        assert_eq!(reference.repository, "acme/user-lib");
+        assert_eq!(reference.version, Version::Tag("v1.2.3".to_string()));
+        assert_eq!(reference.name(), "user-lib");
+        assert_eq!(reference.to_string(), "oci://ghcr.io/acme/user-lib:v1.2.3");
+
+        let local: Reference = "oci://localhost:5000/user-lib@sha256:9b2e".parse().unwrap();
+        assert_eq!(local.registry, "localhost:5000");
+        assert_eq!(local.address(), "localhost:5000/user-lib@sha256:9b2e");
+
+        for invalid in [
+            "ghcr.io/acme/user-lib:v1",
+            "oci://ghcr.io/acme/user-lib",
+            "oci://ghcr.io/acme/User:v1",
+            "oci://ghcr.io/acme/user-lib:",
+            "oci://ghcr.io",
+        ] {
+            assert!(invalid.parse::<Reference>().is_err(), "{invalid}");
+        }
+    }
+
+    #[test]
+    fn test_push_args() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        std::fs::create_dir_all(dir.join("_custom")).unwrap();
+        std::fs::create_dir_all(dir.join(".gensonnet")).unwrap();
+        std::fs::write(dir.join("user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("index.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("_custom/user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join(".gensonnet/graph.json"), "{}\n").unwrap();
+
+        let files = artifact_files(dir).unwrap();
+        assert_eq!(
+            files,
+            vec![
+                "_custom/user.libsonnet",
+                "index.libsonnet",
+                "user.libsonnet"
+            ]
+        );
+
+        let reference: Reference = "oci://ghcr.io/acme/user-lib:v1.2.3".parse().unwrap();
+        let args = push_args(&reference, &files);
+        assert_eq!(&args[..2], ["push", "ghcr.io/acme/user-lib:v1.2.3"]);
+        assert!(args.contains(&"org.opencontainers.image.version=v1.2.3".to_string()));
+        assert_eq!(
+            args.last().unwrap(),
+            &format!("user.libsonnet:{LAYER_MEDIA_TYPE}")
+        );
+        assert_eq!(default_pull_dir(&reference), Path::new("vendor/user-lib"));
+    }
+}
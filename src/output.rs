@@ -0,0 +1,189 @@
+//! Output files written only when their content changes
+//!
+//! Regenerating a source whose inputs did not change leaves its output
+//! directories untouched, so file watchers and make-style builds depending
+//! on the libraries do not retrigger. Files are compared with the content
+//! about to be written, and the libraries of Go AST sources are written in
+//! their final form, downgraded for the `jsonnet_version`, with the header
+//! and formatted, so the steps after generation find nothing to change.
+//!
+//! Each run counts the files of the output directories of a source it
+//! created, changed, left unchanged and deleted:
+//!
+//! ```text
+//! Output files of users: 2 created, 1 changed, 41 unchanged, 0 deleted
+//! ```
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::path::Path;
+
+use crate::header::without_block;
+
+/// What writing a file did
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum WriteOutcome {
+    Created,
+    Changed,
+    Unchanged,
+}
+
+/// Write a file unless it already has `content`
+///
+/// Files differing only in their header block are left alone too, since
+/// the block is put in place after generation (see `header`).
+pub fn write_if_changed(path: &Path, content: impl AsRef<[u8]>) -> Result<WriteOutcome> {
+    let content = content.as_ref();
+    let same_content =
+        |current: &[u8]| match (std::str::from_utf8(current), std::str::from_utf8(content)) {
+            (Ok(current), Ok(content)) => without_block(current) == without_block(content),
+            _ => current == content,
+        };
+    let outcome = match std::fs::read(path) {
+        Ok(current) if same_content(&current) => return Ok(WriteOutcome::Unchanged),
+        Ok(_) => WriteOutcome::Changed,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => WriteOutcome::Created,
+        Err(e) => return Err(e.into()),
+    };
+    std::fs::write(path, content)?;
+    Ok(outcome)
+}
+
+/// Files of the output directories of a source, by what a run did to them
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct WriteCounts {
+    pub created: usize,
+    pub changed: usize,
+    pub unchanged: usize,
+    pub deleted: usize,
+}
+
+impl WriteCounts {
+    /// Whether the run left every file as it was
+    pub fn is_unchanged(&self) -> bool {
+        self.created == 0 && self.changed == 0 && self.deleted == 0
+    }
+}
+
+impl std::fmt::Display for WriteCounts {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "{} created, {} changed, {} unchanged, {} deleted",
+            self.created, self.changed, self.unchanged, self.deleted
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_write_if_changed() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let file = temp_dir.path().join("user.libsonnet");
+        assert_eq!(
+            write_if_changed(&file, "{}\n").unwrap(),
+            WriteOutcome::Created
+        );
+
+        // An unchanged file keeps its modification time
+        let modified = std::fs::metadata(&file).unwrap().modified().unwrap();
+        let past = modified - std::time::Duration::from_secs(3600);
+        std::fs::File::options()
+            .write(true)
+            .open(&file)
+            .unwrap()
+            .set_modified(past)
+            .unwrap();
+        assert_eq!(
+            write_if_changed(&file, "{}\n").unwrap(),
+            WriteOutcome::Unchanged
+        );
+        assert_eq!(std::fs::metadata(&file).unwrap().modified().unwrap(), past);
+
+        assert_eq!(
+            write_if_changed(&file, "{ a: 1 }\n").unwrap(),
+            WriteOutcome::Changed
+        );
+        assert_eq!(std::fs::read_to_string(&file).unwrap(), "{ a: 1 }\n");
+
+        // The block is put in place by the step after generation
+        let with_block = crate::header::insert_block("{ a: 1 }\n", Some("MIT"), "//");
+        std::fs::write(&file, &with_block).unwrap();
+        assert_eq!(
+            write_if_changed(&file, "{ a: 1 }\n").unwrap(),
+            WriteOutcome::Unchanged
+        );
+        assert_eq!(std::fs::read_to_string(&file).unwrap(), with_block);
+    }
+
+    #[tokio::test]
+    async fn test_regenerate_unchanged() {
+        let command = std::env::var_os("JSONNETFMT_BIN")
+            .map_or_else(|| "jsonnetfmt".into(), std::path::PathBuf::from);
+        if std::process::Command::new(&command)
+            .arg("--version")
+            .output()
+            .is_err()
+        {
+            eprintln!("{} not found, skipping", command.display());
+            return;
+        }
+
+        let temp_dir = tempfile::tempdir().unwrap();
+        let source = crate::Source::GoAst(
+            crate::config::GoAstSource::from_url(
+                "git+https://github.com/acme/api",
+                temp_dir.path(),
+            )
+            .unwrap(),
+        );
+        let config = crate::Config {
+            sources: vec![source.clone()],
+            generation: crate::config::GenerationConfig {
+                jsonnet_version: Some("0.17.0".to_string()),
+                formatter: crate::config::Formatter::Jsonnetfmt,
+                formatter_command: Some(command),
+                ..Default::default()
+            },
+            ..Default::default()
+        };
+        let app = crate::JsonnetGen::new(config).unwrap();
+
+        // Downgraded, stamped and formatted by jsonnetfmt after generation
+        let output_path = source.output_path();
+        let files = [
+            output_path.join("user.libsonnet"),
+            output_path.join("users/group.libsonnet"),
+        ];
+        let code = "// Generated from Go AST: User\n{\n    spec(obj): std.get(obj, \"spec\", {}),\n  \"name\": 'user'}\n";
+        let past = std::time::SystemTime::now() - std::time::Duration::from_secs(3600);
+        for run in 0..2 {
+            let before = app.start_source_output(&source, temp_dir.path()).unwrap();
+            for file in &files {
+                std::fs::create_dir_all(file.parent().unwrap()).unwrap();
+                app.write_output(file, code).unwrap();
+            }
+            app.finish_source_output(&source, temp_dir.path(), &Default::default(), &before)
+                .await
+                .unwrap();
+            for file in &files {
+                if run == 0 {
+                    std::fs::File::options()
+                        .write(true)
+                        .open(file)
+                        .unwrap()
+                        .set_modified(past)
+                        .unwrap();
+                } else {
+                    assert_eq!(std::fs::metadata(file).unwrap().modified().unwrap(), past);
+                }
+            }
+        }
+        let group = std::fs::read_to_string(&files[1]).unwrap();
+        assert_eq!(group.matches("local polyfills = import").count(), 1);
+        assert!(group.contains("import '../polyfills.libsonnet'"));
+    }
+}
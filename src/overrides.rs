@@ -0,0 +1,67 @@
+//! Hand-written overrides of generated libraries
+//!
+//! An `_overrides.libsonnet` next to the generated files is merged into the
+//! generated `index.libsonnet`, so extensions written by hand are part of
+//! the library without editing generated code:
+//!
+//! ```jsonnet
+//! // _overrides.libsonnet
+//! {
+//!   packages+: { api+: { categories+: { uncategorized+: {
+//!     User+: { admin():: self.lib.new('admin') },
+//!   } } } },
+//! }
+//! ```
+//!
+//! User files, the overrides and the `_custom/` mixins of Tanka libraries,
+//! are never written during regeneration: formatting and dialect downgrades
+//! of the output leave them as they are.
+
+use std::path::Path;
+
+use crate::tanka;
+
+/// File of the hand-written overrides of an output directory
+pub const OVERRIDES_FILE: &str = "_overrides.libsonnet";
+
+/// Merge the overrides imported from `import` into a generated index
+pub fn merge_overrides(index: &str, import: &str) -> String {
+    let mut code = index.trim_end().to_string();
+    code.push_str(&format!("\n+ (import \"{import}\")\n"));
+    code
+}
+
+/// Check whether a file below an output directory is written by hand
+pub fn is_user_file(dir: &Path, path: &Path) -> bool {
+    let Ok(relative) = path.strip_prefix(dir) else {
+        return false;
+    };
+    relative == Path::new(OVERRIDES_FILE) || relative.starts_with(tanka::CUSTOM_DIR)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_merge_overrides() {
+        let index = "// Generated from Go AST: index\n{\n  packages: {},\n}\n";
+        assert_eq!(
+            merge_overrides(index, "./_overrides.libsonnet"),
+            "// Generated from Go AST: index\n{\n  packages: {},\n}\n+ (import \"./_overrides.libsonnet\")\n"
+        );
+    }
+
+    #[test]
+    fn test_is_user_file() {
+        let dir = Path::new("/out/widgets");
+        assert!(is_user_file(dir, &dir.join(OVERRIDES_FILE)));
+        assert!(is_user_file(dir, &dir.join("_custom/user.libsonnet")));
+        assert!(!is_user_file(dir, &dir.join("user.libsonnet")));
+        assert!(!is_user_file(dir, &dir.join("tests").join(OVERRIDES_FILE)));
+        assert!(!is_user_file(
+            dir,
+            Path::new("/elsewhere/_overrides.libsonnet")
+        ));
+    }
+}
@@ -0,0 +1,190 @@
+//! Plan of a dry run
+//!
+//! A source is generated into a copy of its output directory, so every step
+//! of a real run applies, including the post-processing and the files a run
+//! removes. Comparing the copy with the output directory tells which files a
+//! run would create, update or delete, without writing to the output.
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::compat::read_tree;
+
+/// What a run would do to an output file
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum FileAction {
+    Create,
+    Update,
+    Delete,
+}
+
+impl std::fmt::Display for FileAction {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            FileAction::Create => write!(f, "create"),
+            FileAction::Update => write!(f, "update"),
+            FileAction::Delete => write!(f, "delete"),
+        }
+    }
+}
+
+/// An output file a run would change
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct PlannedFile {
+    /// File path relative to the output directory
+    pub file: PathBuf,
+
+    pub action: FileAction,
+}
+
+/// Copy the files below `from` to `to`, which is created
+///
+/// A missing `from` leaves `to` empty, as for a first run.
+pub fn copy_directory(from: &Path, to: &Path) -> Result<()> {
+    std::fs::create_dir_all(to)?;
+    if !from.is_dir() {
+        return Ok(());
+    }
+    for entry in WalkDir::new(from) {
+        let entry = entry?;
+        let target = to.join(entry.path().strip_prefix(from)?);
+        if entry.file_type().is_dir() {
+            std::fs::create_dir_all(&target)?;
+        } else if entry.file_type().is_file() {
+            std::fs::copy(entry.path(), &target)?;
+        }
+    }
+    Ok(())
+}
+
+/// Compare an output directory with its staged copy
+///
+/// Unchanged files are left out; the others are sorted by path.
+pub fn planned_files(output_dir: &Path, staged_dir: &Path) -> Result<Vec<PlannedFile>> {
+    let current = read_tree(output_dir)?;
+    let staged = read_tree(staged_dir)?;
+
+    let mut planned = Vec::new();
+    for (file, content) in &staged {
+        let action = match current.get(file) {
+            None => FileAction::Create,
+            Some(current) if current != content => FileAction::Update,
+            Some(_) => continue,
+        };
+        planned.push(PlannedFile {
+            file: file.clone(),
+            action,
+        });
+    }
+    for file in current.keys().filter(|file| !staged.contains_key(*file)) {
+        planned.push(PlannedFile {
+            file: file.clone(),
+            action: FileAction::Delete,
+        });
+    }
+
+    planned.sort_by(|a, b| a.file.cmp(&b.file));
+    Ok(planned)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_planned_files() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let output = temp_dir.path().join("output");
+        let staged = temp_dir.path().join("staged");
+        std::fs::create_dir_all(output.join("tests")).unwrap();
+        std::fs::write(output.join("user.libsonnet"), "{ a: 1 }\n").unwrap();
+        std::fs::write(output.join("index.libsonnet"), "{}\n").unwrap();
+        std::fs::write(output.join("tests/user_test.jsonnet"), "{}\n").unwrap();
+        std::fs::write(output.join("conversions.libsonnet"), "{}\n").unwrap();
+
+        copy_directory(&output, &staged).unwrap();
+        assert!(staged.join("tests/user_test.jsonnet").is_file());
+
+        std::fs::write(staged.join("user.libsonnet"), "{ a: 2 }\n").unwrap();
+        std::fs::write(staged.join("group.libsonnet"), "{}\n").unwrap();
+        std::fs::remove_file(staged.join("conversions.libsonnet")).unwrap();
+
+        let planned: Vec<(String, FileAction)> = planned_files(&output, &staged)
+            .unwrap()
+            .into_iter()
+            .map(|p| (p.file.to_string_lossy().to_string(), p.action))
+            .collect();
+        assert_eq!(
+            planned,
+            vec![
+                ("conversions.libsonnet".to_string(), FileAction::Delete),
+                ("group.libsonnet".to_string(), FileAction::Create),
+                ("user.libsonnet".to_string(), FileAction::Update),
+            ]
+        );
+        assert_eq!(
+            std::fs::read_to_string(output.join("user.libsonnet")).unwrap(),
+            "{ a: 1 }\n"
+        );
+    }
+
+    #[tokio::test]
+    async fn test_plan_package_outputs() {
+        let Some(repository) = crate::git::test_repository(&[
+            (
+                "api/user.go",
+                "package api\n\ntype User struct {\n    Name string `json:\"name\"`\n}\n",
+            ),
+            (
+                "admin/role.go",
+                "package admin\n\ntype Role struct {\n    Name string `json:\"name\"`\n}\n",
+            ),
+        ]) else {
+            return;
+        };
+        let admin_dir = repository.path().join("admin-lib");
+        let mut source = crate::config::GoAstSource::from_url(
+            &format!("git+file://{}?name=api", repository.path().display()),
+            &repository.path().join("generated"),
+        )
+        .unwrap();
+        source.options.package_outputs = vec![crate::plugin::ast::PackageOutput {
+            packages: vec!["admin".to_string()],
+            output_path: admin_dir.clone(),
+            post_hook: Some("touch hooked".to_string()),
+        }];
+        let mut config = crate::Config::default();
+        config.sources = vec![crate::Source::GoAst(source)];
+        let app = crate::JsonnetGen::new(config).unwrap();
+
+        let source = app.config().sources[0].clone();
+        let result = app.process_source_dry_run(&source).await.unwrap();
+        assert!(result.errors.is_empty(), "{:?}", result.errors);
+
+        // Neither output is written and the hook does not run
+        assert!(!repository.path().join("generated").exists());
+        assert!(!admin_dir.exists());
+        assert!(result
+            .files
+            .iter()
+            .any(|file| file.file.starts_with(&admin_dir) && file.action == FileAction::Create));
+        assert!(result
+            .files
+            .iter()
+            .any(|file| file.file.is_relative() && file.action == FileAction::Create));
+    }
+
+    #[test]
+    fn test_copy_missing_directory() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let staged = temp_dir.path().join("staged");
+        copy_directory(&temp_dir.path().join("missing"), &staged).unwrap();
+        assert!(staged.is_dir());
+        assert!(planned_files(&temp_dir.path().join("missing"), &staged)
+            .unwrap()
+            .is_empty());
+    }
+}
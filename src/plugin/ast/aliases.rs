@@ -0,0 +1,178 @@
+//! Named primitive types and aliases of primitive types
+//!
+//! `type Port int` and `type Port = int` are not types of their own: fields
+//! of type `Port` get the schema of `int`, with the name of the type under
+//! `x-go-type` for documentation and validation hooks. Fields of named
+//! slices and maps (`type Users []*User`) likewise get the schema of the
+//! collection, and fields of structs and interfaces the reference to the
+//! type (`x-go-struct-ref`, `x-go-interface-ref`). The parser resolves the
+//! types declared in the file of the field; `resolve_primitive_types` looks
+//! up the ones declared in another file of the package.
+
+use std::collections::HashMap;
+use std::path::PathBuf;
+
+use crate::plugin::ExtractedSchema;
+
+use super::package_dir;
+
+/// Schema type of the named primitive types of a file returned by the parser
+///
+/// These are not types and are removed by `resolve_primitive_types`.
+pub const PRIMITIVE_TYPES_SCHEMA_TYPE: &str = "go_primitive_types";
+
+/// Key of a property schema naming its named primitive type
+pub const PRIMITIVE_TYPE_KEY: &str = "x-go-type";
+
+/// Key of a property schema naming the struct of its package it holds
+pub const STRUCT_REF_KEY: &str = "x-go-struct-ref";
+
+/// Key of a property schema naming a type the file of the field does not declare
+pub const LOCAL_TYPE_KEY: &str = "x-go-local-type";
+
+/// Give the fields of named primitive types of other files their schema
+///
+/// Removes the named primitive types from `schemas`, and the marks of
+/// fields whose type is not a named primitive type of the package.
+pub fn resolve_primitive_types(schemas: &mut Vec<ExtractedSchema>) {
+    let mut packages: HashMap<PathBuf, serde_yaml::Mapping> = HashMap::new();
+    for schema in schemas.iter() {
+        if schema.schema_type != PRIMITIVE_TYPES_SCHEMA_TYPE {
+            continue;
+        }
+        if let Some(types) = schema.content.as_mapping() {
+            packages
+                .entry(package_dir(schema))
+                .or_default()
+                .extend(types.clone());
+        }
+    }
+    schemas.retain(|schema| schema.schema_type != PRIMITIVE_TYPES_SCHEMA_TYPE);
+
+    let empty = serde_yaml::Mapping::new();
+    for schema in schemas.iter_mut() {
+        let types = packages.get(&package_dir(schema)).unwrap_or(&empty);
+        resolve_types(&mut schema.content, types, &mut Vec::new());
+    }
+}
+
+/// Resolve the types of the schemas nested in `value`
+///
+/// `resolving` holds the types whose schema `value` is part of: collections
+/// of other files may contain themselves, and are not expanded again.
+fn resolve_types(
+    value: &mut serde_yaml::Value,
+    types: &serde_yaml::Mapping,
+    resolving: &mut Vec<serde_yaml::Value>,
+) {
+    match value {
+        serde_yaml::Value::Mapping(mapping) => {
+            let mut expanded = false;
+            if let Some(type_name) = mapping.remove(LOCAL_TYPE_KEY) {
+                if let (Some(serde_yaml::Value::Mapping(primitive)), false) =
+                    (types.get(&type_name), resolving.contains(&type_name))
+                {
+                    // The primitive replaces the guessed shape of the type
+                    for (key, value) in primitive {
+                        mapping.insert(key.clone(), value.clone());
+                    }
+                    resolving.push(type_name);
+                    expanded = true;
+                }
+            }
+            for (_, nested) in mapping.iter_mut() {
+                resolve_types(nested, types, resolving);
+            }
+            if expanded {
+                resolving.pop();
+            }
+        }
+        serde_yaml::Value::Sequence(items) => {
+            for item in items {
+                resolve_types(item, types, resolving);
+            }
+        }
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::{test_schema, test_struct};
+
+    #[test]
+    fn test_resolve_primitive_types() {
+        let mut schemas = vec![
+            test_struct(
+                "Server",
+                "api",
+                "api/server.go",
+                "{properties: {port: {type: string, x-go-local-type: Port}, ports: {type: array, items: {type: string, x-go-local-type: Port}}, peer: {type: string, x-go-local-type: Peer}}}",
+            ),
+            test_schema(
+                "api",
+                PRIMITIVE_TYPES_SCHEMA_TYPE,
+                "api/types.go",
+                "{Port: {type: integer, x-go-type: Port}}",
+            ),
+        ];
+
+        resolve_primitive_types(&mut schemas);
+        assert_eq!(schemas.len(), 1);
+        let properties = &schemas[0].content["properties"];
+        let port: serde_yaml::Value =
+            serde_yaml::from_str("{type: integer, x-go-type: Port}").unwrap();
+        assert_eq!(properties["port"], port);
+        assert_eq!(properties["ports"]["items"], port);
+        assert_eq!(
+            properties["peer"],
+            serde_yaml::from_str::<serde_yaml::Value>("{type: string}").unwrap()
+        );
+    }
+
+    #[test]
+    fn test_resolve_collection_types() {
+        let mut schemas = vec![
+            ExtractedSchema {
+                name: "Team".to_string(),
+                schema_type: "go_struct".to_string(),
+                content: serde_yaml::from_str(
+                    "{properties: {members: {type: string, x-go-local-type: Users}, tree: {type: string, x-go-local-type: Tree}, owner: {type: string, x-go-local-type: User}}}",
+                )
+                .unwrap(),
+                source_file: "api/team.go".into(),
+                metadata: HashMap::new(),
+            },
+            ExtractedSchema {
+                name: "api".to_string(),
+                schema_type: PRIMITIVE_TYPES_SCHEMA_TYPE.to_string(),
+                content: serde_yaml::from_str(
+                    "{Users: {type: array, items: {type: object, nullable: true, x-go-struct-ref: User}, x-go-type: Users}, \
+                      User: {type: object, x-go-struct-ref: User}, \
+                      Tree: {type: object, additionalProperties: {type: string, x-go-local-type: Tree}, x-go-type: Tree}}",
+                )
+                .unwrap(),
+                source_file: "api/types.go".into(),
+                metadata: HashMap::new(),
+            },
+        ];
+
+        resolve_primitive_types(&mut schemas);
+        let properties = &schemas[0].content["properties"];
+        assert_eq!(properties["members"]["type"], "array");
+        assert_eq!(properties["members"]["items"]["nullable"], true);
+        // Structs of other files are objects referencing the struct
+        assert_eq!(
+            properties["owner"],
+            serde_yaml::from_str::<serde_yaml::Value>("{type: object, x-go-struct-ref: User}")
+                .unwrap()
+        );
+        // A type containing itself is expanded once
+        assert_eq!(properties["tree"]["type"], "object");
+        assert_eq!(
+            properties["tree"]["additionalProperties"],
+            serde_yaml::from_str::<serde_yaml::Value>("{type: string}").unwrap()
+        );
+    }
+}
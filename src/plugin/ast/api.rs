@@ -0,0 +1,141 @@
+//! Request and response types grouped by operation
+//!
+//! HTTP handlers often pair the types of an operation by name,
+//! `CreateUserRequest` and `CreateUserResponse`. The libraries of such
+//! types are also grouped in `api.libsonnet` under the operation, and the
+//! index links it as `api`:
+//!
+//! ```jsonnet
+//! local index = import "index.libsonnet";
+//!
+//! index.api.createUser.request.new(user)
+//! ```
+//!
+//! An operation may have only one of the two types. When the types come
+//! from several packages, the operations are grouped by package first
+//! (`api.v1.createUser`).
+
+use std::collections::BTreeMap;
+
+use super::generator::{field_key, quote_string, GoJsonnetGenerator};
+use super::parser::lower_camel;
+use crate::plugin::ExtractedSchema;
+
+/// File of the operations library
+pub const API_FILE: &str = "api.libsonnet";
+
+/// Suffixes of the types of an operation, with their field in the group
+const SUFFIXES: &[(&str, &str)] = &[("Request", "request"), ("Response", "response")];
+
+/// Libraries of the request and response of an operation
+type Operation = BTreeMap<&'static str, String>;
+
+/// Generate the operations library, if any type follows the convention
+pub fn generate_api(schemas: &[ExtractedSchema]) -> Option<String> {
+    let generator = GoJsonnetGenerator::new();
+    let mut packages: BTreeMap<String, BTreeMap<String, Operation>> = BTreeMap::new();
+    for schema in schemas {
+        // Enums and unions are not messages
+        if schema.content.get("properties").is_none() {
+            continue;
+        }
+        let Some((operation, field)) = SUFFIXES.iter().find_map(|(suffix, field)| {
+            let operation = schema.name.strip_suffix(suffix)?;
+            operation
+                .starts_with(|c: char| c.is_ascii_uppercase())
+                .then_some((operation, *field))
+        }) else {
+            continue;
+        };
+        let package = schema
+            .metadata
+            .get("package")
+            .and_then(|p| p.as_str())
+            .unwrap_or("main")
+            .to_string();
+        packages
+            .entry(package)
+            .or_default()
+            .entry(lower_camel(operation))
+            .or_default()
+            .insert(field, generator.file_name(schema));
+    }
+    if packages.is_empty() {
+        return None;
+    }
+
+    let mut code = String::from("// Generated from Go AST: api\n{\n");
+    let nested = packages.len() > 1;
+    for (package, operations) in &packages {
+        let indent = if nested {
+            code.push_str(&format!("  {}: {{\n", field_key(package)));
+            "    "
+        } else {
+            "  "
+        };
+        for (operation, files) in operations {
+            code.push_str(&format!("{indent}{}: {{\n", field_key(operation)));
+            for (field, file) in files {
+                code.push_str(&format!(
+                    "{indent}  {field}:: import {},\n",
+                    quote_string(&format!("./{file}"))
+                ));
+            }
+            code.push_str(&format!("{indent}}},\n"));
+        }
+        if nested {
+            code.push_str("  },\n");
+        }
+    }
+    code.push_str("}\n");
+    Some(code)
+}
+
+/// Link the operations library from a generated index
+pub fn link_api(index: &str) -> String {
+    let mut code = index.trim_end().to_string();
+    code.push_str(&format!(
+        "\n+ {{ api: import {} }}\n",
+        quote_string(&format!("./{API_FILE}"))
+    ));
+    code
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    #[test]
+    fn test_generate_api() {
+        let message = "{type: object, properties: {}}";
+        let mut schemas = vec![
+            test_struct("CreateUserRequest", "api", "api/types.go", message),
+            test_struct("CreateUserResponse", "api", "api/types.go", message),
+            test_struct("HealthResponse", "api", "api/types.go", message),
+            test_struct("Request", "api", "api/types.go", message),
+            test_struct("User", "api", "api/types.go", message),
+            test_struct(
+                "StatusRequest",
+                "api",
+                "api/types.go",
+                "{type: string, enum: [a]}",
+            ),
+        ];
+        assert_eq!(
+            generate_api(&schemas).unwrap(),
+            "// Generated from Go AST: api\n{\n  createUser: {\n    request:: import \"./createuserrequest.libsonnet\",\n    response:: import \"./createuserresponse.libsonnet\",\n  },\n  health: {\n    response:: import \"./healthresponse.libsonnet\",\n  },\n}\n"
+        );
+
+        schemas.push(test_struct("GetUserRequest", "v2", "v2/types.go", message));
+        let code = generate_api(&schemas).unwrap();
+        assert!(code.contains("  api: {\n    createUser: {\n"));
+        assert!(code.contains("  v2: {\n    getUser: {\n"));
+
+        assert!(generate_api(&schemas[4..5]).is_none());
+        assert_eq!(
+            link_api("{\n  packages: {},\n}\n"),
+            "{\n  packages: {},\n}\n+ { api: import \"./api.libsonnet\" }\n"
+        );
+    }
+}
@@ -0,0 +1,336 @@
+//! Types of several packages with the same name
+//!
+//! Each type is written to `<name>.libsonnet`, so types of different
+//! packages with one name, such as `v1.User` and `v2.User`, would overwrite
+//! each other in a shared output directory. `resolve_collisions` fails on
+//! them or renames them by the `name_collisions` strategy; a renamed type
+//! keeps its Go name in the `go_name` metadata, which references from other
+//! packages and the Go tests use.
+
+use anyhow::{anyhow, Result};
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::path::PathBuf;
+
+use super::aliases::STRUCT_REF_KEY;
+use super::generator::GoJsonnetGenerator;
+use super::naming;
+use super::options::CollisionStrategy;
+use super::package_dir;
+use super::parser::is_api_version;
+use crate::plugin::ExtractedSchema;
+
+/// Metadata key of the Go name of a renamed type
+pub const GO_NAME_KEY: &str = "go_name";
+
+/// Get the name of a type in its Go package
+pub fn go_name(schema: &ExtractedSchema) -> &str {
+    schema
+        .metadata
+        .get(GO_NAME_KEY)
+        .and_then(|n| n.as_str())
+        .unwrap_or(&schema.name)
+}
+
+/// Rename the types written to the same file as a type of another package
+///
+/// `route` gives the output directory of a type, as an index. `taken` holds
+/// the files written before, with the package directory of their type: the
+/// types colliding with those are renamed, and types colliding with each
+/// other all are. The files of `schemas` are added to `taken`. Returns the
+/// renames, as `(package.Type, new name)`.
+pub fn resolve_collisions(
+    schemas: &mut [ExtractedSchema],
+    strategy: CollisionStrategy,
+    route: impl Fn(&ExtractedSchema) -> usize,
+    taken: &mut HashMap<(usize, String), PathBuf>,
+) -> Result<Vec<(String, String)>> {
+    let generator = GoJsonnetGenerator::new();
+    let mut groups: BTreeMap<(usize, String), Vec<usize>> = BTreeMap::new();
+    for (idx, schema) in schemas.iter().enumerate() {
+        groups
+            .entry((route(schema), generator.file_name(schema)))
+            .or_default()
+            .push(idx);
+    }
+
+    let mut colliding = Vec::new();
+    let mut messages = Vec::new();
+    for (key, members) in &groups {
+        let dirs: BTreeSet<PathBuf> = members
+            .iter()
+            .map(|&idx| package_dir(&schemas[idx]))
+            .collect();
+        let renamed: Vec<usize> = match taken.get(key) {
+            Some(dir) => members
+                .iter()
+                .copied()
+                .filter(|&idx| package_dir(&schemas[idx]) != *dir)
+                .collect(),
+            None if dirs.len() > 1 => members.clone(),
+            None => Vec::new(),
+        };
+        if renamed.is_empty() {
+            continue;
+        }
+        let names: Vec<String> = renamed
+            .iter()
+            .map(|&idx| {
+                format!(
+                    "{} ({})",
+                    qualified_name(&schemas[idx]),
+                    schemas[idx].source_file.display()
+                )
+            })
+            .collect();
+        messages.push(format!(
+            "{} would be written to {}",
+            names.join(", "),
+            key.1
+        ));
+        colliding.extend(renamed);
+    }
+    if colliding.is_empty() {
+        record_files(schemas, &route, taken);
+        return Ok(Vec::new());
+    }
+    if strategy == CollisionStrategy::Error {
+        return Err(anyhow!(
+            "Types of several packages have the same name: {}; set name_collisions to qualify or version",
+            messages.join("; ")
+        ));
+    }
+
+    let mut renames = Vec::new();
+    for idx in colliding {
+        let name = new_name(&schemas[idx], strategy);
+        renames.push((qualified_name(&schemas[idx]), name.clone()));
+        rename(schemas, idx, name);
+    }
+
+    // The new names may collide in turn
+    let mut files: HashMap<(usize, String), PathBuf> = HashMap::new();
+    for schema in schemas.iter() {
+        let key = (route(schema), generator.file_name(schema));
+        let dir = package_dir(schema);
+        let previous = taken.get(&key).or_else(|| files.get(&key));
+        if previous.is_some_and(|previous| *previous != dir) {
+            return Err(anyhow!(
+                "Types of several packages have the same name after renaming {} to {}",
+                qualified_name(schema),
+                schema.name
+            ));
+        }
+        files.insert(key, dir);
+    }
+    taken.extend(files);
+    Ok(renames)
+}
+
+/// Add the files of types to the files written
+fn record_files(
+    schemas: &[ExtractedSchema],
+    route: &impl Fn(&ExtractedSchema) -> usize,
+    taken: &mut HashMap<(usize, String), PathBuf>,
+) {
+    let generator = GoJsonnetGenerator::new();
+    for schema in schemas {
+        taken
+            .entry((route(schema), generator.file_name(schema)))
+            .or_insert_with(|| package_dir(schema));
+    }
+}
+
+/// Get the name a strategy gives a colliding type
+fn new_name(schema: &ExtractedSchema, strategy: CollisionStrategy) -> String {
+    let package = schema
+        .metadata
+        .get("package")
+        .and_then(|p| p.as_str())
+        .unwrap_or_default();
+    let qualified = format!(
+        "{}{}",
+        naming::capitalize(&naming::camel_case(package)),
+        schema.name
+    );
+    if strategy != CollisionStrategy::Version {
+        return qualified;
+    }
+
+    let dir = package_dir(schema);
+    let version = dir
+        .components()
+        .rev()
+        .filter_map(|component| component.as_os_str().to_str())
+        .find(|component| is_api_version(component));
+    match version {
+        Some(version) => format!("{}{}", schema.name, naming::capitalize(version)),
+        None => qualified,
+    }
+}
+
+/// Rename a type and the references to it from its package
+fn rename(schemas: &mut [ExtractedSchema], idx: usize, name: String) {
+    let old = schemas[idx].name.clone();
+    let dir = package_dir(&schemas[idx]);
+    schemas[idx]
+        .metadata
+        .entry(GO_NAME_KEY.to_string())
+        .or_insert_with(|| old.as_str().into());
+    schemas[idx].name = name;
+    let file = GoJsonnetGenerator::new().file_name(&schemas[idx]);
+    let name = schemas[idx].name.clone();
+
+    for schema in schemas.iter_mut() {
+        if package_dir(schema) == dir {
+            rename_references(&mut schema.content, &old, &name, &file);
+        }
+    }
+}
+
+/// Point the references to a type of the package, and the union variants
+/// it is, at its new name
+fn rename_references(value: &mut serde_yaml::Value, old: &str, name: &str, file: &str) {
+    match value {
+        serde_yaml::Value::Mapping(map) => {
+            for key in [STRUCT_REF_KEY, "x-go-interface-ref"] {
+                if map.get(key).and_then(|r| r.as_str()) == Some(old) {
+                    map.insert(key.into(), name.into());
+                }
+            }
+            if let Some(variants) = map
+                .get_mut("x-go-union")
+                .and_then(|u| u.get_mut("variants"))
+                .and_then(|v| v.as_sequence_mut())
+            {
+                for variant in variants.iter_mut() {
+                    if variant.get("name").and_then(|n| n.as_str()) == Some(old) {
+                        variant["name"] = name.into();
+                        variant["file"] = file.into();
+                    }
+                }
+            }
+            for (_, inner) in map.iter_mut() {
+                rename_references(inner, old, name, file);
+            }
+        }
+        serde_yaml::Value::Sequence(values) => {
+            for inner in values {
+                rename_references(inner, old, name, file);
+            }
+        }
+        _ => {}
+    }
+}
+
+/// Get a type as `package.Type`
+fn qualified_name(schema: &ExtractedSchema) -> String {
+    match schema.metadata.get("package").and_then(|p| p.as_str()) {
+        Some(package) if !package.is_empty() => format!("{package}.{}", go_name(schema)),
+        _ => go_name(schema).to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    fn modules() -> Vec<ExtractedSchema> {
+        vec![
+            test_struct("User", "v1", "api/v1/user.go", "{properties: {}}"),
+            test_struct(
+                "Team",
+                "v1",
+                "api/v1/team.go",
+                "{properties: {owner: {type: object, x-go-struct-ref: User}}}",
+            ),
+            test_struct("User", "v2", "api/v2/user.go", "{properties: {}}"),
+            test_struct(
+                "User",
+                "users",
+                "internal/users/user.go",
+                "{properties: {}}",
+            ),
+            test_struct("Group", "v2", "api/v2/group.go", "{properties: {}}"),
+        ]
+    }
+
+    #[test]
+    fn test_resolve_collisions() {
+        let mut schemas = modules();
+        let error = resolve_collisions(
+            &mut schemas,
+            CollisionStrategy::Error,
+            |_| 0,
+            &mut HashMap::new(),
+        )
+        .unwrap_err();
+        assert!(error
+            .to_string()
+            .contains("v1.User (api/v1/user.go), v2.User (api/v2/user.go), users.User (internal/users/user.go) would be written to user.libsonnet"));
+
+        let renames = resolve_collisions(
+            &mut schemas,
+            CollisionStrategy::Version,
+            |_| 0,
+            &mut HashMap::new(),
+        )
+        .unwrap();
+        assert_eq!(renames.len(), 3);
+        let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(
+            names,
+            vec!["UserV1", "Team", "UserV2", "UsersUser", "Group"]
+        );
+        assert_eq!(go_name(&schemas[0]), "User");
+        assert_eq!(
+            schemas[1].content["properties"]["owner"][STRUCT_REF_KEY].as_str(),
+            Some("UserV1")
+        );
+
+        let mut schemas = modules();
+        resolve_collisions(
+            &mut schemas,
+            CollisionStrategy::Qualify,
+            |_| 0,
+            &mut HashMap::new(),
+        )
+        .unwrap();
+        assert_eq!(schemas[0].name, "V1User");
+
+        // Types written to other directories do not collide
+        let mut schemas = modules();
+        schemas.remove(2);
+        let renames = resolve_collisions(
+            &mut schemas,
+            CollisionStrategy::Error,
+            |schema| usize::from(schema.source_file.starts_with("internal")),
+            &mut HashMap::new(),
+        );
+        assert!(renames.is_ok_and(|renames| renames.is_empty()));
+    }
+
+    #[test]
+    fn test_resolve_collisions_with_earlier_files() {
+        let mut taken = HashMap::new();
+        let mut first = vec![test_struct(
+            "User",
+            "v1",
+            "api/v1/user.go",
+            "{properties: {}}",
+        )];
+        resolve_collisions(&mut first, CollisionStrategy::Version, |_| 0, &mut taken).unwrap();
+        assert_eq!(first[0].name, "User");
+
+        // Only the types of the later package are renamed
+        let mut second = vec![test_struct(
+            "User",
+            "v2",
+            "api/v2/user.go",
+            "{properties: {}}",
+        )];
+        let renames =
+            resolve_collisions(&mut second, CollisionStrategy::Version, |_| 0, &mut taken).unwrap();
+        assert_eq!(renames, vec![("v2.User".to_string(), "UserV2".to_string())]);
+    }
+}
@@ -0,0 +1,293 @@
+//! Exported constants of Go packages as a Jsonnet library
+//!
+//! With the `constants` option, the exported package-level constants with a
+//! literal value (port numbers, label and annotation keys, ...) are written
+//! to `constants.libsonnet` under their lowerCamelCase name, and the index
+//! links the library as `constants`, so Jsonnet code can use
+//! `index.constants.defaultPort` instead of repeating the value:
+//!
+//! ```go
+//! // DefaultPort is the port the server listens on
+//! const DefaultPort = 8080
+//! ```
+//!
+//! ```jsonnet
+//! {
+//!   // DefaultPort is the port the server listens on
+//!   defaultPort: 8080,
+//! }
+//! ```
+//!
+//! Field defaults may also name a constant, in a `default` tag or in the
+//! constructor of the type; `resolve_constant_defaults` looks up the ones
+//! declared in another file of the package than the type.
+
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::path::PathBuf;
+
+use super::generator::{field_key, quote_string, yaml_to_jsonnet};
+use super::package_dir;
+use super::parser::lower_camel;
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+/// Schema type of the constants of a file returned by the parser
+///
+/// These are not types and are removed by `take_constants`.
+pub const CONSTANTS_SCHEMA_TYPE: &str = "go_constants";
+
+/// Schema type of the values of all constants of a file returned by the parser
+///
+/// These are not types and are removed by `resolve_constant_defaults`.
+pub const CONSTANT_VALUES_SCHEMA_TYPE: &str = "go_constant_values";
+
+/// Key of a property schema naming the constant holding its default
+pub const DEFAULT_CONSTANT_KEY: &str = "x-go-default-const";
+
+/// File of the constants library
+pub const CONSTANTS_FILE: &str = "constants.libsonnet";
+
+/// An exported constant
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Constant {
+    /// Go name of the constant
+    pub name: String,
+
+    pub package: String,
+    pub value: serde_yaml::Value,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+}
+
+impl Constant {
+    /// Get the field of the constant in the library
+    pub fn key(&self) -> String {
+        lower_camel(&self.name)
+    }
+}
+
+/// Remove the constants from `schemas`
+///
+/// The constants are sorted by package and name. A name declared by several
+/// packages with different values is exported with the value of the first
+/// package, with a warning.
+pub fn take_constants(schemas: &mut Vec<ExtractedSchema>) -> (Vec<Constant>, Vec<String>) {
+    let mut constants: Vec<Constant> = schemas
+        .iter()
+        .filter(|schema| schema.schema_type == CONSTANTS_SCHEMA_TYPE)
+        .filter_map(|schema| serde_yaml::from_value::<Vec<Constant>>(schema.content.clone()).ok())
+        .flatten()
+        .collect();
+    schemas.retain(|schema| schema.schema_type != CONSTANTS_SCHEMA_TYPE);
+    constants.sort_by(|a, b| (&a.package, &a.name).cmp(&(&b.package, &b.name)));
+
+    let mut warnings = Vec::new();
+    let mut exported: BTreeMap<String, Constant> = BTreeMap::new();
+    for constant in constants {
+        match exported.get(&constant.key()) {
+            Some(existing) if existing.value != constant.value => {
+                warnings.push(Code::ConflictingConstant.warning(format!(
+                    "Constant {} is declared by packages {} and {} with different values; the value of {} is exported",
+                    constant.key(),
+                    existing.package,
+                    constant.package,
+                    existing.package
+                )))
+            }
+            Some(_) => {}
+            None => {
+                exported.insert(constant.key(), constant);
+            }
+        }
+    }
+    (exported.into_values().collect(), warnings)
+}
+
+/// Replace the constants named by defaults with their values
+///
+/// Removes the constant values from `schemas`. A default naming a constant
+/// the package does not declare is left out, with a warning, unless the
+/// field is a string: its default is then the text of the tag.
+pub fn resolve_constant_defaults(schemas: &mut Vec<ExtractedSchema>) -> Vec<String> {
+    let mut packages: HashMap<PathBuf, serde_yaml::Mapping> = HashMap::new();
+    for schema in schemas.iter() {
+        if schema.schema_type != CONSTANT_VALUES_SCHEMA_TYPE {
+            continue;
+        }
+        if let Some(values) = schema.content.as_mapping() {
+            packages
+                .entry(package_dir(schema))
+                .or_default()
+                .extend(values.clone());
+        }
+    }
+    schemas.retain(|schema| schema.schema_type != CONSTANT_VALUES_SCHEMA_TYPE);
+
+    let mut warnings = Vec::new();
+    let empty = serde_yaml::Mapping::new();
+    for schema in schemas.iter_mut() {
+        let values = packages.get(&package_dir(schema)).unwrap_or(&empty);
+        let mut missing = Vec::new();
+        resolve_defaults(&mut schema.content, values, &mut missing);
+        for constant in missing {
+            warnings.push(Code::UnresolvedDefault.warning(format!(
+                "Default {} of a field of {} is not a constant of its package and is left out",
+                constant, schema.name
+            )));
+        }
+    }
+    warnings
+}
+
+/// Resolve the defaults of the schemas nested in `value`
+fn resolve_defaults(
+    value: &mut serde_yaml::Value,
+    constants: &serde_yaml::Mapping,
+    missing: &mut Vec<String>,
+) {
+    match value {
+        serde_yaml::Value::Mapping(mapping) => {
+            if let Some(constant) = mapping.remove(DEFAULT_CONSTANT_KEY) {
+                match constants.get(&constant) {
+                    Some(default) => {
+                        mapping.insert("default".into(), default.clone());
+                    }
+                    None if !mapping.contains_key("default") => {
+                        missing.extend(constant.as_str().map(str::to_string));
+                    }
+                    None => {}
+                }
+            }
+            for (_, nested) in mapping.iter_mut() {
+                resolve_defaults(nested, constants, missing);
+            }
+        }
+        serde_yaml::Value::Sequence(items) => {
+            for item in items {
+                resolve_defaults(item, constants, missing);
+            }
+        }
+        _ => {}
+    }
+}
+
+/// Generate the constants library
+pub fn generate_constants(constants: &[Constant]) -> String {
+    let mut code = String::from("// Generated from Go AST: constants\n{\n");
+    for constant in constants {
+        if let Some(description) = &constant.description {
+            for line in description.lines() {
+                code.push_str(&format!("  // {line}\n"));
+            }
+        }
+        code.push_str(&format!(
+            "  {}: {},\n",
+            field_key(&constant.key()),
+            yaml_to_jsonnet(&constant.value)
+        ));
+    }
+    code.push_str("}\n");
+    code
+}
+
+/// Link the constants library from a generated index
+pub fn link_constants(index: &str) -> String {
+    let mut code = index.trim_end().to_string();
+    code.push_str(&format!(
+        "\n+ {{ constants: import {} }}\n",
+        quote_string(&format!("./{CONSTANTS_FILE}"))
+    ));
+    code
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    fn constants_schema(package: &str, constants: &[(&str, serde_yaml::Value)]) -> ExtractedSchema {
+        let constants: Vec<Constant> = constants
+            .iter()
+            .map(|(name, value)| Constant {
+                name: name.to_string(),
+                package: package.to_string(),
+                value: value.clone(),
+                description: None,
+            })
+            .collect();
+        test_schema(
+            package,
+            CONSTANTS_SCHEMA_TYPE,
+            &format!("/repo/{package}/constants.go"),
+            &serde_yaml::to_string(&constants).unwrap(),
+        )
+    }
+
+    #[test]
+    fn test_take_constants() {
+        let mut schemas = vec![
+            constants_schema(
+                "v1",
+                &[
+                    ("LabelKey", "app.kubernetes.io/name".into()),
+                    ("DefaultPort", 8080.into()),
+                ],
+            ),
+            constants_schema("v2", &[("DefaultPort", 9090.into())]),
+        ];
+
+        let (constants, warnings) = take_constants(&mut schemas);
+        assert!(schemas.is_empty());
+        let keys: Vec<String> = constants.iter().map(Constant::key).collect();
+        assert_eq!(keys, vec!["defaultPort", "labelKey"]);
+        assert_eq!(constants[0].value, serde_yaml::Value::from(8080));
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].contains("defaultPort"));
+
+        assert_eq!(
+            generate_constants(&constants),
+            "// Generated from Go AST: constants\n{\n  defaultPort: 8080,\n  labelKey: \"app.kubernetes.io/name\",\n}\n"
+        );
+    }
+
+    #[test]
+    fn test_resolve_constant_defaults() {
+        let mut schemas = vec![
+            test_schema(
+                "Page",
+                "go_struct",
+                "api/page.go",
+                "{properties: {limit: {type: integer, x-go-default-const: defaultLimit}, sort: {type: string, default: name, x-go-default-const: name}, size: {type: integer, x-go-default-const: maxSize}}}",
+            ),
+            test_schema(
+                "api",
+                CONSTANT_VALUES_SCHEMA_TYPE,
+                "api/defaults.go",
+                "{defaultLimit: 100}",
+            ),
+        ];
+
+        let warnings = resolve_constant_defaults(&mut schemas);
+        assert_eq!(schemas.len(), 1);
+        let properties = &schemas[0].content["properties"];
+        assert_eq!(properties["limit"]["default"], serde_yaml::Value::from(100));
+        assert_eq!(
+            properties["sort"]["default"],
+            serde_yaml::Value::from("name")
+        );
+        assert!(properties["size"].get("default").is_none());
+        assert!(properties["limit"].get(DEFAULT_CONSTANT_KEY).is_none());
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].contains("maxSize"));
+    }
+
+    #[test]
+    fn test_link_constants() {
+        assert_eq!(
+            link_constants("{\n  packages: {},\n}\n"),
+            "{\n  packages: {},\n}\n+ { constants: import \"./constants.libsonnet\" }\n"
+        );
+    }
+}
@@ -0,0 +1,361 @@
+//! CUE definitions of the generated types
+//!
+//! With the `cue` output format (`--output-format cue`), each type also gets
+//! a CUE definition in the `cue` directory of the output, named after its
+//! library (`user.libsonnet` gets `cue/user.cue`). The files form one CUE
+//! package named after the output directory, so definitions reference each
+//! other by name:
+//!
+//! ```cue
+//! package widgets
+//!
+//! import "strings"
+//!
+//! // A user
+//! #User: {
+//!     name: string & strings.MinRunes(1)
+//!     port?: *8080 | int & >=1 & <=65535
+//!     address?: null | #Address
+//! }
+//! ```
+//!
+//! Fields without `omitempty` are required. The bounds, lengths, patterns
+//! and formats of the `validate` tags become constraints, enums the
+//! disjunction of their values and interfaces with a union of
+//! implementations the disjunction of their definitions.
+
+use std::collections::BTreeSet;
+use std::path::Path;
+
+use super::generator::{
+    format_pattern, quote_string, GoJsonnetGenerator, INTEGER_KEY_PATTERN, MAP_KEY_KEY,
+};
+use super::package_dir;
+use super::references::{enum_literals, literal, TypeIndex};
+use crate::plugin::ExtractedSchema;
+
+/// Directory of the CUE definitions in the output
+pub const CUE_DIR: &str = "cue";
+
+/// CUE keywords, which are quoted as field names
+const CUE_KEYWORDS: &[&str] = &[
+    "package", "import", "for", "in", "if", "let", "true", "false", "null",
+];
+
+/// Get the file of the CUE definition of a type, in `CUE_DIR`
+pub fn cue_file(schema: &ExtractedSchema) -> String {
+    GoJsonnetGenerator::new()
+        .file_name(schema)
+        .replace(".libsonnet", ".cue")
+}
+
+/// Get the CUE package of the definitions written to an output directory
+pub fn package_name(output_path: &Path) -> String {
+    let name: String = output_path
+        .file_name()
+        .map(|name| name.to_string_lossy().to_lowercase())
+        .unwrap_or_default()
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '_' })
+        .collect();
+    match name.chars().next() {
+        Some(c) if c.is_ascii_alphabetic() => name,
+        _ => format!("gen{name}"),
+    }
+}
+
+/// Generate the CUE definition of each type, with its file in `CUE_DIR`
+pub fn generate_cue(schemas: &[ExtractedSchema], package: &str) -> Vec<(String, String)> {
+    let types = TypeIndex::new(schemas);
+    schemas
+        .iter()
+        .map(|schema| (cue_file(schema), file(&types, schema, package)))
+        .collect()
+}
+
+/// Generate the file of the definition of a type
+fn file(types: &TypeIndex, schema: &ExtractedSchema, package: &str) -> String {
+    let mut definition = Definition {
+        types,
+        imports: BTreeSet::new(),
+    };
+    let expression = definition.expression(&schema.content, &package_dir(schema), 0);
+
+    let mut code = format!(
+        "// Generated from Go AST: {}\npackage {}\n\n",
+        schema.name, package
+    );
+    match definition.imports.len() {
+        0 => {}
+        1 => code.push_str(&format!(
+            "import {}\n\n",
+            quote_string(definition.imports.first().copied().unwrap_or_default())
+        )),
+        _ => {
+            code.push_str("import (\n");
+            for import in &definition.imports {
+                code.push_str(&format!("\t{}\n", quote_string(import)));
+            }
+            code.push_str(")\n\n");
+        }
+    }
+    push_comment(&mut code, &schema.content, 0);
+    code.push_str(&format!("#{}: {}\n", schema.name, expression));
+    code
+}
+
+/// The conversion of the schema of one type
+struct Definition<'a> {
+    types: &'a TypeIndex<'a>,
+
+    /// Packages of the CUE standard library the constraints use
+    imports: BTreeSet<&'static str>,
+}
+
+impl Definition<'_> {
+    /// Get the CUE expression of a schema of the package in `dir`
+    fn expression(&mut self, schema: &serde_yaml::Value, dir: &Path, indent: usize) -> String {
+        let Some(mapping) = schema.as_mapping() else {
+            return "_".to_string();
+        };
+        let mut expression = match self.types.target_name(mapping, dir) {
+            Some(name) => format!("#{name}"),
+            None => self.shape(mapping, dir, indent),
+        };
+        if mapping.get("nullable").and_then(|n| n.as_bool()) == Some(true) {
+            expression = format!("null | {expression}");
+        }
+        if let Some(default) = mapping.get("default") {
+            expression = format!("*{} | {}", literal(default), expression);
+        }
+        expression
+    }
+
+    /// Get the CUE expression of the type and constraints of a schema
+    fn shape(&mut self, schema: &serde_yaml::Mapping, dir: &Path, indent: usize) -> String {
+        if let Some(values) = enum_literals(schema) {
+            return values.join(" | ");
+        }
+        let variants: Vec<String> = self
+            .types
+            .variants(schema, dir)
+            .into_iter()
+            .map(|idx| format!("#{}", self.types.schema(idx).name))
+            .collect();
+        if !variants.is_empty() {
+            return variants.join(" | ");
+        }
+
+        let integer = |key: &str| schema.get(key).and_then(|v| v.as_u64());
+        let mut terms = Vec::new();
+        match schema.get("type").and_then(|t| t.as_str()) {
+            Some("string") => {
+                terms.push("string".to_string());
+                match schema.get("format").and_then(|f| f.as_str()) {
+                    Some("date-time") => {
+                        self.imports.insert("time");
+                        terms.push("time.Time".to_string());
+                    }
+                    Some("duration") => {
+                        self.imports.insert("time");
+                        terms.push("time.Duration".to_string());
+                    }
+                    Some(format) => terms
+                        .extend(format_pattern(format).map(|p| format!("=~{}", quote_string(p)))),
+                    None => {}
+                }
+                if let Some(pattern) = schema.get("pattern").and_then(|p| p.as_str()) {
+                    terms.push(format!("=~{}", quote_string(pattern)));
+                }
+                if let Some(min) = integer("minLength") {
+                    self.imports.insert("strings");
+                    terms.push(format!("strings.MinRunes({min})"));
+                }
+                if let Some(max) = integer("maxLength") {
+                    self.imports.insert("strings");
+                    terms.push(format!("strings.MaxRunes({max})"));
+                }
+            }
+            Some(numeric @ ("integer" | "number")) => {
+                terms.push(
+                    if numeric == "integer" {
+                        "int"
+                    } else {
+                        "number"
+                    }
+                    .to_string(),
+                );
+                for (key, operator) in [
+                    ("minimum", ">="),
+                    ("exclusiveMinimum", ">"),
+                    ("maximum", "<="),
+                    ("exclusiveMaximum", "<"),
+                ] {
+                    if let Some(bound) = schema.get(key).filter(|b| b.is_number()) {
+                        terms.push(format!("{operator}{}", literal(bound)));
+                    }
+                }
+            }
+            Some("boolean") => terms.push("bool".to_string()),
+            Some("array") => {
+                let items = schema
+                    .get("items")
+                    .map(|items| self.expression(items, dir, indent))
+                    .unwrap_or_else(|| "_".to_string());
+                terms.push(format!("[...{items}]"));
+                if let Some(min) = integer("minItems") {
+                    self.imports.insert("list");
+                    terms.push(format!("list.MinItems({min})"));
+                }
+                if let Some(max) = integer("maxItems") {
+                    self.imports.insert("list");
+                    terms.push(format!("list.MaxItems({max})"));
+                }
+            }
+            Some("object") => terms.push(self.structure(schema, dir, indent)),
+            _ => terms.push("_".to_string()),
+        }
+        terms.join(" & ")
+    }
+
+    /// Get the CUE struct of an object schema
+    fn structure(&mut self, schema: &serde_yaml::Mapping, dir: &Path, indent: usize) -> String {
+        let properties = schema.get("properties").and_then(|p| p.as_mapping());
+        let required: Vec<&str> = schema
+            .get("required")
+            .and_then(|r| r.as_sequence())
+            .into_iter()
+            .flatten()
+            .filter_map(|name| name.as_str())
+            .collect();
+        let inner = "\t".repeat(indent + 1);
+
+        let mut code = String::from("{\n");
+        for (name, property) in properties.into_iter().flatten() {
+            let Some(name) = name.as_str() else {
+                continue;
+            };
+            push_comment(&mut code, property, indent + 1);
+            let optional = if required.contains(&name) { "" } else { "?" };
+            code.push_str(&format!(
+                "{inner}{}{optional}: {}\n",
+                label(name),
+                self.expression(property, dir, indent + 1)
+            ));
+        }
+        match schema.get("additionalProperties") {
+            Some(values) if values.is_mapping() => {
+                let keys = match schema.get(MAP_KEY_KEY) {
+                    Some(keys) if keys.get("type").and_then(|t| t.as_str()) == Some("integer") => {
+                        format!("=~{}", quote_string(INTEGER_KEY_PATTERN))
+                    }
+                    _ => "string".to_string(),
+                };
+                code.push_str(&format!(
+                    "{inner}[{keys}]: {}\n",
+                    self.expression(values, dir, indent + 1)
+                ));
+            }
+            Some(serde_yaml::Value::Bool(false)) => {}
+            // Free-form objects are open
+            _ if properties.is_none() => code.push_str(&format!("{inner}...\n")),
+            _ => {}
+        }
+        code.push_str(&format!("{}}}", "\t".repeat(indent)));
+        code
+    }
+}
+
+/// Write the description of a schema as a comment
+fn push_comment(code: &mut String, schema: &serde_yaml::Value, indent: usize) {
+    if let Some(description) = schema.get("description").and_then(|d| d.as_str()) {
+        let tabs = "\t".repeat(indent);
+        for line in description.lines() {
+            match line.is_empty() {
+                true => code.push_str(&format!("{tabs}//\n")),
+                false => code.push_str(&format!("{tabs}// {line}\n")),
+            }
+        }
+    }
+}
+
+/// Get the label of a field, quoted unless it is a plain identifier
+///
+/// Names starting with `_` or `#` would be hidden fields or definitions.
+fn label(name: &str) -> String {
+    let mut chars = name.chars();
+    let plain = chars
+        .next()
+        .is_some_and(|c| c.is_ascii_alphabetic() || c == '$')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '$')
+        && !CUE_KEYWORDS.contains(&name);
+    if plain {
+        name.to_string()
+    } else {
+        quote_string(name)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_generate_cue() {
+        let schemas = vec![
+            test_schema("User", "go_struct", "api/user.go", "{type: object, description: A user, required: [name, tags], properties: {_id: {type: string}, address: {type: object, nullable: true, x-go-struct-ref: Address}, labels: {type: object, additionalProperties: {type: string}}, name: {type: string, minLength: 1, pattern: '^[a-z]+$'}, port: {type: integer, default: 8080, minimum: 1, maximum: 65535}, ports: {type: object, additionalProperties: {type: string}, x-go-map-key: {type: integer}}, since: {type: string, format: date-time}, tags: {type: array, items: {type: string}, maxItems: 3}}}"),
+            test_schema("Address", "go_struct", "api/address.go", "{type: object, properties: {city: {type: string}}}"),
+        ];
+
+        let files = generate_cue(&schemas, "widgets");
+        assert_eq!(files[0].0, "user.cue");
+        assert_eq!(
+            files[0].1,
+            concat!(
+                "// Generated from Go AST: User\n",
+                "package widgets\n\n",
+                "import (\n\t\"list\"\n\t\"strings\"\n\t\"time\"\n)\n\n",
+                "// A user\n",
+                "#User: {\n",
+                "\t\"_id\"?: string\n",
+                "\taddress?: null | #Address\n",
+                "\tlabels?: {\n\t\t[string]: string\n\t}\n",
+                "\tname: string & =~\"^[a-z]+$\" & strings.MinRunes(1)\n",
+                "\tport?: *8080 | int & >=1 & <=65535\n",
+                "\tports?: {\n\t\t[=~\"^(0|-?[1-9][0-9]*)$\"]: string\n\t}\n",
+                "\tsince?: string & time.Time\n",
+                "\ttags: [...string] & list.MaxItems(3)\n",
+                "}\n",
+            )
+        );
+        assert_eq!(
+            files[1].1,
+            "// Generated from Go AST: Address\npackage widgets\n\n#Address: {\n\tcity?: string\n}\n"
+        );
+    }
+
+    #[test]
+    fn test_generate_cue_enums_and_unions() {
+        let schemas = vec![
+            test_schema("Level", "go_struct", "api/level.go", "{type: integer, x-go-enum: {flags: false, values: [{name: LevelDebug, value: 0}, {name: LevelInfo, value: 1}]}}"),
+            test_schema("Color", "go_struct", "api/color.go", "{type: string, x-go-enum: {flags: false, values: [{name: ColorRed, value: 0, string: red}, {name: ColorBlue, value: 1, string: blue}]}}"),
+            test_schema("Shape", "go_struct", "api/shape.go", "{type: object, x-go-union: {discriminator: kind, variants: [{name: Circle}, {name: Square}]}}"),
+            test_schema("Circle", "go_struct", "api/circle.go", "{type: object, properties: {radius: {type: number}}}"),
+            test_schema("Square", "go_struct", "api/square.go", "{type: object}"),
+        ];
+
+        let files = generate_cue(&schemas, "api");
+        assert!(files[0].1.ends_with("#Level: 0 | 1\n"));
+        assert!(files[1].1.ends_with("#Color: \"red\" | \"blue\"\n"));
+        assert!(files[2].1.ends_with("#Shape: #Circle | #Square\n"));
+        // Free-form objects are open
+        assert!(files[4].1.ends_with("#Square: {\n\t...\n}\n"));
+    }
+
+    #[test]
+    fn test_package_name() {
+        assert_eq!(package_name(Path::new("./generated/my-api")), "my_api");
+        assert_eq!(package_name(Path::new("out/1.2")), "gen1_2");
+    }
+}
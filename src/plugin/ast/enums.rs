@@ -0,0 +1,275 @@
+//! Integer enums declared with `iota`
+//!
+//! A named integer type with a `const` block counting with `iota` is an
+//! enum, and one shifting by `iota` is a set of flags:
+//!
+//! ```go
+//! type Level int
+//!
+//! const (
+//!     LevelDebug Level = iota
+//!     LevelInfo
+//! )
+//!
+//! type Permission uint8
+//!
+//! const (
+//!     PermissionRead Permission = 1 << iota
+//!     PermissionWrite
+//! )
+//! ```
+//!
+//! The parser records the named integer types and computes the values of
+//! each block; the constants may be declared in another file than their
+//! type, so `resolve_enums` pairs them up per package into the schema of the
+//! type. The library of an enum holds its
+//! values by name (`level.debug`), the list of values and an `isValid`
+//! check; flag sets also get `combine([...])`, OR-ing flags into one value.
+
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::path::PathBuf;
+
+use super::package_dir;
+use super::parser::lower_camel;
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+/// Schema type of the enum constants returned by the parser
+///
+/// These are not types of their own and are removed by `resolve_enums`.
+pub const ENUM_SCHEMA_TYPE: &str = "go_enum";
+
+/// Fields of enum libraries that are not values
+const HELPERS: &[&str] = &[
+    "values", "all", "isValid", "combine", "byName", "byValue", "fromInt", "toInt",
+];
+
+/// A constant of an enum
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct EnumValue {
+    /// Go name of the constant
+    pub name: String,
+
+    pub value: i64,
+
+    /// Name printed by the `String()` method, with the `enum_strings` option
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub string: Option<String>,
+}
+
+/// Get the field of an enum constant in its library
+///
+/// The name of the type is dropped from the front of the constant
+/// (`LevelDebug` is `debug`) unless the rest would clash with a helper.
+pub fn value_key(type_name: &str, constant: &str) -> String {
+    let short = constant
+        .strip_prefix(type_name)
+        .filter(|rest| rest.starts_with(|c: char| c.is_ascii_uppercase()))
+        .map(lower_camel);
+    match short {
+        Some(short) if !HELPERS.contains(&short.as_str()) => short,
+        _ => lower_camel(constant),
+    }
+}
+
+/// Get the values of an enum type described by `x-go-enum`, without
+/// duplicates: the `String()` names of string enums, the integers otherwise
+///
+/// Flag sets combine their values and are not enumerated.
+pub fn enum_values(schema: &serde_yaml::Mapping) -> Option<Vec<&serde_yaml::Value>> {
+    let enumeration = schema.get("x-go-enum")?;
+    if enumeration.get("flags").and_then(|f| f.as_bool()) == Some(true) {
+        return None;
+    }
+    let key = if schema.get("type").and_then(|t| t.as_str()) == Some("string") {
+        "string"
+    } else {
+        "value"
+    };
+    let mut values = Vec::new();
+    for value in enumeration.get("values")?.as_sequence()? {
+        let value = value.get(key)?;
+        if !values.contains(&value) {
+            values.push(value);
+        }
+    }
+    Some(values)
+}
+
+/// Turn the named integer types enumerated by constants into schemas
+///
+/// Removes the `go_enum` schemas and adds one `go_struct` schema per integer
+/// type of a package with `iota` constants, described by `x-go-enum`. Named
+/// integers without constants are dropped, as before; warnings are returned
+/// for constants whose type is not declared in their package.
+pub fn resolve_enums(schemas: &mut Vec<ExtractedSchema>) -> Vec<String> {
+    let mut enums: HashMap<(PathBuf, String), (bool, Vec<EnumValue>)> = HashMap::new();
+    let mut order = Vec::new();
+    let mut types = Vec::new();
+    let mut stringers: HashMap<(PathBuf, String), Option<Vec<String>>> = HashMap::new();
+    for schema in schemas.iter() {
+        if schema.schema_type != ENUM_SCHEMA_TYPE {
+            continue;
+        }
+        let key = (package_dir(schema), schema.name.clone());
+        if schema.content.get("stringer").is_some() {
+            let names = schema
+                .content
+                .get("names")
+                .cloned()
+                .and_then(|names| serde_yaml::from_value(names).ok());
+            let entry = stringers.entry(key).or_default();
+            if entry.is_none() {
+                *entry = names;
+            }
+            continue;
+        }
+        let Some(values) = schema.content.get("values") else {
+            types.push(schema.clone());
+            continue;
+        };
+        let values: Vec<EnumValue> = serde_yaml::from_value(values.clone()).unwrap_or_default();
+        let flags = schema
+            .content
+            .get("flags")
+            .and_then(|f| f.as_bool())
+            .unwrap_or_default();
+        if !enums.contains_key(&key) {
+            order.push(key.clone());
+        }
+        let entry = enums.entry(key).or_default();
+        entry.0 |= flags;
+        entry.1.extend(values);
+    }
+    schemas.retain(|schema| schema.schema_type != ENUM_SCHEMA_TYPE);
+
+    for mut schema in types {
+        let key = (package_dir(&schema), schema.name.clone());
+        let Some((flags, mut values)) = enums.remove(&key) else {
+            continue;
+        };
+        let mut content = schema.content.as_mapping().cloned().unwrap_or_default();
+        // Flags combine into values without a name of their own
+        if let (Some(names), false) = (stringers.get(&key), flags) {
+            name_values(&mut values, names.as_deref());
+            content.insert("type".into(), "string".into());
+        }
+        let mut enum_values = serde_yaml::Mapping::new();
+        enum_values.insert("flags".into(), flags.into());
+        enum_values.insert(
+            "values".into(),
+            serde_yaml::to_value(values).unwrap_or_default(),
+        );
+        content.insert("x-go-enum".into(), serde_yaml::Value::Mapping(enum_values));
+        schema.schema_type = "go_struct".to_string();
+        schema.content = serde_yaml::Value::Mapping(content);
+        schemas.push(schema);
+    }
+
+    order
+        .into_iter()
+        .filter(|key| enums.contains_key(key))
+        .map(|(dir, name)| {
+            Code::UntypedEnum.warning(format!(
+                "Constants of {} in {} have no integer type declaration in their package; no enum library is generated",
+                name,
+                dir.display()
+            ))
+        })
+        .collect()
+}
+
+/// Record the names printed by `String()` on the values of an enum
+///
+/// Stringer lists one name per distinct value in ascending order; without
+/// its tables, or when they do not match the constants, the names are the
+/// names of the constants, as stringer prints them by default.
+fn name_values(values: &mut [EnumValue], table: Option<&[String]>) {
+    let mut distinct: Vec<i64> = values.iter().map(|v| v.value).collect();
+    distinct.sort_unstable();
+    distinct.dedup();
+    let table = table.filter(|names| names.len() == distinct.len());
+
+    let mut named: HashMap<i64, String> = HashMap::new();
+    for value in values.iter_mut() {
+        let string = match table {
+            Some(names) => names[distinct.binary_search(&value.value).unwrap_or_default()].clone(),
+            // Aliases print as the first constant of their value
+            None => named
+                .entry(value.value)
+                .or_insert_with(|| value.name.clone())
+                .clone(),
+        };
+        value.string = Some(string);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_value_key() {
+        assert_eq!(value_key("Level", "LevelDebug"), "debug");
+        assert_eq!(value_key("Level", "Debug"), "debug");
+        assert_eq!(value_key("Mode", "ModeAll"), "modeAll");
+        assert_eq!(value_key("Level", "Levels"), "levels");
+        assert_eq!(value_key("Code", "CodeHTTPError"), "httpError");
+    }
+
+    #[test]
+    fn test_resolve_enums() {
+        let mut schemas = vec![
+            test_schema("User", "go_struct", "api/user.go", "{type: object}"),
+            test_schema("Level", ENUM_SCHEMA_TYPE, "api/level.go", "{type: integer}"),
+            test_schema("Count", ENUM_SCHEMA_TYPE, "api/level.go", "{type: integer}"),
+            test_schema("Level", ENUM_SCHEMA_TYPE, "api/level_values.go", "{flags: false, values: [{name: LevelDebug, value: 0}, {name: LevelInfo, value: 1}]}"),
+            test_schema("Missing", ENUM_SCHEMA_TYPE, "api/level.go", "{flags: true, values: [{name: MissingA, value: 1}]}"),
+        ];
+
+        let warnings = resolve_enums(&mut schemas);
+        assert_eq!(schemas.len(), 2);
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].contains("Missing"));
+
+        // Count has no constants and is not an enum
+        assert_eq!(schemas[1].name, "Level");
+        assert_eq!(schemas[1].schema_type, "go_struct");
+        let level = &schemas[1].content;
+        assert_eq!(level.get("type").and_then(|t| t.as_str()), Some("integer"));
+        let values: Vec<EnumValue> =
+            serde_yaml::from_value(level["x-go-enum"]["values"].clone()).unwrap();
+        assert_eq!(values.len(), 2);
+        assert_eq!(values[1].name, "LevelInfo");
+        assert_eq!(values[1].value, 1);
+    }
+
+    #[test]
+    fn test_resolve_enums_stringer() {
+        let values = "{flags: false, values: [{name: ColorRed, value: 0}, {name: ColorBlue, value: 1}, {name: ColorDefault, value: 0}]}";
+        let mut schemas = vec![
+            test_schema("Color", ENUM_SCHEMA_TYPE, "api/color.go", "{type: integer}"),
+            test_schema("Color", ENUM_SCHEMA_TYPE, "api/color.go", values),
+            test_schema("Color", ENUM_SCHEMA_TYPE, "api/color_string.go", "{stringer: true, names: [red, blue]}"),
+            test_schema("Shade", ENUM_SCHEMA_TYPE, "api/color.go", "{type: integer}"),
+            test_schema("Shade", ENUM_SCHEMA_TYPE, "api/color.go", "{flags: false, values: [{name: ShadeDark, value: 0}, {name: ShadeLight, value: 1}, {name: ShadeBlack, value: 0}]}"),
+            test_schema("Shade", ENUM_SCHEMA_TYPE, "api/shade.go", "{stringer: true}"),
+        ];
+
+        assert!(resolve_enums(&mut schemas).is_empty());
+        let strings = |schema: &ExtractedSchema| -> Vec<String> {
+            let values: Vec<EnumValue> =
+                serde_yaml::from_value(schema.content["x-go-enum"]["values"].clone()).unwrap();
+            values.into_iter().filter_map(|v| v.string).collect()
+        };
+        assert_eq!(schemas[0].content["type"], "string");
+        assert_eq!(strings(&schemas[0]), vec!["red", "blue", "red"]);
+        // Without the tables of stringer, the constants name the values
+        assert_eq!(
+            strings(&schemas[1]),
+            vec!["ShadeDark", "ShadeLight", "ShadeDark"]
+        );
+    }
+}
@@ -0,0 +1,343 @@
+//! Sentinel errors, error types and error codes of Go packages as a Jsonnet
+//! catalog
+//!
+//! With the `errors` option, the exported errors of the packages are written
+//! to `errors.libsonnet` under their lowerCamelCase name, and the index links
+//! the library as `errors`, so alerting rules and test fixtures refer to the
+//! canonical identifiers instead of copying messages:
+//!
+//! ```go
+//! // ErrNotFound is returned for users that do not exist
+//! var ErrNotFound = errors.New("user not found")
+//!
+//! const CodeNotFound ErrorCode = 404
+//! ```
+//!
+//! ```jsonnet
+//! {
+//!   // ErrNotFound is returned for users that do not exist
+//!   errNotFound: { name: "ErrNotFound", package: "users", kind: "sentinel", message: "user not found", code: 404 },
+//!
+//!   // Errors by code
+//!   byCode:: { "404": self.errNotFound },
+//! }
+//! ```
+//!
+//! Sentinel errors are the variables set to `errors.New` or `fmt.Errorf` of a
+//! string literal, error types the types with an `Error()` method, and error
+//! codes the constants of a type named `...Code`. A code belongs to the
+//! errors of its package with the same name apart from the `Err`, `Error`
+//! and `Code` affixes (`CodeNotFound` to `ErrNotFound` and `NotFoundError`);
+//! other codes are entries of their own.
+
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+
+use super::generator::{field_key, quote_string, yaml_to_jsonnet};
+use super::parser::lower_camel;
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+/// Schema type of the errors of a file returned by the parser
+///
+/// These are not types and are removed by `take_errors`.
+pub const ERRORS_SCHEMA_TYPE: &str = "go_errors";
+
+/// File of the errors library
+pub const ERRORS_FILE: &str = "errors.libsonnet";
+
+/// Affixes of the names of errors and codes, longest first
+const AFFIXES: &[&str] = &["ErrorCode", "ErrCode", "Error", "Code", "Err"];
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ErrorKind {
+    /// A variable holding an error value
+    Sentinel,
+
+    /// A type with an `Error()` method
+    Type,
+
+    /// A constant of an error code type
+    Code,
+}
+
+impl ErrorKind {
+    fn as_str(&self) -> &'static str {
+        match self {
+            ErrorKind::Sentinel => "sentinel",
+            ErrorKind::Type => "type",
+            ErrorKind::Code => "code",
+        }
+    }
+}
+
+/// An exported error of a package
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct GoError {
+    /// Go name of the variable, type or constant
+    pub name: String,
+
+    pub package: String,
+    pub kind: ErrorKind,
+
+    /// Text of the error, for sentinel errors
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub code: Option<serde_yaml::Value>,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+}
+
+impl GoError {
+    /// Get the field of the error in the library
+    pub fn key(&self) -> String {
+        lower_camel(&self.name)
+    }
+}
+
+/// Name of an error or code without its affixes (`NotFound` for
+/// `ErrNotFound`, `NotFoundError` and `CodeNotFound`)
+fn stem(name: &str) -> &str {
+    let mut stem = name;
+    if let Some(rest) = AFFIXES.iter().find_map(|prefix| {
+        stem.strip_prefix(prefix)
+            .filter(|rest| rest.starts_with(|c: char| c.is_ascii_uppercase()))
+    }) {
+        stem = rest;
+    }
+    if let Some(rest) = AFFIXES
+        .iter()
+        .find_map(|suffix| stem.strip_suffix(suffix).filter(|rest| !rest.is_empty()))
+    {
+        stem = rest;
+    }
+    stem
+}
+
+/// Remove the errors from `schemas` and build the catalog
+///
+/// Codes are given to the errors of their package they name, and the
+/// entries are sorted by package and name. A name declared by several
+/// packages with a different message or code is exported with those of the
+/// first package, with a warning.
+pub fn take_errors(schemas: &mut Vec<ExtractedSchema>) -> (Vec<GoError>, Vec<String>) {
+    let mut records: Vec<GoError> = schemas
+        .iter()
+        .filter(|schema| schema.schema_type == ERRORS_SCHEMA_TYPE)
+        .filter_map(|schema| serde_yaml::from_value::<Vec<GoError>>(schema.content.clone()).ok())
+        .flatten()
+        .collect();
+    schemas.retain(|schema| schema.schema_type != ERRORS_SCHEMA_TYPE);
+    records.sort_by(|a, b| (&a.package, &a.name).cmp(&(&b.package, &b.name)));
+
+    // Codes are declared in any file of their package
+    let mut codes: BTreeMap<(String, String), serde_yaml::Value> = BTreeMap::new();
+    for record in records.iter().filter(|r| r.kind == ErrorKind::Code) {
+        if let Some(code) = &record.code {
+            codes
+                .entry((record.package.clone(), stem(&record.name).to_string()))
+                .or_insert_with(|| code.clone());
+        }
+    }
+    let mut claimed = BTreeSet::new();
+    for record in records.iter_mut().filter(|r| r.kind != ErrorKind::Code) {
+        let stem = (record.package.clone(), stem(&record.name).to_string());
+        if let Some(code) = codes.get(&stem) {
+            record.code = Some(code.clone());
+            claimed.insert(stem);
+        }
+    }
+    records.retain(|record| {
+        record.kind != ErrorKind::Code
+            || !claimed.contains(&(record.package.clone(), stem(&record.name).to_string()))
+    });
+
+    let mut warnings = Vec::new();
+    let mut exported: BTreeMap<String, GoError> = BTreeMap::new();
+    for record in records {
+        match exported.get(&record.key()) {
+            Some(existing)
+                if (&existing.message, &existing.code) != (&record.message, &record.code) =>
+            {
+                warnings.push(Code::ConflictingError.warning(format!(
+                    "Error {} is declared by packages {} and {} differently; the one of {} is exported",
+                    record.key(),
+                    existing.package,
+                    record.package,
+                    existing.package
+                )))
+            }
+            Some(_) => {}
+            None => {
+                exported.insert(record.key(), record);
+            }
+        }
+    }
+    (exported.into_values().collect(), warnings)
+}
+
+/// Generate the errors library
+pub fn generate_errors(errors: &[GoError]) -> String {
+    let optional = |value: Option<String>| value.unwrap_or_else(|| "null".to_string());
+    let mut code = String::from("// Generated from Go AST: errors\n{\n");
+    for error in errors {
+        if let Some(description) = &error.description {
+            for line in description.lines() {
+                code.push_str(&format!("  // {line}\n"));
+            }
+        }
+        code.push_str(&format!(
+            "  {}: {{ name: {}, package: {}, kind: {}, message: {}, code: {} }},\n",
+            field_key(&error.key()),
+            quote_string(&error.name),
+            quote_string(&error.package),
+            quote_string(error.kind.as_str()),
+            optional(error.message.as_deref().map(quote_string)),
+            optional(error.code.as_ref().map(yaml_to_jsonnet)),
+        ));
+    }
+
+    // The first error of each code, in the order of the catalog
+    let mut by_code: BTreeMap<String, String> = BTreeMap::new();
+    for error in errors {
+        let code = match &error.code {
+            Some(serde_yaml::Value::String(code)) => code.clone(),
+            Some(serde_yaml::Value::Number(code)) => code.to_string(),
+            _ => continue,
+        };
+        by_code.entry(code).or_insert_with(|| error.key());
+    }
+    if !by_code.is_empty() {
+        code.push_str("\n  // Errors by code\n  byCode:: {\n");
+        for (error_code, key) in by_code {
+            code.push_str(&format!(
+                "    {}: self{},\n",
+                quote_string(&error_code),
+                match field_key(&key) {
+                    key if key.starts_with('"') => format!("[{key}]"),
+                    key => format!(".{key}"),
+                }
+            ));
+        }
+        code.push_str("  },\n");
+    }
+    code.push_str("}\n");
+    code
+}
+
+/// Link the errors library from a generated index
+pub fn link_errors(index: &str) -> String {
+    let mut code = index.trim_end().to_string();
+    code.push_str(&format!(
+        "\n+ {{ errors: import {} }}\n",
+        quote_string(&format!("./{ERRORS_FILE}"))
+    ));
+    code
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn error(package: &str, name: &str, kind: ErrorKind) -> GoError {
+        GoError {
+            name: name.to_string(),
+            package: package.to_string(),
+            kind,
+            message: None,
+            code: None,
+            description: None,
+        }
+    }
+
+    fn errors_schema(errors: Vec<GoError>) -> ExtractedSchema {
+        ExtractedSchema {
+            name: errors[0].package.clone(),
+            schema_type: ERRORS_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::to_value(errors).unwrap(),
+            source_file: "/repo/users/errors.go".into(),
+            metadata: HashMap::new(),
+        }
+    }
+
+    #[test]
+    fn test_take_errors() {
+        let not_found = GoError {
+            message: Some("user not found".to_string()),
+            description: Some("ErrNotFound is returned for users that do not exist".to_string()),
+            ..error("users", "ErrNotFound", ErrorKind::Sentinel)
+        };
+        let code = |name: &str, value: u64| GoError {
+            code: Some(value.into()),
+            ..error("users", name, ErrorKind::Code)
+        };
+        let mut schemas = vec![
+            errors_schema(vec![
+                not_found,
+                error("users", "ValidationError", ErrorKind::Type),
+            ]),
+            errors_schema(vec![
+                code("CodeNotFound", 404),
+                code("ValidationErrorCode", 422),
+                code("CodeTeapot", 418),
+            ]),
+            errors_schema(vec![GoError {
+                message: Some("no such user".to_string()),
+                ..error("admin", "ErrNotFound", ErrorKind::Sentinel)
+            }]),
+        ];
+
+        let (errors, warnings) = take_errors(&mut schemas);
+        assert!(schemas.is_empty());
+        let entries: Vec<(&str, &str, Option<&serde_yaml::Value>)> = errors
+            .iter()
+            .map(|e| (e.package.as_str(), e.name.as_str(), e.code.as_ref()))
+            .collect();
+        assert_eq!(
+            entries,
+            vec![
+                ("users", "CodeTeapot", Some(&418.into())),
+                ("admin", "ErrNotFound", None),
+                ("users", "ValidationError", Some(&422.into())),
+            ]
+        );
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].starts_with("GS0404: Error errNotFound"));
+
+        assert_eq!(stem("ErrNotFound"), "NotFound");
+        assert_eq!(stem("ErrCodeNotFound"), "NotFound");
+        assert_eq!(stem("NotFoundErr"), "NotFound");
+        assert_eq!(stem("Error"), "Error");
+    }
+
+    #[test]
+    fn test_generate_errors() {
+        let errors = vec![
+            GoError {
+                message: Some("user not found".to_string()),
+                code: Some(404.into()),
+                description: Some(
+                    "ErrNotFound is returned for users that do not exist".to_string(),
+                ),
+                ..error("users", "ErrNotFound", ErrorKind::Sentinel)
+            },
+            GoError {
+                code: Some(404.into()),
+                ..error("users", "NotFoundError", ErrorKind::Type)
+            },
+        ];
+        assert_eq!(
+            generate_errors(&errors),
+            "// Generated from Go AST: errors\n{\n  // ErrNotFound is returned for users that do not exist\n  errNotFound: { name: \"ErrNotFound\", package: \"users\", kind: \"sentinel\", message: \"user not found\", code: 404 },\n  notFoundError: { name: \"NotFoundError\", package: \"users\", kind: \"type\", message: null, code: 404 },\n\n  // Errors by code\n  byCode:: {\n    \"404\": self.errNotFound,\n  },\n}\n"
+        );
+        assert_eq!(
+            link_errors("{\n  packages: {},\n}\n"),
+            "{\n  packages: {},\n}\n+ { errors: import \"./errors.libsonnet\" }\n"
+        );
+    }
+}
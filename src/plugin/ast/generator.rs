@@ -0,0 +1,5085 @@
+//! Jsonnet code generation for schemas extracted from Go source
+//!
+//! Each Go type becomes a library object whose hidden `new()` and `withX()`
+//! helpers return `self + {...}`, so they can be used both as mixins
+//! (`lib.new() + lib.withName('a')`) and chained (`lib.new().withName('a')`).
+
+use anyhow::Result;
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::{Path, PathBuf};
+
+use super::aliases::STRUCT_REF_KEY;
+use super::enums::{value_key, EnumValue};
+use super::naming::snake_case;
+use super::patterns;
+use super::unions::DEFAULT_DISCRIMINATOR;
+use super::validate::FIELD_RULES_KEY;
+use crate::compat::{function_surface, tokenize};
+use crate::plugin::ExtractedSchema;
+
+/// Jsonnet reserved words that cannot be used as identifiers
+const JSONNET_KEYWORDS: &[&str] = &[
+    "assert",
+    "else",
+    "error",
+    "false",
+    "for",
+    "function",
+    "if",
+    "import",
+    "importstr",
+    "importbin",
+    "in",
+    "local",
+    "null",
+    "tailstrict",
+    "then",
+    "self",
+    "super",
+    "true",
+];
+
+/// RFC 3339 timestamp, as produced by `time.Time.MarshalJSON`
+const DATE_TIME_PATTERN: &str =
+    r"^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?(Z|[+-][0-9]{2}:[0-9]{2})$";
+
+/// Go duration string, as accepted by `time.ParseDuration`
+pub const DURATION_PATTERN: &str = r"^(0|[-+]?(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+)$";
+
+/// Dotted-quad IPv4 address
+const IPV4_PATTERN: &str = r"^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$";
+
+/// IPv6 address; only the character set and presence of a colon are checked
+const IPV6_PATTERN: &str = r"^[0-9a-fA-F:]*:[0-9a-fA-F:.]*$";
+
+/// Key of a label or annotation, as checked by
+/// `validation.IsQualifiedName`: a name of at most 63 characters, with an
+/// optional DNS subdomain prefix
+const QUALIFIED_NAME_PATTERN: &str = r"^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$";
+
+/// Format of the keys of labels and annotations
+pub const QUALIFIED_NAME_FORMAT: &str = "qualified-name";
+
+/// Key of string maps holding labels or annotations, as `labels` or
+/// `annotations`, which get a setter of one entry
+pub const METADATA_MAP_KEY: &str = "x-go-metadata-map";
+
+/// Kubernetes resource quantity, as written by `resource.Quantity`
+const QUANTITY_PATTERN: &str =
+    r"^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([KMGTPE]i|[numkMGTPE]|[eE][+-]?[0-9]+)?$";
+
+/// Semantic version, as in the `semver` rule of go-playground/validator
+pub const SEMVER_PATTERN: &str = r"^(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-(0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*)(\.(0|[1-9][0-9]*|[0-9]*[a-zA-Z-][0-9a-zA-Z-]*))*)?(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$";
+
+/// IPv4 CIDR block
+const CIDRV4_PATTERN: &str = r"^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])/(3[0-2]|[12]?[0-9])$";
+
+/// IPv6 CIDR block; the address is checked as by `IPV6_PATTERN`
+const CIDRV6_PATTERN: &str = r"^[0-9a-fA-F:]*:[0-9a-fA-F:.]*/(12[0-8]|1[01][0-9]|[1-9]?[0-9])$";
+
+/// Integer map key, as written by encoding/json
+pub const INTEGER_KEY_PATTERN: &str = r"^(0|-?[1-9][0-9]*)$";
+
+/// String formats checked by the generated assertions, as
+/// (format, helper, pattern, description)
+const FORMAT_CHECKS: &[(&str, &str, &str, &str)] = &[
+    (
+        "date-time",
+        "isDateTime",
+        DATE_TIME_PATTERN,
+        "an RFC3339 timestamp",
+    ),
+    (
+        "duration",
+        "isDuration",
+        DURATION_PATTERN,
+        "a duration such as 30s or 5m",
+    ),
+    (
+        "email",
+        "isEmail",
+        r"^[^@\s]+@[^@\s]+\.[^@\s]+$",
+        "an email address",
+    ),
+    (
+        "uri",
+        "isUri",
+        r"^[a-zA-Z][a-zA-Z0-9+.-]*://[^\s]+$",
+        "a URL",
+    ),
+    (
+        "uuid",
+        "isUuid",
+        r"^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$",
+        "a UUID",
+    ),
+    ("ipv4", "isIpv4", IPV4_PATTERN, "an IPv4 address"),
+    ("ipv6", "isIpv6", IPV6_PATTERN, "an IPv6 address"),
+    ("ip", "isIp", "", "an IP address"),
+    ("cidrv4", "isCidrv4", CIDRV4_PATTERN, "an IPv4 CIDR block"),
+    ("cidrv6", "isCidrv6", CIDRV6_PATTERN, "an IPv6 CIDR block"),
+    ("cidr", "isCidr", "", "a CIDR block"),
+    ("semver", "isSemver", SEMVER_PATTERN, "a semantic version"),
+    (
+        QUALIFIED_NAME_FORMAT,
+        "isQualifiedName",
+        QUALIFIED_NAME_PATTERN,
+        "a qualified name such as app.kubernetes.io/name",
+    ),
+    (
+        "quantity",
+        "isQuantity",
+        QUANTITY_PATTERN,
+        "a quantity such as 512Mi or 250m",
+    ),
+    (
+        "hostname",
+        "isHostname",
+        r"^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$",
+        "a hostname",
+    ),
+];
+
+/// Recursive object merge used by the map mixins; other values are replaced
+const DEEP_MERGE_HELPER: &str = "local deepMerge(a, b) =\n  if std.isObject(a) && std.isObject(b)\n  then a + { [k]: deepMerge(std.get(a, k), b[k]) for k in std.objectFields(b) }\n  else b;\n";
+
+/// Deep merge of the map mixins asserting that no value is replaced by one
+/// of another type, such as a string by an object; `null` replaces and is
+/// replaced freely
+const ASSERTED_MERGE_HELPER: &str = "local deepMerge(a, b, path) =\n  if std.isObject(a) && std.isObject(b)\n  then a + { [k]: deepMerge(std.get(a, k), b[k], path + '.' + k) for k in std.objectFields(b) }\n  else\n    assert a == null || b == null || std.type(a) == std.type(b) : '%s: %s cannot be replaced by %s' % [path, std.type(a), std.type(b)];\n    b;\n";
+
+/// Deep merge of the map mixins tracing the values replaced by one of
+/// another type
+const TRACED_MERGE_HELPER: &str = "local deepMerge(a, b, path) =\n  if std.isObject(a) && std.isObject(b)\n  then a + { [k]: deepMerge(std.get(a, k), b[k], path + '.' + k) for k in std.objectFields(b) }\n  else if a == null || b == null || std.type(a) == std.type(b) then b\n  else std.trace('%s: %s replaced by %s' % [path, std.type(a), std.type(b)], b);\n";
+
+/// Get the deep merge helper of a struct schema, with the checks of its
+/// `MERGE_CHECKS_KEY`
+fn deep_merge_helper(schema: &serde_yaml::Value) -> &'static str {
+    match merge_checks(schema) {
+        Some("assert") => ASSERTED_MERGE_HELPER,
+        Some("trace") => TRACED_MERGE_HELPER,
+        _ => DEEP_MERGE_HELPER,
+    }
+}
+
+/// Get the `MERGE_CHECKS_KEY` mode of a struct schema
+fn merge_checks(schema: &serde_yaml::Value) -> Option<&str> {
+    schema
+        .get(MERGE_CHECKS_KEY)
+        .and_then(|m| m.as_str())
+        .filter(|mode| matches!(*mode, "assert" | "trace"))
+}
+
+/// Call the deep merge helper of a struct schema on the field `name`, named
+/// `path` by the checks
+fn deep_merge_call(schema: &serde_yaml::Value, name: &str, path: &str, param: &str) -> String {
+    let quoted = quote_string(name);
+    match merge_checks(schema) {
+        Some(_) => format!(
+            "deepMerge(super[{quoted}], {param}, {})",
+            quote_string(path)
+        ),
+        None => format!("deepMerge(super[{quoted}], {param})"),
+    }
+}
+
+/// How a `withXMixin()` setter combines values
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum MixinKind {
+    /// Slices, appended with `+:`
+    List,
+    /// Maps, deep-merged
+    Map,
+}
+
+/// Get the mixin kind of a property, if it has one
+fn mixin_kind(property: &serde_yaml::Value) -> Option<MixinKind> {
+    match property.get("type").and_then(|t| t.as_str()) {
+        Some("array") => Some(MixinKind::List),
+        Some("object")
+            if property
+                .get("additionalProperties")
+                .is_some_and(|a| a.is_mapping()) =>
+        {
+            Some(MixinKind::Map)
+        }
+        _ => None,
+    }
+}
+
+/// Comment line introducing a tombstone
+const TOMBSTONE_COMMENT: &str = "  // Tombstone";
+
+/// Comment marking the setters kept under the old name of a renamed field
+const RENAMED_COMMENT: &str = "  // Deprecated:";
+
+/// Version of gensonnet recorded in the header of generated libraries
+const GENERATOR_VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// Directory of the generated jsonnetunit tests, inside the output directory
+pub const TESTS_DIR: &str = "tests";
+
+/// Directory of the generated example manifests, inside the output directory
+pub const EXAMPLES_DIR: &str = "examples";
+
+/// File of the conversions between API versions, inside the output directory
+pub const CONVERSIONS_FILE: &str = "convert.libsonnet";
+
+/// File of the defaults of the types generated with `defaults_library`
+pub const DEFAULTS_FILE: &str = "defaults.libsonnet";
+
+/// Key of schemas whose defaults are written to `DEFAULTS_FILE`
+pub const DEFAULTS_LIBRARY_KEY: &str = "x-go-defaults-library";
+
+/// Key of struct schemas holding the `visibility` of their field classes
+pub const VISIBILITY_KEY: &str = "x-go-visibility";
+
+/// Key of the properties of `omitempty` fields generated with `omit_empty`
+pub const OMIT_EMPTY_KEY: &str = "x-go-omitempty";
+
+/// Key of the `OMIT_EMPTY_KEY` properties of pointer fields, empty only when
+/// null even when they are not `nullable`
+pub const POINTER_KEY: &str = "x-go-pointer";
+
+/// Formats checked as either of two others, without a pattern of their own
+const EITHER_FORMATS: &[(&str, &str, &str)] =
+    &[("ip", "ipv4", "ipv6"), ("cidr", "cidrv4", "cidrv6")];
+
+/// Key of struct schemas whose patterns are checked with the `regexMatch`
+/// native function instead of `patterns::MATCHES_PATTERN_HELPER`, as
+/// `native`, or with it when the evaluator registers it, as `auto`
+pub const PATTERN_CHECKS_KEY: &str = "x-go-pattern-checks";
+
+/// Key of struct schemas whose scalar fields get `withXFromEnv()` setters
+pub const ENV_SETTERS_KEY: &str = "x-go-env-setters";
+
+/// Key of struct schemas whose map mixins check the types of the values they
+/// replace, as `trace` or `assert`
+pub const MERGE_CHECKS_KEY: &str = "x-go-merge-checks";
+
+/// Key of a map schema holding the schema of its keys, when they are not
+/// plain strings
+pub const MAP_KEY_KEY: &str = "x-go-map-key";
+
+/// Setters of the `metadata` helpers of Kubernetes resources, with whether
+/// they get a mixin
+const METADATA_HELPERS: &[(&str, bool)] = &[
+    ("name", false),
+    ("namespace", false),
+    ("labels", true),
+    ("annotations", true),
+];
+
+/// Index category of types without a `+gensonnet:category` marker
+const DEFAULT_CATEGORY: &str = "uncategorized";
+
+/// Validation keywords listed as the constraints of fields in `index.json`
+const INDEX_CONSTRAINTS: &[&str] = &[
+    "minimum",
+    "maximum",
+    "exclusiveMinimum",
+    "exclusiveMaximum",
+    "multipleOf",
+    "minLength",
+    "maxLength",
+    "pattern",
+    "format",
+    "minItems",
+    "maxItems",
+    "uniqueItems",
+    "minProperties",
+    "maxProperties",
+];
+
+/// A type listed in the generated index
+#[derive(Debug, Clone)]
+struct IndexEntry {
+    description: String,
+    import_path: String,
+    file: String,
+
+    /// Kind, fields, values and variants, for `index.json`
+    api: serde_json::Map<String, serde_json::Value>,
+}
+
+/// A Go package listed in the generated index, with its types by category
+#[derive(Debug, Clone, Default)]
+struct IndexPackage {
+    import_path: String,
+    categories: BTreeMap<String, BTreeMap<String, IndexEntry>>,
+}
+
+/// Jsonnet library generator for Go schemas
+#[derive(Debug, Clone, Default)]
+pub struct GoJsonnetGenerator;
+
+impl GoJsonnetGenerator {
+    /// Create a new generator
+    pub fn new() -> Self {
+        Self
+    }
+
+    /// Get the output file name for a schema
+    pub fn file_name(&self, schema: &ExtractedSchema) -> String {
+        self.type_file_name(&schema.name)
+    }
+
+    /// Get the output file name for the schema of a type
+    pub fn type_file_name(&self, type_name: &str) -> String {
+        format!("{}.libsonnet", type_name.to_lowercase())
+    }
+
+    /// Get sample arguments for the `new()` of a schema, as Jsonnet literals
+    ///
+    /// The values satisfy the checks of each parameter where they can be
+    /// derived from the schema (defaults, enums, formats and bounds), so the
+    /// constructor can be evaluated without user input.
+    pub fn sample_new_args(&self, schema: &ExtractedSchema) -> Vec<String> {
+        if schema.content.get("x-go-kubernetes").is_some() {
+            return vec![quote_string(&schema.name.to_lowercase())];
+        }
+
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+
+        constructor_params(&properties)
+            .into_iter()
+            .map(|(_, _, property)| sample_value(property))
+            .collect()
+    }
+
+    /// Get the parameter names of the `new()` of a schema
+    pub fn new_params(&self, schema: &ExtractedSchema) -> Vec<String> {
+        if schema.content.get("x-go-kubernetes").is_some() {
+            return vec!["name".to_string()];
+        }
+
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+
+        constructor_params(&properties)
+            .into_iter()
+            .map(|(_, param, _)| param)
+            .collect()
+    }
+
+    /// Get the parameter types of the generated functions of a schema
+    ///
+    /// Types are JSON Schema types, with the format when there is one
+    /// (`string/email`) and the element type of arrays and maps
+    /// (`array<array<string>>`, `object<integer>`). Functions whose
+    /// parameters are not fields, such as the `Now` setters, are left out.
+    pub fn param_types(&self, schema: &ExtractedSchema) -> BTreeMap<String, Vec<String>> {
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+
+        let names = HelperNames::of(&schema.content);
+        let mut types = BTreeMap::new();
+
+        // Kubernetes resources are built from a name, with the helpers of
+        // metadata and spec nested in fields of their own
+        if schema.content.get("x-go-kubernetes").is_some() {
+            types.insert("new".to_string(), vec!["string".to_string()]);
+            for (field, mixin) in METADATA_HELPERS {
+                let field_type = match *mixin {
+                    true => "object<string>",
+                    false => "string",
+                };
+                types.insert(
+                    format!("metadata.{}", names.setter(field)),
+                    vec![field_type.to_string()],
+                );
+                if *mixin {
+                    types.insert(
+                        format!("metadata.{}", names.variant(field, "Mixin")),
+                        vec![field_type.to_string()],
+                    );
+                    types.insert(
+                        format!("metadata.{}", entry_setter(field, names)),
+                        vec!["string".to_string(); 2],
+                    );
+                }
+            }
+            let spec = properties
+                .get("spec")
+                .and_then(|s| s.get("properties"))
+                .and_then(|p| p.as_mapping())
+                .cloned()
+                .unwrap_or_default();
+            for (name, property) in &spec {
+                let Some(name) = name.as_str().filter(|_| !is_read_only(property)) else {
+                    continue;
+                };
+                if mixin_kind(property).is_some() {
+                    types.insert(
+                        format!("spec.{}", names.variant(name, "Mixin")),
+                        vec![param_type(property)],
+                    );
+                }
+                types.insert(
+                    format!("spec.{}", names.setter(name)),
+                    vec![param_type(property)],
+                );
+                for (helper, _, _) in unit_helpers(name, property, &spec, names) {
+                    types.insert(format!("spec.{helper}"), vec!["number".to_string()]);
+                }
+                if let Some(entry) = metadata_entry_setter(name, property, names) {
+                    types.insert(format!("spec.{entry}"), vec!["string".to_string(); 2]);
+                }
+                if let Some((helper, _)) = env_setter(&schema.content, name, property, names, "") {
+                    types.insert(format!("spec.{helper}"), vec!["string".to_string()]);
+                }
+            }
+            types.insert("spec.validate".to_string(), vec!["object".to_string()]);
+
+            // Fields next to metadata and spec are set directly
+            for (name, property) in &properties {
+                let name = match name.as_str() {
+                    Some("metadata" | "spec" | "status" | "kind") | None => continue,
+                    Some(_) if is_read_only(property) => continue,
+                    Some(name) => name,
+                };
+                types.insert(names.setter(name), vec![param_type(property)]);
+            }
+            types.insert("validate".to_string(), vec!["object".to_string()]);
+            types.insert(names.function("assertValid"), vec!["object".to_string()]);
+            return types;
+        }
+
+        types.insert(
+            "new".to_string(),
+            constructor_params(&properties)
+                .into_iter()
+                .map(|(_, _, property)| param_type(property))
+                .collect(),
+        );
+        types.insert("validate".to_string(), vec!["object".to_string()]);
+        types.insert(names.function("assertValid"), vec!["object".to_string()]);
+
+        for (name, property) in &properties {
+            let name = match name.as_str() {
+                Some(_) if is_read_only(property) => continue,
+                Some(name) => name,
+                None => continue,
+            };
+            let renamed_from = property
+                .get("x-go-renamed-from")
+                .and_then(|r| r.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|r| r.as_str());
+            for field in std::iter::once(name).chain(renamed_from) {
+                if mixin_kind(property).is_some() {
+                    types.insert(names.variant(field, "Mixin"), vec![param_type(property)]);
+                }
+                types.insert(names.setter(field), vec![param_type(property)]);
+            }
+
+            for (helper, _, _) in unit_helpers(name, property, &properties, names) {
+                types.insert(helper, vec!["number".to_string()]);
+            }
+            if let Some(entry) = metadata_entry_setter(name, property, names) {
+                types.insert(entry, vec!["string".to_string(); 2]);
+            }
+            if let Some((helper, _)) = env_setter(&schema.content, name, property, names, "") {
+                types.insert(helper, vec!["string".to_string()]);
+            }
+
+            if property.get("x-go-conditions").and_then(|c| c.as_bool()) == Some(true) {
+                let singular = name.strip_suffix('s').unwrap_or(name);
+                types.insert(
+                    names.prefixed("with", singular),
+                    vec!["string".to_string(); 5],
+                );
+                types.insert(names.prefixed("has", singular), vec!["string".to_string()]);
+            }
+        }
+
+        types
+    }
+
+    /// Generate the example program of a schema
+    ///
+    /// The program, written to `EXAMPLES_DIR`, builds a value with the
+    /// library from sample values derived from the schema: a Kubernetes
+    /// resource sets its required spec fields, other objects pass the
+    /// parameters of `new()` and set the optional fields with an enum or an
+    /// example. Objects with checks are passed through `validate()`. Unions,
+    /// enums and interfaces have no example.
+    pub fn generate_example(&self, schema: &ExtractedSchema) -> Option<String> {
+        if schema.content.get("x-go-kubernetes").is_none() {
+            return self.generate_object_example(schema);
+        }
+
+        let file_name = self.file_name(schema);
+        let stem = file_name.trim_end_matches(".libsonnet");
+        let local = param_name(stem);
+        let names = HelperNames::of(&schema.content);
+        let spec = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.get("spec"))
+            .and_then(|s| s.get("properties"))
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+
+        let mut code = String::new();
+        code.push_str(&format!(
+            "// Example {} built with {file_name}\n",
+            schema.name
+        ));
+        code.push_str(&format!("// Generator: gensonnet {GENERATOR_VERSION}\n"));
+        code.push_str(&format!(
+            "local {local} = import {};\n\n",
+            quote_string(&format!("../{file_name}"))
+        ));
+        code.push_str(&format!(
+            "{local}.new({})\n",
+            quote_string(&format!("{stem}-example"))
+        ));
+        for (name, _, property) in constructor_params(&spec) {
+            code.push_str(&format!(
+                "+ {local}.spec.{}({})\n",
+                names.setter(name),
+                sample_value(property)
+            ));
+        }
+
+        Some(code)
+    }
+
+    /// Generate the example program of an object that is not a resource
+    fn generate_object_example(&self, schema: &ExtractedSchema) -> Option<String> {
+        if schema.content.get("x-go-union").is_some()
+            || schema.content.get("x-go-enum").is_some()
+            || schema.content.get("x-go-interface").is_some()
+        {
+            return None;
+        }
+        let properties = schema.content.get("properties")?.as_mapping()?.clone();
+
+        let file_name = self.file_name(schema);
+        let stem = file_name.trim_end_matches(".libsonnet");
+        let local = param_name(stem);
+        let names = HelperNames::of(&schema.content);
+        let args: Vec<String> = constructor_params(&properties)
+            .into_iter()
+            .map(|(_, _, property)| sample_value(property))
+            .collect();
+
+        let mut value = format!("{local}.new({})", args.join(", "));
+        for (name, property) in &properties {
+            let Some(name) = name.as_str() else {
+                continue;
+            };
+            let optional = property
+                .get("x-go-constructor-param")
+                .and_then(|p| p.as_bool())
+                != Some(true);
+            let read_only = is_read_only(property);
+            let sampled = property.get("enum").is_some() || property.get("example").is_some();
+            if optional && !read_only && sampled {
+                value.push_str(&format!(
+                    "\n  .{}({})",
+                    names.setter(name),
+                    sample_value(property)
+                ));
+            }
+        }
+
+        let mut code = String::new();
+        code.push_str(&format!(
+            "// Example {} built with {file_name}\n",
+            schema.name
+        ));
+        code.push_str(&format!("// Generator: gensonnet {GENERATOR_VERSION}\n"));
+        code.push_str(&format!(
+            "local {local} = import {};\n\n",
+            quote_string(&format!("../{file_name}"))
+        ));
+        if has_validate(&properties) {
+            code.push_str(&format!("{local}.validate({value})\n"));
+        } else {
+            code.push_str(&format!("{value}\n"));
+        }
+
+        Some(code)
+    }
+
+    /// Generate jsonnetunit test scaffolding for a schema
+    ///
+    /// Returns files as (path relative to `TESTS_DIR`, code). The suite
+    /// `<type>_test.jsonnet` checks that `new()` produces the defaults, that
+    /// `validate()` accepts the result and that mixins merge. Jsonnet cannot
+    /// catch errors, so each rejected input is a separate program under
+    /// `invalid/<type>/` that must fail to evaluate.
+    pub fn generate_tests(&self, schema: &ExtractedSchema) -> Vec<(PathBuf, String)> {
+        // Union and enum libraries have no constructor of their own to test,
+        // and the helpers of Kubernetes resources are mixins checked by the Go
+        // tests
+        if schema.content.get("x-go-union").is_some()
+            || schema.content.get("x-go-enum").is_some()
+            || schema.content.get("x-go-kubernetes").is_some()
+        {
+            return Vec::new();
+        }
+
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+        let file_name = self.file_name(schema);
+        let stem = file_name.trim_end_matches(".libsonnet");
+        let params = constructor_params(&properties);
+        let args: Vec<String> = params
+            .iter()
+            .map(|(_, _, property)| sample_value(property))
+            .collect();
+        let has_validate = has_validate(&properties);
+
+        let names = HelperNames::of(&schema.content);
+        let mut files = Vec::new();
+
+        let mut code = String::new();
+        code.push_str(&format!(
+            "// Generated from Go AST: {} tests\n",
+            schema.name
+        ));
+        code.push_str("local test = import \"jsonnetunit/test.libsonnet\";\n");
+        code.push_str(&format!(
+            "local lib = import {};\n\n",
+            quote_string(&format!("../{file_name}"))
+        ));
+        code.push_str(&format!("local sample = lib.new({});\n\n", args.join(", ")));
+        code.push_str("test.suite({\n");
+
+        // The constructor sets the required fields and the defaults
+        let mut expected = Vec::new();
+        for (name, property) in &properties {
+            let name = match name.as_str() {
+                Some(name) => name,
+                None => continue,
+            };
+            if let Some(idx) = params.iter().position(|(n, _, _)| *n == name) {
+                expected.push(format!("{}: {}", field_key(name), args[idx]));
+            } else if is_read_only(property) || omits_empty(property) {
+                continue;
+            } else if let Some(default) = property.get("default") {
+                expected.push(format!("{}: {}", field_key(name), yaml_to_jsonnet(default)));
+            }
+        }
+        code.push_str(&format!("  {}: {{\n", names.function("testNew")));
+        code.push_str("    actual: sample,\n");
+        code.push_str(&format!("    expect: {{ {} }},\n", expected.join(", ")));
+        code.push_str("  },\n");
+
+        if has_validate {
+            code.push_str(&format!("  {}: {{\n", names.function("testValidate")));
+            code.push_str("    actual: lib.validate(sample),\n");
+            code.push_str("    expect: sample,\n");
+            code.push_str("  },\n");
+        }
+
+        for (name, property) in &properties {
+            let name = match name.as_str() {
+                Some(name) => name,
+                None => continue,
+            };
+            let (first, second, expect) = match mixin_kind(property) {
+                _ if is_read_only(property) => continue,
+                Some(MixinKind::List) => {
+                    let item = property
+                        .get("items")
+                        .map(sample_value)
+                        .unwrap_or_else(|| "null".to_string());
+                    (
+                        format!("[{item}]"),
+                        format!("[{item}]"),
+                        format!("[{item}, {item}]"),
+                    )
+                }
+                Some(MixinKind::Map) => {
+                    let value = property
+                        .get("additionalProperties")
+                        .map(sample_value)
+                        .unwrap_or_else(|| "null".to_string());
+                    (
+                        format!("{{ a: {value} }}"),
+                        format!("{{ b: {value} }}"),
+                        format!("{{ a: {value}, b: {value} }}"),
+                    )
+                }
+                None => continue,
+            };
+            let setter = names.setter(name);
+            let mixin = names.variant(name, "Mixin");
+            let access = if is_identifier(name) {
+                format!(".{name}")
+            } else {
+                format!("[{}]", quote_string(name))
+            };
+            code.push_str(&format!("  {}: {{\n", names.prefixed("test", &mixin)));
+            code.push_str(&format!(
+                "    actual: sample.{setter}({first}).{mixin}({second}){access},\n"
+            ));
+            code.push_str(&format!("    expect: {expect},\n"));
+            code.push_str("  },\n");
+        }
+
+        code.push_str("})\n");
+        files.push((PathBuf::from(format!("{stem}_test.jsonnet")), code));
+
+        // Inputs `validate()` must reject
+        if has_validate {
+            for (name, property) in &properties {
+                let name = match name.as_str() {
+                    Some(name) => name,
+                    None => continue,
+                };
+                let required = params.iter().any(|(n, _, _)| *n == name);
+                let invalid = if required {
+                    Some(format!(
+                        "{{ [k]: sample[k] for k in std.objectFields(sample) if k != {} }}",
+                        quote_string(name)
+                    ))
+                } else {
+                    invalid_value(property)
+                        .map(|value| format!("sample + {{ {}: {value} }}", field_key(name)))
+                };
+                let Some(invalid) = invalid else {
+                    continue;
+                };
+
+                let mut code = String::new();
+                code.push_str(&format!(
+                    "// Generated from Go AST: {} tests\n",
+                    schema.name
+                ));
+                code.push_str(&format!("// Must fail validation of {name}\n"));
+                code.push_str(&format!(
+                    "local lib = import {};\n\n",
+                    quote_string(&format!("../../../{file_name}"))
+                ));
+                code.push_str(&format!("local sample = lib.new({});\n\n", args.join(", ")));
+                code.push_str(&format!("lib.validate({invalid})\n"));
+
+                let file: String = name
+                    .chars()
+                    .map(|c| if c.is_alphanumeric() { c } else { '_' })
+                    .collect();
+                files.push((
+                    Path::new("invalid")
+                        .join(stem)
+                        .join(format!("{file}.jsonnet")),
+                    code,
+                ));
+            }
+        }
+
+        files
+    }
+
+    /// Add tombstones for functions removed since the previous output
+    ///
+    /// `code` is the freshly generated library and `previous` the file it
+    /// replaces. A removed function is kept as one that raises an error naming
+    /// the release that removed it and, when a field is marked
+    /// `+gensonnet:replaces`, the setter to use instead. Tombstones from the
+    /// previous output are carried over while the release is unchanged.
+    pub fn add_tombstones(
+        &self,
+        schema: &ExtractedSchema,
+        code: &str,
+        previous: &str,
+        release: Option<&str>,
+    ) -> String {
+        let current = function_surface(&tokenize(code));
+        let previous_tombstones = parse_tombstones(previous);
+
+        let mut blocks = Vec::new();
+        for (name, tombstone_release, block) in &previous_tombstones {
+            if tombstone_release.as_deref() == release && !current.contains_key(name) {
+                blocks.push(block.clone());
+            }
+        }
+
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+        let names = HelperNames::of(&schema.content);
+        for (name, params) in function_surface(&tokenize(previous)) {
+            if current.contains_key(&name) || previous_tombstones.iter().any(|(n, _, _)| *n == name)
+            {
+                continue;
+            }
+
+            let replacement = properties.iter().find_map(|(field, property)| {
+                let replaces = property.get("x-go-replaces")?.as_sequence()?;
+                replaces
+                    .iter()
+                    .filter_map(|r| r.as_str())
+                    .any(|r| names.setter(r) == name)
+                    .then(|| names.setter(field.as_str().unwrap_or_default()))
+            });
+
+            let mut message = match release {
+                Some(release) => format!("{name} was removed in {release}"),
+                None => format!("{name} was removed"),
+            };
+            let comment = match release {
+                Some(release) => format!("{TOMBSTONE_COMMENT} for {release}: {name} was removed"),
+                None => format!("{TOMBSTONE_COMMENT}: {name} was removed"),
+            };
+            if let Some(replacement) = replacement {
+                message.push_str(&format!("; use {replacement}"));
+            }
+            blocks.push(format!(
+                "{comment}\n  {name}({params}):: error {},\n",
+                quote_string(&message)
+            ));
+        }
+
+        if blocks.is_empty() {
+            return code.to_string();
+        }
+
+        // Tombstones go last, before the closing brace of the library
+        let body = code.trim_end().strip_suffix('}').unwrap_or(code);
+        let mut result = body.to_string();
+        for block in blocks {
+            result.push('\n');
+            result.push_str(&block);
+        }
+        result.push_str("}\n");
+        result
+    }
+
+    /// Generate the `index.libsonnet` listing every generated type
+    ///
+    /// Types are grouped by Go package and category, and each entry imports
+    /// its library as `lib`. `manifest(obj)` checks the Kubernetes resources
+    /// of a value with the `assertValid()` of their libraries.
+    pub fn generate_index(&self, schemas: &[ExtractedSchema]) -> String {
+        let mut code = String::new();
+        code.push_str("// Generated from Go AST: index\n");
+        code.push_str("{\n");
+        code.push_str("  packages: {\n");
+
+        for (package, index_package) in self.index_packages(schemas) {
+            code.push_str(&format!("    {}: {{\n", field_key(&package)));
+            code.push_str(&format!(
+                "      importPath: {},\n",
+                quote_string(&index_package.import_path)
+            ));
+            code.push_str("      categories: {\n");
+            for (category, entries) in &index_package.categories {
+                code.push_str(&format!("        {}: {{\n", field_key(category)));
+                for (name, entry) in entries {
+                    code.push_str(&format!("          {}: {{\n", field_key(name)));
+                    code.push_str(&format!(
+                        "            description: {},\n",
+                        quote_string(&entry.description)
+                    ));
+                    code.push_str(&format!(
+                        "            importPath: {},\n",
+                        quote_string(&entry.import_path)
+                    ));
+                    code.push_str(&format!(
+                        "            file: {},\n",
+                        quote_string(&entry.file)
+                    ));
+                    code.push_str(&format!(
+                        "            lib:: import {},\n",
+                        quote_string(&format!("./{}", entry.file))
+                    ));
+                    code.push_str("          },\n");
+                }
+                code.push_str("        },\n");
+            }
+            code.push_str("      },\n");
+            code.push_str("    },\n");
+        }
+
+        code.push_str("  },\n");
+        code.push_str(&self.manifest_function(schemas));
+        code.push_str("}\n");
+        code
+    }
+
+    /// Render the `manifest(obj)` of the index, with the libraries of the
+    /// Kubernetes resources by apiVersion and kind
+    ///
+    /// Arrays and the fields of other objects, such as the `items` of a
+    /// `List` or the objects of a Tanka environment, are searched for
+    /// resources; values of other types are returned as they are.
+    fn manifest_function(&self, schemas: &[ExtractedSchema]) -> String {
+        let mut resources: BTreeMap<&str, BTreeMap<&str, (String, String)>> = BTreeMap::new();
+        for schema in schemas {
+            let Some(resource) = schema.content.get("x-go-kubernetes") else {
+                continue;
+            };
+            let (Some(api_version), Some(kind)) = (
+                resource.get("apiVersion").and_then(|v| v.as_str()),
+                resource.get("kind").and_then(|k| k.as_str()),
+            ) else {
+                continue;
+            };
+            resources.entry(api_version).or_default().insert(
+                kind,
+                (
+                    self.file_name(schema),
+                    HelperNames::of(&schema.content).function("assertValid"),
+                ),
+            );
+        }
+
+        let mut code =
+            String::from("\n  // Libraries of the Kubernetes resources, by apiVersion and kind\n");
+        code.push_str("  resources:: {\n");
+        for (api_version, kinds) in &resources {
+            code.push_str(&format!("    {}: {{\n", quote_string(api_version)));
+            for (kind, (file, assert_valid)) in kinds {
+                code.push_str(&format!(
+                    "      {}: {{ lib:: import {}, assertValid:: self.lib.{assert_valid} }},\n",
+                    field_key(kind),
+                    quote_string(&format!("./{file}"))
+                ));
+            }
+            code.push_str("    },\n");
+        }
+        code.push_str("  },\n\n");
+
+        code.push_str(
+            "  // Fail unless every Kubernetes resource of obj is valid, and return obj\n",
+        );
+        code.push_str("  manifest(obj)::\n");
+        code.push_str("    if std.isArray(obj) then std.map(self.manifest, obj)\n");
+        code.push_str("    else if !std.isObject(obj) then obj\n");
+        code.push_str("    else\n");
+        code.push_str(
+            "      local kinds = std.get(self.resources, std.toString(std.get(obj, 'apiVersion')), {});\n",
+        );
+        code.push_str(
+            "      local resource = std.get(kinds, std.toString(std.get(obj, 'kind')));\n",
+        );
+        code.push_str("      if resource != null then resource.assertValid(obj)\n");
+        code.push_str("      else std.mapWithKey(function(_, value) self.manifest(value), obj),\n");
+        code
+    }
+
+    /// Generate the `index.json` catalog, with the same layout as `index.libsonnet`
+    pub fn generate_index_json(&self, schemas: &[ExtractedSchema]) -> Result<String> {
+        let mut packages = serde_json::Map::new();
+
+        for (package, index_package) in self.index_packages(schemas) {
+            let mut categories = serde_json::Map::new();
+            for (category, entries) in index_package.categories {
+                let entries = entries
+                    .into_iter()
+                    .map(|(name, entry)| {
+                        let mut json = serde_json::Map::new();
+                        json.insert("description".into(), entry.description.into());
+                        json.insert("importPath".into(), entry.import_path.into());
+                        json.insert("file".into(), entry.file.into());
+                        json.extend(entry.api);
+                        (name, serde_json::Value::Object(json))
+                    })
+                    .collect();
+                categories.insert(category, serde_json::Value::Object(entries));
+            }
+
+            packages.insert(
+                package,
+                serde_json::json!({
+                    "importPath": index_package.import_path,
+                    "categories": categories,
+                }),
+            );
+        }
+
+        let index = serde_json::json!({ "packages": packages });
+        Ok(serde_json::to_string_pretty(&index)? + "\n")
+    }
+
+    /// Group schemas into index packages and categories
+    ///
+    /// The import path comes from the `import_path` metadata, falling back to
+    /// the package name when the module is unknown.
+    fn index_packages(&self, schemas: &[ExtractedSchema]) -> BTreeMap<String, IndexPackage> {
+        let mut packages: BTreeMap<String, IndexPackage> = BTreeMap::new();
+
+        for schema in schemas {
+            let metadata_str = |key: &str| {
+                schema
+                    .metadata
+                    .get(key)
+                    .and_then(|v| v.as_str())
+                    .filter(|v| !v.is_empty())
+                    .map(str::to_string)
+            };
+            let package = metadata_str("package").unwrap_or_else(|| "main".to_string());
+            let import_path = metadata_str("import_path").unwrap_or_else(|| package.clone());
+
+            let mut categories: Vec<String> = schema
+                .metadata
+                .get("categories")
+                .and_then(|v| v.as_sequence())
+                .map(|values| {
+                    values
+                        .iter()
+                        .filter_map(|v| v.as_str())
+                        .map(str::to_string)
+                        .collect()
+                })
+                .unwrap_or_default();
+            if categories.is_empty() {
+                categories.push(DEFAULT_CATEGORY.to_string());
+            }
+
+            let entry = IndexEntry {
+                description: metadata_str("summary").unwrap_or_default(),
+                import_path: import_path.clone(),
+                file: self.file_name(schema),
+                api: index_api(&schema.content),
+            };
+
+            let index_package = packages.entry(package).or_default();
+            index_package.import_path = import_path;
+            for category in categories {
+                index_package
+                    .categories
+                    .entry(category)
+                    .or_default()
+                    .insert(schema.name.clone(), entry.clone());
+            }
+        }
+
+        packages
+    }
+
+    /// Generate the conversions between the API versions of Kubernetes kinds
+    ///
+    /// Kinds declared in several versions of a group get a `fromToTo(obj)`
+    /// function per older and newer version, such as `v1alpha1ToV1`,
+    /// dispatching on the kind of the object. Fields with the same name and
+    /// shape in both versions are copied; the others are left as TODO
+    /// comments. Returns `None` when no kind has several versions.
+    pub fn generate_conversions(&self, schemas: &[ExtractedSchema]) -> Option<String> {
+        // Resources by group and kind, ordered by version
+        let mut kinds: BTreeMap<(String, String), Vec<(&str, &ExtractedSchema)>> = BTreeMap::new();
+        for schema in schemas {
+            let Some(resource) = schema.content.get("x-go-kubernetes") else {
+                continue;
+            };
+            let Some(api_version) = resource.get("apiVersion").and_then(|v| v.as_str()) else {
+                continue;
+            };
+            let (group, version) = api_version.rsplit_once('/').unwrap_or(("", api_version));
+            let kind = resource
+                .get("kind")
+                .and_then(|k| k.as_str())
+                .unwrap_or(&schema.name);
+            if version_order(version).is_some() {
+                kinds
+                    .entry((group.to_string(), kind.to_string()))
+                    .or_default()
+                    .push((api_version, schema));
+            }
+        }
+
+        // Conversions by older and newer version, then by kind
+        let mut conversions: BTreeMap<(&str, &str), BTreeMap<&str, String>> = BTreeMap::new();
+        for (_, mut versions) in kinds {
+            versions.sort_by_key(|(api_version, _)| version_order(short_version(api_version)));
+            versions.dedup_by_key(|(api_version, _)| *api_version);
+            for (idx, (from, source)) in versions.iter().enumerate() {
+                for (to, target) in &versions[idx + 1..] {
+                    conversions
+                        .entry((short_version(from), short_version(to)))
+                        .or_default()
+                        .entry(kind_name(target))
+                        .or_insert_with(|| conversion(source, target, from, to));
+                }
+            }
+        }
+        if conversions.is_empty() {
+            return None;
+        }
+
+        let mut code = String::new();
+        code.push_str("// Generated from Go AST: conversions between API versions\n");
+        code.push_str("//\n");
+        code.push_str("// Fields with the same name and type in both versions are copied;\n");
+        code.push_str("// the TODO comments mark the fields to convert by hand.\n");
+        code.push_str("{\n");
+        for ((from, to), kinds) in conversions {
+            let function = format!("{from}To{}{}", to[..1].to_uppercase(), &to[1..]);
+            code.push_str(&format!("  // Convert {from} objects to {to}\n"));
+            code.push_str(&format!("  {function}(obj)::\n"));
+            code.push_str("    local conversions = {\n");
+            for (kind, body) in kinds {
+                code.push_str(&format!("      {}(obj)::\n", field_key(kind)));
+                code.push_str(&body);
+            }
+            code.push_str("    };\n");
+            code.push_str(&format!(
+                "    assert std.objectHas(conversions, obj.kind) : \"no conversion of \" + obj.kind + \" from {from} to {to}\";\n"
+            ));
+            code.push_str("    conversions[obj.kind](obj),\n");
+        }
+        code.push_str("}\n");
+        Some(code)
+    }
+
+    /// Generate `defaults.libsonnet`, the defaults `new()` sets on the types
+    /// generated with `defaults_library`
+    ///
+    /// Each library reads the defaults of its type from its hidden
+    /// `defaults` field, so environments replace them without touching the
+    /// helpers: `user + { defaults:: prod.User }`.
+    pub fn generate_defaults(&self, schemas: &[ExtractedSchema]) -> Option<String> {
+        let mut layered: Vec<&ExtractedSchema> = schemas
+            .iter()
+            .filter(|schema| has_defaults_library(&schema.content))
+            .collect();
+        if layered.is_empty() {
+            return None;
+        }
+        layered.sort_by(|a, b| a.name.cmp(&b.name));
+
+        let mut code = String::new();
+        code.push_str("// Generated from Go AST: defaults of the fields set by new()\n");
+        code.push_str(&format!("// Generator: gensonnet {GENERATOR_VERSION}\n"));
+        code.push_str("{\n");
+        for schema in layered {
+            let properties = schema
+                .content
+                .get("properties")
+                .and_then(|p| p.as_mapping())
+                .cloned()
+                .unwrap_or_default();
+            code.push_str(&format!("  {}: {{\n", field_key(&schema.name)));
+            if schema.content.get("x-go-kubernetes").is_some() {
+                let spec = properties
+                    .get("spec")
+                    .and_then(|s| s.get("properties"))
+                    .and_then(|p| p.as_mapping())
+                    .cloned()
+                    .unwrap_or_default();
+                let defaults: Vec<(&str, &serde_yaml::Value)> = spec
+                    .iter()
+                    .filter(|(_, property)| property.get("default").is_some())
+                    .filter_map(|(name, property)| Some((name.as_str()?, property)))
+                    .collect();
+                if !defaults.is_empty() {
+                    code.push_str("    spec: {\n");
+                    for (name, property) in defaults {
+                        code.push_str(&doc_comment(property, "      "));
+                        code.push_str(&format!(
+                            "      {}: {},\n",
+                            field_key(name),
+                            yaml_to_jsonnet(&property["default"])
+                        ));
+                    }
+                    code.push_str("    },\n");
+                }
+            } else {
+                let params = constructor_params(&properties);
+                for (name, property) in &properties {
+                    let Some(name) = name.as_str() else {
+                        continue;
+                    };
+                    let Some(default) = property.get("default") else {
+                        continue;
+                    };
+                    if is_read_only(property) || params.iter().any(|(n, _, _)| *n == name) {
+                        continue;
+                    }
+                    let colon = if is_hidden(&schema.content, property) || omits_empty(property) {
+                        "::"
+                    } else {
+                        ":"
+                    };
+                    code.push_str(&doc_comment(property, "    "));
+                    code.push_str(&format!(
+                        "    {}{colon} {},\n",
+                        field_key(name),
+                        yaml_to_jsonnet(default)
+                    ));
+                }
+            }
+            code.push_str("  },\n");
+        }
+        code.push_str("}\n");
+        Some(code)
+    }
+
+    /// Generate the Jsonnet library for a single schema
+    pub fn generate(&self, schema: &ExtractedSchema) -> Result<String> {
+        let mut code = String::new();
+
+        code.push_str(&format!("// Generated from Go AST: {}\n", schema.name));
+        match schema.metadata.get("line").and_then(|l| l.as_u64()) {
+            Some(line) => code.push_str(&format!(
+                "// Source: {}:{} (type {})\n",
+                schema.source_file.display(),
+                line,
+                schema.name
+            )),
+            None => code.push_str(&format!("// Source: {}\n", schema.source_file.display())),
+        }
+        code.push_str(&format!("// Generator: gensonnet {GENERATOR_VERSION}\n"));
+
+        if let Some(unresolved) = schema
+            .content
+            .get("x-go-embedded")
+            .and_then(|v| v.as_sequence())
+        {
+            let names: Vec<&str> = unresolved.iter().filter_map(|v| v.as_str()).collect();
+            code.push_str(&format!(
+                "// Note: fields of inline types {} are defined outside this file and are not included\n",
+                names.join(", ")
+            ));
+        }
+
+        if let Some(interface) = schema
+            .content
+            .get("x-go-marshaler")
+            .and_then(|v| v.as_str())
+        {
+            code.push_str(&format!(
+                "// Note: {} implements {} and is encoded as a scalar; its fields are not included\n",
+                schema.name, interface
+            ));
+        }
+
+        if let Some(union) = schema.content.get("x-go-union") {
+            return Ok(self.generate_union(schema, union, code));
+        }
+
+        if let Some(resource) = schema.content.get("x-go-kubernetes") {
+            return Ok(self.generate_kubernetes(schema, resource, code));
+        }
+
+        if let Some(enumeration) = schema.content.get("x-go-enum") {
+            return Ok(self.generate_enum(schema, enumeration, code));
+        }
+
+        code.push('\n');
+
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+
+        let mut helpers = String::new();
+        if properties
+            .values()
+            .any(|p| mixin_kind(p) == Some(MixinKind::Map))
+        {
+            helpers.push_str(deep_merge_helper(&schema.content));
+        }
+        helpers.push_str(&validation_helpers(&schema.content, properties.values()));
+        if !helpers.is_empty() {
+            code.push_str(&helpers);
+            code.push('\n');
+        }
+
+        code.push_str("{\n");
+
+        // Libraries of the types of other packages, resolved by `resolve_references`
+        let mut imports = BTreeMap::new();
+        for property in properties.values() {
+            collect_references(property, &mut imports);
+        }
+        if !imports.is_empty() {
+            code.push_str("  // Libraries of the types of other packages used by the fields\n");
+            code.push_str("  imports:: {\n");
+            for (type_name, library) in &imports {
+                code.push_str(&format!("    {}: {},\n", field_key(type_name), library));
+            }
+            code.push_str("  },\n\n");
+        }
+
+        // Constructor with field defaults; required fields are parameters
+        let params = constructor_params(&properties);
+        let param_list: Vec<&str> = params.iter().map(|(_, param, _)| param.as_str()).collect();
+        let assertions: Vec<String> = params
+            .iter()
+            .flat_map(|(name, param, property)| field_assertions(name, param, property))
+            .collect();
+
+        let layered = has_defaults_library(&schema.content);
+        if layered {
+            code.push_str(&defaults_field(schema));
+        }
+        let base = if layered {
+            "self + self.defaults"
+        } else {
+            "self"
+        };
+        let deprecation = Deprecation::of(&schema.content, &schema.content);
+        code.push_str(&format!("  // Create a new {}\n", schema.name));
+        if let Some(deprecation) = &deprecation {
+            code.push_str(&deprecation.docs("new", &[]));
+        }
+        let (open, close) = match &deprecation {
+            Some(deprecation) => deprecation.trace("new"),
+            None => (String::new(), String::new()),
+        };
+        let indent = if assertions.is_empty() {
+            code.push_str(&format!(
+                "  new({}):: {open}{base} + {{\n",
+                param_list.join(", ")
+            ));
+            "  "
+        } else {
+            code.push_str(&format!("  new({})::\n", param_list.join(", ")));
+            for assertion in assertions {
+                code.push_str(&format!("    {assertion};\n"));
+            }
+            code.push_str(&format!("    {open}{base} + {{\n"));
+            "    "
+        };
+        for (name, property) in &properties {
+            let name = match name.as_str() {
+                Some(name) => name,
+                None => continue,
+            };
+            // Defaults of `omitempty` fields are hidden until set
+            let colon = if is_hidden(&schema.content, property) || omits_empty(property) {
+                "::"
+            } else {
+                ":"
+            };
+            if is_read_only(property) {
+                continue;
+            }
+            if let Some((_, param, _)) = params.iter().find(|(n, _, _)| *n == name) {
+                code.push_str(&doc_comment(property, &format!("{indent}  ")));
+                code.push_str(&format!("{indent}  {}{colon} {param},\n", field_key(name)));
+            } else if let Some(default) = property.get("default").filter(|_| !layered) {
+                code.push_str(&doc_comment(property, &format!("{indent}  ")));
+                code.push_str(&format!(
+                    "{indent}  {}{colon} {},\n",
+                    field_key(name),
+                    yaml_to_jsonnet(default)
+                ));
+            }
+        }
+        code.push_str(&format!("{indent}}}{close},\n"));
+
+        // Check of every constraint, for objects not built with the setters
+        let mut checks: Vec<String> = properties
+            .iter()
+            .filter_map(|(name, property)| Some((name.as_str()?, property)))
+            .flat_map(|(name, property)| object_assertions(name, property))
+            .collect();
+        checks.extend(cross_field_assertions(&properties, "obj"));
+        let validated = !checks.is_empty();
+        if validated {
+            code.push_str(&format!(
+                "\n  // Check that a {} object satisfies its validation rules\n",
+                schema.name
+            ));
+            code.push_str("  validate(obj)::\n");
+            for check in checks {
+                code.push_str(&format!("    {check};\n"));
+            }
+            code.push_str("    obj,\n");
+        }
+
+        let names = HelperNames::of(&schema.content);
+        code.push_str(&assert_valid_function(&names, &schema.name, validated));
+
+        // Presence check for fields where unset and null differ
+        if properties.values().any(has_presence) {
+            code.push_str("\n  // Whether a field is set, including to an explicit null\n");
+            code.push_str(&format!(
+                "  {}(obj, field):: std.objectHas(obj, field),\n",
+                names.function("isSet")
+            ));
+        }
+
+        // Field setters
+        for (name, property) in &properties {
+            let name = match name.as_str() {
+                Some(name) => name,
+                None => continue,
+            };
+            if is_read_only(property) {
+                code.push_str(&format!(
+                    "\n  // {name} is read-only: it is set by the server and has no setter\n"
+                ));
+                code.push_str(&doc_comment(property, "  "));
+                if let Some(source) = field_source(schema, property) {
+                    code.push_str(&format!("  // Source: {source}\n"));
+                }
+                continue;
+            }
+            let param = param_name(name);
+            // `:::` makes a field visible again after `withoutX()` hid it, or
+            // after `new()` hid the default of an `omitempty` field; fields
+            // marked `gensonnet:hidden` stay hidden
+            let hidden = is_hidden(&schema.content, property);
+            let colon = if hidden {
+                "::"
+            } else if has_presence(property) || omits_empty(property) {
+                ":::"
+            } else {
+                ":"
+            };
+            let deprecation = Deprecation::of(property, &schema.content);
+            code.push_str(&format!("\n  // Set the {name} field\n"));
+            code.push_str(&doc_comment(property, "  "));
+            if let Some(source) = field_source(schema, property) {
+                code.push_str(&format!("  // Source: {source}\n"));
+            }
+            if let Some(deprecation) = &deprecation {
+                code.push_str(&deprecation.docs(&names.setter(name), &[(&param, property)]));
+            }
+            let traced = |function: &str, body: String| match &deprecation {
+                Some(deprecation) => {
+                    let (open, close) = deprecation.trace(function);
+                    format!("{open}{body}{close}")
+                }
+                None => body,
+            };
+            if let Some(excluded) = property.get("x-go-excluded").and_then(|e| e.as_str()) {
+                code.push_str(&format!(
+                    "  // {excluded} is excluded from generation; the value is passed through as is\n"
+                ));
+            }
+            if let Some(interface) = interface_ref(property) {
+                code.push_str(&format!(
+                    "  // {interface} is an interface; build values with {}.libsonnet\n",
+                    interface.to_lowercase()
+                ));
+            }
+            let mut references = BTreeMap::new();
+            collect_references(property, &mut references);
+            for type_name in references.keys() {
+                code.push_str(&format!(
+                    "  // {type_name} is declared in another package; build values with imports.{}\n",
+                    field_key(type_name)
+                ));
+            }
+            if let Some(accessors) = property.get("x-go-accessors").and_then(|a| a.as_sequence()) {
+                let methods: Vec<&str> = accessors.iter().filter_map(|m| m.as_str()).collect();
+                code.push_str(&format!(
+                    "  // Inferred from {}; confirm it is part of the wire format\n",
+                    methods.join("/")
+                ));
+            }
+
+            let assertions = field_assertions(name, &param, property);
+            // Zero values of `omitempty` fields stay out of the output
+            let fields = match empty_check(property, &param).filter(|_| !hidden) {
+                Some(check) => format!(
+                    "(if {check} then {{ {key}:: {param} }} else {{ {key}{colon} {param} }})",
+                    key = field_key(name)
+                ),
+                None => format!("{{ {}{colon} {param} }}", field_key(name)),
+            };
+            let body = traced(&names.setter(name), format!("self + {fields}"));
+            if assertions.is_empty() {
+                code.push_str(&format!("  {}({param}):: {body},\n", names.setter(name)));
+            } else {
+                code.push_str(&format!("  {}({param})::\n", names.setter(name)));
+                for assertion in assertions {
+                    code.push_str(&format!("    {assertion};\n"));
+                }
+                code.push_str(&format!("    {body},\n"));
+            }
+
+            // Mixins add to the current value instead of replacing it
+            match mixin_kind(property) {
+                Some(MixinKind::List) => {
+                    code.push_str(&format!("\n  // Append to the {name} field\n"));
+                    let mixin = names.variant(name, "Mixin");
+                    let body = traced(
+                        &mixin,
+                        format!("self + {{ {}+{colon} {param} }}", field_key(name)),
+                    );
+                    code.push_str(&format!("  {mixin}({param}):: {body},\n"));
+                }
+                Some(MixinKind::Map) => {
+                    let quoted = quote_string(name);
+                    code.push_str(&format!("\n  // Deep-merge into the {name} field\n"));
+                    let mixin = names.variant(name, "Mixin");
+                    let body = traced(
+                        &mixin,
+                        format!(
+                            "self + {{ {}{colon} if {quoted} in super then {} else {param} }}",
+                            field_key(name),
+                            deep_merge_call(&schema.content, name, name, &param)
+                        ),
+                    );
+                    code.push_str(&format!("  {mixin}({param}):: {body},\n"));
+                }
+                None => {}
+            }
+
+            if let Some((helper, body)) = env_setter(&schema.content, name, property, names, "  ") {
+                code.push_str(&format!(
+                    "\n  // Set the {name} field from the external variable named varName\n"
+                ));
+                code.push_str(&format!("  {helper}(varName)::{body},\n"));
+            }
+
+            if has_integer_keys(property) {
+                code.push_str(&format!(
+                    "\n  // Set one entry of the {name} field, writing an integer key in decimal as encoding/json does\n"
+                ));
+                let entry = names.variant(name, "Entry");
+                let body = traced(
+                    &entry,
+                    format!(
+                        "self + {{ {}+{colon} {{ [std.toString(key)]: value }} }}",
+                        field_key(name)
+                    ),
+                );
+                code.push_str(&format!("  {entry}(key, value)::\n"));
+                code.push_str(&format!(
+                    "    assert (std.isNumber(key) && std.floor(key) == key) || matchesPattern({}, key) : {};\n",
+                    quote_string(INTEGER_KEY_PATTERN),
+                    quote_string(&format!("{name} keys must be integers"))
+                ));
+                code.push_str(&format!("    {body},\n"));
+            }
+
+            if let Some(entry) = metadata_entry_setter(name, property, names) {
+                code.push_str(&format!("\n  // Set one entry of the {name} field\n"));
+                code.push_str(&format!("  {entry}(key, value)::\n"));
+                for assertion in metadata_entry_assertions(name) {
+                    code.push_str(&format!("    {assertion};\n"));
+                }
+                let body = traced(
+                    &entry,
+                    format!(
+                        "self + {{ {}+{colon} {{ [key]: value }} }}",
+                        field_key(name)
+                    ),
+                );
+                code.push_str(&format!("    {body},\n"));
+            }
+
+            if property.get("x-go-null-setter").and_then(|n| n.as_bool()) == Some(true) {
+                code.push_str(&format!(
+                    "\n  // Set the {name} field to an explicit null\n"
+                ));
+                let null = names.variant(name, "Null");
+                let null_colon = if omits_empty(property) { "::" } else { colon };
+                let body = traced(
+                    &null,
+                    format!("self + {{ {}{null_colon} null }}", field_key(name)),
+                );
+                code.push_str(&format!("  {null}():: {body},\n"));
+            }
+
+            if has_presence(property) {
+                code.push_str(&format!(
+                    "\n  // Leave the {name} field unset, removing any value\n"
+                ));
+                code.push_str(&format!(
+                    "  {}():: self + {{ {}:: null }},\n",
+                    names.prefixed("without", name),
+                    field_key(name)
+                ));
+            }
+
+            code.push_str(&render_unit_helpers(
+                name,
+                property,
+                &properties,
+                names,
+                "  ",
+            ));
+
+            if property.get("x-go-conditions").and_then(|c| c.as_bool()) == Some(true) {
+                code.push_str(&condition_helpers(name, names));
+            }
+
+            if let Some(placeholder) = property
+                .get("x-go-now-placeholder")
+                .and_then(|p| p.as_str())
+            {
+                code.push_str(&format!(
+                    "\n  // Set the {name} field to the current time when applied\n"
+                ));
+                code.push_str(&format!(
+                    "  {}():: self + {{ {}{colon} {} }},\n",
+                    names.variant(name, "Now"),
+                    field_key(name),
+                    quote_string(placeholder)
+                ));
+            }
+
+            // Old setters of renamed fields call the new ones
+            let renamed_from = property
+                .get("x-go-renamed-from")
+                .and_then(|r| r.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|r| r.as_str());
+            for old in renamed_from {
+                let old_param = param_name(old);
+                let mut suffixes = vec![""];
+                if mixin_kind(property).is_some() {
+                    suffixes.push("Mixin");
+                }
+                for suffix in suffixes {
+                    code.push_str(&format!(
+                        "\n{RENAMED_COMMENT} {old} was renamed to {name}\n"
+                    ));
+                    code.push_str(&format!(
+                        "  {}({old_param}):: self.{}({old_param}),\n",
+                        names.variant(old, suffix),
+                        names.variant(name, suffix)
+                    ));
+                }
+            }
+        }
+
+        code.push_str("}\n");
+
+        Ok(code)
+    }
+
+    /// Generate a k8s-libsonnet style library for a Kubernetes resource
+    ///
+    /// `new(name)` sets the kind, API version and name; the `metadata` and
+    /// `spec` helpers return mixins to add to it with `+`. The status is
+    /// written by controllers and gets no helpers.
+    fn generate_kubernetes(
+        &self,
+        schema: &ExtractedSchema,
+        resource: &serde_yaml::Value,
+        mut code: String,
+    ) -> String {
+        let properties = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+        let spec = properties
+            .get("spec")
+            .and_then(|s| s.get("properties"))
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default();
+        let kind = resource
+            .get("kind")
+            .and_then(|k| k.as_str())
+            .unwrap_or(&schema.name);
+        let api_version = resource.get("apiVersion").and_then(|v| v.as_str());
+        let names = HelperNames::of(&schema.content);
+
+        if api_version.is_none() {
+            code.push_str(&format!(
+                "// Note: the API group of {kind} is unknown; set apiVersion with {}()\n",
+                names.setter("apiVersion")
+            ));
+        }
+        code.push('\n');
+        let mut helpers = String::new();
+        if spec.values().any(|p| mixin_kind(p) == Some(MixinKind::Map)) {
+            helpers.push_str(deep_merge_helper(&schema.content));
+        }
+        // The keys of labels and annotations are checked by their setters
+        let metadata_keys: serde_yaml::Value = serde_yaml::Value::Mapping(
+            [("format".into(), QUALIFIED_NAME_FORMAT.into())]
+                .into_iter()
+                .collect(),
+        );
+        helpers.push_str(&validation_helpers(
+            &schema.content,
+            spec.values().chain([&metadata_keys]),
+        ));
+        if !helpers.is_empty() {
+            code.push_str(&helpers);
+            code.push('\n');
+        }
+        code.push_str("{\n");
+
+        let layered = has_defaults_library(&schema.content);
+        if layered {
+            code.push_str(&defaults_field(schema));
+        }
+        let deprecation = Deprecation::of(&schema.content, &schema.content);
+        code.push_str(&format!("  // Create a new {kind} named name\n"));
+        if let Some(deprecation) = &deprecation {
+            code.push_str(&deprecation.docs("new", &[]));
+        }
+        let (open, close) = match &deprecation {
+            Some(deprecation) => deprecation.trace("new"),
+            None => (String::new(), String::new()),
+        };
+        code.push_str(&format!("  new(name):: {open}{{\n"));
+        if let Some(api_version) = api_version {
+            code.push_str(&format!("    apiVersion: {},\n", quote_string(api_version)));
+        }
+        code.push_str(&format!("    kind: {},\n", quote_string(kind)));
+        code.push_str("    metadata: { name: name },\n");
+        let defaults: Vec<String> = spec
+            .iter()
+            .filter_map(|(name, property)| {
+                Some(format!(
+                    "{}: {}",
+                    field_key(name.as_str()?),
+                    yaml_to_jsonnet(property.get("default")?)
+                ))
+            })
+            .collect();
+        if layered {
+            code.push_str(&format!("  }} + self.defaults{close},\n"));
+        } else {
+            if !defaults.is_empty() {
+                code.push_str(&format!("    spec: {{ {} }},\n", defaults.join(", ")));
+            }
+            code.push_str(&format!("  }}{close},\n"));
+        }
+
+        code.push_str("\n  // Helpers for the metadata field\n");
+        code.push_str("  metadata:: {\n");
+        for (idx, (field, mixin)) in METADATA_HELPERS.iter().enumerate() {
+            if idx > 0 {
+                code.push('\n');
+            }
+            code.push_str(&format!("    // Set the metadata.{field} field\n"));
+            code.push_str(&format!(
+                "    {}({field}):: {{ metadata+: {{ {field}: {field} }} }},\n",
+                names.setter(field)
+            ));
+            if *mixin {
+                code.push_str(&format!("\n    // Merge into the metadata.{field} field\n"));
+                code.push_str(&format!(
+                    "    {}({field}):: {{ metadata+: {{ {field}+: {field} }} }},\n",
+                    names.variant(field, "Mixin")
+                ));
+                code.push_str(&format!(
+                    "\n    // Set one entry of the metadata.{field} field\n"
+                ));
+                code.push_str(&format!(
+                    "    {}(key, value)::\n",
+                    entry_setter(field, names)
+                ));
+                for assertion in metadata_entry_assertions(field) {
+                    code.push_str(&format!("      {assertion};\n"));
+                }
+                code.push_str(&format!(
+                    "      {{ metadata+: {{ {field}+: {{ [key]: value }} }} }},\n"
+                ));
+            }
+        }
+        code.push_str("  },\n");
+
+        code.push_str("\n  // Helpers for the spec field\n");
+        code.push_str("  spec:: {\n");
+        let mut first = true;
+        for (name, property) in &spec {
+            let name = match name.as_str() {
+                Some(name) => name,
+                None => continue,
+            };
+            if !first {
+                code.push('\n');
+            }
+            first = false;
+
+            if is_read_only(property) {
+                code.push_str(&format!(
+                    "    // spec.{name} is read-only: it is set by the server and has no setter\n"
+                ));
+                code.push_str(&doc_comment(property, "    "));
+                continue;
+            }
+            let param = param_name(name);
+            let key = field_key(name);
+            let deprecation = Deprecation::of(property, &schema.content);
+            code.push_str(&format!("    // Set the spec.{name} field\n"));
+            code.push_str(&doc_comment(property, "    "));
+            if let Some(source) = field_source(schema, property) {
+                code.push_str(&format!("    // Source: {source}\n"));
+            }
+            if let Some(deprecation) = &deprecation {
+                let docs = deprecation.docs(&names.setter(name), &[(&param, property)]);
+                for line in docs.lines() {
+                    code.push_str(&format!("  {line}\n"));
+                }
+            }
+            let traced = |function: &str, body: String| match &deprecation {
+                Some(deprecation) => {
+                    let (open, close) = deprecation.trace(function);
+                    format!("{open}{body}{close}")
+                }
+                None => body,
+            };
+
+            let assertions = field_assertions(name, &param, property);
+            let body = traced(
+                &names.setter(name),
+                format!("{{ spec+: {{ {key}: {param} }} }}"),
+            );
+            if assertions.is_empty() {
+                code.push_str(&format!("    {}({param}):: {body},\n", names.setter(name)));
+            } else {
+                code.push_str(&format!("    {}({param})::\n", names.setter(name)));
+                for assertion in assertions {
+                    code.push_str(&format!("      {assertion};\n"));
+                }
+                code.push_str(&format!("      {body},\n"));
+            }
+
+            let mixin = names.variant(name, "Mixin");
+            match mixin_kind(property) {
+                Some(MixinKind::List) => {
+                    code.push_str(&format!("\n    // Append to the spec.{name} field\n"));
+                    let body = traced(&mixin, format!("{{ spec+: {{ {key}+: {param} }} }}"));
+                    code.push_str(&format!("    {mixin}({param}):: {body},\n"));
+                }
+                Some(MixinKind::Map) => {
+                    let quoted = quote_string(name);
+                    code.push_str(&format!("\n    // Deep-merge into the spec.{name} field\n"));
+                    let body = traced(
+                        &mixin,
+                        format!(
+                            "{{ spec+: {{ {key}: if {quoted} in super then {} else {param} }} }}",
+                            deep_merge_call(&schema.content, name, &format!("spec.{name}"), &param)
+                        ),
+                    );
+                    code.push_str(&format!("    {mixin}({param}):: {body},\n"));
+                }
+                None => {}
+            }
+
+            if let Some((helper, body)) = env_setter(&schema.content, name, property, names, "    ")
+            {
+                code.push_str(&format!(
+                    "\n    // Set the spec.{name} field from the external variable named varName\n"
+                ));
+                code.push_str(&format!("    {helper}(varName)::{body},\n"));
+            }
+
+            if let Some(entry) = metadata_entry_setter(name, property, names) {
+                code.push_str(&format!(
+                    "\n    // Set one entry of the spec.{name} field\n"
+                ));
+                code.push_str(&format!("    {entry}(key, value)::\n"));
+                for assertion in metadata_entry_assertions(name) {
+                    code.push_str(&format!("      {assertion};\n"));
+                }
+                let body = traced(
+                    &entry,
+                    format!("{{ spec+: {{ {key}+: {{ [key]: value }} }} }}"),
+                );
+                code.push_str(&format!("      {body},\n"));
+            }
+
+            code.push_str(&render_unit_helpers(name, property, &spec, names, "    "));
+        }
+
+        // Checks of the spec, for objects not built with the setters
+        let mut checks: Vec<String> = spec
+            .iter()
+            .filter_map(|(name, property)| Some((name.as_str()?, property)))
+            .flat_map(|(name, property)| object_assertions(name, property))
+            .collect();
+        checks.extend(cross_field_assertions(&spec, "obj"));
+        if !checks.is_empty() {
+            if !first {
+                code.push('\n');
+            }
+            code.push_str(&format!(
+                "    // Check that the spec of a {kind} satisfies its validation rules\n"
+            ));
+            code.push_str("    validate(obj)::\n");
+            for check in &checks {
+                code.push_str(&format!("      {check};\n"));
+            }
+            code.push_str("      obj,\n");
+        }
+        code.push_str("  },\n");
+
+        // Fields next to metadata and spec are set directly
+        for (name, property) in &properties {
+            let name = match name.as_str() {
+                Some("metadata" | "spec" | "status" | "kind") | None => continue,
+                Some("apiVersion") if api_version.is_some() => continue,
+                Some(name) => name,
+            };
+            if is_read_only(property) {
+                code.push_str(&format!(
+                    "\n  // {name} is read-only: it is set by the server and has no setter\n"
+                ));
+                code.push_str(&doc_comment(property, "  "));
+                continue;
+            }
+            let param = param_name(name);
+            code.push_str(&format!("\n  // Set the {name} field\n"));
+            code.push_str(&doc_comment(property, "  "));
+            if let Some(source) = field_source(schema, property) {
+                code.push_str(&format!("  // Source: {source}\n"));
+            }
+            code.push_str(&format!(
+                "  {}({param}):: {{ {}: {param} }},\n",
+                names.setter(name),
+                field_key(name)
+            ));
+        }
+
+        if properties.contains_key("status") {
+            code.push_str("\n  // status is written by the controller and has no helpers\n");
+        }
+
+        if !checks.is_empty() {
+            code.push_str(&format!(
+                "\n  // Check that a {kind} object satisfies its validation rules\n"
+            ));
+            code.push_str("  validate(obj)::\n");
+            code.push_str(
+                "    assert std.isObject(self.spec.validate(std.get(obj, \"spec\", {})));\n",
+            );
+            code.push_str("    obj,\n");
+        }
+        code.push_str(&assert_valid_function(&names, kind, !checks.is_empty()));
+
+        code.push_str("}\n");
+
+        code
+    }
+
+    /// Generate the library of an integer enum from its constants
+    ///
+    /// The values are hidden fields named after the constants, so the library
+    /// manifests as `{}`. Flag sets accept any OR of their flags. Enums named
+    /// by their `String()` method have the names as values, with tables to
+    /// convert from and to the integers.
+    fn generate_enum(
+        &self,
+        schema: &ExtractedSchema,
+        enumeration: &serde_yaml::Value,
+        mut code: String,
+    ) -> String {
+        let flags = enumeration
+            .get("flags")
+            .and_then(|f| f.as_bool())
+            .unwrap_or_default();
+        let values: Vec<EnumValue> = enumeration
+            .get("values")
+            .cloned()
+            .and_then(|values| serde_yaml::from_value(values).ok())
+            .unwrap_or_default();
+
+        // Enums of other frontends are only names, without integers
+        let names = enumeration
+            .get("names")
+            .and_then(|n| n.as_bool())
+            .unwrap_or_default();
+
+        let strings: Option<Vec<&str>> = values.iter().map(|v| v.string.as_deref()).collect();
+        if let Some(strings) = strings.filter(|_| !flags) {
+            return generate_string_enum(schema, &values, &strings, names, code);
+        }
+
+        code.push_str(&format!(
+            "// Note: {} is an integer {} of the constants of its iota block\n\n",
+            schema.name,
+            if flags { "set of flags" } else { "enum" }
+        ));
+        code.push_str("{\n");
+        for value in &values {
+            code.push_str(&format!(
+                "  {}:: {},\n",
+                field_key(&value_key(&schema.name, &value.name)),
+                value.value
+            ));
+        }
+
+        let numbers: Vec<String> = values.iter().map(|v| v.value.to_string()).collect();
+        code.push_str(&format!(
+            "\n  // Values of {}, in declaration order\n",
+            schema.name
+        ));
+        code.push_str(&format!("  values:: [{}],\n", numbers.join(", ")));
+
+        if flags {
+            let all = values.iter().fold(0, |all, v| all | v.value);
+            code.push_str("\n  // All flags set\n");
+            code.push_str(&format!("  all:: {all},\n"));
+            code.push_str("\n  // Whether a value only sets known flags\n");
+            code.push_str(
+                "  isValid(value):: std.isNumber(value) && value >= 0 && std.floor(value) == value && (value | self.all) == self.all,\n",
+            );
+            code.push_str("\n  // Combine flags into one value\n");
+            code.push_str(
+                "  combine(flags):: std.foldl(function(acc, flag) acc | flag, flags, 0),\n",
+            );
+        } else {
+            code.push_str(&format!(
+                "\n  // Whether a value is one of the values of {}\n",
+                schema.name
+            ));
+            code.push_str("  isValid(value):: std.member(self.values, value),\n");
+        }
+        code.push_str("}\n");
+        code
+    }
+
+    /// Generate the library of an interface from its implementations
+    ///
+    /// Each implementation gets a `newX()` constructor and a `fromX()` helper
+    /// tagging an existing value with the discriminator, and `validate()`
+    /// dispatches to the library named by the discriminator of a value.
+    fn generate_union(
+        &self,
+        schema: &ExtractedSchema,
+        union: &serde_yaml::Value,
+        mut code: String,
+    ) -> String {
+        let discriminator = union
+            .get("discriminator")
+            .and_then(|d| d.as_str())
+            .unwrap_or(DEFAULT_DISCRIMINATOR);
+        let variants: Vec<&serde_yaml::Value> = union
+            .get("variants")
+            .and_then(|v| v.as_sequence())
+            .into_iter()
+            .flatten()
+            .collect();
+        let str_of = |variant: &serde_yaml::Value, key: &str| {
+            variant
+                .get(key)
+                .and_then(|v| v.as_str())
+                .unwrap_or_default()
+                .to_string()
+        };
+        let names = HelperNames::of(&schema.content);
+        let key = field_key(discriminator);
+        let quoted = quote_string(discriminator);
+
+        let members = if schema.content.get("x-go-interface").is_some() {
+            code.push_str(&format!(
+                "// Note: {} is an interface; values are one of its implementations, told apart by the {} field\n\n",
+                schema.name, discriminator
+            ));
+            "implementations"
+        } else {
+            code.push_str(&format!(
+                "// Note: {} is a union declared in the configuration; values are one of its variants, told apart by the {} field\n\n",
+                schema.name, discriminator
+            ));
+            "variants"
+        };
+        code.push_str("{\n");
+        code.push_str(&format!(
+            "  // Libraries of the {members}, by discriminator value\n"
+        ));
+        code.push_str("  variants:: {\n");
+        for variant in &variants {
+            code.push_str(&format!(
+                "    {}: import {},\n",
+                field_key(&str_of(variant, "value")),
+                quote_string(&str_of(variant, "file"))
+            ));
+        }
+        code.push_str("  },\n");
+
+        for variant in &variants {
+            let name = str_of(variant, "name");
+            let value = quote_string(&str_of(variant, "value"));
+            let params: Vec<&str> = variant
+                .get("params")
+                .and_then(|p| p.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|p| p.as_str())
+                .collect();
+            let params = params.join(", ");
+
+            code.push_str(&format!(
+                "\n  // Create a {} implemented by {name}\n",
+                schema.name
+            ));
+            code.push_str(&format!(
+                "  {}({params}):: self.variants[{value}].new({params}) + {{ {key}: {value} }},\n",
+                names.prefixed("new", &name)
+            ));
+            code.push_str(&format!(
+                "\n  // Tag an existing {name} value as a {}\n",
+                schema.name
+            ));
+            code.push_str(&format!(
+                "  {}(value):: value + {{ {key}: {value} }},\n",
+                names.prefixed("from", &name)
+            ));
+        }
+
+        code.push_str(&format!(
+            "\n  // Get the library of a {} value from its {discriminator} field\n",
+            schema.name
+        ));
+        code.push_str(&format!("  {}(obj)::\n", names.function("variantOf")));
+        code.push_str(&format!(
+            "    assert std.isObject(obj) && std.objectHas(obj, {quoted}) : {};\n",
+            quote_string(&format!(
+                "{} values must have a {discriminator} field",
+                schema.name
+            ))
+        ));
+        code.push_str(&format!(
+            "    assert std.objectHas(self.variants, obj[{quoted}]) : {} + obj[{quoted}];\n",
+            quote_string(&format!("Unknown {} {discriminator}: ", schema.name))
+        ));
+        code.push_str(&format!("    self.variants[obj[{quoted}]],\n"));
+
+        code.push_str(&format!(
+            "\n  // Fields of the {members}, by discriminator value\n"
+        ));
+        code.push_str("  fields:: {\n");
+        for variant in &variants {
+            let fields: Vec<String> = variant
+                .get("fields")
+                .and_then(|f| f.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|f| f.as_str())
+                .map(quote_string)
+                .collect();
+            code.push_str(&format!(
+                "    {}: [{}],\n",
+                field_key(&str_of(variant, "value")),
+                fields.join(", ")
+            ));
+        }
+        code.push_str("  },\n");
+
+        code.push_str(&format!(
+            "\n  // Check that a {} value is exactly one of the {members}: its {discriminator} names one, and it sets no field of the others only\n",
+            schema.name
+        ));
+        code.push_str(&format!("  {}(obj)::\n", names.function("oneOf")));
+        code.push_str(&format!(
+            "    assert std.isObject(self.{}(obj));\n",
+            names.function("variantOf")
+        ));
+        code.push_str(&format!("    local own = self.fields[obj[{quoted}]];\n"));
+        code.push_str(&format!(
+            "    local others = std.set(std.flattenArrays([self.fields[v] for v in std.objectFields(self.fields) if v != obj[{quoted}]]));\n"
+        ));
+        code.push_str(
+            "    local mixed = [f for f in std.objectFields(obj) if std.setMember(f, others) && !std.member(own, f)];\n",
+        );
+        code.push_str(&format!(
+            "    assert mixed == [] : {} + obj[{quoted}] + ': ' + std.join(', ', mixed);\n",
+            quote_string(&format!(
+                "{} values set fields of other {members} than ",
+                schema.name
+            ))
+        ));
+        code.push_str("    obj,\n");
+
+        code.push_str(&format!(
+            "\n  // Check a {} value against the rules of its implementation\n",
+            schema.name
+        ));
+        code.push_str("  validate(obj)::\n");
+        code.push_str(&format!(
+            "    local variant = self.{}(self.{}(obj));\n",
+            names.function("variantOf"),
+            names.function("oneOf")
+        ));
+        code.push_str(
+            "    if std.objectHasAll(variant, 'validate') then variant.validate(obj) else obj,\n",
+        );
+        code.push_str(&assert_valid_function(&names, &schema.name, true));
+        code.push_str("}\n");
+
+        code
+    }
+}
+
+/// Find the setters kept for renamed fields in generated output
+///
+/// Returns (old setter, new setter) pairs.
+pub fn renamed_setters(code: &str) -> Vec<(String, String)> {
+    let lines: Vec<&str> = code.lines().collect();
+
+    lines
+        .windows(2)
+        .filter(|pair| pair[0].starts_with(RENAMED_COMMENT))
+        .filter_map(|pair| {
+            let (old, rest) = pair[1].trim().split_once('(')?;
+            let (_, target) = rest.split_once(":: self.")?;
+            let (new, _) = target.split_once('(')?;
+            Some((old.to_string(), new.to_string()))
+        })
+        .collect()
+}
+
+/// Find the functions kept as tombstones in generated output
+pub fn tombstone_names(code: &str) -> Vec<String> {
+    parse_tombstones(code)
+        .into_iter()
+        .map(|(name, _, _)| name)
+        .collect()
+}
+
+/// Find the tombstones in generated output with the function replacing them
+pub fn tombstone_replacements(code: &str) -> Vec<(String, Option<String>)> {
+    parse_tombstones(code)
+        .into_iter()
+        .map(|(name, _, block)| {
+            let replacement = block.split_once("; use ").and_then(|(_, rest)| {
+                let replacement: String = rest
+                    .chars()
+                    .take_while(|c| c.is_alphanumeric() || *c == '_')
+                    .collect();
+                (!replacement.is_empty()).then_some(replacement)
+            });
+            (name, replacement)
+        })
+        .collect()
+}
+
+/// Find the tombstones in generated output as (function, release, source)
+fn parse_tombstones(code: &str) -> Vec<(String, Option<String>, String)> {
+    let lines: Vec<&str> = code.lines().collect();
+    let mut tombstones = Vec::new();
+
+    for (idx, line) in lines.iter().enumerate() {
+        let rest = match line.strip_prefix(TOMBSTONE_COMMENT) {
+            Some(rest) => rest,
+            None => continue,
+        };
+        let release = rest
+            .strip_prefix(" for ")
+            .and_then(|r| r.split_once(':'))
+            .map(|(release, _)| release.to_string());
+
+        let definition = match lines.get(idx + 1) {
+            Some(definition) => definition,
+            None => continue,
+        };
+        if let Some(name) = function_surface(&tokenize(definition)).into_keys().next() {
+            tombstones.push((name, release, format!("{line}\n{definition}\n")));
+        }
+    }
+
+    tombstones
+}
+
+/// Get the order of a Kubernetes API version: alpha, then beta, then GA
+/// versions of each major version
+fn version_order(version: &str) -> Option<(u64, u8, u64)> {
+    let rest = version.strip_prefix('v')?;
+    let digits = rest.len() - rest.trim_start_matches(|c: char| c.is_ascii_digit()).len();
+    let major = rest[..digits].parse().ok()?;
+    let rest = &rest[digits..];
+    if rest.is_empty() {
+        return Some((major, 2, 0));
+    }
+    let (stability, minor) = match rest.strip_prefix("alpha") {
+        Some(minor) => (0, minor),
+        None => (1, rest.strip_prefix("beta")?),
+    };
+    Some((major, stability, minor.parse().ok()?))
+}
+
+/// Get the version part of an API version
+fn short_version(api_version: &str) -> &str {
+    api_version
+        .rsplit_once('/')
+        .map_or(api_version, |(_, version)| version)
+}
+
+/// Get the kind of a Kubernetes resource
+fn kind_name(schema: &ExtractedSchema) -> &str {
+    schema
+        .content
+        .get("x-go-kubernetes")
+        .and_then(|r| r.get("kind"))
+        .and_then(|k| k.as_str())
+        .unwrap_or(&schema.name)
+}
+
+/// Generate the body of the conversion of a resource between API versions
+///
+/// The status is left out, as controllers write it for the new version.
+fn conversion(source: &ExtractedSchema, target: &ExtractedSchema, from: &str, to: &str) -> String {
+    let properties = |schema: &ExtractedSchema| {
+        schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default()
+    };
+    let (source, target) = (properties(source), properties(target));
+    let spec = |properties: &serde_yaml::Mapping| {
+        properties
+            .get("spec")
+            .and_then(|s| s.get("properties"))
+            .and_then(|p| p.as_mapping())
+            .cloned()
+            .unwrap_or_default()
+    };
+
+    let mut code = String::new();
+    code.push_str("        local spec = std.get(obj, \"spec\", {});\n");
+    code.push_str("        {\n");
+    code.push_str(&format!("          apiVersion: {},\n", quote_string(to)));
+    code.push_str("          kind: obj.kind,\n");
+    code.push_str("          metadata: std.get(obj, \"metadata\", {}),\n");
+    let skipped = ["apiVersion", "kind", "metadata", "spec", "status"];
+    let top: serde_yaml::Mapping = source
+        .iter()
+        .filter(|(name, _)| !name.as_str().is_some_and(|name| skipped.contains(&name)))
+        .map(|(name, property)| (name.clone(), property.clone()))
+        .collect();
+    let target_top: serde_yaml::Mapping = target
+        .iter()
+        .filter(|(name, _)| !name.as_str().is_some_and(|name| skipped.contains(&name)))
+        .map(|(name, property)| (name.clone(), property.clone()))
+        .collect();
+    for line in field_conversions(&top, &target_top, "obj", from, to) {
+        code.push_str(&format!("          {line}\n"));
+    }
+    code.push_str("          spec: {\n");
+    for line in field_conversions(&spec(&source), &spec(&target), "spec", from, to) {
+        code.push_str(&format!("            {line}\n"));
+    }
+    code.push_str("          },\n");
+    code.push_str("        },\n");
+    code
+}
+
+/// Generate the fields of a conversion, copying those with the same shape
+fn field_conversions(
+    source: &serde_yaml::Mapping,
+    target: &serde_yaml::Mapping,
+    obj: &str,
+    from: &str,
+    to: &str,
+) -> Vec<String> {
+    let mut lines = Vec::new();
+    for (name, property) in target {
+        let Some(name) = name.as_str() else {
+            continue;
+        };
+        match source.get(name) {
+            Some(previous) if same_shape(previous, property) => lines.push(format!(
+                "[if std.objectHas({obj}, {key}) then {key}]: {obj}[{key}],",
+                key = quote_string(name)
+            )),
+            Some(previous) => lines.push(format!(
+                "// TODO: convert {name} ({} in {from}, {} in {to})",
+                shape(previous),
+                shape(property)
+            )),
+            None => lines.push(format!("// TODO: set {name}, which {from} does not have")),
+        }
+    }
+    for (name, _) in source {
+        if let Some(name) = name.as_str().filter(|name| target.get(*name).is_none()) {
+            lines.push(format!("// TODO: {name} has no counterpart in {to}"));
+        }
+    }
+    lines
+}
+
+/// Check whether two properties hold the same values
+///
+/// Only the types are compared; descriptions, defaults and validation rules
+/// may differ between versions.
+fn same_shape(a: &serde_yaml::Value, b: &serde_yaml::Value) -> bool {
+    ["type", "format"]
+        .iter()
+        .all(|key| a.get(key) == b.get(key))
+        && ["items", "additionalProperties"]
+            .iter()
+            .all(|key| match (a.get(key), b.get(key)) {
+                (Some(a), Some(b)) => same_shape(a, b),
+                (a, b) => a.is_none() && b.is_none(),
+            })
+}
+
+/// Get the type of a parameter holding a property, for `param_types`
+fn param_type(property: &serde_yaml::Value) -> String {
+    let schema_type = property
+        .get("type")
+        .and_then(|t| t.as_str())
+        .unwrap_or("any");
+    let element = match schema_type {
+        "array" => property.get("items"),
+        "object" => property
+            .get("additionalProperties")
+            .filter(|p| p.is_mapping()),
+        _ => None,
+    };
+    match (element, property.get("format").and_then(|f| f.as_str())) {
+        (Some(element), _) => format!("{schema_type}<{}>", param_type(element)),
+        (None, Some(format)) => format!("{schema_type}/{format}"),
+        (None, None) => schema_type.to_string(),
+    }
+}
+
+/// Describe a type for `index.json`: its kind, its fields with their
+/// constraints, and the values of an enum or the variants of a union
+fn index_api(content: &serde_yaml::Value) -> serde_json::Map<String, serde_json::Value> {
+    let to_json = |value: &serde_yaml::Value| serde_json::to_value(value).ok();
+    let kind = if content.get("x-go-union").is_some() {
+        "union"
+    } else if content.get("x-go-interface").is_some() {
+        "interface"
+    } else if content.get("x-go-enum").is_some() || content.get("enum").is_some() {
+        "enum"
+    } else if content.get("x-go-kubernetes").is_some() {
+        "resource"
+    } else if content.get("properties").is_some() {
+        "struct"
+    } else {
+        "alias"
+    };
+
+    let mut api = serde_json::Map::new();
+    api.insert("kind".into(), kind.into());
+    if let Some(values) = content.get("enum").and_then(to_json) {
+        api.insert("enum".into(), values);
+    }
+    if let Some(variants) = content
+        .get("x-go-union")
+        .and_then(|u| u.get("variants"))
+        .and_then(|v| v.as_sequence())
+    {
+        let names: Vec<serde_json::Value> = variants
+            .iter()
+            .filter_map(|variant| variant.get("name").and_then(to_json))
+            .collect();
+        api.insert("variants".into(), names.into());
+    }
+
+    let Some(properties) = content.get("properties").and_then(|p| p.as_mapping()) else {
+        return api;
+    };
+    let required: Vec<&str> = content
+        .get("required")
+        .and_then(|r| r.as_sequence())
+        .into_iter()
+        .flatten()
+        .filter_map(|name| name.as_str())
+        .collect();
+    let mut fields = serde_json::Map::new();
+    for (name, property) in properties {
+        let Some(name) = name.as_str() else {
+            continue;
+        };
+        let flag = |key: &str| property.get(key).and_then(|v| v.as_bool()) == Some(true);
+        let mut field = serde_json::Map::new();
+        field.insert("type".into(), param_type(property).into());
+        if let Some(reference) = type_ref(property) {
+            field.insert("ref".into(), reference.into());
+        }
+        if let Some(description) = property.get("description").and_then(|d| d.as_str()) {
+            field.insert("description".into(), description.into());
+        }
+        if required.contains(&name) || flag("x-go-constructor-param") {
+            field.insert("required".into(), true.into());
+        }
+        for (key, label) in [("nullable", "nullable"), ("readOnly", "readOnly")] {
+            if flag(key) {
+                field.insert(label.into(), true.into());
+            }
+        }
+        if let Some(default) = property.get("default").and_then(to_json) {
+            field.insert("default".into(), default);
+        }
+        if let Some(values) = property.get("enum").and_then(to_json) {
+            field.insert("enum".into(), values);
+        }
+        let constraints: serde_json::Map<String, serde_json::Value> = INDEX_CONSTRAINTS
+            .iter()
+            .filter_map(|key| Some((key.to_string(), to_json(property.get(*key)?)?)))
+            .collect();
+        if !constraints.is_empty() {
+            field.insert("constraints".into(), constraints.into());
+        }
+        if let Some(message) = property.get("x-go-deprecated").and_then(|d| d.as_str()) {
+            field.insert("deprecated".into(), message.into());
+        }
+        fields.insert(name.to_string(), field.into());
+    }
+    api.insert("fields".into(), fields.into());
+    api
+}
+
+/// Get the type a property references, through arrays and maps
+fn type_ref(property: &serde_yaml::Value) -> Option<&str> {
+    for key in [STRUCT_REF_KEY, "x-go-interface-ref"] {
+        if let Some(name) = property.get(key).and_then(|n| n.as_str()) {
+            return Some(name);
+        }
+    }
+    if let Some(name) = property
+        .get("x-go-ref")
+        .and_then(|r| r.get("type"))
+        .and_then(|t| t.as_str())
+    {
+        return Some(name);
+    }
+    ["items", "additionalProperties"]
+        .iter()
+        .filter_map(|key| property.get(key))
+        .find_map(type_ref)
+}
+
+/// Get the interface whose values a property holds, through arrays and maps
+fn interface_ref(property: &serde_yaml::Value) -> Option<&str> {
+    if let Some(interface) = property.get("x-go-interface-ref").and_then(|i| i.as_str()) {
+        return Some(interface);
+    }
+    ["items", "additionalProperties"]
+        .iter()
+        .filter_map(|key| property.get(key))
+        .find_map(interface_ref)
+}
+
+/// Describe the shape of a property in TODO comments
+fn shape(property: &serde_yaml::Value) -> String {
+    let type_name = property
+        .get("type")
+        .and_then(|t| t.as_str())
+        .unwrap_or("any");
+    match (property.get("items"), property.get("additionalProperties")) {
+        (Some(items), _) => format!("array of {}", shape(items)),
+        (_, Some(values)) => format!("map of {}", shape(values)),
+        _ => match property.get("format").and_then(|f| f.as_str()) {
+            Some(format) => format!("{type_name} ({format})"),
+            None => type_name.to_string(),
+        },
+    }
+}
+
+/// Collect the libraries of the resolved references of a property, by type
+///
+/// Libraries are rendered as the expression importing them: the generated
+/// library of the type, or the type's object of an external library.
+fn collect_references(property: &serde_yaml::Value, references: &mut BTreeMap<String, String>) {
+    if let Some(reference) = property.get("x-go-ref") {
+        let field = |key: &str| reference.get(key).and_then(|v| v.as_str());
+        if let (Some(type_name), Some(file)) = (field("type"), field("file")) {
+            references.insert(
+                type_name.to_string(),
+                format!("import {}", quote_string(file)),
+            );
+        } else if let (Some(type_name), Some(library)) = (field("type"), field("library")) {
+            let mut expression = format!("(import {})", quote_string(library));
+            for segment in reference
+                .get("path")
+                .and_then(|p| p.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|s| s.as_str())
+            {
+                if is_identifier(segment) {
+                    expression.push('.');
+                    expression.push_str(segment);
+                } else {
+                    expression.push_str(&format!("[{}]", quote_string(segment)));
+                }
+            }
+            references.insert(type_name.to_string(), expression);
+        }
+    }
+    for key in ["items", "additionalProperties"] {
+        if let Some(inner) = property.get(key) {
+            collect_references(inner, references);
+        }
+    }
+}
+
+/// Whether a property is written by the server rather than by users
+fn is_read_only(property: &serde_yaml::Value) -> bool {
+    property.get("readOnly").and_then(|r| r.as_bool()) == Some(true)
+}
+
+/// Whether the defaults of a type are written to `DEFAULTS_FILE`
+fn has_defaults_library(schema: &serde_yaml::Value) -> bool {
+    schema
+        .get(DEFAULTS_LIBRARY_KEY)
+        .and_then(|d| d.as_bool())
+        .unwrap_or(false)
+}
+
+/// Render the `assertValid(obj)` of a library, which manifests use to fail
+/// fast: it checks that the value is an object and passes it through
+/// `validate()`, if the library has one
+fn assert_valid_function(names: &HelperNames, type_name: &str, validated: bool) -> String {
+    let result = if validated {
+        "self.validate(obj)"
+    } else {
+        "obj"
+    };
+    format!(
+        "\n  // Fail unless obj is a valid {type_name}, and return it\n  {}(obj)::\n    assert std.isObject(obj) : {} + std.type(obj);\n    {result},\n",
+        names.function("assertValid"),
+        quote_string(&format!("{type_name} values are objects, not "))
+    )
+}
+
+/// Render the hidden field of a library holding the defaults of its type
+fn defaults_field(schema: &ExtractedSchema) -> String {
+    format!(
+        "  // Defaults of the fields set by new(), from {DEFAULTS_FILE}\n  defaults:: (import {})[{}],\n\n",
+        quote_string(DEFAULTS_FILE),
+        quote_string(&schema.name)
+    )
+}
+
+/// Whether a property is emitted as a hidden (`::`) field
+///
+/// The `x-go-hidden` of the property wins over the visibility of its class
+/// in the schema.
+fn is_hidden(schema: &serde_yaml::Value, property: &serde_yaml::Value) -> bool {
+    if let Some(hidden) = property.get("x-go-hidden").and_then(|h| h.as_bool()) {
+        return hidden;
+    }
+    let class = if property
+        .get("x-go-constructor-param")
+        .and_then(|p| p.as_bool())
+        == Some(true)
+    {
+        "parameters"
+    } else if property.get("default").is_some() {
+        "defaults"
+    } else if mixin_kind(property).is_some() {
+        "mixins"
+    } else {
+        "optional"
+    };
+    schema
+        .get(VISIBILITY_KEY)
+        .and_then(|v| v.get(class))
+        .and_then(|v| v.as_str())
+        == Some("hidden")
+}
+
+/// Whether a property distinguishes unset, null and a value
+fn has_presence(property: &serde_yaml::Value) -> bool {
+    property.get("x-go-presence").and_then(|p| p.as_bool()) == Some(true)
+}
+
+/// Whether a property is left out of the output while it holds its zero
+/// value, as encoding/json does with `omitempty`
+///
+/// Fields holding structs are never left out, and fields distinguishing
+/// unset from null keep their presence helpers instead.
+fn omits_empty(property: &serde_yaml::Value) -> bool {
+    empty_check(property, "value").is_some()
+}
+
+/// Check of whether `value` is the zero value of an `omitempty` property:
+/// null, false, 0, '' or an empty array or map
+fn empty_check(property: &serde_yaml::Value, value: &str) -> Option<String> {
+    if property.get(OMIT_EMPTY_KEY).and_then(|o| o.as_bool()) != Some(true)
+        || has_presence(property)
+    {
+        return None;
+    }
+    let flag = |key: &str| property.get(key).and_then(|n| n.as_bool()) == Some(true);
+    if flag("nullable") || flag(POINTER_KEY) {
+        return Some(format!("{value} == null"));
+    }
+    let is_struct = [
+        "properties",
+        STRUCT_REF_KEY,
+        "x-go-interface-ref",
+        "x-go-ref",
+    ]
+    .iter()
+    .any(|key| property.get(*key).is_some());
+    match property.get("type").and_then(|t| t.as_str()) {
+        Some("string") => Some(format!("{value} == ''")),
+        Some("integer" | "number") => Some(format!("{value} == 0")),
+        Some("boolean") => Some(format!("{value} == false")),
+        Some("array") => Some(format!("std.length({value}) == 0")),
+        Some("object") if !is_struct => Some(format!("std.length({value}) == 0")),
+        _ => None,
+    }
+}
+
+/// Describe where the Go field behind a property is declared
+///
+/// Gives `user.go:42 (User.Email)`, from the `x-go-source` the parser records,
+/// and `server.go:7 (Server.Port, type Port)` for named primitive types.
+fn field_source(schema: &ExtractedSchema, property: &serde_yaml::Value) -> Option<String> {
+    let source = property.get("x-go-source")?;
+    let type_name = source.get("type")?.as_str()?;
+    let field = source.get("field")?.as_str()?;
+    let line = source.get("line")?.as_u64()?;
+    let named = match property.get("x-go-type").and_then(|t| t.as_str()) {
+        Some(named) => format!(", type {named}"),
+        None => String::new(),
+    };
+    Some(format!(
+        "{}:{line} ({type_name}.{field}{named})",
+        schema.source_file.display()
+    ))
+}
+
+/// Render the doc comment of a field as line comments, so libraries read
+/// in an editor document their fields
+fn doc_comment(property: &serde_yaml::Value, indent: &str) -> String {
+    let Some(description) = property.get("description").and_then(|d| d.as_str()) else {
+        return String::new();
+    };
+    description
+        .lines()
+        .map(|line| format!("{indent}// {line}").trim_end().to_string() + "\n")
+        .collect()
+}
+
+/// Get the `new()` parameters as (field name, parameter name, property)
+///
+/// Parameter names are made unique, since fields that are not valid
+/// identifiers all map to `value`.
+fn constructor_params(properties: &serde_yaml::Mapping) -> Vec<(&str, String, &serde_yaml::Value)> {
+    let mut params: Vec<(&str, String, &serde_yaml::Value)> = Vec::new();
+
+    for (name, property) in properties {
+        let name = match name.as_str() {
+            Some(name) => name,
+            None => continue,
+        };
+        if property
+            .get("x-go-constructor-param")
+            .and_then(|p| p.as_bool())
+            != Some(true)
+            || is_read_only(property)
+        {
+            continue;
+        }
+
+        let base = param_name(name);
+        let mut param = base.clone();
+        let mut suffix = 2;
+        while params.iter().any(|(_, p, _)| *p == param) {
+            param = format!("{base}{suffix}");
+            suffix += 1;
+        }
+        params.push((name, param, property));
+    }
+
+    params
+}
+
+/// Name the setter of one entry of a map (`withLabel`)
+fn entry_setter(name: &str, names: HelperNames) -> String {
+    names.prefixed("with", name.strip_suffix('s').unwrap_or(name))
+}
+
+/// Get the setter of one entry of a map of labels or annotations
+fn metadata_entry_setter(
+    name: &str,
+    property: &serde_yaml::Value,
+    names: HelperNames,
+) -> Option<String> {
+    property
+        .get(METADATA_MAP_KEY)
+        .is_some()
+        .then(|| entry_setter(name, names))
+}
+
+/// Get the `withXFromEnv(varName)` setter of a scalar field of a schema
+/// generated with `ENV_SETTERS_KEY`, with its code from `::` on
+///
+/// External variables given with `--ext-str` are strings and are parsed as
+/// JSON for numbers and booleans; those given with `--ext-code` are used as
+/// they are.
+fn env_setter(
+    schema: &serde_yaml::Value,
+    name: &str,
+    property: &serde_yaml::Value,
+    names: HelperNames,
+    indent: &str,
+) -> Option<(String, String)> {
+    if schema.get(ENV_SETTERS_KEY).and_then(|e| e.as_bool()) != Some(true)
+        || property.get("x-go-ref").is_some()
+    {
+        return None;
+    }
+    let setter = names.setter(name);
+    let code = match property.get("type").and_then(|t| t.as_str())? {
+        "string" => format!(" self.{setter}(std.extVar(varName))"),
+        "integer" | "number" | "boolean" => format!(
+            "\n{indent}  local value = std.extVar(varName);\n{indent}  self.{setter}(if std.isString(value) then std.parseJson(value) else value)"
+        ),
+        _ => return None,
+    };
+    Some((names.variant(name, "FromEnv"), code))
+}
+
+/// Build the assertions of the setter of one entry of labels or annotations
+fn metadata_entry_assertions(name: &str) -> [String; 2] {
+    [
+        format!(
+            "assert isQualifiedName(key) : {}",
+            quote_string(&format!(
+                "{name} keys must be a qualified name such as app.kubernetes.io/name"
+            ))
+        ),
+        format!(
+            "assert std.isString(value) : {}",
+            quote_string(&format!("{name} values must be strings"))
+        ),
+    ]
+}
+
+/// Units of the helpers of durations, byte sizes and quantities, as
+/// (suffix, unit, conversion of `value`)
+const DURATION_UNITS: &[(&str, &str, &str)] = &[
+    ("Milliseconds", "milliseconds", "std.toString(value) + 'ms'"),
+    ("Seconds", "seconds", "std.toString(value) + 's'"),
+    ("Minutes", "minutes", "std.toString(value) + 'm'"),
+    ("Hours", "hours", "std.toString(value) + 'h'"),
+];
+const SECONDS_UNITS: &[(&str, &str, &str)] = &[
+    ("Minutes", "minutes", "value * 60"),
+    ("Hours", "hours", "value * 3600"),
+];
+const BYTES_UNITS: &[(&str, &str, &str)] = &[
+    ("Ki", "kibibytes", "value * 1024"),
+    ("Mi", "mebibytes", "value * 1048576"),
+    ("Gi", "gibibytes", "value * 1073741824"),
+];
+const QUANTITY_UNITS: &[(&str, &str, &str)] = &[
+    ("Milli", "thousandths", "std.toString(value) + 'm'"),
+    ("Ki", "kibi", "std.toString(value) + 'Ki'"),
+    ("Mi", "mebi", "std.toString(value) + 'Mi'"),
+    ("Gi", "gibi", "std.toString(value) + 'Gi'"),
+];
+
+/// Get the helpers setting a field from a number in a unit, as (name,
+/// doc comment, conversion of `value`)
+///
+/// Durations and quantities get one helper per unit (`withTimeoutSeconds`,
+/// `withMemoryMi`); integer fields named for their unit, such as
+/// `maxBodyBytes` or `timeoutSeconds`, get helpers for larger units in
+/// place of it (`withMaxBodyMi`, `withTimeoutMinutes`), unless there is a
+/// field of that name too. Helpers named like another field's setter are
+/// left out.
+fn unit_helpers(
+    name: &str,
+    property: &serde_yaml::Value,
+    properties: &serde_yaml::Mapping,
+    names: HelperNames,
+) -> Vec<(String, String, &'static str)> {
+    let format = property.get("format").and_then(|f| f.as_str());
+    let integer = property.get("type").and_then(|t| t.as_str()) == Some("integer");
+    let (stem, units) = match format {
+        Some("duration") => (name, DURATION_UNITS),
+        Some("quantity") => (name, QUANTITY_UNITS),
+        _ if !integer => return Vec::new(),
+        _ => match [("Bytes", BYTES_UNITS), ("Seconds", SECONDS_UNITS)]
+            .into_iter()
+            .find_map(|(unit, units)| {
+                let stem = name.strip_suffix(unit).filter(|s| !s.is_empty())?;
+                Some((stem, units)).filter(|_| !properties.contains_key(stem))
+            }) {
+            Some(found) => found,
+            None => return Vec::new(),
+        },
+    };
+    units
+        .iter()
+        .filter(|(suffix, _, _)| !properties.contains_key(format!("{stem}{suffix}").as_str()))
+        .map(|(suffix, unit, conversion)| {
+            let doc = match format {
+                Some("quantity") => format!("Set the {name} field to a number of {unit} units"),
+                _ => format!("Set the {name} field from a number of {unit}"),
+            };
+            (names.variant(stem, suffix), doc, *conversion)
+        })
+        .collect()
+}
+
+/// Render the helpers setting a field from a number in a unit, calling its
+/// setter
+fn render_unit_helpers(
+    name: &str,
+    property: &serde_yaml::Value,
+    properties: &serde_yaml::Mapping,
+    names: HelperNames,
+    indent: &str,
+) -> String {
+    let mut code = String::new();
+    for (helper, doc, conversion) in unit_helpers(name, property, properties, names) {
+        code.push_str(&format!("\n{indent}// {doc}\n"));
+        code.push_str(&format!("{indent}{helper}(value)::\n"));
+        code.push_str(&format!(
+            "{indent}  assert std.isNumber(value) : {};\n",
+            quote_string(&format!("{helper} takes a number"))
+        ));
+        code.push_str(&format!(
+            "{indent}  self.{}({conversion}),\n",
+            names.setter(name)
+        ));
+    }
+    code
+}
+
+/// Build the `withCondition`/`hasCondition` helpers for a conditions list
+///
+/// Helpers for a field other than `conditions` are named after it
+/// (`podConditions` gives `withPodCondition`).
+fn condition_helpers(name: &str, names: HelperNames) -> String {
+    let singular = name.strip_suffix('s').unwrap_or(name);
+    let key = field_key(name);
+    let quoted = quote_string(name);
+
+    let mut code = String::new();
+    code.push_str("\n  // Set the condition of the given type, replacing any existing one\n");
+    code.push_str(&format!(
+        "  {}(type, status, reason='', message='', lastTransitionTime=null)::\n",
+        names.prefixed("with", singular)
+    ));
+    code.push_str(
+        "    assert std.member(['True', 'False', 'Unknown'], status) : 'status must be True, False or Unknown';\n",
+    );
+    code.push_str(
+        "    local condition = { type: type, status: status, reason: reason, message: message }\n",
+    );
+    code.push_str(
+        "      + (if lastTransitionTime != null then { lastTransitionTime: lastTransitionTime } else {});\n",
+    );
+    code.push_str(&format!(
+        "    self + {{ {key}: [c for c in (if {quoted} in super then super[{quoted}] else []) if c.type != type] + [condition] }},\n"
+    ));
+    code.push_str("\n  // Check whether a condition of the given type is set\n");
+    code.push_str(&format!(
+        "  {}(type):: std.length([c for c in std.get(self, {quoted}, []) if c.type == type]) > 0,\n",
+        names.prefixed("has", singular)
+    ));
+
+    code
+}
+
+/// Build the setter assertions for a property's validation keywords
+fn field_assertions(name: &str, param: &str, property: &serde_yaml::Value) -> Vec<String> {
+    field_checks(name, param, property, 0)
+        .into_iter()
+        .map(|(condition, message)| format!("assert {condition} : {}", quote_string(&message)))
+        .collect()
+}
+
+/// Build the `validate(obj)` assertions for a property
+///
+/// Required fields must be present; other checks apply when the field is set.
+fn object_assertions(name: &str, property: &serde_yaml::Value) -> Vec<String> {
+    let key = quote_string(name);
+    let value = if is_identifier(name) {
+        format!("obj.{name}")
+    } else {
+        format!("obj[{key}]")
+    };
+
+    let mut assertions = Vec::new();
+    if property
+        .get("x-go-constructor-param")
+        .and_then(|p| p.as_bool())
+        == Some(true)
+    {
+        assertions.push(format!(
+            "assert std.objectHas(obj, {key}) : {}",
+            quote_string(&format!("{name} is required"))
+        ));
+    }
+    for (condition, message) in field_checks(name, &value, property, 0) {
+        assertions.push(format!(
+            "assert !std.objectHas(obj, {key}) || ({condition}) : {}",
+            quote_string(&message)
+        ));
+    }
+
+    assertions
+}
+
+/// Build the assertions for cross-field rules (`gtfield`, `required_with`, ...)
+///
+/// `obj` is the object holding the fields. Comparisons only apply when both
+/// fields are set.
+fn cross_field_assertions(properties: &serde_yaml::Mapping, obj: &str) -> Vec<String> {
+    let has = |field: &str| format!("std.objectHas({obj}, {})", quote_string(field));
+    let get = |field: &str| {
+        if is_identifier(field) {
+            format!("{obj}.{field}")
+        } else {
+            format!("{obj}[{}]", quote_string(field))
+        }
+    };
+
+    let mut assertions = Vec::new();
+    for (name, property) in properties {
+        let name = match name.as_str() {
+            Some(name) => name,
+            None => continue,
+        };
+        let rules = match property.get(FIELD_RULES_KEY).and_then(|r| r.as_sequence()) {
+            Some(rules) => rules,
+            None => continue,
+        };
+
+        for rule in rules {
+            let fields: Vec<&str> = rule
+                .get("fields")
+                .and_then(|f| f.as_sequence())
+                .map(|f| f.iter().filter_map(|v| v.as_str()).collect())
+                .unwrap_or_default();
+            let (first, rule) = match (fields.first(), rule.get("rule").and_then(|r| r.as_str())) {
+                (Some(first), Some(rule)) => (*first, rule),
+                _ => continue,
+            };
+
+            let comparison = match rule {
+                "eqfield" => Some(("==", "equal to")),
+                "nefield" => Some(("!=", "different from")),
+                "gtfield" => Some((">", "greater than")),
+                "gtefield" => Some((">=", "at least")),
+                "ltfield" => Some(("<", "less than")),
+                "ltefield" => Some(("<=", "at most")),
+                _ => None,
+            };
+            let present: Vec<String> = fields.iter().map(|f| has(f)).collect();
+            let joined = |operator: &str| match present.len() {
+                1 => present[0].clone(),
+                _ => format!("({})", present.join(operator)),
+            };
+            let (condition, message) = match (comparison, rule) {
+                (Some((operator, phrase)), _) => (
+                    format!(
+                        "!({} && {}) || {} {operator} {}",
+                        has(name),
+                        has(first),
+                        get(name),
+                        get(first)
+                    ),
+                    format!("{name} must be {phrase} {first}"),
+                ),
+                (None, "required_with") => (
+                    format!("!{} || {}", joined(" || "), has(name)),
+                    format!("{name} is required when {} is set", fields.join(" or ")),
+                ),
+                (None, "required_with_all") => (
+                    format!("!{} || {}", joined(" && "), has(name)),
+                    format!("{name} is required when {} are set", fields.join(" and ")),
+                ),
+                (None, "required_without") => (
+                    format!("{} || {}", joined(" && "), has(name)),
+                    format!("{name} is required when {} is not set", fields.join(" or ")),
+                ),
+                (None, "required_without_all") => (
+                    format!("{} || {}", joined(" || "), has(name)),
+                    format!(
+                        "{name} is required when none of {} is set",
+                        fields.join(", ")
+                    ),
+                ),
+                _ => continue,
+            };
+
+            assertions.push(format!("assert {condition} : {}", quote_string(&message)));
+        }
+    }
+
+    assertions
+}
+
+/// Build the (condition, message) checks for a property's validation keywords
+///
+/// `value` is the expression being checked. Element schemas (`items`, and
+/// `additionalProperties` for maps) are checked for every element, using
+/// `depth` to keep comprehension variables apart.
+fn field_checks(
+    name: &str,
+    value: &str,
+    property: &serde_yaml::Value,
+    depth: usize,
+) -> Vec<(String, String)> {
+    let mut checks = Vec::new();
+
+    if let Some(format) = property.get("format").and_then(|f| f.as_str()) {
+        if let Some((_, helper, _, description)) =
+            FORMAT_CHECKS.iter().find(|(f, _, _, _)| *f == format)
+        {
+            checks.push((
+                format!("{helper}({value})"),
+                format!("{name} must be {description}"),
+            ));
+        }
+    }
+
+    if let Some(pattern) = property.get("pattern").and_then(|p| p.as_str()) {
+        checks.push((
+            format!("matchesPattern({}, {value})", quote_string(pattern)),
+            format!("{name} must match pattern {pattern}"),
+        ));
+    }
+
+    if let Some(values) = property.get("enum").and_then(|e| e.as_sequence()) {
+        let rendered: Vec<String> = values.iter().map(yaml_to_jsonnet).collect();
+        let listed: Vec<String> = values
+            .iter()
+            .map(|v| {
+                v.as_str()
+                    .map(str::to_string)
+                    .unwrap_or_else(|| yaml_to_jsonnet(v))
+            })
+            .collect();
+        checks.push((
+            format!("std.member([{}], {value})", rendered.join(", ")),
+            format!("{name} must be one of {}", listed.join(", ")),
+        ));
+    }
+
+    let unit = match property.get("type").and_then(|t| t.as_str()) {
+        Some("string") => "characters",
+        Some("array") => "items",
+        _ => "entries",
+    };
+    let bounds = [
+        ("minLength", ">=", "at least", true),
+        ("maxLength", "<=", "at most", true),
+        ("minItems", ">=", "at least", true),
+        ("maxItems", "<=", "at most", true),
+        ("minProperties", ">=", "at least", true),
+        ("maxProperties", "<=", "at most", true),
+        ("minimum", ">=", "at least", false),
+        ("maximum", "<=", "at most", false),
+        ("exclusiveMinimum", ">", "greater than", false),
+        ("exclusiveMaximum", "<", "less than", false),
+    ];
+    for (keyword, operator, phrase, is_length) in bounds {
+        let bound = match property.get(keyword) {
+            Some(bound) if bound.as_f64().is_some() => yaml_to_jsonnet(bound),
+            _ => continue,
+        };
+        if is_length {
+            checks.push((
+                format!("std.length({value}) {operator} {bound}"),
+                format!("{name} must have {phrase} {bound} {unit}"),
+            ));
+        } else {
+            checks.push((
+                format!("{value} {operator} {bound}"),
+                format!("{name} must be {phrase} {bound}"),
+            ));
+        }
+    }
+
+    // Element checks, from `dive`
+    let element = format!(
+        "e{}",
+        if depth == 0 {
+            String::new()
+        } else {
+            depth.to_string()
+        }
+    );
+    let elements = match property.get("type").and_then(|t| t.as_str()) {
+        Some("array") => property
+            .get("items")
+            .map(|items| (items, value.to_string())),
+        Some("object") => property
+            .get("additionalProperties")
+            .filter(|p| p.is_mapping())
+            .map(|values| (values, format!("std.objectValues({value})"))),
+        _ => None,
+    };
+    if let Some((schema, collection)) = elements {
+        for (condition, message) in field_checks(&format!("{name}[]"), &element, schema, depth + 1)
+        {
+            checks.push((
+                format!("std.all([{condition} for {element} in {collection}])"),
+                message,
+            ));
+        }
+    }
+
+    // Key checks of maps with typed keys, which are strings in Jsonnet
+    if let Some(keys) = property.get(MAP_KEY_KEY) {
+        let key = format!("k{}", &element[1..]);
+        let collection = format!("std.objectFields({value})");
+        if has_integer_keys(property) {
+            checks.push((
+                format!(
+                    "std.all([matchesPattern({}, {key}) for {key} in {collection}])",
+                    quote_string(INTEGER_KEY_PATTERN)
+                ),
+                format!("{name} keys must be integers"),
+            ));
+        } else if keys.get("type").and_then(|t| t.as_str()) == Some("string") {
+            for (condition, message) in field_checks(&format!("{name} keys"), &key, keys, depth + 1)
+            {
+                checks.push((
+                    format!("std.all([{condition} for {key} in {collection}])"),
+                    message,
+                ));
+            }
+        }
+    }
+
+    // `omitempty`: the zero value skips the checks
+    if property.get("x-go-allow-empty").and_then(|a| a.as_bool()) == Some(true) {
+        let empty = match property.get("type").and_then(|t| t.as_str()) {
+            Some("string") => "''",
+            Some("number" | "integer") => "0",
+            Some("boolean") => "false",
+            Some("array") => "[]",
+            _ => "{}",
+        };
+        checks = checks
+            .into_iter()
+            .map(|(condition, message)| (format!("{value} == {empty} || {condition}"), message))
+            .collect();
+    }
+
+    checks
+}
+
+/// Whether a map property has integer keys, written in decimal by encoding/json
+fn has_integer_keys(property: &serde_yaml::Value) -> bool {
+    property
+        .get(MAP_KEY_KEY)
+        .and_then(|keys| keys.get("type"))
+        .and_then(|t| t.as_str())
+        == Some("integer")
+}
+
+/// Sample values of the string formats, valid for the checks in `FORMAT_CHECKS`
+const FORMAT_SAMPLES: &[(&str, &str)] = &[
+    ("date-time", "1970-01-01T00:00:00Z"),
+    ("duration", "1s"),
+    ("email", "user@example.com"),
+    ("uri", "https://example.com"),
+    ("uuid", "00000000-0000-0000-0000-000000000000"),
+    ("ipv4", "192.0.2.1"),
+    ("ipv6", "2001:db8::1"),
+    ("ip", "192.0.2.1"),
+    ("cidrv4", "192.0.2.0/24"),
+    ("cidrv6", "2001:db8::/32"),
+    ("cidr", "192.0.2.0/24"),
+    ("semver", "1.0.0"),
+    ("qualified-name", "app.kubernetes.io/name"),
+    ("quantity", "1"),
+    ("hostname", "example.com"),
+];
+
+/// Build a sample value for a property, as a Jsonnet literal
+///
+/// Values of a `pattern` without a format cannot be derived; the schema's
+/// `example` is used when there is one.
+fn sample_value(property: &serde_yaml::Value) -> String {
+    if let Some(value) = ["default", "example"]
+        .iter()
+        .find_map(|key| property.get(*key))
+    {
+        return yaml_to_jsonnet(value);
+    }
+    if let Some(first) = property
+        .get("enum")
+        .and_then(|e| e.as_sequence())
+        .and_then(|e| e.first())
+    {
+        return yaml_to_jsonnet(first);
+    }
+
+    let count = |key: &str| {
+        property
+            .get(key)
+            .and_then(|v| v.as_u64())
+            .unwrap_or_default() as usize
+    };
+    match property.get("type").and_then(|t| t.as_str()) {
+        Some("string") => {
+            let format = property.get("format").and_then(|f| f.as_str());
+            match FORMAT_SAMPLES.iter().find(|(f, _)| Some(*f) == format) {
+                Some((_, sample)) => quote_string(sample),
+                None => quote_string(&"x".repeat(count("minLength").max(1))),
+            }
+        }
+        Some("integer" | "number") => {
+            let bound = |key: &str| property.get(key).and_then(|v| v.as_f64());
+            let value = match (
+                bound("minimum"),
+                bound("exclusiveMinimum"),
+                bound("maximum"),
+                bound("exclusiveMaximum"),
+            ) {
+                (Some(minimum), _, _, _) => minimum,
+                (None, Some(minimum), _, _) => minimum + 1.0,
+                (None, None, Some(maximum), _) if maximum < 0.0 => maximum,
+                (None, None, _, Some(maximum)) if maximum <= 0.0 => maximum - 1.0,
+                _ => 0.0,
+            };
+            if value.fract() == 0.0 {
+                format!("{}", value as i64)
+            } else {
+                value.to_string()
+            }
+        }
+        Some("boolean") => "false".to_string(),
+        Some("array") => {
+            let item = property
+                .get("items")
+                .map(sample_value)
+                .unwrap_or_else(|| "null".to_string());
+            format!("[{}]", vec![item; count("minItems")].join(", "))
+        }
+        Some("object") => {
+            let value = property
+                .get("additionalProperties")
+                .filter(|p| p.is_mapping())
+                .map(sample_value)
+                .unwrap_or_else(|| "null".to_string());
+            let entries: Vec<String> = (0..count("minProperties"))
+                .map(|i| format!("k{i}: {value}"))
+                .collect();
+            format!("{{{}}}", entries.join(", "))
+        }
+        _ => "null".to_string(),
+    }
+}
+
+/// Check whether a library has a `validate()` for the object of its properties
+fn has_validate(properties: &serde_yaml::Mapping) -> bool {
+    properties
+        .iter()
+        .filter_map(|(name, property)| Some((name.as_str()?, property)))
+        .any(|(name, property)| !object_assertions(name, property).is_empty())
+        || !cross_field_assertions(properties, "obj").is_empty()
+}
+
+/// Build a value failing the checks of a property, as a Jsonnet literal
+///
+/// With `omitempty` the zero value skips the checks, so only values that are
+/// not empty are used.
+fn invalid_value(property: &serde_yaml::Value) -> Option<String> {
+    let allow_empty = property.get("x-go-allow-empty").and_then(|a| a.as_bool()) == Some(true);
+    let bound = |key: &str| property.get(key).and_then(|v| v.as_f64());
+    let number = |value: f64| {
+        if value.fract() == 0.0 {
+            format!("{}", value as i64)
+        } else {
+            value.to_string()
+        }
+    };
+
+    // Contains a space, so it is none of the checked formats
+    if property.get("enum").is_some()
+        || property
+            .get("format")
+            .and_then(|f| f.as_str())
+            .is_some_and(|f| FORMAT_CHECKS.iter().any(|(format, _, _, _)| *format == f))
+    {
+        return Some(quote_string("not a valid value"));
+    }
+
+    match property.get("type").and_then(|t| t.as_str()) {
+        Some("string") => {
+            if let Some(max) = bound("maxLength") {
+                return Some(quote_string(&"x".repeat(max as usize + 1)));
+            }
+            (!allow_empty && bound("minLength").is_some_and(|min| min > 0.0))
+                .then(|| "\"\"".to_string())
+        }
+        Some("integer" | "number") => {
+            let value = if let Some(max) = bound("maximum") {
+                max + 1.0
+            } else if let Some(max) = bound("exclusiveMaximum") {
+                max
+            } else if let Some(min) = bound("minimum") {
+                min - 1.0
+            } else if let Some(min) = bound("exclusiveMinimum") {
+                min
+            } else {
+                return None;
+            };
+            (!allow_empty || value != 0.0).then(|| number(value))
+        }
+        Some("array") => {
+            let item = property
+                .get("items")
+                .map(sample_value)
+                .unwrap_or_else(|| "null".to_string());
+            if let Some(max) = bound("maxItems") {
+                return Some(format!("[{}]", vec![item; max as usize + 1].join(", ")));
+            }
+            (!allow_empty && bound("minItems").is_some_and(|min| min > 0.0))
+                .then(|| "[]".to_string())
+        }
+        _ => None,
+    }
+}
+
+/// Render the helpers checking the string formats and patterns of
+/// properties, for the setters and `validate()`
+fn validation_helpers<'a>(
+    schema: &serde_yaml::Value,
+    properties: impl Iterator<Item = &'a serde_yaml::Value>,
+) -> String {
+    let mut formats = Vec::new();
+    let mut patterns = BTreeSet::new();
+    for property in properties {
+        collect_formats(property, &mut formats);
+        collect_patterns(property, &mut patterns);
+    }
+    for (format, first, second) in EITHER_FORMATS {
+        if formats.contains(format) {
+            formats.extend([*first, *second]);
+        }
+    }
+    let checks: Vec<_> = FORMAT_CHECKS
+        .iter()
+        .filter(|(format, _, _, _)| formats.contains(format))
+        .collect();
+    patterns.extend(
+        checks
+            .iter()
+            .map(|(_, _, pattern, _)| *pattern)
+            .filter(|pattern| !pattern.is_empty()),
+    );
+    if patterns.is_empty() {
+        return String::new();
+    }
+
+    let mode = schema.get(PATTERN_CHECKS_KEY).and_then(|p| p.as_str());
+    let mut code = match mode {
+        Some("native") => patterns::NATIVE_MATCHES_PATTERN_HELPER.to_string(),
+        Some("auto") => {
+            patterns::render_patterns(patterns)
+                + patterns::PATTERN_ENDS_HELPER
+                + patterns::AUTO_MATCHES_PATTERN_HELPER
+        }
+        _ => {
+            patterns::render_patterns(patterns)
+                + patterns::PATTERN_ENDS_HELPER
+                + patterns::MATCHES_PATTERN_HELPER
+        }
+    };
+    let mut natives = BTreeSet::new();
+    for (format, helper, pattern, _) in checks {
+        let either = EITHER_FORMATS.iter().find(|(f, _, _)| f == format);
+        let native = native_format_check(format).filter(|_| mode == Some("auto"));
+        if let Some((_, first, second)) = either {
+            let helper_of = |format: &str| {
+                FORMAT_CHECKS
+                    .iter()
+                    .find(|(f, _, _, _)| *f == format)
+                    .map(|(_, helper, _, _)| *helper)
+                    .unwrap_or_default()
+            };
+            code.push_str(&format!(
+                "local {helper}(value) = {}(value) || {}(value);\n",
+                helper_of(first),
+                helper_of(second)
+            ));
+        } else if let Some((function, check)) = native {
+            if natives.insert(function) {
+                code.push_str(&format!("local {function} = std.native('{function}');\n"));
+            }
+            code.push_str(&format!(
+                "local {helper}(value) = if {function} == null then matchesPattern({}, value) else {check};\n",
+                quote_string(pattern)
+            ));
+        } else {
+            code.push_str(&format!(
+                "local {helper}(value) = matchesPattern({}, value);\n",
+                quote_string(pattern)
+            ));
+        }
+    }
+    code
+}
+
+/// Get the native function of `crate::natives` checking a string format,
+/// with the check using it
+fn native_format_check(format: &str) -> Option<(&'static str, &'static str)> {
+    match format {
+        "semver" => Some((
+            "semverCompare",
+            "std.isString(value) && semverCompare(value, value) != null",
+        )),
+        "cidrv4" => Some((
+            "parseCidr",
+            "local cidr = if std.isString(value) then parseCidr(value); cidr != null && cidr.version == 4",
+        )),
+        "cidrv6" => Some((
+            "parseCidr",
+            "local cidr = if std.isString(value) then parseCidr(value); cidr != null && cidr.version == 6",
+        )),
+        _ => None,
+    }
+}
+
+/// Collect the patterns checked for a property and its element and key
+/// schemas
+fn collect_patterns<'a>(property: &'a serde_yaml::Value, patterns: &mut BTreeSet<&'a str>) {
+    if let Some(pattern) = property.get("pattern").and_then(|p| p.as_str()) {
+        patterns.insert(pattern);
+    }
+    if has_integer_keys(property) {
+        patterns.insert(INTEGER_KEY_PATTERN);
+    }
+    for key in ["items", "additionalProperties", MAP_KEY_KEY] {
+        if let Some(inner) = property.get(key).filter(|p| p.is_mapping()) {
+            collect_patterns(inner, patterns);
+        }
+    }
+}
+
+/// Collect the string formats used by a property and its element and key
+/// schemas
+fn collect_formats<'a>(property: &'a serde_yaml::Value, formats: &mut Vec<&'a str>) {
+    if let Some(format) = property.get("format").and_then(|f| f.as_str()) {
+        formats.push(format);
+    }
+    for key in ["items", "additionalProperties", MAP_KEY_KEY] {
+        if let Some(inner) = property.get(key).filter(|p| p.is_mapping()) {
+            collect_formats(inner, formats);
+        }
+    }
+}
+
+/// Get the pattern the generated assertions check a string format with
+pub fn format_pattern(format: &str) -> Option<&'static str> {
+    FORMAT_CHECKS
+        .iter()
+        .find(|(f, _, pattern, _)| *f == format && !pattern.is_empty())
+        .map(|(_, _, pattern, _)| *pattern)
+}
+
+/// Check whether a name is a valid Jsonnet identifier
+pub fn is_identifier(name: &str) -> bool {
+    let mut chars = name.chars();
+    match chars.next() {
+        Some(c) if c.is_ascii_alphabetic() || c == '_' => {}
+        _ => return false,
+    }
+
+    chars.all(|c| c.is_ascii_alphanumeric() || c == '_') && !JSONNET_KEYWORDS.contains(&name)
+}
+
+/// Generate the library of an enum marshaled by its `String()` names
+///
+/// The names are the values; `byName` and `byValue` map them to and from
+/// the integers of the constants.
+fn generate_string_enum(
+    schema: &ExtractedSchema,
+    values: &[EnumValue],
+    strings: &[&str],
+    names_only: bool,
+    mut code: String,
+) -> String {
+    if names_only {
+        code.push_str(&format!(
+            "// Note: {} is an enum encoded as the names of its values\n\n",
+            schema.name
+        ));
+    } else {
+        code.push_str(&format!(
+            "// Note: {} is an integer enum encoded as the names printed by its String method\n\n",
+            schema.name
+        ));
+    }
+    code.push_str("{\n");
+    for (value, string) in values.iter().zip(strings) {
+        code.push_str(&format!(
+            "  {}:: {},\n",
+            field_key(&value_key(&schema.name, &value.name)),
+            quote_string(string)
+        ));
+    }
+
+    let mut distinct: Vec<(&str, i64)> = Vec::new();
+    for (value, string) in values.iter().zip(strings) {
+        if !distinct.iter().any(|(name, _)| name == string) {
+            distinct.push((string, value.value));
+        }
+    }
+    let names: Vec<String> = distinct
+        .iter()
+        .map(|(name, _)| quote_string(name))
+        .collect();
+    code.push_str(&format!(
+        "\n  // Values of {}, in declaration order\n",
+        schema.name
+    ));
+    code.push_str(&format!("  values:: [{}],\n", names.join(", ")));
+
+    code.push_str(&format!(
+        "\n  // Whether a value is one of the names of {}\n",
+        schema.name
+    ));
+    code.push_str("  isValid(value):: std.member(self.values, value),\n");
+    if names_only {
+        code.push_str("}\n");
+        return code;
+    }
+
+    code.push_str("\n  // Integers of the constants by name\n");
+    code.push_str("  byName:: {\n");
+    for (name, number) in &distinct {
+        code.push_str(&format!("    {}: {number},\n", field_key(name)));
+    }
+    code.push_str("  },\n");
+    code.push_str("\n  // Names by integer\n");
+    code.push_str("  byValue:: {\n");
+    for (name, number) in &distinct {
+        code.push_str(&format!(
+            "    {}: {},\n",
+            quote_string(&number.to_string()),
+            quote_string(name)
+        ));
+    }
+    code.push_str("  },\n");
+
+    code.push_str("\n  // Get the name of an integer\n");
+    code.push_str("  fromInt(number):: self.byValue[std.toString(number)],\n");
+    code.push_str("\n  // Get the integer of a name\n");
+    code.push_str("  toInt(name):: self.byName[name],\n");
+    code.push_str("}\n");
+    code
+}
+
+/// Render an object field key, quoting it when necessary
+pub fn field_key(name: &str) -> String {
+    if is_identifier(name) {
+        name.to_string()
+    } else {
+        quote_string(name)
+    }
+}
+
+/// Build the `withX` setter name for a field
+pub fn setter_name(name: &str) -> String {
+    let mut setter = String::from("with");
+    let mut upper_next = true;
+
+    for c in name.chars() {
+        if c.is_ascii_alphanumeric() {
+            if upper_next {
+                setter.extend(c.to_uppercase());
+                upper_next = false;
+            } else {
+                setter.push(c);
+            }
+        } else {
+            // Drop separators such as '_', '-' and '.' and start a new word
+            upper_next = true;
+        }
+    }
+
+    setter
+}
+
+/// Deprecation notice of a type or field
+struct Deprecation<'a> {
+    message: &'a str,
+
+    /// Whether the helpers warn with `std.trace` when used
+    trace: bool,
+}
+
+impl<'a> Deprecation<'a> {
+    /// Get the deprecation of a schema or property, if it is deprecated
+    fn of(value: &'a serde_yaml::Value, schema: &serde_yaml::Value) -> Option<Self> {
+        let message = value.get("x-go-deprecated")?.as_str()?;
+        Some(Self {
+            message,
+            trace: schema
+                .get("x-go-deprecation-trace")
+                .and_then(|t| t.as_bool())
+                == Some(true),
+        })
+    }
+
+    /// Build the comment and docsonnet entry of a deprecated helper
+    fn docs(&self, function: &str, params: &[(&str, &serde_yaml::Value)]) -> String {
+        let args: Vec<String> = params
+            .iter()
+            .map(|(param, property)| {
+                let param_type = property
+                    .get("type")
+                    .and_then(|t| t.as_str())
+                    .unwrap_or("any");
+                format!(
+                    "{{ name: {}, type: {} }}",
+                    quote_string(param),
+                    quote_string(param_type)
+                )
+            })
+            .collect();
+
+        format!(
+            "  // Deprecated: {}\n  {}:: {{ \"function\": {{ help: {}, args: [{}] }} }},\n",
+            self.message,
+            quote_string(&format!("#{function}")),
+            quote_string(&format!("Deprecated: {}", self.message)),
+            args.join(", ")
+        )
+    }
+
+    /// Get the code wrapping the body of a helper to warn when it is used
+    fn trace(&self, function: &str) -> (String, String) {
+        if !self.trace {
+            return (String::new(), String::new());
+        }
+        let warning = quote_string(&format!("{function} is deprecated: {}", self.message));
+        (format!("std.trace({warning}, "), ")".to_string())
+    }
+}
+
+/// Names of the helper functions generated for the fields of a schema
+#[derive(Debug, Clone, Copy, Default)]
+struct HelperNames {
+    /// `with_name` instead of `withName`
+    snake_case: bool,
+}
+
+impl HelperNames {
+    /// Get the naming of a schema's helpers
+    fn of(schema: &serde_yaml::Value) -> Self {
+        Self {
+            snake_case: schema.get("x-go-function-naming").and_then(|n| n.as_str())
+                == Some("snake_case"),
+        }
+    }
+
+    /// Name the helper of a field with the given prefix (`withoutName`)
+    fn prefixed(&self, prefix: &str, field: &str) -> String {
+        if self.snake_case {
+            format!("{prefix}_{}", snake_case(field))
+        } else {
+            format!("{prefix}{}", &setter_name(field)["with".len()..])
+        }
+    }
+
+    /// Name the setter of a field (`withName`)
+    fn setter(&self, field: &str) -> String {
+        self.prefixed("with", field)
+    }
+
+    /// Name a variant of the setter of a field (`withNameMixin`)
+    fn variant(&self, field: &str, suffix: &str) -> String {
+        if self.snake_case && !suffix.is_empty() {
+            format!("{}_{}", self.setter(field), snake_case(suffix))
+        } else {
+            format!("{}{suffix}", self.setter(field))
+        }
+    }
+
+    /// Name a helper that is not tied to a field, given in camelCase
+    fn function(&self, name: &str) -> String {
+        if self.snake_case {
+            snake_case(name)
+        } else {
+            name.to_string()
+        }
+    }
+}
+
+/// Build a safe function parameter name for a field
+pub fn param_name(name: &str) -> String {
+    if is_identifier(name) {
+        name.to_string()
+    } else {
+        "value".to_string()
+    }
+}
+
+/// Quote a string as a Jsonnet string literal
+pub fn quote_string(value: &str) -> String {
+    let mut quoted = String::with_capacity(value.len() + 2);
+    quoted.push('"');
+
+    for c in value.chars() {
+        match c {
+            '"' => quoted.push_str("\\\""),
+            '\\' => quoted.push_str("\\\\"),
+            '\n' => quoted.push_str("\\n"),
+            '\r' => quoted.push_str("\\r"),
+            '\t' => quoted.push_str("\\t"),
+            c if c.is_control() => quoted.push_str(&format!("\\u{:04x}", c as u32)),
+            c => quoted.push(c),
+        }
+    }
+
+    quoted.push('"');
+    quoted
+}
+
+/// Serialize a YAML value as a Jsonnet literal
+pub fn yaml_to_jsonnet(value: &serde_yaml::Value) -> String {
+    match value {
+        serde_yaml::Value::Null => "null".to_string(),
+        serde_yaml::Value::Bool(b) => b.to_string(),
+        serde_yaml::Value::Number(n) => n.to_string(),
+        serde_yaml::Value::String(s) => quote_string(s),
+        serde_yaml::Value::Sequence(seq) => {
+            let items: Vec<String> = seq.iter().map(yaml_to_jsonnet).collect();
+            format!("[{}]", items.join(", "))
+        }
+        serde_yaml::Value::Mapping(map) => {
+            let items: Vec<String> = map
+                .iter()
+                .map(|(k, v)| {
+                    let key = match k {
+                        serde_yaml::Value::String(s) => field_key(s),
+                        other => quote_string(&yaml_to_jsonnet(other)),
+                    };
+                    format!("{key}: {}", yaml_to_jsonnet(v))
+                })
+                .collect();
+            format!("{{{}}}", items.join(", "))
+        }
+        serde_yaml::Value::Tagged(tagged) => yaml_to_jsonnet(&tagged.value),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::{test_evaluate as evaluate, test_schema, test_schema_value};
+
+    #[test]
+    fn test_setter_name() {
+        assert_eq!(setter_name("name"), "withName");
+        assert_eq!(setter_name("avatar_url"), "withAvatarUrl");
+        assert_eq!(
+            setter_name("app.kubernetes.io/name"),
+            "withAppKubernetesIoName"
+        );
+    }
+
+    #[test]
+    fn test_field_key_quoting() {
+        assert_eq!(field_key("name"), "name");
+        assert_eq!(field_key("local"), "\"local\"");
+        assert_eq!(field_key("foo-bar"), "\"foo-bar\"");
+        assert_eq!(param_name("foo-bar"), "value");
+    }
+
+    #[test]
+    fn test_generate_required_params() {
+        let mut required = serde_yaml::Mapping::new();
+        required.insert("type".into(), "string".into());
+        required.insert("x-go-constructor-param".into(), true.into());
+        let mut optional = serde_yaml::Mapping::new();
+        optional.insert("type".into(), "string".into());
+        optional.insert("default".into(), "n/a".into());
+
+        let mut properties = serde_yaml::Mapping::new();
+        properties.insert("name".into(), required.clone().into());
+        properties.insert("foo-bar".into(), required.into());
+        properties.insert("bio".into(), optional.into());
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains(
+            "  new(name, value):: self + {\n    name: name,\n    \"foo-bar\": value,\n    bio: \"n/a\",\n  },\n"
+        ));
+    }
+
+    #[test]
+    fn test_generate_deprecations() {
+        let schema = test_schema(
+            "Profile",
+            "go_struct",
+            "profile.go",
+            r#"
+properties:
+  nick: {type: string, x-go-deprecated: use displayName instead}
+  tags: {items: {type: string}, type: array, x-go-deprecated: tags are ignored}
+type: object
+x-go-deprecated: use User
+x-go-deprecation-trace: true
+"#,
+        );
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains(concat!(
+            "  // Deprecated: use displayName instead\n",
+            "  \"#withNick\":: { \"function\": { help: \"Deprecated: use displayName instead\", args: [{ name: \"nick\", type: \"string\" }] } },\n",
+            "  withNick(nick):: std.trace(\"withNick is deprecated: use displayName instead\", self + { nick: nick }),\n",
+        )));
+        assert!(code.contains(
+            "  withTagsMixin(tags):: std.trace(\"withTagsMixin is deprecated: tags are ignored\", self + { tags+: tags }),\n"
+        ));
+        assert!(
+            code.contains("  new():: std.trace(\"new is deprecated: use User\", self + {\n  }),\n")
+        );
+        // Deprecation comments are not mistaken for renamed setters
+        assert!(renamed_setters(&code).is_empty());
+    }
+
+    #[test]
+    fn test_generate_union() {
+        let schema = test_schema(
+            "Storage",
+            "go_struct",
+            "storage.go",
+            r#"
+type: object
+x-go-interface: {methods: [Get]}
+x-go-union:
+  discriminator: kind
+  variants:
+    - {fields: [path], file: memorystorage.libsonnet, name: MemoryStorage, params: [], value: memory}
+    - {fields: [bucket, path], file: s3storage.libsonnet, name: S3Storage, params: [bucket], value: S3Storage}
+"#,
+        );
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains(
+            "  variants:: {\n    memory: import \"memorystorage.libsonnet\",\n    S3Storage: import \"s3storage.libsonnet\",\n  },\n"
+        ));
+        assert!(code.contains(
+            "  newS3Storage(bucket):: self.variants[\"S3Storage\"].new(bucket) + { kind: \"S3Storage\" },\n"
+        ));
+        assert!(code.contains("  fromMemoryStorage(value):: value + { kind: \"memory\" },\n"));
+        assert!(code.contains("    self.variants[obj[\"kind\"]],\n"));
+        assert!(code.contains(
+            "  fields:: {\n    memory: [\"path\"],\n    S3Storage: [\"bucket\", \"path\"],\n  },\n"
+        ));
+        assert!(code.contains("  oneOf(obj)::\n    assert std.isObject(self.variantOf(obj));\n"));
+        assert!(code.contains("    local variant = self.variantOf(self.oneOf(obj));\n"));
+        assert!(!code.contains("  new("));
+        assert!(generator.generate_tests(&schema).is_empty());
+
+        // Unions of the configuration are not interfaces
+        let declared = ExtractedSchema {
+            content: serde_yaml::from_str(
+                "{type: object, x-go-union: {discriminator: type, variants: [{fields: [url], file: gitsource.libsonnet, name: GitSource, params: [], value: GitSource}]}}",
+            )
+            .unwrap(),
+            ..schema
+        };
+        let code = generator.generate(&declared).unwrap();
+        assert!(code.contains(
+            "// Note: Storage is a union declared in the configuration; values are one of its variants, told apart by the type field\n"
+        ));
+        assert!(code.contains(
+            "  newGitSource():: self.variants[\"GitSource\"].new() + { type: \"GitSource\" },\n"
+        ));
+    }
+
+    #[test]
+    fn test_generate_enum() {
+        let enum_schema =
+            |name: &str, content: &str| test_schema(name, "go_struct", "level.go", content);
+        let generator = GoJsonnetGenerator::new();
+
+        let level = enum_schema(
+            "Level",
+            "{type: integer, x-go-enum: {flags: false, values: [{name: LevelDebug, value: 0}, {name: LevelInfo, value: 1}]}}",
+        );
+        let code = generator.generate(&level).unwrap();
+        assert!(code.contains("  debug:: 0,\n  info:: 1,\n"));
+        assert!(code.contains("  values:: [0, 1],\n"));
+        assert!(code.contains("  isValid(value):: std.member(self.values, value),\n"));
+        assert!(!code.contains("combine"));
+        assert!(!code.contains("  new("));
+        assert!(generator.generate_tests(&level).is_empty());
+
+        let permission = enum_schema(
+            "Permission",
+            "{type: integer, x-go-enum: {flags: true, values: [{name: PermissionRead, value: 1}, {name: PermissionWrite, value: 2}, {name: PermissionExec, value: 4}]}}",
+        );
+        let code = generator.generate(&permission).unwrap();
+        assert!(code.contains("  read:: 1,\n  write:: 2,\n  exec:: 4,\n"));
+        assert!(code.contains("  all:: 7,\n"));
+        assert!(code
+            .contains("  combine(flags):: std.foldl(function(acc, flag) acc | flag, flags, 0),\n"));
+    }
+
+    #[test]
+    fn test_generate_string_enum() {
+        let schema = test_schema(
+            "Level",
+            "go_struct",
+            "level.go",
+            "{type: string, x-go-enum: {flags: false, values: [{name: LevelDebug, value: 0, string: debug}, {name: LevelInfo, value: 1, string: info}, {name: LevelDefault, value: 1, string: info}]}}",
+        );
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("  debug:: \"debug\",\n  info:: \"info\",\n  default:: \"info\",\n"));
+        assert!(code.contains("  values:: [\"debug\", \"info\"],\n"));
+        assert!(code.contains("  byName:: {\n    debug: 0,\n    info: 1,\n  },\n"));
+        assert!(code.contains("  byValue:: {\n    \"0\": \"debug\",\n    \"1\": \"info\",\n  },\n"));
+        assert!(code.contains("  toInt(name):: self.byName[name],\n"));
+
+        // Enums of names only have no integers
+        let schema = ExtractedSchema {
+            content: serde_yaml::from_str(
+                "{type: string, x-go-enum: {flags: false, names: true, values: [{name: DEBUG, value: 0, string: DEBUG}, {name: INFO, value: 1, string: INFO}]}}",
+            )
+            .unwrap(),
+            ..schema
+        };
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("Level is an enum encoded as the names of its values"));
+        assert!(code.contains("  values:: [\"DEBUG\", \"INFO\"],\n"));
+        assert!(!code.contains("byName"));
+    }
+
+    #[test]
+    fn test_generate_kubernetes() {
+        let schema = test_schema(
+            "Widget",
+            "go_struct",
+            "widget_types.go",
+            r#"
+type: object
+properties:
+  apiVersion: {type: string}
+  kind: {type: string}
+  metadata: {type: object}
+  spec:
+    type: object
+    properties:
+      image: {type: string, x-go-constructor-param: true}
+      replicas: {type: integer, default: 1, minimum: 1}
+      selector: {type: object, additionalProperties: {type: string}}
+  status: {type: object, readOnly: true}
+x-go-kubernetes: {apiVersion: example.com/v1, kind: Widget}
+"#,
+        );
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains(
+            "  new(name):: {\n    apiVersion: \"example.com/v1\",\n    kind: \"Widget\",\n    metadata: { name: name },\n    spec: { replicas: 1 },\n  },\n"
+        ));
+        assert!(code
+            .contains("    withNamespace(namespace):: { metadata+: { namespace: namespace } },\n"));
+        assert!(
+            code.contains("    withLabelsMixin(labels):: { metadata+: { labels+: labels } },\n")
+        );
+        assert!(code.contains("    withAnnotation(key, value)::\n      assert isQualifiedName(key) : \"annotations keys must be a qualified name such as app.kubernetes.io/name\";\n      assert std.isString(value) : \"annotations values must be strings\";\n      { metadata+: { annotations+: { [key]: value } } },\n"));
+        assert!(code.contains("local isQualifiedName(value) = matchesPattern("));
+        assert!(code.contains("      { spec+: { replicas: replicas } },\n"));
+        assert!(
+            code.contains("if \"selector\" in super then deepMerge(super[\"selector\"], selector)")
+        );
+        assert!(code.contains(
+            "    assert std.isObject(self.spec.validate(std.get(obj, \"spec\", {})));\n"
+        ));
+        assert!(!code.contains("withStatus"));
+        assert!(!code.contains("withKind"));
+
+        assert_eq!(generator.new_params(&schema), vec!["name"]);
+        assert_eq!(generator.sample_new_args(&schema), vec!["\"widget\""]);
+        let types = generator.param_types(&schema);
+        assert_eq!(types["spec.withReplicas"], vec!["integer"]);
+        assert_eq!(types["spec.validate"], vec!["object"]);
+        assert_eq!(types["metadata.withLabelsMixin"], vec!["object<string>"]);
+        assert!(!types.contains_key("withReplicas"));
+        assert!(generator.generate_tests(&schema).is_empty());
+
+        let example = generator.generate_example(&schema).unwrap();
+        assert!(example.contains("local widget = import \"../widget.libsonnet\";\n\n"));
+        assert!(example.contains("widget.new(\"widget-example\")\n+ widget.spec.withImage("));
+        assert!(!example.contains("withReplicas"));
+    }
+
+    #[test]
+    fn test_generate_references() {
+        let schema = test_schema(
+            "Order",
+            "go_struct",
+            "order.go",
+            "{properties: {billing: {type: object, x-go-ref: {file: address.libsonnet, package: example.com/shared/address, type: Address}}, updated: {type: string, x-go-ref: {package: time, type: Duration}}}, type: object}",
+        );
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("  imports:: {\n    Address: import \"address.libsonnet\",\n  },\n"));
+        assert!(code.contains(
+            "  // Address is declared in another package; build values with imports.Address\n  withBilling(billing)"
+        ));
+        assert!(!code.contains("Duration"));
+    }
+
+    #[test]
+    fn test_generate_external_references() {
+        let schema = test_schema(
+            "Job",
+            "go_struct",
+            "job.go",
+            "{properties: {template: {type: object, x-go-ref: {library: github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet, package: k8s.io/api/core/v1, path: [core, v1, podTemplateSpec], type: PodTemplateSpec}}}, type: object}",
+        );
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains(
+            "    PodTemplateSpec: (import \"github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet\").core.v1.podTemplateSpec,\n"
+        ));
+        assert!(code.contains("build values with imports.PodTemplateSpec"));
+    }
+
+    #[test]
+    fn test_generate_conversions() {
+        let resource = |version: &str, spec: &str| {
+            test_schema(
+                "Widget",
+                "go_struct",
+                &format!("api/{version}/widget_types.go"),
+                &format!(
+                    "{{properties: {{apiVersion: {{type: string}}, kind: {{type: string}}, metadata: {{type: object}}, spec: {{properties: {spec}, type: object}}, status: {{type: object}}}}, type: object, x-go-kubernetes: {{apiVersion: example.com/{version}, kind: Widget}}}}"
+                ),
+            )
+        };
+        let schemas = vec![
+            resource("v1", "{image: {type: string}, port: {type: integer}, size: {type: integer}}"),
+            resource(
+                "v1alpha1",
+                "{image: {type: string, description: Image}, legacy: {type: boolean}, size: {type: string}}",
+            ),
+            resource("v1beta1", "{image: {type: string}}"),
+        ];
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate_conversions(&schemas).unwrap();
+        let functions: Vec<&str> = code
+            .lines()
+            .filter(|line| line.ends_with("(obj)::") && line.starts_with("  v"))
+            .map(str::trim)
+            .collect();
+        assert_eq!(
+            functions,
+            vec![
+                "v1alpha1ToV1(obj)::",
+                "v1alpha1ToV1beta1(obj)::",
+                "v1beta1ToV1(obj)::"
+            ]
+        );
+        assert!(code.contains("          apiVersion: \"example.com/v1\",\n"));
+        assert!(code.contains(
+            "            [if std.objectHas(spec, \"image\") then \"image\"]: spec[\"image\"],\n"
+        ));
+        assert!(code.contains(
+            "// TODO: convert size (string in example.com/v1alpha1, integer in example.com/v1)"
+        ));
+        assert!(code.contains("// TODO: set port, which example.com/v1alpha1 does not have"));
+        assert!(code.contains("// TODO: legacy has no counterpart in example.com/v1"));
+        assert!(!code.contains("status"));
+
+        // A single version needs no conversions
+        assert!(generator.generate_conversions(&schemas[..1]).is_none());
+    }
+
+    #[test]
+    fn test_sample_new_args() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            r#"
+properties:
+  bio: {type: string}
+  email: {type: string, format: email, x-go-constructor-param: true}
+  name: {type: string, minLength: 3, x-go-constructor-param: true}
+  replicas: {type: integer, exclusiveMinimum: 0, x-go-constructor-param: true}
+  role: {type: string, enum: [admin, member], x-go-constructor-param: true}
+  tags: {type: array, minItems: 1, items: {type: string, format: uuid}, x-go-constructor-param: true}
+"#,
+        )
+        .unwrap();
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        assert_eq!(
+            GoJsonnetGenerator::new().sample_new_args(&schema),
+            vec![
+                "\"user@example.com\"",
+                "\"xxx\"",
+                "1",
+                "\"admin\"",
+                "[\"00000000-0000-0000-0000-000000000000\"]",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_generate_object_example() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            r#"
+properties:
+  bio: {type: string, default: n/a}
+  email: {type: string, format: email, x-go-constructor-param: true}
+  id: {type: string, enum: [a], readOnly: true}
+  name: {type: string, minLength: 2, x-go-constructor-param: true}
+  role: {type: string, enum: [admin, member]}
+"#,
+        )
+        .unwrap();
+        let mut schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let generator = GoJsonnetGenerator::new();
+        let example = generator.generate_example(&schema).unwrap();
+        assert!(example.starts_with("// Example User built with user.libsonnet\n"));
+        assert!(example.ends_with(
+            "local user = import \"../user.libsonnet\";\n\nuser.validate(user.new(\"user@example.com\", \"xx\")\n  .withRole(\"admin\"))\n"
+        ));
+
+        schema.content =
+            serde_yaml::from_str("{type: string, enum: [a, b], x-go-enum: {values: []}}").unwrap();
+        assert!(generator.generate_example(&schema).is_none());
+    }
+
+    #[test]
+    fn test_generate_tests() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            r#"
+properties:
+  bio: {type: string, default: n/a}
+  name: {type: string, minLength: 2, x-go-constructor-param: true}
+  role: {type: string, enum: [admin, member]}
+  tags: {type: array, items: {type: string}}
+"#,
+        )
+        .unwrap();
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let files = GoJsonnetGenerator::new().generate_tests(&schema);
+        let paths: Vec<_> = files.iter().map(|(path, _)| path.clone()).collect();
+        assert_eq!(
+            paths,
+            vec![
+                PathBuf::from("user_test.jsonnet"),
+                PathBuf::from("invalid/user/name.jsonnet"),
+                PathBuf::from("invalid/user/role.jsonnet"),
+            ]
+        );
+
+        let suite = &files[0].1;
+        assert!(suite.contains("local lib = import \"../user.libsonnet\";\n"));
+        assert!(suite.contains("local sample = lib.new(\"xx\");\n"));
+        assert!(suite.contains("    expect: { bio: \"n/a\", name: \"xx\" },\n"));
+        assert!(suite.contains("    actual: lib.validate(sample),\n"));
+        assert!(suite.contains(
+            "  testWithTagsMixin: {\n    actual: sample.withTags([\"x\"]).withTagsMixin([\"x\"]).tags,\n    expect: [\"x\", \"x\"],\n"
+        ));
+
+        let types = GoJsonnetGenerator::new().param_types(&schema);
+        assert_eq!(types["new"], vec!["string"]);
+        assert_eq!(types["withTagsMixin"], vec!["array<string>"]);
+
+        assert!(files[1].1.contains("if k != \"name\" }"));
+        assert!(files[2]
+            .1
+            .contains("lib.validate(sample + { role: \"not a valid value\" })\n"));
+    }
+
+    #[test]
+    fn test_generate_source_comments() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            "properties: {email: {type: string, description: \"Email of the user\\nUsed for notifications\", x-go-source: {type: User, field: Email, line: 12}}, port: {type: integer, default: 8080, description: Port to listen on, x-go-type: Port, x-go-source: {type: User, field: Port, line: 14}}}",
+        )
+        .unwrap();
+        let schema = ExtractedSchema {
+            metadata: [("line".to_string(), serde_yaml::Value::from(10))]
+                .into_iter()
+                .collect(),
+            ..test_schema_value("User", "go_struct", "api/user.go", content)
+        };
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.starts_with(&format!(
+            "// Generated from Go AST: User\n// Source: api/user.go:10 (type User)\n// Generator: gensonnet {GENERATOR_VERSION}\n"
+        )));
+        assert!(code.contains(
+            "  // Set the email field\n  // Email of the user\n  // Used for notifications\n  // Source: api/user.go:12 (User.Email)\n  withEmail(email)::"
+        ));
+        assert!(code.contains("    // Port to listen on\n    port: 8080,\n"));
+        assert!(code.contains("  // Source: api/user.go:14 (User.Port, type Port)\n"));
+    }
+
+    #[test]
+    fn test_generate_presence_helpers() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            "properties: {labels: {additionalProperties: {type: string}, type: object, x-go-null-setter: true, x-go-presence: true}, name: {type: string}}",
+        )
+        .unwrap();
+        let schema = test_schema_value("Filter", "go_struct", "filter.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("  isSet(obj, field):: std.objectHas(obj, field),\n"));
+        assert!(code.contains("withLabels(labels):: self + { labels::: labels },"));
+        assert!(code.contains("withLabelsMixin(labels):: self + { labels::: if"));
+        assert!(code.contains("withLabelsNull():: self + { labels::: null },"));
+        assert!(code.contains("withoutLabels():: self + { labels:: null },"));
+        assert!(code.contains("withName(name):: self + { name: name },"));
+        assert!(!code.contains("withoutName"));
+    }
+
+    #[test]
+    fn test_generate_omit_empty() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            r#"
+properties:
+  name: {type: string, x-go-constructor-param: true}
+  port: {type: integer, default: 8080, x-go-omitempty: true}
+  tags: {type: array, items: {type: string}, x-go-omitempty: true}
+  owner: {type: object, nullable: true, x-go-struct-ref: User, x-go-null-setter: true, x-go-omitempty: true}
+  limits: {type: object, x-go-struct-ref: Limits, x-go-omitempty: true}
+"#,
+        )
+        .unwrap();
+        let schema = test_schema_value("Server", "go_struct", "server.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("    name: name,\n    port:: 8080,\n"));
+        assert!(code.contains(
+            "withPort(port):: self + (if port == 0 then { port:: port } else { port::: port }),"
+        ));
+        assert!(code.contains(
+            "withTags(tags):: self + (if std.length(tags) == 0 then { tags:: tags } else { tags::: tags }),"
+        ));
+        assert!(code.contains("withTagsMixin(tags):: self + { tags+::: tags },"));
+        assert!(code.contains("(if owner == null then { owner:: owner } else { owner::: owner })"));
+        assert!(code.contains("withOwnerNull():: self + { owner:: null },"));
+        // encoding/json never leaves out a struct
+        assert!(code.contains("withLimits(limits):: self + { limits: limits },"));
+    }
+
+    #[test]
+    fn test_generate_hidden_fields() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            "properties: {name: {type: string, x-go-constructor-param: true}, token: {type: string, x-go-constructor-param: true, x-go-hidden: true}}",
+        )
+        .unwrap();
+        let schema = test_schema_value("Login", "go_struct", "login.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("    name: name,\n    token:: token,\n"));
+        assert!(code.contains("withToken(token):: self + { token:: token },"));
+        assert!(code.contains("withName(name):: self + { name: name },"));
+    }
+
+    #[test]
+    fn test_generate_defaults_library() {
+        let user = test_schema(
+            "User",
+            "go_struct",
+            "user.go",
+            "{properties: {name: {type: string, x-go-constructor-param: true}, role: {type: string, default: member, description: Role of the user}, token: {type: string, default: none, x-go-hidden: true}}, x-go-defaults-library: true}",
+        );
+        let widget = test_schema(
+            "Widget",
+            "go_struct",
+            "widget.go",
+            "{properties: {spec: {type: object, properties: {replicas: {type: integer, default: 1}}}}, x-go-kubernetes: {kind: Widget, apiVersion: acme.io/v1}, x-go-defaults-library: true}",
+        );
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&user).unwrap();
+        assert!(code.contains("  defaults:: (import \"defaults.libsonnet\")[\"User\"],\n"));
+        assert!(code.contains("  new(name):: self + self.defaults + {\n    name: name,\n  },\n"));
+        let code = generator.generate(&widget).unwrap();
+        assert!(code.contains("    metadata: { name: name },\n  } + self.defaults,\n"));
+
+        let defaults = generator
+            .generate_defaults(&[widget.clone(), user.clone()])
+            .unwrap();
+        assert!(defaults.ends_with(
+            "{\n  User: {\n    // Role of the user\n    role: \"member\",\n    token:: \"none\",\n  },\n  Widget: {\n    spec: {\n      replicas: 1,\n    },\n  },\n}\n"
+        ));
+
+        let mut inline = user;
+        inline
+            .content
+            .as_mapping_mut()
+            .unwrap()
+            .remove(DEFAULTS_LIBRARY_KEY);
+        assert!(generator.generate_defaults(&[inline.clone()]).is_none());
+        assert!(generator
+            .generate(&inline)
+            .unwrap()
+            .contains("    role: \"member\",\n"));
+    }
+
+    #[test]
+    fn test_generate_assert_valid_and_manifest() {
+        let schema =
+            |name: &str, content: &str| test_schema(name, "go_struct", "widget.go", content);
+        let widget = schema(
+            "Widget",
+            "{properties: {spec: {type: object, properties: {replicas: {type: integer, minimum: 1}}}}, x-go-kubernetes: {kind: Widget, apiVersion: acme.io/v1}}",
+        );
+        let user = schema("User", "{properties: {name: {type: string}}}");
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&widget).unwrap();
+        assert!(code.contains("  // Fail unless obj is a valid Widget, and return it\n  assertValid(obj)::\n    assert std.isObject(obj) : \"Widget values are objects, not \" + std.type(obj);\n    self.validate(obj),\n"));
+        let code = generator.generate(&user).unwrap();
+        assert!(code.contains("  assertValid(obj)::\n    assert std.isObject(obj) : \"User values are objects, not \" + std.type(obj);\n    obj,\n"));
+        assert_eq!(
+            generator.param_types(&user)["assertValid"],
+            vec!["object".to_string()]
+        );
+
+        let index = generator.generate_index(&[user, widget]);
+        assert!(index.contains("  resources:: {\n    \"acme.io/v1\": {\n      Widget: { lib:: import \"./widget.libsonnet\", assertValid:: self.lib.assertValid },\n    },\n  },\n"));
+        assert!(index.contains(
+            "  manifest(obj)::\n    if std.isArray(obj) then std.map(self.manifest, obj)\n"
+        ));
+        assert!(index.ends_with("self.manifest(value), obj),\n}\n"));
+    }
+
+    #[test]
+    fn test_generate_pattern_checks() {
+        for (format, _, pattern, _) in FORMAT_CHECKS {
+            assert!(
+                pattern.is_empty() || patterns::compile(pattern).is_some(),
+                "{format}"
+            );
+        }
+        assert!(patterns::compile(INTEGER_KEY_PATTERN).is_some());
+
+        let mut schema = test_schema(
+            "User",
+            "go_struct",
+            "user.go",
+            r"{properties: {email: {type: string, format: email}, code: {type: string, pattern: '^[A-Z]{3}$'}}}",
+        );
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("local patterns = {\n  \"^[A-Z]{3}$\": { seq: [{ start: true }, { repeat: { set: \"ABCDEFGHIJKLMNOPQRSTUVWXYZ\" }, min: 3, max: 3 }, { end: true }] },\n"));
+        assert!(code.contains(patterns::MATCHES_PATTERN_HELPER));
+        assert!(code.contains("local isEmail(value) = matchesPattern(\"^[^@\\\\s]+@"));
+        assert!(code.contains(
+            "assert matchesPattern(\"^[A-Z]{3}$\", code) : \"code must match pattern ^[A-Z]{3}$\""
+        ));
+
+        schema
+            .content
+            .as_mapping_mut()
+            .unwrap()
+            .insert(PATTERN_CHECKS_KEY.into(), "native".into());
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains(patterns::NATIVE_MATCHES_PATTERN_HELPER));
+        assert!(!code.contains("local patterns"));
+
+        // Native functions when they are registered, string checks otherwise
+        schema.content = serde_yaml::from_str(
+            r"{x-go-pattern-checks: auto, properties: {version: {type: string, format: semver}, subnets: {type: array, items: {type: string, format: cidr}}}}",
+        )
+        .unwrap();
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains(patterns::AUTO_MATCHES_PATTERN_HELPER));
+        assert!(code.contains("local semverCompare = std.native('semverCompare');\nlocal isSemver(value) = if semverCompare == null then matchesPattern(\"^(0|[1-9][0-9]*)"));
+        assert!(code.contains(
+            "\", value) else std.isString(value) && semverCompare(value, value) != null;\n"
+        ));
+        assert_eq!(
+            code.matches("local parseCidr = std.native('parseCidr');")
+                .count(),
+            1
+        );
+        assert!(code.contains("cidr != null && cidr.version == 6;\n"));
+        assert!(code.contains("local isCidr(value) = isCidrv4(value) || isCidrv6(value);\n"));
+        assert!(code.contains("must be a CIDR block"));
+    }
+
+    #[test]
+    fn test_evaluate_pattern_checks() {
+        let schema = test_schema(
+            "User",
+            "go_struct",
+            "user.go",
+            r"{properties: {email: {type: string, format: email}, code: {type: string, pattern: '^[A-Z]{3}$'}, address: {type: string, format: ip}}}",
+        );
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        let files = [("user.libsonnet", code.as_str())];
+        let Some(result) = evaluate(
+            &files,
+            "local user = import 'user.libsonnet'; user.new().withEmail('ada@example.com').withCode('ABC').withAddress('10.0.0.1')",
+        ) else {
+            return;
+        };
+        assert_eq!(
+            result.unwrap(),
+            "{\n   \"address\": \"10.0.0.1\",\n   \"code\": \"ABC\",\n   \"email\": \"ada@example.com\"\n}"
+        );
+
+        for (call, message) in [
+            (
+                "user.new().withCode('AB1')",
+                "code must match pattern ^[A-Z]{3}$",
+            ),
+            (
+                "user.new().withEmail('ada')",
+                "email must be an email address",
+            ),
+            (
+                "user.validate({ address: '10.0.0.300' })",
+                "address must be an IP address",
+            ),
+        ] {
+            let error = evaluate(
+                &files,
+                &format!("local user = import 'user.libsonnet'; {call}"),
+            )
+            .unwrap()
+            .unwrap_err();
+            assert!(error.to_string().contains(message), "{call}: {error}");
+        }
+    }
+
+    #[test]
+    fn test_generate_metadata_maps() {
+        let schema = test_schema(
+            "Deployment",
+            "go_struct",
+            "deployment.go",
+            "{properties: {labels: {type: object, additionalProperties: {type: string}, x-go-map-key: {type: string, format: qualified-name}, x-go-metadata-map: labels}}}",
+        );
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains("  // Set one entry of the labels field\n  withLabel(key, value)::\n    assert isQualifiedName(key) : \"labels keys must be a qualified name such as app.kubernetes.io/name\";\n"));
+        assert!(code.contains("    self + { labels+: { [key]: value } },\n"));
+        assert!(code.contains("  withLabelsMixin(labels)::"));
+        assert!(code.contains("    assert std.all([isQualifiedName(k) for k in std.objectFields(labels)]) : \"labels keys must be a qualified name such as app.kubernetes.io/name\";\n"));
+        assert_eq!(
+            generator.param_types(&schema)["withLabel"],
+            vec!["string", "string"]
+        );
+
+        let labels = |key: &str| patterns::tests::matches(QUALIFIED_NAME_PATTERN, key);
+        assert!(labels("app.kubernetes.io/name") && labels("tier") && labels("a_b.c-d"));
+        assert!(!labels("-tier") && !labels("Example.com/x") && !labels(&"x".repeat(64)));
+    }
+
+    #[test]
+    fn test_generate_unit_helpers() {
+        let schema = test_schema(
+            "Limits",
+            "go_struct",
+            "limits.go",
+            "{properties: {memory: {type: string, format: quantity}, timeout: {type: string, format: duration}, timeoutSeconds: {type: integer}, maxBodyBytes: {type: integer}, bytes: {type: integer}}}",
+        );
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains("  // Set the memory field to a number of mebi units\n  withMemoryMi(value)::\n    assert std.isNumber(value) : \"withMemoryMi takes a number\";\n    self.withMemory(std.toString(value) + 'Mi'),\n"));
+        assert!(code.contains("  withMemoryMilli(value)::"));
+        assert!(code.contains("  withTimeoutMinutes(value)::\n    assert std.isNumber(value) : \"withTimeoutMinutes takes a number\";\n    self.withTimeout(std.toString(value) + 'm'),\n"));
+        assert!(code.contains("  withMaxBodyGi(value)::\n    assert std.isNumber(value) : \"withMaxBodyGi takes a number\";\n    self.withMaxBodyBytes(value * 1073741824),\n"));
+        assert!(code.contains(
+            "assert isQuantity(memory) : \"memory must be a quantity such as 512Mi or 250m\""
+        ));
+
+        // The setter of timeoutSeconds is not replaced, and its helpers
+        // are taken by the duration
+        assert_eq!(code.matches("withTimeoutSeconds(").count(), 1);
+        assert_eq!(code.matches("withTimeoutMinutes(").count(), 1);
+        assert!(!code.contains("withKi"));
+
+        let types = generator.param_types(&schema);
+        assert_eq!(types["withMemoryGi"], vec!["number"]);
+        assert_eq!(types["withTimeoutSeconds"], vec!["integer"]);
+    }
+
+    #[test]
+    fn test_generate_field_visibility() {
+        let schema = test_schema(
+            "User",
+            "go_struct",
+            "user.go",
+            "{properties: {name: {type: string, x-go-constructor-param: true}, role: {type: string, default: member}, tags: {type: array, items: {type: string}}, email: {type: string}, token: {type: string, default: none, x-go-hidden: false}}, x-go-visibility: {defaults: hidden, mixins: hidden}}",
+        );
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("    name: name,\n    role:: \"member\",\n    token: \"none\",\n"));
+        assert!(code.contains("  withTags(tags):: self + { tags:: tags },\n"));
+        assert!(code.contains("  withTagsMixin(tags):: self + { tags+:: tags },\n"));
+        assert!(code.contains("  withEmail(email):: self + { email: email },\n"));
+    }
+
+    #[test]
+    fn test_generate_read_only_fields() {
+        let content: serde_yaml::Value = serde_yaml::from_str(
+            "properties: {createdAt: {type: string, format: date-time, description: When the order was placed, readOnly: true, x-go-constructor-param: true}, items: {type: array, items: {type: string}, readOnly: true}, name: {type: string, x-go-constructor-param: true}, state: {type: string, default: pending, readOnly: true}}",
+        )
+        .unwrap();
+        let schema = test_schema_value("Order", "go_struct", "order.go", content);
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains("  new(name):: self + {\n    name: name,\n  },\n"));
+        assert!(code.contains(
+            "  // createdAt is read-only: it is set by the server and has no setter\n  // When the order was placed\n"
+        ));
+        assert!(!code.contains("withCreatedAt"));
+        assert!(!code.contains("withItems"));
+        assert!(!code.contains("withState"));
+
+        assert_eq!(generator.new_params(&schema), vec!["name"]);
+        let types = generator.param_types(&schema);
+        assert!(types.contains_key("withName"));
+        assert!(!types.contains_key("withItemsMixin"));
+        let files = generator.generate_tests(&schema);
+        assert!(files[0].1.contains("    expect: { name: \"x\" },\n"));
+    }
+
+    #[test]
+    fn test_generate_snake_case_helpers() {
+        let content: serde_yaml::Value = serde_yaml::from_str(concat!(
+            "properties: {",
+            "avatarUrl: {type: string, x-go-null-setter: true, x-go-presence: true, x-go-renamed-from: [avatar]}, ",
+            "podConditions: {type: array, x-go-conditions: true}",
+            "}\n",
+            "x-go-function-naming: snake_case\n",
+        ))
+        .unwrap();
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains("  is_set(obj, field)::"));
+        assert!(
+            code.contains("  with_avatar_url(avatarUrl):: self + { avatarUrl::: avatarUrl },\n")
+        );
+        assert!(code.contains("  with_avatar_url_null():: self + { avatarUrl::: null },\n"));
+        assert!(code.contains("  without_avatar_url():: self + { avatarUrl:: null },\n"));
+        assert!(code.contains("  with_avatar(avatar):: self.with_avatar_url(avatar),\n"));
+        assert!(code.contains("  with_pod_conditions_mixin(podConditions)::"));
+        assert!(code.contains("  with_pod_condition(type, status,"));
+        assert!(code.contains("  has_pod_condition(type)::"));
+
+        let types = generator.param_types(&schema);
+        assert!(types.contains_key("with_avatar"));
+        assert!(types.contains_key("with_pod_conditions_mixin"));
+        assert!(types.contains_key("has_pod_condition"));
+
+        let tests = generator.generate_tests(&schema);
+        assert!(tests[0].1.contains("  test_new: {\n"));
+        assert!(tests[0].1.contains("  test_with_pod_conditions_mixin: {\n"));
+    }
+
+    #[test]
+    fn test_generate_validation_rules() {
+        let mut properties = serde_yaml::Mapping::new();
+        for (name, rules) in [
+            ("name", "required,min=2"),
+            ("email", "omitempty,email"),
+            ("role", "oneof=admin member"),
+            ("tags", "dive,uuid"),
+        ] {
+            let mut property = serde_yaml::Mapping::new();
+            let schema_type = if name == "tags" { "array" } else { "string" };
+            property.insert("type".into(), schema_type.into());
+            if name == "tags" {
+                let mut items = serde_yaml::Mapping::new();
+                items.insert("type".into(), "string".into());
+                property.insert("items".into(), items.into());
+            }
+            if name == "name" {
+                property.insert("x-go-constructor-param".into(), true.into());
+            }
+            crate::plugin::ast::validate::ValidateRules::parse(rules).apply(&mut property);
+            properties.insert(name.into(), property.into());
+        }
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("local isEmail(value) ="));
+        assert!(code.contains("local isUuid(value) ="));
+        assert!(code.contains(
+            "    assert std.length(name) >= 2 : \"name must have at least 2 characters\";\n"
+        ));
+        assert!(code.contains(
+            "    assert email == '' || isEmail(email) : \"email must be an email address\";\n"
+        ));
+        assert!(code.contains(
+            "    assert std.member([\"admin\", \"member\"], role) : \"role must be one of admin, member\";\n"
+        ));
+        assert!(code.contains(
+            "    assert std.all([isUuid(e) for e in tags]) : \"tags[] must be a UUID\";\n"
+        ));
+        assert!(code.contains("  validate(obj)::\n"));
+        assert!(code.contains("    assert std.objectHas(obj, \"name\") : \"name is required\";\n"));
+        assert!(code.contains(
+            "    assert !std.objectHas(obj, \"email\") || (obj.email == '' || isEmail(obj.email)) : \"email must be an email address\";\n"
+        ));
+    }
+
+    #[test]
+    fn test_generate_cross_field_rules() {
+        let mut properties = serde_yaml::Mapping::new();
+        for (name, rules) in [
+            ("minAge", "omitempty"),
+            ("maxAge", "omitempty,gtefield=minAge"),
+            ("sort", "required_without=query"),
+        ] {
+            let mut property = serde_yaml::Mapping::new();
+            property.insert("type".into(), "integer".into());
+            crate::plugin::ast::validate::ValidateRules::parse(rules).apply(&mut property);
+            properties.insert(name.into(), property.into());
+        }
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+
+        let schema = test_schema_value("UserFilter", "go_struct", "filter.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(!code.contains("std.objectHas(self"));
+        assert!(code.contains(
+            "    assert !(std.objectHas(obj, \"maxAge\") && std.objectHas(obj, \"minAge\")) || obj.maxAge >= obj.minAge : \"maxAge must be at least minAge\";\n"
+        ));
+
+        // The library and objects being built are not checked, only validate()
+        let files = [("filter.libsonnet", code.as_str())];
+        let evaluate = |call: &str| {
+            evaluate(
+                &files,
+                &format!("local filter = import 'filter.libsonnet'; {call}"),
+            )
+        };
+        let Some(result) = evaluate("filter.new().withMaxAge(20).withMinAge(30)") else {
+            return;
+        };
+        assert_eq!(
+            result.unwrap(),
+            "{\n   \"maxAge\": 20,\n   \"minAge\": 30\n}"
+        );
+        assert_eq!(
+            evaluate("filter.validate(filter.new().withMinAge(1).withMaxAge(5).withSort(1))")
+                .unwrap()
+                .unwrap(),
+            "{\n   \"maxAge\": 5,\n   \"minAge\": 1,\n   \"sort\": 1\n}"
+        );
+        for (call, message) in [
+            (
+                "filter.validate(filter.new().withMinAge(30).withMaxAge(20).withSort(1))",
+                "maxAge must be at least minAge",
+            ),
+            (
+                "filter.assertValid(filter.new())",
+                "sort is required when query is not set",
+            ),
+        ] {
+            let error = evaluate(call).unwrap().unwrap_err();
+            assert!(error.to_string().contains(message), "{call}: {error}");
+        }
+    }
+
+    #[test]
+    fn test_generate_mixins() {
+        let mut roles = serde_yaml::Mapping::new();
+        roles.insert("type".into(), "array".into());
+        let mut values = serde_yaml::Mapping::new();
+        values.insert("type".into(), "string".into());
+        roles.insert("items".into(), values.clone().into());
+        let mut attributes = serde_yaml::Mapping::new();
+        attributes.insert("type".into(), "object".into());
+        attributes.insert("additionalProperties".into(), values.into());
+        let mut settings = serde_yaml::Mapping::new();
+        settings.insert("type".into(), "object".into());
+
+        let mut properties = serde_yaml::Mapping::new();
+        properties.insert("roles".into(), roles.into());
+        properties.insert("attributes".into(), attributes.into());
+        properties.insert("settings".into(), settings.into());
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.starts_with(&format!(
+            "// Generated from Go AST: User\n// Source: user.go\n// Generator: gensonnet {GENERATOR_VERSION}\n\nlocal deepMerge(a, b) =\n"
+        )));
+        assert!(code.contains("  withRoles(roles):: self + { roles: roles },\n"));
+        assert!(code.contains("  withRolesMixin(roles):: self + { roles+: roles },\n"));
+        assert!(code.contains(
+            "  withAttributesMixin(attributes):: self + { attributes: if \"attributes\" in super then deepMerge(super[\"attributes\"], attributes) else attributes },\n"
+        ));
+        assert!(!code.contains("withSettingsMixin"));
+
+        // Checked merges name the path of the replaced value
+        for (mode, helper) in [
+            ("assert", ASSERTED_MERGE_HELPER),
+            ("trace", TRACED_MERGE_HELPER),
+        ] {
+            let mut checked = schema.clone();
+            checked
+                .content
+                .as_mapping_mut()
+                .unwrap()
+                .insert(MERGE_CHECKS_KEY.into(), mode.into());
+            let code = GoJsonnetGenerator::new().generate(&checked).unwrap();
+            assert!(code.contains(helper));
+            assert!(code.contains(
+                "  withAttributesMixin(attributes):: self + { attributes: if \"attributes\" in super then deepMerge(super[\"attributes\"], attributes, \"attributes\") else attributes },\n"
+            ));
+        }
+        assert!(ASSERTED_MERGE_HELPER.contains("assert a == null || b == null"));
+        assert!(TRACED_MERGE_HELPER.contains("std.trace("));
+    }
+
+    #[test]
+    fn test_generate_env_setters() {
+        let mut schema = test_schema(
+            "Server",
+            "go_struct",
+            "server.go",
+            r"{x-go-env-setters: true, properties: {host: {type: string}, port: {type: integer}, debug: {type: boolean}, tags: {type: array, items: {type: string}}, tls: {type: object, x-go-ref: TLS}}}",
+        );
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains(
+            "\n  // Set the host field from the external variable named varName\n  withHostFromEnv(varName):: self.withHost(std.extVar(varName)),\n"
+        ));
+        assert!(code.contains(
+            "  withPortFromEnv(varName)::\n    local value = std.extVar(varName);\n    self.withPort(if std.isString(value) then std.parseJson(value) else value),\n"
+        ));
+        assert!(code.contains("  withDebugFromEnv(varName)::\n"));
+        assert!(!code.contains("withTagsFromEnv"));
+        assert!(!code.contains("withTlsFromEnv"));
+        assert_eq!(
+            generator.param_types(&schema)["withPortFromEnv"],
+            vec!["string"]
+        );
+
+        // Kubernetes resources get them among the spec helpers
+        schema.content = serde_yaml::from_str(
+            r"{x-go-env-setters: true, x-go-kubernetes: {kind: Widget, apiVersion: acme.io/v1}, properties: {spec: {type: object, properties: {replicas: {type: integer}}}}}",
+        )
+        .unwrap();
+        assert_eq!(
+            generator.param_types(&schema)["spec.withReplicasFromEnv"],
+            vec!["string"]
+        );
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains("    withReplicasFromEnv(varName)::\n      local value = std.extVar(varName);\n      self.withReplicas(if std.isString(value) then std.parseJson(value) else value),\n"));
+
+        schema.content = serde_yaml::from_str(r"{properties: {host: {type: string}}}").unwrap();
+        assert!(!generator.generate(&schema).unwrap().contains("FromEnv"));
+    }
+
+    #[test]
+    fn test_generate_nested_collections() {
+        let schema = test_schema(
+            "Board",
+            "go_struct",
+            "board.go",
+            "{properties: {grid: {type: array, items: {type: array, items: {type: string}}}, \
+             teams: {type: object, additionalProperties: {type: array, items: {type: object, nullable: true}}}, \
+             shapes: {type: object, additionalProperties: {type: array, items: {type: object, x-go-interface-ref: Shape}}}}}",
+        );
+
+        let generator = GoJsonnetGenerator::new();
+        let types = generator.param_types(&schema);
+        assert_eq!(types["withGridMixin"], vec!["array<array<string>>"]);
+        assert_eq!(types["withTeams"], vec!["object<array<object>>"]);
+
+        let code = generator.generate(&schema).unwrap();
+        assert!(code.contains("  // Shape is an interface; build values with shape.libsonnet\n"));
+        assert!(code.contains("  withGridMixin(grid):: self + { grid+: grid },\n"));
+
+        let files = generator.generate_tests(&schema);
+        assert!(files[0].1.contains(
+            "    actual: sample.withGrid([[]]).withGridMixin([[]]).grid,\n    expect: [[], []],\n"
+        ));
+    }
+
+    #[test]
+    fn test_generate_map_keys() {
+        let schema = test_schema(
+            "Pool",
+            "go_struct",
+            "pool.go",
+            "{properties: {replicas: {type: object, additionalProperties: {type: string}, x-go-map-key: {type: integer}}, \
+             limits: {type: object, additionalProperties: {type: integer}, x-go-map-key: {type: string, enum: [cpu, memory]}}}}",
+        );
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains(
+            "std.all([matchesPattern(\"^(0|-?[1-9][0-9]*)$\", k) for k in std.objectFields(replicas)]) : \"replicas keys must be integers\""
+        ));
+        assert!(code.contains(
+            "  withReplicasEntry(key, value)::\n    assert (std.isNumber(key) && std.floor(key) == key) || matchesPattern(\"^(0|-?[1-9][0-9]*)$\", key) : \"replicas keys must be integers\";\n    self + { replicas+: { [std.toString(key)]: value } },\n"
+        ));
+        assert!(code.contains(
+            "std.all([std.member([\"cpu\", \"memory\"], k) for k in std.objectFields(limits)]) : \"limits keys must be one of cpu, memory\""
+        ));
+        assert!(!code.contains("withLimitsEntry"));
+    }
+
+    #[test]
+    fn test_generate_renamed_setters() {
+        let mut emails = serde_yaml::Mapping::new();
+        emails.insert("type".into(), "array".into());
+        emails.insert("x-go-renamed-from".into(), vec!["emails"].into());
+        let mut properties = serde_yaml::Mapping::new();
+        properties.insert("addresses".into(), emails.into());
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let code = GoJsonnetGenerator::new().generate(&schema).unwrap();
+        assert!(code.contains("  // Deprecated: emails was renamed to addresses\n"));
+        assert!(code.contains("  withEmails(emails):: self.withAddresses(emails),\n"));
+        assert!(code.contains("  withEmailsMixin(emails):: self.withAddressesMixin(emails),\n"));
+
+        assert_eq!(
+            renamed_setters(&code),
+            vec![
+                ("withEmails".to_string(), "withAddresses".to_string()),
+                (
+                    "withEmailsMixin".to_string(),
+                    "withAddressesMixin".to_string()
+                ),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_add_tombstones() {
+        let mut replacing = serde_yaml::Mapping::new();
+        replacing.insert("type".into(), "string".into());
+        replacing.insert("x-go-replaces".into(), vec!["nickname"].into());
+        let mut properties = serde_yaml::Mapping::new();
+        properties.insert("displayName".into(), replacing.into());
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+
+        let schema = test_schema_value("User", "go_struct", "user.go", content);
+
+        let generator = GoJsonnetGenerator::new();
+        let previous = "{\n  new():: self + {},\n  withNickname(nickname):: self + { nickname: nickname },\n  withAge(age):: self + { age: age },\n}\n";
+        let code = generator.generate(&schema).unwrap();
+
+        let first = generator.add_tombstones(&schema, &code, previous, Some("v13"));
+        assert!(first.contains(
+            "  // Tombstone for v13: withNickname was removed\n  withNickname(nickname):: error \"withNickname was removed in v13; use withDisplayName\",\n"
+        ));
+        assert!(first.contains("  withAge(age):: error \"withAge was removed in v13\",\n"));
+        assert!(first.ends_with("}\n"));
+
+        // Carried over within the release, dropped by the next one
+        let second = generator.add_tombstones(&schema, &code, &first, Some("v13"));
+        assert_eq!(second, first);
+        let third = generator.add_tombstones(&schema, &code, &second, Some("v14"));
+        assert_eq!(third, code);
+    }
+
+    #[test]
+    fn test_generate_index() {
+        let schema = |name: &str, categories: &[&str], summary: Option<&str>| {
+            let mut metadata = std::collections::HashMap::new();
+            metadata.insert("package".to_string(), "api".into());
+            metadata.insert("import_path".to_string(), "github.com/acme/api".into());
+            metadata.insert(
+                "categories".to_string(),
+                categories
+                    .iter()
+                    .map(|c| serde_yaml::Value::from(*c))
+                    .collect(),
+            );
+            if let Some(summary) = summary {
+                metadata.insert("summary".to_string(), summary.into());
+            }
+            ExtractedSchema {
+                metadata,
+                ..test_schema_value(name, "go_struct", "user.go", serde_yaml::Value::Null)
+            }
+        };
+        let schemas = vec![
+            schema("User", &["identity"], Some("User is an account.")),
+            schema("Config", &[], None),
+        ];
+
+        let generator = GoJsonnetGenerator::new();
+        let code = generator.generate_index(&schemas);
+        assert!(code.contains("    api: {\n      importPath: \"github.com/acme/api\",\n"));
+        assert!(code.contains(
+            "        identity: {\n          User: {\n            description: \"User is an account.\",\n"
+        ));
+        assert!(code.contains("            lib:: import \"./user.libsonnet\",\n"));
+        assert!(code.contains("        uncategorized: {\n          Config: {\n"));
+
+        let json: serde_json::Value =
+            serde_json::from_str(&generator.generate_index_json(&schemas).unwrap()).unwrap();
+        let user = &json["packages"]["api"]["categories"]["identity"]["User"];
+        assert_eq!(user["importPath"], "github.com/acme/api");
+        assert_eq!(user["file"], "user.libsonnet");
+        assert_eq!(
+            json["packages"]["api"]["categories"]["uncategorized"]["Config"]["description"],
+            ""
+        );
+        assert_eq!(user["kind"], "alias");
+
+        let mut schemas = schemas;
+        schemas[0].content = serde_yaml::from_str(
+            "{properties: {groups: {items: {type: object, x-go-struct-ref: Group}, type: array}, name: {description: Display name, maxLength: 64, minLength: 1, type: string, x-go-constructor-param: true}, role: {default: member, enum: [admin, member], type: string}}, type: object}",
+        )
+        .unwrap();
+        schemas[1].content = serde_yaml::from_str("{enum: [dev, prod], type: string}").unwrap();
+        let json: serde_json::Value =
+            serde_json::from_str(&generator.generate_index_json(&schemas).unwrap()).unwrap();
+        let user = &json["packages"]["api"]["categories"]["identity"]["User"];
+        assert_eq!(user["kind"], "struct");
+        assert_eq!(
+            user["fields"]["name"],
+            serde_json::json!({
+                "type": "string",
+                "description": "Display name",
+                "required": true,
+                "constraints": { "minLength": 1, "maxLength": 64 },
+            })
+        );
+        assert_eq!(user["fields"]["groups"]["type"], "array<object>");
+        assert_eq!(user["fields"]["groups"]["ref"], "Group");
+        assert_eq!(user["fields"]["role"]["default"], "member");
+        assert_eq!(
+            user["fields"]["role"]["enum"],
+            serde_json::json!(["admin", "member"])
+        );
+        let config = &json["packages"]["api"]["categories"]["uncategorized"]["Config"];
+        assert_eq!(config["kind"], "enum");
+        assert_eq!(config["enum"], serde_json::json!(["dev", "prod"]));
+    }
+
+    #[test]
+    fn test_yaml_to_jsonnet() {
+        let value: serde_yaml::Value =
+            serde_yaml::from_str("{a: 1, b: [true, null], c: 'say \"hi\"'}").unwrap();
+        assert_eq!(
+            yaml_to_jsonnet(&value),
+            r#"{a: 1, b: [true, null], c: "say \"hi\""}"#
+        );
+    }
+}
@@ -0,0 +1,81 @@
+//! Go module resolution
+//!
+//! Maps source directories to Go import paths using the `module` directive of
+//! the nearest `go.mod`.
+
+use std::path::Path;
+
+/// Get the module path declared in the contents of a `go.mod` file
+pub fn module_path(go_mod: &str) -> Option<&str> {
+    go_mod.lines().find_map(|line| {
+        let line = line.split("//").next().unwrap_or(line).trim();
+        let path = line.strip_prefix("module")?;
+        if !path.starts_with(char::is_whitespace) {
+            return None;
+        }
+        let path = path.trim().trim_matches('"');
+        (!path.is_empty()).then_some(path)
+    })
+}
+
+/// Get the import path of the package in `dir`
+///
+/// Looks for a `go.mod` in `dir` and its parents, stopping at `root`. Returns
+/// `None` when no module is found.
+pub fn import_path(root: &Path, dir: &Path) -> Option<String> {
+    let mut current = dir;
+    loop {
+        if let Ok(go_mod) = std::fs::read_to_string(current.join("go.mod")) {
+            let module = module_path(&go_mod)?;
+            let relative = dir.strip_prefix(current).ok()?;
+            let mut path = module.to_string();
+            for component in relative.components() {
+                path.push('/');
+                path.push_str(&component.as_os_str().to_string_lossy());
+            }
+            return Some(path);
+        }
+
+        if current == root {
+            return None;
+        }
+        current = current.parent()?;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_module_path() {
+        assert_eq!(
+            module_path("module github.com/acme/api\n\ngo 1.21\n"),
+            Some("github.com/acme/api")
+        );
+        assert_eq!(
+            module_path("// comment\nmodule \"example.com/m\" // quoted\n"),
+            Some("example.com/m")
+        );
+        assert_eq!(module_path("go 1.21\n"), None);
+        assert_eq!(module_path("modules foo\n"), None);
+    }
+
+    #[test]
+    fn test_import_path() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        let package_dir = root.join("pkg/api/v1");
+        std::fs::create_dir_all(&package_dir).unwrap();
+        std::fs::write(root.join("go.mod"), "module github.com/acme/svc\n").unwrap();
+
+        assert_eq!(
+            import_path(root, &package_dir).as_deref(),
+            Some("github.com/acme/svc/pkg/api/v1")
+        );
+        assert_eq!(
+            import_path(root, root).as_deref(),
+            Some("github.com/acme/svc")
+        );
+    }
+}
@@ -0,0 +1,327 @@
+//! Dependencies fetched through the Go module proxy
+//!
+//! Fields may be typed with packages of modules outside the repository, such
+//! as `k8s.io/apimachinery`. With `fetch_dependencies`, the module of such a
+//! package is downloaded at the version required by the repository's
+//! `go.mod` and the referenced types are generated from it. Downloads go
+//! through `go mod download`, so `GOPROXY`, `GOPRIVATE`, `GONOSUMDB` and the
+//! other settings of `go env` apply, as does the module cache.
+//!
+//! A repository vendoring its dependencies has the packages in `vendor/`
+//! already. They are used first, as `go build` does, so hermetic builds
+//! without access to the proxy generate the same types.
+
+use anyhow::{anyhow, Result};
+use serde::Deserialize;
+use std::collections::BTreeSet;
+use std::path::{Path, PathBuf};
+
+use super::gowork::WorkspaceModule;
+use crate::plugin::ExtractedSchema;
+
+/// A module required by `go.mod`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Requirement {
+    pub path: String,
+    pub version: String,
+}
+
+/// Output of `go mod download -json`
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct Download {
+    #[serde(default)]
+    dir: Option<PathBuf>,
+    #[serde(default)]
+    error: Option<String>,
+}
+
+/// Get the modules of the `require` directives of a `go.mod` file
+///
+/// Modules replaced by a local directory are left out, since they are not
+/// downloaded.
+pub fn requirements(go_mod: &str) -> Vec<Requirement> {
+    let mut replaced = BTreeSet::new();
+    let mut required = Vec::new();
+    let mut block: Option<&str> = None;
+    for line in go_mod.lines() {
+        let line = line.split("//").next().unwrap_or(line).trim();
+        let spec = match block {
+            Some(_) if line == ")" => {
+                block = None;
+                continue;
+            }
+            Some(directive) => Some((directive, line)),
+            None => {
+                let Some((directive, rest)) = line.split_once(char::is_whitespace) else {
+                    continue;
+                };
+                let rest = rest.trim();
+                if rest == "(" {
+                    block = Some(directive);
+                    continue;
+                }
+                Some((directive, rest))
+            }
+        };
+
+        let Some((directive, spec)) = spec else {
+            continue;
+        };
+        let words: Vec<&str> = spec.split_whitespace().collect();
+        match (directive, words.as_slice()) {
+            ("require", [path, version]) => required.push(Requirement {
+                path: path.trim_matches('"').to_string(),
+                version: version.to_string(),
+            }),
+            ("replace", [path, .., "=>", target])
+                if target.starts_with('.') || target.starts_with('/') =>
+            {
+                replaced.insert(path.trim_matches('"').to_string());
+            }
+            _ => {}
+        }
+    }
+
+    required.retain(|requirement| !replaced.contains(&requirement.path));
+    required
+}
+
+/// Find the required module providing a package
+///
+/// The module with the longest matching path wins, as with nested modules.
+pub fn module_of<'a>(package: &str, requirements: &'a [Requirement]) -> Option<&'a Requirement> {
+    requirements
+        .iter()
+        .filter(|requirement| in_module(package, &requirement.path))
+        .max_by_key(|requirement| requirement.path.len())
+}
+
+/// Get the references to types that are not generated, as package and type
+///
+/// Packages of the standard library and of the workspace are left out; the
+/// former have no module to fetch and the latter are reported by
+/// `resolve_references`.
+pub fn missing_references(
+    schemas: &[ExtractedSchema],
+    workspace: &[WorkspaceModule],
+) -> BTreeSet<(String, String)> {
+    let generated: BTreeSet<(String, String)> = schemas
+        .iter()
+        .filter_map(|schema| {
+            let import_path = schema.metadata.get("import_path")?.as_str()?;
+            Some((import_path.to_string(), schema.name.clone()))
+        })
+        .collect();
+
+    let mut missing = BTreeSet::new();
+    for schema in schemas {
+        if let Some(properties) = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+        {
+            for property in properties.values() {
+                collect_references(property, &mut missing);
+            }
+        }
+    }
+    missing.retain(|(package, type_name)| {
+        !generated.contains(&(package.clone(), type_name.clone()))
+            && !is_standard_library(package)
+            && !workspace
+                .iter()
+                .any(|module| in_module(package, &module.path))
+    });
+    missing
+}
+
+/// Collect the references of a property and of its items and values
+fn collect_references(property: &serde_yaml::Value, references: &mut BTreeSet<(String, String)>) {
+    for key in ["items", "additionalProperties"] {
+        if let Some(inner) = property.get(key) {
+            collect_references(inner, references);
+        }
+    }
+    let reference = property.get("x-go-ref").and_then(|r| {
+        if r.get("library").is_some() {
+            return None;
+        }
+        Some((
+            r.get("package")?.as_str()?.to_string(),
+            r.get("type")?.as_str()?.to_string(),
+        ))
+    });
+    references.extend(reference);
+}
+
+/// Check whether a package belongs to a module
+fn in_module(package: &str, module: &str) -> bool {
+    package
+        .strip_prefix(module)
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with('/'))
+}
+
+/// Check whether a package is part of the standard library
+///
+/// Module paths start with a domain name, standard packages have no dot in
+/// their first element.
+fn is_standard_library(package: &str) -> bool {
+    !package.split('/').next().unwrap_or(package).contains('.')
+}
+
+/// Download a module with `go mod download` and get its directory
+///
+/// `go` is taken from PATH. The environment is passed on, so proxy and
+/// checksum settings apply.
+pub fn download(requirement: &Requirement) -> Result<PathBuf> {
+    let module = format!("{}@{}", requirement.path, requirement.version);
+    let output = std::process::Command::new("go")
+        .args(["mod", "download", "-json", &module])
+        .output()
+        .map_err(|e| anyhow!("Failed to run go mod download for {}: {}", module, e))?;
+
+    // Failures are reported in the JSON output as well as on stderr
+    let download: Option<Download> = serde_json::from_slice(&output.stdout).ok();
+    match download {
+        Some(Download {
+            dir: Some(dir),
+            error: None,
+        }) if output.status.success() => Ok(dir),
+        Some(Download {
+            error: Some(error), ..
+        }) => Err(anyhow!("Failed to download {}: {}", module, error)),
+        _ => Err(anyhow!(
+            "Failed to download {}: {}",
+            module,
+            String::from_utf8_lossy(&output.stderr).trim_end()
+        )),
+    }
+}
+
+/// Get the directory of a package in the `vendor/` directory of a repository
+///
+/// Like `go build`, only a `vendor/` directory with a `modules.txt` counts.
+pub fn vendored_package(root: &Path, package: &str) -> Option<PathBuf> {
+    let vendor = root.join("vendor");
+    if !vendor.join("modules.txt").is_file() {
+        return None;
+    }
+    let dir = vendor.join(package);
+    dir.is_dir().then_some(dir)
+}
+
+/// Get the directory of a package inside its downloaded module
+pub fn package_dir(module_dir: &Path, requirement: &Requirement, package: &str) -> PathBuf {
+    let relative = package
+        .strip_prefix(&requirement.path)
+        .unwrap_or_default()
+        .trim_start_matches('/');
+    if relative.is_empty() {
+        module_dir.to_path_buf()
+    } else {
+        module_dir.join(relative)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn requirement(path: &str, version: &str) -> Requirement {
+        Requirement {
+            path: path.to_string(),
+            version: version.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_requirements() {
+        let go_mod = "module example.com/api\n\ngo 1.22\n\nrequire k8s.io/apimachinery v0.30.1\n\nrequire (\n\tgithub.com/acme/money v1.2.0 // indirect\n\texample.com/local v0.0.0\n)\n\nreplace example.com/local => ../local\n";
+        assert_eq!(
+            requirements(go_mod),
+            vec![
+                requirement("k8s.io/apimachinery", "v0.30.1"),
+                requirement("github.com/acme/money", "v1.2.0"),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_module_of() {
+        let requirements = vec![
+            requirement("k8s.io/apimachinery", "v0.30.1"),
+            requirement("github.com/acme/sdk", "v1.0.0"),
+            requirement("github.com/acme/sdk/v2", "v2.1.0"),
+        ];
+        assert_eq!(
+            module_of("k8s.io/apimachinery/pkg/apis/meta/v1", &requirements),
+            Some(&requirements[0])
+        );
+        assert_eq!(
+            module_of("github.com/acme/sdk/v2/types", &requirements),
+            Some(&requirements[2])
+        );
+        assert_eq!(module_of("k8s.io/api/core/v1", &requirements), None);
+        assert_eq!(
+            package_dir(
+                Path::new("/cache/k8s.io/apimachinery@v0.30.1"),
+                &requirements[0],
+                "k8s.io/apimachinery/pkg/apis/meta/v1"
+            ),
+            PathBuf::from("/cache/k8s.io/apimachinery@v0.30.1/pkg/apis/meta/v1")
+        );
+    }
+
+    #[test]
+    fn test_vendored_package() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        let package = "k8s.io/apimachinery/pkg/apis/meta/v1";
+        std::fs::create_dir_all(root.join("vendor").join(package)).unwrap();
+        assert_eq!(vendored_package(root, package), None);
+
+        std::fs::write(
+            root.join("vendor/modules.txt"),
+            "# k8s.io/apimachinery v0.30.1\n",
+        )
+        .unwrap();
+        assert_eq!(
+            vendored_package(root, package),
+            Some(root.join("vendor").join(package))
+        );
+        assert_eq!(vendored_package(root, "k8s.io/api/core/v1"), None);
+    }
+
+    #[test]
+    fn test_missing_references() {
+        let mut metadata = HashMap::new();
+        metadata.insert("import_path".to_string(), "example.com/api/orders".into());
+        let schemas = vec![
+            ExtractedSchema {
+                name: "Order".to_string(),
+                schema_type: "go_struct".to_string(),
+                content: serde_yaml::from_str(
+                    "{properties: {meta: {type: object, x-go-ref: {package: k8s.io/apimachinery/pkg/apis/meta/v1, type: ObjectMeta}}, lines: {type: array, items: {type: object, x-go-ref: {package: example.com/shared/money, type: Amount}}}, timeout: {type: string, x-go-ref: {package: time, type: Duration}}, parent: {type: object, x-go-ref: {package: example.com/api/orders, type: Order}}}, type: object}",
+                )
+                .unwrap(),
+                source_file: "orders/order.go".into(),
+                metadata,
+            },
+        ];
+        let workspace = vec![WorkspaceModule {
+            path: "example.com/shared".to_string(),
+            dir: "./shared".into(),
+        }];
+
+        let missing = missing_references(&schemas, &workspace);
+        assert_eq!(
+            missing.into_iter().collect::<Vec<_>>(),
+            vec![(
+                "k8s.io/apimachinery/pkg/apis/meta/v1".to_string(),
+                "ObjectMeta".to_string()
+            )]
+        );
+    }
+}
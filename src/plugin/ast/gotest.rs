@@ -0,0 +1,343 @@
+//! Go test helpers checking the generated libraries against the Go types
+//!
+//! For each Go package, a test file is written to the package directory of
+//! the local checkout configured with `go_checkout`, since the clone the
+//! types are read from is a cache no `go test` runs in. Its tests render the
+//! `new()` of every generated library with `jsonnet` and unmarshal the output
+//! into the original Go type, so a library that produces fields the type does
+//! not have, or values it cannot decode, fails the Go test suite.
+
+use std::collections::BTreeMap;
+use std::path::{Component, Path, PathBuf};
+
+use super::collisions::go_name;
+use super::generator::GoJsonnetGenerator;
+use crate::plugin::ExtractedSchema;
+
+/// Name of the test file written to each Go package directory
+pub const GO_TEST_FILE: &str = "zz_generated_gensonnet_test.go";
+
+/// Helpers shared by the tests of a package
+const GO_TEST_HELPERS: &str = r#"// gensonnetRender evaluates a Jsonnet expression with the generated libraries
+// on the import path. The jsonnet binary is taken from $JSONNET_BIN or PATH,
+// and the test is skipped when it is not installed.
+func gensonnetRender(t *testing.T, expr string) []byte {
+	t.Helper()
+	bin := os.Getenv("JSONNET_BIN")
+	if bin == "" {
+		bin = "jsonnet"
+	}
+	if _, err := exec.LookPath(bin); err != nil {
+		t.Skipf("%s not found: %v", bin, err)
+	}
+
+	out, err := exec.Command(bin, "-J", filepath.FromSlash(gensonnetLibDir), "-e", expr).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("jsonnet failed: %s", exitErr.Stderr)
+		}
+		t.Fatalf("jsonnet failed: %v", err)
+	}
+	return out
+}
+
+// gensonnetRoundTrip checks that the rendered expression unmarshals into v
+// without unknown fields, and that marshaling v keeps every rendered value.
+func gensonnetRoundTrip(t *testing.T, expr string, v interface{}) {
+	t.Helper()
+	rendered := gensonnetRender(t, expr)
+
+	decoder := json.NewDecoder(bytes.NewReader(rendered))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatalf("rendered Jsonnet does not unmarshal into %T: %v\n%s", v, err, rendered)
+	}
+	marshaled, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal %T: %v", v, err)
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(rendered, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(marshaled, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !gensonnetContains(got, want) {
+		t.Errorf("round trip through %T changed the value\nrendered:  %s\nmarshaled: %s", v, rendered, marshaled)
+	}
+}
+
+// gensonnetContains reports whether got holds every value of want. Go output
+// may have more fields, since fields without omitempty are always marshaled.
+func gensonnetContains(got, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		got, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if gotValue, ok := got[key]; !ok || !gensonnetContains(gotValue, value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		got, ok := got.([]interface{})
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !gensonnetContains(got[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(got, want)
+	}
+}
+"#;
+
+/// Generate the test file of a Go package
+///
+/// `lib_dir` is the directory of the generated libraries, relative to the
+/// package directory. Types encoded as scalars by a custom marshaler and
+/// interfaces are left out, since their libraries do not build values Go
+/// can decode into the type.
+pub fn generate_go_test(
+    generator: &GoJsonnetGenerator,
+    package: &str,
+    schemas: &[&ExtractedSchema],
+    lib_dir: &str,
+) -> String {
+    let mut code = String::new();
+    code.push_str("// Code generated by gensonnet. DO NOT EDIT.\n\n");
+    code.push_str("//go:generate gensonnet generate\n\n");
+    code.push_str(&format!("package {package}\n\n"));
+    code.push_str("import (\n");
+    for import in [
+        "bytes",
+        "encoding/json",
+        "os",
+        "os/exec",
+        "path/filepath",
+        "reflect",
+        "testing",
+    ] {
+        code.push_str(&format!("\t\"{import}\"\n"));
+    }
+    code.push_str(")\n\n");
+    code.push_str("// gensonnetLibDir is the directory of the generated Jsonnet libraries\n");
+    code.push_str(&format!(
+        "const gensonnetLibDir = {}\n\n",
+        go_quote(lib_dir)
+    ));
+    code.push_str(GO_TEST_HELPERS);
+
+    let mut schemas: Vec<&&ExtractedSchema> = schemas
+        .iter()
+        .filter(|schema| {
+            schema.content.get("x-go-marshaler").is_none()
+                && schema.content.get("x-go-interface").is_none()
+                && schema.content.get("x-go-union").is_none()
+        })
+        .collect();
+    schemas.sort_by(|a, b| a.name.cmp(&b.name));
+
+    for schema in schemas {
+        let expr = format!(
+            "(import \"{}\").new({})",
+            generator.file_name(schema),
+            generator.sample_new_args(schema).join(", ")
+        );
+        code.push_str(&format!(
+            "\n// Test{name}Gensonnet checks that {name}.new() renders a valid {name}\n",
+            name = schema.name
+        ));
+        code.push_str(&format!(
+            "func Test{}Gensonnet(t *testing.T) {{\n",
+            schema.name
+        ));
+        code.push_str(&format!("\tvar v {}\n", go_name(schema)));
+        code.push_str(&format!(
+            "\tgensonnetRoundTrip(t, {}, &v)\n",
+            go_quote(&expr)
+        ));
+        code.push_str("}\n");
+    }
+
+    code
+}
+
+/// Group schemas by the directory of their Go source, with the package name
+pub fn group_by_package(
+    schemas: &[ExtractedSchema],
+) -> BTreeMap<PathBuf, (String, Vec<&ExtractedSchema>)> {
+    let mut packages: BTreeMap<PathBuf, (String, Vec<&ExtractedSchema>)> = BTreeMap::new();
+    for schema in schemas {
+        let Some(dir) = schema.source_file.parent() else {
+            continue;
+        };
+        let package = schema
+            .metadata
+            .get("package")
+            .and_then(|p| p.as_str())
+            .filter(|p| !p.is_empty())
+            .unwrap_or("main");
+        packages
+            .entry(dir.to_path_buf())
+            .or_insert_with(|| (package.to_string(), Vec::new()))
+            .1
+            .push(schema);
+    }
+    packages
+}
+
+/// Get the directory of a package in the local checkout of a source
+///
+/// `dir` is the package directory in the clone at `repo_path`; the tests
+/// are written to the same directory of `checkout`, where `go test` runs.
+pub fn checkout_dir(dir: &Path, repo_path: &Path, checkout: &Path) -> PathBuf {
+    match dir.strip_prefix(repo_path) {
+        Ok(relative) => checkout.join(relative),
+        Err(_) => checkout.join(dir),
+    }
+}
+
+/// Get the path of `target` relative to `base`, with `/` separators
+///
+/// Both paths are resolved first; when they share no prefix the absolute
+/// target is returned.
+pub fn relative_path(base: &Path, target: &Path) -> String {
+    let base = base.canonicalize().unwrap_or_else(|_| base.to_path_buf());
+    let target = target
+        .canonicalize()
+        .unwrap_or_else(|_| target.to_path_buf());
+
+    let base_parts: Vec<Component> = base.components().collect();
+    let target_parts: Vec<Component> = target.components().collect();
+    let common = base_parts
+        .iter()
+        .zip(&target_parts)
+        .take_while(|(a, b)| a == b)
+        .count();
+    if common == 0 {
+        return target.to_string_lossy().replace('\\', "/");
+    }
+
+    let mut parts: Vec<String> = vec!["..".to_string(); base_parts.len() - common];
+    parts.extend(
+        target_parts[common..]
+            .iter()
+            .map(|part| part.as_os_str().to_string_lossy().to_string()),
+    );
+    if parts.is_empty() {
+        ".".to_string()
+    } else {
+        parts.join("/")
+    }
+}
+
+/// Quote a string as a Go string literal
+fn go_quote(value: &str) -> String {
+    let mut quoted = String::from("\"");
+    for c in value.chars() {
+        match c {
+            '"' => quoted.push_str("\\\""),
+            '\\' => quoted.push_str("\\\\"),
+            '\n' => quoted.push_str("\\n"),
+            '\t' => quoted.push_str("\\t"),
+            c if c.is_control() => quoted.push_str(&format!("\\u{:04x}", c as u32)),
+            c => quoted.push(c),
+        }
+    }
+    quoted.push('"');
+    quoted
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    #[test]
+    fn test_generate_go_test() {
+        let user = test_struct(
+            "User",
+            "api",
+            "api/user.go",
+            "properties: {name: {type: string, x-go-constructor-param: true}}",
+        );
+        let level = test_struct(
+            "Level",
+            "api",
+            "api/level.go",
+            "x-go-marshaler: encoding.TextMarshaler",
+        );
+
+        let code = generate_go_test(
+            &GoJsonnetGenerator::new(),
+            "api",
+            &[&user, &level],
+            "../lib",
+        );
+        assert!(code.starts_with("// Code generated by gensonnet. DO NOT EDIT.\n"));
+        assert!(code.contains("package api\n"));
+        assert!(code.contains("const gensonnetLibDir = \"../lib\"\n"));
+        assert!(code.contains(concat!(
+            "func TestUserGensonnet(t *testing.T) {\n",
+            "\tvar v User\n",
+            "\tgensonnetRoundTrip(t, \"(import \\\"user.libsonnet\\\").new(\\\"x\\\")\", &v)\n",
+            "}\n",
+        )));
+        assert!(!code.contains("TestLevelGensonnet"));
+    }
+
+    #[test]
+    fn test_group_by_package() {
+        let schemas = vec![
+            test_struct("User", "api", "api/user.go", "{}"),
+            test_struct("Group", "api", "api/group.go", "{}"),
+            test_struct("Job", "api", "batch/job.go", "{}"),
+        ];
+        let packages = group_by_package(&schemas);
+        assert_eq!(packages.len(), 2);
+        let (package, types) = &packages[Path::new("api")];
+        assert_eq!(package, "api");
+        assert_eq!(types.len(), 2);
+    }
+
+    #[test]
+    fn test_checkout_dir() {
+        assert_eq!(
+            checkout_dir(
+                Path::new("/cache/repo/api/v1"),
+                Path::new("/cache/repo"),
+                Path::new("/src/operator")
+            ),
+            Path::new("/src/operator/api/v1")
+        );
+        assert_eq!(
+            checkout_dir(Path::new("api"), Path::new("/cache/repo"), Path::new("op")),
+            Path::new("op/api")
+        );
+    }
+
+    #[test]
+    fn test_relative_path() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        std::fs::create_dir_all(root.join("pkg/api")).unwrap();
+        std::fs::create_dir_all(root.join("lib")).unwrap();
+
+        assert_eq!(
+            relative_path(&root.join("pkg/api"), &root.join("lib")),
+            "../../lib"
+        );
+        assert_eq!(relative_path(root, &root.join("lib")), "lib");
+        assert_eq!(relative_path(&root.join("lib"), &root.join("lib")), ".");
+    }
+}
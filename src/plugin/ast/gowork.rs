@@ -0,0 +1,271 @@
+//! Go workspaces and references between packages
+//!
+//! A repository may hold several modules tied together by a `go.work` file,
+//! with types of one module used by fields of another. The parser records
+//! the package and name of every type of another package on the field's
+//! schema (`x-go-ref`); `resolve_references` looks the type up among the
+//! types generated for the source, so the field gets the schema of the type
+//! and the generated library imports the library of the type.
+
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
+use super::collisions::go_name;
+use super::generator::GoJsonnetGenerator;
+use super::gomod;
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+/// A module of a Go workspace
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct WorkspaceModule {
+    /// Module path declared in its `go.mod`
+    pub path: String,
+
+    /// Directory of the module, as listed in `go.work`
+    pub dir: PathBuf,
+}
+
+/// Get the directories listed by the `use` directives of a `go.work` file
+pub fn use_dirs(go_work: &str) -> Vec<String> {
+    let mut dirs = Vec::new();
+    let mut in_block = false;
+    for line in go_work.lines() {
+        let line = line.split("//").next().unwrap_or(line).trim();
+        if in_block {
+            if line == ")" {
+                in_block = false;
+            } else if !line.is_empty() {
+                dirs.push(line.trim_matches('"').to_string());
+            }
+            continue;
+        }
+
+        let Some(rest) = line.strip_prefix("use") else {
+            continue;
+        };
+        let rest = rest.trim();
+        if rest == "(" {
+            in_block = true;
+        } else if !rest.is_empty() && line.starts_with("use ") {
+            dirs.push(rest.trim_matches('"').to_string());
+        }
+    }
+    dirs
+}
+
+/// Get the modules of the `go.work` file at the root of a repository
+///
+/// Returns nothing when the repository is not a workspace. Listed
+/// directories without a readable `go.mod` are skipped.
+pub fn workspace_modules(root: &Path) -> Vec<WorkspaceModule> {
+    let Ok(go_work) = std::fs::read_to_string(root.join("go.work")) else {
+        return Vec::new();
+    };
+    use_dirs(&go_work)
+        .into_iter()
+        .filter_map(|dir| {
+            let go_mod = std::fs::read_to_string(root.join(&dir).join("go.mod")).ok()?;
+            let path = gomod::module_path(&go_mod)?.to_string();
+            Some(WorkspaceModule {
+                path,
+                dir: dir.into(),
+            })
+        })
+        .collect()
+}
+
+/// Resolve the references of fields to types of other packages
+///
+/// Uses the `import_path` metadata of the schemas. A resolved reference gets
+/// the `file` of the type's library and the field takes the type's schema
+/// type. Returns warnings for references into a module of the workspace
+/// whose type was not generated.
+pub fn resolve_references(
+    schemas: &mut [ExtractedSchema],
+    workspace: &[WorkspaceModule],
+) -> Vec<String> {
+    let mut targets = ReferenceTargets::new();
+    add_reference_targets(&mut targets, schemas);
+    resolve_references_to(schemas, &targets, workspace)
+}
+
+/// Library file and schema type of the types references can resolve to,
+/// keyed by import path and type name
+pub type ReferenceTargets = HashMap<(String, String), (String, serde_yaml::Value)>;
+
+/// Add the types of schemas to the targets of references
+pub fn add_reference_targets(targets: &mut ReferenceTargets, schemas: &[ExtractedSchema]) {
+    let generator = GoJsonnetGenerator::new();
+    for schema in schemas {
+        let Some(import_path) = schema.metadata.get("import_path").and_then(|p| p.as_str()) else {
+            continue;
+        };
+        let schema_type = schema.content.get("type").cloned().unwrap_or_default();
+        targets.insert(
+            (import_path.to_string(), go_name(schema).to_string()),
+            (generator.file_name(schema), schema_type),
+        );
+    }
+}
+
+/// Resolve the references of fields to the given types
+///
+/// As `resolve_references`, for packages processed apart from the packages
+/// they import.
+pub fn resolve_references_to(
+    schemas: &mut [ExtractedSchema],
+    targets: &ReferenceTargets,
+    workspace: &[WorkspaceModule],
+) -> Vec<String> {
+    let mut warnings = Vec::new();
+    for schema in schemas.iter_mut() {
+        let name = schema.name.clone();
+        let Some(properties) = schema
+            .content
+            .get_mut("properties")
+            .and_then(|p| p.as_mapping_mut())
+        else {
+            continue;
+        };
+        for (field, property) in properties.iter_mut() {
+            let field = field.as_str().unwrap_or_default();
+            for unresolved in resolve_property(property, targets) {
+                if let Some(module) = workspace.iter().find(|m| in_module(&unresolved, &m.path)) {
+                    warnings.push(Code::UngeneratedReference.warning(format!(
+                        "{}.{} references {}, which is in workspace module {} but is not generated; check the include patterns",
+                        name,
+                        field,
+                        unresolved,
+                        module.dir.display()
+                    )));
+                }
+            }
+        }
+    }
+
+    warnings
+}
+
+/// Resolve the references of a property and of its items and values
+///
+/// Returns the references that were not found, as `package.Type`.
+fn resolve_property(property: &mut serde_yaml::Value, targets: &ReferenceTargets) -> Vec<String> {
+    let mut unresolved = Vec::new();
+    for key in ["items", "additionalProperties"] {
+        if let Some(inner) = property.get_mut(key) {
+            unresolved.extend(resolve_property(inner, targets));
+        }
+    }
+
+    let Some(property) = property.as_mapping_mut() else {
+        return unresolved;
+    };
+    let reference = property.get("x-go-ref").and_then(|r| {
+        Some((
+            r.get("package")?.as_str()?.to_string(),
+            r.get("type")?.as_str()?.to_string(),
+        ))
+    });
+    let Some((package, type_name)) = reference else {
+        return unresolved;
+    };
+    if property["x-go-ref"].get("library").is_some() {
+        return unresolved;
+    }
+
+    match targets.get(&(package.clone(), type_name.clone())) {
+        Some((file, schema_type)) => {
+            if !schema_type.is_null() {
+                property.insert("type".into(), schema_type.clone());
+            }
+            if let Some(reference) = property
+                .get_mut("x-go-ref")
+                .and_then(|r| r.as_mapping_mut())
+            {
+                reference.insert("file".into(), file.as_str().into());
+            }
+        }
+        None => unresolved.push(format!("{package}.{type_name}")),
+    }
+    unresolved
+}
+
+/// Check whether a package belongs to a module
+fn in_module(reference: &str, module: &str) -> bool {
+    reference
+        .strip_prefix(module)
+        .is_some_and(|rest| rest.starts_with('/') || rest.starts_with('.'))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    fn schema(name: &str, import_path: &str, content: &str) -> ExtractedSchema {
+        let mut schema = test_schema(name, "go_struct", "types.go", content);
+        schema
+            .metadata
+            .insert("import_path".to_string(), import_path.into());
+        schema
+    }
+
+    #[test]
+    fn test_use_dirs() {
+        let go_work = "go 1.22\n\nuse ./api\n\nuse (\n\t./shared // types\n\t\"./tools\"\n)\n\nreplace example.com/x => ./x\n";
+        assert_eq!(use_dirs(go_work), vec!["./api", "./shared", "./tools"]);
+        assert!(use_dirs("go 1.22\n").is_empty());
+    }
+
+    #[test]
+    fn test_workspace_modules() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        std::fs::create_dir_all(root.join("shared")).unwrap();
+        std::fs::write(
+            root.join("go.work"),
+            "go 1.22\nuse (\n\t./shared\n\t./missing\n)\n",
+        )
+        .unwrap();
+        std::fs::write(root.join("shared/go.mod"), "module example.com/shared\n").unwrap();
+
+        let modules = workspace_modules(root);
+        assert_eq!(modules.len(), 1);
+        assert_eq!(modules[0].path, "example.com/shared");
+        assert_eq!(modules[0].dir, PathBuf::from("./shared"));
+        assert!(workspace_modules(&root.join("shared")).is_empty());
+    }
+
+    #[test]
+    fn test_resolve_references() {
+        let mut schemas = vec![
+            schema(
+                "Order",
+                "example.com/api/orders",
+                "{properties: {billing: {type: string, x-go-ref: {package: example.com/shared/address, type: Address}}, lines: {type: array, items: {type: string, x-go-ref: {package: example.com/shared/money, type: Amount}}}, updated: {type: string, x-go-ref: {package: time, type: Duration}}}, type: object}",
+            ),
+            schema("Address", "example.com/shared/address", "{properties: {}, type: object}"),
+        ];
+        let workspace = vec![WorkspaceModule {
+            path: "example.com/shared".to_string(),
+            dir: "./shared".into(),
+        }];
+
+        let warnings = resolve_references(&mut schemas, &workspace);
+        let properties = schemas[0].content.get("properties").unwrap();
+        let billing = properties.get("billing").unwrap();
+        assert_eq!(billing.get("type").unwrap().as_str(), Some("object"));
+        assert_eq!(
+            billing["x-go-ref"]["file"].as_str(),
+            Some("address.libsonnet")
+        );
+
+        // Money is in the workspace but not generated; time is not in it
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].starts_with(
+            "GS0201: Order.lines references example.com/shared/money.Amount, which is in workspace module ./shared"
+        ));
+        assert!(properties["updated"]["x-go-ref"].get("file").is_none());
+    }
+}
@@ -0,0 +1,215 @@
+//! Helm chart values from a Go struct
+//!
+//! Operators often define the configuration of their chart as a Go struct.
+//! With `helm_values: Values` in the source options, that struct is also
+//! written as the `values.schema.json` Helm validates chart values against,
+//! and its defaults as `values.libsonnet`, from which the `values.yaml` of
+//! the chart can be rendered:
+//!
+//! ```go
+//! type Values struct {
+//!     ReplicaCount int   `json:"replicaCount" default:"1"`
+//!     Image        Image `json:"image"`
+//! }
+//! ```
+//!
+//! ```jsonnet
+//! {
+//!   replicaCount: 1,
+//!   image: {
+//!     pullPolicy: "IfNotPresent",
+//!   },
+//! }
+//! ```
+//!
+//! Helm validates draft-07 schemas, so the types the struct references are
+//! under `definitions`. Nested structs hold their own defaults; fields
+//! without a default are left out of the values.
+
+use anyhow::{anyhow, Result};
+
+use super::aliases::STRUCT_REF_KEY;
+use super::collisions::go_name;
+use super::generator::{field_key, yaml_to_jsonnet};
+use super::jsonschema::generate_draft07_schema;
+use super::package_dir;
+use crate::plugin::ExtractedSchema;
+
+/// File of the schema of the chart values
+pub const VALUES_SCHEMA_FILE: &str = "values.schema.json";
+
+/// File of the defaults of the chart values
+pub const VALUES_FILE: &str = "values.libsonnet";
+
+/// Find the values struct named by `helm_values`, as `Type` or `package.Type`
+pub fn find_values(schemas: &[ExtractedSchema], name: &str) -> Result<usize> {
+    let (package, type_name) = match name.rsplit_once('.') {
+        Some((package, type_name)) => (Some(package), type_name),
+        None => (None, name),
+    };
+    let matches: Vec<usize> = schemas
+        .iter()
+        .enumerate()
+        .filter(|(_, schema)| schema.name == type_name || go_name(schema) == type_name)
+        .filter(|(_, schema)| {
+            package.is_none_or(|package| {
+                schema.metadata.get("package").and_then(|p| p.as_str()) == Some(package)
+            })
+        })
+        .map(|(idx, _)| idx)
+        .collect();
+    match matches.as_slice() {
+        [idx] => Ok(*idx),
+        [] => Err(anyhow!("helm_values type {} is not generated", name)),
+        _ => Err(anyhow!(
+            "helm_values type {} is declared by several packages; name it as package.{}",
+            name,
+            type_name
+        )),
+    }
+}
+
+/// Generate the `values.schema.json` of a values struct
+pub fn generate_values_schema(schemas: &[ExtractedSchema], idx: usize) -> Result<String> {
+    generate_draft07_schema(schemas, idx)
+}
+
+/// Generate the `values.libsonnet` with the defaults of a values struct
+pub fn generate_values(schemas: &[ExtractedSchema], idx: usize) -> String {
+    let values = defaults(schemas, idx, &mut vec![idx]);
+    let mut code = String::from("// Generated from Go AST: values\n");
+    push_object(&mut code, &values, "");
+    code.push('\n');
+    code
+}
+
+/// Get the defaults of the fields of a struct and of the structs it holds
+///
+/// `resolving` holds the structs being expanded, which are not expanded
+/// again.
+fn defaults(
+    schemas: &[ExtractedSchema],
+    idx: usize,
+    resolving: &mut Vec<usize>,
+) -> serde_yaml::Mapping {
+    let schema = &schemas[idx];
+    let mut values = serde_yaml::Mapping::new();
+    let properties = schema
+        .content
+        .get("properties")
+        .and_then(|p| p.as_mapping());
+    for (name, property) in properties.into_iter().flatten() {
+        if let Some(default) = property.get("default") {
+            values.insert(name.clone(), default.clone());
+            continue;
+        }
+        // Pointers to structs are left unset
+        if property.get("nullable").and_then(|n| n.as_bool()) == Some(true) {
+            continue;
+        }
+        let Some(target) = struct_target(schemas, schema, property) else {
+            continue;
+        };
+        if resolving.contains(&target) {
+            continue;
+        }
+        resolving.push(target);
+        let nested = defaults(schemas, target, resolving);
+        resolving.pop();
+        if !nested.is_empty() {
+            values.insert(name.clone(), serde_yaml::Value::Mapping(nested));
+        }
+    }
+    values
+}
+
+/// Find the struct a field of `schema` holds
+fn struct_target(
+    schemas: &[ExtractedSchema],
+    schema: &ExtractedSchema,
+    property: &serde_yaml::Value,
+) -> Option<usize> {
+    if let Some(reference) = property.get("x-go-ref") {
+        let package = reference.get("package")?.as_str()?;
+        let name = reference.get("type")?.as_str()?;
+        return schemas.iter().position(|candidate| {
+            go_name(candidate) == name
+                && candidate
+                    .metadata
+                    .get("import_path")
+                    .and_then(|p| p.as_str())
+                    == Some(package)
+        });
+    }
+    let name = property.get(STRUCT_REF_KEY)?.as_str()?;
+    let dir = package_dir(schema);
+    schemas
+        .iter()
+        .position(|candidate| candidate.name == name && package_dir(candidate) == dir)
+}
+
+/// Write an object of values, one field per line
+fn push_object(code: &mut String, values: &serde_yaml::Mapping, indent: &str) {
+    if values.is_empty() {
+        code.push_str("{}");
+        return;
+    }
+    code.push_str("{\n");
+    let inner = format!("{indent}  ");
+    for (name, value) in values {
+        let key = match name.as_str() {
+            Some(name) => field_key(name),
+            None => field_key(&yaml_to_jsonnet(name)),
+        };
+        code.push_str(&format!("{inner}{key}: "));
+        match value.as_mapping() {
+            Some(nested) => push_object(code, nested, &inner),
+            None => code.push_str(&yaml_to_jsonnet(value)),
+        }
+        code.push_str(",\n");
+    }
+    code.push_str(&format!("{indent}}}"));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    fn chart() -> Vec<ExtractedSchema> {
+        vec![
+            test_struct("Values", "chart", "chart/values.go", "{type: object, properties: {image: {type: object, x-go-struct-ref: Image}, next: {type: object, x-go-struct-ref: Values}, replicaCount: {type: integer, default: 1}, service: {type: object, x-go-struct-ref: Service}, tls: {type: object, nullable: true, x-go-struct-ref: Image}}}"),
+            test_struct("Image", "chart", "chart/image.go", "{type: object, properties: {repository: {type: string}, pullPolicy: {type: string, default: IfNotPresent}}}"),
+            test_struct("Service", "chart", "chart/service.go", "{type: object, properties: {port: {type: integer}}}"),
+            test_struct("Image", "other", "other/image.go", "{type: object}"),
+        ]
+    }
+
+    #[test]
+    fn test_find_values() {
+        let schemas = chart();
+        assert_eq!(find_values(&schemas, "Values").unwrap(), 0);
+        assert_eq!(find_values(&schemas, "other.Image").unwrap(), 3);
+        assert!(find_values(&schemas, "Image")
+            .unwrap_err()
+            .to_string()
+            .contains("several packages"));
+        assert!(find_values(&schemas, "Config").is_err());
+    }
+
+    #[test]
+    fn test_generate_values() {
+        let schemas = chart();
+        assert_eq!(
+            generate_values(&schemas, 0),
+            "// Generated from Go AST: values\n{\n  image: {\n    pullPolicy: \"IfNotPresent\",\n  },\n  replicaCount: 1,\n}\n"
+        );
+
+        let values_schema: serde_json::Value =
+            serde_json::from_str(&generate_values_schema(&schemas, 0).unwrap()).unwrap();
+        assert_eq!(
+            values_schema["properties"]["image"]["$ref"],
+            "#/definitions/Image"
+        );
+    }
+}
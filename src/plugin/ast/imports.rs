@@ -0,0 +1,143 @@
+//! Form of the imports between generated libraries
+//!
+//! Libraries import each other by file name (`import "address.libsonnet"`),
+//! which Jsonnet resolves next to the importing file. The `imports` option
+//! rewrites these imports, in the libraries and in the index, for libraries
+//! dropped into `vendor/` trees managed by jsonnet-bundler:
+//!
+//! ```yaml
+//! options:
+//!   imports:
+//!     style: absolute
+//!     prefix: github.com/acme/libs/user/
+//! ```
+//!
+//! Absolute imports (`import "github.com/acme/libs/user/address.libsonnet"`)
+//! are resolved through the library search path (`-J vendor`), so every
+//! library is loaded once however it is reached. Relative imports are
+//! written as `./address.libsonnet`, which is never looked up in the search
+//! path.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeSet;
+
+/// Form of the imports of generated libraries
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ImportOptions {
+    #[serde(default)]
+    pub style: ImportStyle,
+
+    /// Path of the output directory in the search path, for absolute imports
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub prefix: Option<String>,
+}
+
+/// Whether imports are resolved next to the file or in the search path
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ImportStyle {
+    /// `./address.libsonnet`
+    #[default]
+    Relative,
+
+    /// `<prefix>address.libsonnet`
+    Absolute,
+}
+
+impl ImportOptions {
+    /// Check that absolute imports have a prefix
+    pub fn validate(&self) -> Result<()> {
+        if self.style == ImportStyle::Absolute
+            && self.prefix.as_deref().is_none_or(|p| p.trim().is_empty())
+        {
+            return Err(anyhow!("Absolute imports need an import prefix"));
+        }
+        Ok(())
+    }
+
+    /// Path of the import of a generated file
+    fn path(&self, file: &str) -> String {
+        match (self.style, self.prefix.as_deref()) {
+            (ImportStyle::Absolute, Some(prefix)) => {
+                format!("{}/{file}", prefix.trim_end_matches('/'))
+            }
+            _ => format!("./{file}"),
+        }
+    }
+}
+
+/// Rewrite the imports of generated files in a library
+///
+/// `files` are the file names of the output directory; imports of other
+/// files, of subdirectories and of external libraries are kept.
+pub fn rewrite_imports(code: &str, files: &BTreeSet<String>, options: &ImportOptions) -> String {
+    const IMPORT: &str = "import \"";
+
+    let mut output = String::with_capacity(code.len());
+    let mut rest = code;
+    while let Some(start) = rest.find(IMPORT) {
+        let path_start = start + IMPORT.len();
+        let Some(length) = rest[path_start..].find('"') else {
+            break;
+        };
+        let path = &rest[path_start..path_start + length];
+        // Skip identifiers ending in `import`
+        let keyword = start == 0
+            || !rest[..start]
+                .chars()
+                .next_back()
+                .is_some_and(|c| c.is_alphanumeric() || c == '_');
+        let file = path.strip_prefix("./").unwrap_or(path);
+
+        output.push_str(&rest[..path_start]);
+        if keyword && files.contains(file) {
+            output.push_str(&options.path(file));
+        } else {
+            output.push_str(path);
+        }
+        rest = &rest[path_start + length..];
+    }
+    output.push_str(rest);
+    output
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rewrite_imports() {
+        let files: BTreeSet<String> = ["address.libsonnet", "user.libsonnet"]
+            .iter()
+            .map(|f| f.to_string())
+            .collect();
+        let code = "{\n  imports:: {\n    Address: import \"address.libsonnet\",\n    Pod: (import \"github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet\").core.v1.pod,\n  },\n  user: import \"./user.libsonnet\",\n  tests: import \"../user.libsonnet\",\n}\n";
+
+        let absolute = ImportOptions {
+            style: ImportStyle::Absolute,
+            prefix: Some("github.com/acme/libs/user/".to_string()),
+        };
+        let rewritten = rewrite_imports(code, &files, &absolute);
+        assert!(rewritten
+            .contains("    Address: import \"github.com/acme/libs/user/address.libsonnet\",\n"));
+        assert!(
+            rewritten.contains("  user: import \"github.com/acme/libs/user/user.libsonnet\",\n")
+        );
+        assert!(rewritten
+            .contains("(import \"github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet\")"));
+        assert!(rewritten.contains("  tests: import \"../user.libsonnet\",\n"));
+
+        let relative = rewrite_imports(code, &files, &ImportOptions::default());
+        assert!(relative.contains("    Address: import \"./address.libsonnet\",\n"));
+        assert!(relative.contains("  user: import \"./user.libsonnet\",\n"));
+
+        assert!(ImportOptions {
+            style: ImportStyle::Absolute,
+            prefix: None
+        }
+        .validate()
+        .is_err());
+        assert!(ImportOptions::default().validate().is_ok());
+    }
+}
@@ -0,0 +1,476 @@
+//! JSON Schemas of the generated types
+//!
+//! With the `jsonschema` output format (`--output-format jsonschema`), each
+//! type also gets a draft 2020-12 JSON Schema in the `schemas` directory of
+//! the output, named after its library (`user.libsonnet` gets
+//! `schemas/user.schema.json`), for editor validation and admission
+//! webhooks. The types a schema references are included under `$defs`, so
+//! each file can be used on its own:
+//!
+//! ```json
+//! {
+//!   "$schema": "https://json-schema.org/draft/2020-12/schema",
+//!   "$id": "user.schema.json",
+//!   "title": "User",
+//!   "type": "object",
+//!   "properties": {
+//!     "address": { "$ref": "#/$defs/Address" }
+//!   },
+//!   "$defs": {
+//!     "Address": { "title": "Address", "type": "object", ... }
+//!   }
+//! }
+//! ```
+//!
+//! Pointers may be null, enums list their values, interfaces with a union
+//! of implementations are a `oneOf` of them and the keys of maps with
+//! integer keys are checked with `propertyNames`. The other `x-go-*`
+//! annotations are left out.
+
+use anyhow::Result;
+use serde_json::{json, Map, Value};
+use std::collections::HashMap;
+use std::path::Path;
+
+use super::enums::enum_values;
+use super::generator::{GoJsonnetGenerator, DURATION_PATTERN, INTEGER_KEY_PATTERN, MAP_KEY_KEY};
+use super::package_dir;
+use super::references::TypeIndex;
+use crate::plugin::ExtractedSchema;
+
+/// Directory of the JSON Schemas in the output
+pub const SCHEMAS_DIR: &str = "schemas";
+
+/// Dialect of the JSON Schemas
+pub const DIALECT: &str = "https://json-schema.org/draft/2020-12/schema";
+
+/// Dialect of the schemas for tools validating draft-07, such as Helm
+pub const DRAFT_07: &str = "http://json-schema.org/draft-07/schema#";
+
+/// Keywords of the intermediate schemas with the same meaning in JSON Schema
+const KEYWORDS: &[&str] = &[
+    "type",
+    "format",
+    "pattern",
+    "enum",
+    "default",
+    "description",
+    "minimum",
+    "maximum",
+    "exclusiveMinimum",
+    "exclusiveMaximum",
+    "multipleOf",
+    "minLength",
+    "maxLength",
+    "minItems",
+    "maxItems",
+    "uniqueItems",
+    "minProperties",
+    "maxProperties",
+    "required",
+    "readOnly",
+];
+
+/// Get the file of the JSON Schema of a type, in `SCHEMAS_DIR`
+pub fn schema_file(schema: &ExtractedSchema) -> String {
+    GoJsonnetGenerator::new()
+        .file_name(schema)
+        .replace(".libsonnet", ".schema.json")
+}
+
+/// Generate the JSON Schema of each type, with its file in `SCHEMAS_DIR`
+pub fn generate_json_schemas(schemas: &[ExtractedSchema]) -> Result<Vec<(String, String)>> {
+    let types = TypeIndex::new(schemas);
+    schemas
+        .iter()
+        .enumerate()
+        .map(|(idx, schema)| {
+            let file = schema_file(schema);
+            let document = document(&types, idx, DIALECT, Some(&file), "$defs");
+            Ok((file, serde_json::to_string_pretty(&document)? + "\n"))
+        })
+        .collect()
+}
+
+/// Generate the draft-07 JSON Schema of one type
+///
+/// Draft-07 has no `$defs`; the referenced types are under `definitions`.
+pub fn generate_draft07_schema(schemas: &[ExtractedSchema], idx: usize) -> Result<String> {
+    let document = document(&TypeIndex::new(schemas), idx, DRAFT_07, None, "definitions");
+    Ok(serde_json::to_string_pretty(&document)? + "\n")
+}
+
+/// Get the JSON Schema of a type, with the types it references under
+/// `definitions`
+fn document(
+    types: &TypeIndex,
+    idx: usize,
+    dialect: &str,
+    id: Option<&str>,
+    definitions: &'static str,
+) -> Value {
+    let mut document = Document {
+        types,
+        root: idx,
+        definitions,
+        keys: HashMap::new(),
+        pending: Vec::new(),
+    };
+    let mut root = Map::new();
+    root.insert("$schema".into(), dialect.into());
+    if let Some(id) = id {
+        root.insert("$id".into(), id.into());
+    }
+    root.extend(document.definition(idx));
+
+    let mut defs = Map::new();
+    while let Some(next) = document.pending.pop() {
+        let key = document.keys[&next].clone();
+        defs.insert(key, Value::Object(document.definition(next)));
+    }
+    if !defs.is_empty() {
+        root.insert(definitions.into(), Value::Object(defs));
+    }
+    Value::Object(root)
+}
+
+/// The conversion of the schema of one type
+struct Document<'a> {
+    types: &'a TypeIndex<'a>,
+    root: usize,
+
+    /// Keyword holding the referenced types
+    definitions: &'static str,
+
+    /// Keys of the referenced types under `definitions`
+    keys: HashMap<usize, String>,
+
+    /// Referenced types whose definition is not converted yet
+    pending: Vec<usize>,
+}
+
+impl Document<'_> {
+    /// Convert the schema of a type, with its title
+    fn definition(&mut self, idx: usize) -> Map<String, Value> {
+        let schema = self.types.schema(idx);
+        let mut definition = Map::new();
+        definition.insert("title".into(), schema.name.as_str().into());
+        definition.extend(self.convert(&schema.content, &package_dir(schema)));
+        definition
+    }
+
+    /// Get the `$ref` of a type, adding it to the definitions on first use
+    ///
+    /// Types are keyed by name, or by `package.Name` when types of several
+    /// packages share it.
+    fn reference(&mut self, idx: usize) -> String {
+        if idx == self.root {
+            return "#".to_string();
+        }
+        if let Some(key) = self.keys.get(&idx) {
+            return format!("#/{}/{key}", self.definitions);
+        }
+        let schema = self.types.schema(idx);
+        let taken = schema.name == self.types.schema(self.root).name
+            || self.keys.values().any(|key| *key == schema.name);
+        let key = if taken {
+            let package = schema
+                .metadata
+                .get("package")
+                .and_then(|p| p.as_str())
+                .unwrap_or_default();
+            format!("{}.{}", package, schema.name)
+        } else {
+            schema.name.clone()
+        };
+        self.keys.insert(idx, key.clone());
+        self.pending.push(idx);
+        format!("#/{}/{key}", self.definitions)
+    }
+
+    /// Convert a schema of the package in `dir`
+    fn convert(&mut self, schema: &serde_yaml::Value, dir: &Path) -> Map<String, Value> {
+        let mut converted = Map::new();
+        let Some(mapping) = schema.as_mapping() else {
+            return converted;
+        };
+
+        match self.types.target(mapping, dir) {
+            // The referenced type replaces the shape guessed for the field
+            Some(target) => {
+                converted.insert("$ref".into(), self.reference(target).into());
+                for key in ["description", "default"] {
+                    if let Some(value) = mapping.get(key) {
+                        converted.insert(key.into(), to_json(value));
+                    }
+                }
+            }
+            None => {
+                for key in KEYWORDS {
+                    if let Some(value) = mapping.get(*key) {
+                        converted.insert(key.to_string(), to_json(value));
+                    }
+                }
+                if let Some(items) = mapping.get("items") {
+                    converted.insert("items".into(), Value::Object(self.convert(items, dir)));
+                }
+                match mapping.get("additionalProperties") {
+                    Some(serde_yaml::Value::Bool(allowed)) => {
+                        converted.insert("additionalProperties".into(), (*allowed).into());
+                    }
+                    Some(values) => {
+                        converted.insert(
+                            "additionalProperties".into(),
+                            Value::Object(self.convert(values, dir)),
+                        );
+                    }
+                    None => {}
+                }
+                if let Some(properties) = mapping.get("properties").and_then(|p| p.as_mapping()) {
+                    let mut converted_properties = Map::new();
+                    for (name, property) in properties {
+                        if let Some(name) = name.as_str() {
+                            converted_properties.insert(
+                                name.to_string(),
+                                Value::Object(self.convert(property, dir)),
+                            );
+                        }
+                    }
+                    converted.insert("properties".into(), Value::Object(converted_properties));
+                }
+                if let Some(names) = mapping.get(MAP_KEY_KEY).and_then(property_names) {
+                    converted.insert("propertyNames".into(), names);
+                }
+                if let Some(values) = enum_values(mapping) {
+                    converted.insert(
+                        "enum".into(),
+                        Value::Array(values.into_iter().map(to_json).collect()),
+                    );
+                }
+                let variants: Vec<Value> = self
+                    .types
+                    .variants(mapping, dir)
+                    .into_iter()
+                    .map(|variant| json!({ "$ref": self.reference(variant) }))
+                    .collect();
+                if !variants.is_empty() {
+                    converted.insert("oneOf".into(), Value::Array(variants));
+                }
+                // Go durations are not the ISO 8601 durations of JSON Schema
+                if mapping.get("format").and_then(|f| f.as_str()) == Some("duration") {
+                    converted.remove("format");
+                    converted.insert("pattern".into(), DURATION_PATTERN.into());
+                }
+            }
+        }
+
+        if mapping.contains_key("x-go-deprecated") {
+            converted.insert("deprecated".into(), true.into());
+        }
+        if mapping.get("nullable").and_then(|n| n.as_bool()) == Some(true) {
+            allow_null(&mut converted);
+        }
+        converted
+    }
+}
+
+/// Get the `propertyNames` of a map from the schema of its keys
+///
+/// encoding/json writes integer keys in decimal; other keys are checked
+/// with the enum, pattern or format of their type.
+fn property_names(keys: &serde_yaml::Value) -> Option<Value> {
+    if keys.get("type").and_then(|t| t.as_str()) == Some("integer") {
+        return Some(json!({ "pattern": INTEGER_KEY_PATTERN }));
+    }
+    let names: Map<String, Value> = ["enum", "pattern", "format"]
+        .into_iter()
+        .filter_map(|key| Some((key.to_string(), to_json(keys.get(key)?))))
+        .collect();
+    (!names.is_empty()).then_some(Value::Object(names))
+}
+
+/// Let a converted schema also be null
+fn allow_null(schema: &mut Map<String, Value>) {
+    if let Some(reference) = schema.remove("$ref") {
+        schema.insert(
+            "anyOf".into(),
+            json!([{ "$ref": reference }, { "type": "null" }]),
+        );
+    }
+    if let Some(Value::String(schema_type)) = schema.get("type").cloned() {
+        schema.insert("type".into(), json!([schema_type, "null"]));
+    }
+    if let Some(Value::Array(values)) = schema.get_mut("enum") {
+        values.push(Value::Null);
+    }
+}
+
+/// Convert a YAML value to JSON
+fn to_json(value: &serde_yaml::Value) -> Value {
+    serde_json::to_value(value).unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    /// A struct of the package of its directory, imported from example.com
+    fn schema(name: &str, file: &str, content: &str) -> ExtractedSchema {
+        let dir = Path::new(file).parent().unwrap().display().to_string();
+        let mut schema = test_struct(name, &dir, file, content);
+        schema.metadata.insert(
+            "import_path".to_string(),
+            format!("example.com/{dir}").into(),
+        );
+        schema
+    }
+
+    fn documents(schemas: &[ExtractedSchema]) -> Vec<Value> {
+        generate_json_schemas(schemas)
+            .unwrap()
+            .into_iter()
+            .map(|(_, json)| serde_json::from_str(&json).unwrap())
+            .collect()
+    }
+
+    #[test]
+    fn test_generate_json_schemas() {
+        let schemas = vec![
+            schema(
+                "User",
+                "api/user.go",
+                "{type: object, description: A user, required: [name], properties: {name: {type: string, minLength: 1}, address: {type: object, nullable: true, x-go-struct-ref: Address}, billing: {type: object, x-go-ref: {package: example.com/shared, type: Address, file: address.libsonnet}}, timeout: {type: string, format: duration}, ports: {type: object, additionalProperties: {type: string}, x-go-map-key: {type: integer}}, old: {type: string, x-go-deprecated: Use name}}}",
+            ),
+            schema(
+                "Address",
+                "api/address.go",
+                "{type: object, properties: {city: {type: string}, next: {type: object, nullable: true, x-go-struct-ref: Address}}}",
+            ),
+            schema("Address", "shared/address.go", "{type: object, properties: {zip: {type: string}}}"),
+        ];
+
+        assert_eq!(
+            generate_json_schemas(&schemas).unwrap()[0].0,
+            "user.schema.json"
+        );
+        let documents = documents(&schemas);
+        let user = &documents[0];
+        assert_eq!(user["$schema"], DIALECT);
+        assert_eq!(user["$id"], "user.schema.json");
+        assert_eq!(user["title"], "User");
+        assert_eq!(user["description"], "A user");
+        assert_eq!(user["required"], json!(["name"]));
+        assert_eq!(
+            user["properties"]["name"],
+            json!({"type": "string", "minLength": 1})
+        );
+        assert_eq!(
+            user["properties"]["address"],
+            json!({"anyOf": [{"$ref": "#/$defs/Address"}, {"type": "null"}]})
+        );
+        // Types of several packages sharing a name are keyed by package
+        assert_eq!(
+            user["properties"]["billing"],
+            json!({"$ref": "#/$defs/shared.Address"})
+        );
+        assert_eq!(
+            user["$defs"]["shared.Address"]["properties"]["zip"]["type"],
+            "string"
+        );
+        assert_eq!(user["properties"]["timeout"]["pattern"], DURATION_PATTERN);
+        assert!(user["properties"]["timeout"].get("format").is_none());
+        assert_eq!(
+            user["properties"]["ports"]["propertyNames"],
+            json!({"pattern": INTEGER_KEY_PATTERN})
+        );
+        assert_eq!(user["properties"]["old"]["deprecated"], true);
+
+        // Definitions are converted with the references they hold
+        let address = &user["$defs"]["Address"];
+        assert_eq!(address["title"], "Address");
+        assert_eq!(
+            address["properties"]["next"],
+            json!({"anyOf": [{"$ref": "#/$defs/Address"}, {"type": "null"}]})
+        );
+        let own = &documents[1];
+        assert_eq!(own["properties"]["next"]["anyOf"][0]["$ref"], "#");
+        assert!(own.get("$defs").is_none());
+    }
+
+    #[test]
+    fn test_generate_json_schemas_enums_and_unions() {
+        let schemas = vec![
+            schema(
+                "Level",
+                "api/level.go",
+                "{type: integer, x-go-enum: {flags: false, values: [{name: LevelDebug, value: 0}, {name: LevelInfo, value: 1}]}}",
+            ),
+            schema(
+                "Color",
+                "api/color.go",
+                "{type: string, nullable: true, x-go-enum: {flags: false, values: [{name: ColorRed, value: 0, string: red}, {name: ColorDefault, value: 0, string: red}]}}",
+            ),
+            schema(
+                "Shape",
+                "api/shape.go",
+                "{type: object, x-go-union: {discriminator: kind, variants: [{name: Circle, file: circle.libsonnet, value: Circle, params: []}]}}",
+            ),
+            schema("Circle", "api/circle.go", "{type: object, properties: {radius: {type: number}}}"),
+            schema(
+                "Drawing",
+                "api/drawing.go",
+                "{type: object, properties: {shapes: {type: array, items: {type: object, x-go-interface-ref: Shape}}}}",
+            ),
+        ];
+
+        let documents = documents(&schemas);
+        assert_eq!(documents[0]["enum"], json!([0, 1]));
+        assert_eq!(documents[1]["type"], json!(["string", "null"]));
+        assert_eq!(documents[1]["enum"], json!(["red", null]));
+        assert_eq!(documents[2]["oneOf"], json!([{"$ref": "#/$defs/Circle"}]));
+
+        let drawing = &documents[4];
+        assert_eq!(
+            drawing["properties"]["shapes"]["items"],
+            json!({"$ref": "#/$defs/Shape"})
+        );
+        assert_eq!(
+            drawing["$defs"]["Shape"]["oneOf"],
+            json!([{"$ref": "#/$defs/Circle"}])
+        );
+        assert_eq!(
+            drawing["$defs"]["Circle"]["properties"]["radius"]["type"],
+            "number"
+        );
+    }
+
+    #[test]
+    fn test_generate_draft07_schema() {
+        let schemas = vec![
+            schema(
+                "Values",
+                "chart/values.go",
+                "{type: object, properties: {image: {type: object, x-go-struct-ref: Image}}}",
+            ),
+            schema(
+                "Image",
+                "chart/image.go",
+                "{type: object, properties: {tag: {type: string}}}",
+            ),
+        ];
+
+        let values: Value =
+            serde_json::from_str(&generate_draft07_schema(&schemas, 0).unwrap()).unwrap();
+        assert_eq!(values["$schema"], DRAFT_07);
+        assert!(values.get("$id").is_none());
+        assert_eq!(
+            values["properties"]["image"],
+            json!({"$ref": "#/definitions/Image"})
+        );
+        assert_eq!(
+            values["definitions"]["Image"]["properties"]["tag"]["type"],
+            "string"
+        );
+    }
+}
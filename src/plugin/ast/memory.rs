@@ -0,0 +1,100 @@
+//! Generation from Go source text held in memory
+//!
+//! `generate_source` takes the files of a package as text and returns the
+//! generated libraries as strings, without reading or writing files, so
+//! other tools can embed the generator. The files are resolved together
+//! by the passes of a source (see `pipeline`), so unions, enums, named
+//! primitive types and constants of one file apply to the others and types
+//! of several packages with the same name are named apart.
+//!
+//! Only the libraries of the types, the constants library and
+//! `index.libsonnet` are generated; the files that need an output directory
+//! or an evaluator, such as tests, examples and reports, are not.
+
+use anyhow::{anyhow, Result};
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+
+use super::generator::GoJsonnetGenerator;
+use super::options::GoAstOptions;
+use super::parser::GoAstParser;
+use super::{constants, errors, pipeline, plugin, roots};
+
+/// Name the file of Go source text read from stdin is given
+pub const STDIN_FILE: &str = "stdin.go";
+
+/// Libraries generated from Go source text
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct GeneratedSource {
+    /// Jsonnet code by file name, as written to an output directory
+    pub files: BTreeMap<String, String>,
+
+    /// Warnings of the parser and of resolving the files together
+    pub warnings: Vec<String>,
+}
+
+/// Generate the libraries of Go files given as (path, source text)
+///
+/// Paths name the files in messages and place them in packages, by
+/// directory; they are not read. Fails with every error of the files.
+pub async fn generate_source(
+    files: &[(PathBuf, String)],
+    options: &GoAstOptions,
+) -> Result<GeneratedSource> {
+    let mut schemas = Vec::new();
+    let mut warnings = Vec::new();
+    let mut errors = Vec::new();
+    for (path, content) in files {
+        let mut parser = GoAstParser::with_options(options.clone());
+        parser.parse_content(content, path).await?;
+        let diagnostics = parser.diagnostics();
+        if !diagnostics.is_empty() {
+            errors.extend(
+                diagnostics
+                    .iter()
+                    .map(|diagnostic| format!("{}: {}", path.display(), diagnostic)),
+            );
+            continue;
+        }
+        schemas.extend(parser.extract_schemas());
+        schemas.extend(plugin::records(&parser));
+        warnings.extend(parser.warnings());
+    }
+    if !errors.is_empty() {
+        return Err(anyhow!("{}", errors.join("\n")));
+    }
+
+    warnings.extend(pipeline::resolve(&mut schemas, options)?);
+    let records = pipeline::take_records(&mut schemas, "", Path::new(""));
+    warnings.extend(records.warnings);
+    roots::take_unreachable(&mut schemas, options)?;
+    warnings.extend(pipeline::link(&mut schemas, options, &[], |_| 0)?);
+
+    let generator = GoJsonnetGenerator::new();
+    let mut generated = GeneratedSource {
+        warnings,
+        ..GeneratedSource::default()
+    };
+    for schema in &schemas {
+        generated
+            .files
+            .insert(generator.file_name(schema), generator.generate(schema)?);
+    }
+    let mut index = generator.generate_index(&schemas);
+    if !records.constants.is_empty() {
+        index = constants::link_constants(&index);
+        generated.files.insert(
+            constants::CONSTANTS_FILE.to_string(),
+            constants::generate_constants(&records.constants),
+        );
+    }
+    if !records.errors.is_empty() {
+        index = errors::link_errors(&index);
+        generated.files.insert(
+            errors::ERRORS_FILE.to_string(),
+            errors::generate_errors(&records.errors),
+        );
+    }
+    generated.files.insert("index.libsonnet".to_string(), index);
+    Ok(generated)
+}
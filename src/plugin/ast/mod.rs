@@ -1,16 +1,65 @@
 //! AST (Abstract Syntax Tree) processing for Go source code
 //! See: https://tree-sitter.github.io/tree-sitter/
 
+pub mod aliases;
+pub mod api;
+pub mod collisions;
+pub mod constants;
+pub mod cue;
+pub mod enums;
+pub mod errors;
 pub mod factory;
+pub mod generator;
+pub mod gomod;
+pub mod goproxy;
+pub mod gotest;
+pub mod gowork;
+pub mod helm;
+pub mod imports;
+pub mod jsonschema;
+pub mod memory;
+pub mod naming;
+pub mod obfuscate;
+pub mod options;
 pub mod parser;
+pub mod patterns;
+pub mod pipeline;
 pub mod plugin;
+pub mod references;
+pub mod roots;
+pub mod scheme;
+pub mod services;
+pub mod stream;
+pub mod tags;
+pub mod translations;
 pub mod types;
+pub mod typescript;
+pub mod unions;
+pub mod unsupported;
+pub mod validate;
 
 #[cfg(test)]
 mod tests;
 
 // Re-export main types for convenience
 pub use factory::GoAstPluginFactory;
-pub use parser::GoAstParser;
+pub use generator::GoJsonnetGenerator;
+pub use memory::{generate_source, GeneratedSource};
+pub use options::{
+    AnyPolicy, CollisionStrategy, ExampleOutput, FieldOverride, GoAstOptions, NamingPolicy,
+    ObfuscateOptions, PackageOutput, PatternChecks, PointerStrategy, TypeMapping, UnexportedPolicy,
+    UnionOptions, UnwrapRule,
+};
+pub use parser::{GoAstParser, MappingRule, MappingTrace};
 pub use plugin::GoAstPlugin;
+pub use tags::StructTag;
 pub use types::*;
+
+/// Get the directory of a schema's Go file, which identifies its package
+pub(crate) fn package_dir(schema: &crate::plugin::ExtractedSchema) -> std::path::PathBuf {
+    schema
+        .source_file
+        .parent()
+        .unwrap_or(std::path::Path::new(""))
+        .to_path_buf()
+}
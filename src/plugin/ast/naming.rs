@@ -0,0 +1,127 @@
+//! Case conversion for generated field and function names
+//!
+//! Names are split into words at separators (`_`, `-`, `.`, ...) and at case
+//! changes, keeping acronyms together (`AvatarURL` is `avatar` + `url`, and
+//! `HTTPServer` is `http` + `server`). Digits stay with the word before them.
+
+use super::options::NamingPolicy;
+
+impl NamingPolicy {
+    /// Apply the policy to a name
+    pub fn apply(&self, name: &str) -> String {
+        match self {
+            NamingPolicy::Preserve => name.to_string(),
+            NamingPolicy::CamelCase => camel_case(name),
+            NamingPolicy::SnakeCase => snake_case(name),
+        }
+    }
+
+    /// Join a prefix and a name as one name of the policy (`repo` and
+    /// `cache` give `repoCache`, or `repo_cache` in snake_case)
+    pub fn prefixed(&self, prefix: &str, name: &str) -> String {
+        match self {
+            NamingPolicy::Preserve => format!("{prefix}{}", capitalize(name)),
+            NamingPolicy::CamelCase => camel_case(&format!("{prefix}_{name}")),
+            NamingPolicy::SnakeCase => snake_case(&format!("{prefix}_{name}")),
+        }
+    }
+}
+
+/// Split a name into lowercase words
+pub fn words(name: &str) -> Vec<String> {
+    let chars: Vec<char> = name.chars().collect();
+    let mut words = Vec::new();
+    let mut current = String::new();
+
+    for (idx, &c) in chars.iter().enumerate() {
+        if !c.is_ascii_alphanumeric() {
+            if !current.is_empty() {
+                words.push(std::mem::take(&mut current));
+            }
+            continue;
+        }
+
+        if c.is_ascii_uppercase() && !current.is_empty() {
+            let prev = chars[idx - 1];
+            let next_lower = chars.get(idx + 1).is_some_and(|n| n.is_ascii_lowercase());
+            // A capital starts a word after a lowercase letter or a digit, and
+            // ends an acronym when a lowercase letter follows it
+            if prev.is_ascii_lowercase()
+                || prev.is_ascii_digit()
+                || (prev.is_ascii_uppercase() && next_lower)
+            {
+                words.push(std::mem::take(&mut current));
+            }
+        }
+        current.push(c.to_ascii_lowercase());
+    }
+    if !current.is_empty() {
+        words.push(current);
+    }
+
+    words
+}
+
+/// Convert a name to lowerCamelCase (`avatar_url` -> `avatarUrl`)
+pub fn camel_case(name: &str) -> String {
+    let mut result = String::new();
+    for (idx, word) in words(name).iter().enumerate() {
+        if idx == 0 {
+            result.push_str(word);
+        } else {
+            result.push_str(&capitalize(word));
+        }
+    }
+    result
+}
+
+/// Convert a name to snake_case (`createdAt` -> `created_at`)
+pub fn snake_case(name: &str) -> String {
+    words(name).join("_")
+}
+
+/// Uppercase the first letter of a word
+pub(super) fn capitalize(word: &str) -> String {
+    let mut chars = word.chars();
+    match chars.next() {
+        Some(first) => first.to_uppercase().chain(chars).collect(),
+        None => String::new(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_words() {
+        assert_eq!(words("avatar_url"), vec!["avatar", "url"]);
+        assert_eq!(words("createdAt"), vec!["created", "at"]);
+        assert_eq!(words("AvatarURL"), vec!["avatar", "url"]);
+        assert_eq!(words("HTTPServer"), vec!["http", "server"]);
+        assert_eq!(words("ipv4Address"), vec!["ipv4", "address"]);
+        assert_eq!(words("api-version"), vec!["api", "version"]);
+    }
+
+    #[test]
+    fn test_policies() {
+        assert_eq!(NamingPolicy::CamelCase.apply("avatar_url"), "avatarUrl");
+        assert_eq!(NamingPolicy::CamelCase.apply("CreatedAt"), "createdAt");
+        assert_eq!(NamingPolicy::SnakeCase.apply("createdAt"), "created_at");
+        assert_eq!(NamingPolicy::SnakeCase.apply("APIVersion"), "api_version");
+        assert_eq!(NamingPolicy::Preserve.apply("avatar_url"), "avatar_url");
+
+        assert_eq!(
+            NamingPolicy::Preserve.prefixed("repo", "cache"),
+            "repoCache"
+        );
+        assert_eq!(
+            NamingPolicy::CamelCase.prefixed("repo", "CacheTTL"),
+            "repoCacheTtl"
+        );
+        assert_eq!(
+            NamingPolicy::SnakeCase.prefixed("repo", "cacheTTL"),
+            "repo_cache_ttl"
+        );
+    }
+}
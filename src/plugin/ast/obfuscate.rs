@@ -0,0 +1,274 @@
+//! Name obfuscation for distributed library variants
+//!
+//! Replaces type and field names with salted hashes so generated helpers can
+//! be shared without revealing internal schema naming. The mapping from
+//! aliases back to the real names is returned separately and must be kept
+//! private: it is what translates objects built with the distributed helpers
+//! back into the real wire format.
+
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+
+use super::validate::FIELD_RULES_KEY;
+use crate::plugin::ExtractedSchema;
+
+/// Schema keys that carry internal names and are left out of the variant
+const REVEALING_KEYS: &[&str] = &[
+    "description",
+    "x-go-accessors",
+    "x-go-deprecated",
+    "x-go-excluded",
+    "x-go-embedded",
+    "x-go-interface",
+    "x-go-interface-ref",
+    "x-go-kubernetes",
+    "x-go-ref",
+    "x-go-union",
+    "x-go-source",
+    "x-go-struct-ref",
+];
+
+/// Number of hash characters in an alias
+const ALIAS_LENGTH: usize = 10;
+
+/// Private mapping from aliases to the real names
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct NameMapping {
+    /// Type aliases, keyed by alias
+    pub types: BTreeMap<String, String>,
+
+    /// Field aliases, keyed by alias, as `Type.field`
+    pub fields: BTreeMap<String, String>,
+}
+
+/// Renames types and fields to salted hash aliases
+#[derive(Debug, Clone)]
+pub struct Obfuscator {
+    salt: String,
+}
+
+impl Obfuscator {
+    /// Create an obfuscator
+    ///
+    /// The salt keeps aliases from being reversed by hashing guessed names.
+    pub fn new(salt: impl Into<String>) -> Self {
+        Self { salt: salt.into() }
+    }
+
+    /// Get the alias of a type
+    pub fn type_alias(&self, type_name: &str) -> String {
+        format!("T{}", self.hash(type_name))
+    }
+
+    /// Get the alias of a field of a type
+    pub fn field_alias(&self, type_name: &str, field: &str) -> String {
+        format!("f{}", self.hash(&format!("{type_name}.{field}")))
+    }
+
+    /// Build the obfuscated variant of a set of schemas
+    ///
+    /// Documentation, source paths and package metadata are dropped, since
+    /// they reveal the names being hidden.
+    pub fn obfuscate(&self, schemas: &[ExtractedSchema]) -> (Vec<ExtractedSchema>, NameMapping) {
+        let mut mapping = NameMapping::default();
+        let mut result = Vec::new();
+
+        for schema in schemas {
+            let alias = self.type_alias(&schema.name);
+            mapping.types.insert(alias.clone(), schema.name.clone());
+
+            let mut content = schema.content.clone();
+            if let Some(content) = content.as_mapping_mut() {
+                self.obfuscate_type(&schema.name, content, &mut mapping);
+            }
+
+            result.push(ExtractedSchema {
+                name: alias.clone(),
+                schema_type: schema.schema_type.clone(),
+                content,
+                source_file: format!("{alias}.go").into(),
+                metadata: Default::default(),
+            });
+        }
+
+        (result, mapping)
+    }
+
+    /// Rename the properties of a type schema
+    fn obfuscate_type(
+        &self,
+        type_name: &str,
+        content: &mut serde_yaml::Mapping,
+        mapping: &mut NameMapping,
+    ) {
+        strip_revealing(content);
+
+        let alias = |name: &str| self.field_alias(type_name, name);
+        let alias_all = |value: &mut serde_yaml::Value| {
+            if let Some(names) = value.as_sequence_mut() {
+                for name in names {
+                    if let Some(real) = name.as_str() {
+                        *name = serde_yaml::Value::String(alias(real));
+                    }
+                }
+            }
+        };
+
+        if let Some(required) = content.get_mut("required") {
+            alias_all(required);
+        }
+
+        let properties = match content
+            .get_mut("properties")
+            .and_then(|p| p.as_mapping_mut())
+        {
+            Some(properties) => std::mem::take(properties),
+            None => return,
+        };
+
+        let mut renamed = serde_yaml::Mapping::new();
+        for (name, mut property) in properties {
+            let name = match name.as_str() {
+                Some(name) => name.to_string(),
+                None => continue,
+            };
+            let field_alias = alias(&name);
+            mapping
+                .fields
+                .insert(field_alias.clone(), format!("{type_name}.{name}"));
+
+            if let Some(property) = property.as_mapping_mut() {
+                strip_revealing_nested(property);
+                if let Some(rules) = property
+                    .get_mut(FIELD_RULES_KEY)
+                    .and_then(|r| r.as_sequence_mut())
+                {
+                    for rule in rules {
+                        if let Some(fields) = rule.get_mut("fields") {
+                            alias_all(fields);
+                        }
+                    }
+                }
+                for key in ["x-go-replaces", "x-go-renamed-from"] {
+                    if let Some(names) = property.get_mut(key) {
+                        alias_all(names);
+                    }
+                }
+            }
+
+            renamed.insert(serde_yaml::Value::String(field_alias), property);
+        }
+
+        content.insert(
+            serde_yaml::Value::String("properties".to_string()),
+            serde_yaml::Value::Mapping(renamed),
+        );
+    }
+
+    fn hash(&self, name: &str) -> String {
+        let hash = crate::utils::calculate_string_hash(&format!("{}:{}", self.salt, name));
+        hash[..ALIAS_LENGTH].to_string()
+    }
+}
+
+/// Remove the keys revealing internal names from a schema
+fn strip_revealing(schema: &mut serde_yaml::Mapping) {
+    for key in REVEALING_KEYS {
+        schema.remove(*key);
+    }
+}
+
+/// Remove revealing keys from a property and its element schemas
+fn strip_revealing_nested(schema: &mut serde_yaml::Mapping) {
+    strip_revealing(schema);
+    for key in ["items", "additionalProperties"] {
+        if let Some(inner) = schema.get_mut(key).and_then(|i| i.as_mapping_mut()) {
+            strip_revealing_nested(inner);
+        }
+    }
+    if let Some(properties) = schema
+        .get_mut("properties")
+        .and_then(|p| p.as_mapping_mut())
+    {
+        for property in properties.values_mut() {
+            if let Some(property) = property.as_mapping_mut() {
+                strip_revealing_nested(property);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn user_schema() -> ExtractedSchema {
+        let mut name = serde_yaml::Mapping::new();
+        name.insert("type".into(), "string".into());
+        name.insert("description".into(), "Internal account name".into());
+        let mut properties = serde_yaml::Mapping::new();
+        properties.insert("name".into(), name.into());
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("properties".into(), properties.into());
+        content.insert("required".into(), vec!["name"].into());
+
+        let mut metadata = std::collections::HashMap::new();
+        metadata.insert("package".to_string(), "billing".into());
+
+        ExtractedSchema {
+            name: "User".to_string(),
+            schema_type: "go_struct".to_string(),
+            content: content.into(),
+            source_file: "internal/billing/user.go".into(),
+            metadata,
+        }
+    }
+
+    #[test]
+    fn test_aliases_are_salted_and_stable() {
+        let obfuscator = Obfuscator::new("secret");
+        assert_eq!(obfuscator.type_alias("User"), obfuscator.type_alias("User"));
+        assert_ne!(
+            obfuscator.type_alias("User"),
+            Obfuscator::new("other").type_alias("User")
+        );
+        assert_ne!(
+            obfuscator.field_alias("User", "name"),
+            obfuscator.field_alias("Group", "name")
+        );
+        assert_eq!(obfuscator.type_alias("User").len(), ALIAS_LENGTH + 1);
+    }
+
+    #[test]
+    fn test_obfuscate() {
+        let obfuscator = Obfuscator::new("secret");
+        let (schemas, mapping) = obfuscator.obfuscate(&[user_schema()]);
+        let schema = &schemas[0];
+
+        let type_alias = obfuscator.type_alias("User");
+        let field_alias = obfuscator.field_alias("User", "name");
+        assert_eq!(schema.name, type_alias);
+        assert!(schema.metadata.is_empty());
+        assert!(!schema.source_file.to_string_lossy().contains("billing"));
+
+        let property = schema
+            .content
+            .get("properties")
+            .and_then(|p| p.get(field_alias.as_str()))
+            .unwrap();
+        assert!(property.get("description").is_none());
+        assert_eq!(
+            schema.content.get("required"),
+            Some(&serde_yaml::Value::from(vec![field_alias.as_str()]))
+        );
+
+        assert_eq!(
+            mapping.types.get(&type_alias).map(String::as_str),
+            Some("User")
+        );
+        assert_eq!(
+            mapping.fields.get(&field_alias).map(String::as_str),
+            Some("User.name")
+        );
+    }
+}
@@ -0,0 +1,1186 @@
+//! Go AST plugin options
+//!
+//! Options are configured per Go AST source and passed to the plugin through
+//! `PluginConfig::config`.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::path::PathBuf;
+use std::str::FromStr;
+
+/// Options controlling how Go types are turned into schemas
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct GoAstOptions {
+    /// Schema overrides for named Go types
+    ///
+    /// Keys are written as in Go source (`time.Duration`, `MoneyAmount`) or
+    /// with the full import path (`github.com/acme/foo.MoneyAmount`).
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub type_mappings: BTreeMap<String, TypeMapping>,
+
+    /// Generic wrappers replaced by the types they hold
+    ///
+    /// Keys are written like those of `type_mappings`, without type
+    /// arguments (`mo.Option`, `github.com/acme/opt.Nullable`). Generic
+    /// `Optional`, `Option` and `Nullable` types of any package are unwrapped
+    /// as optional values, `List` as lists and `sync.Map` as a map unless a
+    /// rule says otherwise.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub unwrap_types: BTreeMap<String, UnwrapRule>,
+
+    /// Value written by the generated `withXNow()` setters of timestamp fields
+    ///
+    /// Jsonnet has no clock, so the placeholder is meant to be substituted
+    /// when the output is applied (e.g. `"${NOW}"`).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub now_placeholder: Option<String>,
+
+    /// Synthesize fields from `GetX()`/`SetX()` methods without a matching field
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub infer_accessors: bool,
+
+    /// How `interface{}`, `any` and `json.RawMessage` fields are represented
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub any_policy: Option<AnyPolicy>,
+
+    /// How pointer fields are represented when they are not set
+    ///
+    /// Pointers are marked `nullable` only when a strategy is set.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub pointer_strategy: Option<PointerStrategy>,
+
+    /// Case of the generated field names
+    ///
+    /// A name given by a `gensonnet:"name"` struct tag is used as is.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub field_naming: Option<NamingPolicy>,
+
+    /// Case of the generated helper functions (`withAvatarUrl` or
+    /// `with_avatar_url`)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub function_naming: Option<NamingPolicy>,
+
+    /// Per-field overrides, keyed by Go type and field name (`UserRepository.db`)
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub field_overrides: BTreeMap<String, FieldOverride>,
+
+    /// Types left out of generation, as glob patterns on `Type` or
+    /// `pkg.Type` (`*Request`)
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub exclude_types: Vec<String>,
+
+    /// Types generated, as glob patterns on `Type` or `pkg.Type`, with `!`
+    /// before patterns of types left out (`User*`, `!UserRepository`)
+    ///
+    /// The last matching pattern decides; when some pattern selects types,
+    /// types matching none are left out.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub types: Vec<String>,
+
+    /// Types generated with the types they reference, as `Type` or
+    /// `pkg.Type`; the other types are left out
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub roots: Vec<String>,
+
+    /// Fields left out of generation, as glob patterns on `Type.Field` or
+    /// `pkg.Type.Field` (`*.XXX_unrecognized`)
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub exclude_fields: Vec<String>,
+
+    /// Generated names of fields, keyed by glob patterns on `Type.Field` or
+    /// `pkg.Type.Field` (`*.Metadata: meta`)
+    ///
+    /// Unlike `renames`, no aliases are kept for the previous names.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub field_names: BTreeMap<String, String>,
+
+    /// Unions of struct types, keyed by the name of their library
+    /// (`Source: {variants: [GitSource, ImageSource]}`)
+    ///
+    /// Unlike the unions of interfaces, the variants need no common methods.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub unions: BTreeMap<String, UnionOptions>,
+
+    /// Struct fields whose own fields are generated in their place, with a
+    /// prefix, keyed by glob patterns on `Type.Field` or `pkg.Type.Field`
+    /// (`UserManager.repo: repo` turns `repo.cache` into `repoCache`)
+    ///
+    /// The flattened fields no longer match the encoding of the Go type.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub flatten_fields: BTreeMap<String, String>,
+
+    /// Whether the fields of each class are emitted hidden (`::`) or
+    /// visible (`:`); fields are visible by default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub visibility: Option<VisibilityPolicy>,
+
+    /// Visibility of single fields, keyed by glob patterns on `Type.Field`
+    /// or `pkg.Type.Field` (`*.Internal: hidden`)
+    ///
+    /// Takes precedence over `visibility` and `gensonnet:hidden` comments.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub field_visibility: BTreeMap<String, Visibility>,
+
+    /// How types of several packages with the same name are told apart
+    /// when they share an output directory
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub name_collisions: Option<CollisionStrategy>,
+
+    /// How unexported struct fields are handled
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub unexported_fields: Option<UnexportedPolicy>,
+
+    /// Renamed struct fields, keyed by the old field (`User.Email` or
+    /// `users.User.Email`), with the new Go field name as value
+    ///
+    /// The old setter is kept as a deprecated alias of the new one.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub renames: BTreeMap<String, String>,
+
+    /// Leave `omitempty` fields out of the manifested output until they
+    /// are set to another value than their zero value, as encoding/json does
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub omit_empty: bool,
+
+    /// Keep functions removed since the previous generation as tombstones
+    /// that fail with a message naming their replacement
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub tombstones: bool,
+
+    /// Also write jsonnetunit test scaffolding for each type to `tests/` in
+    /// the output directory
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub unit_tests: bool,
+
+    /// Also write Go tests to each package directory of `go_checkout`,
+    /// checking that the output of every `new()` unmarshals into the Go type
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub go_tests: bool,
+
+    /// Local checkout of the source's repository, where `go_tests` are
+    /// written
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub go_checkout: Option<PathBuf>,
+
+    /// Also write the exported package-level constants with a literal value
+    /// to `constants.libsonnet`, linked from the index as `constants`
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub constants: bool,
+
+    /// Also write the exported sentinel errors, error types and error code
+    /// constants to `errors.libsonnet`, linked from the index as `errors`
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub errors: bool,
+
+    /// Make the names printed by the generated `String()` method (stringer)
+    /// the values of integer enums, with lookup tables to and from integers
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub enum_strings: bool,
+
+    /// Also take defaults from constructors returning another type than
+    /// their name, and from the field assignments following the literal
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub constructor_analysis: bool,
+
+    /// Write the defaults of the fields to `defaults.libsonnet` instead of
+    /// into the constructors, so environments can replace them apart from
+    /// the helpers
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub defaults_library: bool,
+
+    /// How the patterns of `regexp=` rules and string formats are checked
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub pattern_checks: Option<PatternChecks>,
+
+    /// How the map mixins report merges replacing a value by one of another
+    /// type, such as a string by an object; unchecked by default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub merge_checks: Option<MergeChecks>,
+
+    /// Also give scalar fields a `withXFromEnv(varName)` setter, setting the
+    /// field from the external variable `varName` (`std.extVar`)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub env_setters: bool,
+
+    /// Download the modules of referenced packages outside the repository
+    /// through the Go module proxy and generate the referenced types
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub fetch_dependencies: bool,
+
+    /// Existing Jsonnet libraries of Go packages, keyed by package path
+    /// prefix (`k8s.io/api`), with the import path of the library as value
+    ///
+    /// Fields typed with these packages use the library instead of generated
+    /// types. The rest of the package path selects the object of the library
+    /// (`core/v1` is `core.v1`), and the type its lowerCamelCase field.
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub external_libraries: BTreeMap<String, String>,
+
+    /// Make the helpers of types and fields marked `Deprecated:` print a
+    /// warning with `std.trace` when they are used
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub deprecation_warnings: bool,
+
+    /// Documentation of the libraries in another language, falling back to
+    /// the Go comments
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub translations: Option<super::translations::TranslationOptions>,
+
+    /// Example programs written to `examples/` for each object type
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub examples: Option<ExampleOutput>,
+
+    /// Custom templates rendered with the type graph of the source into
+    /// the output directory (see `crate::template`)
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub templates: Vec<PathBuf>,
+
+    /// Also write a variant of the libraries with hashed type and field names
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub obfuscate: Option<ObfuscateOptions>,
+
+    /// Form of the imports between the generated libraries
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub imports: Option<super::imports::ImportOptions>,
+
+    /// Output directories of the types of selected packages, instead of the
+    /// output path of the source
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub package_outputs: Vec<PackageOutput>,
+
+    /// Parse and render one package at a time, keeping only outlines of the
+    /// written types, to bound the memory used by huge modules
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub streaming: bool,
+
+    /// Field whose schema resolution is logged, as `Type.Field` or
+    /// `pkg.Type.Field` (set by `generate --trace-mapping`)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub trace_mapping: Option<String>,
+
+    /// Release of the generated library, used in tombstone messages
+    ///
+    /// Tombstones are dropped when the release changes, so they last for one
+    /// deprecation cycle.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub release: Option<String>,
+
+    /// Struct, as `Type` or `package.Type`, written as the
+    /// `values.schema.json` and `values.libsonnet` of a Helm chart
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub helm_values: Option<String>,
+
+    /// Also write the request and response of each method of the
+    /// interfaces to `services/`, for test fixtures and mocks of services
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub services: bool,
+}
+
+impl GoAstOptions {
+    /// Read options from a plugin configuration value
+    pub fn from_value(value: &serde_yaml::Value) -> Result<Self> {
+        if value.is_null() {
+            return Ok(Self::default());
+        }
+
+        serde_yaml::from_value(value.clone())
+            .map_err(|e| anyhow!("Invalid Go AST plugin options: {}", e))
+    }
+
+    /// Convert options to a plugin configuration value
+    pub fn to_value(&self) -> Result<serde_yaml::Value> {
+        Ok(serde_yaml::to_value(self)?)
+    }
+
+    /// Check the glob patterns of the exclusion, naming and routing rules
+    pub fn validate_patterns(&self) -> Result<()> {
+        for pattern in self
+            .exclude_types
+            .iter()
+            .chain(&self.types)
+            .chain(&self.roots)
+            .chain(&self.exclude_fields)
+            .chain(self.field_names.keys())
+            .chain(self.flatten_fields.keys())
+            .chain(self.field_visibility.keys())
+            .chain(
+                self.package_outputs
+                    .iter()
+                    .flat_map(|output| &output.packages),
+            )
+        {
+            glob::Pattern::new(pattern.strip_prefix('!').unwrap_or(pattern))
+                .map_err(|e| anyhow!("Invalid pattern '{}': {}", pattern, e))?;
+        }
+        Ok(())
+    }
+
+    /// Whether a type of `package` matches `exclude_types`
+    pub fn excludes_type(&self, package: Option<&str>, type_name: &str) -> bool {
+        self.exclude_types
+            .iter()
+            .any(|pattern| matches_path(pattern, package, type_name))
+    }
+
+    /// Whether a type of `package` is selected by `types`
+    pub fn selects_type(&self, package: Option<&str>, type_name: &str) -> bool {
+        let mut selected = !self.types.iter().any(|pattern| !pattern.starts_with('!'));
+        for pattern in &self.types {
+            let (negated, pattern) = match pattern.strip_prefix('!') {
+                Some(pattern) => (true, pattern),
+                None => (false, pattern.as_str()),
+            };
+            if matches_path(pattern, package, type_name) {
+                selected = !negated;
+            }
+        }
+        selected
+    }
+
+    /// Whether a field matches `exclude_fields`
+    pub fn excludes_field(&self, package: Option<&str>, type_name: &str, field: &str) -> bool {
+        let path = format!("{type_name}.{field}");
+        self.exclude_fields
+            .iter()
+            .any(|pattern| matches_path(pattern, package, &path))
+    }
+
+    /// Get the name `field_names` gives a field
+    ///
+    /// A key naming the field exactly wins over patterns, which are tried in
+    /// key order.
+    pub fn field_name(&self, package: Option<&str>, type_name: &str, field: &str) -> Option<&str> {
+        field_rule(&self.field_names, package, type_name, field).map(String::as_str)
+    }
+
+    /// Get the prefix `flatten_fields` gives the fields of a field
+    ///
+    /// Keys are matched as for `field_names`.
+    pub fn flatten_prefix(
+        &self,
+        package: Option<&str>,
+        type_name: &str,
+        field: &str,
+    ) -> Option<&str> {
+        field_rule(&self.flatten_fields, package, type_name, field).map(String::as_str)
+    }
+
+    /// Get the visibility `field_visibility` gives a field
+    ///
+    /// Keys are matched as for `field_names`.
+    pub fn field_visibility(
+        &self,
+        package: Option<&str>,
+        type_name: &str,
+        field: &str,
+    ) -> Option<Visibility> {
+        field_rule(&self.field_visibility, package, type_name, field).copied()
+    }
+
+    /// Get the external library of a type of `package`, as its import path
+    /// and the path of the type inside it
+    ///
+    /// The longest matching prefix of `external_libraries` wins.
+    pub fn external_library(&self, package: &str, type_name: &str) -> Option<(&str, Vec<String>)> {
+        let (prefix, library) = self
+            .external_libraries
+            .iter()
+            .filter(|(prefix, _)| {
+                package
+                    .strip_prefix(prefix.as_str())
+                    .is_some_and(|rest| rest.is_empty() || rest.starts_with('/'))
+            })
+            .max_by_key(|(prefix, _)| prefix.len())?;
+
+        let mut path: Vec<String> = package[prefix.len()..]
+            .split('/')
+            .filter(|segment| !segment.is_empty())
+            .map(str::to_string)
+            .collect();
+        path.push(super::naming::camel_case(type_name));
+        Some((library.as_str(), path))
+    }
+
+    /// Get the old names of a renamed field of a type in `package`
+    pub fn renamed_from(&self, package: Option<&str>, type_name: &str, field: &str) -> Vec<&str> {
+        self.renames
+            .iter()
+            .filter(|(_, new_name)| *new_name == field)
+            .filter_map(|(old, _)| {
+                let parts: Vec<&str> = old.split('.').collect();
+                match parts.as_slice() {
+                    [old_type, old_field] if *old_type == type_name => Some(*old_field),
+                    [old_package, old_type, old_field]
+                        if Some(*old_package) == package && *old_type == type_name =>
+                    {
+                        Some(*old_field)
+                    }
+                    _ => None,
+                }
+            })
+            .collect()
+    }
+
+    /// Find the mapping for a Go type as it appears in a file
+    ///
+    /// `imports` maps package names (or aliases) to import paths, and `package`
+    /// is the name of the package the type reference appears in.
+    pub fn find_type_mapping(
+        &self,
+        go_type: &str,
+        imports: &HashMap<String, String>,
+        package: Option<&str>,
+    ) -> Option<&TypeMapping> {
+        find_by_type(&self.type_mappings, go_type, imports, package)
+    }
+
+    /// Find the unwrapping rule of a generic type as it appears in a file
+    ///
+    /// `go_type` is written with its type arguments, if any
+    /// (`mo.Option[string]`). Configured rules come first, then the built-in
+    /// ones; `keep` turns both off.
+    pub fn find_unwrap_rule(
+        &self,
+        go_type: &str,
+        imports: &HashMap<String, String>,
+        package: Option<&str>,
+    ) -> Option<UnwrapRule> {
+        let (base, generic) = match go_type.split_once('[') {
+            Some((base, _)) => (base, true),
+            None => (go_type, false),
+        };
+        let rule = find_by_type(&self.unwrap_types, base, imports, package)
+            .copied()
+            .or_else(|| {
+                let (qualifier, name) = match base.rsplit_once('.') {
+                    Some((qualifier, name)) => (imports.get(qualifier).map(String::as_str), name),
+                    None => (None, base),
+                };
+                match (qualifier, name) {
+                    (Some("sync"), "Map") if !generic => Some(UnwrapRule::Map),
+                    (_, "Optional" | "Option" | "Nullable") if generic => {
+                        Some(UnwrapRule::Optional)
+                    }
+                    (_, "List") if generic => Some(UnwrapRule::List),
+                    _ => None,
+                }
+            });
+        rule.filter(|rule| *rule != UnwrapRule::Keep)
+    }
+}
+
+/// Find the entry of a map keyed by Go type for a type as it appears in a file
+///
+/// `imports` maps package names (or aliases) to import paths, and `package`
+/// is the name of the package the type reference appears in.
+fn find_by_type<'a, V>(
+    entries: &'a BTreeMap<String, V>,
+    go_type: &str,
+    imports: &HashMap<String, String>,
+    package: Option<&str>,
+) -> Option<&'a V> {
+    if entries.is_empty() {
+        return None;
+    }
+
+    if let Some(entry) = entries.get(go_type) {
+        return Some(entry);
+    }
+
+    match go_type.split_once('.') {
+        // Qualified reference: resolve the package through the file's imports
+        Some((qualifier, name)) => {
+            let path = imports.get(qualifier)?;
+            entries.get(&format!("{path}.{name}"))
+        }
+        // Local reference: match `pkg.Name` or any `.../pkg.Name` import path
+        None => {
+            let package = package?;
+            let qualified = format!("{package}.{go_type}");
+            entries.get(&qualified).or_else(|| {
+                let suffix = format!("/{qualified}");
+                entries
+                    .iter()
+                    .find(|(key, _)| key.ends_with(&suffix))
+                    .map(|(_, entry)| entry)
+            })
+        }
+    }
+}
+
+/// How a generic wrapper is replaced by the types it holds
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum UnwrapRule {
+    /// The type argument, as if the field were declared with it
+    Inner,
+
+    /// The type argument, optional and nullable like a pointer to it
+    Optional,
+
+    /// An array of the type argument
+    List,
+
+    /// An object with values of the last type argument, or of any value
+    /// without type arguments
+    Map,
+
+    /// The wrapper itself, turning off a built-in rule
+    Keep,
+}
+
+/// Match a glob pattern against `path` or `package.path`
+///
+/// Invalid patterns match nothing; they are reported by `validate_patterns`.
+pub(super) fn matches_path(pattern: &str, package: Option<&str>, path: &str) -> bool {
+    let Ok(pattern) = glob::Pattern::new(pattern) else {
+        return false;
+    };
+    pattern.matches(path)
+        || package.is_some_and(|package| pattern.matches(&format!("{package}.{path}")))
+}
+
+/// Get the value of the rule of a field, keyed by `Type.Field` or
+/// `pkg.Type.Field` patterns
+///
+/// A key naming the field exactly wins over patterns, which are tried in
+/// key order.
+fn field_rule<'a, T>(
+    rules: &'a BTreeMap<String, T>,
+    package: Option<&str>,
+    type_name: &str,
+    field: &str,
+) -> Option<&'a T> {
+    let path = format!("{type_name}.{field}");
+    let exact = package
+        .and_then(|package| rules.get(&format!("{package}.{path}")))
+        .or_else(|| rules.get(&path));
+    exact.or_else(|| {
+        rules
+            .iter()
+            .find(|(pattern, _)| matches_path(pattern, package, &path))
+            .map(|(_, value)| value)
+    })
+}
+
+/// Policy for fields whose values can hold arbitrary JSON
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum AnyPolicy {
+    /// Map to a free-form object
+    #[default]
+    Object,
+
+    /// Leave the field out of the generated library
+    Skip,
+
+    /// Fail unless the field has a schema in `field_overrides`
+    Require,
+}
+
+impl FromStr for AnyPolicy {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "object" => Ok(AnyPolicy::Object),
+            "skip" => Ok(AnyPolicy::Skip),
+            "require" => Ok(AnyPolicy::Require),
+            other => Err(anyhow!(
+                "Unknown any policy '{}' (expected object, skip or require)",
+                other
+            )),
+        }
+    }
+}
+
+/// Policy for unexported struct fields, which encoding/json never encodes
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum UnexportedPolicy {
+    /// Leave the field out of the generated library
+    #[default]
+    Skip,
+
+    /// Generate the field, with a warning
+    Include,
+
+    /// Fail unless the field is tagged `json:"-"` or excluded
+    Fail,
+}
+
+impl FromStr for UnexportedPolicy {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "skip" => Ok(UnexportedPolicy::Skip),
+            "include" => Ok(UnexportedPolicy::Include),
+            "fail" => Ok(UnexportedPolicy::Fail),
+            other => Err(anyhow!(
+                "Unknown unexported field policy '{}' (expected skip, include or fail)",
+                other
+            )),
+        }
+    }
+}
+
+/// Whether a field is emitted hidden or visible
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Visibility {
+    /// `::`, left out when the object is manifested
+    Hidden,
+
+    /// `:`
+    Visible,
+}
+
+/// Visibility of the fields of each class
+///
+/// A field is of the first class it belongs to: parameters of `new()`,
+/// fields with a default, slices and maps with a mixin, or other optional
+/// fields.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct VisibilityPolicy {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parameters: Option<Visibility>,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub defaults: Option<Visibility>,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub mixins: Option<Visibility>,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub optional: Option<Visibility>,
+}
+
+/// Evaluation of the pattern checks of the generated libraries
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum PatternChecks {
+    /// Compile patterns to checks with standard library string functions;
+    /// patterns they cannot express are not checked
+    #[default]
+    Strings,
+
+    /// Call the `regexMatch` native function, which Tanka provides and
+    /// other evaluators must register
+    Native,
+
+    /// Call the native functions of `gensonnet natives` that the evaluator
+    /// registers, and compile the patterns as with `strings` for the rest
+    Auto,
+}
+
+/// Report of the type-incompatible merges of the map mixins
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum MergeChecks {
+    /// Print the merge with `std.trace` and keep the new value
+    Trace,
+
+    /// Fail the evaluation
+    Assert,
+}
+
+/// Naming of types of several packages with the same name
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum CollisionStrategy {
+    /// Fail, naming the colliding types
+    #[default]
+    Error,
+
+    /// Prefix the names with their package (`V1User`, `V2User`)
+    Qualify,
+
+    /// Suffix the names with the API version of their package directory
+    /// (`UserV1`, `UserV2beta1`), or else prefix them with their package
+    Version,
+}
+
+/// Example programs written next to the generated libraries
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ExampleOutput {
+    /// No examples
+    #[default]
+    None,
+
+    /// A Jsonnet program per type
+    Jsonnet,
+
+    /// The Jsonnet programs, each also rendered to `<type>.json`
+    Json,
+
+    /// The Jsonnet programs, with the resources also rendered to a
+    /// multi-document YAML file
+    Yaml,
+}
+
+/// Strategy for pointer fields, whose Go zero value encodes as `null`
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum PointerStrategy {
+    /// Leave the field out until it is set
+    #[default]
+    Omit,
+
+    /// Initialize the field to `null` in `new()`
+    Null,
+
+    /// Generate a `withXNull()` setter next to `withX()`
+    Both,
+
+    /// Generate `withXNull()` and `withoutX()` setters and an `isSet()`
+    /// check, so unset, null and a value can each be expressed
+    Presence,
+}
+
+/// Case convention applied to generated names
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub enum NamingPolicy {
+    /// Keep names as they are
+    #[default]
+    #[serde(rename = "preserve")]
+    Preserve,
+
+    /// lowerCamelCase (`avatarUrl`)
+    #[serde(rename = "camelCase")]
+    CamelCase,
+
+    /// snake_case (`avatar_url`)
+    #[serde(rename = "snake_case")]
+    SnakeCase,
+}
+
+/// A union of struct types declared in the configuration
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct UnionOptions {
+    /// Types of the union, as `Type` or `pkg.Type`, all in one package
+    pub variants: Vec<String>,
+
+    /// Field telling the variants apart, `kind` by default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub discriminator: Option<String>,
+}
+
+/// Settings for the obfuscated library variant
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct ObfuscateOptions {
+    /// Output directory of the obfuscated variant
+    pub output_path: PathBuf,
+
+    /// File receiving the private mapping from aliases to real names
+    pub mapping_file: PathBuf,
+
+    /// Secret mixed into the hashes so aliases cannot be guessed
+    pub salt: String,
+}
+
+/// Output directory of the types of some packages
+///
+/// Orgs publishing one library repository per service route each service's
+/// packages to the checkout of its repository, and push it with the hook.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct PackageOutput {
+    /// Glob patterns matched against the package name or import path
+    pub packages: Vec<String>,
+
+    pub output_path: PathBuf,
+
+    /// Shell command run in the output directory once it is generated
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub post_hook: Option<String>,
+}
+
+impl PackageOutput {
+    /// Whether the output receives the types of a package
+    pub fn matches(&self, package: &str, import_path: Option<&str>) -> bool {
+        self.packages.iter().any(|pattern| {
+            glob::Pattern::new(pattern).is_ok_and(|pattern| {
+                pattern.matches(package) || import_path.is_some_and(|path| pattern.matches(path))
+            })
+        })
+    }
+}
+
+/// Override for a single struct field
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct FieldOverride {
+    /// Policy used instead of the source's `any_policy`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub policy: Option<AnyPolicy>,
+
+    /// Schema used for the field instead of the one derived from its type
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub schema: Option<serde_yaml::Value>,
+
+    /// Go type whose schema the field takes, such as `ClusterConfig` for a
+    /// `map[string]interface{}` blob holding one
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub go_type: Option<String>,
+}
+
+/// Schema override for a named Go type
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct TypeMapping {
+    /// Schema type (string, number, integer, boolean, object, array)
+    #[serde(rename = "type")]
+    pub schema_type: String,
+
+    /// Schema format (e.g. `date-time`, `duration`)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub format: Option<String>,
+
+    /// Regular expression values must match
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub pattern: Option<String>,
+
+    /// Description used when the field has no documentation of its own
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+}
+
+impl TypeMapping {
+    /// Build the schema for this mapping
+    pub fn to_schema(&self) -> serde_yaml::Mapping {
+        let mut schema = serde_yaml::Mapping::new();
+        schema.insert(
+            serde_yaml::Value::String("type".to_string()),
+            serde_yaml::Value::String(self.schema_type.clone()),
+        );
+
+        let optional = [
+            ("format", &self.format),
+            ("pattern", &self.pattern),
+            ("description", &self.description),
+        ];
+        for (key, value) in optional {
+            if let Some(value) = value {
+                schema.insert(
+                    serde_yaml::Value::String(key.to_string()),
+                    serde_yaml::Value::String(value.clone()),
+                );
+            }
+        }
+
+        schema
+    }
+}
+
+/// Get the built-in mapping for well-known standard library types
+///
+/// These types have custom JSON encodings, so their struct internals must not
+/// be expanded into the schema.
+pub fn builtin_type_mapping(import_path: &str, name: &str) -> Option<TypeMapping> {
+    let format = match (import_path, name) {
+        ("time", "Time") | ("k8s.io/apimachinery/pkg/apis/meta/v1", "Time" | "MicroTime") => {
+            "date-time"
+        }
+        ("time", "Duration") | ("k8s.io/apimachinery/pkg/apis/meta/v1", "Duration") => "duration",
+        ("k8s.io/apimachinery/pkg/api/resource", "Quantity") => "quantity",
+        _ => return None,
+    };
+
+    Some(TypeMapping {
+        schema_type: "string".to_string(),
+        format: Some(format.to_string()),
+        pattern: None,
+        description: None,
+    })
+}
+
+/// Get the package name Go assigns to an import path by default
+pub fn default_package_name(import_path: &str) -> &str {
+    let mut segments = import_path.rsplit('/');
+    let last = segments.next().unwrap_or(import_path);
+
+    // Major version suffixes (`.../v2`) are not part of the package name
+    let is_version =
+        last.len() > 1 && last.starts_with('v') && last[1..].chars().all(|c| c.is_ascii_digit());
+    let name = if is_version {
+        segments.next().unwrap_or(last)
+    } else {
+        last
+    };
+
+    // gopkg.in style paths carry the version after a dot (`yaml.v3`)
+    name.split('.').next().unwrap_or(name)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn options(keys: &[&str]) -> GoAstOptions {
+        let mut options = GoAstOptions::default();
+        for key in keys {
+            options.type_mappings.insert(
+                key.to_string(),
+                TypeMapping {
+                    schema_type: "string".to_string(),
+                    format: None,
+                    pattern: None,
+                    description: None,
+                },
+            );
+        }
+        options
+    }
+
+    #[test]
+    fn test_find_type_mapping_as_written() {
+        let options = options(&["time.Duration"]);
+        let imports = HashMap::new();
+
+        assert!(options
+            .find_type_mapping("time.Duration", &imports, Some("api"))
+            .is_some());
+        assert!(options
+            .find_type_mapping("time.Time", &imports, Some("api"))
+            .is_none());
+    }
+
+    #[test]
+    fn test_find_unwrap_rule() {
+        let options: GoAstOptions = serde_yaml::from_str(
+            "unwrap_types: {github.com/samber/mo.Either: inner, opt.Nullable: keep, Box: inner}",
+        )
+        .unwrap();
+        let imports = HashMap::from([
+            ("mo".to_string(), "github.com/samber/mo".to_string()),
+            ("sync".to_string(), "sync".to_string()),
+        ]);
+        let rule = |go_type: &str| options.find_unwrap_rule(go_type, &imports, Some("api"));
+
+        assert_eq!(rule("mo.Either[string, int]"), Some(UnwrapRule::Inner));
+        assert_eq!(rule("Box[User]"), Some(UnwrapRule::Inner));
+        assert_eq!(rule("mo.Option[string]"), Some(UnwrapRule::Optional));
+        assert_eq!(rule("Nullable[int]"), Some(UnwrapRule::Optional));
+        assert_eq!(rule("List[*User]"), Some(UnwrapRule::List));
+        assert_eq!(rule("sync.Map"), Some(UnwrapRule::Map));
+
+        // Built-in rules need type arguments, and `keep` turns them off
+        assert_eq!(rule("Option"), None);
+        assert_eq!(rule("opt.Nullable[int]"), None);
+        assert_eq!(rule("mo.Result[int]"), None);
+    }
+
+    #[test]
+    fn test_find_type_mapping_by_import_path() {
+        let options = options(&["github.com/acme/foo.MoneyAmount"]);
+        let imports = HashMap::from([("money".to_string(), "github.com/acme/foo".to_string())]);
+
+        assert!(options
+            .find_type_mapping("money.MoneyAmount", &imports, Some("api"))
+            .is_some());
+        // Local reference from within the foo package
+        assert!(options
+            .find_type_mapping("MoneyAmount", &HashMap::new(), Some("foo"))
+            .is_some());
+        assert!(options
+            .find_type_mapping("MoneyAmount", &HashMap::new(), Some("bar"))
+            .is_none());
+    }
+
+    #[test]
+    fn test_renamed_from() {
+        let mut options = GoAstOptions::default();
+        options
+            .renames
+            .insert("User.Email".to_string(), "PrimaryEmail".to_string());
+        options
+            .renames
+            .insert("billing.Account.Mail".to_string(), "Email".to_string());
+
+        assert_eq!(
+            options.renamed_from(Some("users"), "User", "PrimaryEmail"),
+            vec!["Email"]
+        );
+        assert_eq!(
+            options.renamed_from(Some("billing"), "Account", "Email"),
+            vec!["Mail"]
+        );
+        assert!(options
+            .renamed_from(Some("users"), "Account", "Email")
+            .is_empty());
+        assert!(options.renamed_from(None, "User", "Email").is_empty());
+    }
+
+    #[test]
+    fn test_external_library() {
+        let mut options = GoAstOptions::default();
+        let k8s = "github.com/jsonnet-libs/k8s-libsonnet/1.29/main.libsonnet";
+        options
+            .external_libraries
+            .insert("k8s.io/api".to_string(), k8s.to_string());
+        options
+            .external_libraries
+            .insert("k8s.io/apimachinery/pkg/apis".to_string(), k8s.to_string());
+
+        assert_eq!(
+            options.external_library("k8s.io/api/core/v1", "PodTemplateSpec"),
+            Some((
+                k8s,
+                vec!["core".into(), "v1".into(), "podTemplateSpec".into()]
+            ))
+        );
+        assert_eq!(
+            options.external_library("k8s.io/apimachinery/pkg/apis/meta/v1", "ObjectMeta"),
+            Some((k8s, vec!["meta".into(), "v1".into(), "objectMeta".into()]))
+        );
+        assert!(options
+            .external_library("k8s.io/apiserver/pkg", "Config")
+            .is_none());
+    }
+
+    #[test]
+    fn test_exclusion_and_naming_rules() {
+        let options: GoAstOptions = serde_yaml::from_str(concat!(
+            "exclude_fields: ['*.XXX_unrecognized', 'billing.Account.Secret']\n",
+            "exclude_types: ['*Request', 'internal.*']\n",
+            "field_names: {'*.Metadata': meta, 'User.Metadata': userMeta}\n",
+            "flatten_fields: {'*Manager.repo': repo, 'users.UserManager.repo': store}\n",
+        ))
+        .unwrap();
+        options.validate_patterns().unwrap();
+
+        assert!(options.excludes_type(None, "CreateUserRequest"));
+        assert!(options.excludes_type(Some("internal"), "Cache"));
+        assert!(!options.excludes_type(Some("users"), "User"));
+
+        let selected: GoAstOptions =
+            serde_yaml::from_str("types: ['User*', '!UserRepository', '!*.UserList']").unwrap();
+        selected.validate_patterns().unwrap();
+        assert!(selected.selects_type(Some("users"), "UserSpec"));
+        assert!(!selected.selects_type(Some("users"), "UserRepository"));
+        assert!(!selected.selects_type(Some("users"), "UserList"));
+        assert!(!selected.selects_type(Some("users"), "Group"));
+        let negated: GoAstOptions = serde_yaml::from_str("types: ['!*Repository']").unwrap();
+        assert!(negated.selects_type(None, "Group"));
+        assert!(!negated.selects_type(None, "UserRepository"));
+        assert!(options.selects_type(None, "Group"));
+
+        assert!(options.excludes_field(None, "User", "XXX_unrecognized"));
+        assert!(options.excludes_field(Some("billing"), "Account", "Secret"));
+        assert!(!options.excludes_field(Some("users"), "Account", "Secret"));
+
+        assert_eq!(options.field_name(None, "Group", "Metadata"), Some("meta"));
+        assert_eq!(
+            options.field_name(Some("users"), "User", "Metadata"),
+            Some("userMeta")
+        );
+        assert_eq!(options.field_name(None, "Group", "Name"), None);
+
+        assert_eq!(
+            options.flatten_prefix(None, "GroupManager", "repo"),
+            Some("repo")
+        );
+        assert_eq!(
+            options.flatten_prefix(Some("users"), "UserManager", "repo"),
+            Some("store")
+        );
+
+        let visibility: GoAstOptions = serde_yaml::from_str(concat!(
+            "visibility: {defaults: hidden}\n",
+            "field_visibility: {'*.Internal*': hidden, 'users.User.InternalID': visible}\n",
+        ))
+        .unwrap();
+        visibility.validate_patterns().unwrap();
+        assert_eq!(
+            visibility.visibility.as_ref().and_then(|v| v.defaults),
+            Some(Visibility::Hidden)
+        );
+        assert_eq!(
+            visibility.field_visibility(None, "Group", "InternalNote"),
+            Some(Visibility::Hidden)
+        );
+        assert_eq!(
+            visibility.field_visibility(Some("users"), "User", "InternalID"),
+            Some(Visibility::Visible)
+        );
+        assert_eq!(visibility.field_visibility(None, "User", "Name"), None);
+        assert_eq!(options.flatten_prefix(None, "UserManager", "service"), None);
+
+        let invalid: GoAstOptions = serde_yaml::from_str("exclude_types: ['[']").unwrap();
+        assert!(invalid.validate_patterns().is_err());
+    }
+
+    #[test]
+    fn test_builtin_type_mapping() {
+        let time = builtin_type_mapping("time", "Time").unwrap();
+        assert_eq!(time.format.as_deref(), Some("date-time"));
+
+        let duration = builtin_type_mapping("time", "Duration").unwrap();
+        assert_eq!(duration.format.as_deref(), Some("duration"));
+        let quantity = builtin_type_mapping("k8s.io/apimachinery/pkg/api/resource", "Quantity");
+        assert_eq!(quantity.unwrap().format.as_deref(), Some("quantity"));
+
+        assert!(builtin_type_mapping("time", "Month").is_none());
+        assert!(builtin_type_mapping("github.com/acme/time", "Time").is_none());
+    }
+
+    #[test]
+    fn test_any_policy_from_str() {
+        assert_eq!("skip".parse::<AnyPolicy>().unwrap(), AnyPolicy::Skip);
+        assert_eq!("require".parse::<AnyPolicy>().unwrap(), AnyPolicy::Require);
+        assert!("free".parse::<AnyPolicy>().is_err());
+    }
+
+    #[test]
+    fn test_unexported_policy_from_str() {
+        assert_eq!(
+            "include".parse::<UnexportedPolicy>().unwrap(),
+            UnexportedPolicy::Include
+        );
+        assert_eq!(
+            "fail".parse::<UnexportedPolicy>().unwrap(),
+            UnexportedPolicy::Fail
+        );
+        assert!("warn".parse::<UnexportedPolicy>().is_err());
+        assert_eq!(
+            GoAstOptions::default()
+                .unexported_fields
+                .unwrap_or_default(),
+            UnexportedPolicy::Skip
+        );
+    }
+
+    #[test]
+    fn test_default_package_name() {
+        assert_eq!(default_package_name("time"), "time");
+        assert_eq!(default_package_name("github.com/acme/foo"), "foo");
+        assert_eq!(default_package_name("github.com/acme/foo/v2"), "foo");
+        assert_eq!(default_package_name("gopkg.in/yaml.v3"), "yaml");
+    }
+
+    #[test]
+    fn test_package_outputs() {
+        let options = GoAstOptions::from_value(
+            &serde_yaml::from_str(
+                "{package_outputs: [{output_path: ../users-lib, packages: [github.com/acme/users/*], post_hook: git push}, {output_path: ../billing-lib, packages: [billing]}]}",
+            )
+            .unwrap(),
+        )
+        .unwrap();
+        let route = |package: &str, import_path: Option<&str>| {
+            options
+                .package_outputs
+                .iter()
+                .position(|output| output.matches(package, import_path))
+        };
+
+        assert_eq!(route("api", Some("github.com/acme/users/api")), Some(0));
+        assert_eq!(route("billing", Some("github.com/acme/billing")), Some(1));
+        assert_eq!(route("orders", Some("github.com/acme/orders")), None);
+        assert_eq!(
+            options.package_outputs[0].post_hook.as_deref(),
+            Some("git push")
+        );
+        assert!(options.validate_patterns().is_ok());
+    }
+}
@@ -1,12 +1,35 @@
 //! Go AST parser implementation
 
 use anyhow::Result;
-use std::collections::HashMap;
+use std::collections::{BTreeMap, BTreeSet, HashMap, HashSet};
 use std::path::{Path, PathBuf};
 use tree_sitter::{Language, Node, Parser};
 
+use super::aliases::{
+    LOCAL_TYPE_KEY, PRIMITIVE_TYPES_SCHEMA_TYPE, PRIMITIVE_TYPE_KEY, STRUCT_REF_KEY,
+};
+use super::constants::{
+    Constant, CONSTANTS_SCHEMA_TYPE, CONSTANT_VALUES_SCHEMA_TYPE, DEFAULT_CONSTANT_KEY,
+};
+use super::enums::{EnumValue, ENUM_SCHEMA_TYPE};
+use super::errors::{ErrorKind, GoError, ERRORS_SCHEMA_TYPE};
+use super::generator::{
+    DEFAULTS_LIBRARY_KEY, ENV_SETTERS_KEY, MAP_KEY_KEY, MERGE_CHECKS_KEY, METADATA_MAP_KEY,
+    OMIT_EMPTY_KEY, PATTERN_CHECKS_KEY, POINTER_KEY, QUALIFIED_NAME_FORMAT, VISIBILITY_KEY,
+};
+use super::options::{
+    builtin_type_mapping, default_package_name, AnyPolicy, GoAstOptions, MergeChecks, NamingPolicy,
+    PatternChecks, PointerStrategy, TypeMapping, UnexportedPolicy, UnwrapRule, Visibility,
+};
+use super::patterns;
+use super::scheme::GROUP_VERSION_SCHEMA_TYPE;
 use super::types::*;
+use super::unions::METHODS_SCHEMA_TYPE;
+use super::unsupported::{Unsupported, UnsupportedKind};
+use super::validate::{ValidateRules, FIELD_RULES_KEY};
+use crate::diagnostics::Code;
 use crate::plugin::*;
+use crate::report::SKIPPED_SCHEMA_TYPE;
 
 /// Go AST parser using tree-sitter
 #[allow(dead_code)]
@@ -25,6 +48,44 @@ pub struct GoAstParser {
 
     /// Package information
     package_info: Option<PackageNode>,
+
+    /// Imported packages by name (or alias) to import path
+    imports: HashMap<String, String>,
+
+    /// Field defaults found in `NewXxx` constructors, by type and Go field name
+    constructor_defaults: HashMap<String, HashMap<String, FieldDefault>>,
+
+    /// Values of the constants of this file, computed from their expressions
+    const_values: HashMap<String, serde_yaml::Value>,
+
+    /// Type parameter lists of the generic types declared in this file
+    type_params: HashMap<String, String>,
+
+    /// Group and version of the `GroupVersion` variable of this file
+    group_version: Option<(String, String)>,
+
+    /// Types registered with the scheme by the `init` functions of this file
+    registered_kinds: Vec<String>,
+
+    /// Exported constants of this file, with the `constants` option
+    constants: Vec<Constant>,
+
+    /// Exported errors and error codes of this file, with the `errors` option
+    go_errors: Vec<GoError>,
+
+    /// Constants of the `iota` blocks of this file, as (type, flags, values)
+    enums: Vec<(String, bool, Vec<EnumValue>)>,
+
+    /// Named primitive types and aliases of primitives declared in this file
+    ///
+    /// Named types have a `Basic` definition and aliases an `Alias` one.
+    primitive_types: Vec<TypeDeclNode>,
+
+    /// Names of enum values in the tables of stringer, by type
+    stringer_names: HashMap<String, Vec<String>>,
+
+    /// Schema extraction options
+    options: GoAstOptions,
 }
 
 impl Default for GoAstParser {
@@ -36,6 +97,11 @@ impl Default for GoAstParser {
 impl GoAstParser {
     /// Create a new Go AST parser
     pub fn new() -> Self {
+        Self::with_options(GoAstOptions::default())
+    }
+
+    /// Create a new Go AST parser with the given options
+    pub fn with_options(options: GoAstOptions) -> Self {
         let mut parser = Parser::new();
         let language = tree_sitter_go::language();
         parser.set_language(language).unwrap();
@@ -46,6 +112,18 @@ impl GoAstParser {
             nodes: Vec::new(),
             type_defs: HashMap::new(),
             package_info: None,
+            imports: HashMap::new(),
+            constructor_defaults: HashMap::new(),
+            const_values: HashMap::new(),
+            type_params: HashMap::new(),
+            group_version: None,
+            registered_kinds: Vec::new(),
+            constants: Vec::new(),
+            go_errors: Vec::new(),
+            enums: Vec::new(),
+            primitive_types: Vec::new(),
+            stringer_names: HashMap::new(),
+            options,
         }
     }
 
@@ -61,6 +139,17 @@ impl GoAstParser {
         self.nodes.clear();
         self.type_defs.clear();
         self.package_info = None;
+        self.imports.clear();
+        self.constructor_defaults.clear();
+        self.const_values.clear();
+        self.type_params.clear();
+        self.group_version = None;
+        self.registered_kinds.clear();
+        self.constants.clear();
+        self.go_errors.clear();
+        self.enums.clear();
+        self.primitive_types.clear();
+        self.stringer_names.clear();
 
         // Parse with tree-sitter
         let tree = self.parser.parse(content, None).unwrap();
@@ -72,9 +161,31 @@ impl GoAstParser {
         // Extract type declarations
         self.extract_type_declarations(&root_node, file_path, content)?;
 
+        // Evaluate the constants, which defaults and enums refer to
+        self.extract_const_values(&root_node, content);
+
         // Extract function declarations (including methods)
         self.extract_function_declarations(&root_node, file_path, content)?;
 
+        // Extract the scheme registration of Kubernetes API packages
+        self.extract_scheme_registration(&root_node, content);
+
+        // Extract the exported constants for the constants library
+        if self.options.constants {
+            self.extract_constants(&root_node, content);
+        }
+
+        // Extract the sentinel errors, error types and codes for the errors library
+        if self.options.errors {
+            self.extract_errors(&root_node, content);
+        }
+
+        // Extract the values of integer enums counted with iota
+        self.extract_enums(&root_node, content);
+        if self.options.enum_strings {
+            self.extract_stringer_names(&root_node, content);
+        }
+
         // Extract imports
         self.extract_imports(&root_node, file_path, content)?;
 
@@ -145,10 +256,14 @@ impl GoAstParser {
         for child in type_decl_node.children(&mut cursor) {
             if child.kind() == "type_spec" {
                 self.process_type_spec(&child, file_path, content)?;
+            } else if child.kind() == "type_alias" {
+                self.process_primitive_type(&child, file_path, content, true);
             } else if child.kind() == "type_spec_list" {
                 for spec in child.children(&mut child.walk()) {
                     if spec.kind() == "type_spec" {
                         self.process_type_spec(&spec, file_path, content)?;
+                    } else if spec.kind() == "type_alias" {
+                        self.process_primitive_type(&spec, file_path, content, true);
                     }
                 }
             }
@@ -166,12 +281,14 @@ impl GoAstParser {
     ) -> Result<()> {
         let mut name_node = None;
         let mut type_node = None;
+        let mut type_params = None;
         let mut cursor = type_spec.walk();
 
         // Extract name and type
         for child in type_spec.children(&mut cursor) {
             match child.kind() {
                 "type_identifier" => name_node = Some(child),
+                "type_parameter_list" => type_params = Some(child),
                 "struct_type" | "interface_type" | "array_type" | "pointer_type" | "map_type"
                 | "slice_type" | "channel_type" | "function_type" => {
                     type_node = Some(child);
@@ -180,6 +297,12 @@ impl GoAstParser {
             }
         }
 
+        // Named primitives are not types of their own, unless `iota` makes
+        // an integer one an enum: fields take the schema of the primitive
+        if type_node.is_none() {
+            self.process_primitive_type(type_spec, file_path, content, false);
+        }
+
         if let (Some(name), Some(type_def_node)) = (name_node, type_node) {
             let type_name = self.get_node_text(name, content);
             let type_definition = self.parse_type_definition(&type_def_node, content)?;
@@ -193,11 +316,127 @@ impl GoAstParser {
 
             self.nodes.push(GoAstNode::TypeDecl(type_decl));
             self.type_defs.insert(type_name.clone(), type_definition);
+            if let Some(type_params) = type_params {
+                self.type_params
+                    .insert(type_name, self.get_node_text(type_params, content));
+            }
         }
 
         Ok(())
     }
 
+    /// Record a named primitive type (`type Port int`) or an alias of a
+    /// primitive type (`type Port = int`)
+    fn process_primitive_type(
+        &mut self,
+        spec: &Node,
+        file_path: &Path,
+        content: &str,
+        alias: bool,
+    ) {
+        let (Some(name), Some(underlying)) = (
+            spec.child_by_field_name("name"),
+            spec.child_by_field_name("type"),
+        ) else {
+            return;
+        };
+        let underlying = self.get_node_text(underlying, content);
+        if !is_primitive_type(&underlying) {
+            return;
+        }
+        self.primitive_types.push(TypeDeclNode {
+            name: self.get_node_text(name, content),
+            type_def: match alias {
+                true => TypeDefinition::Alias(underlying),
+                false => TypeDefinition::Basic(underlying),
+            },
+            position: self.node_to_position(name, file_path),
+            docs: self.extract_documentation(spec, content),
+        });
+    }
+
+    /// Get the primitive a named primitive type or alias of this file stands for
+    fn primitive_type(&self, name: &str) -> Option<&str> {
+        self.primitive_types
+            .iter()
+            .find(|type_decl| type_decl.name == name)
+            .and_then(|type_decl| match &type_decl.type_def {
+                TypeDefinition::Basic(underlying) | TypeDefinition::Alias(underlying) => {
+                    Some(underlying.as_str())
+                }
+                _ => None,
+            })
+    }
+
+    /// Get the C type a type of this file uses, when the file imports "C"
+    ///
+    /// C types have no JSON encoding worth describing, so the types using them
+    /// in encoded fields are not generated; fields encoding/json ignores may
+    /// hold them.
+    fn cgo_type(&self, type_name: &str) -> Option<String> {
+        if self.imports.get("C").map(String::as_str) != Some("C") {
+            return None;
+        }
+        let include_unexported = self.options.unexported_fields == Some(UnexportedPolicy::Include);
+        match self.type_defs.get(type_name)? {
+            TypeDefinition::Struct(struct_type) => struct_type
+                .fields
+                .iter()
+                .filter(|field| !field.struct_tag().is_ignored("json"))
+                .filter(|field| {
+                    field.embedded
+                        || include_unexported
+                        || field.names.iter().any(|name| is_exported(name))
+                })
+                .find_map(|field| c_type(&field.field_type)),
+            definition => c_type(definition),
+        }
+    }
+
+    /// Get the definition of a named slice, array or map type of this file
+    ///
+    /// Types whose elements contain the type itself, such as
+    /// `type Tree map[string]Tree`, have none, as their schema would be
+    /// infinite.
+    fn collection_definition(&self, name: &str) -> Option<&TypeDefinition> {
+        let definition = self.type_defs.get(name)?;
+        let is_collection = matches!(
+            definition,
+            TypeDefinition::Slice(_) | TypeDefinition::Array(_) | TypeDefinition::Map(_, _)
+        );
+        (is_collection && !self.contains_collection(definition, name, &mut Vec::new()))
+            .then_some(definition)
+    }
+
+    /// Whether the elements of a type contain the named type, following the
+    /// named collections of this file
+    fn contains_collection<'a>(
+        &'a self,
+        type_def: &'a TypeDefinition,
+        name: &str,
+        seen: &mut Vec<&'a str>,
+    ) -> bool {
+        match type_def {
+            TypeDefinition::Pointer(inner)
+            | TypeDefinition::Slice(inner)
+            | TypeDefinition::Array(inner)
+            | TypeDefinition::Map(_, inner) => self.contains_collection(inner, name, seen),
+            TypeDefinition::Basic(other) if other == name => true,
+            TypeDefinition::Basic(other) if !seen.contains(&other.as_str()) => {
+                seen.push(other);
+                match self.type_defs.get(other) {
+                    Some(
+                        definition @ (TypeDefinition::Slice(_)
+                        | TypeDefinition::Array(_)
+                        | TypeDefinition::Map(_, _)),
+                    ) => self.contains_collection(definition, name, seen),
+                    _ => false,
+                }
+            }
+            _ => false,
+        }
+    }
+
     /// Parse type definition from AST node
     fn parse_type_definition(&self, type_node: &Node, content: &str) -> Result<TypeDefinition> {
         match type_node.kind() {
@@ -207,9 +446,13 @@ impl GoAstParser {
             "pointer_type" => self.parse_pointer_type(type_node, content),
             "map_type" => self.parse_map_type(type_node, content),
             "slice_type" => self.parse_slice_type(type_node, content),
-            "type_identifier" => Ok(TypeDefinition::Basic(
+            "type_identifier" | "qualified_type" | "generic_type" => Ok(TypeDefinition::Basic(
                 self.get_node_text(*type_node, content),
             )),
+            "parenthesized_type" => match type_node.named_child(0) {
+                Some(inner) => self.parse_type_definition(&inner, content),
+                None => Ok(TypeDefinition::Basic("unknown".to_string())),
+            },
             _ => Ok(TypeDefinition::Basic("unknown".to_string())),
         }
     }
@@ -226,14 +469,10 @@ impl GoAstParser {
                 for field_decl in child.children(&mut child.walk()) {
                     if field_decl.kind() == "field_declaration" {
                         let field = self.parse_field_declaration(&field_decl, content)?;
-                        if field.names.is_empty() {
-                            // This is an embedded field
-                            if let TypeDefinition::Basic(type_name) = &field.field_type {
-                                embedded.push(type_name.clone());
-                            }
-                        } else {
-                            fields.push(field);
+                        if field.embedded {
+                            embedded.push(field.names.join(""));
                         }
+                        fields.push(field);
                     }
                 }
             }
@@ -251,16 +490,22 @@ impl GoAstParser {
     /// Parse interface type
     fn parse_interface_type(&self, interface_node: &Node, content: &str) -> Result<TypeDefinition> {
         let mut methods = Vec::new();
-        let embedded = Vec::new();
+        let mut embedded = Vec::new();
         let mut cursor = interface_node.walk();
 
-        // Find method list
+        // Find method list, with the interfaces embedded in it
         for child in interface_node.children(&mut cursor) {
             if child.kind() == "method_spec_list" {
                 for method_spec in child.children(&mut child.walk()) {
-                    if method_spec.kind() == "method_spec" {
-                        let method = self.parse_method_spec(&method_spec, content)?;
-                        methods.push(method);
+                    match method_spec.kind() {
+                        "method_spec" => {
+                            let method = self.parse_method_spec(&method_spec, content)?;
+                            methods.push(method);
+                        }
+                        "type_identifier" | "qualified_type" => {
+                            embedded.push(self.get_node_text(method_spec, content));
+                        }
+                        _ => {}
                     }
                 }
             }
@@ -277,13 +522,9 @@ impl GoAstParser {
 
     /// Parse array type
     fn parse_array_type(&self, array_node: &Node, content: &str) -> Result<TypeDefinition> {
-        let mut cursor = array_node.walk();
-
-        for child in array_node.children(&mut cursor) {
-            if child.kind() == "element_type" {
-                let element_type = self.parse_type_definition(&child, content)?;
-                return Ok(TypeDefinition::Array(Box::new(element_type)));
-            }
+        if let Some(element) = array_node.child_by_field_name("element") {
+            let element_type = self.parse_type_definition(&element, content)?;
+            return Ok(TypeDefinition::Array(Box::new(element_type)));
         }
 
         Ok(TypeDefinition::Array(Box::new(TypeDefinition::Basic(
@@ -293,18 +534,10 @@ impl GoAstParser {
 
     /// Parse pointer type
     fn parse_pointer_type(&self, pointer_node: &Node, content: &str) -> Result<TypeDefinition> {
-        let mut cursor = pointer_node.walk();
-
-        for child in pointer_node.children(&mut cursor) {
-            if child.kind() == "base_type" {
-                let base_type = self.parse_type_definition(&child, content)?;
-                return Ok(TypeDefinition::Pointer(Box::new(base_type)));
-            } else if child.kind() == "type_identifier" {
-                let type_name = self.get_node_text(child, content);
-                return Ok(TypeDefinition::Pointer(Box::new(TypeDefinition::Basic(
-                    type_name,
-                ))));
-            }
+        // The pointee is the only named child of `*T`
+        if let Some(base) = pointer_node.named_child(0) {
+            let base_type = self.parse_type_definition(&base, content)?;
+            return Ok(TypeDefinition::Pointer(Box::new(base_type)));
         }
 
         Ok(TypeDefinition::Pointer(Box::new(TypeDefinition::Basic(
@@ -314,17 +547,14 @@ impl GoAstParser {
 
     /// Parse map type
     fn parse_map_type(&self, map_node: &Node, content: &str) -> Result<TypeDefinition> {
-        let mut key_type = None;
-        let mut value_type = None;
-        let mut cursor = map_node.walk();
-
-        for child in map_node.children(&mut cursor) {
-            match child.kind() {
-                "key_type" => key_type = Some(self.parse_type_definition(&child, content)?),
-                "value_type" => value_type = Some(self.parse_type_definition(&child, content)?),
-                _ => {}
-            }
-        }
+        let key_type = match map_node.child_by_field_name("key") {
+            Some(key) => Some(self.parse_type_definition(&key, content)?),
+            None => None,
+        };
+        let value_type = match map_node.child_by_field_name("value") {
+            Some(value) => Some(self.parse_type_definition(&value, content)?),
+            None => None,
+        };
 
         let key = key_type.unwrap_or(TypeDefinition::Basic("string".to_string()));
         let value = value_type.unwrap_or(TypeDefinition::Basic("unknown".to_string()));
@@ -334,13 +564,9 @@ impl GoAstParser {
 
     /// Parse slice type
     fn parse_slice_type(&self, slice_node: &Node, content: &str) -> Result<TypeDefinition> {
-        let mut cursor = slice_node.walk();
-
-        for child in slice_node.children(&mut cursor) {
-            if child.kind() == "element_type" {
-                let element_type = self.parse_type_definition(&child, content)?;
-                return Ok(TypeDefinition::Slice(Box::new(element_type)));
-            }
+        if let Some(element) = slice_node.child_by_field_name("element") {
+            let element_type = self.parse_type_definition(&element, content)?;
+            return Ok(TypeDefinition::Slice(Box::new(element_type)));
         }
 
         Ok(TypeDefinition::Slice(Box::new(TypeDefinition::Basic(
@@ -353,40 +579,74 @@ impl GoAstParser {
         let mut names = Vec::new();
         let mut field_type = TypeDefinition::Basic("unknown".to_string());
         let mut tags = None;
+        let mut pointer_embed = false;
         let mut cursor = field_decl.walk();
 
         for child in field_decl.children(&mut cursor) {
             match child.kind() {
-                "field_identifier_list" => {
-                    for name_node in child.children(&mut child.walk()) {
-                        if name_node.kind() == "field_identifier" {
-                            names.push(self.get_node_text(name_node, content));
-                        }
-                    }
+                "field_identifier" => {
+                    names.push(self.get_node_text(child, content));
                 }
-                "type" => {
-                    field_type = self.parse_type_definition(&child, content)?;
+                "*" => pointer_embed = true,
+                "raw_string_literal" => {
+                    tags = Some(
+                        self.get_node_text(child, content)
+                            .trim_matches('`')
+                            .to_string(),
+                    );
                 }
-                "raw_string_literal" | "interpreted_string_literal" => {
+                "interpreted_string_literal" => {
                     tags = Some(
                         self.get_node_text(child, content)
                             .trim_matches('"')
-                            .to_string(),
+                            .replace("\\\"", "\""),
                     );
                 }
                 _ => {}
             }
         }
 
+        if let Some(type_node) = field_decl.child_by_field_name("type") {
+            field_type = self.parse_type_definition(&type_node, content)?;
+        }
+
+        // Embedded fields are named after the (unqualified) embedded type
+        let embedded = names.is_empty();
+        if embedded {
+            if let TypeDefinition::Basic(type_name) = &field_type {
+                let base_name = type_name.rsplit('.').next().unwrap_or(type_name);
+                let base_name = base_name.split('[').next().unwrap_or(base_name);
+                names.push(base_name.to_string());
+            }
+            if pointer_embed {
+                field_type = TypeDefinition::Pointer(Box::new(field_type));
+            }
+        }
+
         Ok(FieldNode {
             names,
             field_type,
             tags,
-            docs: self.extract_documentation(field_decl, content),
+            embedded,
+            docs: self.extract_field_documentation(field_decl, content),
             position: self.node_to_position(*field_decl, &PathBuf::new()),
         })
     }
 
+    /// Extract the doc comments of a field, with a magic comment at the end
+    /// of its line
+    fn extract_field_documentation(&self, field_decl: &Node, content: &str) -> Vec<String> {
+        let mut docs = self.extract_documentation(field_decl, content);
+        let rest = &content[field_decl.end_byte()..];
+        let line = rest.split('\n').next().unwrap_or_default();
+        if let Some(comment) = line.trim().strip_prefix("//") {
+            if comment.trim().starts_with(DIRECTIVE_PREFIX) {
+                docs.push(comment.trim().to_string());
+            }
+        }
+        docs
+    }
+
     /// Parse method specification
     fn parse_method_spec(&self, method_spec: &Node, content: &str) -> Result<MethodNode> {
         let mut name = String::new();
@@ -395,19 +655,17 @@ impl GoAstParser {
         let mut cursor = method_spec.walk();
 
         for child in method_spec.children(&mut cursor) {
-            match child.kind() {
-                "field_identifier" => {
-                    name = self.get_node_text(child, content);
-                }
-                "parameter_list" => {
-                    params = self.parse_parameter_list(&child, content)?;
-                }
-                "result" => {
-                    results = self.parse_result_list(&child, content)?;
-                }
-                _ => {}
+            if child.kind() == "field_identifier" {
+                name = self.get_node_text(child, content);
             }
         }
+        // The result may be a parameter list as well, or a single type
+        if let Some(parameters) = method_spec.child_by_field_name("parameters") {
+            params = self.parse_parameter_list(&parameters, content)?;
+        }
+        if let Some(result) = method_spec.child_by_field_name("result") {
+            results = self.parse_result(&result, content)?;
+        }
 
         Ok(MethodNode {
             name,
@@ -441,21 +699,20 @@ impl GoAstParser {
         let mut cursor = param_decl.walk();
 
         for child in param_decl.children(&mut cursor) {
-            match child.kind() {
-                "identifier" => {
-                    names.push(self.get_node_text(child, content));
-                }
-                "type" => {
-                    param_type = self.parse_type_definition(&child, content)?;
-                }
-                _ => {}
+            if child.kind() == "identifier" {
+                names.push(self.get_node_text(child, content));
             }
         }
 
+        if let Some(type_node) = param_decl.child_by_field_name("type") {
+            param_type = self.parse_type_definition(&type_node, content)?;
+        }
+
         Ok(FieldNode {
             names,
             field_type: param_type,
             tags: None,
+            embedded: false,
             docs: Vec::new(),
             position: self.node_to_position(*param_decl, &PathBuf::new()),
         })
@@ -476,6 +733,22 @@ impl GoAstParser {
         Ok(results)
     }
 
+    /// Parse a function result, which is either a single type or a list
+    fn parse_result(&self, result: &Node, content: &str) -> Result<Vec<FieldNode>> {
+        if result.kind() == "parameter_list" {
+            return self.parse_result_list(result, content);
+        }
+
+        Ok(vec![FieldNode {
+            names: Vec::new(),
+            field_type: self.parse_type_definition(result, content)?,
+            tags: None,
+            embedded: false,
+            docs: Vec::new(),
+            position: self.node_to_position(*result, &PathBuf::new()),
+        }])
+    }
+
     /// Extract function declarations from AST
     fn extract_function_declarations(
         &mut self,
@@ -488,6 +761,7 @@ impl GoAstParser {
         for node in root_node.children(&mut cursor) {
             if node.kind() == "function_declaration" {
                 self.process_function_declaration(&node, file_path, content)?;
+                self.process_constructor(&node, content);
             } else if node.kind() == "method_declaration" {
                 self.process_method_declaration(&node, file_path, content)?;
             }
@@ -561,458 +835,4274 @@ impl GoAstParser {
         Ok(())
     }
 
-    /// Process a method declaration node
-    fn process_method_declaration(
-        &mut self,
-        method_decl_node: &Node,
-        file_path: &Path,
-        content: &str,
-    ) -> Result<()> {
-        let mut name = String::new();
-        let mut receiver = None;
-        let mut params = Vec::new();
-        let mut results = Vec::new();
-        let mut cursor = method_decl_node.walk();
+    /// Record field defaults from a `NewXxx` constructor
+    ///
+    /// Only literal and constant initializers in the composite literal
+    /// building `Xxx` are used; anything computed at runtime is ignored.
+    /// With `constructor_analysis`, the constructor may build the type it
+    /// returns under another name, as `NewRepository() *UserRepository`, and
+    /// the fields it assigns to the value afterwards count as well.
+    fn process_constructor(&mut self, func_decl_node: &Node, content: &str) {
+        let name = match func_decl_node.child_by_field_name("name") {
+            Some(name) => self.get_node_text(name, content),
+            None => return,
+        };
+        let type_name = match name.strip_prefix("New") {
+            Some(type_name) if !type_name.is_empty() => type_name.to_string(),
+            _ => return,
+        };
+        let body = match func_decl_node.child_by_field_name("body") {
+            Some(body) => body,
+            None => return,
+        };
 
-        for child in method_decl_node.children(&mut cursor) {
-            match child.kind() {
-                "field_identifier" => {
-                    name = self.get_node_text(child, content);
+        let mut type_names = vec![type_name];
+        if self.options.constructor_analysis {
+            let result = func_decl_node
+                .child_by_field_name("result")
+                .map(|result| self.get_node_text(result, content));
+            if let Some(result) = result {
+                let result = result.trim_start_matches('*');
+                if is_identifier(result) && result != type_names[0] {
+                    type_names.push(result.to_string());
                 }
-                "parameter_list" => {
-                    // Parse regular parameters (not receiver)
-                    params = self.parse_parameter_list(&child, content)?;
-                }
-                "result" => {
-                    results = self.parse_result_list(&child, content)?;
-                }
-                _ => {}
             }
         }
+        let Some((type_name, literal)) = type_names.into_iter().find_map(|type_name| {
+            self.find_composite_literal(&body, &type_name, content)
+                .map(|literal| (type_name, literal))
+        }) else {
+            return;
+        };
+        let literal_value = match literal.child_by_field_name("body") {
+            Some(value) => value,
+            None => return,
+        };
 
-        // For method declarations, we need to parse the receiver from the parent context
-        // The receiver is typically the first parameter in the method declaration
-        // Let me check if there's a receiver parameter list
-        let mut receiver_cursor = method_decl_node.walk();
-        for child in method_decl_node.children(&mut receiver_cursor) {
-            if child.kind() == "parameter_list" {
-                // Check if this parameter list contains a receiver
-                let mut param_cursor = child.walk();
-                for param in child.children(&mut param_cursor) {
-                    if param.kind() == "parameter_declaration" {
-                        let receiver_info = self.parse_receiver_parameter(&param, content)?;
-                        if receiver_info.is_some() {
-                            receiver = receiver_info;
-                            break;
-                        }
-                    }
+        // Arguments and variables are not constants of other files
+        let mut locals = HashSet::new();
+        collect_local_names(*func_decl_node, content, &mut locals);
+
+        let mut defaults = HashMap::new();
+        for element in literal_value.named_children(&mut literal_value.walk()) {
+            if element.kind() != "keyed_element" {
+                continue;
+            }
+
+            let parts: Vec<Node> = element
+                .named_children(&mut element.walk())
+                .map(|part| unwrap_literal_element(part))
+                .collect();
+            if let [key, value] = parts.as_slice() {
+                if let Some(default) = self.constructor_default(value, content, &locals) {
+                    defaults.insert(self.get_node_text(*key, content), default);
                 }
-                break;
             }
         }
 
-        // Create method node
-        let method_node = MethodNode {
-            name,
-            receiver,
-            params,
-            results,
-            docs: self.extract_documentation(method_decl_node, content),
-            position: self.node_to_position(*method_decl_node, file_path),
-        };
+        if self.options.constructor_analysis {
+            self.constructor_assignments(&body, &literal, content, &locals, &mut defaults);
+        }
 
-        self.nodes.push(GoAstNode::Method(method_node));
+        if !defaults.is_empty() {
+            self.constructor_defaults.insert(type_name, defaults);
+        }
+    }
 
-        Ok(())
+    /// Get the default of a value assigned by a constructor
+    ///
+    /// Identifiers that are neither constants of the file nor variables of
+    /// the constructor may be constants of another file of the package.
+    fn constructor_default(
+        &self,
+        value: &Node,
+        content: &str,
+        locals: &HashSet<String>,
+    ) -> Option<FieldDefault> {
+        if let Some(value) = self.eval_constant(value, content, None, &self.const_values) {
+            return Some(FieldDefault::Value(value));
+        }
+        let name = self.get_node_text(*value, content);
+        (value.kind() == "identifier" && !locals.contains(&name))
+            .then_some(FieldDefault::Constant(name))
     }
 
-    /// Parse receiver parameter from a parameter declaration
-    fn parse_receiver_parameter(
+    /// Record the fields a constructor assigns to the value it builds
+    ///
+    /// Only a body binding the literal to a variable (`r := &T{...}`) and
+    /// assigning its fields at the top level (`r.Timeout = 30`) is followed;
+    /// assignments in branches and loops depend on the arguments.
+    fn constructor_assignments(
         &self,
-        param_decl: &Node,
+        body: &Node,
+        literal: &Node,
         content: &str,
-    ) -> Result<Option<TypeDefinition>> {
-        let mut names = Vec::new();
-        let mut param_type = TypeDefinition::Basic("unknown".to_string());
-        let mut cursor = param_decl.walk();
+        locals: &HashSet<String>,
+        defaults: &mut HashMap<String, FieldDefault>,
+    ) {
+        let statements: Vec<Node> = match body.named_child(0) {
+            Some(list) if list.kind() == "statement_list" => {
+                list.named_children(&mut list.walk()).collect()
+            }
+            _ => body.named_children(&mut body.walk()).collect(),
+        };
 
-        for child in param_decl.children(&mut cursor) {
-            match child.kind() {
-                "identifier" => {
-                    let name = self.get_node_text(child, content);
-                    names.push(name);
-                }
-                "type" => {
-                    param_type = self.parse_type_definition(&child, content)?;
-                }
-                "type_identifier" => {
-                    let type_name = self.get_node_text(child, content);
-                    param_type = TypeDefinition::Basic(type_name.clone());
+        let mut variable = None;
+        for statement in statements {
+            let (Some(left), Some(right)) = (
+                statement
+                    .child_by_field_name("left")
+                    .and_then(|left| left.named_child(0)),
+                statement
+                    .child_by_field_name("right")
+                    .and_then(|right| right.named_child(0)),
+            ) else {
+                continue;
+            };
+            match statement.kind() {
+                "short_var_declaration" => {
+                    let range = right.byte_range();
+                    if range.start <= literal.start_byte() && literal.end_byte() <= range.end {
+                        variable = Some(self.get_node_text(left, content));
+                    }
                 }
-                "pointer_type" => {
-                    param_type = self.parse_type_definition(&child, content)?;
+                "assignment_statement" if left.kind() == "selector_expression" => {
+                    let operator = statement
+                        .child_by_field_name("operator")
+                        .map(|operator| self.get_node_text(operator, content));
+                    let (Some(operand), Some(field)) = (
+                        left.child_by_field_name("operand"),
+                        left.child_by_field_name("field"),
+                    ) else {
+                        continue;
+                    };
+                    if operator.as_deref() != Some("=")
+                        || variable.as_deref()
+                            != Some(self.get_node_text(operand, content).as_str())
+                    {
+                        continue;
+                    }
+                    let field = self.get_node_text(field, content);
+                    match self.constructor_default(&right, content, locals) {
+                        Some(default) => {
+                            defaults.insert(field, default);
+                        }
+                        // A runtime value replaces the one of the literal
+                        None => {
+                            defaults.remove(&field);
+                        }
+                    }
                 }
                 _ => {}
             }
         }
+    }
 
-        // In Go, receivers are written as (t Type) or (Type)
-        // The receiver is always a single parameter with a type
-        // We identify it by checking if it has a valid type (not "unknown")
-        match &param_type {
-            TypeDefinition::Basic(type_name) => {
-                if !type_name.is_empty() && type_name != "unknown" {
-                    return Ok(Some(param_type));
+    /// Record the `GroupVersion` and the scheme registration of the file
+    ///
+    /// Kubebuilder declares `GroupVersion = schema.GroupVersion{...}` in
+    /// `groupversion_info.go` and registers types with
+    /// `SchemeBuilder.Register(&Widget{}, ...)` in `init`; the Kubernetes
+    /// style uses `SchemeGroupVersion` and `scheme.AddKnownTypes`. Group and
+    /// version may be string literals or string constants of the file.
+    fn extract_scheme_registration(&mut self, root_node: &Node, content: &str) {
+        let mut constants = HashMap::new();
+        let mut variables = Vec::new();
+        for node in root_node.named_children(&mut root_node.walk()) {
+            match node.kind() {
+                "const_declaration" | "var_declaration" => {
+                    for spec in declaration_specs(node) {
+                        let names: Vec<String> = spec
+                            .children_by_field_name("name", &mut spec.walk())
+                            .map(|name| self.get_node_text(name, content))
+                            .collect();
+                        let Some(values) = spec.child_by_field_name("value") else {
+                            continue;
+                        };
+                        let values: Vec<Node> = values.named_children(&mut values.walk()).collect();
+                        for (name, value) in names.into_iter().zip(values) {
+                            if node.kind() == "var_declaration" {
+                                variables.push((name, value));
+                            } else if let Some(serde_yaml::Value::String(value)) =
+                                self.literal_to_value(&value, content)
+                            {
+                                constants.insert(name, value);
+                            }
+                        }
+                    }
                 }
-            }
-            TypeDefinition::Pointer(inner_type) => {
-                if let TypeDefinition::Basic(type_name) = inner_type.as_ref() {
-                    if !type_name.is_empty() && type_name != "unknown" {
-                        return Ok(Some(param_type));
+                "function_declaration" => {
+                    let is_init = node
+                        .child_by_field_name("name")
+                        .is_some_and(|name| self.get_node_text(name, content) == "init");
+                    if let Some(body) = node.child_by_field_name("body").filter(|_| is_init) {
+                        self.collect_registered_kinds(&body, content);
                     }
                 }
+                _ => {}
             }
-            _ => {}
         }
 
-        Ok(None)
-    }
-
-    /// Extract imports from AST
-    fn extract_imports(&mut self, root_node: &Node, file_path: &Path, content: &str) -> Result<()> {
-        let mut cursor = root_node.walk();
-
-        for node in root_node.children(&mut cursor) {
-            if node.kind() == "import_declaration" {
-                self.process_import_declaration(&node, file_path, content)?;
+        for (name, value) in variables {
+            if name != "GroupVersion" && name != "SchemeGroupVersion" {
+                continue;
             }
-        }
-
-        Ok(())
-    }
+            let Some(body) = (value.kind() == "composite_literal")
+                .then(|| value.child_by_field_name("body"))
+                .flatten()
+            else {
+                continue;
+            };
 
-    /// Process import declaration
-    fn process_import_declaration(
-        &mut self,
-        import_decl: &Node,
-        file_path: &Path,
-        content: &str,
-    ) -> Result<()> {
-        for child in import_decl.children(&mut import_decl.walk()) {
-            if child.kind() == "import_spec_list" {
-                for import_spec in child.children(&mut child.walk()) {
-                    if import_spec.kind() == "import_spec" {
-                        self.process_import_spec(&import_spec, file_path, content)?;
+            let mut fields = HashMap::new();
+            for element in body.named_children(&mut body.walk()) {
+                if element.kind() != "keyed_element" {
+                    continue;
+                }
+                let parts: Vec<Node> = element
+                    .named_children(&mut element.walk())
+                    .map(|part| unwrap_literal_element(part))
+                    .collect();
+                if let [key, value] = parts.as_slice() {
+                    let text = match self.literal_to_value(value, content) {
+                        Some(serde_yaml::Value::String(text)) => Some(text),
+                        _ => constants.get(&self.get_node_text(*value, content)).cloned(),
+                    };
+                    if let Some(text) = text {
+                        fields.insert(self.get_node_text(*key, content), text);
                     }
                 }
             }
+            if let (Some(group), Some(version)) = (fields.remove("Group"), fields.remove("Version"))
+            {
+                self.group_version = Some((group, version));
+            }
         }
-
-        Ok(())
     }
 
-    /// Process import specification
-    fn process_import_spec(
+    /// Record the exported package-level constants with a literal value
+    ///
+    /// A value may also name a constant declared before it in the file.
+    /// Constants computed from expressions, including `iota`, are left out.
+    fn extract_constants(&mut self, root_node: &Node, content: &str) {
+        let package = self
+            .package_info
+            .as_ref()
+            .map(|p| p.name.clone())
+            .unwrap_or_default();
+        let mut values: HashMap<String, serde_yaml::Value> = HashMap::new();
+        for node in root_node.named_children(&mut root_node.walk()) {
+            if node.kind() != "const_declaration" {
+                continue;
+            }
+            for spec in declaration_specs(node) {
+                let names: Vec<String> = spec
+                    .children_by_field_name("name", &mut spec.walk())
+                    .map(|name| self.get_node_text(name, content))
+                    .collect();
+                let Some(value_list) = spec.child_by_field_name("value") else {
+                    continue;
+                };
+                let docs = self.extract_documentation(&spec, content);
+                let value_nodes: Vec<Node> =
+                    value_list.named_children(&mut value_list.walk()).collect();
+                for (name, value_node) in names.into_iter().zip(value_nodes) {
+                    let value = match self.literal_to_value(&value_node, content) {
+                        Some(value) => value,
+                        None if value_node.kind() == "identifier" => {
+                            match values.get(&self.get_node_text(value_node, content)) {
+                                Some(value) => value.clone(),
+                                None => continue,
+                            }
+                        }
+                        None => continue,
+                    };
+                    values.insert(name.clone(), value.clone());
+                    if is_exported(&name) {
+                        self.constants.push(Constant {
+                            name,
+                            package: package.clone(),
+                            value,
+                            description: doc_description(&docs),
+                        });
+                    }
+                }
+            }
+        }
+    }
+
+    /// Record the exported sentinel errors, error types and error codes
+    ///
+    /// Sentinel errors are variables set to `errors.New` or `fmt.Errorf` of
+    /// a string literal, and codes constants of a type named `...Code`, which
+    /// the specs of a block without a type repeat.
+    fn extract_errors(&mut self, root_node: &Node, content: &str) {
+        let package = self
+            .package_info
+            .as_ref()
+            .map(|p| p.name.clone())
+            .unwrap_or_default();
+        let record = |name: &str, kind, docs: &[String]| GoError {
+            name: name.to_string(),
+            package: package.clone(),
+            kind,
+            message: None,
+            code: None,
+            description: doc_description(docs),
+        };
+
+        let mut errors = Vec::new();
+        for node in root_node.named_children(&mut root_node.walk()) {
+            let mut code_type = None;
+            for spec in declaration_specs(node) {
+                let docs = self.extract_documentation(&spec, content);
+                let names: Vec<String> = spec
+                    .children_by_field_name("name", &mut spec.walk())
+                    .map(|name| self.get_node_text(name, content))
+                    .collect();
+                let values: Vec<Node> = spec
+                    .child_by_field_name("value")
+                    .map(|values| values.named_children(&mut values.walk()).collect())
+                    .unwrap_or_default();
+
+                if node.kind() == "const_declaration" {
+                    if let Some(spec_type) = spec.child_by_field_name("type") {
+                        code_type = Some(self.get_node_text(spec_type, content));
+                    } else if !values.is_empty() {
+                        code_type = None;
+                    }
+                    if !code_type.as_ref().is_some_and(|t| t.ends_with("Code")) {
+                        continue;
+                    }
+                    for name in names.iter().filter(|name| is_exported(name)) {
+                        if let Some(value) = self.const_values.get(name) {
+                            errors.push(GoError {
+                                code: Some(value.clone()),
+                                ..record(name, ErrorKind::Code, &docs)
+                            });
+                        }
+                    }
+                    continue;
+                }
+
+                for (name, value) in names.iter().zip(values) {
+                    if !is_exported(name) || value.kind() != "call_expression" {
+                        continue;
+                    }
+                    let function = value
+                        .child_by_field_name("function")
+                        .map(|function| self.get_node_text(function, content))
+                        .unwrap_or_default();
+                    if function != "errors.New" && function != "fmt.Errorf" {
+                        continue;
+                    }
+                    let message = value
+                        .child_by_field_name("arguments")
+                        .and_then(|arguments| arguments.named_child(0))
+                        .and_then(|argument| self.literal_to_value(&argument, content));
+                    if let Some(serde_yaml::Value::String(message)) = message {
+                        errors.push(GoError {
+                            message: Some(message),
+                            ..record(name, ErrorKind::Sentinel, &docs)
+                        });
+                    }
+                }
+            }
+        }
+
+        // Types with an `Error() string` method, documented by their declaration
+        for node in &self.nodes {
+            let GoAstNode::Method(method) = node else {
+                continue;
+            };
+            let Some(receiver) = method.receiver.as_ref().and_then(|r| self.named_type(r)) else {
+                continue;
+            };
+            let is_error = method.name == "Error"
+                && method.params.is_empty()
+                && matches!(method.results.as_slice(), [result] if matches!(&result.field_type, TypeDefinition::Basic(name) if name == "string"));
+            if !is_error || !is_exported(receiver) {
+                continue;
+            }
+            let docs = self
+                .nodes
+                .iter()
+                .find_map(|node| match node {
+                    GoAstNode::TypeDecl(type_decl) if type_decl.name == receiver => {
+                        Some(type_decl.docs.as_slice())
+                    }
+                    _ => None,
+                })
+                .unwrap_or_default();
+            errors.push(record(receiver, ErrorKind::Type, docs));
+        }
+        self.go_errors = errors;
+    }
+
+    /// Compute the values of the constants of this file
+    ///
+    /// Constants are literals or expressions of literals, `iota` and other
+    /// constants of the file, declared in any order; specs without a value
+    /// repeat the expressions of the previous one, as in Go. Constants of
+    /// other packages and function calls are not evaluated.
+    fn extract_const_values(&mut self, root_node: &Node, content: &str) {
+        let mut specs = Vec::new();
+        for node in root_node.named_children(&mut root_node.walk()) {
+            if node.kind() != "const_declaration" {
+                continue;
+            }
+            let mut expressions: Vec<Node> = Vec::new();
+            for (iota, spec) in declaration_specs(node).into_iter().enumerate() {
+                if let Some(values) = spec.child_by_field_name("value") {
+                    expressions = values.named_children(&mut values.walk()).collect();
+                }
+                let names: Vec<String> = spec
+                    .children_by_field_name("name", &mut spec.walk())
+                    .map(|name| self.get_node_text(name, content))
+                    .collect();
+                for (name, expression) in names.into_iter().zip(&expressions) {
+                    if name != "_" {
+                        specs.push((name, *expression, iota as i64));
+                    }
+                }
+            }
+        }
+
+        // Constants may refer to later ones, so evaluate until nothing changes
+        let mut values = HashMap::new();
+        loop {
+            let known = values.len();
+            for (name, expression, iota) in &specs {
+                if values.contains_key(name) {
+                    continue;
+                }
+                if let Some(value) = self.eval_constant(expression, content, Some(*iota), &values) {
+                    values.insert(name.clone(), value);
+                }
+            }
+            if values.len() == known {
+                break;
+            }
+        }
+        self.const_values = values;
+    }
+
+    /// Evaluate a constant expression
+    ///
+    /// `iota` is the index of the spec within a `const` block, and `known`
+    /// holds the values of the constants the expression may name.
+    fn eval_constant(
+        &self,
+        node: &Node,
+        content: &str,
+        iota: Option<i64>,
+        known: &HashMap<String, serde_yaml::Value>,
+    ) -> Option<serde_yaml::Value> {
+        if let Some(value) = self.literal_to_value(node, content) {
+            return Some(value);
+        }
+        let eval = |node: Node| self.eval_constant(&node, content, iota, known);
+        match node.kind() {
+            "iota" => iota.map(serde_yaml::Value::from),
+            "identifier" => match self.get_node_text(*node, content).as_str() {
+                "iota" => iota.map(serde_yaml::Value::from),
+                name => known.get(name).cloned(),
+            },
+            "parenthesized_expression" => eval(node.named_child(0)?),
+            "unary_expression" => {
+                let operand = eval(node.child_by_field_name("operand")?)?;
+                let operator = self.get_node_text(node.child_by_field_name("operator")?, content);
+                match (operator.as_str(), &operand) {
+                    ("+", serde_yaml::Value::Number(_)) => Some(operand),
+                    ("-", serde_yaml::Value::Number(n)) => match n.as_i64() {
+                        Some(i) => i.checked_neg().map(serde_yaml::Value::from),
+                        None => n.as_f64().map(|f| serde_yaml::Value::from(-f)),
+                    },
+                    ("^", serde_yaml::Value::Number(n)) => n.as_i64().map(|i| (!i).into()),
+                    ("!", serde_yaml::Value::Bool(b)) => Some((!b).into()),
+                    _ => None,
+                }
+            }
+            "binary_expression" => {
+                let left = eval(node.child_by_field_name("left")?)?;
+                let right = eval(node.child_by_field_name("right")?)?;
+                let operator = self.get_node_text(node.child_by_field_name("operator")?, content);
+                binary_constant(&operator, &left, &right)
+            }
+            // Conversions such as `int64(5)` or `Level(2)` keep the value
+            "call_expression" => {
+                let function = self.get_node_text(node.child_by_field_name("function")?, content);
+                let is_type = is_primitive_type(&function)
+                    || self.primitive_types.iter().any(|t| t.name == function);
+                let arguments = node.child_by_field_name("arguments")?;
+                if !is_type || arguments.named_child_count() != 1 {
+                    return None;
+                }
+                let value = eval(arguments.named_child(0)?)?;
+                match (function.as_str(), &value) {
+                    ("float32" | "float64", serde_yaml::Value::Number(n)) => {
+                        n.as_f64().map(serde_yaml::Value::from)
+                    }
+                    _ => Some(value),
+                }
+            }
+            _ => None,
+        }
+    }
+
+    /// Get the default of a field from its `default` tag
+    ///
+    /// The tag may name a constant: one of this file is replaced by its
+    /// value, others are recorded under `x-go-default-const` for
+    /// `resolve_constant_defaults` to look up in the package. String fields
+    /// keep the text of the tag when the package has no such constant.
+    fn tag_default(
+        &self,
+        value: &str,
+        schema: &serde_yaml::Value,
+    ) -> (Option<serde_yaml::Value>, Option<String>) {
+        if let Some(constant) = self.const_values.get(value) {
+            return (Some(constant.clone()), None);
+        }
+        let default = tag_default(value, schema);
+        let is_string = schema.get("type").and_then(|t| t.as_str()) == Some("string");
+        let constant =
+            (is_identifier(value) && (default.is_none() || is_string)).then(|| value.to_string());
+        (default, constant)
+    }
+
+    /// Record the values of the `const` blocks counting with `iota`
+    ///
+    /// Specs without a value repeat the expression and type of the previous
+    /// one with the next `iota`, as in Go. Only typed blocks are enums, and a
+    /// block shifting by `iota` is a set of flags.
+    fn extract_enums(&mut self, root_node: &Node, content: &str) {
+        for node in root_node.named_children(&mut root_node.walk()) {
+            if node.kind() != "const_declaration" {
+                continue;
+            }
+            let mut groups: Vec<(String, bool, Vec<EnumValue>)> = Vec::new();
+            let mut expression: Option<(Node, Option<String>)> = None;
+            for spec in declaration_specs(node) {
+                if let Some(values) = spec.child_by_field_name("value") {
+                    expression = values.named_child(0).map(|value| {
+                        let type_name = spec
+                            .child_by_field_name("type")
+                            .map(|t| self.get_node_text(t, content));
+                        (value, type_name)
+                    });
+                }
+                let Some((value, Some(type_name))) = expression.as_ref() else {
+                    continue;
+                };
+                if !self.get_node_text(*value, content).contains("iota") {
+                    continue;
+                }
+                let Some(name) = spec
+                    .child_by_field_name("name")
+                    .map(|name| self.get_node_text(name, content))
+                    .filter(|name| is_exported(name))
+                else {
+                    continue;
+                };
+                let Some(number) = self.const_values.get(&name).and_then(|v| v.as_i64()) else {
+                    continue;
+                };
+
+                let flags = self.get_node_text(*value, content).contains("<<");
+                let value = EnumValue {
+                    name,
+                    value: number,
+                    string: None,
+                };
+                match groups
+                    .iter_mut()
+                    .find(|(existing, _, _)| existing == type_name)
+                {
+                    Some((_, group_flags, values)) => {
+                        *group_flags |= flags;
+                        values.push(value);
+                    }
+                    None => groups.push((type_name.clone(), flags, vec![value])),
+                }
+            }
+            self.enums.extend(groups);
+        }
+    }
+
+    /// Read the names of the tables generated by stringer
+    ///
+    /// Stringer concatenates the names of the values of `T` in `_T_name` and
+    /// records where each one starts in `_T_index`, in ascending order of the
+    /// values. Sparse enums, whose names are split into runs or a map, have
+    /// no such pair and keep the names of their constants.
+    fn extract_stringer_names(&mut self, root_node: &Node, content: &str) {
+        let mut names: HashMap<String, String> = HashMap::new();
+        let mut indexes: HashMap<String, Vec<usize>> = HashMap::new();
+        for node in root_node.named_children(&mut root_node.walk()) {
+            if node.kind() != "const_declaration" && node.kind() != "var_declaration" {
+                continue;
+            }
+            for spec in declaration_specs(node) {
+                let (Some(name), Some(value)) = (
+                    spec.child_by_field_name("name"),
+                    spec.child_by_field_name("value")
+                        .and_then(|values| values.named_child(0)),
+                ) else {
+                    continue;
+                };
+                let name = self.get_node_text(name, content);
+                let Some(type_name) = name.strip_prefix('_') else {
+                    continue;
+                };
+                if let Some(type_name) = type_name.strip_suffix("_name") {
+                    if let Some(serde_yaml::Value::String(text)) =
+                        self.literal_to_value(&value, content)
+                    {
+                        names.insert(type_name.to_string(), text);
+                    }
+                } else if let Some(type_name) = type_name.strip_suffix("_index") {
+                    // The length of `[N]uint8{...}` is not an offset
+                    let body = value.child_by_field_name("body").unwrap_or(value);
+                    let mut offsets = Vec::new();
+                    collect_int_literals(body, content, &mut offsets);
+                    indexes.insert(type_name.to_string(), offsets);
+                }
+            }
+        }
+
+        for (type_name, text) in names {
+            let Some(offsets) = indexes.get(&type_name) else {
+                continue;
+            };
+            let split: Option<Vec<String>> = offsets
+                .windows(2)
+                .map(|w| text.get(w[0]..w[1]).map(str::to_string))
+                .collect();
+            if let Some(split) = split.filter(|split| !split.is_empty()) {
+                self.stringer_names.insert(type_name, split);
+            }
+        }
+    }
+
+    /// Collect the types registered by the `Register` and `AddKnownTypes`
+    /// calls of a function body
+    fn collect_registered_kinds(&mut self, node: &Node, content: &str) {
+        if node.kind() == "call_expression" {
+            let function = node
+                .child_by_field_name("function")
+                .map(|function| self.get_node_text(function, content))
+                .unwrap_or_default();
+            let arguments = node.child_by_field_name("arguments");
+            if let (true, Some(arguments)) = (
+                function.ends_with(".Register") || function.ends_with(".AddKnownTypes"),
+                arguments,
+            ) {
+                for argument in arguments.named_children(&mut arguments.walk()) {
+                    let literal = match argument.kind() {
+                        "unary_expression" => argument.child_by_field_name("operand"),
+                        _ => Some(argument),
+                    };
+                    let type_name = literal
+                        .filter(|literal| literal.kind() == "composite_literal")
+                        .and_then(|literal| literal.child_by_field_name("type"))
+                        .map(|type_node| self.get_node_text(type_node, content));
+                    if let Some(type_name) = type_name {
+                        self.registered_kinds.push(type_name);
+                    }
+                }
+            }
+        }
+
+        let children: Vec<Node> = node.named_children(&mut node.walk()).collect();
+        for child in children {
+            self.collect_registered_kinds(&child, content);
+        }
+    }
+
+    /// Find the composite literal constructing the named type
+    fn find_composite_literal<'a>(
+        &self,
+        node: &Node<'a>,
+        type_name: &str,
+        content: &str,
+    ) -> Option<Node<'a>> {
+        if node.kind() == "composite_literal" {
+            let literal_type = node.child_by_field_name("type")?;
+            if self.get_node_text(literal_type, content) == type_name {
+                return Some(*node);
+            }
+        }
+
+        let mut cursor = node.walk();
+        let children: Vec<Node<'a>> = node.named_children(&mut cursor).collect();
+        children
+            .iter()
+            .find_map(|child| self.find_composite_literal(child, type_name, content))
+    }
+
+    /// Convert a literal expression to a value, if it is a compile-time literal
+    fn literal_to_value(&self, node: &Node, content: &str) -> Option<serde_yaml::Value> {
+        let text = self.get_node_text(*node, content);
+
+        match node.kind() {
+            "interpreted_string_literal" => {
+                Some(serde_yaml::Value::String(unquote_go_string(&text)))
+            }
+            "raw_string_literal" => Some(serde_yaml::Value::String(
+                text.trim_matches('`').to_string(),
+            )),
+            "int_literal" => parse_go_int(&text).map(|n| serde_yaml::Value::Number(n.into())),
+            "float_literal" => text
+                .replace('_', "")
+                .parse::<f64>()
+                .ok()
+                .map(|n| serde_yaml::Value::Number(n.into())),
+            "true" => Some(serde_yaml::Value::Bool(true)),
+            "false" => Some(serde_yaml::Value::Bool(false)),
+            "unary_expression" if text.starts_with('-') => {
+                let operand = node.child_by_field_name("operand")?;
+                match self.literal_to_value(&operand, content)? {
+                    serde_yaml::Value::Number(n) => {
+                        if let Some(i) = n.as_i64() {
+                            Some(serde_yaml::Value::Number((-i).into()))
+                        } else {
+                            n.as_f64().map(|f| serde_yaml::Value::Number((-f).into()))
+                        }
+                    }
+                    _ => None,
+                }
+            }
+            "call_expression" => {
+                // make(map[K]V) and make([]T, n) produce empty collections
+                let function = node.child_by_field_name("function")?;
+                if self.get_node_text(function, content) != "make" {
+                    return None;
+                }
+                let arguments = node.child_by_field_name("arguments")?;
+                let made = arguments.named_child(0)?;
+                self.empty_collection(&made)
+            }
+            "composite_literal" => {
+                let literal_type = node.child_by_field_name("type")?;
+                let body = node.child_by_field_name("body")?;
+                let elements: Vec<Node> = body
+                    .named_children(&mut body.walk())
+                    .filter(|e| e.kind() != "comment")
+                    .collect();
+
+                match literal_type.kind() {
+                    "slice_type" | "array_type" | "implicit_length_array_type" => {
+                        let mut items = Vec::new();
+                        for element in elements {
+                            let element = unwrap_literal_element(element);
+                            items.push(self.literal_to_value(&element, content)?);
+                        }
+                        Some(serde_yaml::Value::Sequence(items))
+                    }
+                    "map_type" => {
+                        let mut map = serde_yaml::Mapping::new();
+                        for element in elements {
+                            let parts: Vec<Node> = element
+                                .named_children(&mut element.walk())
+                                .map(|part| unwrap_literal_element(part))
+                                .collect();
+                            match parts.as_slice() {
+                                [key, value] => {
+                                    map.insert(
+                                        self.literal_to_value(key, content)?,
+                                        self.literal_to_value(value, content)?,
+                                    );
+                                }
+                                _ => return None,
+                            }
+                        }
+                        Some(serde_yaml::Value::Mapping(map))
+                    }
+                    _ => None,
+                }
+            }
+            "parenthesized_expression" => {
+                let inner = node.named_child(0)?;
+                self.literal_to_value(&inner, content)
+            }
+            _ => None,
+        }
+    }
+
+    /// Get the empty value of a collection type passed to `make`
+    fn empty_collection(&self, type_node: &Node) -> Option<serde_yaml::Value> {
+        match type_node.kind() {
+            "map_type" => Some(serde_yaml::Value::Mapping(serde_yaml::Mapping::new())),
+            "slice_type" => Some(serde_yaml::Value::Sequence(Vec::new())),
+            _ => None,
+        }
+    }
+
+    /// Process a method declaration node
+    fn process_method_declaration(
         &mut self,
-        import_spec: &Node,
+        method_decl_node: &Node,
         file_path: &Path,
         content: &str,
     ) -> Result<()> {
-        let mut path = String::new();
-        let mut alias = None;
-        let mut cursor = import_spec.walk();
+        let mut name = String::new();
+        let mut receiver = None;
+        let mut params = Vec::new();
+        let mut results = Vec::new();
 
-        for child in import_spec.children(&mut cursor) {
-            match child.kind() {
-                "package_identifier" => {
-                    alias = Some(self.get_node_text(child, content));
-                }
-                "interpreted_string_literal" => {
-                    path = self
-                        .get_node_text(child, content)
-                        .trim_matches('"')
-                        .to_string();
+        if let Some(name_node) = method_decl_node.child_by_field_name("name") {
+            name = self.get_node_text(name_node, content);
+        }
+        if let Some(param_list) = method_decl_node.child_by_field_name("parameters") {
+            params = self.parse_parameter_list(&param_list, content)?;
+        }
+        if let Some(result) = method_decl_node.child_by_field_name("result") {
+            results = self.parse_result(&result, content)?;
+        }
+
+        // For method declarations, we need to parse the receiver from the parent context
+        // The receiver is typically the first parameter in the method declaration
+        // Let me check if there's a receiver parameter list
+        let mut receiver_cursor = method_decl_node.walk();
+        for child in method_decl_node.children(&mut receiver_cursor) {
+            if child.kind() == "parameter_list" {
+                // Check if this parameter list contains a receiver
+                let mut param_cursor = child.walk();
+                for param in child.children(&mut param_cursor) {
+                    if param.kind() == "parameter_declaration" {
+                        let receiver_info = self.parse_receiver_parameter(&param, content)?;
+                        if receiver_info.is_some() {
+                            receiver = receiver_info;
+                            break;
+                        }
+                    }
                 }
-                _ => {}
+                break;
             }
         }
 
-        let import_node = ImportNode {
-            path,
-            alias,
-            position: self.node_to_position(*import_spec, file_path),
+        // Create method node
+        let method_node = MethodNode {
+            name,
+            receiver,
+            params,
+            results,
+            docs: self.extract_documentation(method_decl_node, content),
+            position: self.node_to_position(*method_decl_node, file_path),
         };
 
-        self.nodes.push(GoAstNode::Import(import_node));
+        self.nodes.push(GoAstNode::Method(method_node));
+
         Ok(())
     }
 
-    /// Extract comments from AST
-    fn extract_comments(
-        &mut self,
-        _root_node: &Node,
-        file_path: &Path,
+    /// Parse receiver parameter from a parameter declaration
+    fn parse_receiver_parameter(
+        &self,
+        param_decl: &Node,
         content: &str,
-    ) -> Result<()> {
-        // Tree-sitter doesn't include comments in the AST by default
-        // We'll extract them from the source text
-        let lines: Vec<&str> = content.lines().collect();
+    ) -> Result<Option<TypeDefinition>> {
+        let mut names = Vec::new();
+        let mut param_type = TypeDefinition::Basic("unknown".to_string());
+        let mut cursor = param_decl.walk();
 
-        for (line_num, line) in lines.iter().enumerate() {
-            let trimmed = line.trim();
+        for child in param_decl.children(&mut cursor) {
+            match child.kind() {
+                "identifier" => {
+                    let name = self.get_node_text(child, content);
+                    names.push(name);
+                }
+                "type" => {
+                    param_type = self.parse_type_definition(&child, content)?;
+                }
+                "type_identifier" => {
+                    let type_name = self.get_node_text(child, content);
+                    param_type = TypeDefinition::Basic(type_name.clone());
+                }
+                "pointer_type" => {
+                    param_type = self.parse_type_definition(&child, content)?;
+                }
+                _ => {}
+            }
+        }
 
-            if let Some(stripped) = trimmed.strip_prefix("//") {
-                let comment = CommentNode {
-                    text: stripped.trim().to_string(),
-                    comment_type: CommentType::Line,
-                    position: Position {
-                        file: file_path.to_path_buf(),
-                        line: line_num + 1,
-                        column: 1,
-                        offset: 0,
-                    },
-                };
-                self.nodes.push(GoAstNode::Comment(comment));
-            } else if trimmed.starts_with("/*") && trimmed.ends_with("*/") {
-                let comment = CommentNode {
-                    text: trimmed[2..trimmed.len() - 2].trim().to_string(),
-                    comment_type: CommentType::Block,
-                    position: Position {
-                        file: file_path.to_path_buf(),
-                        line: line_num + 1,
-                        column: 1,
-                        offset: 0,
-                    },
+        // In Go, receivers are written as (t Type) or (Type)
+        // The receiver is always a single parameter with a type
+        // We identify it by checking if it has a valid type (not "unknown")
+        match &param_type {
+            TypeDefinition::Basic(type_name) => {
+                if !type_name.is_empty() && type_name != "unknown" {
+                    return Ok(Some(param_type));
+                }
+            }
+            TypeDefinition::Pointer(inner_type) => {
+                if let TypeDefinition::Basic(type_name) = inner_type.as_ref() {
+                    if !type_name.is_empty() && type_name != "unknown" {
+                        return Ok(Some(param_type));
+                    }
+                }
+            }
+            _ => {}
+        }
+
+        Ok(None)
+    }
+
+    /// Extract imports from AST
+    fn extract_imports(&mut self, root_node: &Node, file_path: &Path, content: &str) -> Result<()> {
+        let mut cursor = root_node.walk();
+
+        for node in root_node.children(&mut cursor) {
+            if node.kind() == "import_declaration" {
+                self.process_import_declaration(&node, file_path, content)?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Process import declaration
+    fn process_import_declaration(
+        &mut self,
+        import_decl: &Node,
+        file_path: &Path,
+        content: &str,
+    ) -> Result<()> {
+        for child in import_decl.children(&mut import_decl.walk()) {
+            match child.kind() {
+                "import_spec_list" => {
+                    for import_spec in child.children(&mut child.walk()) {
+                        if import_spec.kind() == "import_spec" {
+                            self.process_import_spec(&import_spec, file_path, content)?;
+                        }
+                    }
+                }
+                // `import "C"` and other imports without parentheses
+                "import_spec" => self.process_import_spec(&child, file_path, content)?,
+                _ => {}
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Process import specification
+    fn process_import_spec(
+        &mut self,
+        import_spec: &Node,
+        file_path: &Path,
+        content: &str,
+    ) -> Result<()> {
+        let mut path = String::new();
+        let mut alias = None;
+        let mut cursor = import_spec.walk();
+
+        for child in import_spec.children(&mut cursor) {
+            match child.kind() {
+                "package_identifier" => {
+                    alias = Some(self.get_node_text(child, content));
+                }
+                "interpreted_string_literal" => {
+                    path = self
+                        .get_node_text(child, content)
+                        .trim_matches('"')
+                        .to_string();
+                }
+                _ => {}
+            }
+        }
+
+        if !path.is_empty() {
+            let name = alias
+                .clone()
+                .unwrap_or_else(|| default_package_name(&path).to_string());
+            self.imports.insert(name, path.clone());
+        }
+
+        let import_node = ImportNode {
+            path,
+            alias,
+            position: self.node_to_position(*import_spec, file_path),
+        };
+
+        self.nodes.push(GoAstNode::Import(import_node));
+        Ok(())
+    }
+
+    /// Extract comments from AST
+    fn extract_comments(
+        &mut self,
+        _root_node: &Node,
+        file_path: &Path,
+        content: &str,
+    ) -> Result<()> {
+        // Tree-sitter doesn't include comments in the AST by default
+        // We'll extract them from the source text
+        let lines: Vec<&str> = content.lines().collect();
+
+        for (line_num, line) in lines.iter().enumerate() {
+            let trimmed = line.trim();
+
+            if let Some(stripped) = trimmed.strip_prefix("//") {
+                let comment = CommentNode {
+                    text: stripped.trim().to_string(),
+                    comment_type: CommentType::Line,
+                    position: Position {
+                        file: file_path.to_path_buf(),
+                        line: line_num + 1,
+                        column: 1,
+                        offset: 0,
+                    },
+                };
+                self.nodes.push(GoAstNode::Comment(comment));
+            } else if trimmed.starts_with("/*") && trimmed.ends_with("*/") {
+                let comment = CommentNode {
+                    text: trimmed[2..trimmed.len() - 2].trim().to_string(),
+                    comment_type: CommentType::Block,
+                    position: Position {
+                        file: file_path.to_path_buf(),
+                        line: line_num + 1,
+                        column: 1,
+                        offset: 0,
+                    },
                 };
                 self.nodes.push(GoAstNode::Comment(comment));
             }
         }
 
-        Ok(())
+        Ok(())
+    }
+
+    /// Extract documentation comments for a node
+    fn extract_documentation(&self, node: &Node, content: &str) -> Vec<String> {
+        // Doc comments are the `//` lines directly above the line the node starts on
+        let line_start = content[..node.start_byte()]
+            .rfind('\n')
+            .map(|idx| idx + 1)
+            .unwrap_or(0);
+
+        let mut docs = Vec::new();
+        for line in content[..line_start].lines().rev() {
+            let trimmed = line.trim();
+            match trimmed.strip_prefix("//") {
+                Some(_) if trimmed.starts_with("//go:") => {}
+                Some(text) => docs.push(text.trim().to_string()),
+                None => break,
+            }
+        }
+
+        docs.reverse();
+        docs
+    }
+
+    /// Get text content of a node
+    fn get_node_text(&self, node: Node, content: &str) -> String {
+        let start = node.start_byte();
+        let end = node.end_byte();
+        content[start..end].to_string()
+    }
+
+    /// Convert tree-sitter node to position
+    fn node_to_position(&self, node: Node, file_path: &Path) -> Position {
+        Position {
+            file: file_path.to_path_buf(),
+            line: node.start_position().row + 1,
+            column: node.start_position().column + 1,
+            offset: node.start_byte(),
+        }
+    }
+
+    /// Get all parsed nodes
+    pub fn get_nodes(&self) -> &[GoAstNode] {
+        &self.nodes
+    }
+
+    /// Get type definitions
+    pub fn get_type_defs(&self) -> &HashMap<String, TypeDefinition> {
+        &self.type_defs
+    }
+
+    /// Get package information
+    pub fn get_package_info(&self) -> Option<&PackageNode> {
+        self.package_info.as_ref()
+    }
+
+    /// Extract schemas from AST
+    pub fn extract_schemas(&self) -> Vec<ExtractedSchema> {
+        let mut schemas = Vec::new();
+
+        for node in &self.nodes {
+            if let GoAstNode::TypeDecl(type_decl) = node {
+                if self.is_excluded(&type_decl.name) {
+                    continue;
+                }
+                let schema = self.type_decl_to_schema(type_decl);
+                schemas.push(schema);
+            }
+        }
+
+        schemas
+    }
+
+    /// Get the method sets of the types receiving methods in this file
+    ///
+    /// Methods may be declared in another file than their type, so the sets
+    /// are returned as `go_methods` schemas for `resolve_unions` to match
+    /// against the interfaces of the whole module.
+    pub fn method_sets(&self) -> Vec<ExtractedSchema> {
+        let mut sets: BTreeMap<&str, (Vec<&str>, &Position)> = BTreeMap::new();
+        for node in &self.nodes {
+            if let GoAstNode::Method(method) = node {
+                let Some(receiver) = method.receiver.as_ref().and_then(|r| self.named_type(r))
+                else {
+                    continue;
+                };
+                sets.entry(receiver)
+                    .or_insert_with(|| (Vec::new(), &method.position))
+                    .0
+                    .push(&method.name);
+            }
+        }
+
+        sets.into_iter()
+            .map(|(receiver, (mut methods, position))| {
+                methods.sort();
+                methods.dedup();
+                ExtractedSchema {
+                    name: receiver.to_string(),
+                    schema_type: METHODS_SCHEMA_TYPE.to_string(),
+                    content: serde_yaml::Value::Sequence(
+                        methods
+                            .into_iter()
+                            .map(|m| serde_yaml::Value::String(m.to_string()))
+                            .collect(),
+                    ),
+                    source_file: position.file.clone(),
+                    metadata: HashMap::new(),
+                }
+            })
+            .collect()
+    }
+
+    /// Get the scheme registration of this file
+    ///
+    /// `GroupVersion` is usually declared in another file than the types it
+    /// applies to, so it is returned as a `go_group_version` schema for
+    /// `resolve_group_versions` to apply to the resources of the package.
+    pub fn scheme_registration(&self) -> Vec<ExtractedSchema> {
+        if self.group_version.is_none() && self.registered_kinds.is_empty() {
+            return Vec::new();
+        }
+
+        let mut registration = serde_yaml::Mapping::new();
+        if let Some((group, version)) = &self.group_version {
+            registration.insert("group".into(), group.as_str().into());
+            registration.insert("version".into(), version.as_str().into());
+        }
+        let mut kinds = self.registered_kinds.clone();
+        kinds.sort();
+        kinds.dedup();
+        registration.insert(
+            "kinds".into(),
+            serde_yaml::Value::Sequence(kinds.into_iter().map(serde_yaml::Value::String).collect()),
+        );
+
+        let package = self.package_info.as_ref();
+        vec![ExtractedSchema {
+            name: package.map(|p| p.name.clone()).unwrap_or_default(),
+            schema_type: GROUP_VERSION_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::Value::Mapping(registration),
+            source_file: package.map(|p| PathBuf::from(&p.path)).unwrap_or_default(),
+            metadata: HashMap::new(),
+        }]
+    }
+
+    /// Get the exported constants of this file
+    ///
+    /// The constants of a package are spread over its files, so they are
+    /// returned as a `go_constants` schema for `take_constants` to merge.
+    pub fn constants(&self) -> Vec<ExtractedSchema> {
+        if self.constants.is_empty() {
+            return Vec::new();
+        }
+        let package = self.package_info.as_ref();
+        vec![ExtractedSchema {
+            name: package.map(|p| p.name.clone()).unwrap_or_default(),
+            schema_type: CONSTANTS_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::to_value(&self.constants).unwrap_or_default(),
+            source_file: package.map(|p| PathBuf::from(&p.path)).unwrap_or_default(),
+            metadata: HashMap::new(),
+        }]
+    }
+
+    /// Get the exported errors and error codes of this file
+    ///
+    /// Codes may be declared in another file than their errors, so they are
+    /// returned as a `go_errors` schema for `take_errors` to match.
+    pub fn error_catalog(&self) -> Vec<ExtractedSchema> {
+        if self.go_errors.is_empty() {
+            return Vec::new();
+        }
+        let package = self.package_info.as_ref();
+        vec![ExtractedSchema {
+            name: package.map(|p| p.name.clone()).unwrap_or_default(),
+            schema_type: ERRORS_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::to_value(&self.go_errors).unwrap_or_default(),
+            source_file: package.map(|p| PathBuf::from(&p.path)).unwrap_or_default(),
+            metadata: HashMap::new(),
+        }]
+    }
+
+    /// Get the named primitive types, aliases, named collections, structs and
+    /// interfaces of this file
+    ///
+    /// Fields may use the types of another file of the package, so the
+    /// schemas of the types are returned as a `go_primitive_types` schema for
+    /// `resolve_primitive_types`.
+    pub fn primitive_types(&self) -> Vec<ExtractedSchema> {
+        let declared = self
+            .type_defs
+            .iter()
+            .filter(|(name, definition)| {
+                matches!(
+                    definition,
+                    TypeDefinition::Struct(_) | TypeDefinition::Interface(_)
+                ) || self.collection_definition(name).is_some()
+            })
+            .map(|(name, _)| name);
+        let names: BTreeSet<&String> = self
+            .primitive_types
+            .iter()
+            .map(|type_decl| &type_decl.name)
+            .chain(declared)
+            .collect();
+        if names.is_empty() {
+            return Vec::new();
+        }
+        let mut types = BTreeMap::new();
+        for name in names {
+            types.insert(
+                name.clone(),
+                serde_yaml::Value::Mapping(
+                    self.type_to_schema(&TypeDefinition::Basic(name.clone())),
+                ),
+            );
+        }
+        let package = self.package_info.as_ref();
+        vec![ExtractedSchema {
+            name: package.map(|p| p.name.clone()).unwrap_or_default(),
+            schema_type: PRIMITIVE_TYPES_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::to_value(types).unwrap_or_default(),
+            source_file: package.map(|p| PathBuf::from(&p.path)).unwrap_or_default(),
+            metadata: HashMap::new(),
+        }]
+    }
+
+    /// Get the values of the constants of this file
+    ///
+    /// Defaults may name constants of another file of the package, so the
+    /// values are returned as a `go_constant_values` schema for
+    /// `resolve_constant_defaults`.
+    pub fn const_values(&self) -> Vec<ExtractedSchema> {
+        if self.const_values.is_empty() {
+            return Vec::new();
+        }
+        let package = self.package_info.as_ref();
+        let values: BTreeMap<&String, &serde_yaml::Value> = self.const_values.iter().collect();
+        vec![ExtractedSchema {
+            name: package.map(|p| p.name.clone()).unwrap_or_default(),
+            schema_type: CONSTANT_VALUES_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::to_value(values).unwrap_or_default(),
+            source_file: package.map(|p| PathBuf::from(&p.path)).unwrap_or_default(),
+            metadata: HashMap::new(),
+        }]
+    }
+
+    /// Get the named integer types and the enum values of this file
+    ///
+    /// The constants may be declared in another file than their type, so
+    /// both are returned as `go_enum` schemas for `resolve_enums` to pair up:
+    /// the types with the metadata of their declaration, the values with
+    /// `flags` and `values`.
+    pub fn enums(&self) -> Vec<ExtractedSchema> {
+        let mut schemas: Vec<ExtractedSchema> = self
+            .primitive_types
+            .iter()
+            .filter(|type_decl| {
+                matches!(&type_decl.type_def, TypeDefinition::Basic(underlying) if is_integer_type(underlying))
+            })
+            .filter(|type_decl| !self.is_excluded(&type_decl.name))
+            .map(|type_decl| {
+                let mut schema = self.type_decl_to_schema(type_decl);
+                let mut content = serde_yaml::Mapping::new();
+                content.insert("type".into(), "integer".into());
+                schema.schema_type = ENUM_SCHEMA_TYPE.to_string();
+                schema.content = serde_yaml::Value::Mapping(content);
+                schema
+            })
+            .collect();
+
+        let file = self
+            .package_info
+            .as_ref()
+            .map(|p| PathBuf::from(&p.path))
+            .unwrap_or_default();
+        if self.options.enum_strings {
+            let mut stringers = BTreeSet::new();
+            for node in &self.nodes {
+                if let GoAstNode::Method(method) = node {
+                    let receiver = method.receiver.as_ref().and_then(|r| self.named_type(r));
+                    if let (Some(receiver), "String", true) =
+                        (receiver, method.name.as_str(), method.params.is_empty())
+                    {
+                        stringers.insert(receiver);
+                    }
+                }
+            }
+            schemas.extend(stringers.into_iter().map(|type_name| {
+                let mut content = serde_yaml::Mapping::new();
+                content.insert("stringer".into(), true.into());
+                if let Some(names) = self.stringer_names.get(type_name) {
+                    content.insert(
+                        "names".into(),
+                        names
+                            .iter()
+                            .map(|n| serde_yaml::Value::from(n.as_str()))
+                            .collect(),
+                    );
+                }
+                ExtractedSchema {
+                    name: type_name.to_string(),
+                    schema_type: ENUM_SCHEMA_TYPE.to_string(),
+                    content: serde_yaml::Value::Mapping(content),
+                    source_file: file.clone(),
+                    metadata: HashMap::new(),
+                }
+            }));
+        }
+        schemas.extend(self.enums.iter().map(|(type_name, flags, values)| {
+            let mut content = serde_yaml::Mapping::new();
+            content.insert("flags".into(), (*flags).into());
+            content.insert(
+                "values".into(),
+                serde_yaml::to_value(values).unwrap_or_default(),
+            );
+            ExtractedSchema {
+                name: type_name.clone(),
+                schema_type: ENUM_SCHEMA_TYPE.to_string(),
+                content: serde_yaml::Value::Mapping(content),
+                source_file: file.clone(),
+                metadata: HashMap::new(),
+            }
+        }));
+        schemas
+    }
+
+    /// Get warnings about types that could not be represented faithfully
+    pub fn warnings(&self) -> Vec<String> {
+        let mut warnings = Vec::new();
+
+        for node in &self.nodes {
+            if let GoAstNode::TypeDecl(type_decl) = node {
+                if self.is_excluded(&type_decl.name) {
+                    continue;
+                }
+
+                // Magic comments that are not directives, or not ones of types
+                let directives = Directives::of(&type_decl.docs);
+                for comment in directives.unknown {
+                    warnings.push(Code::UnknownDirective.warning(format!(
+                        "{}: unknown magic comment '{}' is ignored",
+                        type_decl.name, comment
+                    )));
+                }
+                if directives.rename.is_some() {
+                    warnings.push(Code::UnknownDirective.warning(format!(
+                        "{}: {DIRECTIVE_PREFIX}rename applies to fields and is ignored on types",
+                        type_decl.name
+                    )));
+                }
+
+                if let TypeDefinition::Struct(struct_type) = &type_decl.type_def {
+                    for field in &struct_type.fields {
+                        for comment in Directives::of(&field.docs).unknown {
+                            warnings.push(Code::UnknownDirective.warning(format!(
+                                "{}.{}: unknown magic comment '{}' is ignored",
+                                type_decl.name,
+                                field.names.first().cloned().unwrap_or_default(),
+                                comment
+                            )));
+                        }
+                        for field_name in self.unexported_field_names(&type_decl.name, field) {
+                            if self.options.unexported_fields == Some(UnexportedPolicy::Include) {
+                                warnings.push(Code::UnexportedField.warning(format!(
+                                    "{}.{} is unexported and is generated although encoding/json ignores it",
+                                    type_decl.name, field_name
+                                )));
+                            }
+                        }
+                        if let Some(excluded) = self.excluded_reference(&field.field_type) {
+                            let field_name = field.names.first().cloned().unwrap_or_default();
+                            warnings.push(Code::ExcludedReference.warning(format!(
+                                "{}.{} references excluded type {} and is generated as an opaque value",
+                                type_decl.name, field_name, excluded
+                            )));
+                        }
+                    }
+                }
+            }
+        }
+
+        warnings.extend(
+            self.unsupported_constructs()
+                .into_iter()
+                .map(|construct| construct.kind.code().warning(construct.message)),
+        );
+        warnings
+    }
+
+    /// Get the constructs of this file that could not be represented
+    ///
+    /// Returned as `go_unsupported` schemas, which `take_unsupported` gathers
+    /// into the report of the run.
+    pub fn unsupported(&self) -> Vec<ExtractedSchema> {
+        self.unsupported_constructs()
+            .iter()
+            .map(Unsupported::to_schema)
+            .collect()
+    }
+
+    /// Get the types of this file that are not generated, with the reason
+    ///
+    /// Returned as `go_skipped` schemas, which `take_skipped_types` gathers
+    /// into the report and the dry-run plan of the run.
+    pub fn skipped_types(&self) -> Vec<ExtractedSchema> {
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        let mut skipped = Vec::new();
+        for node in &self.nodes {
+            let GoAstNode::TypeDecl(type_decl) = node else {
+                continue;
+            };
+            let reason = if has_marker(&type_decl.docs, EXCLUDE_MARKER) {
+                format!("marked {EXCLUDE_MARKER}")
+            } else if Directives::of(&type_decl.docs).skip {
+                format!("marked {DIRECTIVE_PREFIX}skip")
+            } else if self.options.excludes_type(package, &type_decl.name) {
+                "excluded by exclude_types".to_string()
+            } else if !self.options.selects_type(package, &type_decl.name) {
+                "not selected by types".to_string()
+            } else if let Some(c_type) = self.cgo_type(&type_decl.name) {
+                format!("uses cgo type {c_type}")
+            } else {
+                continue;
+            };
+
+            let mut content = serde_yaml::Mapping::new();
+            content.insert("reason".into(), reason.into());
+            let mut metadata = HashMap::new();
+            metadata.insert("package".to_string(), package.unwrap_or_default().into());
+            metadata.insert(
+                "line".to_string(),
+                serde_yaml::Value::Number((type_decl.position.line as u64).into()),
+            );
+            skipped.push(ExtractedSchema {
+                name: type_decl.name.clone(),
+                schema_type: SKIPPED_SCHEMA_TYPE.to_string(),
+                content: serde_yaml::Value::Mapping(content),
+                source_file: type_decl.position.file.clone(),
+                metadata,
+            });
+        }
+        skipped
+    }
+
+    /// Find the constructs of this file that could not be represented
+    fn unsupported_constructs(&self) -> Vec<Unsupported> {
+        let mut constructs = Vec::new();
+
+        for node in &self.nodes {
+            let GoAstNode::TypeDecl(type_decl) = node else {
+                continue;
+            };
+            if self.is_excluded_by_user(&type_decl.name) {
+                continue;
+            }
+            let construct = |kind,
+                             position: &Position,
+                             field: Option<&String>,
+                             construct: String,
+                             message: String| {
+                Unsupported {
+                    id: String::new(),
+                    kind,
+                    source: String::new(),
+                    file: position.file.clone(),
+                    line: position.line,
+                    type_name: type_decl.name.clone(),
+                    field: field.cloned(),
+                    construct,
+                    message,
+                }
+            };
+
+            if let Some(c_type) = self.cgo_type(&type_decl.name) {
+                constructs.push(construct(
+                    UnsupportedKind::Cgo,
+                    &type_decl.position,
+                    None,
+                    c_type.clone(),
+                    format!(
+                        "{} uses cgo type {} and is not generated",
+                        type_decl.name, c_type
+                    ),
+                ));
+                continue;
+            }
+
+            if let Some(type_params) = self.type_params.get(&type_decl.name) {
+                constructs.push(construct(
+                    UnsupportedKind::Generic,
+                    &type_decl.position,
+                    None,
+                    type_params.clone(),
+                    format!(
+                        "{} has type parameters {}, which are not supported; fields of parameter types get a guessed schema",
+                        type_decl.name, type_params
+                    ),
+                ));
+            }
+
+            if let TypeDefinition::Struct(struct_type) = &type_decl.type_def {
+                for field in &struct_type.fields {
+                    let Some(field_name) = field.names.first() else {
+                        continue;
+                    };
+                    let tag = field.struct_tag();
+
+                    if let Some(rules) = tag.get("validate") {
+                        let mut schema = self.field_to_schema(field);
+                        if let Some(schema) = schema.as_mapping_mut() {
+                            for rule in ValidateRules::parse(rules).apply(schema) {
+                                constructs.push(construct(
+                                    UnsupportedKind::ValidateRule,
+                                    &field.position,
+                                    Some(field_name),
+                                    rule.to_string(),
+                                    format!(
+                                        "{}.{}: validate rule '{}' is not supported and is not checked",
+                                        type_decl.name, field_name, rule
+                                    ),
+                                ));
+                            }
+                        }
+                        if self.options.pattern_checks.unwrap_or_default() != PatternChecks::Native
+                        {
+                            for pattern in patterns::uncompiled(&schema) {
+                                constructs.push(construct(
+                                    UnsupportedKind::ValidateRule,
+                                    &field.position,
+                                    Some(field_name),
+                                    format!("regexp={pattern}"),
+                                    format!(
+                                        "{}.{}: pattern '{}' needs a regex engine and is only checked by std.native('regexMatch'), with pattern_checks: native or auto",
+                                        type_decl.name, field_name, pattern
+                                    ),
+                                ));
+                            }
+                        }
+                    }
+
+                    if let Some(generic) = self
+                        .named_type(&field.field_type)
+                        .filter(|type_name| type_name.contains('['))
+                        .filter(|_| self.unwrap_type(&field.field_type).is_none())
+                    {
+                        constructs.push(construct(
+                            UnsupportedKind::Generic,
+                            &field.position,
+                            Some(field_name),
+                            generic.to_string(),
+                            format!(
+                                "{}.{} has generic type {}, which is not supported and gets a guessed schema",
+                                type_decl.name, field_name, generic
+                            ),
+                        ));
+                    }
+
+                    if let Some(key) = map_key_type(&field.field_type).filter(|key| {
+                        let key = self.primitive_type(key).unwrap_or(key);
+                        matches!(
+                            key,
+                            "bool" | "float32" | "float64" | "complex64" | "complex128"
+                        )
+                    }) {
+                        constructs.push(construct(
+                            UnsupportedKind::MapKey,
+                            &field.position,
+                            Some(field_name),
+                            go_type_string(&field.field_type),
+                            format!(
+                                "{}.{}: encoding/json cannot encode maps with {} keys; the keys are not checked",
+                                type_decl.name, field_name, key
+                            ),
+                        ));
+                    }
+
+                    for (key, known) in [
+                        ("json", &["omitempty", "inline"][..]),
+                        ("yaml", &["omitempty", "inline", "flow"][..]),
+                    ] {
+                        for option in tag.options(key).filter(|option| !known.contains(option)) {
+                            constructs.push(construct(
+                                UnsupportedKind::TagOption,
+                                &field.position,
+                                Some(field_name),
+                                format!("{key}:\",{option}\""),
+                                format!(
+                                    "{}.{}: {} tag option '{}' is not supported and is ignored",
+                                    type_decl.name, field_name, key, option
+                                ),
+                            ));
+                        }
+                    }
+                }
+            }
+
+            if let Some(interface) = self.marshaler_interface(&type_decl.name) {
+                if self.find_type_mapping(&type_decl.name).is_none() {
+                    constructs.push(construct(
+                        UnsupportedKind::CustomMarshaler,
+                        &type_decl.position,
+                        None,
+                        interface.to_string(),
+                        format!(
+                            "{} implements {} and is mapped to an opaque string; add a type mapping to describe its encoding",
+                            type_decl.name, interface
+                        ),
+                    ));
+                }
+            }
+        }
+
+        constructs
+    }
+
+    /// Trace how the schema of the field named by `trace_mapping` is chosen
+    ///
+    /// Returns one report per matching field in this file.
+    pub fn mapping_traces(&self) -> Vec<String> {
+        let Some(target) = self.options.trace_mapping.as_deref() else {
+            return Vec::new();
+        };
+        let (package, path) = match target.matches('.').count() {
+            2 => {
+                let (package, path) = target.split_once('.').unwrap_or_default();
+                (Some(package), path)
+            }
+            _ => (None, target),
+        };
+        let Some((type_name, field_name)) = path.split_once('.') else {
+            return Vec::new();
+        };
+        let current = self.package_info.as_ref().map(|p| p.name.as_str());
+        if package.is_some() && package != current {
+            return Vec::new();
+        }
+
+        self.trace_mapping(type_name, field_name)
+            .map(|trace| vec![trace.render()])
+            .unwrap_or_default()
+    }
+
+    /// Trace how the schema of a struct field is chosen
+    pub fn trace_mapping(&self, type_name: &str, field_name: &str) -> Option<MappingTrace> {
+        let struct_type = match self.type_defs.get(type_name)? {
+            TypeDefinition::Struct(struct_type) => struct_type,
+            _ => return None,
+        };
+        let field = struct_type
+            .fields
+            .iter()
+            .find(|f| f.names.iter().any(|n| n == field_name))?;
+
+        let mut steps = Vec::new();
+        if let Some(reason) = self.left_out_reason(type_name, field_name, field) {
+            steps.push((MappingRule::LeftOut, format!("the field is {reason}")));
+        } else {
+            let key = format!("field_overrides[\"{type_name}.{field_name}\"]");
+            match self.field_rule(type_name, field_name, field) {
+                FieldRule::Schema(_) => {
+                    steps.push((MappingRule::FieldOverride, format!("{key} sets the schema")))
+                }
+                FieldRule::GoType(go_type) => {
+                    steps.push((
+                        MappingRule::FieldOverride,
+                        format!("{key} maps the field to {go_type}"),
+                    ));
+                    self.trace_type(&parse_type_text(go_type), &mut steps);
+                }
+                FieldRule::Any(policy, from) => steps.push((
+                    MappingRule::AnyPolicy,
+                    format!("holds arbitrary JSON; policy {policy:?} from {from}"),
+                )),
+                FieldRule::Derived => self.trace_type(&field.field_type, &mut steps),
+            }
+        }
+        // Every step but the last leads on to another rule
+        let rule = steps.last().map_or(MappingRule::GoType, |(rule, _)| *rule);
+
+        let schema = match self.field_schema(type_name, field_name, field) {
+            _ if rule == MappingRule::LeftOut => None,
+            FieldSchema::Derived => Some(self.field_to_schema(field)),
+            FieldSchema::Override(schema) => Some(schema),
+            FieldSchema::Skip | FieldSchema::Missing => None,
+        };
+
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        Some(MappingTrace {
+            field: match package {
+                Some(package) => format!("{package}.{type_name}.{field_name}"),
+                None => format!("{type_name}.{field_name}"),
+            },
+            go_type: go_type_string(&field.field_type),
+            steps,
+            rule,
+            schema,
+        })
+    }
+
+    /// Check whether a type in this file is left out of generation
+    ///
+    /// Types are left out when marked `+gensonnet:exclude` or
+    /// `gensonnet:skip`, when they match `exclude_types` or are not selected
+    /// by `types`, and when their fields use C types of cgo.
+    fn is_excluded(&self, type_name: &str) -> bool {
+        self.is_excluded_by_user(type_name) || self.cgo_type(type_name).is_some()
+    }
+
+    /// Check whether a type in this file is marked `+gensonnet:exclude` or
+    /// `gensonnet:skip`, matches `exclude_types` or is not selected by `types`
+    fn is_excluded_by_user(&self, type_name: &str) -> bool {
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        let declared = self.nodes.iter().any(
+            |node| matches!(node, GoAstNode::TypeDecl(type_decl) if type_decl.name == type_name),
+        );
+        if declared
+            && (self.options.excludes_type(package, type_name)
+                || !self.options.selects_type(package, type_name))
+        {
+            return true;
+        }
+
+        self.nodes.iter().any(|node| match node {
+            GoAstNode::TypeDecl(type_decl) => {
+                type_decl.name == type_name
+                    && (has_marker(&type_decl.docs, EXCLUDE_MARKER)
+                        || Directives::of(&type_decl.docs).skip)
+            }
+            _ => false,
+        })
+    }
+
+    /// Find an excluded type referenced anywhere in a field type
+    fn excluded_reference<'a>(&self, type_def: &'a TypeDefinition) -> Option<&'a str> {
+        match type_def {
+            TypeDefinition::Basic(type_name) => {
+                self.is_excluded(type_name).then_some(type_name.as_str())
+            }
+            TypeDefinition::Pointer(inner)
+            | TypeDefinition::Slice(inner)
+            | TypeDefinition::Array(inner) => self.excluded_reference(inner),
+            TypeDefinition::Map(_, value) => self.excluded_reference(value),
+            _ => None,
+        }
+    }
+
+    /// Get the marshaling interface a type in this file implements, if any
+    ///
+    /// Types with custom `MarshalJSON` or `MarshalText` methods do not encode
+    /// as their fields, so their struct layout must not be expanded.
+    fn marshaler_interface(&self, type_name: &str) -> Option<&'static str> {
+        let mut interface = None;
+
+        for node in &self.nodes {
+            if let GoAstNode::Method(method) = node {
+                let receiver = method.receiver.as_ref().and_then(|r| self.named_type(r));
+                if receiver != Some(type_name) {
+                    continue;
+                }
+
+                match method.name.as_str() {
+                    "MarshalJSON" => return Some("json.Marshaler"),
+                    "MarshalText" => interface = Some("encoding.TextMarshaler"),
+                    _ => {}
+                }
+            }
+        }
+
+        interface
+    }
+
+    /// Convert type declaration to schema
+    fn type_decl_to_schema(&self, type_decl: &TypeDeclNode) -> ExtractedSchema {
+        let mut metadata = HashMap::new();
+        metadata.insert(
+            "package".to_string(),
+            serde_yaml::Value::String(
+                self.package_info
+                    .as_ref()
+                    .map(|p| p.name.clone())
+                    .unwrap_or_default(),
+            ),
+        );
+        metadata.insert(
+            "line".to_string(),
+            serde_yaml::Value::Number((type_decl.position.line as u64).into()),
+        );
+        metadata.insert(
+            "docs".to_string(),
+            serde_yaml::Value::Sequence(
+                type_decl
+                    .docs
+                    .iter()
+                    .map(|d| serde_yaml::Value::String(d.clone()))
+                    .collect(),
+            ),
+        );
+        metadata.insert(
+            "categories".to_string(),
+            serde_yaml::Value::Sequence(
+                marker_values(&type_decl.docs, CATEGORY_MARKER)
+                    .into_iter()
+                    .map(serde_yaml::Value::String)
+                    .collect(),
+            ),
+        );
+        if let Some(summary) = doc_summary(&type_decl.docs) {
+            metadata.insert("summary".to_string(), serde_yaml::Value::String(summary));
+        }
+        if let TypeDefinition::Struct(struct_type) = &type_decl.type_def {
+            let skipped = self.skipped_fields(&type_decl.name, struct_type);
+            if !skipped.is_empty() {
+                metadata.insert(
+                    "skipped_fields".to_string(),
+                    serde_yaml::Value::Sequence(
+                        skipped
+                            .into_iter()
+                            .map(|(field, reason)| {
+                                let mut entry = serde_yaml::Mapping::new();
+                                entry.insert("field".into(), field.into());
+                                entry.insert("reason".into(), reason.into());
+                                serde_yaml::Value::Mapping(entry)
+                            })
+                            .collect(),
+                    ),
+                );
+            }
+        }
+
+        let mut schema_content = if let Some(interface) = self.marshaler_interface(&type_decl.name)
+        {
+            let mut schema = self
+                .find_type_mapping(&type_decl.name)
+                .map(|mapping| mapping.to_schema())
+                .unwrap_or_else(opaque_schema);
+            schema.insert(
+                serde_yaml::Value::String("x-go-marshaler".to_string()),
+                serde_yaml::Value::String(interface.to_string()),
+            );
+            serde_yaml::Value::Mapping(schema)
+        } else {
+            match &type_decl.type_def {
+                TypeDefinition::Struct(struct_type) => {
+                    let mut schema = self.struct_to_schema(&type_decl.name, struct_type);
+                    if let Some(schema) = schema.as_mapping_mut() {
+                        self.mark_kubernetes_resource(&type_decl.name, struct_type, schema);
+                    }
+                    schema
+                }
+                TypeDefinition::Interface(interface_type) => {
+                    self.interface_to_schema(interface_type, &type_decl.docs)
+                }
+                _ => serde_yaml::Value::Null,
+            }
+        };
+        if let Some(schema) = schema_content.as_mapping_mut() {
+            mark_deprecated(schema, &type_decl.docs);
+            if self.options.deprecation_warnings {
+                schema.insert(
+                    serde_yaml::Value::String("x-go-deprecation-trace".to_string()),
+                    serde_yaml::Value::Bool(true),
+                );
+            }
+        }
+
+        ExtractedSchema {
+            name: type_decl.name.clone(),
+            schema_type: "go_struct".to_string(),
+            content: schema_content,
+            source_file: type_decl.position.file.clone(),
+            metadata,
+        }
+    }
+
+    /// Convert struct type to schema
+    fn struct_to_schema(&self, name: &str, struct_type: &StructTypeNode) -> serde_yaml::Value {
+        let mut properties = serde_yaml::Mapping::new();
+        let mut required = Vec::new();
+        let mut unresolved = Vec::new();
+
+        self.collect_struct_properties(
+            name,
+            struct_type,
+            &mut properties,
+            &mut required,
+            &mut unresolved,
+            &mut Vec::new(),
+        );
+
+        if self.options.infer_accessors {
+            self.infer_accessor_properties(name, struct_type, &mut properties);
+        }
+
+        let mut schema = serde_yaml::Mapping::new();
+        schema.insert(
+            serde_yaml::Value::String("type".to_string()),
+            serde_yaml::Value::String("object".to_string()),
+        );
+        schema.insert(
+            serde_yaml::Value::String("properties".to_string()),
+            serde_yaml::Value::Mapping(properties),
+        );
+
+        if !required.is_empty() {
+            schema.insert(
+                serde_yaml::Value::String("required".to_string()),
+                serde_yaml::Value::Sequence(
+                    required
+                        .iter()
+                        .map(|r| serde_yaml::Value::String(r.clone()))
+                        .collect(),
+                ),
+            );
+        }
+
+        if self.options.function_naming == Some(NamingPolicy::SnakeCase) {
+            schema.insert(
+                serde_yaml::Value::String("x-go-function-naming".to_string()),
+                serde_yaml::Value::String("snake_case".to_string()),
+            );
+        }
+        if self.options.defaults_library {
+            schema.insert(DEFAULTS_LIBRARY_KEY.into(), true.into());
+        }
+        if self.options.env_setters {
+            schema.insert(ENV_SETTERS_KEY.into(), true.into());
+        }
+        match self.options.pattern_checks {
+            Some(PatternChecks::Native) => {
+                schema.insert(PATTERN_CHECKS_KEY.into(), "native".into());
+            }
+            Some(PatternChecks::Auto) => {
+                schema.insert(PATTERN_CHECKS_KEY.into(), "auto".into());
+            }
+            _ => {}
+        }
+        match self.options.merge_checks {
+            Some(MergeChecks::Trace) => {
+                schema.insert(MERGE_CHECKS_KEY.into(), "trace".into());
+            }
+            Some(MergeChecks::Assert) => {
+                schema.insert(MERGE_CHECKS_KEY.into(), "assert".into());
+            }
+            None => {}
+        }
+        if let Some(visibility) = &self.options.visibility {
+            if let Ok(visibility) = serde_yaml::to_value(visibility) {
+                schema.insert(VISIBILITY_KEY.into(), visibility);
+            }
+        }
+
+        // Inline types we could not resolve in this file are recorded so the
+        // generator can point users at the missing fields
+        if !unresolved.is_empty() {
+            schema.insert(
+                serde_yaml::Value::String("x-go-embedded".to_string()),
+                serde_yaml::Value::Sequence(
+                    unresolved
+                        .into_iter()
+                        .map(serde_yaml::Value::String)
+                        .collect(),
+                ),
+            );
+        }
+
+        serde_yaml::Value::Mapping(schema)
+    }
+
+    /// Record the Kubernetes resource layout of a struct
+    ///
+    /// A struct embedding `metav1.TypeMeta`, with `metav1.ObjectMeta` metadata
+    /// and a `spec` field, is a resource: the fields of its spec struct are
+    /// expanded so the generator can build `spec` helpers, `status` is marked
+    /// read-only, and `x-go-kubernetes` gives the kind and API version.
+    fn mark_kubernetes_resource(
+        &self,
+        name: &str,
+        struct_type: &StructTypeNode,
+        schema: &mut serde_yaml::Mapping,
+    ) {
+        let field = |wire: &str| {
+            struct_type.fields.iter().find(|field| {
+                field
+                    .names
+                    .iter()
+                    .any(|n| self.wire_name(name, n, field) == wire)
+            })
+        };
+        let has_type_meta = struct_type
+            .fields
+            .iter()
+            .any(|f| f.embedded && self.is_metav1_type(&f.field_type, "TypeMeta"));
+        let has_object_meta =
+            field("metadata").is_some_and(|f| self.is_metav1_type(&f.field_type, "ObjectMeta"));
+        let spec = match field("spec") {
+            Some(spec) if has_type_meta && has_object_meta => spec,
+            _ => return,
+        };
+
+        // TypeMeta is not declared here; its fields are known
+        if let Some(embedded) = schema
+            .get_mut("x-go-embedded")
+            .and_then(|e| e.as_sequence_mut())
+        {
+            embedded.retain(|e| {
+                !e.as_str()
+                    .is_some_and(|e| e.ends_with(".TypeMeta") || e == "TypeMeta")
+            });
+            if embedded.is_empty() {
+                schema.remove("x-go-embedded");
+            }
+        }
+
+        if let Some(properties) = schema
+            .get_mut("properties")
+            .and_then(|p| p.as_mapping_mut())
+        {
+            for key in ["apiVersion", "kind"] {
+                let mut property = serde_yaml::Mapping::new();
+                property.insert(
+                    serde_yaml::Value::String("type".to_string()),
+                    serde_yaml::Value::String("string".to_string()),
+                );
+                properties
+                    .entry(serde_yaml::Value::String(key.to_string()))
+                    .or_insert(serde_yaml::Value::Mapping(property));
+            }
+
+            let spec_struct = self.named_type(&spec.field_type).and_then(|type_name| {
+                match self.type_defs.get(type_name) {
+                    Some(TypeDefinition::Struct(inner))
+                        if self.marshaler_interface(type_name).is_none()
+                            && !self.is_excluded(type_name) =>
+                    {
+                        Some((type_name, inner))
+                    }
+                    _ => None,
+                }
+            });
+            if let (Some((type_name, inner)), Some(property)) = (
+                spec_struct,
+                properties.get_mut("spec").and_then(|p| p.as_mapping_mut()),
+            ) {
+                if let serde_yaml::Value::Mapping(inner) = self.struct_to_schema(type_name, inner) {
+                    for key in ["properties", "required"] {
+                        if let Some(value) = inner.get(key) {
+                            property
+                                .insert(serde_yaml::Value::String(key.to_string()), value.clone());
+                        }
+                    }
+                }
+            }
+
+            // The status is written by the controller, not by users
+            if let Some(status) = properties
+                .get_mut("status")
+                .and_then(|p| p.as_mapping_mut())
+            {
+                status.remove("x-go-constructor-param");
+                status.insert(
+                    serde_yaml::Value::String("readOnly".to_string()),
+                    serde_yaml::Value::Bool(true),
+                );
+            }
+        }
+
+        let mut resource = serde_yaml::Mapping::new();
+        resource.insert(
+            serde_yaml::Value::String("kind".to_string()),
+            serde_yaml::Value::String(name.to_string()),
+        );
+        if let Some(api_version) = self.api_version() {
+            resource.insert(
+                serde_yaml::Value::String("apiVersion".to_string()),
+                serde_yaml::Value::String(api_version),
+            );
+        }
+        schema.insert(
+            serde_yaml::Value::String("x-go-kubernetes".to_string()),
+            serde_yaml::Value::Mapping(resource),
+        );
+    }
+
+    /// Check whether a type is the given type of the `metav1` package
+    fn is_metav1_type(&self, type_def: &TypeDefinition, name: &str) -> bool {
+        self.named_type(type_def)
+            .and_then(|type_name| type_name.split_once('.'))
+            .is_some_and(|(qualifier, type_name)| {
+                type_name == name
+                    && self.imports.get(qualifier).map(String::as_str) == Some(METAV1_IMPORT_PATH)
+            })
+    }
+
+    /// Get the API version of the Kubernetes types of this file
+    ///
+    /// The group is taken from a `+groupName=` comment of the file, and the
+    /// version from the package name (`v1`, `v1beta1`, ...).
+    fn api_version(&self) -> Option<String> {
+        let comments: Vec<String> = self
+            .nodes
+            .iter()
+            .filter_map(|node| match node {
+                GoAstNode::Comment(comment) => Some(comment.text.clone()),
+                _ => None,
+            })
+            .collect();
+        let group = marker_values(&comments, GROUP_NAME_MARKER)
+            .into_iter()
+            .next()?;
+        let version = &self.package_info.as_ref()?.name;
+        is_api_version(version).then(|| format!("{group}/{version}"))
+    }
+
+    /// Collect the wire-level properties of a struct, flattening inline fields
+    ///
+    /// Mirrors encoding/json and yaml.v3: anonymous struct fields without an
+    /// explicit name and fields tagged `,inline` contribute their fields to the
+    /// parent, and fields declared directly on the parent shadow promoted ones.
+    fn collect_struct_properties(
+        &self,
+        struct_name: &str,
+        struct_type: &StructTypeNode,
+        properties: &mut serde_yaml::Mapping,
+        required: &mut Vec<String>,
+        unresolved: &mut Vec<String>,
+        visiting: &mut Vec<String>,
+    ) {
+        let mut inline_fields = Vec::new();
+        let constructor_defaults = self.constructor_defaults.get(struct_name);
+        let type_directives = self.type_directives(struct_name);
+
+        for field in &struct_type.fields {
+            let tag = field.struct_tag();
+            let directives = Directives::of(&field.docs);
+            if tag.is_ignored("json") || directives.skip {
+                continue;
+            }
+
+            if self.field_is_inline(field) {
+                inline_fields.push(field);
+                continue;
+            }
+
+            let package = self.package_info.as_ref().map(|p| p.name.as_str());
+            for name in &field.names {
+                // Flattened fields are configured explicitly, even when unexported
+                if let Some(prefix) = self.options.flatten_prefix(package, struct_name, name) {
+                    if !self.options.excludes_field(package, struct_name, name)
+                        && self.flatten_field(
+                            prefix, field, properties, required, unresolved, visiting,
+                        )
+                    {
+                        continue;
+                    }
+                }
+                if self.options.excludes_field(package, struct_name, name)
+                    || (!is_exported(name)
+                        && self.options.unexported_fields.unwrap_or_default()
+                            == UnexportedPolicy::Skip)
+                {
+                    continue;
+                }
+
+                let wire_name = self.wire_name(struct_name, name, field);
+                let key = serde_yaml::Value::String(wire_name.clone());
+                if properties.contains_key(&key) {
+                    continue;
+                }
+
+                let mut schema = match self.field_schema(struct_name, name, field) {
+                    FieldSchema::Derived => self.field_to_schema(field),
+                    FieldSchema::Override(schema) => schema,
+                    FieldSchema::Skip | FieldSchema::Missing => continue,
+                };
+
+                // A `default` tag wins over the value assigned by the constructor
+                let (mut default, mut constant) = match tag.get("default") {
+                    Some(value) => self.tag_default(value, &schema),
+                    None => (None, None),
+                };
+                if default.is_none() && constant.is_none() {
+                    match constructor_defaults.and_then(|defaults| defaults.get(name)) {
+                        Some(FieldDefault::Value(value)) => default = Some(value.clone()),
+                        Some(FieldDefault::Constant(name)) => constant = Some(name.clone()),
+                        None => {}
+                    }
+                }
+                if let Some(schema) = schema.as_mapping_mut() {
+                    if let Some(default) = default {
+                        schema.insert(serde_yaml::Value::String("default".to_string()), default);
+                    }
+                    if let Some(constant) = constant {
+                        schema.insert(DEFAULT_CONSTANT_KEY.into(), constant.into());
+                    }
+                }
+
+                // Validator rules become schema keywords checked by the setters
+                let rules = ValidateRules::parse(tag.get("validate").unwrap_or_default());
+                if let Some(schema) = schema.as_mapping_mut() {
+                    rules.apply(schema);
+                }
+
+                // Mandatory fields become parameters of new()
+                if rules.is_required() {
+                    if let Some(schema) = schema.as_mapping_mut() {
+                        schema.insert(
+                            serde_yaml::Value::String("x-go-constructor-param".to_string()),
+                            serde_yaml::Value::Bool(true),
+                        );
+                    }
+                }
+
+                if let (TypeDefinition::Pointer(_), Some(schema)) =
+                    (&field.field_type, schema.as_mapping_mut())
+                {
+                    self.apply_pointer_strategy(schema);
+                }
+
+                // Renamed fields keep their old setters as aliases
+                let explicit = self.explicit_name(struct_name, name, field).is_some();
+                let renamed: Vec<serde_yaml::Value> = self
+                    .options
+                    .renamed_from(package, struct_name, name)
+                    .into_iter()
+                    .filter_map(|old| renamed_wire_name(old, name, &wire_name))
+                    .map(|old| match explicit {
+                        true => old,
+                        false => self.options.field_naming.unwrap_or_default().apply(&old),
+                    })
+                    .filter(|old| *old != wire_name)
+                    .map(serde_yaml::Value::String)
+                    .collect();
+                if let (false, Some(schema)) = (renamed.is_empty(), schema.as_mapping_mut()) {
+                    schema.insert(
+                        serde_yaml::Value::String("x-go-renamed-from".to_string()),
+                        serde_yaml::Value::Sequence(renamed),
+                    );
+                }
+
+                // Where the field is declared, for the comments of the generated setters
+                if let Some(schema) = schema.as_mapping_mut() {
+                    mark_deprecated(schema, &field.docs);
+                    // Read-only fields are documented but not set by users
+                    if directives.readonly
+                        || type_directives.readonly
+                        || self.is_status_field(name, field)
+                    {
+                        schema.insert("readOnly".into(), true.into());
+                        schema.remove("x-go-constructor-param");
+                    }
+                    // String maps of labels and annotations get keys checked
+                    // and entry setters
+                    let metadata_map = directives.metadata_map.or(match name.as_str() {
+                        "Labels" => Some("labels"),
+                        "Annotations" => Some("annotations"),
+                        _ => None,
+                    });
+                    if let Some(kind) = metadata_map.filter(|_| is_string_map(schema)) {
+                        let mut keys = serde_yaml::Mapping::new();
+                        keys.insert("type".into(), "string".into());
+                        keys.insert("format".into(), QUALIFIED_NAME_FORMAT.into());
+                        schema.insert(MAP_KEY_KEY.into(), keys.into());
+                        schema.insert(METADATA_MAP_KEY.into(), kind.into());
+                    }
+                    match self.options.field_visibility(package, struct_name, name) {
+                        Some(visibility) => {
+                            schema.insert(
+                                HIDDEN_KEY.into(),
+                                (visibility == Visibility::Hidden).into(),
+                            );
+                        }
+                        None if directives.hidden || type_directives.hidden => {
+                            schema.insert(HIDDEN_KEY.into(), true.into());
+                        }
+                        None => {}
+                    }
+                    if self.options.omit_empty && self.field_omits_empty(field) {
+                        schema.insert(OMIT_EMPTY_KEY.into(), true.into());
+                        // Only nil pointers are empty, nullable or not
+                        if self.field_is_pointer(field) {
+                            schema.insert(POINTER_KEY.into(), true.into());
+                        }
+                    }
+
+                    let mut source = serde_yaml::Mapping::new();
+                    source.insert(
+                        serde_yaml::Value::String("type".to_string()),
+                        serde_yaml::Value::String(struct_name.to_string()),
+                    );
+                    source.insert(
+                        serde_yaml::Value::String("field".to_string()),
+                        serde_yaml::Value::String(name.clone()),
+                    );
+                    source.insert(
+                        serde_yaml::Value::String("line".to_string()),
+                        serde_yaml::Value::Number((field.position.line as u64).into()),
+                    );
+                    schema.insert(
+                        serde_yaml::Value::String("x-go-source".to_string()),
+                        serde_yaml::Value::Mapping(source),
+                    );
+                }
+
+                properties.insert(key, schema);
+
+                // Check if field is required (no pointer, no omitempty tag)
+                if !self.field_is_optional(field) {
+                    required.push(wire_name);
+                }
+            }
+        }
+
+        // Cross-field rules name Go fields; refer to them by wire name
+        let wire_names: HashMap<&str, String> = struct_type
+            .fields
+            .iter()
+            .flat_map(|field| {
+                field
+                    .names
+                    .iter()
+                    .map(move |name| (name.as_str(), self.wire_name(struct_name, name, field)))
+            })
+            .collect();
+        for property in properties.values_mut() {
+            resolve_field_rules(property, &wire_names);
+        }
+
+        for field in inline_fields {
+            let optional = matches!(field.field_type, TypeDefinition::Pointer(_));
+            let type_name = match self.named_type(&field.field_type) {
+                Some(type_name) => type_name,
+                None => continue,
+            };
+
+            if visiting.iter().any(|v| v == type_name) {
+                continue;
+            }
+
+            match self.type_defs.get(type_name) {
+                Some(TypeDefinition::Struct(inner))
+                    if self.marshaler_interface(type_name).is_none()
+                        && !self.is_excluded(type_name) =>
+                {
+                    let mut inner_required = Vec::new();
+                    visiting.push(type_name.to_string());
+                    self.collect_struct_properties(
+                        type_name,
+                        inner,
+                        properties,
+                        &mut inner_required,
+                        unresolved,
+                        visiting,
+                    );
+                    visiting.pop();
+
+                    if !optional {
+                        for name in inner_required {
+                            if !required.contains(&name) {
+                                required.push(name);
+                            }
+                        }
+                    }
+                }
+                Some(_) => {
+                    // Embedded non-struct, excluded and custom-marshaled types
+                    // are encoded as a regular field
+                    let name = field.names.first().cloned().unwrap_or_default();
+                    let key = serde_yaml::Value::String(name.clone());
+                    if !properties.contains_key(&key) {
+                        properties.insert(key, self.field_to_schema(field));
+                        if !optional {
+                            required.push(name);
+                        }
+                    }
+                }
+                None => unresolved.push(type_name.to_string()),
+            }
+        }
+    }
+
+    /// Collect the properties of the struct of a field under a prefix, for
+    /// `flatten_fields`
+    ///
+    /// Returns false when the field's type is not a struct of the module,
+    /// which is then generated as a regular field.
+    fn flatten_field(
+        &self,
+        prefix: &str,
+        field: &FieldNode,
+        properties: &mut serde_yaml::Mapping,
+        required: &mut Vec<String>,
+        unresolved: &mut Vec<String>,
+        visiting: &mut Vec<String>,
+    ) -> bool {
+        let Some(type_name) = self.named_type(&field.field_type) else {
+            return false;
+        };
+        let inner = match self.type_defs.get(type_name) {
+            Some(TypeDefinition::Struct(inner))
+                if self.marshaler_interface(type_name).is_none()
+                    && !self.is_excluded(type_name)
+                    && !visiting.iter().any(|v| v == type_name) =>
+            {
+                inner
+            }
+            _ => return false,
+        };
+
+        let mut inner_properties = serde_yaml::Mapping::new();
+        let mut inner_required = Vec::new();
+        visiting.push(type_name.to_string());
+        self.collect_struct_properties(
+            type_name,
+            inner,
+            &mut inner_properties,
+            &mut inner_required,
+            unresolved,
+            visiting,
+        );
+        visiting.pop();
+
+        let naming = self.options.field_naming.unwrap_or_default();
+        let optional = matches!(field.field_type, TypeDefinition::Pointer(_));
+        for (key, schema) in inner_properties {
+            let Some(key) = key.as_str() else {
+                continue;
+            };
+            let name = naming.prefixed(prefix, key);
+            if !optional && inner_required.iter().any(|r| r == key) && !required.contains(&name) {
+                required.push(name.clone());
+            }
+            properties
+                .entry(serde_yaml::Value::String(name))
+                .or_insert(schema);
+        }
+        true
+    }
+
+    /// Get the fields of a struct that are left out of its schema, with the reason
+    ///
+    /// Fields of embedded structs are reported with the embedded type.
+    fn skipped_fields(
+        &self,
+        struct_name: &str,
+        struct_type: &StructTypeNode,
+    ) -> Vec<(String, String)> {
+        let mut skipped = Vec::new();
+        for field in &struct_type.fields {
+            if !field.struct_tag().is_ignored("json") && self.field_is_inline(field) {
+                continue;
+            }
+            for name in &field.names {
+                let reason = match self.left_out_reason(struct_name, name, field) {
+                    Some(reason) => reason,
+                    None => match self.field_schema(struct_name, name, field) {
+                        FieldSchema::Skip => "any_policy is skip",
+                        FieldSchema::Missing => {
+                            "any_policy is require and no override is configured"
+                        }
+                        FieldSchema::Derived | FieldSchema::Override(_) => continue,
+                    },
+                };
+                skipped.push((name.clone(), reason.to_string()));
+            }
+        }
+        skipped
+    }
+
+    /// Get why a struct field is left out by its tags, directives or the
+    /// configuration, before its schema is looked at
+    fn left_out_reason(
+        &self,
+        struct_name: &str,
+        field_name: &str,
+        field: &FieldNode,
+    ) -> Option<&'static str> {
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        if field.struct_tag().is_ignored("json") {
+            Some("tagged json:\"-\"")
+        } else if Directives::of(&field.docs).skip {
+            Some("marked gensonnet:skip")
+        } else if self
+            .options
+            .excludes_field(package, struct_name, field_name)
+        {
+            Some("excluded by configuration")
+        } else if !is_exported(field_name)
+            && self.options.unexported_fields.unwrap_or_default() == UnexportedPolicy::Skip
+        {
+            Some("unexported")
+        } else {
+            None
+        }
+    }
+
+    /// Add properties for `GetX()`/`SetX()` accessors that have no backing field
+    ///
+    /// Synthesized properties carry `x-go-accessors` so the generated library
+    /// can flag them for confirmation.
+    fn infer_accessor_properties(
+        &self,
+        struct_name: &str,
+        struct_type: &StructTypeNode,
+        properties: &mut serde_yaml::Mapping,
+    ) {
+        let mut accessors: Vec<(String, Vec<String>, TypeDefinition)> = Vec::new();
+
+        for node in &self.nodes {
+            let method = match node {
+                GoAstNode::Method(method) => method,
+                _ => continue,
+            };
+            let receiver = method.receiver.as_ref().and_then(|r| self.named_type(r));
+            if receiver != Some(struct_name) {
+                continue;
+            }
+
+            // Getters take nothing and return the value; setters take the value
+            let (field_name, field_type) = if let Some(field_name) = method.name.strip_prefix("Get")
+            {
+                match (method.params.as_slice(), method.results.as_slice()) {
+                    ([], [result]) => (field_name, result.field_type.clone()),
+                    _ => continue,
+                }
+            } else if let Some(field_name) = method.name.strip_prefix("Set") {
+                match method.params.as_slice() {
+                    [param] if param.names.len() <= 1 => (field_name, param.field_type.clone()),
+                    _ => continue,
+                }
+            } else {
+                continue;
+            };
+
+            if !field_name.starts_with(|c: char| c.is_ascii_uppercase()) {
+                continue;
+            }
+
+            match accessors.iter_mut().find(|(name, _, _)| name == field_name) {
+                Some((_, methods, _)) => methods.push(method.name.clone()),
+                None => accessors.push((
+                    field_name.to_string(),
+                    vec![method.name.clone()],
+                    field_type,
+                )),
+            }
+        }
+
+        for (field_name, mut methods, field_type) in accessors {
+            let has_field = struct_type
+                .fields
+                .iter()
+                .any(|f| f.names.iter().any(|n| n == &field_name));
+            let key = serde_yaml::Value::String(lower_camel(&field_name));
+            if has_field || properties.contains_key(&key) {
+                continue;
+            }
+
+            methods.sort();
+            let mut schema = self.type_to_schema(&field_type);
+            schema.insert(
+                serde_yaml::Value::String("x-go-accessors".to_string()),
+                serde_yaml::Value::Sequence(
+                    methods.into_iter().map(serde_yaml::Value::String).collect(),
+                ),
+            );
+            properties.insert(key, serde_yaml::Value::Mapping(schema));
+        }
+    }
+
+    /// Apply the configured pointer strategy to a pointer field's schema
+    fn apply_pointer_strategy(&self, schema: &mut serde_yaml::Mapping) {
+        match self.options.pointer_strategy.unwrap_or_default() {
+            PointerStrategy::Omit => {}
+            PointerStrategy::Null => {
+                let key = serde_yaml::Value::String("default".to_string());
+                if !schema.contains_key(&key) {
+                    schema.insert(key, serde_yaml::Value::Null);
+                }
+            }
+            PointerStrategy::Both => {
+                schema.insert(
+                    serde_yaml::Value::String("x-go-null-setter".to_string()),
+                    serde_yaml::Value::Bool(true),
+                );
+            }
+            PointerStrategy::Presence => {
+                for key in ["x-go-null-setter", "x-go-presence"] {
+                    schema.insert(
+                        serde_yaml::Value::String(key.to_string()),
+                        serde_yaml::Value::Bool(true),
+                    );
+                }
+            }
+        }
+    }
+
+    /// Decide how a field's schema is produced, applying overrides and the any policy
+    fn field_schema(&self, struct_name: &str, field_name: &str, field: &FieldNode) -> FieldSchema {
+        let policy = match self.field_rule(struct_name, field_name, field) {
+            FieldRule::Schema(schema) => return FieldSchema::Override(schema.clone()),
+            FieldRule::GoType(go_type) => {
+                let mut schema = self.type_to_schema(&parse_type_text(go_type));
+                if let Some(description) = doc_description(&field.docs) {
+                    schema.insert(
+                        serde_yaml::Value::String("description".to_string()),
+                        serde_yaml::Value::String(description),
+                    );
+                }
+                return FieldSchema::Override(serde_yaml::Value::Mapping(schema));
+            }
+            FieldRule::Any(policy, _) => policy,
+            FieldRule::Derived => return FieldSchema::Derived,
+        };
+
+        match policy {
+            AnyPolicy::Object => {
+                let mut schema = free_form_schema();
+                if let Some(description) = doc_description(&field.docs) {
+                    schema.insert(
+                        serde_yaml::Value::String("description".to_string()),
+                        serde_yaml::Value::String(description),
+                    );
+                }
+                FieldSchema::Override(serde_yaml::Value::Mapping(schema))
+            }
+            AnyPolicy::Skip => FieldSchema::Skip,
+            AnyPolicy::Require => FieldSchema::Missing,
+        }
+    }
+
+    /// Find the rule deciding the schema of a struct field, in order of
+    /// precedence: `field_overrides`, then the any policy, then its Go type
+    fn field_rule<'a>(
+        &'a self,
+        struct_name: &str,
+        field_name: &str,
+        field: &FieldNode,
+    ) -> FieldRule<'a> {
+        let field_override = self
+            .options
+            .field_overrides
+            .get(&format!("{struct_name}.{field_name}"));
+
+        if let Some(schema) = field_override.and_then(|o| o.schema.as_ref()) {
+            return FieldRule::Schema(schema);
+        }
+        if let Some(go_type) = field_override.and_then(|o| o.go_type.as_deref()) {
+            return FieldRule::GoType(go_type);
+        }
+        if !self.is_any_type(&field.field_type) {
+            return FieldRule::Derived;
+        }
+        match (
+            field_override.and_then(|o| o.policy),
+            self.options.any_policy,
+        ) {
+            (Some(policy), _) => FieldRule::Any(policy, "the field override"),
+            (None, Some(policy)) => FieldRule::Any(policy, "any_policy"),
+            (None, None) => FieldRule::Any(AnyPolicy::default(), "the default"),
+        }
+    }
+
+    /// Check whether a type can hold arbitrary JSON
+    fn is_any_type(&self, type_def: &TypeDefinition) -> bool {
+        match type_def {
+            TypeDefinition::Interface(interface) => {
+                interface.methods.is_empty() && interface.embedded.is_empty()
+            }
+            TypeDefinition::Pointer(inner) => self.is_any_type(inner),
+            TypeDefinition::Basic(type_name) if type_name == "any" => true,
+            TypeDefinition::Basic(type_name) => match type_name.split_once('.') {
+                Some((qualifier, "RawMessage")) => {
+                    self.imports.get(qualifier).map(String::as_str) == Some("encoding/json")
+                }
+                _ => false,
+            },
+            _ => false,
+        }
+    }
+
+    /// Get errors for fields that cannot be generated under the configured policies
+    pub fn errors(&self) -> Vec<String> {
+        self.diagnostics()
+            .into_iter()
+            .map(|diagnostic| diagnostic.message)
+            .collect()
+    }
+
+    /// Get the errors of `errors` with the position of their field
+    ///
+    /// Every field of the file is checked, so all errors are reported at once.
+    pub fn diagnostics(&self) -> Vec<Diagnostic> {
+        let mut errors = Vec::new();
+        let error = |field: &FieldNode, message: String| Diagnostic {
+            position: field.position.clone(),
+            message,
+        };
+
+        for node in &self.nodes {
+            let (name, struct_type) = match node {
+                GoAstNode::TypeDecl(TypeDeclNode {
+                    name,
+                    type_def: TypeDefinition::Struct(struct_type),
+                    ..
+                }) if self.marshaler_interface(name).is_none() => (name, struct_type),
+                _ => continue,
+            };
+
+            for field in &struct_type.fields {
+                if field.struct_tag().is_ignored("json") || self.field_is_inline(field) {
+                    continue;
+                }
+                if self.options.unexported_fields == Some(UnexportedPolicy::Fail)
+                    && !self.is_excluded(name)
+                {
+                    for field_name in self.unexported_field_names(name, field) {
+                        errors.push(error(field, format!(
+                            "Field {name}.{field_name} is unexported; tag it json:\"-\", exclude it or set unexported_fields to skip or include"
+                        )));
+                    }
+                }
+                for field_name in &field.names {
+                    if let FieldSchema::Missing = self.field_schema(name, field_name, field) {
+                        errors.push(error(field, format!(
+                            "Field {name}.{field_name} can hold arbitrary JSON and needs a schema in field_overrides"
+                        )));
+                    }
+                }
+            }
+        }
+
+        errors
+    }
+
+    /// Get the unexported names of a field that would otherwise be generated
+    fn unexported_field_names<'a>(&self, struct_name: &str, field: &'a FieldNode) -> Vec<&'a str> {
+        if field.struct_tag().is_ignored("json")
+            || Directives::of(&field.docs).skip
+            || self.field_is_inline(field)
+        {
+            return Vec::new();
+        }
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        field
+            .names
+            .iter()
+            .filter(|name| !is_exported(name))
+            .filter(|name| !self.options.excludes_field(package, struct_name, name))
+            .filter(|name| {
+                self.options
+                    .flatten_prefix(package, struct_name, name)
+                    .is_none()
+            })
+            .map(String::as_str)
+            .collect()
+    }
+
+    /// Check whether a field's contents are flattened into its parent
+    fn field_is_inline(&self, field: &FieldNode) -> bool {
+        let tag = field.struct_tag();
+
+        if tag.has_option("yaml", "inline") || tag.has_option("json", "inline") {
+            return true;
+        }
+
+        field.embedded && tag.name("json").is_none() && tag.name("yaml").is_none()
+    }
+
+    /// Resolve the serialized name of a field
+    ///
+    /// A `gensonnet` tag, a `gensonnet:rename` comment or a `field_names`
+    /// rule names the field explicitly; other names follow the
+    /// `field_naming` policy.
+    fn wire_name(&self, struct_name: &str, field_name: &str, field: &FieldNode) -> String {
+        if let Some(name) = self.explicit_name(struct_name, field_name, field) {
+            return name;
+        }
+        let tag = field.struct_tag();
+        let name = tag
+            .name("json")
+            .or_else(|| tag.name("yaml"))
+            .unwrap_or(field_name);
+        self.options.field_naming.unwrap_or_default().apply(name)
+    }
+
+    /// Get the name given to a field by its `gensonnet` tag, its
+    /// `gensonnet:rename` comment or else by a `field_names` rule
+    fn explicit_name(
+        &self,
+        struct_name: &str,
+        field_name: &str,
+        field: &FieldNode,
+    ) -> Option<String> {
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        field
+            .struct_tag()
+            .name("gensonnet")
+            .map(str::to_string)
+            .or_else(|| Directives::of(&field.docs).rename)
+            .or_else(|| {
+                self.options
+                    .field_name(package, struct_name, field_name)
+                    .map(str::to_string)
+            })
+    }
+
+    /// Get the magic comments of a type declared in this file
+    fn type_directives(&self, type_name: &str) -> Directives {
+        self.nodes
+            .iter()
+            .find_map(|node| match node {
+                GoAstNode::TypeDecl(type_decl) if type_decl.name == type_name => {
+                    Some(Directives::of(&type_decl.docs))
+                }
+                _ => None,
+            })
+            .unwrap_or_default()
+    }
+
+    /// Check whether a field follows the status convention
+    ///
+    /// A `Status` field of a struct type named `...Status` holds the
+    /// observed state, which the server writes, as in Kubernetes resources.
+    fn is_status_field(&self, name: &str, field: &FieldNode) -> bool {
+        name == "Status"
+            && self.named_type(&field.field_type).is_some_and(|type_name| {
+                type_name.ends_with("Status")
+                    && matches!(
+                        self.type_defs.get(type_name),
+                        Some(TypeDefinition::Struct(_))
+                    )
+            })
+    }
+
+    /// Get the name of a (possibly pointer-wrapped) named type
+    #[allow(clippy::only_used_in_recursion)]
+    fn named_type<'a>(&self, type_def: &'a TypeDefinition) -> Option<&'a str> {
+        match type_def {
+            TypeDefinition::Basic(type_name) => Some(type_name.as_str()),
+            TypeDefinition::Pointer(inner) => self.named_type(inner),
+            _ => None,
+        }
+    }
+
+    /// Convert interface type to schema
+    ///
+    /// The methods are recorded so `resolve_unions` can find the types of the
+    /// module implementing the interface.
+    fn interface_to_schema(
+        &self,
+        interface_type: &InterfaceTypeNode,
+        docs: &[String],
+    ) -> serde_yaml::Value {
+        let mut schema = serde_yaml::Mapping::new();
+        schema.insert(
+            serde_yaml::Value::String("type".to_string()),
+            serde_yaml::Value::String("object".to_string()),
+        );
+
+        let mut methods: Vec<&str> = interface_type
+            .methods
+            .iter()
+            .map(|m| m.name.as_str())
+            .collect();
+        methods.sort();
+        let strings = |values: Vec<&str>| {
+            serde_yaml::Value::Sequence(
+                values
+                    .into_iter()
+                    .map(|v| serde_yaml::Value::String(v.to_string()))
+                    .collect(),
+            )
+        };
+
+        let mut interface = serde_yaml::Mapping::new();
+        interface.insert(
+            serde_yaml::Value::String("methods".to_string()),
+            strings(methods),
+        );
+        if !interface_type.embedded.is_empty() {
+            interface.insert(
+                serde_yaml::Value::String("embeds".to_string()),
+                strings(interface_type.embedded.iter().map(String::as_str).collect()),
+            );
+        }
+        if let Some(discriminator) = marker_values(docs, DISCRIMINATOR_MARKER).first() {
+            interface.insert(
+                serde_yaml::Value::String("discriminator".to_string()),
+                serde_yaml::Value::String(discriminator.clone()),
+            );
+        }
+        if self.options.services {
+            interface.insert(
+                serde_yaml::Value::String("signatures".to_string()),
+                self.method_signatures(interface_type),
+            );
+        }
+        schema.insert(
+            serde_yaml::Value::String("x-go-interface".to_string()),
+            serde_yaml::Value::Mapping(interface),
+        );
+
+        if self.options.function_naming == Some(NamingPolicy::SnakeCase) {
+            schema.insert(
+                serde_yaml::Value::String("x-go-function-naming".to_string()),
+                serde_yaml::Value::String("snake_case".to_string()),
+            );
+        }
+
+        serde_yaml::Value::Mapping(schema)
+    }
+
+    /// Describe the request and response of the exported methods of an
+    /// interface, for the `services` option
+    ///
+    /// `context.Context` parameters and `error` results are left out. A
+    /// request of a single struct (a gRPC request message) is that struct;
+    /// other requests are objects of the parameters. Responses of several
+    /// results are objects of the results. `params` and `results` list every
+    /// parameter and result with its Go type, for the catalog of the methods.
+    fn method_signatures(&self, interface_type: &InterfaceTypeNode) -> serde_yaml::Value {
+        let values = |fields: &[FieldNode], skipped: &str, unnamed: &str| {
+            let mut values = Vec::new();
+            for field in fields {
+                if matches!(&field.field_type, TypeDefinition::Basic(name) if name == skipped) {
+                    continue;
+                }
+                let schema = self.type_to_schema(&field.field_type);
+                if field.names.is_empty() {
+                    values.push((format!("{unnamed}{}", values.len()), schema));
+                    continue;
+                }
+                for name in &field.names {
+                    values.push((name.clone(), schema.clone()));
+                }
+            }
+            values
+        };
+        let go_types = |fields: &[FieldNode]| {
+            let mut types = Vec::new();
+            for field in fields {
+                let go_type = go_type_string(&field.field_type);
+                if field.names.is_empty() {
+                    let mut value = serde_yaml::Mapping::new();
+                    value.insert("type".into(), go_type.as_str().into());
+                    types.push(serde_yaml::Value::Mapping(value));
+                }
+                for name in &field.names {
+                    let mut value = serde_yaml::Mapping::new();
+                    value.insert("name".into(), name.as_str().into());
+                    value.insert("type".into(), go_type.as_str().into());
+                    types.push(serde_yaml::Value::Mapping(value));
+                }
+            }
+            serde_yaml::Value::Sequence(types)
+        };
+        let object = |values: Vec<(String, serde_yaml::Mapping)>| {
+            let mut properties = serde_yaml::Mapping::new();
+            for (name, schema) in values {
+                properties.insert(name.into(), schema.into());
+            }
+            let mut schema = serde_yaml::Mapping::new();
+            schema.insert("type".into(), "object".into());
+            schema.insert("properties".into(), properties.into());
+            serde_yaml::Value::Mapping(schema)
+        };
+
+        let mut signatures = Vec::new();
+        for method in &interface_type.methods {
+            if !method.name.starts_with(|c: char| c.is_ascii_uppercase()) {
+                continue;
+            }
+            let mut params = values(&method.params, "context.Context", "arg");
+            let request = match params.as_slice() {
+                [(_, schema)]
+                    if schema.contains_key(STRUCT_REF_KEY) || schema.contains_key("x-go-ref") =>
+                {
+                    let mut schema = params.remove(0).1;
+                    schema.remove("nullable");
+                    serde_yaml::Value::Mapping(schema)
+                }
+                _ => object(params),
+            };
+            let mut results = values(&method.results, "error", "result");
+            let response = match results.len() {
+                0 => serde_yaml::Value::Null,
+                1 => serde_yaml::Value::Mapping(results.remove(0).1),
+                _ => object(results),
+            };
+
+            let mut signature = serde_yaml::Mapping::new();
+            signature.insert("name".into(), method.name.as_str().into());
+            if let Some(description) = doc_description(&method.docs) {
+                signature.insert("description".into(), description.into());
+            }
+            signature.insert("params".into(), go_types(&method.params));
+            signature.insert("results".into(), go_types(&method.results));
+            signature.insert("request".into(), request);
+            signature.insert("response".into(), response);
+            signatures.push(serde_yaml::Value::Mapping(signature));
+        }
+        serde_yaml::Value::Sequence(signatures)
+    }
+
+    /// Convert field to schema
+    fn field_to_schema(&self, field: &FieldNode) -> serde_yaml::Value {
+        let mut schema = self.type_to_schema(&field.field_type);
+
+        // Add description from docs
+        if let Some(description) = doc_description(&field.docs) {
+            schema.insert(
+                serde_yaml::Value::String("description".to_string()),
+                serde_yaml::Value::String(description),
+            );
+        }
+
+        // Fields replacing removed ones, named in tombstone messages
+        let replaces = marker_values(&field.docs, REPLACES_MARKER);
+        if !replaces.is_empty() {
+            schema.insert(
+                serde_yaml::Value::String("x-go-replaces".to_string()),
+                serde_yaml::Value::Sequence(
+                    replaces
+                        .into_iter()
+                        .map(serde_yaml::Value::String)
+                        .collect(),
+                ),
+            );
+        }
+
+        serde_yaml::Value::Mapping(schema)
+    }
+
+    /// Find the rule deciding the schema of a type, in order of precedence
+    ///
+    /// `type_to_schema` and `trace_mapping` both follow it.
+    fn resolve_type<'a>(&'a self, type_def: &'a TypeDefinition) -> TypeRule<'a> {
+        if let TypeDefinition::Pointer(inner) = type_def {
+            return TypeRule::Pointer(inner);
+        }
+        if let Some(unwrapped) = self.unwrap_type(type_def) {
+            return TypeRule::Unwrapped(unwrapped);
+        }
+        if let TypeDefinition::Basic(type_name) = type_def {
+            let package = self.package_info.as_ref().map(|p| p.name.as_str());
+            if self
+                .options
+                .find_unwrap_rule(type_name, &self.imports, package)
+                == Some(UnwrapRule::Map)
+            {
+                return TypeRule::FreeForm(type_name);
+            }
+        }
+
+        let Some(type_name) = self.named_type(type_def) else {
+            return TypeRule::GoType;
+        };
+        if self.is_excluded(type_name) {
+            return TypeRule::Excluded(type_name);
+        }
+        if let Some(mapping) = self.find_type_mapping(type_name) {
+            return TypeRule::TypeMapping(type_name, mapping.clone());
+        }
+        if let Some(mapping) = self.builtin_type_mapping(type_name) {
+            return TypeRule::Builtin(type_name, mapping);
+        }
+        if let Some(underlying) = self.primitive_type(type_name) {
+            return TypeRule::Primitive(type_name, underlying);
+        }
+        if let Some(definition) = self.collection_definition(type_name) {
+            return TypeRule::Collection(type_name, definition);
+        }
+        TypeRule::GoType
+    }
+
+    /// Record the rules `resolve_type` follows for a type
+    fn trace_type(&self, type_def: &TypeDefinition, steps: &mut Vec<(MappingRule, String)>) {
+        let go_type = go_type_string(type_def);
+        match self.resolve_type(type_def) {
+            TypeRule::Pointer(inner) => {
+                let outcome = match self.options.pointer_strategy {
+                    Some(_) => "nullable, with the schema of",
+                    None => "has the schema of",
+                };
+                steps.push((
+                    MappingRule::Pointer,
+                    format!("{go_type} {outcome} {}", go_type_string(inner)),
+                ));
+                self.trace_type(inner, steps);
+            }
+            TypeRule::Unwrapped(unwrapped) => {
+                steps.push((
+                    MappingRule::UnwrapRule,
+                    format!("{go_type} unwraps to {}", go_type_string(&unwrapped)),
+                ));
+                self.trace_type(&unwrapped, steps);
+            }
+            TypeRule::FreeForm(type_name) => steps.push((
+                MappingRule::UnwrapRule,
+                format!("{type_name} unwraps to a free-form map"),
+            )),
+            TypeRule::Excluded(type_name) => steps.push((
+                MappingRule::ExcludedType,
+                format!("{type_name} is excluded and becomes a free-form object"),
+            )),
+            TypeRule::TypeMapping(type_name, _) => {
+                let over = match self.builtin_type_mapping(type_name) {
+                    Some(_) => ", over its built-in mapping",
+                    None => "",
+                };
+                steps.push((
+                    MappingRule::TypeMapping,
+                    format!("type_mappings has an entry for {type_name}{over}"),
+                ));
+            }
+            TypeRule::Builtin(type_name, _) => steps.push((
+                MappingRule::Builtin,
+                format!("{type_name} has a built-in mapping"),
+            )),
+            TypeRule::Primitive(type_name, underlying) => {
+                steps.push((
+                    MappingRule::NamedType,
+                    format!("{type_name} is defined as {underlying}"),
+                ));
+                self.trace_type(&TypeDefinition::Basic(underlying.to_string()), steps);
+            }
+            TypeRule::Collection(type_name, definition) => {
+                steps.push((
+                    MappingRule::NamedType,
+                    format!("{type_name} is defined as {}", go_type_string(definition)),
+                ));
+                self.trace_type(definition, steps);
+            }
+            TypeRule::GoType => {
+                steps.push((MappingRule::GoType, format!("derived from {go_type}")))
+            }
+        }
+    }
+
+    /// Convert a type to schema, applying configured type mappings
+    fn type_to_schema(&self, type_def: &TypeDefinition) -> serde_yaml::Mapping {
+        match self.resolve_type(type_def) {
+            // Pointers have the schema of what they point to; with a pointer
+            // strategy configured, null is a value of its own
+            TypeRule::Pointer(inner) => {
+                let mut schema = self.type_to_schema(inner);
+                if self.options.pointer_strategy.is_some() {
+                    schema.insert(
+                        serde_yaml::Value::String("nullable".to_string()),
+                        serde_yaml::Value::Bool(true),
+                    );
+                }
+                return schema;
+            }
+            // Generic wrappers have the schema of the types they hold
+            TypeRule::Unwrapped(unwrapped) => return self.type_to_schema(&unwrapped),
+            TypeRule::FreeForm(_) => return free_form_schema(),
+            TypeRule::Excluded(type_name) => {
+                let mut schema = free_form_schema();
+                schema.insert(
+                    serde_yaml::Value::String("x-go-excluded".to_string()),
+                    serde_yaml::Value::String(type_name.to_string()),
+                );
+                return schema;
+            }
+            TypeRule::TypeMapping(_, mapping) | TypeRule::Builtin(_, mapping) => {
+                let mut schema = mapping.to_schema();
+                if let (Some("date-time"), Some(placeholder)) =
+                    (mapping.format.as_deref(), &self.options.now_placeholder)
+                {
+                    schema.insert(
+                        serde_yaml::Value::String("x-go-now-placeholder".to_string()),
+                        serde_yaml::Value::String(placeholder.clone()),
+                    );
+                }
+                return schema;
+            }
+            // Named primitives and aliases have the schema of the primitive
+            // and keep their name for documentation and validation hooks
+            TypeRule::Primitive(type_name, underlying) => {
+                let mut schema =
+                    self.type_to_schema(&TypeDefinition::Basic(underlying.to_string()));
+                schema.insert(PRIMITIVE_TYPE_KEY.into(), type_name.into());
+                return schema;
+            }
+            // So do named slices and maps (`type Users []*User`), unless
+            // their elements hold them again
+            TypeRule::Collection(type_name, definition) => {
+                let mut schema = self.type_to_schema(definition);
+                schema.insert(PRIMITIVE_TYPE_KEY.into(), type_name.into());
+                return schema;
+            }
+            TypeRule::GoType => {}
+        }
+
+        let mut schema = serde_yaml::Mapping::new();
+        schema.insert(
+            serde_yaml::Value::String("type".to_string()),
+            serde_yaml::Value::String(self.type_def_to_schema_type(type_def)),
+        );
+
+        // Named primitives of other files of the package are looked up by
+        // `resolve_primitive_types`
+        if let TypeDefinition::Basic(type_name) = type_def {
+            if is_identifier(type_name)
+                && !is_primitive_type(type_name)
+                && !matches!(type_name.as_str(), "any" | "error")
+                && !self.type_defs.contains_key(type_name)
+            {
+                schema.insert(LOCAL_TYPE_KEY.into(), type_name.as_str().into());
+            }
+        }
+
+        // Values of interfaces declared here are built with the union helpers
+        if let Some(type_name) = self.named_type(type_def) {
+            if let Some(definition) = self.type_defs.get(type_name) {
+                if matches!(definition, TypeDefinition::Interface(_))
+                    && !self.is_any_type(definition)
+                {
+                    schema.insert(
+                        serde_yaml::Value::String("x-go-interface-ref".to_string()),
+                        serde_yaml::Value::String(type_name.to_string()),
+                    );
+                }
+                // Schemas in other formats reference the struct
+                if matches!(definition, TypeDefinition::Struct(_)) {
+                    schema.insert(STRUCT_REF_KEY.into(), type_name.into());
+                }
+            }
+
+            // Types of other packages are looked up in the module by `resolve_references`
+            let qualified = type_name
+                .split_once('.')
+                .filter(|(_, name)| !name.contains('['));
+            if let Some((qualifier, name)) = qualified {
+                if let Some(package) = self.imports.get(qualifier) {
+                    let mut reference = serde_yaml::Mapping::new();
+                    reference.insert("package".into(), package.as_str().into());
+                    reference.insert("type".into(), name.into());
+                    if let Some((library, path)) = self.options.external_library(package, name) {
+                        reference.insert("library".into(), library.into());
+                        reference.insert(
+                            "path".into(),
+                            path.into_iter().map(serde_yaml::Value::from).collect(),
+                        );
+                    }
+                    schema.insert(
+                        serde_yaml::Value::String("x-go-ref".to_string()),
+                        serde_yaml::Value::Mapping(reference),
+                    );
+                }
+            }
+        }
+
+        match type_def {
+            TypeDefinition::Array(element) | TypeDefinition::Slice(element) => {
+                let condition = self.condition_kind(element);
+                let items = match condition {
+                    Some(ConditionKind::Metav1) => condition_schema(),
+                    _ => self.type_to_schema(element),
+                };
+                schema.insert(
+                    serde_yaml::Value::String("items".to_string()),
+                    serde_yaml::Value::Mapping(items),
+                );
+
+                // Lets the generator add withCondition/hasCondition helpers
+                if condition.is_some() {
+                    schema.insert(
+                        serde_yaml::Value::String("x-go-conditions".to_string()),
+                        serde_yaml::Value::Bool(true),
+                    );
+                }
+            }
+            TypeDefinition::Map(key, value) => {
+                schema.insert(
+                    serde_yaml::Value::String("additionalProperties".to_string()),
+                    serde_yaml::Value::Mapping(self.type_to_schema(value)),
+                );
+
+                // encoding/json writes integer keys in decimal; the generator
+                // checks and converts keys that are not plain strings
+                let key = self.type_to_schema(key);
+                let plain_string =
+                    key.len() == 1 && key.get("type").and_then(|t| t.as_str()) == Some("string");
+                if !plain_string {
+                    schema.insert(MAP_KEY_KEY.into(), serde_yaml::Value::Mapping(key));
+                }
+            }
+            _ => {}
+        }
+
+        schema
+    }
+
+    /// Get the type a generic wrapper of `unwrap_types` stands for
+    ///
+    /// `None` for other types and for wrappers without the type arguments
+    /// their rule needs; `type_to_schema` gives maps without them, such as
+    /// `sync.Map`, a free-form schema.
+    fn unwrap_type(&self, type_def: &TypeDefinition) -> Option<TypeDefinition> {
+        let TypeDefinition::Basic(type_name) = type_def else {
+            return None;
+        };
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        let rule = self
+            .options
+            .find_unwrap_rule(type_name, &self.imports, package)?;
+        let mut args = type_arguments(type_name);
+        let unwrapped = match rule {
+            UnwrapRule::Inner => args.into_iter().next()?,
+            UnwrapRule::Optional => TypeDefinition::Pointer(Box::new(args.into_iter().next()?)),
+            UnwrapRule::List => TypeDefinition::Slice(Box::new(args.into_iter().next()?)),
+            UnwrapRule::Map => {
+                let value = args.pop()?;
+                let key = match args.pop() {
+                    Some(key) => key,
+                    None => TypeDefinition::Basic("string".to_string()),
+                };
+                TypeDefinition::Map(Box::new(key), Box::new(value))
+            }
+            UnwrapRule::Keep => return None,
+        };
+        Some(unwrapped)
+    }
+
+    /// Find the configured mapping for a type referenced in this file
+    fn find_type_mapping(&self, type_name: &str) -> Option<&TypeMapping> {
+        let package = self.package_info.as_ref().map(|p| p.name.as_str());
+        self.options
+            .find_type_mapping(type_name, &self.imports, package)
+    }
+
+    /// Check whether a type is a Kubernetes-style status condition
+    ///
+    /// Besides `metav1.Condition`, any struct declared in this file with
+    /// `Type` and `Status` fields is treated as a condition.
+    fn condition_kind(&self, type_def: &TypeDefinition) -> Option<ConditionKind> {
+        let type_name = self.named_type(type_def)?;
+
+        if let Some((qualifier, name)) = type_name.split_once('.') {
+            let import_path = self.imports.get(qualifier)?;
+            return (import_path == METAV1_IMPORT_PATH && name == "Condition")
+                .then_some(ConditionKind::Metav1);
+        }
+
+        match self.type_defs.get(type_name) {
+            Some(TypeDefinition::Struct(condition)) => {
+                let has_field = |name: &str| {
+                    condition
+                        .fields
+                        .iter()
+                        .any(|f| f.names.iter().any(|n| n == name))
+                };
+                (has_field("Type") && has_field("Status")).then_some(ConditionKind::Local)
+            }
+            _ => None,
+        }
+    }
+
+    /// Get the built-in mapping for a qualified standard library type
+    fn builtin_type_mapping(&self, type_name: &str) -> Option<TypeMapping> {
+        let (qualifier, name) = type_name.split_once('.')?;
+        let import_path = self
+            .imports
+            .get(qualifier)
+            .map(String::as_str)
+            .unwrap_or(qualifier);
+
+        builtin_type_mapping(import_path, name)
+    }
+
+    /// Convert type definition to schema type
+    fn type_def_to_schema_type(&self, type_def: &TypeDefinition) -> String {
+        match type_def {
+            TypeDefinition::Basic(basic_type) => match basic_type.as_str() {
+                "string" => "string".to_string(),
+                "int" | "int8" | "int16" | "int32" | "int64" => "integer".to_string(),
+                "uint" | "uint8" | "uint16" | "uint32" | "uint64" => "integer".to_string(),
+                "float32" | "float64" => "number".to_string(),
+                "bool" => "boolean".to_string(),
+                other => self.local_schema_type(other).to_string(),
+            },
+            TypeDefinition::Array(_) => "array".to_string(),
+            TypeDefinition::Slice(_) => "array".to_string(),
+            TypeDefinition::Map(_, _) => "object".to_string(),
+            TypeDefinition::Pointer(_) => "object".to_string(),
+            TypeDefinition::Struct(_) => "object".to_string(),
+            TypeDefinition::Interface(_) => "object".to_string(),
+            TypeDefinition::Alias(_) => "string".to_string(),
+        }
+    }
+
+    /// Get the schema type of a type declared in this file, from the shape of
+    /// its definition
+    ///
+    /// Other names are strings until `resolve_primitive_types` looks them up
+    /// in the rest of the package.
+    fn local_schema_type(&self, type_name: &str) -> &'static str {
+        match self.type_defs.get(type_name) {
+            Some(TypeDefinition::Struct(_))
+            | Some(TypeDefinition::Map(_, _))
+            | Some(TypeDefinition::Interface(_)) => "object",
+            Some(TypeDefinition::Slice(_)) | Some(TypeDefinition::Array(_)) => "array",
+            _ => "string",
+        }
+    }
+
+    /// Check if field is optional
+    fn field_is_optional(&self, field: &FieldNode) -> bool {
+        self.field_is_pointer(field) || self.field_omits_empty(field)
+    }
+
+    /// Check for a pointer field, or a wrapper unwrapped as one
+    fn field_is_pointer(&self, field: &FieldNode) -> bool {
+        matches!(field.field_type, TypeDefinition::Pointer(_))
+            || matches!(
+                self.unwrap_type(&field.field_type),
+                Some(TypeDefinition::Pointer(_))
+            )
+    }
+
+    /// Check if a field is tagged `omitempty`
+    fn field_omits_empty(&self, field: &FieldNode) -> bool {
+        let tag = field.struct_tag();
+        tag.has_option("json", "omitempty") || tag.has_option("yaml", "omitempty")
+    }
+}
+
+/// Doc comment marker that leaves a type out of the generated output
+const EXCLUDE_MARKER: &str = "+gensonnet:exclude";
+
+/// Check whether doc comments contain a `+marker` line
+fn has_marker(docs: &[String], marker: &str) -> bool {
+    docs.iter().any(|line| line.trim() == marker)
+}
+
+/// Prefix of the magic comments steering the generation of a type or field
+///
+/// `// gensonnet:skip` leaves it out, `// gensonnet:rename=name` names a
+/// field, `// gensonnet:hidden` emits fields hidden (`::`) and
+/// `// gensonnet:readonly` marks fields `readOnly`, and
+/// `// gensonnet:labels` and `// gensonnet:annotations` give string maps
+/// the helpers of Kubernetes labels and annotations. On a type, `hidden`
+/// and `readonly` apply to each of its fields. Comments are read above the
+/// declaration or at the end of a field's line.
+const DIRECTIVE_PREFIX: &str = "gensonnet:";
+
+/// Schema key of fields emitted hidden
+const HIDDEN_KEY: &str = "x-go-hidden";
+
+/// Magic comments of a type or field
+#[derive(Debug, Default, PartialEq)]
+struct Directives {
+    skip: bool,
+    rename: Option<String>,
+    hidden: bool,
+    readonly: bool,
+
+    /// `labels` or `annotations`
+    metadata_map: Option<&'static str>,
+
+    /// Comments with the prefix that are not directives
+    unknown: Vec<String>,
+}
+
+impl Directives {
+    fn of(docs: &[String]) -> Self {
+        let mut directives = Directives::default();
+        for line in docs {
+            let Some(directive) = line.trim().strip_prefix(DIRECTIVE_PREFIX) else {
+                continue;
+            };
+            match directive.split_once('=') {
+                None if directive == "skip" => directives.skip = true,
+                None if directive == "hidden" => directives.hidden = true,
+                None if directive == "readonly" => directives.readonly = true,
+                None if directive == "labels" => directives.metadata_map = Some("labels"),
+                None if directive == "annotations" => directives.metadata_map = Some("annotations"),
+                Some(("rename", name)) if !name.trim().is_empty() => {
+                    directives.rename = Some(name.trim().to_string())
+                }
+                _ => directives.unknown.push(line.trim().to_string()),
+            }
+        }
+        directives
+    }
+}
+
+/// Check whether a schema is of a `map[string]string`
+fn is_string_map(schema: &serde_yaml::Mapping) -> bool {
+    schema.get("type").and_then(|t| t.as_str()) == Some("object")
+        && schema.get(MAP_KEY_KEY).is_none()
+        && schema
+            .get("additionalProperties")
+            .and_then(|v| v.get("type"))
+            .and_then(|t| t.as_str())
+            == Some("string")
+}
+
+/// Doc comment marker assigning a type to index categories
+const CATEGORY_MARKER: &str = "+gensonnet:category";
+
+/// Replace the Go field names in a property's cross-field rules by wire names
+fn resolve_field_rules(property: &mut serde_yaml::Value, wire_names: &HashMap<&str, String>) {
+    let rules = match property
+        .get_mut(FIELD_RULES_KEY)
+        .and_then(|r| r.as_sequence_mut())
+    {
+        Some(rules) => rules,
+        None => return,
+    };
+
+    for rule in rules {
+        let fields = match rule.get_mut("fields").and_then(|f| f.as_sequence_mut()) {
+            Some(fields) => fields,
+            None => continue,
+        };
+        for field in fields {
+            let wire_name = field.as_str().and_then(|name| wire_names.get(name));
+            if let Some(wire_name) = wire_name {
+                *field = serde_yaml::Value::String(wire_name.clone());
+            }
+        }
     }
+}
 
-    /// Extract documentation comments for a node
-    fn extract_documentation(&self, node: &Node, content: &str) -> Vec<String> {
-        let mut docs = Vec::new();
-        let node_start = node.start_byte();
+/// Doc comment marker naming the discriminator field of an interface's values
+const DISCRIMINATOR_MARKER: &str = "+gensonnet:discriminator";
 
-        // Look for comments before the node
-        let lines: Vec<&str> = content.lines().collect();
-        let mut current_byte = 0;
+/// Doc comment marker naming the removed fields a field replaces
+const REPLACES_MARKER: &str = "+gensonnet:replaces";
 
-        for line in lines.iter() {
-            let line_bytes = line.len() + 1; // +1 for newline
-            let next_byte = current_byte + line_bytes;
+/// Collect the comma-separated values of `+marker=value` lines
+fn marker_values(docs: &[String], marker: &str) -> Vec<String> {
+    docs.iter()
+        .filter_map(|line| line.trim().strip_prefix(marker)?.strip_prefix('='))
+        .flat_map(|values| values.split(','))
+        .map(|value| value.trim().to_string())
+        .filter(|value| !value.is_empty())
+        .collect()
+}
 
-            if next_byte > node_start {
-                break;
-            }
+/// Get the deprecation notice of doc comments
+///
+/// Follows the Go convention: a paragraph starting with `Deprecated:`, whose
+/// text is returned with the lines joined.
+fn deprecation(docs: &[String]) -> Option<String> {
+    let start = docs
+        .iter()
+        .position(|line| line.trim().starts_with("Deprecated:"))?;
+    let lines: Vec<&str> = docs[start..]
+        .iter()
+        .map(|line| line.trim())
+        .take_while(|line| !line.is_empty())
+        .collect();
+    let message = lines.join(" ");
+    let message = message["Deprecated:".len()..].trim();
+    Some(if message.is_empty() {
+        "deprecated".to_string()
+    } else {
+        message.to_string()
+    })
+}
 
-            let trimmed = line.trim();
-            if trimmed.starts_with("//") && !trimmed.starts_with("//go:") {
-                docs.push(trimmed[2..].trim().to_string());
-            }
+/// Record the deprecation notice of doc comments on a schema
+fn mark_deprecated(schema: &mut serde_yaml::Mapping, docs: &[String]) {
+    if let Some(message) = deprecation(docs) {
+        schema.insert(
+            serde_yaml::Value::String("x-go-deprecated".to_string()),
+            serde_yaml::Value::String(message),
+        );
+    }
+}
 
-            current_byte = next_byte;
-        }
+/// Get the first sentence of a type's documentation
+fn doc_summary(docs: &[String]) -> Option<String> {
+    let description = doc_description(docs)?;
+    let summary = match description.find(". ") {
+        Some(end) => &description[..=end],
+        None => description.as_str(),
+    };
+    Some(summary.to_string())
+}
 
-        docs
+/// Build a description from doc comments, leaving out `+marker` lines and
+/// magic comments
+fn doc_description(docs: &[String]) -> Option<String> {
+    let lines: Vec<&str> = docs
+        .iter()
+        .map(|line| line.trim())
+        .filter(|line| {
+            !line.is_empty() && !line.starts_with('+') && !line.starts_with(DIRECTIVE_PREFIX)
+        })
+        .collect();
+
+    if lines.is_empty() {
+        None
+    } else {
+        Some(lines.join(" "))
     }
+}
 
-    /// Get text content of a node
-    fn get_node_text(&self, node: Node, content: &str) -> String {
-        let start = node.start_byte();
-        let end = node.end_byte();
-        content[start..end].to_string()
+/// Import path of the Kubernetes `metav1` package
+const METAV1_IMPORT_PATH: &str = "k8s.io/apimachinery/pkg/apis/meta/v1";
+
+/// Comment marker naming the API group of the Kubernetes types of a package
+const GROUP_NAME_MARKER: &str = "+groupName";
+
+/// Check whether a package name is a Kubernetes API version
+pub(super) fn is_api_version(name: &str) -> bool {
+    let Some(rest) = name.strip_prefix('v') else {
+        return false;
+    };
+    let digits = rest.len() - rest.trim_start_matches(|c: char| c.is_ascii_digit()).len();
+    if digits == 0 {
+        return false;
     }
+    let rest = &rest[digits..];
+    rest.is_empty()
+        || ["alpha", "beta"].iter().any(|stage| {
+            rest.strip_prefix(stage)
+                .is_some_and(|n| !n.is_empty() && n.chars().all(|c| c.is_ascii_digit()))
+        })
+}
 
-    /// Convert tree-sitter node to position
-    fn node_to_position(&self, node: Node, file_path: &Path) -> Position {
-        Position {
-            file: file_path.to_path_buf(),
-            line: node.start_position().row + 1,
-            column: node.start_position().column + 1,
-            offset: node.start_byte(),
+/// Where a condition type is defined
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum ConditionKind {
+    /// `metav1.Condition`
+    Metav1,
+    /// A structurally similar type declared in the parsed file
+    Local,
+}
+
+/// Schema of `metav1.Condition`
+fn condition_schema() -> serde_yaml::Mapping {
+    let property = |schema_type: &str, format: Option<&str>| {
+        let mut property = serde_yaml::Mapping::new();
+        property.insert(
+            serde_yaml::Value::String("type".to_string()),
+            serde_yaml::Value::String(schema_type.to_string()),
+        );
+        if let Some(format) = format {
+            property.insert(
+                serde_yaml::Value::String("format".to_string()),
+                serde_yaml::Value::String(format.to_string()),
+            );
         }
+        serde_yaml::Value::Mapping(property)
+    };
+
+    let mut properties = serde_yaml::Mapping::new();
+    for (name, schema) in [
+        ("type", property("string", None)),
+        ("status", property("string", None)),
+        ("observedGeneration", property("integer", None)),
+        ("lastTransitionTime", property("string", Some("date-time"))),
+        ("reason", property("string", None)),
+        ("message", property("string", None)),
+    ] {
+        properties.insert(serde_yaml::Value::String(name.to_string()), schema);
     }
 
-    /// Get all parsed nodes
-    pub fn get_nodes(&self) -> &[GoAstNode] {
-        &self.nodes
-    }
+    let mut schema = serde_yaml::Mapping::new();
+    schema.insert(
+        serde_yaml::Value::String("type".to_string()),
+        serde_yaml::Value::String("object".to_string()),
+    );
+    schema.insert(
+        serde_yaml::Value::String("properties".to_string()),
+        serde_yaml::Value::Mapping(properties),
+    );
+    schema.insert(
+        serde_yaml::Value::String("required".to_string()),
+        serde_yaml::Value::Sequence(vec!["type".into(), "status".into()]),
+    );
+    schema
+}
 
-    /// Get type definitions
-    pub fn get_type_defs(&self) -> &HashMap<String, TypeDefinition> {
-        &self.type_defs
+/// Get the wire name a field had before it was renamed from `old_field`
+///
+/// The old name is assumed to follow the convention of the new one (the Go
+/// name as is, or lowerCamelCase). Returns `None` when the wire name did not
+/// change, since the setters then keep their names.
+fn renamed_wire_name(old_field: &str, new_field: &str, wire_name: &str) -> Option<String> {
+    if wire_name == old_field || wire_name == lower_camel(old_field) {
+        return None;
     }
 
-    /// Get package information
-    pub fn get_package_info(&self) -> Option<&PackageNode> {
-        self.package_info.as_ref()
+    if wire_name == new_field {
+        Some(old_field.to_string())
+    } else {
+        Some(lower_camel(old_field))
     }
+}
 
-    /// Extract schemas from AST
-    pub fn extract_schemas(&self) -> Vec<ExtractedSchema> {
-        let mut schemas = Vec::new();
+/// Whether a Go identifier is exported
+/// A default of a field assigned by a constructor
+enum FieldDefault {
+    Value(serde_yaml::Value),
 
-        for node in &self.nodes {
-            if let GoAstNode::TypeDecl(type_decl) = node {
-                let schema = self.type_decl_to_schema(type_decl);
-                schemas.push(schema);
+    /// A constant the file does not declare
+    Constant(String),
+}
+
+/// Apply a binary operator to two constant values
+///
+/// Integers follow Go, failing on overflow and division by zero; numbers
+/// with a float become floats, strings concatenate and booleans combine.
+fn binary_constant(
+    operator: &str,
+    left: &serde_yaml::Value,
+    right: &serde_yaml::Value,
+) -> Option<serde_yaml::Value> {
+    use serde_yaml::Value;
+
+    match (left, right) {
+        (Value::Number(l), Value::Number(r)) => match (l.as_i64(), r.as_i64()) {
+            (Some(l), Some(r)) => {
+                let shift = || u32::try_from(r).ok();
+                match operator {
+                    "+" => l.checked_add(r),
+                    "-" => l.checked_sub(r),
+                    "*" => l.checked_mul(r),
+                    "/" => l.checked_div(r),
+                    "%" => l.checked_rem(r),
+                    "<<" => shift().and_then(|s| l.checked_shl(s)),
+                    ">>" => shift().and_then(|s| l.checked_shr(s)),
+                    "|" => Some(l | r),
+                    "&" => Some(l & r),
+                    "^" => Some(l ^ r),
+                    "&^" => Some(l & !r),
+                    _ => None,
+                }
+                .map(Value::from)
+            }
+            _ => {
+                let (l, r) = (l.as_f64()?, r.as_f64()?);
+                match operator {
+                    "+" => Some(l + r),
+                    "-" => Some(l - r),
+                    "*" => Some(l * r),
+                    "/" if r != 0.0 => Some(l / r),
+                    _ => None,
+                }
+                .map(Value::from)
             }
+        },
+        (Value::String(l), Value::String(r)) if operator == "+" => {
+            Some(Value::from(format!("{l}{r}")))
         }
-
-        schemas
+        (Value::Bool(l), Value::Bool(r)) => match operator {
+            "&&" => Some(Value::from(*l && *r)),
+            "||" => Some(Value::from(*l || *r)),
+            _ => None,
+        },
+        _ => None,
     }
+}
 
-    /// Convert type declaration to schema
-    fn type_decl_to_schema(&self, type_decl: &TypeDeclNode) -> ExtractedSchema {
-        let mut metadata = HashMap::new();
-        metadata.insert(
-            "package".to_string(),
-            serde_yaml::Value::String(
-                self.package_info
-                    .as_ref()
-                    .map(|p| p.name.clone())
-                    .unwrap_or_default(),
-            ),
-        );
-        metadata.insert(
-            "docs".to_string(),
-            serde_yaml::Value::Sequence(
-                type_decl
-                    .docs
-                    .iter()
-                    .map(|d| serde_yaml::Value::String(d.clone()))
-                    .collect(),
-            ),
-        );
+/// Whether a text is a Go identifier
+fn is_identifier(text: &str) -> bool {
+    let mut chars = text.chars();
+    chars.next().is_some_and(|c| c.is_alphabetic() || c == '_')
+        && chars.all(|c| c.is_alphanumeric() || c == '_')
+}
 
-        let schema_content = match &type_decl.type_def {
-            TypeDefinition::Struct(struct_type) => self.struct_to_schema(struct_type),
-            TypeDefinition::Interface(interface_type) => self.interface_to_schema(interface_type),
-            _ => serde_yaml::Value::Null,
-        };
+/// Collect the names of the parameters and variables declared under a node
+fn collect_local_names(node: Node, content: &str, names: &mut HashSet<String>) {
+    let declared = match node.kind() {
+        "parameter_declaration" | "variadic_parameter_declaration" | "var_spec" | "const_spec" => {
+            Some("name")
+        }
+        "short_var_declaration" | "range_clause" => Some("left"),
+        _ => None,
+    };
+    if let Some(field) = declared {
+        for declared in node.children_by_field_name(field, &mut node.walk()) {
+            let identifiers: Vec<Node> = match declared.kind() {
+                "expression_list" => declared.named_children(&mut declared.walk()).collect(),
+                _ => vec![declared],
+            };
+            for identifier in identifiers {
+                if identifier.kind() == "identifier" {
+                    if let Some(name) = content.get(identifier.byte_range()) {
+                        names.insert(name.to_string());
+                    }
+                }
+            }
+        }
+    }
+    for child in node.named_children(&mut node.walk()) {
+        collect_local_names(child, content, names);
+    }
+}
 
-        ExtractedSchema {
-            name: type_decl.name.clone(),
-            schema_type: "go_struct".to_string(),
-            content: schema_content,
-            source_file: type_decl.position.file.clone(),
-            metadata,
+/// Collect the integer literals under a node, in source order
+fn collect_int_literals(node: Node, content: &str, literals: &mut Vec<usize>) {
+    if node.kind() == "int_literal" {
+        if let Some(value) = content
+            .get(node.byte_range())
+            .and_then(parse_go_int)
+            .and_then(|value| usize::try_from(value).ok())
+        {
+            literals.push(value);
         }
+        return;
     }
+    for child in node.named_children(&mut node.walk()) {
+        collect_int_literals(child, content, literals);
+    }
+}
 
-    /// Convert struct type to schema
-    fn struct_to_schema(&self, struct_type: &StructTypeNode) -> serde_yaml::Value {
-        let mut properties = serde_yaml::Mapping::new();
-        let mut required = Vec::new();
+/// Get the first C type of cgo in a type, through pointers and collections
+fn c_type(type_def: &TypeDefinition) -> Option<String> {
+    match type_def {
+        TypeDefinition::Basic(name) if name.starts_with("C.") => Some(name.clone()),
+        TypeDefinition::Pointer(inner)
+        | TypeDefinition::Slice(inner)
+        | TypeDefinition::Array(inner) => c_type(inner),
+        TypeDefinition::Map(key, value) => c_type(key).or_else(|| c_type(value)),
+        _ => None,
+    }
+}
 
-        for field in &struct_type.fields {
-            for name in &field.names {
-                let field_schema = self.field_to_schema(field);
-                properties.insert(serde_yaml::Value::String(name.clone()), field_schema);
+/// Get the key type of a map field, through pointers
+fn map_key_type(type_def: &TypeDefinition) -> Option<&str> {
+    match type_def {
+        TypeDefinition::Pointer(inner) => map_key_type(inner),
+        TypeDefinition::Map(key, _) => match key.as_ref() {
+            TypeDefinition::Basic(key) => Some(key),
+            _ => None,
+        },
+        _ => None,
+    }
+}
 
-                // Check if field is required (no pointer, no omitempty tag)
-                if !self.field_is_optional(field) {
-                    required.push(name.clone());
-                }
+/// Whether a Go builtin type is a number, string or boolean
+fn is_primitive_type(name: &str) -> bool {
+    is_integer_type(name) || matches!(name, "float32" | "float64" | "string" | "bool")
+}
+
+/// Whether a Go builtin type is an integer
+fn is_integer_type(name: &str) -> bool {
+    matches!(
+        name,
+        "int"
+            | "int8"
+            | "int16"
+            | "int32"
+            | "int64"
+            | "uint"
+            | "uint8"
+            | "uint16"
+            | "uint32"
+            | "uint64"
+            | "uintptr"
+            | "byte"
+            | "rune"
+    )
+}
+
+fn is_exported(name: &str) -> bool {
+    name.starts_with(|c: char| c.is_uppercase())
+}
+
+/// Convert an exported Go name to lowerCamelCase (`APIVersion` -> `apiVersion`)
+pub(crate) fn lower_camel(name: &str) -> String {
+    let chars: Vec<char> = name.chars().collect();
+    let upper_run = chars.iter().take_while(|c| c.is_ascii_uppercase()).count();
+
+    // In a leading acronym the last capital starts the next word
+    let lower_count = if upper_run > 1 && upper_run < chars.len() {
+        upper_run - 1
+    } else {
+        upper_run.max(1)
+    };
+
+    chars
+        .iter()
+        .enumerate()
+        .map(|(i, c)| {
+            if i < lower_count {
+                c.to_ascii_lowercase()
+            } else {
+                *c
             }
+        })
+        .collect()
+}
+
+/// Rules deciding the schema of a field, in order of precedence
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum MappingRule {
+    /// Tags, directives or configuration leaving the field out
+    LeftOut,
+    /// A schema or Go type in `field_overrides`
+    FieldOverride,
+    /// `any_policy`, or the policy of a field override, for fields holding
+    /// arbitrary JSON
+    AnyPolicy,
+    /// Pointers, which have the schema of what they point to
+    Pointer,
+    /// `unwrap_types` and the built-in wrappers (`mo.Option[T]`, ...)
+    UnwrapRule,
+    /// Types marked `+gensonnet:exclude`, matching `exclude_types` or not
+    /// selected by `types`
+    ExcludedType,
+    /// `type_mappings` of the source
+    TypeMapping,
+    /// Built-in mappings of standard library types (`time.Time`, ...)
+    Builtin,
+    /// Named primitives, aliases and collections, which have the schema of
+    /// their definition
+    NamedType,
+    /// The Go type of the field
+    GoType,
+}
+
+impl MappingRule {
+    /// Get a short description of the rule
+    pub fn describe(&self) -> &'static str {
+        match self {
+            MappingRule::LeftOut => "left out",
+            MappingRule::FieldOverride => "field override",
+            MappingRule::AnyPolicy => "any policy",
+            MappingRule::Pointer => "pointer",
+            MappingRule::UnwrapRule => "unwrap rule",
+            MappingRule::ExcludedType => "excluded type",
+            MappingRule::TypeMapping => "type mapping",
+            MappingRule::Builtin => "built-in mapping",
+            MappingRule::NamedType => "named type",
+            MappingRule::GoType => "Go type",
         }
+    }
+}
 
-        let mut schema = serde_yaml::Mapping::new();
-        schema.insert(
-            serde_yaml::Value::String("type".to_string()),
-            serde_yaml::Value::String("object".to_string()),
-        );
-        schema.insert(
-            serde_yaml::Value::String("properties".to_string()),
-            serde_yaml::Value::Mapping(properties),
-        );
+/// How the schema of a field was chosen
+#[derive(Debug, Clone)]
+pub struct MappingTrace {
+    /// Field, as `pkg.Type.Field`
+    pub field: String,
+    /// Go type of the field
+    pub go_type: String,
+    /// Rules followed to the schema, in order
+    pub steps: Vec<(MappingRule, String)>,
+    /// Rule that produced the schema
+    pub rule: MappingRule,
+    /// Resulting schema, or `None` when the field is left out
+    pub schema: Option<serde_yaml::Value>,
+}
 
-        if !required.is_empty() {
-            schema.insert(
-                serde_yaml::Value::String("required".to_string()),
-                serde_yaml::Value::Sequence(
-                    required
-                        .iter()
-                        .map(|r| serde_yaml::Value::String(r.clone()))
-                        .collect(),
-                ),
-            );
+impl MappingTrace {
+    /// Render the trace for the terminal
+    pub fn render(&self) -> String {
+        let mut lines = vec![format!("Mapping of {} ({}):", self.field, self.go_type)];
+        for (idx, (rule, outcome)) in self.steps.iter().enumerate() {
+            let status = match idx + 1 == self.steps.len() {
+                true => " <- applied",
+                false => "",
+            };
+            lines.push(format!(
+                "  {}. {}: {}{}",
+                idx + 1,
+                rule.describe(),
+                outcome,
+                status
+            ));
+        }
+        match &self.schema {
+            Some(schema) => lines.push(format!(
+                "  schema: {}",
+                serde_json::to_string(schema).unwrap_or_default()
+            )),
+            None => lines.push("  schema: none, the field is left out".to_string()),
         }
+        lines.join("\n")
+    }
+}
 
-        serde_yaml::Value::Mapping(schema)
+/// Render a type as written in Go source
+fn go_type_string(type_def: &TypeDefinition) -> String {
+    match type_def {
+        TypeDefinition::Basic(name) | TypeDefinition::Alias(name) => name.clone(),
+        TypeDefinition::Pointer(inner) => format!("*{}", go_type_string(inner)),
+        TypeDefinition::Slice(inner) => format!("[]{}", go_type_string(inner)),
+        TypeDefinition::Array(inner) => format!("[...]{}", go_type_string(inner)),
+        TypeDefinition::Map(key, value) => {
+            format!("map[{}]{}", go_type_string(key), go_type_string(value))
+        }
+        TypeDefinition::Struct(_) => "struct{...}".to_string(),
+        TypeDefinition::Interface(interface)
+            if interface.methods.is_empty() && interface.embedded.is_empty() =>
+        {
+            "interface{}".to_string()
+        }
+        TypeDefinition::Interface(_) => "interface{...}".to_string(),
     }
+}
 
-    /// Convert interface type to schema
-    fn interface_to_schema(&self, _interface_type: &InterfaceTypeNode) -> serde_yaml::Value {
-        let mut schema = serde_yaml::Mapping::new();
-        schema.insert(
-            serde_yaml::Value::String("type".to_string()),
-            serde_yaml::Value::String("object".to_string()),
-        );
+/// Parse a Go type written as text, such as a type argument
+///
+/// Pointers, slices, arrays and maps are taken apart; other types, including
+/// generic instantiations, are kept by name.
+fn parse_type_text(text: &str) -> TypeDefinition {
+    let text = text.trim();
+    if let Some(inner) = text.strip_prefix('*') {
+        return TypeDefinition::Pointer(Box::new(parse_type_text(inner)));
+    }
+    if let Some(inner) = text.strip_prefix("[]") {
+        return TypeDefinition::Slice(Box::new(parse_type_text(inner)));
+    }
+    if text.starts_with('[') {
+        if let Some(end) = closing_bracket(text, 0) {
+            return TypeDefinition::Array(Box::new(parse_type_text(&text[end + 1..])));
+        }
+    }
+    if text.starts_with("map[") {
+        if let Some(end) = closing_bracket(text, 3) {
+            return TypeDefinition::Map(
+                Box::new(parse_type_text(&text[4..end])),
+                Box::new(parse_type_text(&text[end + 1..])),
+            );
+        }
+    }
+    TypeDefinition::Basic(text.to_string())
+}
 
-        // For interfaces, we might want to generate different schemas
-        // depending on the use case. For now, we'll create a basic object schema.
+/// Get the type arguments of a generic instantiation (`Pair[string, []int]`)
+fn type_arguments(type_name: &str) -> Vec<TypeDefinition> {
+    let Some(start) = type_name.find('[') else {
+        return Vec::new();
+    };
+    let Some(end) = closing_bracket(type_name, start) else {
+        return Vec::new();
+    };
+
+    let mut args = Vec::new();
+    let (mut depth, mut arg_start) = (0, start + 1);
+    for (idx, c) in type_name[..end]
+        .char_indices()
+        .skip_while(|(idx, _)| *idx <= start)
+    {
+        match c {
+            '[' | '(' | '{' => depth += 1,
+            ']' | ')' | '}' => depth -= 1,
+            ',' if depth == 0 => {
+                args.push(parse_type_text(&type_name[arg_start..idx]));
+                arg_start = idx + 1;
+            }
+            _ => {}
+        }
+    }
+    let last = type_name[arg_start..end].trim();
+    if !last.is_empty() {
+        args.push(parse_type_text(last));
+    }
+    args
+}
 
-        serde_yaml::Value::Mapping(schema)
+/// Get the index of the `]` closing the `[` at `open`
+fn closing_bracket(text: &str, open: usize) -> Option<usize> {
+    let mut depth = 0;
+    for (idx, c) in text.char_indices().filter(|(idx, _)| *idx >= open) {
+        match c {
+            '[' => depth += 1,
+            ']' => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(idx);
+                }
+            }
+            _ => {}
+        }
     }
+    None
+}
 
-    /// Convert field to schema
-    fn field_to_schema(&self, field: &FieldNode) -> serde_yaml::Value {
-        let mut schema = serde_yaml::Mapping::new();
+/// Rule deciding the schema of a struct field, before its Go type
+enum FieldRule<'a> {
+    /// A schema in `field_overrides`
+    Schema(&'a serde_yaml::Value),
+    /// A Go type in `field_overrides`
+    GoType(&'a str),
+    /// A policy for fields holding arbitrary JSON, and where it is set
+    Any(AnyPolicy, &'static str),
+    /// The field's own Go type
+    Derived,
+}
 
-        let field_type = self.type_def_to_schema_type(&field.field_type);
-        schema.insert(
-            serde_yaml::Value::String("type".to_string()),
-            serde_yaml::Value::String(field_type),
-        );
+/// Rule deciding the schema of a type, found by `resolve_type`
+enum TypeRule<'a> {
+    /// A pointer to a type
+    Pointer(&'a TypeDefinition),
+    /// A generic wrapper, unwrapped to the type it holds
+    Unwrapped(TypeDefinition),
+    /// A wrapper unwrapped to a free-form map
+    FreeForm(&'a str),
+    /// A type left out of generation
+    Excluded(&'a str),
+    /// A type in `type_mappings`
+    TypeMapping(&'a str, TypeMapping),
+    /// A standard library type with a built-in mapping
+    Builtin(&'a str, TypeMapping),
+    /// A named primitive or alias, and its primitive
+    Primitive(&'a str, &'a str),
+    /// A named slice or map, and its definition
+    Collection(&'a str, &'a TypeDefinition),
+    /// Derived from the Go type itself
+    GoType,
+}
 
-        // Add description from docs
-        if !field.docs.is_empty() {
-            schema.insert(
-                serde_yaml::Value::String("description".to_string()),
-                serde_yaml::Value::String(field.docs.join(" ")),
-            );
-        }
+/// How a struct field's schema is produced
+enum FieldSchema {
+    /// Derived from the field's Go type
+    Derived,
+    /// Supplied by configuration or policy
+    Override(serde_yaml::Value),
+    /// Left out by policy
+    Skip,
+    /// Required by policy but not configured
+    Missing,
+}
 
-        serde_yaml::Value::Mapping(schema)
+/// Schema for fields that accept any JSON object
+fn free_form_schema() -> serde_yaml::Mapping {
+    let mut schema = serde_yaml::Mapping::new();
+    schema.insert(
+        serde_yaml::Value::String("type".to_string()),
+        serde_yaml::Value::String("object".to_string()),
+    );
+    schema.insert(
+        serde_yaml::Value::String("additionalProperties".to_string()),
+        serde_yaml::Value::Bool(true),
+    );
+    schema
+}
+
+/// Schema for values whose encoding is not known
+fn opaque_schema() -> serde_yaml::Mapping {
+    let mut schema = serde_yaml::Mapping::new();
+    schema.insert(
+        serde_yaml::Value::String("type".to_string()),
+        serde_yaml::Value::String("string".to_string()),
+    );
+    schema
+}
+
+/// Parse a `default` struct tag value according to the field's schema type
+fn tag_default(value: &str, schema: &serde_yaml::Value) -> Option<serde_yaml::Value> {
+    match schema.get("type").and_then(|t| t.as_str()) {
+        Some("integer") => parse_go_int(value).map(|n| serde_yaml::Value::Number(n.into())),
+        Some("number") => value
+            .parse::<f64>()
+            .ok()
+            .map(|n| serde_yaml::Value::Number(n.into())),
+        Some("boolean") => value.parse::<bool>().ok().map(serde_yaml::Value::Bool),
+        Some("array") | Some("object") => serde_yaml::from_str(value).ok(),
+        _ => Some(serde_yaml::Value::String(value.to_string())),
     }
+}
 
-    /// Convert type definition to schema type
-    fn type_def_to_schema_type(&self, type_def: &TypeDefinition) -> String {
-        match type_def {
-            TypeDefinition::Basic(basic_type) => match basic_type.as_str() {
-                "string" => "string".to_string(),
-                "int" | "int8" | "int16" | "int32" | "int64" => "integer".to_string(),
-                "uint" | "uint8" | "uint16" | "uint32" | "uint64" => "integer".to_string(),
-                "float32" | "float64" => "number".to_string(),
-                "bool" => "boolean".to_string(),
-                _ => "string".to_string(),
-            },
-            TypeDefinition::Array(_) => "array".to_string(),
-            TypeDefinition::Slice(_) => "array".to_string(),
-            TypeDefinition::Map(_, _) => "object".to_string(),
-            TypeDefinition::Pointer(_) => "object".to_string(),
-            TypeDefinition::Struct(_) => "object".to_string(),
-            TypeDefinition::Interface(_) => "object".to_string(),
-            TypeDefinition::Alias(_) => "string".to_string(),
+/// Get the specs of a `const` or `var` declaration, grouped or not
+fn declaration_specs(declaration: Node) -> Vec<Node> {
+    let mut specs = Vec::new();
+    for child in declaration.named_children(&mut declaration.walk()) {
+        match child.kind() {
+            "const_spec" | "var_spec" => specs.push(child),
+            // Newer grammars wrap grouped specs in a list
+            "var_spec_list" => specs.extend(
+                child
+                    .named_children(&mut child.walk())
+                    .filter(|spec| spec.kind() == "var_spec"),
+            ),
+            _ => {}
         }
     }
+    specs
+}
 
-    /// Check if field is optional
-    fn field_is_optional(&self, field: &FieldNode) -> bool {
-        // Check for pointer type
-        if let TypeDefinition::Pointer(_) = field.field_type {
-            return true;
-        }
+/// Unwrap the `literal_element` wrapper newer grammars put around elements
+fn unwrap_literal_element(node: Node) -> Node {
+    if node.kind() == "literal_element" {
+        node.named_child(0).unwrap_or(node)
+    } else {
+        node
+    }
+}
 
-        // Check for omitempty tag
-        if let Some(tags) = &field.tags {
-            return tags.contains("omitempty");
-        }
+/// Parse a Go integer literal (decimal, hex, octal or binary, with `_` separators)
+fn parse_go_int(text: &str) -> Option<i64> {
+    let text = text.replace('_', "");
+    let lower = text.to_ascii_lowercase();
+
+    if let Some(hex) = lower.strip_prefix("0x") {
+        i64::from_str_radix(hex, 16).ok()
+    } else if let Some(bin) = lower.strip_prefix("0b") {
+        i64::from_str_radix(bin, 2).ok()
+    } else if let Some(oct) = lower.strip_prefix("0o") {
+        i64::from_str_radix(oct, 8).ok()
+    } else if lower.len() > 1 && lower.starts_with('0') {
+        i64::from_str_radix(&lower[1..], 8).ok()
+    } else {
+        lower.parse().ok()
+    }
+}
 
-        false
+/// Unquote an interpreted Go string literal
+fn unquote_go_string(text: &str) -> String {
+    let inner = text
+        .strip_prefix('"')
+        .and_then(|t| t.strip_suffix('"'))
+        .unwrap_or(text);
+
+    let mut value = String::with_capacity(inner.len());
+    let mut chars = inner.chars();
+    while let Some(c) = chars.next() {
+        if c != '\\' {
+            value.push(c);
+            continue;
+        }
+        match chars.next() {
+            Some('n') => value.push('\n'),
+            Some('t') => value.push('\t'),
+            Some('r') => value.push('\r'),
+            Some(other) => value.push(other),
+            None => value.push('\\'),
+        }
     }
+
+    value
 }
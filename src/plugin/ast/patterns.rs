@@ -0,0 +1,550 @@
+//! Regular expressions checked with string functions
+//!
+//! The Jsonnet standard library has no regular expressions, so the patterns
+//! of `validate:"regexp=..."` rules, and of the string formats (`email`,
+//! `uuid`, `hostname`, ...), are compiled to trees that the generated
+//! `matchesPattern()` helper walks with `std.stringChars` and `std.member`.
+//! Like `regexp.MatchString`, a pattern matches anywhere in the string
+//! unless it is anchored.
+//!
+//! The supported syntax is the part of RE2 without a regex engine's state:
+//! literals, `.`, classes with ranges (`[a-z0-9_-]`, `[^@]`), `\d`, `\w`,
+//! `\s` and their negations, groups, `|`, the `*`, `+`, `?` and `{n,m}`
+//! repetitions and the `^`, `$`, `\A` and `\z` anchors. Flags, word
+//! boundaries, Unicode classes and ranges of more than `MAX_RANGE`
+//! characters are not.
+
+use super::generator::quote_string;
+
+/// Largest character range spelled out in a compiled class
+const MAX_RANGE: u32 = 256;
+
+/// Largest count of a `{n,m}` repetition, as in RE2
+const MAX_REPEAT: u32 = 1000;
+
+/// Helper walking the compiled patterns
+///
+/// `patternEnds` gives the positions where a match of a node starting at a
+/// position can end, so alternatives and repetitions need no backtracking.
+pub const PATTERN_ENDS_HELPER: &str = r#"local patternEnds(node, chars, i) =
+  local after(nodes, positions) =
+    std.foldl(function(ends, n) std.set(std.flattenArrays([patternEnds(n, chars, p) for p in ends])), nodes, positions);
+  if std.objectHas(node, 'set') then (if i < std.length(chars) && std.member(node.set, chars[i]) then [i + 1] else [])
+  else if std.objectHas(node, 'notSet') then (if i < std.length(chars) && !std.member(node.notSet, chars[i]) then [i + 1] else [])
+  else if std.objectHas(node, 'seq') then after(node.seq, [i])
+  else if std.objectHas(node, 'alt') then std.set(std.flattenArrays([patternEnds(n, chars, i) for n in node.alt]))
+  else if std.objectHas(node, 'start') then (if i == 0 then [i] else [])
+  else if std.objectHas(node, 'end') then (if i == std.length(chars) then [i] else [])
+  else
+    local repeat(level, count, found) =
+      local done = if count >= node.min then std.setUnion(found, level) else found;
+      if level == [] || count == node.max then done
+      else
+        local next = after([node.repeat], level);
+        repeat(if count >= node.min then std.setDiff(next, done) else next, count + 1, done);
+    repeat([i], 0, []);
+"#;
+
+/// Helper of the libraries checking patterns, given the compiled patterns
+/// as a `patterns` object keyed by their source and `PATTERN_ENDS_HELPER`
+///
+/// Patterns that could not be compiled are `null` and match everything.
+pub const MATCHES_PATTERN_HELPER: &str = r#"local matchesPattern(pattern, value) =
+  local chars = std.stringChars(value);
+  std.isString(value) && (
+    patterns[pattern] == null
+    || std.length([i for i in std.range(0, std.length(chars)) if patternEnds(patterns[pattern], chars, i) != []]) > 0
+  );
+"#;
+
+/// Helper of the libraries checking patterns with the `regexMatch` native
+/// function, as Tanka provides
+pub const NATIVE_MATCHES_PATTERN_HELPER: &str =
+    "local matchesPattern(pattern, value) = std.isString(value) && std.native('regexMatch')(pattern, value);\n";
+
+/// Helper of the libraries checking patterns with the `regexMatch` native
+/// function when the evaluator registers it, and like
+/// `MATCHES_PATTERN_HELPER` otherwise
+///
+/// go-jsonnet gives `null` for `std.native()` of an unregistered function.
+pub const AUTO_MATCHES_PATTERN_HELPER: &str = r#"local regexMatch = std.native('regexMatch');
+local matchesPattern(pattern, value) =
+  local chars = std.stringChars(value);
+  std.isString(value) && (
+    if regexMatch != null then regexMatch(pattern, value)
+    else patterns[pattern] == null
+      || std.length([i for i in std.range(0, std.length(chars)) if patternEnds(patterns[pattern], chars, i) != []]) > 0
+  );
+"#;
+
+/// A compiled pattern
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Node {
+    /// One character of a set, or not of it
+    Set { chars: String, negated: bool },
+
+    /// Nodes matched one after the other
+    Seq(Vec<Node>),
+
+    /// Nodes one of which is matched
+    Alt(Vec<Node>),
+
+    /// A node matched `min` to `max` times, any number of times without
+    /// a `max`
+    Repeat {
+        node: Box<Node>,
+        min: u32,
+        max: Option<u32>,
+    },
+
+    /// Start of the string
+    Start,
+
+    /// End of the string
+    End,
+}
+
+impl Node {
+    /// Render the node as a Jsonnet value for `matchesPattern()`
+    pub fn to_jsonnet(&self) -> String {
+        let list = |nodes: &[Node]| {
+            nodes
+                .iter()
+                .map(Node::to_jsonnet)
+                .collect::<Vec<_>>()
+                .join(", ")
+        };
+        match self {
+            Node::Set {
+                chars,
+                negated: false,
+            } => format!("{{ set: {} }}", quote_string(chars)),
+            Node::Set {
+                chars,
+                negated: true,
+            } => format!("{{ notSet: {} }}", quote_string(chars)),
+            Node::Seq(nodes) => format!("{{ seq: [{}] }}", list(nodes)),
+            Node::Alt(nodes) => format!("{{ alt: [{}] }}", list(nodes)),
+            Node::Repeat { node, min, max } => format!(
+                "{{ repeat: {}, min: {min}, max: {} }}",
+                node.to_jsonnet(),
+                max.map_or(-1, i64::from)
+            ),
+            Node::Start => "{ start: true }".to_string(),
+            Node::End => "{ end: true }".to_string(),
+        }
+    }
+}
+
+/// Compile a pattern, if its syntax is supported
+pub fn compile(pattern: &str) -> Option<Node> {
+    let mut parser = Parser {
+        chars: pattern.chars().collect(),
+        pos: 0,
+    };
+    let node = parser.alternation()?;
+    (parser.pos == parser.chars.len()).then_some(node)
+}
+
+/// Recursive descent over the characters of a pattern
+struct Parser {
+    chars: Vec<char>,
+    pos: usize,
+}
+
+impl Parser {
+    fn peek(&self) -> Option<char> {
+        self.chars.get(self.pos).copied()
+    }
+
+    fn next(&mut self) -> Option<char> {
+        let c = self.peek()?;
+        self.pos += 1;
+        Some(c)
+    }
+
+    fn eat(&mut self, c: char) -> bool {
+        if self.peek() == Some(c) {
+            self.pos += 1;
+            return true;
+        }
+        false
+    }
+
+    /// `a|b|...`
+    fn alternation(&mut self) -> Option<Node> {
+        let mut alternatives = vec![self.sequence()?];
+        while self.eat('|') {
+            alternatives.push(self.sequence()?);
+        }
+        Some(match alternatives.len() {
+            1 => alternatives.remove(0),
+            _ => Node::Alt(alternatives),
+        })
+    }
+
+    /// Repeated atoms up to the end of a group or alternative
+    fn sequence(&mut self) -> Option<Node> {
+        let mut nodes = Vec::new();
+        while let Some(c) = self.peek() {
+            if c == '|' || c == ')' {
+                break;
+            }
+            let atom = self.atom()?;
+            nodes.push(self.repetition(atom)?);
+        }
+        Some(match nodes.len() {
+            1 => nodes.remove(0),
+            _ => Node::Seq(nodes),
+        })
+    }
+
+    fn atom(&mut self) -> Option<Node> {
+        match self.next()? {
+            '(' => {
+                if self.eat('?') {
+                    // Only non-capturing and named groups, not flags
+                    if self.eat('P') || self.peek() == Some('<') {
+                        if !self.eat('<') {
+                            return None;
+                        }
+                        while self.next()? != '>' {}
+                    } else if !self.eat(':') {
+                        return None;
+                    }
+                }
+                let node = self.alternation()?;
+                self.eat(')').then_some(node)
+            }
+            '[' => self.class(),
+            '.' => Some(Node::Set {
+                chars: "\n".to_string(),
+                negated: true,
+            }),
+            '^' => Some(Node::Start),
+            '$' => Some(Node::End),
+            '\\' => match self.next()? {
+                'A' => Some(Node::Start),
+                'z' => Some(Node::End),
+                c => {
+                    let (chars, negated) = escape(c)?;
+                    Some(Node::Set { chars, negated })
+                }
+            },
+            '*' | '+' | '?' | '{' | ')' => None,
+            c => Some(literal(c)),
+        }
+    }
+
+    /// `*`, `+`, `?` and `{n,m}` after an atom; lazy repetitions match
+    /// the same strings
+    fn repetition(&mut self, atom: Node) -> Option<Node> {
+        let mut node = atom;
+        loop {
+            let (min, max) = match self.peek() {
+                Some('*') => (0, None),
+                Some('+') => (1, None),
+                Some('?') => (0, Some(1)),
+                Some('{') => {
+                    self.pos += 1;
+                    let min = self.number()?;
+                    let max = if self.eat(',') {
+                        match self.peek() {
+                            Some('}') => None,
+                            _ => Some(self.number()?),
+                        }
+                    } else {
+                        Some(min)
+                    };
+                    if self.peek() != Some('}') || max.is_some_and(|max| max < min) {
+                        return None;
+                    }
+                    (min, max)
+                }
+                _ => return Some(node),
+            };
+            self.pos += 1;
+            self.eat('?');
+            node = Node::Repeat {
+                node: Box::new(node),
+                min,
+                max,
+            };
+        }
+    }
+
+    fn number(&mut self) -> Option<u32> {
+        let start = self.pos;
+        while self.peek().is_some_and(|c| c.is_ascii_digit()) {
+            self.pos += 1;
+        }
+        let digits: String = self.chars[start..self.pos].iter().collect();
+        digits.parse().ok().filter(|n| *n <= MAX_REPEAT)
+    }
+
+    /// `[...]`, after the bracket
+    fn class(&mut self) -> Option<Node> {
+        let negated = self.eat('^');
+        let mut chars = String::new();
+        let mut first = true;
+        loop {
+            let c = self.next()?;
+            if c == ']' && !first {
+                break;
+            }
+            first = false;
+            let start = match c {
+                '[' if self.peek() == Some(':') => return None,
+                '\\' => {
+                    let (escaped, negated) = escape(self.next()?)?;
+                    if negated {
+                        return None;
+                    }
+                    if escaped.chars().count() > 1 {
+                        chars.push_str(&escaped);
+                        continue;
+                    }
+                    escaped.chars().next()?
+                }
+                c => c,
+            };
+            if self.peek() == Some('-') && self.chars.get(self.pos + 1) != Some(&']') {
+                self.pos += 1;
+                let end = match self.next()? {
+                    '\\' => {
+                        let (escaped, false) = escape(self.next()?)? else {
+                            return None;
+                        };
+                        let mut escaped = escaped.chars();
+                        match (escaped.next(), escaped.next()) {
+                            (Some(end), None) => end,
+                            _ => return None,
+                        }
+                    }
+                    c => c,
+                };
+                if end < start || end as u32 - start as u32 >= MAX_RANGE {
+                    return None;
+                }
+                chars.extend(
+                    (start..=end)
+                        .filter(|c| !chars.contains(*c))
+                        .collect::<Vec<_>>(),
+                );
+            } else if !chars.contains(start) {
+                chars.push(start);
+            }
+        }
+        Some(Node::Set { chars, negated })
+    }
+}
+
+/// Character sets of the escapes of patterns, with whether they are
+/// negated; `None` for escapes without one
+fn escape(c: char) -> Option<(String, bool)> {
+    const DIGITS: &str = "0123456789";
+    const WORD: &str = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_";
+    const SPACE: &str = "\t\n\x0c\r ";
+    let set = |chars: &str, negated| Some((chars.to_string(), negated));
+    match c {
+        'd' => set(DIGITS, false),
+        'D' => set(DIGITS, true),
+        'w' => set(WORD, false),
+        'W' => set(WORD, true),
+        's' => set(SPACE, false),
+        'S' => set(SPACE, true),
+        't' => set("\t", false),
+        'n' => set("\n", false),
+        'r' => set("\r", false),
+        'f' => set("\x0c", false),
+        'v' => set("\x0b", false),
+        c if c.is_ascii_punctuation() => Some((c.to_string(), false)),
+        _ => None,
+    }
+}
+
+fn literal(c: char) -> Node {
+    Node::Set {
+        chars: c.to_string(),
+        negated: false,
+    }
+}
+
+/// Get the patterns of a schema and its element schemas that cannot be
+/// compiled
+pub fn uncompiled(schema: &serde_yaml::Value) -> Vec<&str> {
+    let mut patterns: Vec<&str> = schema
+        .get("pattern")
+        .and_then(|p| p.as_str())
+        .filter(|pattern| compile(pattern).is_none())
+        .into_iter()
+        .collect();
+    for key in ["items", "additionalProperties"] {
+        if let Some(inner) = schema.get(key).filter(|s| s.is_mapping()) {
+            patterns.extend(uncompiled(inner));
+        }
+    }
+    patterns
+}
+
+/// Render the `patterns` object of a library, with the pattern sources as
+/// keys
+pub fn render_patterns<'a>(patterns: impl IntoIterator<Item = &'a str>) -> String {
+    let mut code = String::from("local patterns = {\n");
+    for pattern in patterns {
+        match compile(pattern) {
+            Some(node) => code.push_str(&format!(
+                "  {}: {},\n",
+                quote_string(pattern),
+                node.to_jsonnet()
+            )),
+            None => {
+                code.push_str("  // Not expressible without a regex engine, so not checked\n");
+                code.push_str(&format!("  {}: null,\n", quote_string(pattern)));
+            }
+        }
+    }
+    code.push_str("};\n");
+    code
+}
+
+#[cfg(test)]
+pub(crate) mod tests {
+    use super::*;
+    use std::collections::BTreeSet;
+
+    /// The positions `patternEnds` gives, as in `PATTERN_ENDS_HELPER`
+    fn ends(node: &Node, chars: &[char], i: usize) -> BTreeSet<usize> {
+        let after = |nodes: &[Node], positions: BTreeSet<usize>| {
+            nodes.iter().fold(positions, |positions, node| {
+                positions
+                    .iter()
+                    .flat_map(|&p| ends(node, chars, p))
+                    .collect()
+            })
+        };
+        match node {
+            Node::Set {
+                chars: set,
+                negated,
+            } => chars
+                .get(i)
+                .filter(|c| set.contains(**c) != *negated)
+                .map(|_| i + 1)
+                .into_iter()
+                .collect(),
+            Node::Seq(nodes) => after(nodes, BTreeSet::from([i])),
+            Node::Alt(nodes) => nodes.iter().flat_map(|n| ends(n, chars, i)).collect(),
+            Node::Start => (i == 0).then_some(i).into_iter().collect(),
+            Node::End => (i == chars.len()).then_some(i).into_iter().collect(),
+            Node::Repeat { node, min, max } => {
+                let (mut level, mut count, mut found) = (BTreeSet::from([i]), 0, BTreeSet::new());
+                loop {
+                    if count >= *min {
+                        found.extend(level.iter().copied());
+                    }
+                    if level.is_empty() || Some(count) == *max {
+                        return found;
+                    }
+                    let next = after(std::slice::from_ref(node), level);
+                    level = match count >= *min {
+                        true => next.difference(&found).copied().collect(),
+                        false => next,
+                    };
+                    count += 1;
+                }
+            }
+        }
+    }
+
+    /// Whether a value matches a pattern, as by `MATCHES_PATTERN_HELPER`
+    pub(crate) fn matches(pattern: &str, value: &str) -> bool {
+        let node = compile(pattern).unwrap();
+        let chars: Vec<char> = value.chars().collect();
+        (0..=chars.len()).any(|i| !ends(&node, &chars, i).is_empty())
+    }
+
+    fn set(chars: &str) -> Node {
+        Node::Set {
+            chars: chars.to_string(),
+            negated: false,
+        }
+    }
+
+    #[test]
+    fn test_compile() {
+        assert_eq!(
+            compile("^[a-c_-]+$"),
+            Some(Node::Seq(vec![
+                Node::Start,
+                Node::Repeat {
+                    node: Box::new(set("abc_-")),
+                    min: 1,
+                    max: None,
+                },
+                Node::End,
+            ]))
+        );
+        assert_eq!(
+            compile(r"(?:ab|\.)?"),
+            Some(Node::Repeat {
+                node: Box::new(Node::Alt(vec![
+                    Node::Seq(vec![set("a"), set("b")]),
+                    set("."),
+                ])),
+                min: 0,
+                max: Some(1),
+            })
+        );
+        assert_eq!(
+            compile("[^@]{2,}").map(|node| node.to_jsonnet()),
+            Some("{ repeat: { notSet: \"@\" }, min: 2, max: -1 }".to_string())
+        );
+        assert_eq!(
+            compile(r"\d{3}").map(|node| node.to_jsonnet()),
+            Some("{ repeat: { set: \"0123456789\" }, min: 3, max: 3 }".to_string())
+        );
+
+        for unsupported in [
+            r"(?i)abc",
+            r"\bword\b",
+            r"\p{L}+",
+            "[[:alpha:]]",
+            "a{2,1}",
+            "(ab",
+            "*a",
+            "[\u{100}-\u{3000}]",
+        ] {
+            assert_eq!(compile(unsupported), None, "{unsupported}");
+        }
+    }
+
+    #[test]
+    fn test_matches() {
+        let hostname = r"^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$";
+        assert!(matches(hostname, "api.example.com"));
+        assert!(!matches(hostname, "-api.example.com"));
+        assert!(!matches(hostname, "api..example.com"));
+
+        let duration = r"^(0|[-+]?(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+)$";
+        assert!(matches(duration, "1h30m"));
+        assert!(matches(duration, "1.5µs"));
+        assert!(!matches(duration, "1d"));
+
+        // Unanchored patterns match anywhere, like regexp.MatchString
+        assert!(matches("[0-9]{3}", "abc1234"));
+        assert!(!matches("[0-9]{3}", "ab12c"));
+        assert!(matches("^(a|ab)(c|bcd)$", "abcd"));
+        assert!(matches("^(a*)*$", "aaa"));
+        assert!(!matches("^(a*)*$", "aab"));
+    }
+
+    #[test]
+    fn test_render_patterns() {
+        let code = render_patterns(["^a$", r"\bword"]);
+        assert_eq!(
+            code,
+            "local patterns = {\n  \"^a$\": { seq: [{ start: true }, { set: \"a\" }, { end: true }] },\n  // Not expressible without a regex engine, so not checked\n  \"\\\\bword\": null,\n};\n"
+        );
+    }
+}
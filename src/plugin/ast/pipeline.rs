@@ -0,0 +1,109 @@
+//! Passes over the schemas of a source between parsing and generation
+//!
+//! The files of a source are parsed one at a time; `resolve` then applies
+//! what one file declares to the types of the others, `take_records` takes
+//! out the records that are not types, and `link` names the types of
+//! several packages apart and resolves the references between them.
+//! Sources generated to files and Go source text generated in memory
+//! (`memory::generate_source`) run the same passes, so the same types give
+//! the same libraries.
+
+use anyhow::Result;
+use std::collections::HashMap;
+use std::path::Path;
+
+use super::constants::{self, Constant};
+use super::errors::{self, GoError};
+use super::gowork::{self, WorkspaceModule};
+use super::options::GoAstOptions;
+use super::translations::Translations;
+use super::unsupported::{self, Unsupported};
+use super::{aliases, collisions, enums, scheme, unions};
+use crate::plugin::ExtractedSchema;
+use crate::report::{self, SkippedType};
+
+/// Records of a source that are not types
+#[derive(Debug, Default)]
+pub struct Records {
+    /// Constructs that could not be represented
+    pub unsupported: Vec<Unsupported>,
+
+    /// Types left out of the output
+    pub skipped_types: Vec<SkippedType>,
+
+    /// Exported constants, for the constants library
+    pub constants: Vec<Constant>,
+
+    /// Sentinel errors, for the errors library
+    pub errors: Vec<GoError>,
+
+    /// Warnings of collecting the constants and errors
+    pub warnings: Vec<String>,
+}
+
+/// Resolve the schemas of the files of a source together
+///
+/// Returns the warnings of the passes; fails when the translations cannot
+/// be read.
+pub fn resolve(schemas: &mut Vec<ExtractedSchema>, options: &GoAstOptions) -> Result<Vec<String>> {
+    // Interfaces get union helpers over the types implementing them, and
+    // declared unions over their variants
+    let mut warnings = unions::resolve_unions(schemas, &options.unions, options.streaming);
+
+    // Fields of named primitive types of other files get their schema
+    aliases::resolve_primitive_types(schemas);
+
+    // Defaults naming constants of other files get their values
+    warnings.extend(constants::resolve_constant_defaults(schemas));
+
+    // Integer types get the values of their iota constants
+    warnings.extend(enums::resolve_enums(schemas));
+
+    // Kubernetes resources get the API version of their package's scheme
+    warnings.extend(scheme::resolve_group_versions(schemas));
+
+    // Documentation in the language of the translations
+    if let Some(translations) = &options.translations {
+        Translations::load(translations)?.translate(schemas);
+    }
+
+    Ok(warnings)
+}
+
+/// Take the records that are not types out of the schemas
+pub fn take_records(schemas: &mut Vec<ExtractedSchema>, source: &str, repo_path: &Path) -> Records {
+    let unsupported = unsupported::take_unsupported(schemas, source, repo_path);
+    let skipped_types = report::take_skipped_types(schemas, repo_path);
+    let (constants, mut warnings) = constants::take_constants(schemas);
+    let (errors, error_warnings) = errors::take_errors(schemas);
+    warnings.extend(error_warnings);
+    Records {
+        unsupported,
+        skipped_types,
+        constants,
+        errors,
+        warnings,
+    }
+}
+
+/// Rename the types colliding in an output directory and resolve the
+/// references to types of other packages
+///
+/// `route` gives the output directory of a type, as an index. Returns the
+/// warnings of references that were not found.
+pub fn link(
+    schemas: &mut [ExtractedSchema],
+    options: &GoAstOptions,
+    workspace: &[WorkspaceModule],
+    route: impl Fn(&ExtractedSchema) -> usize,
+) -> Result<Vec<String>> {
+    for (type_name, name) in collisions::resolve_collisions(
+        schemas,
+        options.name_collisions.unwrap_or_default(),
+        route,
+        &mut HashMap::new(),
+    )? {
+        tracing::info!("Generating {} as {}", type_name, name);
+    }
+    Ok(gowork::resolve_references(schemas, workspace))
+}
@@ -4,6 +4,8 @@ use anyhow::Result;
 use async_trait::async_trait;
 use std::path::{Path, PathBuf};
 
+use super::generator::GoJsonnetGenerator;
+use super::options::GoAstOptions;
 use super::parser::GoAstParser;
 use crate::plugin::*;
 
@@ -62,20 +64,46 @@ impl Plugin for GoAstPlugin {
     async fn process_source(
         &self,
         source_path: &Path,
-        _context: &PluginContext,
+        context: &PluginContext,
     ) -> Result<PluginResult> {
         let start_time = std::time::Instant::now();
 
-        // Parse the Go source file
-        let mut parser = GoAstParser::new();
+        // Parse the Go source file with the source's options
+        let options = GoAstOptions::from_value(&context.config.config)?;
+        let mut parser = GoAstParser::with_options(options);
         parser.parse_file(source_path).await?;
 
+        // Every error of the file is returned, none of its types are generated
+        let errors: Vec<String> = parser
+            .diagnostics()
+            .iter()
+            .map(ToString::to_string)
+            .collect();
+        if !errors.is_empty() {
+            return Ok(PluginResult {
+                schemas: Vec::new(),
+                generated_files: Vec::new(),
+                statistics: PluginStatistics {
+                    processing_time_ms: start_time.elapsed().as_millis() as u64,
+                    files_processed: 1,
+                    schemas_extracted: 0,
+                    files_generated: 0,
+                },
+                warnings: parser.warnings(),
+                errors,
+            });
+        }
+
         // Extract schemas
-        let schemas = parser.extract_schemas();
+        let mut schemas = parser.extract_schemas();
+        let schemas_count = schemas.len();
+        schemas.extend(records(&parser));
+        let warnings = parser.warnings();
+        for trace in parser.mapping_traces() {
+            tracing::info!("{}: {}", source_path.display(), trace);
+        }
 
         let processing_time = start_time.elapsed();
-
-        let schemas_count = schemas.len();
         Ok(PluginResult {
             schemas,
             generated_files: Vec::new(),
@@ -85,7 +113,7 @@ impl Plugin for GoAstPlugin {
                 schemas_extracted: schemas_count,
                 files_generated: 0,
             },
-            warnings: Vec::new(),
+            warnings,
             errors: Vec::new(),
         })
     }
@@ -96,14 +124,13 @@ impl Plugin for GoAstPlugin {
         context: &PluginContext,
     ) -> Result<Vec<PathBuf>> {
         let mut generated_files = Vec::new();
+        let generator = GoJsonnetGenerator::new();
 
         for schema in schemas {
-            let output_file = context
-                .output_dir
-                .join(format!("{}.libsonnet", schema.name.to_lowercase()));
+            let output_file = context.output_dir.join(generator.file_name(schema));
 
             // Generate Jsonnet code from the schema
-            let jsonnet_code = self.generate_jsonnet_code(schema)?;
+            let jsonnet_code = generator.generate(schema)?;
             tokio::fs::write(&output_file, jsonnet_code).await?;
 
             generated_files.push(output_file);
@@ -125,30 +152,27 @@ impl Plugin for GoAstPlugin {
     }
 }
 
-impl GoAstPlugin {
-    /// Generate Jsonnet code from schema
-    fn generate_jsonnet_code(&self, schema: &ExtractedSchema) -> Result<String> {
-        let mut code = String::new();
-
-        code.push_str(&format!("// Generated from Go AST: {}\n", schema.name));
-        code.push_str(&format!("// Source: {}\n\n", schema.source_file.display()));
-
-        // Add imports
-        code.push_str("local k = import \"k.libsonnet\";\n");
-        code.push_str("local validate = import \"_validation.libsonnet\";\n\n");
-
-        // Generate the main function
-        code.push_str(&format!("// Create a new {} resource\n", schema.name));
-        code.push_str("function(metadata, spec={}) {\n");
-        code.push_str(&format!(
-            "  apiVersion: \"{}\",\n",
-            schema.name.to_lowercase()
-        ));
-        code.push_str(&format!("  kind: \"{}\",\n", schema.name));
-        code.push_str("  metadata: metadata,\n");
-        code.push_str("  spec: spec,\n");
-        code.push_str("}\n");
-
-        Ok(code)
-    }
+/// Get the records of a parsed file that are not types, which the passes
+/// over the files of a source consume
+pub fn records(parser: &GoAstParser) -> Vec<ExtractedSchema> {
+    let mut records = Vec::new();
+    // Matched against the interfaces of the module by `resolve_unions`
+    records.extend(parser.method_sets());
+    // Applied to the Kubernetes resources of the package by `resolve_group_versions`
+    records.extend(parser.scheme_registration());
+    // Looked up by the fields of their types by `resolve_primitive_types`
+    records.extend(parser.primitive_types());
+    // Looked up by the defaults naming them by `resolve_constant_defaults`
+    records.extend(parser.const_values());
+    // Applied to the integer types they enumerate by `resolve_enums`
+    records.extend(parser.enums());
+    // Merged into the constants library of the source by `take_constants`
+    records.extend(parser.constants());
+    // Merged into the errors library of the source by `take_errors`
+    records.extend(parser.error_catalog());
+    // Gathered into the report of the run by `take_unsupported`
+    records.extend(parser.unsupported());
+    // Gathered into the report and the dry-run plan by `take_skipped_types`
+    records.extend(parser.skipped_types());
+    records
 }
@@ -0,0 +1,170 @@
+//! Types referenced by the schemas of the other output formats
+//!
+//! The CUE, TypeScript and JSON Schema outputs replace the shape of a field
+//! referencing a generated type (`x-go-struct-ref`, `x-go-interface-ref` or
+//! `x-go-ref`), and the variants of a union, by references to the
+//! generated types; references to types that are not generated keep their
+//! shape.
+
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
+use super::aliases::STRUCT_REF_KEY;
+use super::enums::enum_values;
+use super::package_dir;
+use crate::plugin::ExtractedSchema;
+
+/// The generated types schemas may reference
+pub struct TypeIndex<'a> {
+    schemas: &'a [ExtractedSchema],
+
+    /// Types by package directory and name
+    local: HashMap<(PathBuf, String), usize>,
+
+    /// Types by import path and name
+    imported: HashMap<(String, String), usize>,
+}
+
+impl<'a> TypeIndex<'a> {
+    pub fn new(schemas: &'a [ExtractedSchema]) -> Self {
+        let mut local = HashMap::new();
+        let mut imported = HashMap::new();
+        for (idx, schema) in schemas.iter().enumerate() {
+            local.insert((package_dir(schema), schema.name.clone()), idx);
+            if let Some(import_path) = schema.metadata.get("import_path").and_then(|p| p.as_str()) {
+                imported.insert((import_path.to_string(), schema.name.clone()), idx);
+            }
+        }
+        Self {
+            schemas,
+            local,
+            imported,
+        }
+    }
+
+    /// Get the schema of a type found in the index
+    pub fn schema(&self, idx: usize) -> &'a ExtractedSchema {
+        &self.schemas[idx]
+    }
+
+    /// Find the type a property schema of the package in `dir` references
+    pub fn target(&self, schema: &serde_yaml::Mapping, dir: &Path) -> Option<usize> {
+        if let Some(reference) = schema.get("x-go-ref") {
+            let package = reference.get("package")?.as_str()?;
+            let name = reference.get("type")?.as_str()?;
+            return self
+                .imported
+                .get(&(package.to_string(), name.to_string()))
+                .copied();
+        }
+        let name = schema
+            .get(STRUCT_REF_KEY)
+            .or_else(|| schema.get("x-go-interface-ref"))?
+            .as_str()?;
+        self.local
+            .get(&(dir.to_path_buf(), name.to_string()))
+            .copied()
+    }
+
+    /// Get the name of the type a property schema of the package in `dir`
+    /// references
+    pub fn target_name(&self, schema: &serde_yaml::Mapping, dir: &Path) -> Option<&'a str> {
+        self.target(schema, dir)
+            .map(|idx| self.schemas[idx].name.as_str())
+    }
+
+    /// Find the generated variants of a union of the package in `dir`
+    pub fn variants(&self, schema: &serde_yaml::Mapping, dir: &Path) -> Vec<usize> {
+        schema
+            .get("x-go-union")
+            .and_then(|u| u.get("variants"))
+            .and_then(|v| v.as_sequence())
+            .into_iter()
+            .flatten()
+            .filter_map(|variant| variant.get("name")?.as_str())
+            .filter_map(|name| {
+                self.local
+                    .get(&(dir.to_path_buf(), name.to_string()))
+                    .copied()
+            })
+            .collect()
+    }
+}
+
+/// Get the values of an enum, as literals
+pub fn enum_literals(schema: &serde_yaml::Mapping) -> Option<Vec<String>> {
+    if let Some(values) = schema.get("enum").and_then(|e| e.as_sequence()) {
+        return Some(values.iter().map(literal).collect());
+    }
+    Some(enum_values(schema)?.into_iter().map(literal).collect())
+}
+
+/// Get a value as a JSON literal, which CUE and TypeScript literals are too
+pub fn literal(value: &serde_yaml::Value) -> String {
+    let json: serde_json::Value = serde_json::to_value(value).unwrap_or_default();
+    json.to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_type_index() {
+        let mut address = test_schema("Address", "go_struct", "api/address.go", "{type: object}");
+        address
+            .metadata
+            .insert("import_path".to_string(), "example.com/api".into());
+        let schemas = vec![
+            address,
+            test_schema("Circle", "go_struct", "api/circle.go", "{type: object}"),
+            test_schema("Address", "go_struct", "other/address.go", "{type: object}"),
+        ];
+        let types = TypeIndex::new(&schemas);
+        let mapping =
+            |content: &str| -> serde_yaml::Mapping { serde_yaml::from_str(content).unwrap() };
+
+        let dir = Path::new("api");
+        assert_eq!(
+            types.target(&mapping("{x-go-struct-ref: Address}"), dir),
+            Some(0)
+        );
+        assert_eq!(
+            types.target(&mapping("{x-go-struct-ref: Address}"), Path::new("other")),
+            Some(2)
+        );
+        assert_eq!(
+            types.target_name(
+                &mapping("{x-go-ref: {package: example.com/api, type: Address}}"),
+                Path::new("other")
+            ),
+            Some("Address")
+        );
+        assert_eq!(
+            types.target(&mapping("{x-go-struct-ref: Missing}"), dir),
+            None
+        );
+        assert_eq!(
+            types.variants(
+                &mapping("{x-go-union: {variants: [{name: Circle}, {name: Square}]}}"),
+                dir
+            ),
+            vec![1]
+        );
+    }
+
+    #[test]
+    fn test_enum_literals() {
+        let schema: serde_yaml::Mapping = serde_yaml::from_str("{enum: [a, 1, null]}").unwrap();
+        assert_eq!(
+            enum_literals(&schema),
+            Some(vec![
+                "\"a\"".to_string(),
+                "1".to_string(),
+                "null".to_string()
+            ])
+        );
+        assert_eq!(enum_literals(&serde_yaml::Mapping::new()), None);
+    }
+}
@@ -0,0 +1,142 @@
+//! Types generated for the roots of a source
+//!
+//! With `roots`, only the named types and the types they reference,
+//! transitively, are generated, which keeps the output of large packages to
+//! what a consumer needs. Types are followed by name, as in the dependency
+//! graph: through struct and interface fields, references to other packages,
+//! union variants and named primitive types.
+
+use anyhow::{anyhow, Result};
+use std::collections::BTreeSet;
+
+use super::aliases::PRIMITIVE_TYPE_KEY;
+use super::options::{matches_path, GoAstOptions};
+use crate::depgraph;
+use crate::plugin::ExtractedSchema;
+use crate::report::SKIPPED_SCHEMA_TYPE;
+
+/// Take the types not reachable from the roots out of the schemas
+///
+/// Returned as `go_skipped` schemas, for the report of the run. Fails when
+/// a root names no type.
+pub fn take_unreachable(
+    schemas: &mut Vec<ExtractedSchema>,
+    options: &GoAstOptions,
+) -> Result<Vec<ExtractedSchema>> {
+    if options.roots.is_empty() {
+        return Ok(Vec::new());
+    }
+    let package = |schema: &ExtractedSchema| {
+        schema
+            .metadata
+            .get("package")
+            .and_then(|p| p.as_str())
+            .map(str::to_string)
+    };
+
+    let mut reachable = vec![false; schemas.len()];
+    let mut queue = Vec::new();
+    for root in &options.roots {
+        let mut found = false;
+        for (idx, schema) in schemas.iter().enumerate() {
+            if matches_path(root, package(schema).as_deref(), &schema.name) {
+                found = true;
+                queue.push(idx);
+            }
+        }
+        if !found {
+            return Err(anyhow!("Root {} names no type of the source", root));
+        }
+    }
+
+    let mut followed = BTreeSet::new();
+    while let Some(idx) = queue.pop() {
+        if std::mem::replace(&mut reachable[idx], true) {
+            continue;
+        }
+        let mut names = depgraph::references(&schemas[idx].content);
+        collect_named_types(&schemas[idx].content, &mut names);
+        for name in names {
+            if !followed.insert(name.clone()) {
+                continue;
+            }
+            queue.extend(
+                schemas
+                    .iter()
+                    .enumerate()
+                    .filter(|(_, schema)| schema.name == name)
+                    .map(|(idx, _)| idx),
+            );
+        }
+    }
+
+    let mut unreachable = Vec::new();
+    let mut idx = 0;
+    schemas.retain(|schema| {
+        idx += 1;
+        if reachable[idx - 1] {
+            return true;
+        }
+        let mut content = serde_yaml::Mapping::new();
+        content.insert("reason".into(), "not referenced by roots".into());
+        unreachable.push(ExtractedSchema {
+            name: schema.name.clone(),
+            schema_type: SKIPPED_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::Value::Mapping(content),
+            source_file: schema.source_file.clone(),
+            metadata: schema.metadata.clone(),
+        });
+        false
+    });
+    Ok(unreachable)
+}
+
+/// Add the named primitive types of fields, such as enums
+fn collect_named_types(value: &serde_yaml::Value, names: &mut BTreeSet<String>) {
+    match value {
+        serde_yaml::Value::Mapping(map) => {
+            if let Some(name) = map.get(PRIMITIVE_TYPE_KEY).and_then(|n| n.as_str()) {
+                names.insert(name.to_string());
+            }
+            for value in map.values() {
+                collect_named_types(value, names);
+            }
+        }
+        serde_yaml::Value::Sequence(items) => {
+            for item in items {
+                collect_named_types(item, names);
+            }
+        }
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    #[test]
+    fn test_take_unreachable() {
+        let mut schemas = vec![
+            test_struct("CreateUserRequest", "api", "api/user.go", "{properties: {user: {type: object, x-go-struct-ref: User}}}"),
+            test_struct("User", "api", "api/user.go", "{properties: {role: {type: string, x-go-type: Role}, address: {type: object, x-go-ref: {package: github.com/acme/api/geo, type: Address}}}}"),
+            test_struct("Role", "api", "api/user.go", "{type: string, x-go-enum: {}}"),
+            test_struct("Address", "api", "api/user.go", "{properties: {}}"),
+            test_struct("UserRepository", "api", "api/user.go", "{properties: {}}"),
+            test_struct("Group", "api", "api/user.go", "{properties: {owner: {type: object, x-go-struct-ref: User}}}"),
+        ];
+        let options: GoAstOptions = serde_yaml::from_str("roots: [api.CreateUserRequest]").unwrap();
+
+        let unreachable = take_unreachable(&mut schemas, &options).unwrap();
+        let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(names, vec!["CreateUserRequest", "User", "Role", "Address"]);
+        let names: Vec<&str> = unreachable.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(names, vec!["UserRepository", "Group"]);
+        assert_eq!(unreachable[0].schema_type, SKIPPED_SCHEMA_TYPE);
+        assert_eq!(unreachable[0].metadata["package"], "api");
+
+        let missing: GoAstOptions = serde_yaml::from_str("roots: [User, Team]").unwrap();
+        assert!(take_unreachable(&mut schemas, &missing).is_err());
+    }
+}
@@ -0,0 +1,196 @@
+//! API versions of Kubernetes resources from the package scheme
+//!
+//! Kubebuilder-style packages declare their `GroupVersion` once, usually in
+//! `groupversion_info.go`, and register their kinds with the scheme in the
+//! `init` functions of the type files. The parser returns what each file
+//! declares; `resolve_group_versions` merges it per package and records the
+//! API version on the resources, so generated constructors set `apiVersion`
+//! without a `+groupName` comment in every file.
+
+use std::collections::{BTreeSet, HashMap};
+use std::path::PathBuf;
+
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+use super::package_dir;
+
+/// Schema type of the scheme registrations returned by the parser
+///
+/// These are not types of their own and are removed by
+/// `resolve_group_versions`.
+pub const GROUP_VERSION_SCHEMA_TYPE: &str = "go_group_version";
+
+/// Scheme registration of a package
+#[derive(Default)]
+struct Registration {
+    api_version: Option<String>,
+    kinds: BTreeSet<String>,
+}
+
+/// Record the API version of the package scheme on each Kubernetes resource
+///
+/// Removes the registrations from `schemas` and returns warnings for
+/// resources that are not registered with the scheme of their package, and
+/// for packages registering kinds without a `GroupVersion`.
+pub fn resolve_group_versions(schemas: &mut Vec<ExtractedSchema>) -> Vec<String> {
+    let mut registrations: HashMap<PathBuf, Registration> = HashMap::new();
+    for schema in schemas.iter() {
+        if schema.schema_type != GROUP_VERSION_SCHEMA_TYPE {
+            continue;
+        }
+        let registration = registrations.entry(package_dir(schema)).or_default();
+        let field = |key: &str| schema.content.get(key).and_then(|v| v.as_str());
+        if let (Some(group), Some(version)) = (field("group"), field("version")) {
+            registration.api_version = Some(api_version(group, version));
+        }
+        registration.kinds.extend(
+            schema
+                .content
+                .get("kinds")
+                .and_then(|k| k.as_sequence())
+                .into_iter()
+                .flatten()
+                .filter_map(|k| k.as_str())
+                .map(str::to_string),
+        );
+    }
+    schemas.retain(|schema| schema.schema_type != GROUP_VERSION_SCHEMA_TYPE);
+
+    let mut warnings = Vec::new();
+    let mut dirs: Vec<&PathBuf> = registrations.keys().collect();
+    dirs.sort();
+    for dir in dirs {
+        let registration = &registrations[dir];
+        if registration.api_version.is_none() && !registration.kinds.is_empty() {
+            warnings.push(Code::MissingGroupVersion.warning(format!(
+                "{} registers kinds with its scheme but declares no GroupVersion; their apiVersion is taken from +groupName",
+                dir.display()
+            )));
+        }
+    }
+
+    for schema in schemas.iter_mut() {
+        let Some(registration) = registrations.get(&package_dir(schema)) else {
+            continue;
+        };
+        let name = schema.name.clone();
+        let Some(resource) = schema
+            .content
+            .get_mut("x-go-kubernetes")
+            .and_then(|r| r.as_mapping_mut())
+        else {
+            continue;
+        };
+
+        if !registration.kinds.is_empty() && !registration.kinds.contains(&name) {
+            warnings.push(Code::UnregisteredKind.warning(format!(
+                "{name} is not registered with the scheme of its package; check its SchemeBuilder.Register call"
+            )));
+        }
+        if let Some(api_version) = &registration.api_version {
+            resource.insert("apiVersion".into(), api_version.as_str().into());
+        }
+    }
+
+    warnings
+}
+
+/// Format the API version of a group, which is empty for the core group
+fn api_version(group: &str, version: &str) -> String {
+    if group.is_empty() {
+        version.to_string()
+    } else {
+        format!("{group}/{version}")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_resolve_group_versions() {
+        let mut schemas = vec![
+            test_schema(
+                "Widget",
+                "go_struct",
+                "api/v1/widget_types.go",
+                "{type: object, x-go-kubernetes: {kind: Widget}}",
+            ),
+            test_schema(
+                "Gadget",
+                "go_struct",
+                "api/v1/gadget_types.go",
+                "{type: object, x-go-kubernetes: {apiVersion: old.example.com/v1, kind: Gadget}}",
+            ),
+            test_schema(
+                "Pod",
+                "go_struct",
+                "core/v1/types.go",
+                "{type: object, x-go-kubernetes: {kind: Pod}}",
+            ),
+            test_schema(
+                "v1",
+                GROUP_VERSION_SCHEMA_TYPE,
+                "api/v1/groupversion_info.go",
+                "{group: example.com, kinds: [], version: v1}",
+            ),
+            test_schema(
+                "v1",
+                GROUP_VERSION_SCHEMA_TYPE,
+                "api/v1/widget_types.go",
+                "{kinds: [Widget, WidgetList]}",
+            ),
+            test_schema(
+                "v1",
+                GROUP_VERSION_SCHEMA_TYPE,
+                "core/v1/register.go",
+                "{group: '', kinds: [Pod], version: v1}",
+            ),
+        ];
+
+        let warnings = resolve_group_versions(&mut schemas);
+        assert_eq!(schemas.len(), 3);
+
+        let api_version = |idx: usize| {
+            schemas[idx].content["x-go-kubernetes"]["apiVersion"]
+                .as_str()
+                .map(str::to_string)
+        };
+        assert_eq!(api_version(0).as_deref(), Some("example.com/v1"));
+        // GroupVersion wins over +groupName
+        assert_eq!(api_version(1).as_deref(), Some("example.com/v1"));
+        assert_eq!(api_version(2).as_deref(), Some("v1"));
+
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].starts_with("GS0502: Gadget is not registered"));
+    }
+
+    #[test]
+    fn test_resolve_group_versions_without_group_version() {
+        let mut schemas = vec![
+            test_schema(
+                "Widget",
+                "go_struct",
+                "api/v1/widget_types.go",
+                "{type: object, x-go-kubernetes: {apiVersion: example.com/v1, kind: Widget}}",
+            ),
+            test_schema(
+                "v1",
+                GROUP_VERSION_SCHEMA_TYPE,
+                "api/v1/widget_types.go",
+                "{kinds: [Widget]}",
+            ),
+        ];
+
+        let warnings = resolve_group_versions(&mut schemas);
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].contains("declares no GroupVersion"));
+        assert_eq!(
+            schemas[0].content["x-go-kubernetes"]["apiVersion"].as_str(),
+            Some("example.com/v1")
+        );
+    }
+}
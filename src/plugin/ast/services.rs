@@ -0,0 +1,338 @@
+//! Request and response shapes of service interfaces
+//!
+//! With the `services` option, every interface with exported methods, such
+//! as a hand-written `UserService` or the `UserServiceClient` that
+//! protoc-gen-go-grpc generates for a proto service, is also written to
+//! `services/<type>.libsonnet`. The library describes each method by the
+//! shape of its request and response, with types by name, and builds the
+//! calls and mocks that API tests and mock servers are configured with:
+//!
+//! ```jsonnet
+//! local users = import "services/userservice.libsonnet";
+//!
+//! users.call(users.methods.getUser, { name: "ada" }, { name: "ada" })
+//! + users.mock(users.methods.listUsers, [])
+//! ```
+//!
+//! Requests checked against an object shape may only set its fields, and
+//! `requests` builds them from the parameters of the Go method
+//! (`users.requests.rename('ada', 'grace')`).
+//!
+//! `services/catalog.json` lists the methods of every service with the Go
+//! types of their parameters and results, for tools outside Jsonnet.
+
+use serde_json::json;
+
+use super::aliases::STRUCT_REF_KEY;
+use super::generator::{field_key, is_identifier, quote_string};
+use super::parser::lower_camel;
+use crate::plugin::ExtractedSchema;
+
+/// Directory of the service libraries in the output directory
+pub const SERVICES_DIR: &str = "services";
+
+/// File name of the method catalog, in `SERVICES_DIR`
+pub const CATALOG_FILE: &str = "catalog.json";
+
+/// Method signatures of an interface, when it has any
+fn signatures(schema: &ExtractedSchema) -> Vec<&serde_yaml::Value> {
+    schema
+        .content
+        .get("x-go-interface")
+        .and_then(|i| i.get("signatures"))
+        .and_then(|s| s.as_sequence())
+        .into_iter()
+        .flatten()
+        .collect()
+}
+
+/// File name of the library of a service, in `SERVICES_DIR`
+fn library_file(schema: &ExtractedSchema) -> String {
+    format!("{}.libsonnet", schema.name.to_lowercase())
+}
+
+/// Generate the library of each interface with method signatures
+///
+/// Returns (file name in `SERVICES_DIR`, code) pairs.
+pub fn generate_services(schemas: &[ExtractedSchema]) -> Vec<(String, String)> {
+    let mut services = Vec::new();
+    for schema in schemas {
+        let signatures = signatures(schema);
+        if signatures.is_empty() {
+            continue;
+        }
+
+        let mut code = format!("// Generated from Go AST: {} methods\n{{\n", schema.name);
+        code.push_str(&format!("  service:: {},\n\n", quote_string(&schema.name)));
+        code.push_str("  // Request and response of each method\n");
+        code.push_str("  methods:: {\n");
+        for signature in &signatures {
+            let Some(name) = signature.get("name").and_then(|n| n.as_str()) else {
+                continue;
+            };
+            if let Some(description) = signature.get("description").and_then(|d| d.as_str()) {
+                for line in description.lines() {
+                    code.push_str(&format!("    // {line}\n"));
+                }
+            }
+            code.push_str(&format!("    {}: {{\n", field_key(&lower_camel(name))));
+            code.push_str(&format!(
+                "      method: {},\n",
+                quote_string(&format!("{}.{}", schema.name, name))
+            ));
+            let shape_of = |key: &str| match signature.get(key) {
+                Some(value) if !value.is_null() => shape(value),
+                _ => "null".to_string(),
+            };
+            code.push_str(&format!("      request: {},\n", shape_of("request")));
+            code.push_str(&format!("      response: {},\n", shape_of("response")));
+            code.push_str("    },\n");
+        }
+        code.push_str("  },\n");
+
+        code.push_str("\n  // Request of each method, built from its parameters\n");
+        code.push_str("  requests:: {\n");
+        for signature in &signatures {
+            let Some(name) = signature.get("name").and_then(|n| n.as_str()) else {
+                continue;
+            };
+            code.push_str(&format!(
+                "    {}\n",
+                request_builder(&lower_camel(name), signature.get("request"))
+            ));
+        }
+        code.push_str("  },\n");
+
+        code.push_str(
+            "\n  // A call of a method, with the request sent and the response expected\n",
+        );
+        code.push_str("  call(method, request, response=null)::\n");
+        code.push_str("    assert !std.isObject(method.request) || std.length(std.setDiff(std.objectFields(request), std.objectFields(method.request))) == 0 :\n");
+        code.push_str("           'request of %s sets fields not in %s' % [method.method, std.objectFields(method.request)];\n");
+        code.push_str("    { method: method.method, request: request, response: response },\n");
+        code.push_str("\n  // A mock of a method, answering every request with a response\n");
+        code.push_str(
+            "  mock(method, response):: { method: method.method, response: response },\n",
+        );
+        code.push_str("}\n");
+
+        services.push((library_file(schema), code));
+    }
+    services
+}
+
+/// Render the builder of the request of a method
+///
+/// Requests of several parameters take them in order and return the object
+/// of the parameters; struct requests are passed through.
+fn request_builder(method: &str, request: Option<&serde_yaml::Value>) -> String {
+    let Some(properties) = request
+        .filter(|r| r.get(STRUCT_REF_KEY).is_none() && r.get("x-go-ref").is_none())
+        .and_then(|r| r.get("properties"))
+        .and_then(|p| p.as_mapping())
+    else {
+        return format!("{}(request):: request,", field_key(method));
+    };
+
+    let names: Vec<&str> = properties.keys().filter_map(|k| k.as_str()).collect();
+    let params: Vec<String> = names
+        .iter()
+        .enumerate()
+        .map(|(idx, name)| match is_identifier(name) {
+            true => name.to_string(),
+            false => format!("arg{idx}"),
+        })
+        .collect();
+    let fields: Vec<String> = names
+        .iter()
+        .zip(&params)
+        .map(|(name, param)| format!("{}: {}", field_key(name), param))
+        .collect();
+    let object = match fields.is_empty() {
+        true => "{}".to_string(),
+        false => format!("{{ {} }}", fields.join(", ")),
+    };
+    format!("{}({}):: {},", field_key(method), params.join(", "), object)
+}
+
+/// Generate the catalog of the methods of every service
+///
+/// Lists each method with its parameters and results, named when the Go
+/// method names them, and their Go types. Returns `None` without services.
+pub fn generate_catalog(schemas: &[ExtractedSchema]) -> Option<String> {
+    let services: Vec<serde_json::Value> = schemas
+        .iter()
+        .filter_map(|schema| {
+            let signatures = signatures(schema);
+            if signatures.is_empty() {
+                return None;
+            }
+            let methods: Vec<serde_json::Value> = signatures
+                .iter()
+                .filter_map(|signature| {
+                    let name = signature.get("name")?.as_str()?;
+                    let mut method = json!({
+                        "name": name,
+                        "params": go_types(signature.get("params")),
+                        "results": go_types(signature.get("results")),
+                    });
+                    if let Some(description) = signature.get("description").and_then(|d| d.as_str())
+                    {
+                        method["description"] = description.into();
+                    }
+                    Some(method)
+                })
+                .collect();
+            Some(json!({
+                "name": schema.name,
+                "library": format!("{}/{}", SERVICES_DIR, library_file(schema)),
+                "methods": methods,
+            }))
+        })
+        .collect();
+    if services.is_empty() {
+        return None;
+    }
+    let catalog = json!({ "services": services });
+    Some(serde_json::to_string_pretty(&catalog).unwrap_or_default() + "\n")
+}
+
+/// Parameters or results of a signature, as `{name, type}` objects
+fn go_types(values: Option<&serde_yaml::Value>) -> Vec<serde_json::Value> {
+    values
+        .and_then(|v| v.as_sequence())
+        .into_iter()
+        .flatten()
+        .filter_map(|value| {
+            let go_type = value.get("type")?.as_str()?;
+            Some(match value.get("name").and_then(|n| n.as_str()) {
+                Some(name) => json!({ "name": name, "type": go_type }),
+                None => json!({ "type": go_type }),
+            })
+        })
+        .collect()
+}
+
+/// Render the shape of a schema: types by name, arrays as `[item]`, maps
+/// as `{ "[string]": value }` and objects by field
+fn shape(schema: &serde_yaml::Value) -> String {
+    for key in [STRUCT_REF_KEY, "x-go-interface-ref"] {
+        if let Some(name) = schema.get(key).and_then(|n| n.as_str()) {
+            return quote_string(name);
+        }
+    }
+    if let Some(name) = schema
+        .get("x-go-ref")
+        .and_then(|r| r.get("type"))
+        .and_then(|t| t.as_str())
+    {
+        return quote_string(name);
+    }
+
+    match schema.get("type").and_then(|t| t.as_str()) {
+        Some("array") => match schema.get("items") {
+            Some(items) => format!("[{}]", shape(items)),
+            None => "[]".to_string(),
+        },
+        Some("object") => {
+            if let Some(properties) = schema.get("properties").and_then(|p| p.as_mapping()) {
+                let fields: Vec<String> = properties
+                    .iter()
+                    .filter_map(|(name, property)| {
+                        Some(format!(
+                            "{}: {}",
+                            field_key(name.as_str()?),
+                            shape(property)
+                        ))
+                    })
+                    .collect();
+                return match fields.is_empty() {
+                    true => "{}".to_string(),
+                    false => format!("{{ {} }}", fields.join(", ")),
+                };
+            }
+            match schema.get("additionalProperties") {
+                Some(values) if values.is_mapping() => {
+                    format!("{{ \"[string]\": {} }}", shape(values))
+                }
+                _ => quote_string("object"),
+            }
+        }
+        Some(primitive) => quote_string(primitive),
+        None => quote_string("any"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_generate_services() {
+        let schemas = vec![
+            ExtractedSchema {
+                name: "UserService".to_string(),
+                schema_type: "go_struct".to_string(),
+                content: serde_yaml::from_str(
+                    r#"
+type: object
+x-go-interface:
+  methods: [CreateUser, GetUser, ListUsers]
+  signatures:
+    - name: CreateUser
+      description: CreateUser creates a new user
+      params: [{name: ctx, type: context.Context}, {name: user, type: "*User"}]
+      results: [{type: error}]
+      request: {type: object, x-go-struct-ref: User}
+      response: null
+    - {name: GetUser, request: {type: object, properties: {name: {type: string}}}, response: {type: object, nullable: true, x-go-struct-ref: User}}
+    - {name: ListUsers, request: {type: object, properties: {}}, response: {type: array, items: {type: object, x-go-struct-ref: User}}}
+    - {name: Rename, request: {type: object, properties: {from: {type: string}, local: {type: string}}}, response: null}
+"#,
+                )
+                .unwrap(),
+                source_file: "service.go".into(),
+                metadata: HashMap::new(),
+            },
+            ExtractedSchema {
+                name: "Shape".to_string(),
+                schema_type: "go_struct".to_string(),
+                content: serde_yaml::from_str("{type: object, x-go-interface: {methods: [Area]}}")
+                    .unwrap(),
+                source_file: "shape.go".into(),
+                metadata: HashMap::new(),
+            },
+        ];
+
+        let services = generate_services(&schemas);
+        assert_eq!(services.len(), 1);
+        let (file, code) = &services[0];
+        assert_eq!(file, "userservice.libsonnet");
+        assert!(code.contains(
+            "    // CreateUser creates a new user\n    createUser: {\n      method: \"UserService.CreateUser\",\n      request: \"User\",\n      response: null,\n    },\n"
+        ));
+        assert!(code.contains("      request: { name: \"string\" },\n      response: \"User\",\n"));
+        assert!(code.contains("      request: {},\n      response: [\"User\"],\n"));
+        assert!(code.contains(
+            "  requests:: {\n    createUser(request):: request,\n    getUser(name):: { name: name },\n    listUsers():: {},\n    rename(from, arg1):: { from: from, \"local\": arg1 },\n  },\n"
+        ));
+
+        let catalog: serde_json::Value =
+            serde_json::from_str(&generate_catalog(&schemas).unwrap()).unwrap();
+        let service = &catalog["services"][0];
+        assert_eq!(service["name"], "UserService");
+        assert_eq!(service["library"], "services/userservice.libsonnet");
+        assert_eq!(
+            service["methods"][0],
+            json!({
+                "name": "CreateUser",
+                "description": "CreateUser creates a new user",
+                "params": [{"name": "ctx", "type": "context.Context"}, {"name": "user", "type": "*User"}],
+                "results": [{"type": "error"}],
+            })
+        );
+        assert_eq!(service["methods"].as_array().unwrap().len(), 4);
+        assert!(generate_catalog(&schemas[1..]).is_none());
+    }
+}
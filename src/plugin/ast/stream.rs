@@ -0,0 +1,215 @@
+//! Package-by-package processing of Go AST sources
+//!
+//! With the `streaming` option, a source is parsed, resolved and rendered
+//! one package directory at a time instead of as a whole, so the peak
+//! memory use is bounded by the largest package rather than the module.
+//! Packages are processed after the packages of the source they import, so
+//! references to other packages are resolved as in a full run; Go forbids
+//! import cycles. Once its libraries are written, only an outline of each
+//! type, without descriptions, is kept to build the index and the other
+//! files covering every type.
+
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::{Path, PathBuf};
+
+use super::gomod;
+use crate::plugin::ExtractedSchema;
+
+/// Group Go files by package directory, packages imported by the others first
+///
+/// Packages without an order between them are sorted by directory.
+pub fn package_batches(repo_path: &Path, go_files: &[PathBuf]) -> Vec<Vec<PathBuf>> {
+    let mut dirs: BTreeMap<PathBuf, Vec<PathBuf>> = BTreeMap::new();
+    for file in go_files {
+        let dir = file.parent().unwrap_or(repo_path).to_path_buf();
+        dirs.entry(dir).or_default().push(file.clone());
+    }
+
+    let import_paths: BTreeMap<String, PathBuf> = dirs
+        .keys()
+        .filter_map(|dir| Some((gomod::import_path(repo_path, dir)?, dir.clone())))
+        .collect();
+    let dependencies: BTreeMap<PathBuf, BTreeSet<PathBuf>> = dirs
+        .iter()
+        .map(|(dir, files)| {
+            let imported = files
+                .iter()
+                .filter_map(|file| std::fs::read_to_string(file).ok())
+                .flat_map(|code| go_imports(&code))
+                .filter_map(|import| import_paths.get(&import))
+                .filter(|imported| *imported != dir)
+                .cloned()
+                .collect();
+            (dir.clone(), imported)
+        })
+        .collect();
+
+    let mut ordered = Vec::new();
+    let mut visited = BTreeSet::new();
+    for dir in dirs.keys() {
+        visit(dir, &dependencies, &mut visited, &mut ordered);
+    }
+    ordered
+        .into_iter()
+        .filter_map(|dir| dirs.remove(&dir))
+        .collect()
+}
+
+/// Add a directory after the directories it imports
+fn visit(
+    dir: &PathBuf,
+    dependencies: &BTreeMap<PathBuf, BTreeSet<PathBuf>>,
+    visited: &mut BTreeSet<PathBuf>,
+    ordered: &mut Vec<PathBuf>,
+) {
+    if !visited.insert(dir.clone()) {
+        return;
+    }
+    for dependency in dependencies.get(dir).into_iter().flatten() {
+        visit(dependency, dependencies, visited, ordered);
+    }
+    ordered.push(dir.clone());
+}
+
+/// Import paths of a Go file
+///
+/// Only the import declarations are read, which precede the other
+/// declarations of a file.
+pub fn go_imports(code: &str) -> Vec<String> {
+    let mut imports = Vec::new();
+    let mut in_block = false;
+    for line in code.lines() {
+        let line = line.split("//").next().unwrap_or(line).trim();
+        if in_block {
+            if line.starts_with(')') {
+                in_block = false;
+            } else {
+                imports.extend(quoted(line));
+            }
+        } else if let Some(rest) = line.strip_prefix("import") {
+            let rest = rest.trim_start();
+            if let Some(block) = rest.strip_prefix('(') {
+                in_block = !block.contains(')');
+                imports.extend(quoted(block));
+            } else {
+                imports.extend(quoted(rest));
+            }
+        } else if ["func ", "type ", "var ", "const "]
+            .iter()
+            .any(|keyword| line.starts_with(keyword))
+        {
+            break;
+        }
+    }
+    imports
+}
+
+/// The first string literal of a line
+fn quoted(line: &str) -> Option<String> {
+    let start = line.find('"')? + 1;
+    let length = line[start..].find('"')?;
+    Some(line[start..start + length].to_string())
+}
+
+/// Outline of a type kept once its libraries are written
+///
+/// The descriptions of the type and of its fields, usually the largest part
+/// of a schema, are removed; everything else is kept.
+pub fn outline(mut schema: ExtractedSchema) -> ExtractedSchema {
+    strip_descriptions(&mut schema.content);
+    schema
+}
+
+fn strip_descriptions(schema: &mut serde_yaml::Value) {
+    let Some(map) = schema.as_mapping_mut() else {
+        return;
+    };
+    if map.get("description").is_some_and(|d| d.is_string()) {
+        map.remove("description");
+    }
+    if let Some(properties) = map.get_mut("properties").and_then(|p| p.as_mapping_mut()) {
+        for property in properties.values_mut() {
+            strip_descriptions(property);
+        }
+    }
+    for key in ["items", "additionalProperties"] {
+        if let Some(inner) = map.get_mut(key) {
+            strip_descriptions(inner);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_go_imports() {
+        let code = "package api\n\nimport \"fmt\"\nimport (\n\t\"time\" // durations\n\tmeta \"github.com/acme/api/meta\"\n)\n\ntype User struct {\n\tName string `json:\"name\"`\n}\n\nimport \"late\"\n";
+        assert_eq!(
+            go_imports(code),
+            vec!["fmt", "time", "github.com/acme/api/meta"]
+        );
+        assert_eq!(go_imports("package api\nimport (\"os\")\n"), vec!["os"]);
+    }
+
+    #[test]
+    fn test_package_batches() {
+        let dir = tempfile::tempdir().unwrap();
+        let repo = dir.path();
+        std::fs::write(repo.join("go.mod"), "module github.com/acme/api\n").unwrap();
+        for (file, code) in [
+            (
+                "a/user.go",
+                "package a\n\nimport \"github.com/acme/api/z\"\n",
+            ),
+            ("a/group.go", "package a\n"),
+            ("m/meta.go", "package m\n"),
+            (
+                "z/time.go",
+                "package z\n\nimport \"github.com/acme/api/m\"\n",
+            ),
+        ] {
+            std::fs::create_dir_all(repo.join(file).parent().unwrap()).unwrap();
+            std::fs::write(repo.join(file), code).unwrap();
+        }
+        let files: Vec<PathBuf> = ["a/user.go", "a/group.go", "m/meta.go", "z/time.go"]
+            .iter()
+            .map(|file| repo.join(file))
+            .collect();
+
+        let batches = package_batches(repo, &files);
+        assert_eq!(
+            batches,
+            vec![
+                vec![repo.join("m/meta.go")],
+                vec![repo.join("z/time.go")],
+                vec![repo.join("a/user.go"), repo.join("a/group.go")],
+            ]
+        );
+    }
+
+    #[test]
+    fn test_outline() {
+        let schema = ExtractedSchema {
+            name: "User".to_string(),
+            schema_type: "go_struct".to_string(),
+            content: serde_yaml::from_str(
+                "{description: A user, properties: {description: {description: About the user, type: string}, tags: {items: {description: A tag, type: string}, type: array}}, type: object}",
+            )
+            .unwrap(),
+            source_file: PathBuf::from("user.go"),
+            metadata: HashMap::new(),
+        };
+
+        let outline = outline(schema);
+        assert_eq!(
+            outline.content,
+            serde_yaml::from_str::<serde_yaml::Value>(
+                "{properties: {description: {type: string}, tags: {items: {type: string}, type: array}}, type: object}"
+            )
+            .unwrap()
+        );
+    }
+}
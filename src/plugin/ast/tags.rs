@@ -0,0 +1,163 @@
+//! Go struct tag parsing
+//!
+//! Implements the `key:"value" key2:"value2"` convention used by
+//! `reflect.StructTag`, plus helpers for the comma-separated option syntax
+//! shared by the `json` and `yaml` encoders.
+
+/// Parsed Go struct tag
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct StructTag {
+    /// Tag entries in declaration order
+    entries: Vec<(String, String)>,
+}
+
+impl StructTag {
+    /// Parse the contents of a struct tag (without the surrounding backticks)
+    pub fn parse(raw: &str) -> Self {
+        let mut entries = Vec::new();
+        let mut rest = raw.trim_matches('`');
+
+        loop {
+            rest = rest.trim_start();
+            if rest.is_empty() {
+                break;
+            }
+
+            // Key runs until the colon; spaces, quotes and control characters are invalid
+            let key_end = match rest.find(|c: char| c == ':' || c == '"' || c.is_whitespace()) {
+                Some(idx) => idx,
+                None => break,
+            };
+            if key_end == 0 || !rest[key_end..].starts_with(":\"") {
+                break;
+            }
+            let key = &rest[..key_end];
+            rest = &rest[key_end + 2..];
+
+            // Quoted value, honouring backslash escapes
+            let mut value = String::new();
+            let mut escaped = false;
+            let mut closed = None;
+            for (idx, c) in rest.char_indices() {
+                if escaped {
+                    value.push(c);
+                    escaped = false;
+                } else if c == '\\' {
+                    escaped = true;
+                } else if c == '"' {
+                    closed = Some(idx);
+                    break;
+                } else {
+                    value.push(c);
+                }
+            }
+
+            match closed {
+                Some(idx) => {
+                    entries.push((key.to_string(), value));
+                    rest = &rest[idx + 1..];
+                }
+                None => break,
+            }
+        }
+
+        Self { entries }
+    }
+
+    /// Get the raw value for a tag key
+    pub fn get(&self, key: &str) -> Option<&str> {
+        self.entries
+            .iter()
+            .find(|(k, _)| k == key)
+            .map(|(_, v)| v.as_str())
+    }
+
+    /// Get the name part of a tag value (before the first comma), if non-empty
+    pub fn name(&self, key: &str) -> Option<&str> {
+        self.get(key)
+            .map(|v| v.split(',').next().unwrap_or_default())
+            .filter(|name| !name.is_empty() && *name != "-")
+    }
+
+    /// Check whether a tag value carries the given option (e.g. `omitempty`)
+    pub fn has_option(&self, key: &str, option: &str) -> bool {
+        self.get(key)
+            .map(|v| v.split(',').skip(1).any(|o| o.trim() == option))
+            .unwrap_or(false)
+    }
+
+    /// Get the options of a tag value (after the name), such as `omitempty`
+    pub fn options(&self, key: &str) -> impl Iterator<Item = &str> {
+        self.get(key)
+            .into_iter()
+            .flat_map(|v| v.split(',').skip(1))
+            .map(str::trim)
+            .filter(|o| !o.is_empty())
+    }
+
+    /// Check whether a rule list such as `validate:"required,email"` contains a rule
+    pub fn has_rule(&self, key: &str, rule: &str) -> bool {
+        self.get(key)
+            .map(|v| v.split(',').any(|r| r.trim() == rule))
+            .unwrap_or(false)
+    }
+
+    /// Check whether the field is excluded from the encoding (`json:"-"`)
+    pub fn is_ignored(&self, key: &str) -> bool {
+        self.get(key) == Some("-")
+    }
+
+    /// Get the keys of the tag, in declaration order
+    pub fn keys(&self) -> impl Iterator<Item = &str> {
+        self.entries.iter().map(|(k, _)| k.as_str())
+    }
+
+    /// Check whether the tag is empty
+    pub fn is_empty(&self) -> bool {
+        self.entries.is_empty()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_multiple_keys() {
+        let tag = StructTag::parse(r#"json:"name,omitempty" validate:"required""#);
+        assert_eq!(tag.get("json"), Some("name,omitempty"));
+        assert_eq!(tag.get("validate"), Some("required"));
+        assert_eq!(tag.name("json"), Some("name"));
+        assert!(tag.has_option("json", "omitempty"));
+        assert!(!tag.has_option("validate", "omitempty"));
+        assert!(tag.has_rule("validate", "required"));
+        assert!(!tag.has_rule("json", "omitempty,"));
+        assert_eq!(tag.options("json").collect::<Vec<_>>(), vec!["omitempty"]);
+        assert_eq!(tag.options("yaml").count(), 0);
+        assert_eq!(tag.keys().collect::<Vec<_>>(), vec!["json", "validate"]);
+    }
+
+    #[test]
+    fn test_parse_inline_and_ignored() {
+        let tag = StructTag::parse(r#"json:",inline" yaml:",inline""#);
+        assert_eq!(tag.name("json"), None);
+        assert!(tag.has_option("yaml", "inline"));
+
+        let ignored = StructTag::parse(r#"json:"-""#);
+        assert!(ignored.is_ignored("json"));
+        assert_eq!(ignored.name("json"), None);
+    }
+
+    #[test]
+    fn test_parse_backticks_and_escapes() {
+        let tag = StructTag::parse(r#"`default:"say \"hi\"" json:"greeting"`"#);
+        assert_eq!(tag.get("default"), Some(r#"say "hi""#));
+        assert_eq!(tag.name("json"), Some("greeting"));
+    }
+
+    #[test]
+    fn test_parse_malformed() {
+        let tag = StructTag::parse("not a tag");
+        assert!(tag.is_empty());
+    }
+}
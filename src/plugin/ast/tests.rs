@@ -2,6 +2,7 @@
 
 use super::*;
 use crate::plugin::{Plugin, PluginCapability, PluginConfig, PluginContext};
+use std::path::{Path, PathBuf};
 use tempfile::TempDir;
 
 #[tokio::test]
@@ -243,3 +244,3007 @@ type TestStruct struct {
     assert_eq!(result.statistics.files_processed, 1);
     assert_eq!(result.statistics.schemas_extracted, 1);
 }
+
+#[tokio::test]
+async fn test_go_ast_parser_inline_embedding() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package main
+
+type Base struct {
+    ID   string `json:"id"`
+    Kind string `json:"kind,omitempty"`
+}
+
+type Extra struct {
+    Note string `yaml:"note"`
+}
+
+type Resource struct {
+    Base
+    Extra Extra  `yaml:",inline"`
+    Named Base   `json:"named"`
+    Name  string `json:"name"`
+    Skip  string `json:"-"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("test.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let resource = schemas.iter().find(|s| s.name == "Resource").unwrap();
+
+    let properties = resource
+        .content
+        .get("properties")
+        .and_then(|p| p.as_mapping())
+        .unwrap();
+    let keys: Vec<&str> = properties.keys().filter_map(|k| k.as_str()).collect();
+    assert_eq!(keys, vec!["named", "name", "id", "kind", "note"]);
+
+    let required: Vec<&str> = resource
+        .content
+        .get("required")
+        .and_then(|r| r.as_sequence())
+        .unwrap()
+        .iter()
+        .filter_map(|r| r.as_str())
+        .collect();
+    assert!(required.contains(&"id"));
+    assert!(!required.contains(&"kind"));
+
+    let code = GoJsonnetGenerator::new().generate(resource).unwrap();
+    assert!(code.contains("withId(id)"));
+    assert!(code.contains("withNote(note)"));
+    assert!(!code.contains("withBase"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_unresolved_inline_type() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package v1
+
+type Widget struct {
+    metav1.TypeMeta   `json:",inline"`
+    Spec WidgetSpec `json:"spec"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("widget.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let widget = schemas.iter().find(|s| s.name == "Widget").unwrap();
+
+    let unresolved = widget
+        .content
+        .get("x-go-embedded")
+        .and_then(|e| e.as_sequence())
+        .unwrap();
+    assert_eq!(unresolved[0].as_str(), Some("metav1.TypeMeta"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_type_mappings() {
+    let options: GoAstOptions = serde_yaml::from_str(
+        r#"
+type_mappings:
+  time.Duration:
+    type: string
+    pattern: "^[0-9]+(ms|s|m|h)$"
+  github.com/acme/foo.MoneyAmount:
+    type: number
+"#,
+    )
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+
+    let test_content = r#"
+package billing
+
+import (
+    "time"
+
+    money "github.com/acme/foo"
+)
+
+type Invoice struct {
+    Timeout  time.Duration      `json:"timeout"`
+    Retries  []time.Duration    `json:"retries"`
+    Total    *money.MoneyAmount `json:"total"`
+    Currency string             `json:"currency"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("invoice.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let invoice = schemas.iter().find(|s| s.name == "Invoice").unwrap();
+    let properties = invoice.content.get("properties").unwrap();
+
+    let timeout = properties.get("timeout").unwrap();
+    assert_eq!(timeout.get("type").and_then(|t| t.as_str()), Some("string"));
+    assert!(timeout.get("pattern").is_some());
+
+    let retries = properties.get("retries").unwrap();
+    assert_eq!(
+        retries
+            .get("items")
+            .and_then(|i| i.get("pattern"))
+            .and_then(|p| p.as_str()),
+        Some("^[0-9]+(ms|s|m|h)$")
+    );
+
+    let total = properties.get("total").unwrap();
+    assert_eq!(total.get("type").and_then(|t| t.as_str()), Some("number"));
+
+    let code = GoJsonnetGenerator::new().generate(invoice).unwrap();
+    assert!(code.contains("assert matchesPattern(\"^[0-9]+(ms|s|m|h)$\", timeout)"));
+    assert!(!code.contains("std.regexMatch"));
+
+    // The checks run on the standard library alone
+    let files = [("invoice.libsonnet", code.as_str())];
+    let evaluate = |call: &str| {
+        crate::plugin::test_evaluate(
+            &files,
+            &format!("local invoice = import 'invoice.libsonnet'; {call}"),
+        )
+    };
+    if let Some(result) = evaluate("invoice.new().withTimeout('30s').timeout") {
+        assert_eq!(result.unwrap(), "\"30s\"");
+        let error = evaluate("invoice.new().withTimeout('30x')")
+            .unwrap()
+            .unwrap_err();
+        assert!(error
+            .to_string()
+            .contains("timeout must match pattern ^[0-9]+(ms|s|m|h)$"));
+    }
+
+    // With pattern_checks: native, through the regexMatch native function
+    let options: GoAstOptions = serde_yaml::from_str(concat!(
+        "pattern_checks: native\n",
+        "type_mappings: {time.Duration: {type: string, pattern: \"^[0-9]+(ms|s|m|h)$\"}}\n",
+    ))
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("invoice.go"))
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let invoice = schemas.iter().find(|s| s.name == "Invoice").unwrap();
+    let code = GoJsonnetGenerator::new().generate(invoice).unwrap();
+    assert!(code.contains("std.native('regexMatch')(pattern, value)"));
+    assert!(!code.contains("std.regexMatch"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_constructor_defaults() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package repo
+
+type Config struct {
+    Host    string            `json:"host"`
+    Port    int               `json:"port" default:"9090"`
+    Timeout float64           `json:"timeout"`
+    Labels  map[string]string `json:"labels"`
+    Tags    []string          `json:"tags"`
+    Debug   bool              `json:"debug"`
+    Client  *Client           `json:"-"`
+}
+
+func NewConfig() *Config {
+    return &Config{
+        Host:    "localhost",
+        Port:    8080,
+        Timeout: 2.5,
+        Labels:  make(map[string]string),
+        Tags:    []string{"a", "b"},
+        Client:  newClient(),
+    }
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("config.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let config = schemas.iter().find(|s| s.name == "Config").unwrap();
+    let properties = config.content.get("properties").unwrap();
+    let default = |name: &str| properties.get(name).and_then(|p| p.get("default")).cloned();
+
+    assert_eq!(default("host"), Some(serde_yaml::Value::from("localhost")));
+    // The struct tag takes precedence over the constructor
+    assert_eq!(default("port"), Some(serde_yaml::Value::from(9090)));
+    assert_eq!(default("timeout"), Some(serde_yaml::Value::from(2.5)));
+    assert_eq!(
+        default("labels"),
+        Some(serde_yaml::Value::Mapping(serde_yaml::Mapping::new()))
+    );
+    assert_eq!(
+        default("tags"),
+        Some(serde_yaml::Value::Sequence(vec!["a".into(), "b".into()]))
+    );
+    assert_eq!(default("debug"), None);
+
+    let code = GoJsonnetGenerator::new().generate(config).unwrap();
+    assert!(code.contains("host: \"localhost\","));
+    assert!(code.contains("labels: {},"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_time_types() {
+    let options: GoAstOptions = serde_yaml::from_str("now_placeholder: \"${NOW}\"").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+
+    let test_content = r#"
+package events
+
+import "time"
+
+type Event struct {
+    CreatedAt time.Time     `json:"createdAt"`
+    DeletedAt *time.Time    `json:"deletedAt,omitempty"`
+    Timeout   time.Duration `json:"timeout"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("event.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let event = schemas.iter().find(|s| s.name == "Event").unwrap();
+    let properties = event.content.get("properties").unwrap();
+    let format = |name: &str| {
+        properties
+            .get(name)
+            .and_then(|p| p.get("format"))
+            .and_then(|f| f.as_str())
+    };
+
+    assert_eq!(format("createdAt"), Some("date-time"));
+    assert_eq!(format("deletedAt"), Some("date-time"));
+    assert_eq!(format("timeout"), Some("duration"));
+    assert_eq!(
+        properties
+            .get("createdAt")
+            .and_then(|p| p.get("type"))
+            .and_then(|t| t.as_str()),
+        Some("string")
+    );
+
+    let code = GoJsonnetGenerator::new().generate(event).unwrap();
+    assert!(code.contains("local isDateTime(value) ="));
+    assert!(code.contains("local isDuration(value) ="));
+    assert!(code.contains("assert isDateTime(createdAt)"));
+    assert!(code.contains("assert isDuration(timeout)"));
+    assert!(code.contains("withCreatedAtNow():: self + { createdAt: \"${NOW}\" },"));
+    assert!(!code.contains("std.regexMatch"));
+
+    // The checks run on the standard library alone
+    let files = [("event.libsonnet", code.as_str())];
+    let evaluate = |call: &str| {
+        crate::plugin::test_evaluate(
+            &files,
+            &format!("local event = import 'event.libsonnet'; {call}"),
+        )
+    };
+    if let Some(result) = evaluate(
+        "event.new().withCreatedAt('2024-05-01T12:30:00.5+02:00').withTimeout('1h30m').timeout",
+    ) {
+        assert_eq!(result.unwrap(), "\"1h30m\"");
+        for (call, message) in [
+            (
+                "event.new().withCreatedAt('2024-05-01 12:30')",
+                "createdAt must be an RFC3339 timestamp",
+            ),
+            (
+                "event.new().withTimeout('90 seconds')",
+                "timeout must be a duration such as 30s or 5m",
+            ),
+        ] {
+            let error = evaluate(call).unwrap().unwrap_err();
+            assert!(error.to_string().contains(message), "{call}: {error}");
+        }
+    }
+
+    // With pattern_checks: native, through the regexMatch native function
+    let options: GoAstOptions = serde_yaml::from_str("pattern_checks: native").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("event.go"))
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let event = schemas.iter().find(|s| s.name == "Event").unwrap();
+    let code = GoJsonnetGenerator::new().generate(event).unwrap();
+    assert!(code.contains("std.native('regexMatch')(pattern, value)"));
+    assert!(code.contains("local isDuration(value) = matchesPattern("));
+    assert!(!code.contains("std.regexMatch"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_custom_marshalers() {
+    let options: GoAstOptions = serde_yaml::from_str(
+        r#"
+type_mappings:
+  Level:
+    type: integer
+"#,
+    )
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+
+    let test_content = r#"
+package api
+
+type Money struct {
+    Units int64
+    Nanos int32
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+    return nil, nil
+}
+
+type Level struct {
+    value int
+}
+
+func (l *Level) MarshalText() ([]byte, error) {
+    return nil, nil
+}
+
+type Order struct {
+    Money `json:",inline"`
+    Level Level `json:"level"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("order.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let money = schemas.iter().find(|s| s.name == "Money").unwrap();
+    assert_eq!(
+        money.content.get("type").and_then(|t| t.as_str()),
+        Some("string")
+    );
+    assert!(money.content.get("properties").is_none());
+    assert_eq!(
+        money.content.get("x-go-marshaler").and_then(|m| m.as_str()),
+        Some("json.Marshaler")
+    );
+
+    // The configured mapping replaces the opaque default
+    let level = schemas.iter().find(|s| s.name == "Level").unwrap();
+    assert_eq!(
+        level.content.get("type").and_then(|t| t.as_str()),
+        Some("integer")
+    );
+
+    // Marshaler types are not flattened into their parent
+    let order = schemas.iter().find(|s| s.name == "Order").unwrap();
+    let properties = order.content.get("properties").unwrap();
+    assert!(properties.get("Units").is_none());
+    assert!(properties.get("Money").is_some());
+
+    let warnings = parser.warnings();
+    assert_eq!(warnings.len(), 1);
+    assert!(warnings[0].starts_with("GS0002: Money implements json.Marshaler"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_accessor_inference() {
+    let options: GoAstOptions = serde_yaml::from_str("infer_accessors: true").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+
+    let test_content = r#"
+package api
+
+type Resource struct {
+    Name string `json:"name"`
+    labels map[string]string
+}
+
+func (r *Resource) GetName() string { return r.Name }
+
+func (r *Resource) GetAPIVersion() string { return "v1" }
+
+func (r *Resource) SetAPIVersion(version string) {}
+
+func (r *Resource) SetReplicas(n int32) {}
+
+func (r *Resource) GetLabels() map[string]string { return r.labels }
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("resource.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let resource = schemas.iter().find(|s| s.name == "Resource").unwrap();
+    let properties = resource.content.get("properties").unwrap();
+
+    let api_version = properties.get("apiVersion").unwrap();
+    assert_eq!(
+        api_version.get("type").and_then(|t| t.as_str()),
+        Some("string")
+    );
+    assert_eq!(
+        api_version.get("x-go-accessors"),
+        Some(&serde_yaml::Value::Sequence(vec![
+            "GetAPIVersion".into(),
+            "SetAPIVersion".into()
+        ]))
+    );
+
+    let replicas = properties.get("replicas").unwrap();
+    assert_eq!(
+        replicas.get("type").and_then(|t| t.as_str()),
+        Some("integer")
+    );
+
+    // Accessors backed by a field do not add properties
+    assert!(properties
+        .get("name")
+        .unwrap()
+        .get("x-go-accessors")
+        .is_none());
+    assert!(properties
+        .get("labels")
+        .unwrap()
+        .get("x-go-accessors")
+        .is_none());
+
+    let code = GoJsonnetGenerator::new().generate(resource).unwrap();
+    assert!(code.contains("// Inferred from GetAPIVersion/SetAPIVersion"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_condition_helpers() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package v1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+type PodCondition struct {
+    Type   string `json:"type"`
+    Status string `json:"status"`
+}
+
+type WidgetStatus struct {
+    Conditions    []metav1.Condition `json:"conditions,omitempty"`
+    PodConditions []PodCondition     `json:"podConditions,omitempty"`
+    Messages      []string           `json:"messages,omitempty"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("widget_types.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let status = schemas.iter().find(|s| s.name == "WidgetStatus").unwrap();
+    let properties = status.content.get("properties").unwrap();
+    let is_conditions = |name: &str| {
+        properties.get(name).and_then(|p| p.get("x-go-conditions")) == Some(&true.into())
+    };
+
+    assert!(is_conditions("conditions"));
+    assert!(is_conditions("podConditions"));
+    assert!(!is_conditions("messages"));
+    assert_eq!(
+        properties
+            .get("conditions")
+            .and_then(|p| p.get("items"))
+            .and_then(|i| i.get("type"))
+            .and_then(|t| t.as_str()),
+        Some("object")
+    );
+
+    let code = GoJsonnetGenerator::new().generate(status).unwrap();
+    assert!(code
+        .contains("withCondition(type, status, reason='', message='', lastTransitionTime=null)::"));
+    assert!(code.contains("hasCondition(type)::"));
+    assert!(code.contains("withPodCondition(type, status"));
+    assert!(code.contains("hasPodCondition(type)::"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_any_policy() {
+    let test_content = r#"
+package repo
+
+import "encoding/json"
+
+type Event struct {
+    Payload  json.RawMessage `json:"payload"`
+    Metadata interface{}     `json:"metadata"`
+    Extra    any             `json:"extra"`
+    Name     string          `json:"name"`
+}
+"#;
+
+    // The default policy maps to a free-form object
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(test_content, Path::new("event.go"))
+        .await
+        .unwrap();
+    assert!(parser.errors().is_empty());
+
+    let schemas = parser.extract_schemas();
+    let event = schemas.iter().find(|s| s.name == "Event").unwrap();
+    let properties = event.content.get("properties").unwrap();
+    for name in ["payload", "metadata", "extra"] {
+        let property = properties.get(name).unwrap();
+        assert_eq!(
+            property.get("type").and_then(|t| t.as_str()),
+            Some("object")
+        );
+        assert_eq!(property.get("additionalProperties"), Some(&true.into()));
+    }
+
+    // Require a schema, supplied for one field and skipped for another
+    let options: GoAstOptions = serde_yaml::from_str(
+        r#"
+any_policy: require
+field_overrides:
+  Event.Payload:
+    schema:
+      type: array
+  Event.Extra:
+    policy: skip
+"#,
+    )
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("event.go"))
+        .await
+        .unwrap();
+
+    let errors = parser.errors();
+    assert_eq!(errors.len(), 1);
+    assert!(errors[0].starts_with("Field Event.Metadata"));
+
+    let schemas = parser.extract_schemas();
+    let event = schemas.iter().find(|s| s.name == "Event").unwrap();
+    let properties = event.content.get("properties").unwrap();
+    assert_eq!(
+        properties
+            .get("payload")
+            .and_then(|p| p.get("type"))
+            .and_then(|t| t.as_str()),
+        Some("array")
+    );
+    assert!(properties.get("extra").is_none());
+    assert!(properties.get("name").is_some());
+
+    // Free-form blobs can take the schema of another Go type
+    let options: GoAstOptions = serde_yaml::from_str(
+        r#"
+field_overrides:
+  Event.Metadata:
+    go_type: EventMetadata
+  Event.Extra:
+    go_type: "map[string]Label"
+"#,
+    )
+    .unwrap();
+    let mut blobs = GoAstParser::with_options(options);
+    blobs
+        .parse_content(
+            &test_content.replace("Metadata interface{}", "Metadata map[string]interface{}"),
+            Path::new("event.go"),
+        )
+        .await
+        .unwrap();
+    let schemas = blobs.extract_schemas();
+    let event = schemas.iter().find(|s| s.name == "Event").unwrap();
+    let properties = &event.content["properties"];
+    assert_eq!(properties["metadata"]["x-go-struct-ref"], "EventMetadata");
+    assert_eq!(
+        properties["extra"]["additionalProperties"]["x-go-struct-ref"],
+        "Label"
+    );
+    let trace = blobs.trace_mapping("Event", "Metadata").unwrap().render();
+    assert!(trace.contains("maps the field to EventMetadata\n"));
+    assert!(trace.contains("  2. Go type: derived from EventMetadata <- applied"));
+
+    // Left-out fields are listed for the report of the run
+    let skipped: Vec<(String, String)> = event
+        .metadata
+        .get("skipped_fields")
+        .and_then(|s| s.as_sequence())
+        .unwrap()
+        .iter()
+        .map(|entry| {
+            let value = |key: &str| entry.get(key).and_then(|v| v.as_str()).unwrap().to_string();
+            (value("field"), value("reason"))
+        })
+        .collect();
+    assert_eq!(
+        skipped,
+        vec![
+            (
+                "Metadata".to_string(),
+                "any_policy is require and no override is configured".to_string()
+            ),
+            ("Extra".to_string(), "any_policy is skip".to_string()),
+        ]
+    );
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_excluded_types() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+// Internal bookkeeping, not part of the API
+// +gensonnet:exclude
+type Internal struct {
+    Counter int `json:"counter"`
+}
+
+type Audit struct {
+    Actor string `json:"actor"`
+}
+
+// Resource is the public type
+type Resource struct {
+    // Name of the resource
+    Name     string     `json:"name"`
+    State    *Internal  `json:"state,omitempty"`
+    History  []Internal `json:"history,omitempty"`
+    Internal `json:",inline"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("resource.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    assert!(schemas.iter().all(|s| s.name != "Internal"));
+    assert!(schemas.iter().any(|s| s.name == "Audit"));
+
+    // Excluded types are listed with the reason for the dry-run plan
+    let skipped = parser.skipped_types();
+    assert_eq!(skipped.len(), 1);
+    assert_eq!(skipped[0].name, "Internal");
+    assert_eq!(
+        skipped[0].content.get("reason").and_then(|r| r.as_str()),
+        Some("marked +gensonnet:exclude")
+    );
+
+    let resource = schemas.iter().find(|s| s.name == "Resource").unwrap();
+    let properties = resource.content.get("properties").unwrap();
+    assert_eq!(
+        properties
+            .get("state")
+            .and_then(|p| p.get("x-go-excluded"))
+            .and_then(|e| e.as_str()),
+        Some("Internal")
+    );
+    assert!(properties
+        .get("history")
+        .and_then(|p| p.get("items"))
+        .and_then(|i| i.get("x-go-excluded"))
+        .is_some());
+    // The excluded type's fields are not flattened back in
+    assert!(properties.get("counter").is_none());
+
+    // Only the directly preceding comment documents a field
+    assert_eq!(
+        properties
+            .get("name")
+            .and_then(|p| p.get("description"))
+            .and_then(|d| d.as_str()),
+        Some("Name of the resource")
+    );
+
+    let warnings = parser.warnings();
+    assert_eq!(warnings.len(), 3);
+    assert!(warnings[0].starts_with("GS0102: Resource.State references excluded type Internal"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_directives() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+// gensonnet:skip
+type Scratch struct {
+    Notes string `json:"notes"`
+}
+
+// Account of a user
+// gensonnet:readonly
+type Account struct {
+    ID string `json:"id"`
+}
+
+type Login struct {
+    // Address to send mails to
+    // gensonnet:rename=email
+    Email string `json:"email_address"`
+    Token string `json:"token"` // gensonnet:hidden
+    // gensonnet:skip
+    Legacy string `json:"legacy"`
+    User   string `json:"user"` // gensonnet:hiddne
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("login.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    assert!(schemas.iter().all(|s| s.name != "Scratch"));
+    assert_eq!(
+        parser.skipped_types()[0]
+            .content
+            .get("reason")
+            .and_then(|r| r.as_str()),
+        Some("marked gensonnet:skip")
+    );
+
+    let account = schemas.iter().find(|s| s.name == "Account").unwrap();
+    assert_eq!(
+        account.content["properties"]["id"].get("readOnly"),
+        Some(&serde_yaml::Value::Bool(true))
+    );
+
+    let login = schemas.iter().find(|s| s.name == "Login").unwrap();
+    let properties = login.content.get("properties").unwrap();
+    assert!(properties.get("legacy").is_none());
+    assert!(properties.get("email_address").is_none());
+    assert_eq!(
+        properties["email"]
+            .get("description")
+            .and_then(|d| d.as_str()),
+        Some("Address to send mails to")
+    );
+    assert_eq!(
+        properties["token"].get("x-go-hidden"),
+        Some(&serde_yaml::Value::Bool(true))
+    );
+
+    let warnings = parser.warnings();
+    assert_eq!(warnings.len(), 1);
+    assert!(warnings[0].starts_with("GS0103: "));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_field_visibility() {
+    let test_content = r#"
+package api
+
+type Login struct {
+    User     string `json:"user"`
+    Token    string `json:"token"`    // gensonnet:hidden
+    Internal string `json:"internal"` // gensonnet:hidden
+}
+"#;
+
+    let options: GoAstOptions = serde_yaml::from_str(concat!(
+        "visibility: {optional: visible, defaults: hidden}\n",
+        "field_visibility: {'Login.Internal': visible, 'api.*.User': hidden}\n",
+    ))
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("login.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let login = schemas.iter().find(|s| s.name == "Login").unwrap();
+    assert_eq!(
+        login.content["x-go-visibility"],
+        serde_yaml::from_str::<serde_yaml::Value>("{defaults: hidden, optional: visible}").unwrap()
+    );
+    let hidden = |field: &str| {
+        login.content["properties"][field]
+            .get("x-go-hidden")
+            .and_then(|h| h.as_bool())
+    };
+    assert_eq!(hidden("user"), Some(true));
+    assert_eq!(hidden("token"), Some(true));
+    assert_eq!(hidden("internal"), Some(false));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_metadata_maps() {
+    let test_content = r#"
+package api
+
+type Deployment struct {
+    Labels      map[string]string `json:"labels"`
+    Annotations map[string]int    `json:"annotations"`
+    PodTags     map[string]string `json:"podTags"` // gensonnet:labels
+    Env         map[string]string `json:"env"`
+}
+"#;
+
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(test_content, Path::new("deployment.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let deployment = schemas.iter().find(|s| s.name == "Deployment").unwrap();
+    let kind = |field: &str| {
+        deployment.content["properties"][field]
+            .get(generator::METADATA_MAP_KEY)
+            .and_then(|k| k.as_str())
+    };
+    assert_eq!(kind("labels"), Some("labels"));
+    assert_eq!(kind("podTags"), Some("labels"));
+    assert_eq!(kind("annotations"), None);
+    assert_eq!(kind("env"), None);
+    assert_eq!(
+        deployment.content["properties"]["labels"][generator::MAP_KEY_KEY]["format"].as_str(),
+        Some(generator::QUALIFIED_NAME_FORMAT)
+    );
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_read_only_fields() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+type OrderStatus struct {
+    Shipped bool `json:"shipped"`
+}
+
+type Order struct {
+    Name      string      `json:"name" validate:"required"`
+    CreatedAt string      `json:"createdAt" validate:"required"` // gensonnet:readonly
+    Status    OrderStatus `json:"status"`
+}
+
+type Payment struct {
+    Status string `json:"status"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("order.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let order = schemas.iter().find(|s| s.name == "Order").unwrap();
+    let properties = order.content.get("properties").unwrap();
+    for field in ["createdAt", "status"] {
+        assert_eq!(
+            properties[field].get("readOnly"),
+            Some(&serde_yaml::Value::Bool(true))
+        );
+        assert!(properties[field].get("x-go-constructor-param").is_none());
+    }
+    assert!(properties["name"].get("x-go-constructor-param").is_some());
+
+    // A scalar status is set by users
+    let payment = schemas.iter().find(|s| s.name == "Payment").unwrap();
+    assert!(payment.content["properties"]["status"]
+        .get("readOnly")
+        .is_none());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_flatten_fields() {
+    let options: GoAstOptions =
+        serde_yaml::from_str("flatten_fields: {UserManager.repo: repo}").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+
+    let test_content = r#"
+package api
+
+type Repository struct {
+    Cache string `json:"cache"`
+    TTL   *int   `json:"ttl,omitempty"`
+}
+
+type UserManager struct {
+    Name string     `json:"name"`
+    repo Repository
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("manager.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let manager = schemas.iter().find(|s| s.name == "UserManager").unwrap();
+    let properties = manager.content.get("properties").unwrap();
+    assert!(properties.get("repo").is_none());
+    assert_eq!(
+        properties["repoCache"].get("type").and_then(|t| t.as_str()),
+        Some("string")
+    );
+    assert!(properties.get("repoTtl").is_some());
+    let required: Vec<&str> = manager.content["required"]
+        .as_sequence()
+        .unwrap()
+        .iter()
+        .filter_map(|r| r.as_str())
+        .collect();
+    assert_eq!(required, vec!["name", "repoCache"]);
+    assert!(parser.warnings().is_empty());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_field_naming() {
+    let test_content = r#"
+package api
+
+type User struct {
+    AvatarURL string `json:"avatar_url"`
+    CreatedAt string `json:"createdAt"`
+    LegacyID  string `json:"legacy_id" gensonnet:"legacy_id"`
+    Nickname  string
+}
+"#;
+
+    let parse = |options: &str| {
+        let options: GoAstOptions = serde_yaml::from_str(options).unwrap();
+        let mut parser = GoAstParser::with_options(options);
+        let content = test_content.to_string();
+        async move {
+            parser
+                .parse_content(&content, Path::new("user.go"))
+                .await
+                .unwrap();
+            parser.extract_schemas().remove(0)
+        }
+    };
+
+    let names = |schema: &crate::plugin::ExtractedSchema| -> Vec<String> {
+        schema
+            .content
+            .get("properties")
+            .and_then(|p| p.as_mapping())
+            .unwrap()
+            .keys()
+            .filter_map(|k| k.as_str().map(str::to_string))
+            .collect()
+    };
+
+    let user = parse("field_naming: camelCase").await;
+    let mut fields = names(&user);
+    fields.sort();
+    assert_eq!(
+        fields,
+        vec!["avatarUrl", "createdAt", "legacy_id", "nickname"]
+    );
+
+    let user = parse("{field_naming: snake_case, function_naming: snake_case}").await;
+    let mut fields = names(&user);
+    fields.sort();
+    assert_eq!(
+        fields,
+        vec!["avatar_url", "created_at", "legacy_id", "nickname"]
+    );
+    let code = GoJsonnetGenerator::new().generate(&user).unwrap();
+    assert!(code.contains("with_created_at(created_at)::"));
+
+    let user = parse("{}").await;
+    let mut fields = names(&user);
+    fields.sort();
+    assert_eq!(
+        fields,
+        vec!["Nickname", "avatar_url", "createdAt", "legacy_id"]
+    );
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_exclusion_rules() {
+    let test_content = r#"
+package api
+
+type ObjectMeta struct {
+    Name string `json:"name"`
+}
+
+type User struct {
+    Metadata ObjectMeta `json:"metadata"`
+    Name     string     `json:"name"`
+    Secret   string     `json:"secret"`
+    db       string
+}
+
+type CreateUserRequest struct {
+    User User `json:"user"`
+}
+"#;
+
+    let options: GoAstOptions = serde_yaml::from_str(concat!(
+        "exclude_fields: ['User.Secret']\n",
+        "exclude_types: ['*Request']\n",
+        "field_names: {'*.Metadata': meta}\n",
+    ))
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+    assert_eq!(names, vec!["ObjectMeta", "User"]);
+
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    let properties = user.content.get("properties").unwrap();
+    assert!(properties.get("meta").is_some());
+    assert!(properties.get("metadata").is_none());
+    assert!(properties.get("name").is_some());
+    assert!(properties.get("secret").is_none());
+    assert!(properties.get("db").is_none());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_type_selection() {
+    let test_content = r#"
+package api
+
+type User struct {
+    Name string `json:"name"`
+}
+
+type UserSpec struct {
+    Replicas int `json:"replicas"`
+}
+
+type UserRepository struct {
+    Users []User `json:"users"`
+}
+
+type Group struct {
+    Owner UserRepository `json:"owner"`
+}
+"#;
+
+    let options: GoAstOptions =
+        serde_yaml::from_str("types: ['User*', '!UserRepository']").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let names: Vec<String> = parser
+        .extract_schemas()
+        .into_iter()
+        .map(|s| s.name)
+        .collect();
+    assert_eq!(names, vec!["User", "UserSpec"]);
+    let skipped = parser.skipped_types();
+    let reasons: Vec<(&str, Option<&str>)> = skipped
+        .iter()
+        .map(|s| {
+            (
+                s.name.as_str(),
+                s.content.get("reason").and_then(|r| r.as_str()),
+            )
+        })
+        .collect();
+    assert_eq!(
+        reasons,
+        vec![
+            ("UserRepository", Some("not selected by types")),
+            ("Group", Some("not selected by types")),
+        ]
+    );
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_unexported_fields() {
+    let test_content = r#"
+package api
+
+type UserRepository struct {
+    Name   string `json:"name"`
+    db     string
+    cache  map[string]string
+    secret string `json:"-"`
+}
+"#;
+
+    let parse = |policy: &str| {
+        let options: GoAstOptions =
+            serde_yaml::from_str(&format!("unexported_fields: {policy}")).unwrap();
+        let mut parser = GoAstParser::with_options(options);
+        let content = test_content.to_string();
+        async move {
+            parser
+                .parse_content(&content, Path::new("repository.go"))
+                .await
+                .unwrap();
+            parser
+        }
+    };
+
+    let parser = parse("skip").await;
+    let schemas = parser.extract_schemas();
+    let properties = schemas[0].content.get("properties").unwrap();
+    assert!(properties.get("name").is_some());
+    assert!(properties.get("db").is_none());
+    assert!(parser.warnings().is_empty());
+    assert!(parser.errors().is_empty());
+
+    let parser = parse("include").await;
+    let schemas = parser.extract_schemas();
+    let properties = schemas[0].content.get("properties").unwrap();
+    assert!(properties.get("db").is_some());
+    assert!(properties.get("cache").is_some());
+    assert!(properties.get("secret").is_none());
+    let warnings = parser.warnings();
+    assert_eq!(warnings.len(), 2);
+    assert!(warnings[0].starts_with("GS0101: UserRepository.db is unexported"));
+
+    let parser = parse("fail").await;
+    let errors = parser.errors();
+    assert_eq!(errors.len(), 2);
+    assert!(errors[1].starts_with("Field UserRepository.cache is unexported"));
+
+    // All errors of the file are reported together, with their position
+    let diagnostics = parser.diagnostics();
+    assert_eq!(diagnostics.len(), 2);
+    assert!(diagnostics[0]
+        .to_string()
+        .starts_with("repository.go:6: Field UserRepository.db is unexported"));
+    assert!(diagnostics[1]
+        .to_string()
+        .starts_with("repository.go:7: Field UserRepository.cache is unexported"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_deprecations() {
+    let test_content = r#"
+package api
+
+// Profile holds display settings.
+//
+// Deprecated: use User, which has the
+// same fields.
+type Profile struct {
+    // Nick is shown in lists.
+    // Deprecated: use DisplayName instead.
+    Nick        string `json:"nick"`
+    DisplayName string `json:"displayName"`
+}
+"#;
+
+    let options: GoAstOptions = serde_yaml::from_str("deprecation_warnings: true").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("profile.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let content = &schemas[0].content;
+    assert_eq!(
+        content.get("x-go-deprecated").and_then(|d| d.as_str()),
+        Some("use User, which has the same fields.")
+    );
+    assert_eq!(
+        content
+            .get("x-go-deprecation-trace")
+            .and_then(|t| t.as_bool()),
+        Some(true)
+    );
+    let properties = content.get("properties").unwrap();
+    assert_eq!(
+        properties
+            .get("nick")
+            .and_then(|n| n.get("x-go-deprecated"))
+            .and_then(|d| d.as_str()),
+        Some("use DisplayName instead.")
+    );
+    assert!(properties
+        .get("displayName")
+        .and_then(|n| n.get("x-go-deprecated"))
+        .is_none());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_interfaces() {
+    let test_content = r#"
+package api
+
+// Storage keeps blobs
+// +gensonnet:discriminator=type
+type Storage interface {
+    io.Closer
+    Get(key string) ([]byte, error)
+    Put(key string, value []byte) error
+}
+
+type S3Storage struct {
+    Bucket string `json:"bucket"`
+}
+
+func (s *S3Storage) Get(key string) ([]byte, error) { return nil, nil }
+
+func (s *S3Storage) Put(key string, value []byte) error { return nil }
+
+func (m MemoryStorage) Close() error { return nil }
+
+type Backup struct {
+    Target  Storage   `json:"target"`
+    Mirrors []Storage `json:"mirrors"`
+}
+"#;
+
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(test_content, Path::new("storage.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let storage = schemas.iter().find(|s| s.name == "Storage").unwrap();
+    let interface = storage.content.get("x-go-interface").unwrap();
+    let methods: Vec<&str> = interface
+        .get("methods")
+        .unwrap()
+        .as_sequence()
+        .unwrap()
+        .iter()
+        .filter_map(|m| m.as_str())
+        .collect();
+    assert_eq!(methods, vec!["Get", "Put"]);
+    assert_eq!(
+        interface.get("embeds").unwrap().as_sequence().unwrap()[0].as_str(),
+        Some("io.Closer")
+    );
+    assert_eq!(
+        interface.get("discriminator").unwrap().as_str(),
+        Some("type")
+    );
+
+    // Method sets include receivers declared in other files
+    let method_sets = parser.method_sets();
+    let names: Vec<&str> = method_sets.iter().map(|s| s.name.as_str()).collect();
+    assert_eq!(names, vec!["MemoryStorage", "S3Storage"]);
+    assert_eq!(method_sets[1].content.as_sequence().unwrap().len(), 2);
+
+    let backup = schemas.iter().find(|s| s.name == "Backup").unwrap();
+    let properties = backup.content.get("properties").unwrap();
+    assert_eq!(
+        properties
+            .get("target")
+            .and_then(|t| t.get("x-go-interface-ref"))
+            .and_then(|r| r.as_str()),
+        Some("Storage")
+    );
+    assert_eq!(
+        properties
+            .get("mirrors")
+            .and_then(|m| m.get("items"))
+            .and_then(|i| i.get("x-go-interface-ref"))
+            .and_then(|r| r.as_str()),
+        Some("Storage")
+    );
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_trace_mapping() {
+    let test_content = r#"
+package api
+
+import (
+    "encoding/json"
+    "time"
+
+    "github.com/samber/mo"
+)
+
+type Event struct {
+    CreatedAt time.Time            `json:"createdAt"`
+    Duration  time.Duration        `json:"duration"`
+    Payload   json.RawMessage      `json:"payload"`
+    Name      *string              `json:"name"`
+    ExpiresAt mo.Option[time.Time] `json:"expiresAt"`
+    Secret    string               `json:"-"`
+}
+"#;
+
+    let options: GoAstOptions = serde_yaml::from_str(concat!(
+        "trace_mapping: api.Event.Duration\n",
+        "type_mappings: {time.Duration: {type: string}}\n",
+    ))
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("event.go"))
+        .await
+        .unwrap();
+
+    let trace = parser.trace_mapping("Event", "CreatedAt").unwrap();
+    assert_eq!(trace.field, "api.Event.CreatedAt");
+    assert_eq!(trace.rule, MappingRule::Builtin);
+    assert_eq!(
+        trace
+            .schema
+            .as_ref()
+            .and_then(|s| s.get("format"))
+            .and_then(|f| f.as_str()),
+        Some("date-time")
+    );
+
+    assert_eq!(
+        parser.trace_mapping("Event", "Duration").unwrap().rule,
+        MappingRule::TypeMapping
+    );
+    assert_eq!(
+        parser.trace_mapping("Event", "Payload").unwrap().rule,
+        MappingRule::AnyPolicy
+    );
+    let name = parser.trace_mapping("Event", "Name").unwrap();
+    assert_eq!(name.rule, MappingRule::GoType);
+    assert_eq!(name.go_type, "*string");
+    let rules: Vec<MappingRule> = name.steps.iter().map(|(rule, _)| *rule).collect();
+    assert_eq!(rules, vec![MappingRule::Pointer, MappingRule::GoType]);
+    assert!(parser.trace_mapping("Event", "Missing").is_none());
+
+    // Unwrap rules lead on to the rule of the type they hold
+    let expires_at = parser.trace_mapping("Event", "ExpiresAt").unwrap();
+    assert_eq!(expires_at.rule, MappingRule::Builtin);
+    assert_eq!(
+        expires_at.steps[0],
+        (
+            MappingRule::UnwrapRule,
+            "mo.Option[time.Time] unwraps to *time.Time".to_string()
+        )
+    );
+
+    let secret = parser.trace_mapping("Event", "Secret").unwrap();
+    assert_eq!(secret.rule, MappingRule::LeftOut);
+    assert!(secret.schema.is_none());
+    assert!(secret
+        .render()
+        .contains("  1. left out: the field is tagged json:\"-\" <- applied\n"));
+
+    let traces = parser.mapping_traces();
+    assert_eq!(traces.len(), 1);
+    assert!(traces[0].starts_with("Mapping of api.Event.Duration (time.Duration):\n"));
+    assert!(traces[0].contains(concat!(
+        "  1. type mapping: type_mappings has an entry for time.Duration, ",
+        "over its built-in mapping <- applied\n"
+    )));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_pointer_strategy() {
+    let test_content = r#"
+package api
+
+type UserSettings struct {
+    Theme string `json:"theme"`
+}
+
+type UserFilter struct {
+    Active   *bool         `json:"active,omitempty"`
+    MinAge   *int          `json:"minAge,omitempty"`
+    Settings *UserSettings `json:"settings,omitempty"`
+    Name     string        `json:"name"`
+}
+"#;
+
+    let parse = |strategy: &str| {
+        let options: GoAstOptions =
+            serde_yaml::from_str(&format!("pointer_strategy: {strategy}")).unwrap();
+        let mut parser = GoAstParser::with_options(options);
+        let content = test_content.to_string();
+        async move {
+            parser
+                .parse_content(&content, Path::new("filter.go"))
+                .await
+                .unwrap();
+            parser
+                .extract_schemas()
+                .into_iter()
+                .find(|s| s.name == "UserFilter")
+                .unwrap()
+        }
+    };
+
+    let filter = parse("omit").await;
+    let properties = filter.content.get("properties").unwrap();
+    let active = properties.get("active").unwrap();
+    assert_eq!(active.get("type").and_then(|t| t.as_str()), Some("boolean"));
+    assert_eq!(active.get("nullable"), Some(&true.into()));
+    assert!(active.get("default").is_none());
+
+    // Without a strategy, pointers are not marked nullable
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(test_content, Path::new("filter.go"))
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let filter = schemas.iter().find(|s| s.name == "UserFilter").unwrap();
+    let active = &filter.content["properties"]["active"];
+    assert_eq!(active["type"], "boolean");
+    assert!(active.get("nullable").is_none());
+    assert_eq!(
+        properties
+            .get("settings")
+            .and_then(|p| p.get("type"))
+            .and_then(|t| t.as_str()),
+        Some("object")
+    );
+
+    let filter = parse("null").await;
+    let code = GoJsonnetGenerator::new().generate(&filter).unwrap();
+    assert!(code.contains("    active: null,\n"));
+    assert!(code.contains("    minAge: null,\n"));
+    assert!(!code.contains("    name: null,\n"));
+
+    let filter = parse("both").await;
+    let code = GoJsonnetGenerator::new().generate(&filter).unwrap();
+    assert!(code.contains("withActiveNull():: self + { active: null },"));
+    assert!(code.contains("withSettingsNull():: self + { settings: null },"));
+    assert!(!code.contains("withNameNull"));
+
+    let filter = parse("presence").await;
+    let code = GoJsonnetGenerator::new().generate(&filter).unwrap();
+    assert!(code.contains("isSet(obj, field):: std.objectHas(obj, field),"));
+    assert!(code.contains("withActive(active):: self + { active::: active },"));
+    assert!(code.contains("withActiveNull():: self + { active::: null },"));
+    assert!(code.contains("withoutActive():: self + { active:: null },"));
+    assert!(code.contains("withName(name):: self + { name: name },"));
+    assert!(!code.contains("withoutName"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_required_constructor_params() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+type User struct {
+    Name  string `json:"name" validate:"required"`
+    Email string `json:"email" validate:"required,email"`
+    Bio   string `json:"bio,omitempty" default:"n/a"`
+}
+
+type Empty struct {
+    Note string `json:"note"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let generator = GoJsonnetGenerator::new();
+
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    let code = generator.generate(user).unwrap();
+    assert!(code.contains("  new(name, email):: self + {\n    name: name,\n    email: email,\n    bio: \"n/a\",\n  },\n"));
+
+    let empty = schemas.iter().find(|s| s.name == "Empty").unwrap();
+    let code = generator.generate(empty).unwrap();
+    assert!(code.contains("  new():: self + {\n  },\n"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_index_metadata() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+// User is an account holder. It is created on signup.
+// +gensonnet:category=identity,billing
+type User struct {
+    Name string `json:"name"`
+}
+
+type Settings struct {
+    Theme string `json:"theme"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    assert_eq!(
+        user.metadata.get("summary").and_then(|v| v.as_str()),
+        Some("User is an account holder.")
+    );
+    let categories: Vec<&str> = user
+        .metadata
+        .get("categories")
+        .and_then(|v| v.as_sequence())
+        .unwrap()
+        .iter()
+        .filter_map(|v| v.as_str())
+        .collect();
+    assert_eq!(categories, vec!["identity", "billing"]);
+
+    let index = GoJsonnetGenerator::new().generate_index(&schemas);
+    assert!(index.contains("        billing: {\n          User: {\n"));
+    assert!(index.contains("        identity: {\n          User: {\n"));
+    assert!(index.contains("        uncategorized: {\n          Settings: {\n"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_validate_rules() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+type Server struct {
+    Host    string            `json:"host" validate:"required,hostname"`
+    Port    int               `json:"port" validate:"gte=1,lte=65535"`
+    Mode    string            `json:"mode" validate:"oneof=dev prod"`
+    Peers   []string          `json:"peers" validate:"max=5,dive,ip"`
+    Labels  map[string]string `json:"labels" validate:"dive,len=3"`
+    Color   string            `json:"color" validate:"hexcolor|rgb"`
+    Slug    string            `json:"slug" validate:"regexp=^[a-z][a-z0-9-]*$"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("server.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let server = schemas.iter().find(|s| s.name == "Server").unwrap();
+    let properties = server.content.get("properties").unwrap();
+
+    let host = properties.get("host").unwrap();
+    assert_eq!(
+        host.get("format").and_then(|f| f.as_str()),
+        Some("hostname")
+    );
+    let port = properties.get("port").unwrap();
+    assert_eq!(port.get("minimum").and_then(|m| m.as_i64()), Some(1));
+    assert_eq!(port.get("maximum").and_then(|m| m.as_i64()), Some(65535));
+    let peers = properties.get("peers").unwrap();
+    assert_eq!(peers.get("maxItems").and_then(|m| m.as_u64()), Some(5));
+    assert_eq!(
+        peers
+            .get("items")
+            .and_then(|i| i.get("format"))
+            .and_then(|f| f.as_str()),
+        Some("ip")
+    );
+    let labels = properties.get("labels").unwrap();
+    assert_eq!(
+        labels
+            .get("additionalProperties")
+            .and_then(|v| v.get("minLength"))
+            .and_then(|m| m.as_u64()),
+        Some(3)
+    );
+
+    let code = GoJsonnetGenerator::new().generate(server).unwrap();
+    assert!(code.contains("assert port >= 1 : \"port must be at least 1\";"));
+    assert!(code.contains("std.member([\"dev\", \"prod\"], mode)"));
+    assert!(code.contains("std.all([isIp(e) for e in peers])"));
+    assert!(code.contains("std.all([std.length(e) >= 3 for e in std.objectValues(labels)])"));
+    assert!(code.contains("assert matchesPattern(\"^[a-z][a-z0-9-]*$\", slug)"));
+    assert!(!code.contains("std.regexMatch"));
+
+    let warnings = parser.warnings();
+    assert_eq!(warnings.len(), 1);
+    assert!(warnings[0].contains("Server.Color"));
+    assert!(warnings[0].contains("hexcolor|rgb"));
+
+    // The checks run on the standard library alone
+    let files = [("server.libsonnet", code.as_str())];
+    let evaluate = |call: &str| {
+        crate::plugin::test_evaluate(
+            &files,
+            &format!("local server = import 'server.libsonnet'; {call}"),
+        )
+    };
+    if let Some(result) = evaluate("server.new('api.example.com').withSlug('web-1').slug") {
+        assert_eq!(result.unwrap(), "\"web-1\"");
+        for (call, message) in [
+            (
+                "server.new('api.example.com').withSlug('Web')",
+                "slug must match pattern ^[a-z][a-z0-9-]*$",
+            ),
+            ("server.new('api example')", "host must be a hostname"),
+        ] {
+            let error = evaluate(call).unwrap().unwrap_err();
+            assert!(error.to_string().contains(message), "{call}: {error}");
+        }
+    }
+
+    // With pattern_checks: native, through the regexMatch native function
+    let options: GoAstOptions = serde_yaml::from_str("pattern_checks: native").unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(test_content, Path::new("server.go"))
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let server = schemas.iter().find(|s| s.name == "Server").unwrap();
+    let code = GoJsonnetGenerator::new().generate(server).unwrap();
+    assert!(code.contains("std.native('regexMatch')(pattern, value)"));
+    assert!(!code.contains("std.regexMatch"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_replaces_marker() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+type User struct {
+    // Name shown in the UI
+    // +gensonnet:replaces=nickname
+    DisplayName string `json:"displayName"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    let display_name = user
+        .content
+        .get("properties")
+        .and_then(|p| p.get("displayName"))
+        .unwrap();
+    assert_eq!(
+        display_name.get("x-go-replaces"),
+        Some(&serde_yaml::Value::from(vec!["nickname"]))
+    );
+    assert_eq!(
+        display_name.get("description").and_then(|d| d.as_str()),
+        Some("Name shown in the UI")
+    );
+
+    let previous = "{\n  withNickname(nickname):: self + { nickname: nickname },\n}\n";
+    let generator = GoJsonnetGenerator::new();
+    let code = generator.generate(user).unwrap();
+    let code = generator.add_tombstones(user, &code, previous, Some("v13"));
+    assert!(code.contains("error \"withNickname was removed in v13; use withDisplayName\""));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_renames() {
+    let mut options = GoAstOptions::default();
+    options
+        .renames
+        .insert("users.User.Email".to_string(), "PrimaryEmail".to_string());
+    options
+        .renames
+        .insert("User.Name".to_string(), "FullName".to_string());
+    let mut parser = GoAstParser::with_options(options);
+
+    let test_content = r#"
+package users
+
+type User struct {
+    PrimaryEmail string `json:"primaryEmail"`
+    FullName     string `json:"name"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    let properties = user.content.get("properties").unwrap();
+    assert_eq!(
+        properties
+            .get("primaryEmail")
+            .and_then(|p| p.get("x-go-renamed-from")),
+        Some(&serde_yaml::Value::from(vec!["email"]))
+    );
+    // The wire name did not change, so neither did the setter
+    assert!(properties
+        .get("name")
+        .and_then(|p| p.get("x-go-renamed-from"))
+        .is_none());
+
+    let code = GoJsonnetGenerator::new().generate(user).unwrap();
+    assert!(code.contains("withEmail(email):: self.withPrimaryEmail(email),"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_cross_field_rules() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"
+package api
+
+type UserFilter struct {
+    MinAge *int   `json:"min_age,omitempty" validate:"omitempty,gte=0"`
+    MaxAge *int   `json:"max_age,omitempty" validate:"omitempty,gtefield=MinAge"`
+    Query  string `json:"query,omitempty"`
+    Sort   string `json:"sort,omitempty" validate:"required_without=Query"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("filter.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let filter = schemas.iter().find(|s| s.name == "UserFilter").unwrap();
+    let max_age = filter
+        .content
+        .get("properties")
+        .and_then(|p| p.get("max_age"))
+        .unwrap();
+    let rules = max_age
+        .get("x-go-field-rules")
+        .and_then(|r| r.as_sequence())
+        .unwrap();
+    assert_eq!(
+        rules[0].get("fields"),
+        Some(&serde_yaml::Value::from(vec!["min_age"]))
+    );
+
+    let code = GoJsonnetGenerator::new().generate(filter).unwrap();
+    assert!(code.contains("obj.max_age >= obj.min_age : \"max_age must be at least min_age\""));
+    assert!(code.contains("\"sort is required when query is not set\""));
+    assert!(parser.warnings().is_empty());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_source_provenance() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"package api
+
+type Base struct {
+    ID string `json:"id"`
+}
+
+// User is an account
+type User struct {
+    Base  `json:",inline"`
+    Email string `json:"email"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("user.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    let code = GoJsonnetGenerator::new().generate(user).unwrap();
+    assert!(code.contains("// Source: user.go:8 (type User)\n// Generator: gensonnet "));
+    assert!(code.contains("  // Set the email field\n  // Source: user.go:10 (User.Email)\n"));
+    // Promoted fields point at the inline type
+    assert!(code.contains("  // Set the id field\n  // Source: user.go:4 (Base.ID)\n"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_kubernetes_resource() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"// +groupName=example.com
+package v1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+type WidgetSpec struct {
+    Replicas int32 `json:"replicas" validate:"required"`
+    Image string `json:"image,omitempty"`
+}
+
+type WidgetStatus struct {
+    Ready bool `json:"ready"`
+}
+
+type Widget struct {
+    metav1.TypeMeta   `json:",inline"`
+    metav1.ObjectMeta `json:"metadata,omitempty"`
+
+    Spec   WidgetSpec   `json:"spec"`
+    Status WidgetStatus `json:"status,omitempty"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("widget_types.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let widget = schemas.iter().find(|s| s.name == "Widget").unwrap();
+    let resource = widget.content.get("x-go-kubernetes").unwrap();
+    assert_eq!(
+        resource.get("apiVersion").and_then(|v| v.as_str()),
+        Some("example.com/v1")
+    );
+    assert!(widget.content.get("x-go-embedded").is_none());
+
+    let properties = widget.content.get("properties").unwrap();
+    assert!(properties
+        .get("spec")
+        .and_then(|s| s.get("properties"))
+        .and_then(|p| p.get("replicas"))
+        .is_some());
+    assert_eq!(
+        properties
+            .get("status")
+            .and_then(|s| s.get("readOnly"))
+            .and_then(|r| r.as_bool()),
+        Some(true)
+    );
+
+    let code = GoJsonnetGenerator::new().generate(widget).unwrap();
+    assert!(code
+        .contains("  new(name):: {\n    apiVersion: \"example.com/v1\",\n    kind: \"Widget\",\n"));
+    assert!(code.contains("    withImage(image):: { spec+: { image: image } },\n"));
+    assert!(code.contains("\"replicas is required\""));
+
+    // Types without the resource layout keep the flat helpers
+    let spec = schemas.iter().find(|s| s.name == "WidgetSpec").unwrap();
+    assert!(spec.content.get("x-go-kubernetes").is_none());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_scheme_registration() {
+    let mut parser = GoAstParser::new();
+
+    let group_version = r#"// +kubebuilder:object:generate=true
+package v1
+
+import (
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+const GroupName = "example.com"
+
+var (
+    GroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1"}
+
+    SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+)
+"#;
+    parser
+        .parse_content(group_version, Path::new("api/v1/groupversion_info.go"))
+        .await
+        .unwrap();
+    let registration = parser.scheme_registration();
+    assert_eq!(registration.len(), 1);
+    assert_eq!(
+        registration[0].schema_type,
+        scheme::GROUP_VERSION_SCHEMA_TYPE
+    );
+    assert_eq!(
+        registration[0]
+            .content
+            .get("group")
+            .and_then(|g| g.as_str()),
+        Some("example.com")
+    );
+    assert_eq!(
+        registration[0]
+            .content
+            .get("version")
+            .and_then(|v| v.as_str()),
+        Some("v1")
+    );
+
+    let types = r#"package v1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+type WidgetSpec struct {
+    Replicas int32 `json:"replicas"`
+}
+
+type Widget struct {
+    metav1.TypeMeta   `json:",inline"`
+    metav1.ObjectMeta `json:"metadata,omitempty"`
+
+    Spec WidgetSpec `json:"spec"`
+}
+
+func init() {
+    SchemeBuilder.Register(&Widget{}, &WidgetList{})
+}
+"#;
+    parser
+        .parse_content(types, Path::new("api/v1/widget_types.go"))
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    let kinds = parser.scheme_registration();
+    assert_eq!(
+        kinds[0]
+            .content
+            .get("kinds")
+            .and_then(|k| k.as_sequence())
+            .map(|k| k.len()),
+        Some(2)
+    );
+    schemas.extend(kinds);
+    schemas.extend(registration);
+
+    let warnings = scheme::resolve_group_versions(&mut schemas);
+    assert!(warnings.is_empty());
+    let widget = schemas.iter().find(|s| s.name == "Widget").unwrap();
+    let code = GoJsonnetGenerator::new().generate(widget).unwrap();
+    assert!(code
+        .contains("  new(name):: {\n    apiVersion: \"example.com/v1\",\n    kind: \"Widget\",\n"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_constants() {
+    let mut parser = GoAstParser::with_options(GoAstOptions {
+        constants: true,
+        ..Default::default()
+    });
+    let content = r#"package api
+
+// DefaultPort is the port the server listens on
+const DefaultPort = 8080
+
+const (
+    // NameLabel is the label holding the application name
+    NameLabel string = "app.kubernetes.io/name"
+    internalTimeout = 30
+    Timeout = internalTimeout
+    Computed = DefaultPort * 2
+)
+
+type Phase string
+
+const (
+    PhasePending Phase = "Pending"
+    PhaseReady Phase = "Ready"
+)
+"#;
+    parser
+        .parse_content(content, Path::new("api/constants.go"))
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    schemas.extend(parser.constants());
+
+    let (constants, warnings) = constants::take_constants(&mut schemas);
+    assert!(warnings.is_empty());
+    assert!(schemas
+        .iter()
+        .all(|s| s.schema_type != constants::CONSTANTS_SCHEMA_TYPE));
+    let keys: Vec<String> = constants.iter().map(constants::Constant::key).collect();
+    assert_eq!(
+        keys,
+        vec![
+            "defaultPort",
+            "nameLabel",
+            "phasePending",
+            "phaseReady",
+            "timeout"
+        ]
+    );
+
+    let code = constants::generate_constants(&constants);
+    assert!(
+        code.contains("  // DefaultPort is the port the server listens on\n  defaultPort: 8080,\n")
+    );
+    assert!(code.contains("  timeout: 30,\n"));
+    assert!(!code.contains("computed"));
+
+    // Constants are only collected with the option
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(content, Path::new("api/constants.go"))
+        .await
+        .unwrap();
+    assert!(parser.constants().is_empty());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_errors() {
+    let mut parser = GoAstParser::with_options(GoAstOptions {
+        errors: true,
+        ..Default::default()
+    });
+    let content = r#"package users
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrNotFound is returned for users that do not exist
+var ErrNotFound = errors.New("user not found")
+
+var (
+    ErrConflict = fmt.Errorf("user already exists")
+    errInternal = errors.New("internal")
+)
+
+type ErrorCode int
+
+const (
+    CodeNotFound ErrorCode = 404
+    CodeTeapot ErrorCode = 418
+)
+
+type ValidationError struct {
+    Field string
+}
+
+func (e *ValidationError) Error() string {
+    return e.Field + " is invalid"
+}
+"#;
+    parser
+        .parse_content(content, Path::new("users/errors.go"))
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    schemas.extend(parser.error_catalog());
+
+    let (errors, warnings) = errors::take_errors(&mut schemas);
+    assert!(warnings.is_empty());
+    let entries: Vec<(&str, errors::ErrorKind)> =
+        errors.iter().map(|e| (e.name.as_str(), e.kind)).collect();
+    assert_eq!(
+        entries,
+        vec![
+            ("CodeTeapot", errors::ErrorKind::Code),
+            ("ErrConflict", errors::ErrorKind::Sentinel),
+            ("ErrNotFound", errors::ErrorKind::Sentinel),
+            ("ValidationError", errors::ErrorKind::Type),
+        ]
+    );
+
+    let code = errors::generate_errors(&errors);
+    assert!(code.contains("  // ErrNotFound is returned for users that do not exist\n  errNotFound: { name: \"ErrNotFound\", package: \"users\", kind: \"sentinel\", message: \"user not found\", code: 404 },\n"));
+    assert!(code.contains("    \"418\": self.codeTeapot,\n"));
+    assert!(!code.contains("errInternal"));
+
+    // Errors are only collected with the option
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(content, Path::new("users/errors.go"))
+        .await
+        .unwrap();
+    assert!(parser.error_catalog().is_empty());
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_constructor_analysis() {
+    let content = r#"package repo
+
+type UserRepository struct {
+    Table   string `json:"table"`
+    Timeout int    `json:"timeout"`
+    Retries int    `json:"retries"`
+    Cache   bool   `json:"cache"`
+    Owner   string `json:"owner"`
+}
+
+func NewRepository(owner string, cache bool) *UserRepository {
+    r := &UserRepository{Table: "users", Owner: owner}
+    r.Timeout = 30
+    r.Retries = maxRetries
+    r.Cache = cache
+    if cache {
+        r.Timeout = 60
+    }
+    return r
+}
+"#;
+    let defaults = |parser: &GoAstParser| {
+        let schemas = parser.extract_schemas();
+        let properties = schemas[0].content.get("properties").unwrap().clone();
+        move |name: &str| {
+            let property = properties.get(name).unwrap();
+            (
+                property.get("default").cloned(),
+                property
+                    .get(constants::DEFAULT_CONSTANT_KEY)
+                    .and_then(|c| c.as_str())
+                    .map(str::to_string),
+            )
+        }
+    };
+
+    let mut parser = GoAstParser::with_options(GoAstOptions {
+        constructor_analysis: true,
+        ..Default::default()
+    });
+    parser
+        .parse_content(content, Path::new("repo/user.go"))
+        .await
+        .unwrap();
+    let default = defaults(&parser);
+    assert_eq!(default("table"), (Some("users".into()), None));
+    // Assignments in branches are not followed
+    assert_eq!(default("timeout"), (Some(30.into()), None));
+    // Left for the constants of the package
+    assert_eq!(default("retries"), (None, Some("maxRetries".to_string())));
+    // Arguments are not defaults
+    assert_eq!(default("cache"), (None, None));
+    assert_eq!(default("owner"), (None, None));
+
+    // Without the option, NewRepository does not build a Repository
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(content, Path::new("repo/user.go"))
+        .await
+        .unwrap();
+    assert_eq!(defaults(&parser)("table"), (None, None));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_primitive_types() {
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(
+            r#"package api
+
+// Port is a TCP port
+type Port int
+
+type Hostname = string
+
+type Server struct {
+    Port     Port       `json:"port"`
+    Host     Hostname   `json:"host"`
+    Backups  []Port     `json:"backups"`
+    Fallback *Port      `json:"fallback"`
+    Mode     Mode       `json:"mode"`
+    Since    Timestamp  `json:"since"`
+}
+"#,
+            Path::new("api/server.go"),
+        )
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    schemas.extend(parser.primitive_types());
+    // Named primitives are not types of their own
+    assert_eq!(
+        schemas
+            .iter()
+            .filter(|s| s.schema_type == "go_struct")
+            .count(),
+        1
+    );
+
+    parser
+        .parse_content(
+            "package api\n\ntype Mode string\n",
+            Path::new("api/mode.go"),
+        )
+        .await
+        .unwrap();
+    schemas.extend(parser.primitive_types());
+    aliases::resolve_primitive_types(&mut schemas);
+    assert_eq!(schemas.len(), 1);
+
+    let properties = schemas[0].content.get("properties").unwrap();
+    let property = |name: &str| properties.get(name).unwrap();
+    assert_eq!(property("port")["type"], "integer");
+    assert_eq!(property("port")["x-go-type"], "Port");
+    assert_eq!(property("host")["type"], "string");
+    assert_eq!(property("host")["x-go-type"], "Hostname");
+    assert_eq!(property("backups")["items"]["type"], "integer");
+    assert_eq!(property("fallback")["type"], "integer");
+    assert!(property("fallback").get("nullable").is_none());
+    // Declared in another file of the package
+    assert_eq!(property("mode")["x-go-type"], "Mode");
+    // Not a named primitive of the package
+    assert!(property("since").get(aliases::LOCAL_TYPE_KEY).is_none());
+    assert!(property("since").get("x-go-type").is_none());
+
+    let code = GoJsonnetGenerator::new().generate(&schemas[0]).unwrap();
+    assert!(code.contains("(Server.Port, type Port)"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_nested_collections() {
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(
+            r#"package api
+
+type User struct {
+    Name string `json:"name"`
+}
+
+type Users []*User
+
+type Tree map[string]Tree
+
+type Board struct {
+    Grid    [][]string          `json:"grid"`
+    Teams   map[string][]*User  `json:"teams"`
+    Scores  []map[string]int    `json:"scores"`
+    Members Users               `json:"members"`
+    Roots   Tree                `json:"roots"`
+}
+"#,
+            Path::new("api/board.go"),
+        )
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let board = schemas.iter().find(|s| s.name == "Board").unwrap();
+    let properties = board.content.get("properties").unwrap();
+    let property = |name: &str| properties.get(name).unwrap();
+    assert_eq!(property("grid")["items"]["items"]["type"], "string");
+    assert_eq!(property("teams")["additionalProperties"]["type"], "array");
+    assert_eq!(
+        property("teams")["additionalProperties"]["items"]["type"],
+        "object"
+    );
+    assert_eq!(
+        property("scores")["items"]["additionalProperties"]["type"],
+        "integer"
+    );
+    // Named collections have the schema of their definition
+    assert_eq!(property("members")["items"]["type"], "object");
+    assert_eq!(property("members")["x-go-type"], "Users");
+    // unless they contain themselves
+    assert_eq!(property("roots")["type"], "object");
+    assert!(property("roots").get("additionalProperties").is_none());
+
+    let primitive_types = parser.primitive_types();
+    assert!(primitive_types[0].content.get("Users").is_some());
+    assert!(primitive_types[0].content.get("Tree").is_none());
+
+    let types = GoJsonnetGenerator::new().param_types(board);
+    assert_eq!(types["withGridMixin"], vec!["array<array<string>>"]);
+    assert_eq!(types["withMembers"], vec!["array<object>"]);
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_map_keys() {
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(
+            r#"package api
+
+type Shard uint16
+
+type Pool struct {
+    Labels   map[string]string  `json:"labels"`
+    Replicas map[int]string     `json:"replicas"`
+    Shards   map[Shard]string   `json:"shards"`
+    Weights  map[float64]string `json:"weights"`
+}
+"#,
+            Path::new("api/pool.go"),
+        )
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let properties = schemas[0].content.get("properties").unwrap();
+    let key = |name: &str| properties[name].get(generator::MAP_KEY_KEY);
+    assert!(key("labels").is_none());
+    assert_eq!(key("replicas").unwrap()["type"], "integer");
+    assert_eq!(key("shards").unwrap()["type"], "integer");
+
+    // encoding/json rejects float keys when marshaling
+    assert_eq!(parser.warnings().len(), 1);
+    assert!(parser.warnings()[0].contains("Pool.Weights"));
+
+    let code = GoJsonnetGenerator::new().generate(&schemas[0]).unwrap();
+    assert!(code.contains("withReplicasEntry(key, value)::"));
+    assert!(code.contains("withShardsEntry(key, value)::"));
+    assert!(!code.contains("withLabelsEntry"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_constant_defaults() {
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(
+            r#"package api
+
+const (
+    defaultLimit = 100
+    maxLimit     = defaultLimit * 10
+    prefix       = "page-"
+    defaultName  = prefix + "main"
+    ratio        = float64(defaultLimit) / 8
+)
+
+type Page struct {
+    Limit   int     `json:"limit" default:"defaultLimit"`
+    Max     int     `json:"max"`
+    Name    string  `json:"name"`
+    Ratio   float64 `json:"ratio" default:"ratio"`
+    Timeout int     `json:"timeout" default:"defaultTimeout"`
+    Sort    string  `json:"sort" default:"ascending"`
+    Offset  int     `json:"offset"`
+}
+
+func NewPage() *Page {
+    return &Page{
+        Max:    maxLimit,
+        Name:   defaultName,
+        Offset: defaultOffset,
+    }
+}
+"#,
+            Path::new("api/page.go"),
+        )
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    schemas.extend(parser.const_values());
+
+    parser
+        .parse_content(
+            "package api\n\nconst defaultTimeout = 30\n",
+            Path::new("api/defaults.go"),
+        )
+        .await
+        .unwrap();
+    schemas.extend(parser.const_values());
+
+    let warnings = constants::resolve_constant_defaults(&mut schemas);
+    assert_eq!(warnings.len(), 1);
+    assert!(warnings[0].contains("defaultOffset"));
+    assert_eq!(schemas.len(), 1);
+
+    let properties = schemas[0].content.get("properties").unwrap();
+    let default = |name: &str| properties.get(name).and_then(|p| p.get("default")).cloned();
+    assert_eq!(default("limit"), Some(serde_yaml::Value::from(100)));
+    assert_eq!(default("max"), Some(serde_yaml::Value::from(1000)));
+    assert_eq!(default("name"), Some(serde_yaml::Value::from("page-main")));
+    assert_eq!(default("ratio"), Some(serde_yaml::Value::from(12.5)));
+    // Constants of other files of the package are resolved too
+    assert_eq!(default("timeout"), Some(serde_yaml::Value::from(30)));
+    // Strings that name no constant keep their text
+    assert_eq!(default("sort"), Some(serde_yaml::Value::from("ascending")));
+    assert_eq!(default("offset"), None);
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_iota_enums() {
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(
+            r#"package api
+
+// Level is the verbosity of the logs
+type Level int
+
+type Permission uint8
+
+type Count int
+"#,
+            Path::new("api/types.go"),
+        )
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    schemas.extend(parser.enums());
+
+    parser
+        .parse_content(
+            r#"package api
+
+const (
+    LevelDebug Level = iota
+    LevelInfo
+    _
+    LevelError
+)
+
+const (
+    PermissionRead Permission = 1 << iota
+    PermissionWrite
+    PermissionExec
+)
+
+const (
+    SizeSmall = iota * 10
+    SizeLarge
+)
+"#,
+            Path::new("api/values.go"),
+        )
+        .await
+        .unwrap();
+    schemas.extend(parser.extract_schemas());
+    schemas.extend(parser.enums());
+
+    let warnings = enums::resolve_enums(&mut schemas);
+    assert!(warnings.is_empty());
+    let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+    assert_eq!(names, vec!["Level", "Permission"]);
+
+    let level = &schemas[0];
+    assert_eq!(level.source_file, Path::new("api/types.go"));
+    let values: Vec<enums::EnumValue> =
+        serde_yaml::from_value(level.content["x-go-enum"]["values"].clone()).unwrap();
+    let values: Vec<(&str, i64)> = values.iter().map(|v| (v.name.as_str(), v.value)).collect();
+    assert_eq!(
+        values,
+        vec![("LevelDebug", 0), ("LevelInfo", 1), ("LevelError", 3)]
+    );
+    assert_eq!(level.content["x-go-enum"]["flags"], false);
+
+    let permission = &schemas[1];
+    assert_eq!(permission.content["x-go-enum"]["flags"], true);
+    let values: Vec<enums::EnumValue> =
+        serde_yaml::from_value(permission.content["x-go-enum"]["values"].clone()).unwrap();
+    let values: Vec<i64> = values.iter().map(|v| v.value).collect();
+    assert_eq!(values, vec![1, 2, 4]);
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_stringer_names() {
+    let mut parser = GoAstParser::with_options(GoAstOptions {
+        enum_strings: true,
+        ..Default::default()
+    });
+    let content = r#"package api
+
+type Level int
+
+const (
+    LevelDebug Level = iota
+    LevelInfo
+)
+
+const _Level_name = "debuginfo"
+
+var _Level_index = [...]uint8{0, 5, 9}
+
+func (i Level) String() string {
+    return _Level_name[_Level_index[i]:_Level_index[i+1]]
+}
+"#;
+    parser
+        .parse_content(content, Path::new("api/level.go"))
+        .await
+        .unwrap();
+    let mut schemas = parser.enums();
+    assert!(enums::resolve_enums(&mut schemas).is_empty());
+    assert_eq!(schemas.len(), 1);
+    let values: Vec<enums::EnumValue> =
+        serde_yaml::from_value(schemas[0].content["x-go-enum"]["values"].clone()).unwrap();
+    let strings: Vec<&str> = values.iter().filter_map(|v| v.string.as_deref()).collect();
+    assert_eq!(strings, vec!["debug", "info"]);
+
+    // The String method is only looked at with the option
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(content, Path::new("api/level.go"))
+        .await
+        .unwrap();
+    let mut schemas = parser.enums();
+    enums::resolve_enums(&mut schemas);
+    assert_eq!(schemas[0].content["type"], "integer");
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_cgo() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"package codec
+
+// #include <zlib.h>
+import "C"
+
+type Options struct {
+    Level int `json:"level"`
+}
+
+type Client struct {
+    stream *C.z_stream
+    Name   string `json:"name"`
+}
+
+type Buffer struct {
+    Data *C.char `json:"data"`
+}
+
+type Frame struct {
+    Buffer Buffer `json:"buffer"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("/repo/codec/zlib.go"))
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+    assert_eq!(names, vec!["Options", "Client", "Frame"]);
+    // Fields of types using C types are opaque
+    let frame = &schemas[2].content["properties"]["buffer"];
+    assert_eq!(frame["x-go-excluded"], "Buffer");
+
+    let mut unsupported = parser.unsupported();
+    let constructs = unsupported::take_unsupported(&mut unsupported, "codec", Path::new("/repo"));
+    assert_eq!(constructs.len(), 1);
+    assert_eq!(constructs[0].kind, unsupported::UnsupportedKind::Cgo);
+    assert_eq!(constructs[0].construct, "C.char");
+
+    let skipped = parser.skipped_types();
+    assert_eq!(skipped.len(), 1);
+    assert_eq!(skipped[0].content["reason"], "uses cgo type C.char");
+    assert!(parser
+        .warnings()
+        .iter()
+        .any(|w| w == "Buffer uses cgo type C.char and is not generated"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_unsupported_constructs() {
+    let mut parser = GoAstParser::new();
+
+    let test_content = r#"package api
+
+type Page[T any] struct {
+    Items []T `json:"items"`
+}
+
+type Server struct {
+    Color string `json:"color" validate:"iscolor"`
+    Slug string `json:"slug" validate:"regexp=\bslug"`
+    Port int `json:"port,string"`
+    Users Page[string] `json:"users"`
+}
+"#;
+
+    parser
+        .parse_content(test_content, Path::new("/repo/api/server.go"))
+        .await
+        .unwrap();
+
+    let mut schemas = parser.unsupported();
+    let constructs = unsupported::take_unsupported(&mut schemas, "api", Path::new("/repo"));
+    let kinds: Vec<(unsupported::UnsupportedKind, &str)> = constructs
+        .iter()
+        .map(|c| (c.kind, c.construct.as_str()))
+        .collect();
+    assert_eq!(
+        kinds,
+        vec![
+            (unsupported::UnsupportedKind::Generic, "[T any]"),
+            (unsupported::UnsupportedKind::ValidateRule, "iscolor"),
+            (unsupported::UnsupportedKind::ValidateRule, r"regexp=\bslug"),
+            (unsupported::UnsupportedKind::TagOption, "json:\",string\""),
+            (unsupported::UnsupportedKind::Generic, "Page[string]"),
+        ]
+    );
+    assert_eq!(constructs[1].field.as_deref(), Some("Color"));
+    assert_eq!(
+        constructs[1].file,
+        std::path::PathBuf::from("api/server.go")
+    );
+    assert_eq!(parser.warnings().len(), 4);
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_local_types() {
+    let mut parser = GoAstParser::new();
+    parser
+        .parse_content(
+            r#"package api
+
+type Settings struct {
+    Theme string `json:"theme"`
+}
+
+type Tags []string
+
+type Labels map[string]string
+
+type Account struct {
+    Settings Settings  `json:"settings"`
+    Previous *Settings `json:"previous"`
+    Tags     Tags      `json:"tags"`
+    Labels   Labels    `json:"labels"`
+    Region   Region    `json:"region"`
+}
+"#,
+            Path::new("api/account.go"),
+        )
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let account = schemas.iter().find(|s| s.name == "Account").unwrap();
+    let properties = &account.content["properties"];
+    // Types declared in the file take the shape of their definition
+    assert_eq!(properties["settings"]["type"], "object");
+    assert_eq!(properties["previous"]["type"], "object");
+    assert_eq!(properties["tags"]["type"], "array");
+    assert_eq!(properties["labels"]["type"], "object");
+    // Declared elsewhere in the package, resolved later
+    assert_eq!(properties["region"]["type"], "string");
+    assert_eq!(properties["region"][aliases::LOCAL_TYPE_KEY], "Region");
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_struct_refs() {
+    let mut parser = GoAstParser::new();
+
+    parser
+        .parse_content(
+            r#"package api
+
+type User struct {
+    Name string `json:"name"`
+}
+
+type Server struct {
+    Owner *User  `json:"owner"`
+    Admin Admin  `json:"admin"`
+    Users []User `json:"users"`
+}
+"#,
+            Path::new("api/server.go"),
+        )
+        .await
+        .unwrap();
+    let mut schemas = parser.extract_schemas();
+    schemas.extend(parser.primitive_types());
+
+    parser
+        .parse_content(
+            "package api\n\ntype Admin struct {\n    Level int `json:\"level\"`\n}\n",
+            Path::new("api/admin.go"),
+        )
+        .await
+        .unwrap();
+    schemas.extend(parser.extract_schemas());
+    schemas.extend(parser.primitive_types());
+    aliases::resolve_primitive_types(&mut schemas);
+    assert_eq!(schemas.len(), 3);
+
+    let properties = schemas[1].content.get("properties").unwrap();
+    assert_eq!(properties["owner"]["x-go-struct-ref"], "User");
+    assert!(properties["owner"].get("nullable").is_none());
+    assert_eq!(properties["users"]["items"]["x-go-struct-ref"], "User");
+    // Declared in another file of the package
+    assert_eq!(properties["admin"]["type"], "object");
+    assert_eq!(properties["admin"]["x-go-struct-ref"], "Admin");
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_service_signatures() {
+    let mut parser = GoAstParser::with_options(GoAstOptions {
+        services: true,
+        ..Default::default()
+    });
+
+    parser
+        .parse_content(
+            r#"package api
+
+import "context"
+
+type GetUserRequest struct {
+    Name string `json:"name"`
+}
+
+type User struct {
+    Name string `json:"name"`
+}
+
+// UserService manages users
+type UserService interface {
+    // GetUser retrieves a user
+    GetUser(ctx context.Context, in *GetUserRequest) (*User, error)
+    Rename(from, to string) error
+    Count() (int, bool)
+    mustEmbedUnimplemented()
+}
+"#,
+            Path::new("api/service.go"),
+        )
+        .await
+        .unwrap();
+    let schemas = parser.extract_schemas();
+    let service = schemas.iter().find(|s| s.name == "UserService").unwrap();
+    let signatures = service.content["x-go-interface"]["signatures"]
+        .as_sequence()
+        .unwrap();
+    assert_eq!(signatures.len(), 3);
+
+    assert_eq!(signatures[0]["name"], "GetUser");
+    assert_eq!(signatures[0]["description"], "GetUser retrieves a user");
+    assert_eq!(
+        signatures[0]["request"]["x-go-struct-ref"],
+        "GetUserRequest"
+    );
+    assert_eq!(signatures[0]["response"]["x-go-struct-ref"], "User");
+    assert_eq!(signatures[0]["params"][0]["type"], "context.Context");
+    assert_eq!(signatures[0]["params"][1]["name"], "in");
+    assert_eq!(signatures[0]["params"][1]["type"], "*GetUserRequest");
+    assert_eq!(signatures[0]["results"][1]["type"], "error");
+    assert!(signatures[0]["results"][0]["name"].is_null());
+
+    let rename = &signatures[1];
+    assert_eq!(rename["request"]["properties"]["from"]["type"], "string");
+    assert_eq!(rename["request"]["properties"]["to"]["type"], "string");
+    assert!(rename["response"].is_null());
+
+    let count = &signatures[2]["response"]["properties"];
+    assert_eq!(count["result0"]["type"], "integer");
+    assert_eq!(count["result1"]["type"], "boolean");
+}
+
+#[tokio::test]
+async fn test_generate_source() {
+    let files = vec![
+        (
+            PathBuf::from("api/user.go"),
+            r#"
+package api
+
+// User is a user of the API
+type User struct {
+    Name string `json:"name"`
+    Role Role   `json:"role,omitempty"`
+}
+"#
+            .to_string(),
+        ),
+        (
+            PathBuf::from("api/role.go"),
+            r#"
+package api
+
+type Role string
+
+const (
+    RoleAdmin  Role = "admin"
+    RoleViewer Role = "viewer"
+)
+"#
+            .to_string(),
+        ),
+    ];
+    let generated = generate_source(&files, &GoAstOptions::default())
+        .await
+        .unwrap();
+
+    let user = &generated.files["user.libsonnet"];
+    assert!(user.contains("withName("));
+    // The enum of the other file applies to the field
+    assert!(user.contains("\"admin\""));
+    assert!(generated.files["index.libsonnet"].contains("user.libsonnet"));
+
+    let broken = vec![(PathBuf::from(memory::STDIN_FILE), "package".to_string())];
+    let error = generate_source(&broken, &GoAstOptions::default())
+        .await
+        .unwrap_err();
+    assert!(error.to_string().starts_with("stdin.go: "));
+}
+
+#[tokio::test]
+async fn test_generate_source_collisions() {
+    let files: Vec<(PathBuf, String)> = ["v1", "v2"]
+        .iter()
+        .map(|version| {
+            (
+                PathBuf::from(format!("api/{version}/user.go")),
+                format!("package {version}\n\ntype User struct {{\n    Name string `json:\"name\"`\n}}\n"),
+            )
+        })
+        .collect();
+
+    // Types of several packages are not written over each other
+    assert!(generate_source(&files, &GoAstOptions::default())
+        .await
+        .is_err());
+
+    let options: GoAstOptions = serde_yaml::from_str("name_collisions: version").unwrap();
+    let generated = generate_source(&files, &options).await.unwrap();
+    let libraries = generated
+        .files
+        .keys()
+        .filter(|file| file.as_str() != "index.libsonnet")
+        .count();
+    assert_eq!(libraries, 2);
+}
+
+#[tokio::test]
+async fn test_generate_source_translations() {
+    let temp_dir = TempDir::new().unwrap();
+    let file = temp_dir.path().join("translations.yaml");
+    std::fs::write(&file, "de:\n  User.Name: Anzeigename des Benutzers\n").unwrap();
+    let options = GoAstOptions {
+        translations: Some(super::translations::TranslationOptions {
+            file,
+            language: "de".to_string(),
+        }),
+        ..GoAstOptions::default()
+    };
+    let files = vec![(
+        PathBuf::from("api/user.go"),
+        "package api\n\ntype User struct {\n    // Display name of the user\n    Name string `json:\"name\"`\n}\n"
+            .to_string(),
+    )];
+    let generated = generate_source(&files, &options).await.unwrap();
+
+    let user = &generated.files["user.libsonnet"];
+    assert!(user.contains("Anzeigename des Benutzers"));
+    assert!(!user.contains("Display name of the user"));
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_unwrap_types() {
+    let options: GoAstOptions = serde_yaml::from_str(
+        "{unwrap_types: {xsync.MapOf: map, Validated: inner}, pointer_strategy: omit}",
+    )
+    .unwrap();
+    let mut parser = GoAstParser::with_options(options);
+    parser
+        .parse_content(
+            r#"
+package api
+
+import (
+    "sync"
+
+    "github.com/puzpuzpuz/xsync/v3"
+    "github.com/samber/mo"
+)
+
+type Optional[T any] struct {
+    value T
+    set   bool
+}
+
+type User struct {
+    Name     string                   `json:"name"`
+    Nick     Optional[string]         `json:"nick"`
+    Age      mo.Option[int]           `json:"age"`
+    Email    Validated[string]        `json:"email"`
+    Friends  List[*User]              `json:"friends"`
+    Scores   xsync.MapOf[string, int] `json:"scores"`
+    Cache    sync.Map                 `json:"cache"`
+    Opaque   mo.Result[int]           `json:"opaque"`
+}
+"#,
+            Path::new("api/user.go"),
+        )
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let user = schemas.iter().find(|s| s.name == "User").unwrap();
+    let properties = &user.content["properties"];
+
+    assert_eq!(properties["nick"]["type"], "string");
+    assert_eq!(properties["nick"]["nullable"], true);
+    assert_eq!(properties["age"]["type"], "integer");
+    assert_eq!(properties["email"]["type"], "string");
+    assert!(properties["email"]["nullable"].is_null());
+    assert_eq!(properties["friends"]["type"], "array");
+    assert_eq!(properties["friends"]["items"]["x-go-struct-ref"], "User");
+    assert_eq!(
+        properties["scores"]["additionalProperties"]["type"],
+        "integer"
+    );
+    assert_eq!(properties["cache"]["additionalProperties"], true);
+
+    // Optional wrappers are not required, like pointers
+    let required: Vec<&str> = user.content["required"]
+        .as_sequence()
+        .unwrap()
+        .iter()
+        .filter_map(|name| name.as_str())
+        .collect();
+    assert!(required.contains(&"email"));
+    assert!(!required.contains(&"nick") && !required.contains(&"age"));
+
+    // Wrappers without a rule are still reported
+    let mut unsupported = parser.unsupported();
+    let constructs = unsupported::take_unsupported(&mut unsupported, "api", Path::new("/repo"));
+    let fields: Vec<&str> = constructs
+        .iter()
+        .filter_map(|c| c.field.as_deref())
+        .collect();
+    assert_eq!(fields, vec!["Opaque"]);
+}
+
+#[tokio::test]
+async fn test_go_ast_parser_omit_empty() {
+    let mut parser = GoAstParser::with_options(GoAstOptions {
+        omit_empty: true,
+        ..Default::default()
+    });
+    parser
+        .parse_content(
+            r#"
+package api
+
+type Server struct {
+    Name  string   `json:"name"`
+    Port  int      `json:"port,omitempty"`
+    Tags  []string `json:"tags,omitempty"`
+    Debug *bool    `json:"debug,omitempty"`
+}
+"#,
+            Path::new("api/server.go"),
+        )
+        .await
+        .unwrap();
+
+    let schemas = parser.extract_schemas();
+    let properties = &schemas[0].content["properties"];
+    assert!(properties["name"]["x-go-omitempty"].is_null());
+    assert_eq!(properties["port"]["x-go-omitempty"], true);
+    assert_eq!(properties["tags"]["x-go-omitempty"], true);
+    assert!(properties["port"]["x-go-pointer"].is_null());
+    assert_eq!(properties["debug"]["x-go-pointer"], true);
+
+    // A pointer to false is set, not empty
+    let code = GoJsonnetGenerator::new().generate(&schemas[0]).unwrap();
+    assert!(code.contains("debug == null"));
+    assert!(!code.contains("debug == false"));
+}
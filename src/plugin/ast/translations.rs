@@ -0,0 +1,270 @@
+//! Documentation of the generated libraries in another language
+//!
+//! The doc comments of the libraries come from the Go comments. With the
+//! `translations` option, a file gives them in other languages, and the
+//! libraries are documented in the chosen one; types, fields and methods
+//! without a translation keep their Go comment:
+//!
+//! ```yaml
+//! options:
+//!   translations:
+//!     file: ./docs/translations.yaml
+//!     language: de
+//! ```
+//!
+//! ```yaml
+//! # docs/translations.yaml
+//! de:
+//!   User: Ein Benutzerkonto.
+//!   User.Email: E-Mail-Adresse für Benachrichtigungen
+//!   users.User.Role:
+//!     description: Rolle des Benutzers
+//!     deprecated: Stattdessen withRoles verwenden
+//! ```
+//!
+//! Keys are Go names, `Type` or `Type.Field` (`Interface.Method` for the
+//! methods of services), optionally qualified by the package. A text
+//! replaces the description; an entry with `description` and `deprecated`
+//! also replaces the notice of a deprecated type or field, in its comments
+//! and docsonnet help. The summary of a type in the index is the first
+//! sentence of its translation.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+
+use crate::plugin::ExtractedSchema;
+
+/// File and language of the translations of a source
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TranslationOptions {
+    /// YAML or JSON file with the texts of each language
+    pub file: PathBuf,
+
+    /// Language of the documentation, a key of the file
+    pub language: String,
+}
+
+/// Translation of a type, field or method
+#[derive(Debug, Clone, PartialEq, Eq, Deserialize)]
+#[serde(untagged)]
+pub enum Entry {
+    Description(String),
+    Texts {
+        #[serde(default)]
+        description: Option<String>,
+
+        #[serde(default)]
+        deprecated: Option<String>,
+    },
+}
+
+impl Entry {
+    fn description(&self) -> Option<&str> {
+        match self {
+            Entry::Description(description) => Some(description),
+            Entry::Texts { description, .. } => description.as_deref(),
+        }
+    }
+
+    fn deprecated(&self) -> Option<&str> {
+        match self {
+            Entry::Description(_) => None,
+            Entry::Texts { deprecated, .. } => deprecated.as_deref(),
+        }
+    }
+}
+
+/// Translations of one language, keyed by Go name
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Translations {
+    entries: BTreeMap<String, Entry>,
+}
+
+impl Translations {
+    /// Read the translations of the configured language
+    pub fn load(options: &TranslationOptions) -> Result<Self> {
+        let text = std::fs::read_to_string(&options.file).map_err(|e| {
+            anyhow!(
+                "Failed to read translations {}: {}",
+                options.file.display(),
+                e
+            )
+        })?;
+        Self::parse(&text, &options.language)
+            .map_err(|e| anyhow!("Translations {}: {}", options.file.display(), e))
+    }
+
+    /// Parse the translations of `language` from a file of every language
+    pub fn parse(text: &str, language: &str) -> Result<Self> {
+        let mut languages: BTreeMap<String, BTreeMap<String, Entry>> = serde_yaml::from_str(text)?;
+        match languages.remove(language) {
+            Some(entries) => Ok(Self { entries }),
+            None => Err(anyhow!(
+                "No language {} (languages: {})",
+                language,
+                languages.keys().cloned().collect::<Vec<_>>().join(", ")
+            )),
+        }
+    }
+
+    /// Get the entry of a Go name, preferring the one qualified by the package
+    fn get(&self, package: &str, name: &str) -> Option<&Entry> {
+        self.entries
+            .get(&format!("{package}.{name}"))
+            .or_else(|| self.entries.get(name))
+    }
+
+    /// Replace the documentation of the types, fields and methods of
+    /// `schemas` by its translations
+    pub fn translate(&self, schemas: &mut [ExtractedSchema]) {
+        for schema in schemas {
+            let package = schema
+                .metadata
+                .get("package")
+                .and_then(|package| package.as_str())
+                .unwrap_or_default()
+                .to_string();
+
+            if let Some(entry) = self.get(&package, &schema.name) {
+                if let Some(description) = entry.description() {
+                    schema
+                        .metadata
+                        .insert("summary".to_string(), summary(description).into());
+                }
+                replace_deprecation(&mut schema.content, entry);
+            }
+
+            if let Some(properties) = schema
+                .content
+                .get_mut("properties")
+                .and_then(|properties| properties.as_mapping_mut())
+            {
+                for property in properties.values_mut() {
+                    let source = property.get("x-go-source");
+                    let name = source
+                        .and_then(|source| source.get("type"))
+                        .and_then(|name| name.as_str())
+                        .zip(
+                            source
+                                .and_then(|source| source.get("field"))
+                                .and_then(|field| field.as_str()),
+                        )
+                        .map(|(type_name, field)| format!("{type_name}.{field}"));
+                    if let Some(entry) = name.and_then(|name| self.get(&package, &name)) {
+                        translate_value(property, entry);
+                    }
+                }
+            }
+
+            if let Some(signatures) = schema
+                .content
+                .get_mut("signatures")
+                .and_then(|signatures| signatures.as_sequence_mut())
+            {
+                for signature in signatures {
+                    let name = signature
+                        .get("name")
+                        .and_then(|name| name.as_str())
+                        .map(|method| format!("{}.{method}", schema.name));
+                    if let Some(entry) = name.and_then(|name| self.get(&package, &name)) {
+                        translate_value(signature, entry);
+                    }
+                }
+            }
+        }
+    }
+}
+
+/// Replace the description and the deprecation notice of a field or method
+fn translate_value(value: &mut serde_yaml::Value, entry: &Entry) {
+    if let (Some(mapping), Some(description)) = (value.as_mapping_mut(), entry.description()) {
+        mapping.insert("description".into(), description.into());
+    }
+    replace_deprecation(value, entry);
+}
+
+/// Replace the notice of something deprecated; nothing else becomes deprecated
+fn replace_deprecation(value: &mut serde_yaml::Value, entry: &Entry) {
+    let Some(deprecated) = entry.deprecated() else {
+        return;
+    };
+    if let Some(mapping) = value.as_mapping_mut() {
+        if mapping.contains_key("x-go-deprecated") {
+            mapping.insert("x-go-deprecated".into(), deprecated.into());
+        }
+    }
+}
+
+/// First sentence of a description, as the summaries of the parser
+fn summary(description: &str) -> String {
+    match description.find(". ") {
+        Some(end) => description[..=end].to_string(),
+        None => description.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_struct;
+
+    const TRANSLATIONS: &str = "de:\n  User: Ein Benutzerkonto. Konten gehören Teams.\n  User.Email: E-Mail-Adresse\n  users.User.Role:\n    description: Rolle des Benutzers\n    deprecated: Stattdessen withRoles verwenden\n  admin.User.Role: Rolle\n  UserService.Get: Liest einen Benutzer\nfr:\n  User: Un compte.\n";
+
+    fn user() -> ExtractedSchema {
+        let mut schema = test_struct(
+            "User",
+            "users",
+            "/repo/users/user.go",
+            "{type: object, properties: {email: {type: string, description: Email of the user, x-go-source: {type: User, field: Email, line: 3}}, name: {type: string, description: Display name, x-go-source: {type: User, field: Name, line: 4}}, role: {type: string, x-go-deprecated: use withRoles, x-go-source: {type: User, field: Role, line: 5}}}}",
+        );
+        schema
+            .metadata
+            .insert("summary".to_string(), "User is an account.".into());
+        schema
+    }
+
+    #[test]
+    fn test_translate() {
+        let translations = Translations::parse(TRANSLATIONS, "de").unwrap();
+        let mut service = ExtractedSchema {
+            name: "UserService".to_string(),
+            content: serde_yaml::from_str(
+                "{signatures: [{name: Get, description: Get reads a user}, {name: List}]}",
+            )
+            .unwrap(),
+            ..user()
+        };
+        let mut schemas = vec![user()];
+        translations.translate(&mut schemas);
+        translations.translate(std::slice::from_mut(&mut service));
+
+        let user = &schemas[0];
+        assert_eq!(user.metadata["summary"], "Ein Benutzerkonto.");
+        let properties = &user.content["properties"];
+        assert_eq!(properties["email"]["description"], "E-Mail-Adresse");
+        assert_eq!(properties["role"]["description"], "Rolle des Benutzers");
+        assert_eq!(
+            properties["role"]["x-go-deprecated"],
+            "Stattdessen withRoles verwenden"
+        );
+
+        // Untranslated fields keep their Go comment
+        assert_eq!(properties["name"]["description"], "Display name");
+        assert_eq!(
+            service.content["signatures"][0]["description"],
+            "Liest einen Benutzer"
+        );
+        assert!(service.content["signatures"][1]
+            .get("description")
+            .is_none());
+    }
+
+    #[test]
+    fn test_parse_translations() {
+        let error = Translations::parse(TRANSLATIONS, "es").unwrap_err();
+        assert_eq!(error.to_string(), "No language es (languages: de, fr)");
+        assert!(Translations::parse("de: [User]\n", "de").is_err());
+    }
+}
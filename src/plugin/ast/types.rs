@@ -3,6 +3,8 @@
 use serde::{Deserialize, Serialize};
 use std::path::PathBuf;
 
+use super::tags::StructTag;
+
 /// Go AST node types
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub enum GoAstNode {
@@ -130,7 +132,7 @@ pub struct InterfaceTypeNode {
 /// Field node
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct FieldNode {
-    /// Field names (can be multiple for embedded fields)
+    /// Field names (embedded fields carry the embedded type's name)
     pub names: Vec<String>,
 
     /// Field type
@@ -139,6 +141,10 @@ pub struct FieldNode {
     /// Field tags
     pub tags: Option<String>,
 
+    /// Whether this is an embedded (anonymous) field
+    #[serde(default)]
+    pub embedded: bool,
+
     /// Documentation comments
     pub docs: Vec<String>,
 
@@ -146,6 +152,16 @@ pub struct FieldNode {
     pub position: Position,
 }
 
+impl FieldNode {
+    /// Parse the field's struct tag
+    pub fn struct_tag(&self) -> StructTag {
+        self.tags
+            .as_deref()
+            .map(StructTag::parse)
+            .unwrap_or_default()
+    }
+}
+
 /// Method node
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct MethodNode {
@@ -209,3 +225,22 @@ pub struct Position {
     /// Offset in file
     pub offset: usize,
 }
+
+/// An error about a construct of the source, at its position
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Diagnostic {
+    pub position: Position,
+    pub message: String,
+}
+
+impl std::fmt::Display for Diagnostic {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "{}:{}: {}",
+            self.position.file.display(),
+            self.position.line,
+            self.message
+        )
+    }
+}
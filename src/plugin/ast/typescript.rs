@@ -0,0 +1,299 @@
+//! TypeScript declarations of the generated types
+//!
+//! With the `typescript` output format (`--output-format typescript`), the
+//! types are also declared in `index.d.ts` next to the index of the
+//! libraries, so frontends consuming the JSON payloads take their types
+//! from the same Go source:
+//!
+//! ```typescript
+//! /** A user */
+//! export interface User {
+//!   name: string;
+//!   port?: number;
+//!   address?: Address | null;
+//!   readonly status?: Status;
+//! }
+//!
+//! export type Level = 0 | 1;
+//! ```
+//!
+//! Fields without `omitempty` are required. Structs are interfaces, enums
+//! the union of their values and interfaces with a union of
+//! implementations the union of the implementations.
+
+use std::collections::HashSet;
+use std::path::Path;
+
+use super::generator::quote_string;
+use super::package_dir;
+use super::references::{enum_literals, literal, TypeIndex};
+use crate::plugin::ExtractedSchema;
+
+/// File of the TypeScript declarations
+pub const DECLARATIONS_FILE: &str = "index.d.ts";
+
+/// Words that are not valid bare property names
+const RESERVED: &[&str] = &["constructor", "__proto__"];
+
+/// Generate the declarations of the types
+///
+/// A name declared by several packages is declared once, by the first.
+pub fn generate_declarations(schemas: &[ExtractedSchema]) -> String {
+    let declarations = Declarations {
+        types: TypeIndex::new(schemas),
+    };
+    let mut code = String::from("// Generated from Go AST: types\n");
+    let mut declared = HashSet::new();
+    for schema in schemas {
+        if !declared.insert(schema.name.as_str()) {
+            continue;
+        }
+        code.push('\n');
+        push_doc(&mut code, &schema.content, "");
+        code.push_str(&declarations.declaration(schema));
+    }
+    code
+}
+
+/// The declarations of the types
+struct Declarations<'a> {
+    types: TypeIndex<'a>,
+}
+
+impl Declarations<'_> {
+    /// Get the declaration of a type
+    ///
+    /// Objects with fields are interfaces; other types are aliases.
+    fn declaration(&self, schema: &ExtractedSchema) -> String {
+        let dir = package_dir(schema);
+        let content = &schema.content;
+        let empty = serde_yaml::Mapping::new();
+        let interface = content.get("type").and_then(|t| t.as_str()) == Some("object")
+            && content.get("properties").is_some()
+            && content.get("x-go-union").is_none();
+        if interface {
+            format!(
+                "export interface {} {}\n",
+                schema.name,
+                self.object(content.as_mapping().unwrap_or(&empty), &dir, "")
+            )
+        } else {
+            format!(
+                "export type {} = {};\n",
+                schema.name,
+                self.type_of(content, &dir, "")
+            )
+        }
+    }
+
+    /// Get the TypeScript type of a schema of the package in `dir`
+    fn type_of(&self, schema: &serde_yaml::Value, dir: &Path, indent: &str) -> String {
+        let Some(mapping) = schema.as_mapping() else {
+            return "unknown".to_string();
+        };
+        let mut type_name = match self.types.target_name(mapping, dir) {
+            Some(name) => name.to_string(),
+            None => self.shape(mapping, dir, indent),
+        };
+        if mapping.get("nullable").and_then(|n| n.as_bool()) == Some(true) {
+            type_name.push_str(" | null");
+        }
+        type_name
+    }
+
+    /// Get the TypeScript type of the shape of a schema
+    fn shape(&self, schema: &serde_yaml::Mapping, dir: &Path, indent: &str) -> String {
+        if let Some(values) = enum_literals(schema) {
+            return values.join(" | ");
+        }
+        let variants: Vec<&str> = self
+            .types
+            .variants(schema, dir)
+            .into_iter()
+            .map(|idx| self.types.schema(idx).name.as_str())
+            .collect();
+        if !variants.is_empty() {
+            return variants.join(" | ");
+        }
+
+        match schema.get("type").and_then(|t| t.as_str()) {
+            Some("string") => "string".to_string(),
+            Some("integer" | "number") => "number".to_string(),
+            Some("boolean") => "boolean".to_string(),
+            Some("array") => {
+                let items = schema
+                    .get("items")
+                    .map(|items| self.type_of(items, dir, indent))
+                    .unwrap_or_else(|| "unknown".to_string());
+                if items.contains(' ') {
+                    format!("({items})[]")
+                } else {
+                    format!("{items}[]")
+                }
+            }
+            Some("object") => {
+                match (schema.get("properties"), schema.get("additionalProperties")) {
+                    (Some(_), _) => self.object(schema, dir, indent),
+                    (None, Some(values)) if values.is_mapping() => {
+                        format!("Record<string, {}>", self.type_of(values, dir, indent))
+                    }
+                    _ => "Record<string, unknown>".to_string(),
+                }
+            }
+            _ => "unknown".to_string(),
+        }
+    }
+
+    /// Get the TypeScript object type of an object schema with fields
+    fn object(&self, schema: &serde_yaml::Mapping, dir: &Path, indent: &str) -> String {
+        let required: Vec<&str> = schema
+            .get("required")
+            .and_then(|r| r.as_sequence())
+            .into_iter()
+            .flatten()
+            .filter_map(|name| name.as_str())
+            .collect();
+        let inner = format!("{indent}  ");
+
+        let mut code = String::from("{\n");
+        let properties = schema.get("properties").and_then(|p| p.as_mapping());
+        for (name, property) in properties.into_iter().flatten() {
+            let Some(name) = name.as_str() else {
+                continue;
+            };
+            push_doc(&mut code, property, &inner);
+            let readonly = match property.get("readOnly").and_then(|r| r.as_bool()) {
+                Some(true) => "readonly ",
+                _ => "",
+            };
+            let optional = if required.contains(&name) { "" } else { "?" };
+            code.push_str(&format!(
+                "{inner}{readonly}{}{optional}: {};\n",
+                property_name(name),
+                self.type_of(property, dir, &inner)
+            ));
+        }
+        if let Some(values) = schema
+            .get("additionalProperties")
+            .filter(|v| v.is_mapping())
+        {
+            code.push_str(&format!(
+                "{inner}[key: string]: {};\n",
+                self.type_of(values, dir, &inner)
+            ));
+        }
+        code.push_str(&format!("{indent}}}"));
+        code
+    }
+}
+
+/// Write the description, default and deprecation of a schema as JSDoc
+fn push_doc(code: &mut String, schema: &serde_yaml::Value, indent: &str) {
+    let mut lines: Vec<String> = schema
+        .get("description")
+        .and_then(|d| d.as_str())
+        .map(|description| description.lines().map(str::to_string).collect())
+        .unwrap_or_default();
+    if let Some(default) = schema.get("default") {
+        lines.push(format!("@default {}", literal(default)));
+    }
+    if let Some(deprecated) = schema.get("x-go-deprecated") {
+        lines.push(format!(
+            "@deprecated {}",
+            deprecated.as_str().unwrap_or_default()
+        ));
+    }
+
+    match lines.as_slice() {
+        [] => {}
+        [line] => code.push_str(&format!("{indent}/** {} */\n", line.trim_end())),
+        lines => {
+            code.push_str(&format!("{indent}/**\n"));
+            for line in lines {
+                code.push_str(format!("{indent} * {line}").trim_end());
+                code.push('\n');
+            }
+            code.push_str(&format!("{indent} */\n"));
+        }
+    }
+}
+
+/// Get the name of a property, quoted unless it is a plain identifier
+fn property_name(name: &str) -> String {
+    let mut chars = name.chars();
+    let plain = chars
+        .next()
+        .is_some_and(|c| c.is_ascii_alphabetic() || c == '_' || c == '$')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '$')
+        && !RESERVED.contains(&name);
+    if plain {
+        name.to_string()
+    } else {
+        quote_string(name)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_generate_declarations() {
+        let schemas = vec![
+            test_schema("User", "go_struct", "api/user.go", "{type: object, description: A user, required: [name], properties: {address: {type: object, nullable: true, x-go-struct-ref: Address}, labels: {type: object, additionalProperties: {type: string}}, name: {type: string}, old: {type: string, x-go-deprecated: Use name}, port: {type: integer, default: 8080, description: Port to listen on}, shapes: {type: array, items: {type: object, x-go-interface-ref: Shape}}, status: {type: object, readOnly: true, properties: {ready: {type: boolean}}}, x-trace: {type: string}}}"),
+            test_schema("Shape", "go_struct", "api/shape.go", "{type: object, x-go-union: {discriminator: kind, variants: [{name: Circle}, {name: Square}]}}"),
+            test_schema("Level", "go_struct", "api/level.go", "{type: integer, x-go-enum: {flags: false, values: [{name: LevelDebug, value: 0}, {name: LevelInfo, value: 1}]}}"),
+            test_schema("Labels", "go_struct", "api/labels.go", "{type: object}"),
+            // Declared by the first package only
+            test_schema("Level", "go_struct", "other/level.go", "{type: string}"),
+        ];
+
+        assert_eq!(
+            generate_declarations(&schemas),
+            concat!(
+                "// Generated from Go AST: types\n",
+                "\n",
+                "/** A user */\n",
+                "export interface User {\n",
+                "  address?: Record<string, unknown> | null;\n",
+                "  labels?: Record<string, string>;\n",
+                "  name: string;\n",
+                "  /** @deprecated Use name */\n",
+                "  old?: string;\n",
+                "  /**\n",
+                "   * Port to listen on\n",
+                "   * @default 8080\n",
+                "   */\n",
+                "  port?: number;\n",
+                "  shapes?: Shape[];\n",
+                "  readonly status?: {\n",
+                "    ready?: boolean;\n",
+                "  };\n",
+                "  \"x-trace\"?: string;\n",
+                "}\n",
+                "\n",
+                "export type Shape = Record<string, unknown>;\n",
+                "\n",
+                "export type Level = 0 | 1;\n",
+                "\n",
+                "export type Labels = Record<string, unknown>;\n",
+            )
+        );
+    }
+
+    #[test]
+    fn test_generate_declarations_references() {
+        let schemas = vec![
+            test_schema("Drawing", "go_struct", "api/drawing.go", "{type: object, properties: {owner: {type: object, nullable: true, x-go-struct-ref: Circle}, shapes: {type: array, items: {type: object, nullable: true, x-go-interface-ref: Shape}}}}"),
+            test_schema("Shape", "go_struct", "api/shape.go", "{type: object, x-go-union: {discriminator: kind, variants: [{name: Circle}]}}"),
+            test_schema("Circle", "go_struct", "api/circle.go", "{type: object, properties: {radius: {type: number}}}"),
+        ];
+
+        let code = generate_declarations(&schemas);
+        assert!(code.contains("  owner?: Circle | null;\n"));
+        assert!(code.contains("  shapes?: (Shape | null)[];\n"));
+        assert!(code.contains("export type Shape = Circle;\n"));
+        assert!(code.contains("export interface Circle {\n  radius?: number;\n}\n"));
+    }
+}
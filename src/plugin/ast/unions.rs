@@ -0,0 +1,452 @@
+//! Union helpers for Go interfaces
+//!
+//! Go types implement interfaces implicitly, and their methods may be
+//! declared in any file of the package, so the implementations of an
+//! interface are only known once the whole module is parsed. The parser
+//! records the methods of each interface and the method set of each receiver
+//! type per file; `resolve_unions` merges the sets and records the
+//! implementations on the interface schema, from which the generator builds
+//! a library with a constructor per implementation.
+//!
+//! Pointer and value receivers are not told apart, and methods promoted from
+//! embedded fields are not seen. Marker interfaces, whose only method is an
+//! unexported one such as `isShape()`, are matched like any other.
+//!
+//! Unions of struct types can also be declared with the `unions` option,
+//! for types without a common interface; they get a library of their own
+//! next to their variants.
+
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::path::PathBuf;
+
+use super::generator::GoJsonnetGenerator;
+use super::options::UnionOptions;
+use super::package_dir;
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+/// Schema type of the method sets returned by the parser
+///
+/// These are not types of their own and are removed by `resolve_unions`.
+pub const METHODS_SCHEMA_TYPE: &str = "go_methods";
+
+/// Field telling the implementations of an interface apart, unless the
+/// interface names another with `+gensonnet:discriminator=field`
+pub const DEFAULT_DISCRIMINATOR: &str = "kind";
+
+/// Record the implementations of each interface on its schema
+///
+/// Removes the method sets from `schemas`, adds a schema per declared union
+/// and returns warnings for interfaces no type implements, for embedded
+/// interfaces declared outside the module and for declared unions that
+/// cannot be generated. With `partial`, `schemas` holds part of the module,
+/// and declared unions none of whose variants it holds are left alone.
+pub fn resolve_unions(
+    schemas: &mut Vec<ExtractedSchema>,
+    declared: &BTreeMap<String, UnionOptions>,
+    partial: bool,
+) -> Vec<String> {
+    let mut method_sets: HashMap<(PathBuf, String), BTreeSet<String>> = HashMap::new();
+    for schema in schemas.iter() {
+        if schema.schema_type == METHODS_SCHEMA_TYPE {
+            method_sets
+                .entry((package_dir(schema), schema.name.clone()))
+                .or_default()
+                .extend(strings(schema.content.as_sequence()));
+        }
+    }
+    schemas.retain(|schema| schema.schema_type != METHODS_SCHEMA_TYPE);
+
+    let generator = GoJsonnetGenerator::new();
+    let mut warnings = Vec::new();
+    let mut unions = Vec::new();
+
+    for (idx, interface) in schemas.iter().enumerate() {
+        let Some(definition) = interface.content.get("x-go-interface") else {
+            continue;
+        };
+        let mut unresolved = Vec::new();
+        let required = interface_methods(schemas, interface, &mut Vec::new(), &mut unresolved);
+        for embedded in unresolved {
+            warnings.push(Code::ExternalInterface.warning(format!(
+                "{} embeds {}, which is not declared in the module; implementations are matched on its other methods",
+                interface.name, embedded
+            )));
+        }
+        if required.is_empty() {
+            continue;
+        }
+
+        let discriminator = definition
+            .get("discriminator")
+            .and_then(|d| d.as_str())
+            .unwrap_or(DEFAULT_DISCRIMINATOR);
+        let mut variants: Vec<&ExtractedSchema> = schemas
+            .iter()
+            .filter(|candidate| {
+                candidate.content.get("properties").is_some()
+                    && candidate.content.get("x-go-marshaler").is_none()
+                    && method_sets
+                        .get(&(package_dir(candidate), candidate.name.clone()))
+                        .is_some_and(|methods| methods.is_superset(&required))
+            })
+            .collect();
+        if variants.is_empty() {
+            warnings.push(Code::NoImplementations.warning(format!(
+                "No type in the module implements {}; its library has no constructors",
+                interface.name
+            )));
+            continue;
+        }
+        variants.sort_by(|a, b| a.name.cmp(&b.name));
+        unions.push((idx, union(&generator, discriminator, &variants)));
+    }
+
+    for (idx, union) in unions {
+        if let Some(content) = schemas[idx].content.as_mapping_mut() {
+            content.insert("x-go-union".into(), union);
+        }
+    }
+
+    for (name, options) in declared {
+        match declared_union(schemas, name, options, partial) {
+            Ok(Some(schema)) => schemas.push(schema),
+            Ok(None) => {}
+            Err(warning) => warnings.push(Code::DeclaredUnion.warning(warning)),
+        }
+    }
+
+    warnings
+}
+
+/// Build the schema of a union declared with the `unions` option
+///
+/// Fails with the reason when a variant is missing or in another package,
+/// or when a type of the package has the name of the union.
+fn declared_union(
+    schemas: &[ExtractedSchema],
+    name: &str,
+    options: &UnionOptions,
+    partial: bool,
+) -> Result<Option<ExtractedSchema>, String> {
+    let found: Vec<(&String, Option<&ExtractedSchema>)> = options
+        .variants
+        .iter()
+        .map(|variant| {
+            let schema = schemas.iter().find(|schema| {
+                schema.content.get("properties").is_some() && names_type(variant, schema)
+            });
+            (variant, schema)
+        })
+        .collect();
+    let Some(first) = found.iter().find_map(|(_, schema)| *schema) else {
+        return match partial {
+            true => Ok(None),
+            false => Err(format!(
+                "Union {name} has no variant that is a struct of the module; it is not generated"
+            )),
+        };
+    };
+
+    let dir = package_dir(first);
+    let mut variants = Vec::new();
+    for (variant, schema) in found {
+        match schema {
+            Some(schema) if package_dir(schema) == dir => variants.push(schema),
+            Some(_) => {
+                return Err(format!(
+                    "Union {name} has variants in several packages, {variant} and {}; it is not generated",
+                    first.name
+                ))
+            }
+            None => {
+                return Err(format!(
+                    "Union {name} names {variant}, which is not a struct of the module; it is not generated"
+                ))
+            }
+        }
+    }
+    if schemas
+        .iter()
+        .any(|schema| schema.name == name && package_dir(schema) == dir)
+    {
+        return Err(format!(
+            "Union {name} has the name of a type of its package; it is not generated"
+        ));
+    }
+
+    let discriminator = options
+        .discriminator
+        .as_deref()
+        .unwrap_or(DEFAULT_DISCRIMINATOR);
+    let mut content = serde_yaml::Mapping::new();
+    content.insert("type".into(), "object".into());
+    content.insert(
+        "x-go-union".into(),
+        union(&GoJsonnetGenerator::new(), discriminator, &variants),
+    );
+    Ok(Some(ExtractedSchema {
+        name: name.to_string(),
+        schema_type: first.schema_type.clone(),
+        content: serde_yaml::Value::Mapping(content),
+        source_file: first.source_file.clone(),
+        metadata: first
+            .metadata
+            .get("package")
+            .map(|package| HashMap::from([("package".to_string(), package.clone())]))
+            .unwrap_or_default(),
+    }))
+}
+
+/// Whether a `Type` or `pkg.Type` names a schema
+fn names_type(variant: &str, schema: &ExtractedSchema) -> bool {
+    match variant.rsplit_once('.') {
+        Some((package, type_name)) => {
+            type_name == schema.name
+                && schema.metadata.get("package").and_then(|p| p.as_str()) == Some(package)
+        }
+        None => variant == schema.name,
+    }
+}
+
+/// Describe a union of variants for the generator
+fn union(
+    generator: &GoJsonnetGenerator,
+    discriminator: &str,
+    variants: &[&ExtractedSchema],
+) -> serde_yaml::Value {
+    let variants = variants
+        .iter()
+        .map(|variant| {
+            let properties = variant
+                .content
+                .get("properties")
+                .and_then(|p| p.as_mapping());
+
+            // A discriminator field with a default keeps its value
+            let value = properties
+                .and_then(|p| p.get(discriminator))
+                .and_then(|p| p.get("default"))
+                .and_then(|d| d.as_str())
+                .unwrap_or(&variant.name);
+
+            let mut entry = serde_yaml::Mapping::new();
+            entry.insert("name".into(), variant.name.as_str().into());
+            entry.insert("file".into(), generator.file_name(variant).into());
+            entry.insert("value".into(), value.into());
+            entry.insert(
+                "params".into(),
+                serde_yaml::Value::Sequence(
+                    generator
+                        .new_params(variant)
+                        .into_iter()
+                        .map(serde_yaml::Value::String)
+                        .collect(),
+                ),
+            );
+
+            // Fields of other variants must not be set on a value
+            entry.insert(
+                "fields".into(),
+                serde_yaml::Value::Sequence(
+                    properties
+                        .into_iter()
+                        .flat_map(|p| p.keys())
+                        .filter(|field| field.as_str() != Some(discriminator))
+                        .cloned()
+                        .collect(),
+                ),
+            );
+            serde_yaml::Value::Mapping(entry)
+        })
+        .collect();
+
+    let mut union = serde_yaml::Mapping::new();
+    union.insert("discriminator".into(), discriminator.into());
+    union.insert("variants".into(), serde_yaml::Value::Sequence(variants));
+    serde_yaml::Value::Mapping(union)
+}
+
+/// Get the methods of an interface, including those of embedded interfaces
+///
+/// Embedded interfaces are looked up in the package of the interface first.
+/// Names that are not declared in the module are added to `unresolved`.
+fn interface_methods<'a>(
+    schemas: &'a [ExtractedSchema],
+    interface: &'a ExtractedSchema,
+    visited: &mut Vec<&'a str>,
+    unresolved: &mut Vec<String>,
+) -> BTreeSet<String> {
+    visited.push(&interface.name);
+    let definition = interface.content.get("x-go-interface");
+    let mut methods: BTreeSet<String> = strings(
+        definition
+            .and_then(|d| d.get("methods"))
+            .and_then(|m| m.as_sequence()),
+    )
+    .collect();
+
+    let embeds = definition
+        .and_then(|d| d.get("embeds"))
+        .and_then(|e| e.as_sequence());
+    for embedded in strings(embeds) {
+        if visited.contains(&embedded.as_str()) {
+            continue;
+        }
+        let dir = package_dir(interface);
+        let is_interface = |schema: &&ExtractedSchema| {
+            schema.name == embedded && schema.content.get("x-go-interface").is_some()
+        };
+        let found = schemas
+            .iter()
+            .filter(is_interface)
+            .find(|schema| package_dir(schema) == dir)
+            .or_else(|| schemas.iter().find(is_interface));
+        match found {
+            Some(found) => methods.extend(interface_methods(schemas, found, visited, unresolved)),
+            None => unresolved.push(embedded),
+        }
+    }
+
+    methods
+}
+
+/// Collect the strings of a YAML sequence
+fn strings(values: Option<&serde_yaml::Sequence>) -> impl Iterator<Item = String> + '_ {
+    values
+        .into_iter()
+        .flatten()
+        .filter_map(|v| v.as_str())
+        .map(str::to_string)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::{test_schema, test_struct};
+
+    #[test]
+    fn test_resolve_unions() {
+        let mut schemas = vec![
+            test_schema("Storage", "go_struct", "api/storage.go", "{type: object, x-go-interface: {embeds: [Closer], methods: [Get, Put]}}"),
+            test_schema("Closer", "go_struct", "api/storage.go", "{type: object, x-go-interface: {methods: [Close]}}"),
+            test_schema("S3Storage", "go_struct", "api/s3.go", "{properties: {bucket: {type: string, x-go-constructor-param: true}}, type: object}"),
+            test_schema("MemoryStorage", "go_struct", "api/memory.go", "{properties: {kind: {default: memory, type: string}}, type: object}"),
+            test_schema("Config", "go_struct", "api/config.go", "{properties: {}, type: object}"),
+            // Methods declared next to the type and in another file
+            test_schema("S3Storage", METHODS_SCHEMA_TYPE, "api/s3.go", "[Get, Put]"),
+            test_schema("S3Storage", METHODS_SCHEMA_TYPE, "api/s3_close.go", "[Close]"),
+            test_schema("MemoryStorage", METHODS_SCHEMA_TYPE, "api/memory.go", "[Close, Get, Put]"),
+            test_schema("Config", METHODS_SCHEMA_TYPE, "api/config.go", "[Get]"),
+        ];
+
+        let warnings = resolve_unions(&mut schemas, &BTreeMap::new(), false);
+        assert_eq!(schemas.len(), 5);
+
+        let union = schemas[0].content.get("x-go-union").unwrap();
+        assert_eq!(union.get("discriminator").unwrap().as_str(), Some("kind"));
+        let variants = union.get("variants").unwrap().as_sequence().unwrap();
+        let names: Vec<&str> = variants
+            .iter()
+            .map(|v| v.get("name").unwrap().as_str().unwrap())
+            .collect();
+        assert_eq!(names, vec!["MemoryStorage", "S3Storage"]);
+        assert_eq!(variants[0].get("value").unwrap().as_str(), Some("memory"));
+        assert_eq!(
+            variants[1].get("value").unwrap().as_str(),
+            Some("S3Storage")
+        );
+        assert_eq!(
+            variants[1].get("file").unwrap().as_str(),
+            Some("s3storage.libsonnet")
+        );
+        assert_eq!(
+            variants[1].get("params").unwrap().as_sequence().unwrap()[0].as_str(),
+            Some("bucket")
+        );
+        assert_eq!(
+            variants[1].get("fields").unwrap()[0].as_str(),
+            Some("bucket")
+        );
+        // The discriminator is not a field of a variant
+        assert!(variants[0]
+            .get("fields")
+            .unwrap()
+            .as_sequence()
+            .unwrap()
+            .is_empty());
+
+        // Closer is implemented by the same types
+        assert!(schemas[1].content.get("x-go-union").is_some());
+        assert!(warnings.is_empty());
+    }
+
+    #[test]
+    fn test_resolve_declared_unions() {
+        let source = |name: &str, file: &str, fields: &str| {
+            test_struct(
+                name,
+                "api",
+                file,
+                &format!("{{properties: {fields}, type: object}}"),
+            )
+        };
+        let mut schemas = vec![
+            source("GitSource", "api/git.go", "{url: {type: string}}"),
+            source("ImageSource", "api/image.go", "{image: {type: string}}"),
+            source("Source", "other/source.go", "{}"),
+            source("Mirror", "other/mirror.go", "{}"),
+        ];
+        let declared: BTreeMap<String, UnionOptions> = serde_yaml::from_str(
+            "{Source: {variants: [GitSource, api.ImageSource], discriminator: type}, \
+             Mixed: {variants: [GitSource, Mirror]}, \
+             Missing: {variants: [GitSource, OciSource]}, \
+             Elsewhere: {variants: [OciSource]}}",
+        )
+        .unwrap();
+
+        let warnings = resolve_unions(&mut schemas, &declared, false);
+        assert_eq!(schemas.len(), 5);
+        let union = &schemas[4];
+        assert_eq!(union.name, "Source");
+        assert_eq!(union.source_file, PathBuf::from("api/git.go"));
+        let union = union.content.get("x-go-union").unwrap();
+        assert_eq!(union.get("discriminator").unwrap().as_str(), Some("type"));
+        let variants = union.get("variants").unwrap().as_sequence().unwrap();
+        assert_eq!(
+            variants[1].get("name").unwrap().as_str(),
+            Some("ImageSource")
+        );
+        assert_eq!(
+            variants[1].get("fields").unwrap()[0].as_str(),
+            Some("image")
+        );
+
+        assert_eq!(warnings.len(), 3);
+        assert!(warnings[0].starts_with("GS0303: Union Elsewhere has no variant"));
+        assert!(warnings[1].starts_with("GS0303: Union Missing names OciSource"));
+        assert!(warnings[2].starts_with("GS0303: Union Mixed has variants in several packages"));
+
+        // Parts of the module without any variant leave the union alone
+        let mut schemas = vec![source("Mirror", "other/mirror.go", "{}")];
+        let warnings = resolve_unions(&mut schemas, &declared, true);
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].starts_with("GS0303: Union Mixed names GitSource"));
+    }
+
+    #[test]
+    fn test_resolve_unions_warnings() {
+        let mut schemas = vec![test_schema(
+            "Handler",
+            "go_struct",
+            "api/handler.go",
+            "{type: object, x-go-interface: {embeds: [io.Closer], methods: [Serve]}}",
+        )];
+
+        let warnings = resolve_unions(&mut schemas, &BTreeMap::new(), false);
+        assert_eq!(warnings.len(), 2);
+        assert!(warnings[0].starts_with("GS0301: Handler embeds io.Closer"));
+        assert!(warnings[1].starts_with("GS0302: No type in the module implements Handler"));
+        assert!(schemas[0].content.get("x-go-union").is_none());
+    }
+}
@@ -0,0 +1,273 @@
+//! Report of the Go constructs a run could not represent
+//!
+//! Warnings are meant for people reading the log; the report lists the same
+//! constructs as records, with an ID that stays the same from run to run as
+//! long as the construct is there, so remediation can be tracked in an issue
+//! tracker. IDs are derived from the source, the file relative to the
+//! repository, the type, the field and the construct, not from the line.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::path::{Path, PathBuf};
+
+use crate::diagnostics::Code;
+use crate::plugin::ExtractedSchema;
+
+/// Schema type of the unsupported constructs returned by the parser
+///
+/// These are not types of their own and are removed by `take_unsupported`.
+pub const UNSUPPORTED_SCHEMA_TYPE: &str = "go_unsupported";
+
+/// Kind of construct the generator cannot represent faithfully
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum UnsupportedKind {
+    /// Type parameters or instantiations of generic types
+    Generic,
+
+    /// Types encoding themselves with a `MarshalJSON`-style method
+    CustomMarshaler,
+
+    /// `validate` rules without a Jsonnet check
+    ValidateRule,
+
+    /// `json` and `yaml` tag options that change the encoding
+    TagOption,
+
+    /// Map keys encoding/json cannot encode
+    MapKey,
+
+    /// Types using C types of cgo, which are not generated
+    Cgo,
+}
+
+impl UnsupportedKind {
+    /// Get the name of the kind in reports
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            UnsupportedKind::Generic => "generic",
+            UnsupportedKind::CustomMarshaler => "custom_marshaler",
+            UnsupportedKind::ValidateRule => "validate_rule",
+            UnsupportedKind::TagOption => "tag_option",
+            UnsupportedKind::MapKey => "map_key",
+            UnsupportedKind::Cgo => "cgo",
+        }
+    }
+
+    /// Get the code of the warnings about constructs of this kind
+    pub fn code(&self) -> Code {
+        match self {
+            UnsupportedKind::Generic => Code::Generic,
+            UnsupportedKind::CustomMarshaler => Code::CustomMarshaler,
+            UnsupportedKind::ValidateRule => Code::ValidateRule,
+            UnsupportedKind::TagOption => Code::TagOption,
+            UnsupportedKind::MapKey => Code::MapKey,
+            UnsupportedKind::Cgo => Code::Cgo,
+        }
+    }
+}
+
+/// A construct the run could not handle
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Unsupported {
+    /// Stable identifier, set by `take_unsupported`
+    #[serde(default)]
+    pub id: String,
+
+    pub kind: UnsupportedKind,
+
+    /// Name of the configured source, set by `take_unsupported`
+    #[serde(default)]
+    pub source: String,
+
+    /// Go file, relative to the repository once taken
+    pub file: PathBuf,
+
+    pub line: usize,
+
+    #[serde(rename = "type")]
+    pub type_name: String,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub field: Option<String>,
+
+    /// The construct itself: rule, tag option, interface or type expression
+    pub construct: String,
+
+    pub message: String,
+}
+
+impl Unsupported {
+    /// Wrap the construct in a schema, to return it with the parsed types
+    pub fn to_schema(&self) -> ExtractedSchema {
+        ExtractedSchema {
+            name: self.type_name.clone(),
+            schema_type: UNSUPPORTED_SCHEMA_TYPE.to_string(),
+            content: serde_yaml::to_value(self).unwrap_or_default(),
+            source_file: self.file.clone(),
+            metadata: Default::default(),
+        }
+    }
+
+    /// Compute the stable identifier of the construct
+    fn stable_id(&self) -> String {
+        let mut hasher = Sha256::new();
+        for part in [
+            self.source.as_str(),
+            self.kind.as_str(),
+            &self.file.to_string_lossy().replace('\\', "/"),
+            &self.type_name,
+            self.field.as_deref().unwrap_or(""),
+            &self.construct,
+        ] {
+            hasher.update(part.as_bytes());
+            hasher.update([0]);
+        }
+        format!("GS-{}", &hex::encode(hasher.finalize())[..10])
+    }
+}
+
+/// Remove the unsupported constructs from `schemas`
+///
+/// Files are made relative to `repo_path` and each construct gets its
+/// source name and ID. The constructs are sorted by file, line and ID.
+pub fn take_unsupported(
+    schemas: &mut Vec<ExtractedSchema>,
+    source: &str,
+    repo_path: &Path,
+) -> Vec<Unsupported> {
+    let mut constructs: Vec<Unsupported> = schemas
+        .iter()
+        .filter(|schema| schema.schema_type == UNSUPPORTED_SCHEMA_TYPE)
+        .filter_map(|schema| serde_yaml::from_value::<Unsupported>(schema.content.clone()).ok())
+        .map(|mut construct| {
+            construct.source = source.to_string();
+            if let Ok(relative) = construct.file.strip_prefix(repo_path) {
+                construct.file = relative.to_path_buf();
+            }
+            construct.id = construct.stable_id();
+            construct
+        })
+        .collect();
+    schemas.retain(|schema| schema.schema_type != UNSUPPORTED_SCHEMA_TYPE);
+
+    constructs.sort_by(|a, b| (&a.file, a.line, &a.id).cmp(&(&b.file, b.line, &b.id)));
+    constructs.dedup_by(|a, b| a.id == b.id);
+    constructs
+}
+
+/// Render a report as JSON, or as CSV for a path ending in `.csv`
+pub fn render_report(path: &Path, constructs: &[Unsupported]) -> Result<String> {
+    match path.extension().and_then(|ext| ext.to_str()) {
+        Some("csv") => Ok(to_csv(constructs)),
+        Some("json") | None => Ok(serde_json::to_string_pretty(constructs)? + "\n"),
+        Some(other) => Err(anyhow!(
+            "Unsupported report format '{}' of {}; use .json or .csv",
+            other,
+            path.display()
+        )),
+    }
+}
+
+/// Render a report as CSV, with a header row
+fn to_csv(constructs: &[Unsupported]) -> String {
+    let mut csv = String::from("id,kind,source,file,line,type,field,construct,message\n");
+    for construct in constructs {
+        let row = [
+            construct.id.clone(),
+            construct.kind.as_str().to_string(),
+            construct.source.clone(),
+            construct.file.to_string_lossy().replace('\\', "/"),
+            construct.line.to_string(),
+            construct.type_name.clone(),
+            construct.field.clone().unwrap_or_default(),
+            construct.construct.clone(),
+            construct.message.clone(),
+        ];
+        let cells: Vec<String> = row.iter().map(|cell| csv_cell(cell)).collect();
+        csv.push_str(&cells.join(","));
+        csv.push('\n');
+    }
+    csv
+}
+
+/// Quote a CSV cell when it holds a separator, a quote or a line break
+fn csv_cell(cell: &str) -> String {
+    if cell.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", cell.replace('"', "\"\""))
+    } else {
+        cell.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn construct(file: &str, line: usize, construct: &str) -> Unsupported {
+        Unsupported {
+            id: String::new(),
+            kind: UnsupportedKind::ValidateRule,
+            source: String::new(),
+            file: file.into(),
+            line,
+            type_name: "Server".to_string(),
+            field: Some("Color".to_string()),
+            construct: construct.to_string(),
+            message: format!("Server.Color: validate rule '{construct}' is not supported"),
+        }
+    }
+
+    #[test]
+    fn test_take_unsupported() {
+        let mut schemas = vec![
+            construct("/repo/api/server.go", 12, "hexcolor|rgb").to_schema(),
+            construct("/repo/api/server.go", 3, "iscolor").to_schema(),
+            ExtractedSchema {
+                name: "Server".to_string(),
+                schema_type: "go_struct".to_string(),
+                content: serde_yaml::Value::Null,
+                source_file: "/repo/api/server.go".into(),
+                metadata: Default::default(),
+            },
+        ];
+
+        let constructs = take_unsupported(&mut schemas, "api", Path::new("/repo"));
+        assert_eq!(schemas.len(), 1);
+        assert_eq!(constructs.len(), 2);
+        assert_eq!(constructs[0].line, 3);
+        assert_eq!(constructs[0].file, PathBuf::from("api/server.go"));
+        assert_eq!(constructs[0].source, "api");
+        assert!(constructs[0].id.starts_with("GS-"));
+
+        // Moving the construct keeps its ID; checking out elsewhere too
+        let mut moved = vec![construct("/other/api/server.go", 40, "iscolor").to_schema()];
+        let moved = take_unsupported(&mut moved, "api", Path::new("/other"));
+        assert_eq!(moved[0].id, constructs[0].id);
+        assert_ne!(constructs[0].id, constructs[1].id);
+    }
+
+    #[test]
+    fn test_render_report() {
+        let mut schemas = vec![construct("/repo/api/server.go", 12, "hexcolor|rgb").to_schema()];
+        let constructs = take_unsupported(&mut schemas, "api", Path::new("/repo"));
+
+        let csv = render_report(Path::new("unsupported.csv"), &constructs).unwrap();
+        let mut lines = csv.lines();
+        assert_eq!(
+            lines.next(),
+            Some("id,kind,source,file,line,type,field,construct,message")
+        );
+        let row = lines.next().unwrap();
+        assert!(row.contains(",validate_rule,api,api/server.go,12,Server,Color,hexcolor|rgb,"));
+
+        let json = render_report(Path::new("unsupported.json"), &constructs).unwrap();
+        let value: serde_json::Value = serde_json::from_str(&json).unwrap();
+        assert_eq!(value[0]["type"], "Server");
+        assert_eq!(value[0]["kind"], "validate_rule");
+
+        assert!(render_report(Path::new("unsupported.xml"), &constructs).is_err());
+        assert_eq!(csv_cell("a,\"b\""), "\"a,\"\"b\"\"\"");
+    }
+}
@@ -0,0 +1,452 @@
+//! go-playground/validator tag translation
+//!
+//! Translates `validate:"..."` rules into JSON Schema keywords on the field
+//! schema (`enum`, `minLength`, `maximum`, `format`, ...), which the generator
+//! turns into Jsonnet assertions. Rules after `dive` apply to the elements of
+//! a slice or the values of a map.
+
+/// Rules relating a field to other fields of the same struct
+///
+/// They are recorded under [`FIELD_RULES_KEY`] with the Go names of the other
+/// fields, and checked by object-level assertions.
+pub const CROSS_FIELD_RULES: &[&str] = &[
+    "eqfield",
+    "nefield",
+    "gtfield",
+    "gtefield",
+    "ltfield",
+    "ltefield",
+    "required_with",
+    "required_with_all",
+    "required_without",
+    "required_without_all",
+];
+
+/// Schema key holding a field's cross-field rules
+pub const FIELD_RULES_KEY: &str = "x-go-field-rules";
+
+/// A single validator rule such as `min=3` or `email`
+#[derive(Debug, Clone, PartialEq)]
+pub struct Rule {
+    /// Rule name
+    pub name: String,
+
+    /// Rule parameter, with `0x2C` and `0x7C` escapes decoded
+    pub param: Option<String>,
+}
+
+/// Rules of a `validate` tag, split at each `dive`
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ValidateRules {
+    /// Rules for the field itself; alternatives joined with `|` share a group
+    pub rules: Vec<Vec<Rule>>,
+
+    /// Rules for the elements, after a `dive`
+    pub dive: Option<Box<ValidateRules>>,
+
+    /// Whether the rules contained a `keys ... endkeys` block for map keys
+    pub has_key_rules: bool,
+}
+
+impl ValidateRules {
+    /// Parse the value of a `validate` tag
+    pub fn parse(tag: &str) -> Self {
+        let mut levels = vec![ValidateRules::default()];
+        let mut in_keys = false;
+
+        for token in tag.split(',').map(str::trim).filter(|t| !t.is_empty()) {
+            match token {
+                "dive" => levels.push(ValidateRules::default()),
+                "keys" => {
+                    in_keys = true;
+                    levels.last_mut().unwrap().has_key_rules = true;
+                }
+                "endkeys" => in_keys = false,
+                _ if in_keys => {}
+                _ => {
+                    let group = token.split('|').map(parse_rule).collect();
+                    levels.last_mut().unwrap().rules.push(group);
+                }
+            }
+        }
+
+        let mut rules = levels.pop().unwrap();
+        while let Some(mut parent) = levels.pop() {
+            parent.dive = Some(Box::new(rules));
+            rules = parent;
+        }
+        rules
+    }
+
+    /// Check whether the field itself (not its elements) is `required`
+    pub fn is_required(&self) -> bool {
+        self.rules
+            .iter()
+            .any(|group| group.len() == 1 && group[0].name == "required")
+    }
+
+    /// Add the schema keywords for these rules to a field schema
+    ///
+    /// Returns the rules that have no schema equivalent.
+    pub fn apply(&self, schema: &mut serde_yaml::Mapping) -> Vec<String> {
+        let mut unsupported = Vec::new();
+        let schema_type = schema
+            .get("type")
+            .and_then(|t| t.as_str())
+            .unwrap_or("object")
+            .to_string();
+
+        if self.has_key_rules {
+            unsupported.push("keys".to_string());
+        }
+
+        for group in &self.rules {
+            if group.len() > 1 {
+                let names: Vec<&str> = group.iter().map(|r| r.name.as_str()).collect();
+                unsupported.push(names.join("|"));
+                continue;
+            }
+            if !apply_rule(&group[0], &schema_type, schema) {
+                unsupported.push(group[0].to_string());
+            }
+        }
+
+        if let Some(dive) = &self.dive {
+            let target = match schema_type.as_str() {
+                "array" => "items",
+                _ => "additionalProperties",
+            };
+            match schema.get_mut(target).and_then(|t| t.as_mapping_mut()) {
+                Some(inner) => unsupported.extend(dive.apply(inner)),
+                None => unsupported.push("dive".to_string()),
+            }
+        }
+
+        unsupported
+    }
+}
+
+impl std::fmt::Display for Rule {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match &self.param {
+            Some(param) => write!(f, "{}={}", self.name, param),
+            None => write!(f, "{}", self.name),
+        }
+    }
+}
+
+/// Parse a `name=param` rule
+fn parse_rule(token: &str) -> Rule {
+    match token.split_once('=') {
+        Some((name, param)) => Rule {
+            name: name.trim().to_string(),
+            param: Some(param.replace("0x2C", ",").replace("0x7C", "|")),
+        },
+        None => Rule {
+            name: token.trim().to_string(),
+            param: None,
+        },
+    }
+}
+
+/// Which bound a rule sets
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Bound {
+    Min,
+    Max,
+    ExclusiveMin,
+    ExclusiveMax,
+}
+
+/// Add the schema keyword for a rule, returning false if it is unsupported
+fn apply_rule(rule: &Rule, schema_type: &str, schema: &mut serde_yaml::Mapping) -> bool {
+    let param = rule.param.as_deref().unwrap_or_default();
+
+    match rule.name.as_str() {
+        // Handled by the parser (constructor parameters) or always satisfied
+        "required" => true,
+        "omitempty" => {
+            insert(schema, "x-go-allow-empty", serde_yaml::Value::Bool(true));
+            true
+        }
+        "oneof" => {
+            let values: Option<Vec<serde_yaml::Value>> = split_oneof(param)
+                .into_iter()
+                .map(|value| typed_value(&value, schema_type))
+                .collect();
+            match values {
+                Some(values) if !values.is_empty() => {
+                    insert(schema, "enum", serde_yaml::Value::Sequence(values));
+                    true
+                }
+                _ => false,
+            }
+        }
+        "eq" if matches!(schema_type, "string" | "number" | "integer" | "boolean") => {
+            match typed_value(param, schema_type) {
+                Some(value) => {
+                    insert(schema, "enum", serde_yaml::Value::Sequence(vec![value]));
+                    true
+                }
+                None => false,
+            }
+        }
+        name if CROSS_FIELD_RULES.contains(&name) => {
+            let fields: Vec<serde_yaml::Value> = param
+                .split_whitespace()
+                .map(|field| serde_yaml::Value::String(field.to_string()))
+                .collect();
+            if fields.is_empty() {
+                return false;
+            }
+
+            let mut field_rule = serde_yaml::Mapping::new();
+            insert(
+                &mut field_rule,
+                "rule",
+                serde_yaml::Value::String(name.to_string()),
+            );
+            insert(
+                &mut field_rule,
+                "fields",
+                serde_yaml::Value::Sequence(fields),
+            );
+
+            let key = serde_yaml::Value::String(FIELD_RULES_KEY.to_string());
+            match schema.get_mut(&key).and_then(|r| r.as_sequence_mut()) {
+                Some(rules) => rules.push(serde_yaml::Value::Mapping(field_rule)),
+                None => insert(
+                    schema,
+                    FIELD_RULES_KEY,
+                    serde_yaml::Value::Sequence(vec![serde_yaml::Value::Mapping(field_rule)]),
+                ),
+            }
+            true
+        }
+        "min" | "gte" => apply_bound(schema, schema_type, Bound::Min, param),
+        "max" | "lte" => apply_bound(schema, schema_type, Bound::Max, param),
+        "gt" => apply_bound(schema, schema_type, Bound::ExclusiveMin, param),
+        "lt" => apply_bound(schema, schema_type, Bound::ExclusiveMax, param),
+        "len" | "eq" => {
+            apply_bound(schema, schema_type, Bound::Min, param)
+                && apply_bound(schema, schema_type, Bound::Max, param)
+        }
+        "regexp" if !param.is_empty() => {
+            insert(
+                schema,
+                "pattern",
+                serde_yaml::Value::String(param.to_string()),
+            );
+            true
+        }
+        name => match rule_format(name) {
+            Some(format) if schema_type == "string" => {
+                insert(
+                    schema,
+                    "format",
+                    serde_yaml::Value::String(format.to_string()),
+                );
+                true
+            }
+            _ => false,
+        },
+    }
+}
+
+/// Get the schema format checked by a validator rule
+fn rule_format(name: &str) -> Option<&'static str> {
+    match name {
+        "email" => Some("email"),
+        "url" | "uri" | "http_url" => Some("uri"),
+        "uuid" | "uuid3" | "uuid4" | "uuid5" | "uuid_rfc4122" => Some("uuid"),
+        "ip" | "ip_addr" => Some("ip"),
+        "ipv4" | "ip4_addr" => Some("ipv4"),
+        "ipv6" | "ip6_addr" => Some("ipv6"),
+        "hostname" | "hostname_rfc1123" | "fqdn" => Some("hostname"),
+        "cidr" => Some("cidr"),
+        "cidrv4" => Some("cidrv4"),
+        "cidrv6" => Some("cidrv6"),
+        "semver" => Some("semver"),
+        _ => None,
+    }
+}
+
+/// Set a length or numeric bound
+///
+/// Like the validator, bounds limit the length of strings, slices and maps
+/// and the value of numbers.
+fn apply_bound(
+    schema: &mut serde_yaml::Mapping,
+    schema_type: &str,
+    bound: Bound,
+    param: &str,
+) -> bool {
+    let (min_key, max_key) = match schema_type {
+        "string" => ("minLength", "maxLength"),
+        "array" => ("minItems", "maxItems"),
+        "object" => ("minProperties", "maxProperties"),
+        "number" | "integer" => {
+            let value = match typed_value(param, "number") {
+                Some(value) => value,
+                None => return false,
+            };
+            let key = match bound {
+                Bound::Min => "minimum",
+                Bound::Max => "maximum",
+                Bound::ExclusiveMin => "exclusiveMinimum",
+                Bound::ExclusiveMax => "exclusiveMaximum",
+            };
+            insert(schema, key, value);
+            return true;
+        }
+        _ => return false,
+    };
+
+    let length: u64 = match param.parse() {
+        Ok(length) => length,
+        Err(_) => return false,
+    };
+    let (key, length) = match bound {
+        Bound::Min => (min_key, length),
+        Bound::Max => (max_key, length),
+        Bound::ExclusiveMin => (min_key, length + 1),
+        Bound::ExclusiveMax if length > 0 => (max_key, length - 1),
+        Bound::ExclusiveMax => return false,
+    };
+    insert(schema, key, serde_yaml::Value::Number(length.into()));
+    true
+}
+
+/// Split `oneof` values, which are separated by spaces and may be single-quoted
+fn split_oneof(param: &str) -> Vec<String> {
+    let mut values = Vec::new();
+    let mut rest = param.trim_start();
+
+    while !rest.is_empty() {
+        if let Some(quoted) = rest.strip_prefix('\'') {
+            let end = quoted.find('\'').unwrap_or(quoted.len());
+            values.push(quoted[..end].to_string());
+            rest = quoted.get(end + 1..).unwrap_or_default();
+        } else {
+            let end = rest.find(' ').unwrap_or(rest.len());
+            values.push(rest[..end].to_string());
+            rest = &rest[end..];
+        }
+        rest = rest.trim_start();
+    }
+
+    values
+}
+
+/// Convert a rule parameter to a value of the field's type
+fn typed_value(param: &str, schema_type: &str) -> Option<serde_yaml::Value> {
+    match schema_type {
+        "integer" | "number" => {
+            if let Ok(value) = param.parse::<i64>() {
+                Some(serde_yaml::Value::Number(value.into()))
+            } else {
+                param
+                    .parse::<f64>()
+                    .ok()
+                    .map(|value| serde_yaml::Value::Number(value.into()))
+            }
+        }
+        "boolean" => param.parse::<bool>().ok().map(serde_yaml::Value::Bool),
+        _ => Some(serde_yaml::Value::String(param.to_string())),
+    }
+}
+
+fn insert(schema: &mut serde_yaml::Mapping, key: &str, value: serde_yaml::Value) {
+    schema.insert(serde_yaml::Value::String(key.to_string()), value);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn schema(schema_type: &str) -> serde_yaml::Mapping {
+        let mut schema = serde_yaml::Mapping::new();
+        insert(
+            &mut schema,
+            "type",
+            serde_yaml::Value::String(schema_type.to_string()),
+        );
+        schema
+    }
+
+    #[test]
+    fn test_parse_dive() {
+        let rules = ValidateRules::parse("required,min=1,dive,oneof=a b,dive,email");
+        assert_eq!(rules.rules.len(), 2);
+        let dive = rules.dive.as_ref().unwrap();
+        assert_eq!(
+            dive.rules[0][0],
+            Rule {
+                name: "oneof".to_string(),
+                param: Some("a b".to_string())
+            }
+        );
+        assert_eq!(dive.dive.as_ref().unwrap().rules[0][0].name, "email");
+        assert!(rules.is_required());
+        assert!(!ValidateRules::parse("dive,required").is_required());
+    }
+
+    #[test]
+    fn test_split_oneof() {
+        assert_eq!(split_oneof("red green"), vec!["red", "green"]);
+        assert_eq!(split_oneof("'light blue' red"), vec!["light blue", "red"]);
+    }
+
+    #[test]
+    fn test_apply_bounds() {
+        let mut string = schema("string");
+        let unsupported = ValidateRules::parse("gte=2,lt=10,uuid").apply(&mut string);
+        assert!(unsupported.is_empty());
+        assert_eq!(string.get("minLength"), Some(&serde_yaml::Value::from(2)));
+        assert_eq!(string.get("maxLength"), Some(&serde_yaml::Value::from(9)));
+        assert_eq!(string.get("format"), Some(&serde_yaml::Value::from("uuid")));
+
+        let mut number = schema("integer");
+        let unsupported = ValidateRules::parse("gt=0,lte=100,oneof=1 2 3").apply(&mut number);
+        assert!(unsupported.is_empty());
+        assert_eq!(
+            number.get("exclusiveMinimum"),
+            Some(&serde_yaml::Value::from(0))
+        );
+        assert_eq!(number.get("maximum"), Some(&serde_yaml::Value::from(100)));
+        assert_eq!(
+            number.get("enum"),
+            Some(&serde_yaml::Value::from(vec![1, 2, 3]))
+        );
+    }
+
+    #[test]
+    fn test_apply_cross_field() {
+        let mut number = schema("integer");
+        let unsupported =
+            ValidateRules::parse("omitempty,gtefield=MinAge,required_with=MinAge Limit")
+                .apply(&mut number);
+        assert!(unsupported.is_empty());
+
+        let rules = number
+            .get(FIELD_RULES_KEY)
+            .and_then(|r| r.as_sequence())
+            .unwrap();
+        assert_eq!(rules.len(), 2);
+        assert_eq!(
+            rules[0].get("rule"),
+            Some(&serde_yaml::Value::from("gtefield"))
+        );
+        assert_eq!(
+            rules[1].get("fields"),
+            Some(&serde_yaml::Value::from(vec!["MinAge", "Limit"]))
+        );
+    }
+
+    #[test]
+    fn test_apply_unsupported() {
+        let mut string = schema("string");
+        let unsupported = ValidateRules::parse("rgb|rgba,excludesall=!@,dive").apply(&mut string);
+        assert_eq!(unsupported, vec!["rgb|rgba", "excludesall=!@", "dive"]);
+    }
+}
@@ -16,3 +16,76 @@ pub use testing::*;
 
 // Re-export common types from the plugin crate
 pub use gensonnet_plugin::*;
+
+/// Build a schema with YAML `content`, for tests
+#[cfg(test)]
+pub(crate) fn test_schema(
+    name: &str,
+    schema_type: &str,
+    source_file: &str,
+    content: &str,
+) -> ExtractedSchema {
+    test_schema_value(
+        name,
+        schema_type,
+        source_file,
+        serde_yaml::from_str(content).unwrap(),
+    )
+}
+
+/// Build a schema with an already parsed `content`, for tests
+#[cfg(test)]
+pub(crate) fn test_schema_value(
+    name: &str,
+    schema_type: &str,
+    source_file: &str,
+    content: impl Into<serde_yaml::Value>,
+) -> ExtractedSchema {
+    ExtractedSchema {
+        name: name.to_string(),
+        schema_type: schema_type.to_string(),
+        content: content.into(),
+        source_file: source_file.into(),
+        metadata: std::collections::HashMap::new(),
+    }
+}
+
+/// Build a Go struct schema of `package` with YAML `content`, for tests
+#[cfg(test)]
+pub(crate) fn test_struct(
+    name: &str,
+    package: &str,
+    source_file: &str,
+    content: &str,
+) -> ExtractedSchema {
+    let mut schema = test_schema(name, "go_struct", source_file, content);
+    schema
+        .metadata
+        .insert("package".to_string(), package.into());
+    schema
+}
+
+/// Evaluate `code` with the libraries `files` on the import path, for tests
+///
+/// The jsonnet binary is taken from $JSONNET_BIN or PATH; without it the
+/// evaluation is skipped and `None` returned.
+#[cfg(test)]
+pub(crate) fn test_evaluate(files: &[(&str, &str)], code: &str) -> Option<anyhow::Result<String>> {
+    let command =
+        std::env::var_os("JSONNET_BIN").map_or_else(|| "jsonnet".into(), std::path::PathBuf::from);
+    if std::process::Command::new(&command)
+        .arg("--version")
+        .output()
+        .is_err()
+    {
+        eprintln!("{} not found, skipping evaluation", command.display());
+        return None;
+    }
+    let temp_dir = tempfile::tempdir().unwrap();
+    for (file, content) in files {
+        std::fs::write(temp_dir.path().join(file), content).unwrap();
+    }
+    let evaluator =
+        crate::evaluate::Evaluator::new(Some(&command), vec![temp_dir.path().to_path_buf()]);
+    Some(evaluator.evaluate(code))
+}
@@ -0,0 +1,398 @@
+//! Timings and allocation statistics of a run
+//!
+//! `--profile FILE` records how long each phase of a run takes (checkout,
+//! parsing of each package, generation, formatting, verification, ...) and
+//! how much it allocates, writes them to `FILE` as JSON and prints a summary
+//! to stderr. Users can attach the file to performance reports; the slowest
+//! packages point at pathological inputs.
+//!
+//! Allocations are counted by `CountingAllocator`, installed as the global
+//! allocator of the binary, only while profiling is enabled; otherwise it
+//! only checks a flag per allocation. The counters are those of the process,
+//! so allocations are given for the run and its phases but not for packages,
+//! whose parsing runs concurrently. Phases are recorded by `phase` guards,
+//! which also log their timing as debug events of the `gensonnet::profile`
+//! target (`RUST_LOG=gensonnet::profile=debug`), with or without `--profile`.
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::collections::BTreeMap;
+use std::path::Path;
+use std::sync::atomic::{AtomicBool, AtomicI64, AtomicU64, Ordering};
+use std::sync::Mutex;
+use std::time::Instant;
+
+/// Number of packages listed in the summary
+const SLOWEST_PACKAGES: usize = 10;
+
+static ALLOCATIONS: AtomicU64 = AtomicU64::new(0);
+static ALLOCATED_BYTES: AtomicU64 = AtomicU64::new(0);
+
+// Bytes allocated and not freed since profiling was enabled; negative when
+// more memory allocated before is freed
+static LIVE_BYTES: AtomicI64 = AtomicI64::new(0);
+static PEAK_BYTES: AtomicI64 = AtomicI64::new(0);
+
+static ENABLED: AtomicBool = AtomicBool::new(false);
+static RECORDER: Mutex<Option<Recorder>> = Mutex::new(None);
+
+/// System allocator counting allocations and live bytes while profiling
+pub struct CountingAllocator;
+
+unsafe impl GlobalAlloc for CountingAllocator {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        let ptr = System.alloc(layout);
+        if !ptr.is_null() && ENABLED.load(Ordering::Relaxed) {
+            record_alloc(layout.size());
+        }
+        ptr
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+        System.dealloc(ptr, layout);
+        if ENABLED.load(Ordering::Relaxed) {
+            LIVE_BYTES.fetch_sub(layout.size() as i64, Ordering::Relaxed);
+        }
+    }
+
+    unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+        let new_ptr = System.realloc(ptr, layout, new_size);
+        if !new_ptr.is_null() && ENABLED.load(Ordering::Relaxed) {
+            LIVE_BYTES.fetch_sub(layout.size() as i64, Ordering::Relaxed);
+            record_alloc(new_size);
+        }
+        new_ptr
+    }
+}
+
+fn record_alloc(size: usize) {
+    ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+    ALLOCATED_BYTES.fetch_add(size as u64, Ordering::Relaxed);
+    let live = LIVE_BYTES.fetch_add(size as i64, Ordering::Relaxed) + size as i64;
+    if live > PEAK_BYTES.load(Ordering::Relaxed) {
+        PEAK_BYTES.fetch_max(live, Ordering::Relaxed);
+    }
+}
+
+/// Allocations counted while profiling
+///
+/// All zero unless `CountingAllocator` is the global allocator.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct AllocStats {
+    pub allocations: u64,
+    pub allocated_bytes: u64,
+
+    /// Highest number of bytes allocated at once since profiling was enabled
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub peak_bytes: u64,
+}
+
+fn is_zero(value: &u64) -> bool {
+    *value == 0
+}
+
+impl AllocStats {
+    /// Current counters
+    pub fn now() -> Self {
+        Self {
+            allocations: ALLOCATIONS.load(Ordering::Relaxed),
+            allocated_bytes: ALLOCATED_BYTES.load(Ordering::Relaxed),
+            peak_bytes: PEAK_BYTES.load(Ordering::Relaxed).max(0) as u64,
+        }
+    }
+
+    /// Allocations made since `earlier`, without a peak
+    fn since(&self, earlier: &AllocStats) -> Self {
+        Self {
+            allocations: self.allocations.saturating_sub(earlier.allocations),
+            allocated_bytes: self.allocated_bytes.saturating_sub(earlier.allocated_bytes),
+            peak_bytes: 0,
+        }
+    }
+}
+
+/// Phases recorded since profiling was enabled
+struct Recorder {
+    start: Instant,
+    start_allocs: AllocStats,
+    phases: Vec<PhaseRecord>,
+}
+
+struct PhaseRecord {
+    name: String,
+    package: Option<String>,
+    millis: f64,
+    allocs: AllocStats,
+}
+
+/// Start recording phases and counting allocations
+pub fn enable() {
+    LIVE_BYTES.store(0, Ordering::Relaxed);
+    PEAK_BYTES.store(0, Ordering::Relaxed);
+    if let Ok(mut recorder) = RECORDER.lock() {
+        *recorder = Some(Recorder {
+            start: Instant::now(),
+            start_allocs: AllocStats::now(),
+            phases: Vec::new(),
+        });
+    }
+    ENABLED.store(true, Ordering::Relaxed);
+}
+
+/// Whether phases are recorded
+pub fn is_enabled() -> bool {
+    ENABLED.load(Ordering::Relaxed)
+}
+
+/// A running phase, recorded when dropped
+pub struct Phase {
+    name: &'static str,
+    package: Option<String>,
+    start: Instant,
+
+    /// Counters at the start, for the phases of the run
+    start_allocs: Option<AllocStats>,
+}
+
+/// Time a phase of the run
+pub fn phase(name: &'static str) -> Phase {
+    start_phase(name, None)
+}
+
+/// Time a phase of the run for a package, or another named input
+///
+/// Packages are processed concurrently, so only the time is recorded.
+pub fn package_phase(name: &'static str, package: impl Into<String>) -> Phase {
+    start_phase(name, Some(package.into()))
+}
+
+fn start_phase(name: &'static str, package: Option<String>) -> Phase {
+    Phase {
+        name,
+        start: Instant::now(),
+        start_allocs: package.is_none().then(AllocStats::now),
+        package,
+    }
+}
+
+impl Drop for Phase {
+    fn drop(&mut self) {
+        let millis = self.start.elapsed().as_secs_f64() * 1000.0;
+        let allocs = self
+            .start_allocs
+            .map(|start| AllocStats::now().since(&start))
+            .unwrap_or_default();
+        tracing::debug!(
+            target: "gensonnet::profile",
+            phase = self.name,
+            package = self.package.as_deref().unwrap_or_default(),
+            millis,
+            allocations = allocs.allocations,
+            allocated_bytes = allocs.allocated_bytes,
+            "phase finished"
+        );
+        if !ENABLED.load(Ordering::Relaxed) {
+            return;
+        }
+        if let Ok(mut recorder) = RECORDER.lock() {
+            if let Some(recorder) = recorder.as_mut() {
+                recorder.phases.push(PhaseRecord {
+                    name: self.name.to_string(),
+                    package: self.package.take(),
+                    millis,
+                    allocs,
+                });
+            }
+        }
+    }
+}
+
+/// Profile of a run
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct Profile {
+    /// Time since profiling was enabled
+    pub total_millis: f64,
+
+    /// Allocations since profiling was enabled, with the peak of the process
+    pub allocations: AllocStats,
+
+    /// Totals of each phase
+    pub phases: BTreeMap<String, PhaseTotal>,
+
+    /// Totals of each phase of each package
+    pub packages: BTreeMap<String, BTreeMap<String, PhaseTotal>>,
+}
+
+/// Time and allocations of the runs of a phase
+///
+/// Allocations are left out for packages.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Serialize, Deserialize)]
+pub struct PhaseTotal {
+    pub count: u64,
+    pub millis: f64,
+
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub allocations: u64,
+
+    #[serde(default, skip_serializing_if = "is_zero")]
+    pub allocated_bytes: u64,
+}
+
+impl PhaseTotal {
+    fn add(&mut self, millis: f64, allocs: &AllocStats) {
+        self.count += 1;
+        self.millis += millis;
+        self.allocations += allocs.allocations;
+        self.allocated_bytes += allocs.allocated_bytes;
+    }
+}
+
+impl Profile {
+    /// Profile of the phases recorded since `enable`
+    pub fn take() -> Self {
+        let mut profile = Profile::default();
+        let Ok(mut recorder) = RECORDER.lock() else {
+            return profile;
+        };
+        let Some(recorder) = recorder.take() else {
+            return profile;
+        };
+        ENABLED.store(false, Ordering::Relaxed);
+
+        profile.total_millis = recorder.start.elapsed().as_secs_f64() * 1000.0;
+        let now = AllocStats::now();
+        profile.allocations = AllocStats {
+            peak_bytes: now.peak_bytes,
+            ..now.since(&recorder.start_allocs)
+        };
+        for record in recorder.phases {
+            profile
+                .phases
+                .entry(record.name.clone())
+                .or_default()
+                .add(record.millis, &record.allocs);
+            if let Some(package) = record.package {
+                profile
+                    .packages
+                    .entry(package)
+                    .or_default()
+                    .entry(record.name)
+                    .or_default()
+                    .add(record.millis, &record.allocs);
+            }
+        }
+        profile
+    }
+
+    /// Write the profile as JSON
+    pub fn write(&self, path: &Path) -> Result<()> {
+        if let Some(parent) = path.parent().filter(|p| !p.as_os_str().is_empty()) {
+            std::fs::create_dir_all(parent)?;
+        }
+        std::fs::write(path, serde_json::to_string_pretty(self)? + "\n")?;
+        Ok(())
+    }
+
+    /// Summary of the phases, slowest first, and of the slowest packages
+    pub fn summary(&self) -> String {
+        let mut lines = vec![format!(
+            "Profile: {:.0}ms, {} allocations of {}, peak {}",
+            self.total_millis,
+            self.allocations.allocations,
+            bytes(self.allocations.allocated_bytes),
+            bytes(self.allocations.peak_bytes)
+        )];
+
+        let mut phases: Vec<(&String, &PhaseTotal)> = self.phases.iter().collect();
+        phases.sort_by(|a, b| b.1.millis.total_cmp(&a.1.millis));
+        for (name, total) in phases {
+            lines.push(format!(
+                "  {:<12} {:>10.1}ms {:>6}x {:>12}",
+                name,
+                total.millis,
+                total.count,
+                bytes(total.allocated_bytes)
+            ));
+        }
+
+        let mut packages: Vec<(&String, f64)> = self
+            .packages
+            .iter()
+            .map(|(package, phases)| {
+                (
+                    package,
+                    phases.values().map(|total| total.millis).sum::<f64>(),
+                )
+            })
+            .collect();
+        packages.sort_by(|a, b| b.1.total_cmp(&a.1));
+        if !packages.is_empty() {
+            lines.push("Slowest packages:".to_string());
+        }
+        for (package, millis) in packages.into_iter().take(SLOWEST_PACKAGES) {
+            lines.push(format!("  {:<40} {:>10.1}ms", package, millis));
+        }
+        lines.join("\n")
+    }
+}
+
+/// Human-readable size
+fn bytes(bytes: u64) -> String {
+    const UNITS: [&str; 4] = ["B", "KiB", "MiB", "GiB"];
+    let mut value = bytes as f64;
+    let mut unit = 0;
+    while value >= 1024.0 && unit < UNITS.len() - 1 {
+        value /= 1024.0;
+        unit += 1;
+    }
+    if unit == 0 {
+        format!("{bytes}B")
+    } else {
+        format!("{value:.1}{}", UNITS[unit])
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_profile() {
+        enable();
+        {
+            let _parse = package_phase("test-parse", "test/api/v1");
+            let _buffer = vec![0u8; 1024];
+        }
+        drop(package_phase("test-parse", "test/api/v1"));
+        drop(phase("test-format"));
+        let profile = Profile::take();
+
+        assert_eq!(profile.phases["test-parse"].count, 2);
+        assert_eq!(profile.phases["test-format"].count, 1);
+        assert_eq!(profile.packages["test/api/v1"]["test-parse"].count, 2);
+
+        // Packages are parsed concurrently, so they get no allocations
+        let packages = serde_json::to_value(&profile.packages).unwrap();
+        assert!(packages["test/api/v1"]["test-parse"]
+            .get("allocated_bytes")
+            .is_none());
+        assert!(profile
+            .packages
+            .values()
+            .all(|phases| !phases.contains_key("test-format")));
+        let summary = profile.summary();
+        assert!(summary.contains("Slowest packages:\n"));
+        assert!(summary.contains("  test/api/v1 "));
+
+        // Nothing is recorded once the profile is taken
+        drop(phase("test-format"));
+        assert!(!Profile::take().phases.contains_key("test-format"));
+    }
+
+    #[test]
+    fn test_bytes() {
+        assert_eq!(bytes(512), "512B");
+        assert_eq!(bytes(3 * 1024 * 1024 / 2), "1.5MiB");
+    }
+}
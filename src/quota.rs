@@ -0,0 +1,422 @@
+//! Per-run resource quotas
+//!
+//! An installation shared by several projects caps what each run may use,
+//! so one oversized source (all of `k8s.io/api`, say) cannot starve the
+//! others. The operator sets the limits of a project in a file of its own,
+//! passed to `gensonnet daemon --quotas`; the `generation.quotas` of the
+//! project's configuration can only lower them. Limits are checked while a
+//! source is processed and before its libraries are written; a run over a
+//! limit fails with a `QuotaExceeded` error, which callers can recover from
+//! the `anyhow::Error` with `downcast_ref` to report the limit in a
+//! structured way. The files already written for the source are restored.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::future::Future;
+use std::path::Path;
+use std::time::{Duration, Instant};
+use tokio::runtime::{Handle, RuntimeFlavor};
+
+/// Limits applied to the processing of each source
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Quotas {
+    /// Maximum number of generated types
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_types: Option<u64>,
+
+    /// Maximum size of the generated libraries, in bytes
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_output_bytes: Option<u64>,
+
+    /// Maximum processing time, in seconds
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_duration_secs: Option<u64>,
+}
+
+impl Quotas {
+    /// Read the quotas of an operator's file
+    pub fn load(path: &Path) -> Result<Self> {
+        let text = std::fs::read_to_string(path)
+            .map_err(|e| anyhow!("Failed to read quotas {}: {}", path.display(), e))?;
+        serde_yaml::from_str(&text).map_err(|e| anyhow!("Quotas {}: {}", path.display(), e))
+    }
+
+    /// Whether no limit is set
+    pub fn is_empty(&self) -> bool {
+        self.max_types.is_none()
+            && self.max_output_bytes.is_none()
+            && self.max_duration_secs.is_none()
+    }
+
+    /// Get the tighter of each limit of these quotas and of `limits`
+    ///
+    /// A project's quotas are applied within the operator's, so they can
+    /// lower a limit but not raise or remove it.
+    pub fn within(&self, limits: &Quotas) -> Quotas {
+        let tighter = |a: Option<u64>, b: Option<u64>| match (a, b) {
+            (Some(a), Some(b)) => Some(a.min(b)),
+            (a, b) => a.or(b),
+        };
+        Quotas {
+            max_types: tighter(self.max_types, limits.max_types),
+            max_output_bytes: tighter(self.max_output_bytes, limits.max_output_bytes),
+            max_duration_secs: tighter(self.max_duration_secs, limits.max_duration_secs),
+        }
+    }
+}
+
+/// Run the processing of a source, stopping it at the duration limit
+///
+/// Parsing and generating are synchronous, so `processing` runs on a thread
+/// where blocking is allowed, leaving the runtime to other tasks, and checks
+/// the limit itself between files and types with a `QuotaTracker`. The
+/// timeout only stops it at its await points, such as the fetching of
+/// dependencies. On a runtime with a single thread, it runs in place.
+pub async fn with_deadline<T>(
+    quotas: &Quotas,
+    source: &str,
+    processing: impl Future<Output = Result<T>>,
+) -> Result<T> {
+    let deadline = async {
+        let Some(limit) = quotas.max_duration_secs else {
+            return processing.await;
+        };
+        let started = Instant::now();
+        match tokio::time::timeout(Duration::from_secs(limit), processing).await {
+            Ok(result) => result,
+            Err(_) => Err(QuotaExceeded {
+                source: source.to_string(),
+                quota: Quota::Duration,
+                limit,
+                actual: started.elapsed().as_secs(),
+            }
+            .into()),
+        }
+    };
+    let handle = Handle::current();
+    match handle.runtime_flavor() {
+        RuntimeFlavor::MultiThread => tokio::task::block_in_place(|| handle.block_on(deadline)),
+        _ => deadline.await,
+    }
+}
+
+/// A limit of `Quotas`
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Quota {
+    Types,
+    OutputBytes,
+    Duration,
+}
+
+impl Quota {
+    /// Get the configuration key of the limit
+    pub fn key(&self) -> &'static str {
+        match self {
+            Quota::Types => "max_types",
+            Quota::OutputBytes => "max_output_bytes",
+            Quota::Duration => "max_duration_secs",
+        }
+    }
+}
+
+/// Error of a run that went over a limit
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct QuotaExceeded {
+    /// Name of the source being processed
+    pub source: String,
+
+    pub quota: Quota,
+
+    pub limit: u64,
+
+    /// Value reached when the run was stopped
+    pub actual: u64,
+}
+
+impl std::fmt::Display for QuotaExceeded {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "Source {} exceeds the {} quota: {} over the limit of {}",
+            self.source,
+            self.quota.key(),
+            self.actual,
+            self.limit
+        )
+    }
+}
+
+impl std::error::Error for QuotaExceeded {}
+
+/// Checks the quotas of one source while it is processed
+#[derive(Debug, Clone)]
+pub struct QuotaTracker {
+    quotas: Quotas,
+    source: String,
+    started: Instant,
+}
+
+impl QuotaTracker {
+    /// Start tracking the processing of a source
+    pub fn start(quotas: &Quotas, source: &str) -> Self {
+        Self {
+            quotas: quotas.clone(),
+            source: source.to_string(),
+            started: Instant::now(),
+        }
+    }
+
+    /// Whether the size of the output has a limit
+    ///
+    /// Measuring the output before writing it means generating it twice,
+    /// which is only worth it when the size is checked.
+    pub fn limits_output(&self) -> bool {
+        self.quotas.max_output_bytes.is_some()
+    }
+
+    /// Check the number of types to generate
+    pub fn check_types(&self, count: usize) -> Result<()> {
+        self.check(Quota::Types, self.quotas.max_types, count as u64)
+    }
+
+    /// Check the size of the output to write
+    pub fn check_output_bytes(&self, bytes: u64) -> Result<()> {
+        self.check(Quota::OutputBytes, self.quotas.max_output_bytes, bytes)
+    }
+
+    /// Check the time spent since the run started
+    pub fn check_duration(&self) -> Result<()> {
+        self.check_elapsed(self.started.elapsed())
+    }
+
+    fn check_elapsed(&self, elapsed: Duration) -> Result<()> {
+        self.check(
+            Quota::Duration,
+            self.quotas.max_duration_secs,
+            elapsed.as_secs(),
+        )
+    }
+
+    fn check(&self, quota: Quota, limit: Option<u64>, actual: u64) -> Result<()> {
+        match limit {
+            Some(limit) if actual > limit => Err(QuotaExceeded {
+                source: self.source.clone(),
+                quota,
+                limit,
+                actual,
+            }
+            .into()),
+            _ => Ok(()),
+        }
+    }
+}
+
+/// Application generating a Go source of two types, limited to one
+///
+/// Returns the directory of the repository and of the output with it, or
+/// `None` when git is not installed.
+#[cfg(test)]
+pub(crate) fn test_app_over_quota() -> Option<(tempfile::TempDir, crate::JsonnetGen)> {
+    let repository = crate::git::test_repository(&[(
+        "api/user.go",
+        "package api\n\ntype User struct {\n    Name string `json:\"name\"`\n}\n\ntype Group struct {\n    Name string `json:\"name\"`\n}\n",
+    )])?;
+    let mut config = crate::Config::default();
+    config.sources = vec![crate::Source::GoAst(
+        crate::config::GoAstSource::from_url(
+            &format!("git+file://{}?name=api", repository.path().display()),
+            &repository.path().join("generated"),
+        )
+        .unwrap(),
+    )];
+    config.generation.quotas.max_types = Some(1);
+    Some((repository, crate::JsonnetGen::new(config).unwrap()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::sync::Arc;
+
+    #[test]
+    fn test_quota_tracker() {
+        let quotas = Quotas {
+            max_types: Some(2),
+            max_output_bytes: None,
+            max_duration_secs: Some(60),
+        };
+        let tracker = QuotaTracker::start(&quotas, "k8s-api");
+
+        assert!(tracker.check_types(2).is_ok());
+        assert!(tracker.check_output_bytes(u64::MAX).is_ok());
+        assert!(!tracker.limits_output());
+        assert!(tracker.check_duration().is_ok());
+
+        let error = tracker.check_types(3).unwrap_err();
+        let exceeded = error.downcast_ref::<QuotaExceeded>().unwrap();
+        assert_eq!(exceeded.quota, Quota::Types);
+        assert_eq!(exceeded.actual, 3);
+        assert_eq!(
+            error.to_string(),
+            "Source k8s-api exceeds the max_types quota: 3 over the limit of 2"
+        );
+
+        let error = tracker.check_elapsed(Duration::from_secs(61)).unwrap_err();
+        assert_eq!(
+            error.downcast_ref::<QuotaExceeded>().unwrap().quota,
+            Quota::Duration
+        );
+    }
+
+    #[tokio::test]
+    async fn test_generate_over_quota() {
+        let Some((_repository, app)) = test_app_over_quota() else {
+            return;
+        };
+        let source = app.config().sources[0].clone();
+
+        // The error keeps its type instead of becoming a partial result
+        let error = app.process_source_with_recovery(&source).await.unwrap_err();
+        let exceeded = error.downcast_ref::<QuotaExceeded>().unwrap();
+        assert_eq!(exceeded.source, "api");
+        assert_eq!(exceeded.quota, Quota::Types);
+        assert_eq!((exceeded.limit, exceeded.actual), (1, 2));
+
+        let error = app.generate().await.unwrap_err();
+        assert!(error.is::<QuotaExceeded>());
+    }
+
+    #[test]
+    fn test_restore_over_quota() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let source = crate::Source::GoAst(
+            crate::config::GoAstSource::from_url(
+                "git+https://github.com/acme/api",
+                temp_dir.path(),
+            )
+            .unwrap(),
+        );
+        let mut config = crate::Config {
+            sources: vec![source.clone()],
+            ..Default::default()
+        };
+        config.generation.quotas.max_types = Some(1);
+        let app = crate::JsonnetGen::new(config).unwrap();
+
+        let output_path = source.output_path();
+        std::fs::create_dir_all(output_path).unwrap();
+        std::fs::write(output_path.join("user.libsonnet"), "{ old: true }\n").unwrap();
+        app.start_source_output(&source, temp_dir.path()).unwrap();
+        app.write_output(&output_path.join("user.libsonnet"), "{ new: true }\n")
+            .unwrap();
+        app.write_output(&output_path.join("group.libsonnet"), "{}\n")
+            .unwrap();
+
+        // The files of the previous run are back, and the error kept
+        let exceeded = QuotaExceeded {
+            source: "api".to_string(),
+            quota: Quota::Duration,
+            limit: 60,
+            actual: 61,
+        };
+        let error = app.restore_over_quota::<()>(exceeded.into()).unwrap_err();
+        assert!(error.is::<QuotaExceeded>());
+        assert_eq!(
+            std::fs::read_to_string(output_path.join("user.libsonnet")).unwrap(),
+            "{ old: true }\n"
+        );
+        assert!(!output_path.join("group.libsonnet").exists());
+
+        // Other errors leave a partial generation in place
+        app.write_output(&output_path.join("group.libsonnet"), "{}\n")
+            .unwrap();
+        assert!(app
+            .restore_over_quota::<()>(anyhow!("parse error"))
+            .is_err());
+        assert!(output_path.join("group.libsonnet").exists());
+    }
+
+    #[test]
+    fn test_quotas_within() {
+        let operator = Quotas {
+            max_types: Some(500),
+            max_output_bytes: Some(1000),
+            max_duration_secs: None,
+        };
+        let project = Quotas {
+            max_types: Some(100),
+            max_output_bytes: Some(5000),
+            max_duration_secs: Some(60),
+        };
+        assert_eq!(
+            project.within(&operator),
+            Quotas {
+                max_types: Some(100),
+                max_output_bytes: Some(1000),
+                max_duration_secs: Some(60),
+            }
+        );
+        assert_eq!(Quotas::default().within(&operator), operator);
+    }
+
+    #[tokio::test(flavor = "multi_thread", worker_threads = 1)]
+    async fn test_with_deadline() {
+        let quotas = Quotas {
+            max_duration_secs: Some(1),
+            ..Quotas::default()
+        };
+        // Close to the limit, so the work below runs for a moment
+        let tracker = QuotaTracker {
+            started: Instant::now() - Duration::from_millis(1900),
+            ..QuotaTracker::start(&quotas, "k8s-api")
+        };
+
+        // Work that never yields is stopped by its own checks, while other
+        // tasks of the runtime keep running
+        let ticks = Arc::new(AtomicUsize::new(0));
+        let ticker = tokio::spawn({
+            let ticks = Arc::clone(&ticks);
+            async move {
+                loop {
+                    ticks.fetch_add(1, Ordering::SeqCst);
+                    tokio::time::sleep(Duration::from_millis(5)).await;
+                }
+            }
+        });
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        let before = ticks.load(Ordering::SeqCst);
+        let error = with_deadline(&quotas, "k8s-api", async {
+            let mut hash = 0u64;
+            while tracker.check_duration().is_ok() {
+                for i in 0..10_000u64 {
+                    hash = hash.wrapping_mul(31).wrapping_add(i);
+                }
+                std::hint::black_box(hash);
+            }
+            tracker.check_duration()
+        })
+        .await
+        .unwrap_err();
+        assert_eq!(
+            error.downcast_ref::<QuotaExceeded>().unwrap().quota,
+            Quota::Duration
+        );
+        assert!(ticks.load(Ordering::SeqCst) > before);
+        ticker.abort();
+
+        let value = with_deadline(&Quotas::default(), "k8s-api", async { Ok(1) }).await;
+        assert_eq!(value.unwrap(), 1);
+    }
+
+    #[test]
+    fn test_quotas_from_yaml() {
+        let quotas: Quotas =
+            serde_yaml::from_str("max_types: 500\nmax_output_bytes: 1000000\n").unwrap();
+        assert_eq!(quotas.max_types, Some(500));
+        assert_eq!(quotas.max_duration_secs, None);
+        assert!(!quotas.is_empty());
+        assert!(Quotas::default().is_empty());
+    }
+}
@@ -0,0 +1,807 @@
+//! Field renames across Go sources and consuming Jsonnet
+//!
+//! A rename is recorded in the `renames` option of Go AST sources, so the
+//! generated library keeps the old setters as deprecated aliases. Consumer
+//! trees are then migrated by rewriting calls to those aliases in the files
+//! that import the library, directly or through other files, on the values
+//! traced to the library.
+
+use anyhow::{anyhow, Result};
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use walkdir::WalkDir;
+
+use crate::compat::tokenize;
+use crate::utils::is_jsonnet;
+
+/// A struct field, written `Type.Field` or `pkg.Type.Field`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FieldPath {
+    pub package: Option<String>,
+    pub type_name: String,
+    pub field: String,
+}
+
+impl FieldPath {
+    /// Get the key of the field in the `renames` option
+    pub fn key(&self) -> String {
+        match &self.package {
+            Some(package) => format!("{}.{}.{}", package, self.type_name, self.field),
+            None => format!("{}.{}", self.type_name, self.field),
+        }
+    }
+
+    /// Whether both paths name a field of the same type
+    pub fn same_type(&self, other: &FieldPath) -> bool {
+        self.package == other.package && self.type_name == other.type_name
+    }
+}
+
+impl FromStr for FieldPath {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        let parts: Vec<&str> = s.split('.').collect();
+        if parts.iter().any(|p| p.is_empty()) {
+            return Err(anyhow!(
+                "Invalid field '{}' (expected Type.Field or pkg.Type.Field)",
+                s
+            ));
+        }
+
+        match parts.as_slice() {
+            [type_name, field] => Ok(FieldPath {
+                package: None,
+                type_name: type_name.to_string(),
+                field: field.to_string(),
+            }),
+            [package, type_name, field] => Ok(FieldPath {
+                package: Some(package.to_string()),
+                type_name: type_name.to_string(),
+                field: field.to_string(),
+            }),
+            _ => Err(anyhow!(
+                "Invalid field '{}' (expected Type.Field or pkg.Type.Field)",
+                s
+            )),
+        }
+    }
+}
+
+/// Find the Jsonnet files below `root` that use one of `libraries`
+///
+/// A file uses a library when it imports it or imports another file using
+/// it. Imports are resolved relative to the importing file, then against the
+/// `jpath` directories.
+pub fn dependent_files(
+    root: &Path,
+    libraries: &[PathBuf],
+    jpath: &[PathBuf],
+) -> Result<Vec<PathBuf>> {
+    let mut imports: BTreeMap<PathBuf, Vec<PathBuf>> = BTreeMap::new();
+    for entry in WalkDir::new(root) {
+        let entry = entry?;
+        let path = entry.path();
+        if !entry.file_type().is_file() || !is_jsonnet(path) {
+            continue;
+        }
+
+        let code = std::fs::read_to_string(path)?;
+        let dir = path.parent().unwrap_or(root);
+        let resolved = import_paths(&code)
+            .iter()
+            .filter_map(|import| resolve_import(import, dir, jpath))
+            .collect();
+        imports.insert(canonical(path), resolved);
+    }
+
+    let mut used: BTreeSet<PathBuf> = libraries.iter().map(|l| canonical(l)).collect();
+    let mut dependents = BTreeSet::new();
+    loop {
+        let found: Vec<PathBuf> = imports
+            .iter()
+            .filter(|(file, _)| !dependents.contains(*file))
+            .filter(|(_, imported)| imported.iter().any(|i| used.contains(i)))
+            .map(|(file, _)| file.clone())
+            .collect();
+        if found.is_empty() {
+            break;
+        }
+        for file in found {
+            used.insert(file.clone());
+            dependents.insert(file);
+        }
+    }
+
+    Ok(dependents.into_iter().collect())
+}
+
+/// Get the paths imported by Jsonnet code
+fn import_paths(code: &str) -> Vec<String> {
+    imports(code)
+        .into_iter()
+        .filter(|(kind, _)| kind == "import")
+        .map(|(_, path)| path)
+        .collect()
+}
+
+/// Get the imports of Jsonnet code, as their kind (`import`, `importstr` or
+/// `importbin`) and path
+pub(crate) fn imports(code: &str) -> Vec<(String, String)> {
+    let tokens = tokenize(code);
+    tokens
+        .windows(2)
+        .filter(|pair| matches!(pair[0].as_str(), "import" | "importstr" | "importbin"))
+        .filter_map(|pair| Some((pair[0].clone(), string_literal(&pair[1])?.to_string())))
+        .collect()
+}
+
+/// Get the content of a quoted string literal token
+pub(crate) fn string_literal(token: &str) -> Option<&str> {
+    let quote = token.chars().next()?;
+    if quote != '"' && quote != '\'' {
+        return None;
+    }
+    token.strip_prefix(quote)?.strip_suffix(quote)
+}
+
+/// Resolve an import the way `jsonnet -J` does
+pub(crate) fn resolve_import(import: &str, dir: &Path, jpath: &[PathBuf]) -> Option<PathBuf> {
+    std::iter::once(dir)
+        .chain(jpath.iter().map(PathBuf::as_path))
+        .map(|base| base.join(import))
+        .find(|path| path.is_file())
+        .map(|path| canonical(&path))
+}
+
+pub(crate) fn canonical(path: &Path) -> PathBuf {
+    path.canonicalize().unwrap_or_else(|_| path.to_path_buf())
+}
+
+/// A library whose renamed functions are rewritten in consumers
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RenamedLibrary {
+    /// Go name of the type of the library, its entry in the index next to it
+    pub type_name: Option<String>,
+
+    pub file: PathBuf,
+
+    /// Old function names mapped to the functions replacing them
+    pub renames: HashMap<String, String>,
+}
+
+/// Consumer code with the calls to renamed functions rewritten
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Rewrite {
+    pub code: String,
+
+    /// Number of rewritten calls
+    pub count: usize,
+
+    /// Calls to a renamed function left alone, as (line, function), since
+    /// their receiver could not be traced to its library
+    pub unresolved: Vec<(usize, String)>,
+}
+
+/// What a Jsonnet expression is known to evaluate to
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Receiver {
+    /// A renamed library, or an object built with its functions
+    Library(usize),
+
+    /// The index next to renamed libraries, or one of its fields
+    Index(usize),
+
+    /// The entry of a renamed library in an index
+    Entry(usize),
+
+    /// A generated library without renamed functions
+    Other,
+
+    Unknown,
+}
+
+/// A field access or method call after the head of a chain
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Step<'a> {
+    Field(&'a str),
+    Call(&'a str),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum TokenKind {
+    Ident,
+    Str,
+    Punct,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Token {
+    kind: TokenKind,
+
+    /// Name, punctuation character, or content of a string without quotes
+    text: String,
+
+    /// Character range of the token in the code
+    start: usize,
+    end: usize,
+
+    line: usize,
+}
+
+impl Token {
+    fn is(&self, punct: &str) -> bool {
+        self.kind == TokenKind::Punct && self.text == punct
+    }
+
+    fn is_ident(&self, name: &str) -> bool {
+        self.kind == TokenKind::Ident && self.text == name
+    }
+}
+
+/// Rewrites calls to renamed functions on values of their libraries
+///
+/// A call is rewritten when its receiver is traced to the library: imported
+/// into a local (`local user = import 'user.libsonnet'`), reached through
+/// the index (`index.packages.users.categories.api.User.lib`), or built by
+/// calling functions of such a value (`user.new().withName('ada')`). Locals
+/// are tracked by name for the whole file, and names also used for function
+/// parameters are not traced. Calls on other values are reported instead of
+/// rewritten, so a setter of the same name on another type is never changed.
+#[derive(Debug, Clone)]
+pub struct Rewriter {
+    libraries: Vec<RenamedLibrary>,
+    indexes: Vec<PathBuf>,
+    jpath: Vec<PathBuf>,
+}
+
+impl Rewriter {
+    /// Create a rewriter for `libraries`, resolving imports against `jpath`
+    pub fn new(libraries: Vec<RenamedLibrary>, jpath: &[PathBuf]) -> Self {
+        let libraries: Vec<RenamedLibrary> = libraries
+            .into_iter()
+            .map(|library| RenamedLibrary {
+                file: canonical(&library.file),
+                ..library
+            })
+            .collect();
+        let mut indexes: Vec<PathBuf> = libraries
+            .iter()
+            .filter_map(|library| library.file.parent())
+            .map(|dir| canonical(&dir.join("index.libsonnet")))
+            .collect();
+        indexes.dedup();
+        Self {
+            libraries,
+            indexes,
+            jpath: jpath.to_vec(),
+        }
+    }
+
+    /// Find the files below `root` using the libraries or their indexes
+    pub fn dependent_files(&self, root: &Path) -> Result<Vec<PathBuf>> {
+        let mut used: Vec<PathBuf> = self
+            .libraries
+            .iter()
+            .map(|library| library.file.clone())
+            .collect();
+        used.extend(self.indexes.iter().cloned());
+        dependent_files(root, &used, &self.jpath)
+    }
+
+    /// Rewrite the calls to renamed functions in the code of `file`
+    ///
+    /// Only names accessed as fields (`.withEmail`) are rewritten; strings
+    /// and comments are left alone.
+    pub fn rewrite(&self, file: &Path, code: &str) -> Rewrite {
+        let chars: Vec<char> = code.chars().collect();
+        let tokens = scan(&chars);
+        let dir = file.parent().unwrap_or(Path::new("."));
+        let bindings = self.bindings(&tokens, dir);
+
+        let mut rewrite = Rewrite::default();
+        let mut replacements = BTreeMap::new();
+        for (i, token) in tokens.iter().enumerate() {
+            if token.kind != TokenKind::Ident || i == 0 || !tokens[i - 1].is(".") {
+                continue;
+            }
+            if !self
+                .libraries
+                .iter()
+                .any(|library| library.renames.contains_key(&token.text))
+            {
+                continue;
+            }
+            match self.chain(&tokens, 0, i - 1, &bindings, dir).0 {
+                Receiver::Library(k) => {
+                    if let Some(new_name) = self.libraries[k].renames.get(&token.text) {
+                        replacements.insert(token.start, (token.end, new_name.as_str()));
+                        rewrite.count += 1;
+                    }
+                }
+                Receiver::Other => {}
+                _ => rewrite.unresolved.push((token.line, token.text.clone())),
+            }
+        }
+
+        let mut i = 0;
+        while i < chars.len() {
+            match replacements.get(&i) {
+                Some((end, new_name)) => {
+                    rewrite.code.push_str(new_name);
+                    i = *end;
+                }
+                None => {
+                    rewrite.code.push(chars[i]);
+                    i += 1;
+                }
+            }
+        }
+        rewrite
+    }
+
+    /// Trace the locals of a file to what they evaluate to
+    fn bindings(&self, tokens: &[Token], dir: &Path) -> HashMap<String, Receiver> {
+        let mut bindings: HashMap<String, Receiver> = HashMap::new();
+        let bind = |bindings: &mut HashMap<String, Receiver>, name: &str, value| {
+            let value = match bindings.get(name) {
+                Some(bound) if *bound != value => Receiver::Unknown,
+                _ => value,
+            };
+            bindings.insert(name.to_string(), value);
+        };
+
+        for (i, token) in tokens.iter().enumerate() {
+            if !token.is_ident("local") {
+                continue;
+            }
+            let mut j = i + 1;
+            while let Some(name) = tokens.get(j).filter(|t| t.kind == TokenKind::Ident) {
+                j += 1;
+                let function = tokens.get(j).is_some_and(|t| t.is("("));
+                if function {
+                    match matching_close(tokens, j) {
+                        Some(close) => j = close + 1,
+                        None => break,
+                    }
+                }
+                if !tokens.get(j).is_some_and(|t| t.is("=")) {
+                    break;
+                }
+                let end = expression_end(tokens, j + 1);
+                let value = match self.chain(tokens, j + 1, end, &bindings, dir) {
+                    (value, begin) if !function && begin == j + 1 => value,
+                    _ => Receiver::Unknown,
+                };
+                bind(&mut bindings, &name.text, value);
+                if !tokens.get(end).is_some_and(|t| t.is(",")) {
+                    break;
+                }
+                j = end + 1;
+            }
+        }
+
+        // Parameters may hold anything
+        for name in parameters(tokens) {
+            bindings.insert(name.to_string(), Receiver::Unknown);
+        }
+        bindings
+    }
+
+    /// Trace the chain of field accesses and calls ending before token `end`
+    ///
+    /// Returns what it evaluates to and the index of its first token, not
+    /// before `start`.
+    fn chain(
+        &self,
+        tokens: &[Token],
+        start: usize,
+        end: usize,
+        bindings: &HashMap<String, Receiver>,
+        dir: &Path,
+    ) -> (Receiver, usize) {
+        let mut steps = Vec::new();
+        let mut j = end;
+        let head = loop {
+            if j <= start {
+                return (Receiver::Unknown, j);
+            }
+            let token = &tokens[j - 1];
+            let after_dot = j >= start + 2 && tokens[j - 2].is(".");
+            if token.is(")") {
+                let Some(open) = matching_open(tokens, start, j - 1) else {
+                    return (Receiver::Unknown, j);
+                };
+                let callee = open > start && tokens[open - 1].kind == TokenKind::Ident;
+                if callee && open >= start + 2 && tokens[open - 2].is(".") {
+                    steps.push(Step::Call(tokens[open - 1].text.as_str()));
+                    j = open - 2;
+                    continue;
+                }
+                if callee {
+                    return (Receiver::Unknown, open - 1);
+                }
+                let (inner, begin) = self.chain(tokens, open + 1, j - 1, bindings, dir);
+                j = open;
+                break if begin == open + 1 {
+                    inner
+                } else {
+                    Receiver::Unknown
+                };
+            } else if token.kind == TokenKind::Ident && after_dot {
+                steps.push(Step::Field(token.text.as_str()));
+                j -= 2;
+            } else if token.kind == TokenKind::Ident {
+                j -= 1;
+                break bindings
+                    .get(&token.text)
+                    .copied()
+                    .unwrap_or(Receiver::Unknown);
+            } else if token.kind == TokenKind::Str
+                && j >= start + 2
+                && tokens[j - 2].is_ident("import")
+            {
+                j -= 2;
+                break self.import(&token.text, dir);
+            } else {
+                return (Receiver::Unknown, j);
+            }
+        };
+
+        let value = steps
+            .iter()
+            .rev()
+            .fold(head, |value, step| match (value, step) {
+                (Receiver::Library(k), Step::Call(_)) => Receiver::Library(k),
+                (Receiver::Index(_), Step::Field("lib")) => Receiver::Other,
+                (Receiver::Index(i), Step::Field(name)) => self
+                    .entry(i, name)
+                    .map(Receiver::Entry)
+                    .unwrap_or(Receiver::Index(i)),
+                (Receiver::Entry(k), Step::Field("lib")) => Receiver::Library(k),
+                (Receiver::Entry(k), Step::Field(_)) => self
+                    .indexes
+                    .iter()
+                    .position(|index| index.parent() == self.libraries[k].file.parent())
+                    .map(Receiver::Index)
+                    .unwrap_or(Receiver::Unknown),
+                (Receiver::Other, Step::Call(_)) => Receiver::Other,
+                _ => Receiver::Unknown,
+            });
+        (value, j)
+    }
+
+    /// Get what an import evaluates to
+    fn import(&self, import: &str, dir: &Path) -> Receiver {
+        let Some(path) = resolve_import(import, dir, &self.jpath) else {
+            return Receiver::Unknown;
+        };
+        if let Some(k) = self.libraries.iter().position(|l| l.file == path) {
+            return Receiver::Library(k);
+        }
+        if let Some(i) = self.indexes.iter().position(|index| *index == path) {
+            return Receiver::Index(i);
+        }
+        // Other libraries generated next to the renamed ones
+        let generated = self
+            .libraries
+            .iter()
+            .any(|library| library.file.parent() == path.parent());
+        if generated && path.extension().is_some_and(|ext| ext == "libsonnet") {
+            Receiver::Other
+        } else {
+            Receiver::Unknown
+        }
+    }
+
+    /// Get the renamed library of a type in the directory of an index
+    fn entry(&self, index: usize, type_name: &str) -> Option<usize> {
+        self.libraries.iter().position(|library| {
+            library.type_name.as_deref() == Some(type_name)
+                && library.file.parent() == self.indexes[index].parent()
+        })
+    }
+}
+
+/// Split Jsonnet code into names, strings and punctuation, without comments
+fn scan(chars: &[char]) -> Vec<Token> {
+    let mut tokens = Vec::new();
+    let mut line = 1;
+    let mut i = 0;
+
+    while i < chars.len() {
+        let c = chars[i];
+        let start = i;
+        let start_line = line;
+        let newlines = |range: &[char]| range.iter().filter(|c| **c == '\n').count();
+
+        if c.is_whitespace() {
+            i += 1;
+        } else if c == '#' || (c == '/' && chars.get(i + 1) == Some(&'/')) {
+            while i < chars.len() && chars[i] != '\n' {
+                i += 1;
+            }
+        } else if c == '/' && chars.get(i + 1) == Some(&'*') {
+            i += 2;
+            while i < chars.len() && !(chars[i] == '*' && chars.get(i + 1) == Some(&'/')) {
+                i += 1;
+            }
+            i = (i + 2).min(chars.len());
+        } else if c == '|' && chars[i..].starts_with(&['|', '|', '|']) {
+            i += 3;
+            while i < chars.len() && !chars[i..].starts_with(&['|', '|', '|']) {
+                i += 1;
+            }
+            i = (i + 3).min(chars.len());
+            tokens.push(Token {
+                kind: TokenKind::Str,
+                text: String::new(),
+                start,
+                end: i,
+                line: start_line,
+            });
+        } else if c == '"' || c == '\'' {
+            i += 1;
+            while i < chars.len() && chars[i] != c {
+                if chars[i] == '\\' {
+                    i += 1;
+                }
+                i += 1;
+            }
+            let text = chars[start + 1..i.min(chars.len())].iter().collect();
+            i = (i + 1).min(chars.len());
+            tokens.push(Token {
+                kind: TokenKind::Str,
+                text,
+                start,
+                end: i,
+                line: start_line,
+            });
+        } else if c.is_alphanumeric() || c == '_' {
+            while i < chars.len() && (chars[i].is_alphanumeric() || chars[i] == '_') {
+                i += 1;
+            }
+            tokens.push(Token {
+                kind: TokenKind::Ident,
+                text: chars[start..i].iter().collect(),
+                start,
+                end: i,
+                line: start_line,
+            });
+        } else {
+            i += 1;
+            tokens.push(Token {
+                kind: TokenKind::Punct,
+                text: c.to_string(),
+                start,
+                end: i,
+                line: start_line,
+            });
+        }
+        line += newlines(&chars[start..i]);
+    }
+
+    tokens
+}
+
+/// Find the `(` matching the `)` at `close`, not before `start`
+fn matching_open(tokens: &[Token], start: usize, close: usize) -> Option<usize> {
+    let mut depth = 0;
+    for i in (start..=close).rev() {
+        if tokens[i].is(")") {
+            depth += 1;
+        } else if tokens[i].is("(") {
+            depth -= 1;
+            if depth == 0 {
+                return Some(i);
+            }
+        }
+    }
+    None
+}
+
+/// Find the `)` matching the `(` at `open`
+fn matching_close(tokens: &[Token], open: usize) -> Option<usize> {
+    let mut depth = 0;
+    for (i, token) in tokens.iter().enumerate().skip(open) {
+        if token.is("(") {
+            depth += 1;
+        } else if token.is(")") {
+            depth -= 1;
+            if depth == 0 {
+                return Some(i);
+            }
+        }
+    }
+    None
+}
+
+/// Find the end of the value of a local starting at `start`: the `,` or `;`
+/// after it, or the bracket closing the object holding it
+fn expression_end(tokens: &[Token], start: usize) -> usize {
+    let mut depth = 0;
+    for (i, token) in tokens.iter().enumerate().skip(start) {
+        match token.text.as_str() {
+            _ if token.kind != TokenKind::Punct => {}
+            "(" | "[" | "{" => depth += 1,
+            ")" | "]" | "}" if depth == 0 => return i,
+            ")" | "]" | "}" => depth -= 1,
+            "," | ";" if depth == 0 => return i,
+            _ => {}
+        }
+    }
+    tokens.len()
+}
+
+/// Get the names of the parameters of functions and methods
+fn parameters(tokens: &[Token]) -> Vec<&str> {
+    let mut names = Vec::new();
+    for (open, token) in tokens.iter().enumerate() {
+        if !token.is("(") {
+            continue;
+        }
+        let Some(close) = matching_close(tokens, open) else {
+            continue;
+        };
+        let function = open > 0 && tokens[open - 1].is_ident("function");
+        let defined = tokens
+            .get(close + 1)
+            .is_some_and(|t| t.is("=") || t.is(":"));
+        if !function && !defined {
+            continue;
+        }
+        let mut depth = 0;
+        for i in open..close {
+            match tokens[i].text.as_str() {
+                _ if tokens[i].kind == TokenKind::Str => {}
+                _ if tokens[i].kind == TokenKind::Ident => {
+                    if depth == 1 && (tokens[i - 1].is("(") || tokens[i - 1].is(",")) {
+                        names.push(tokens[i].text.as_str());
+                    }
+                }
+                "(" | "[" | "{" => depth += 1,
+                ")" | "]" | "}" => depth -= 1,
+                _ => {}
+            }
+        }
+    }
+    names
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_field_path() {
+        let path: FieldPath = "users.User.Email".parse().unwrap();
+        assert_eq!(path.package.as_deref(), Some("users"));
+        assert_eq!(path.key(), "users.User.Email");
+
+        let path: FieldPath = "User.Email".parse().unwrap();
+        assert_eq!(path.package, None);
+        assert!(path.same_type(&"User.PrimaryEmail".parse().unwrap()));
+        assert!(!path.same_type(&"users.User.PrimaryEmail".parse().unwrap()));
+
+        assert!("Email".parse::<FieldPath>().is_err());
+        assert!("a.b.c.d".parse::<FieldPath>().is_err());
+        assert!("User.".parse::<FieldPath>().is_err());
+    }
+
+    /// Write the libraries of User and Group, both with a `withEmail`, and
+    /// get a rewriter for the rename of the one of User
+    fn user_rewriter(dir: &Path) -> Rewriter {
+        let lib_dir = dir.join("lib");
+        std::fs::create_dir_all(&lib_dir).unwrap();
+        for file in ["user.libsonnet", "group.libsonnet", "index.libsonnet"] {
+            std::fs::write(lib_dir.join(file), "{}").unwrap();
+        }
+        Rewriter::new(
+            vec![RenamedLibrary {
+                type_name: Some("User".to_string()),
+                file: lib_dir.join("user.libsonnet"),
+                renames: HashMap::from([("withEmail".to_string(), "withPrimaryEmail".to_string())]),
+            }],
+            &[lib_dir],
+        )
+    }
+
+    #[test]
+    fn test_rewrite_usages() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let rewriter = user_rewriter(temp_dir.path());
+        let code = r#"local user = import 'user.libsonnet';
+// withEmail is renamed
+user.new()
+  .withEmail('a@example.com')
+  + user.withEmail("withEmail")
+  + { withEmail: 1 }
+"#;
+
+        let rewrite = rewriter.rewrite(&temp_dir.path().join("main.jsonnet"), code);
+        assert_eq!(rewrite.count, 2);
+        assert!(rewrite.unresolved.is_empty());
+        assert_eq!(
+            rewrite.code,
+            r#"local user = import 'user.libsonnet';
+// withEmail is renamed
+user.new()
+  .withPrimaryEmail('a@example.com')
+  + user.withPrimaryEmail("withEmail")
+  + { withEmail: 1 }
+"#
+        );
+    }
+
+    #[test]
+    fn test_rewrite_usages_of_renamed_type() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let rewriter = user_rewriter(temp_dir.path());
+        let code = r#"local user = import 'user.libsonnet';
+local group = import 'group.libsonnet';
+local index = import 'index.libsonnet';
+local users = index.packages.users.categories.api, admin = user.new('ada');
+local helpers = import 'helpers.libsonnet';
+local withContact(user) = user.withEmail('team@example.com');
+[
+  group.new().withEmail('team@example.com'),
+  admin.withName('Ada').withEmail('ada@example.com'),
+  users.User.lib.new('grace').withEmail('grace@example.com'),
+  users.Group.lib.withEmail('team@example.com'),
+  (import 'user.libsonnet').withEmail('alan@example.com'),
+  helpers.user.withEmail('bob@example.com'),
+  withContact(group.new()),
+]
+"#;
+
+        let rewrite = rewriter.rewrite(&temp_dir.path().join("main.jsonnet"), code);
+        assert_eq!(rewrite.count, 3);
+        assert!(rewrite
+            .code
+            .contains("group.new().withEmail('team@example.com')"));
+        assert!(rewrite
+            .code
+            .contains("admin.withName('Ada').withPrimaryEmail("));
+        assert!(rewrite
+            .code
+            .contains("users.User.lib.new('grace').withPrimaryEmail("));
+        assert!(rewrite.code.contains("users.Group.lib.withEmail("));
+        assert!(rewrite
+            .code
+            .contains("(import 'user.libsonnet').withPrimaryEmail("));
+
+        // Parameters and values of other files are not traced
+        assert_eq!(
+            rewrite.unresolved,
+            vec![(6, "withEmail".to_string()), (13, "withEmail".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_dependent_files() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        let lib_dir = root.join("lib");
+        let app_dir = root.join("app");
+        std::fs::create_dir_all(&lib_dir).unwrap();
+        std::fs::create_dir_all(&app_dir).unwrap();
+        std::fs::write(lib_dir.join("user.libsonnet"), "{}").unwrap();
+
+        std::fs::write(app_dir.join("users.libsonnet"), "import 'user.libsonnet'").unwrap();
+        std::fs::write(app_dir.join("main.jsonnet"), "(import 'users.libsonnet')").unwrap();
+        std::fs::write(app_dir.join("other.jsonnet"), "import 'missing.libsonnet'").unwrap();
+
+        let files = dependent_files(
+            &app_dir,
+            &[lib_dir.join("user.libsonnet")],
+            std::slice::from_ref(&lib_dir),
+        )
+        .unwrap();
+        let names: Vec<_> = files
+            .iter()
+            .map(|f| f.file_name().unwrap().to_string_lossy().to_string())
+            .collect();
+        assert_eq!(names, vec!["main.jsonnet", "users.libsonnet"]);
+    }
+}
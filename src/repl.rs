@@ -0,0 +1,269 @@
+//! Interactive evaluation session
+//!
+//! Each input is evaluated as a standalone program that starts with a
+//! `local` binding for every generated library, followed by the `local`
+//! definitions entered so far.
+
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::plugin::ast::generator::is_identifier;
+
+/// State of an interactive session
+#[derive(Debug, Clone, Default)]
+pub struct Session {
+    /// Libraries bound by name, as (name, import path relative to the jpath)
+    bindings: Vec<(String, String)>,
+
+    /// `local` definitions entered in the session
+    locals: Vec<String>,
+}
+
+/// What an input line asks for
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Input {
+    /// Evaluate an expression
+    Expression(String),
+
+    /// Add a `local` definition to the session
+    Local(String),
+
+    /// List the bound libraries and definitions
+    List,
+
+    /// Forget the definitions entered so far
+    Reset,
+
+    /// End the session
+    Quit,
+
+    /// Show the available commands
+    Help,
+}
+
+impl Input {
+    /// Classify an input
+    pub fn parse(input: &str) -> Self {
+        let input = input.trim();
+        match input {
+            ":q" | ":quit" | ":exit" => Input::Quit,
+            ":l" | ":libs" => Input::List,
+            ":r" | ":reset" => Input::Reset,
+            ":h" | ":help" => Input::Help,
+            _ if input.starts_with("local ") => {
+                let definition = input.trim_end_matches(';').trim_end();
+                Input::Local(format!("{definition};"))
+            }
+            _ => Input::Expression(input.to_string()),
+        }
+    }
+}
+
+impl Session {
+    /// Create a session binding the libraries found in `dirs`
+    ///
+    /// Each `<type>.libsonnet` is bound as `<type>`, and the first
+    /// `index.libsonnet` as `index`. When several directories define the same
+    /// name, the first one wins.
+    pub fn new(dirs: &[PathBuf]) -> Self {
+        let mut bindings: Vec<(String, String)> = Vec::new();
+        for dir in dirs {
+            for (name, path) in libraries(dir) {
+                if !bindings.iter().any(|(existing, _)| *existing == name) {
+                    bindings.push((name, path));
+                }
+            }
+        }
+        bindings.sort();
+
+        Self {
+            bindings,
+            locals: Vec::new(),
+        }
+    }
+
+    /// Get the bound libraries as (name, import path)
+    pub fn bindings(&self) -> &[(String, String)] {
+        &self.bindings
+    }
+
+    /// Get the `local` definitions entered so far
+    pub fn locals(&self) -> &[String] {
+        &self.locals
+    }
+
+    /// Build the program evaluating an expression in the session
+    pub fn program(&self, expression: &str) -> String {
+        let mut program = String::new();
+        for (name, path) in &self.bindings {
+            program.push_str(&format!(
+                "local {name} = import {};\n",
+                crate::plugin::ast::generator::quote_string(path)
+            ));
+        }
+        for local in &self.locals {
+            program.push_str(local);
+            program.push('\n');
+        }
+        program.push_str(expression);
+        program.push('\n');
+        program
+    }
+
+    /// Build the program checking a `local` definition before it is kept
+    pub fn check_program(&self, definition: &str) -> String {
+        self.program(&format!("{definition}\nnull"))
+    }
+
+    /// Keep a `local` definition for the following inputs
+    pub fn add_local(&mut self, definition: String) {
+        self.locals.push(definition);
+    }
+
+    /// Forget the `local` definitions
+    pub fn reset(&mut self) {
+        self.locals.clear();
+    }
+}
+
+/// Find the libraries in an output directory as (name, import path)
+fn libraries(dir: &Path) -> Vec<(String, String)> {
+    let mut libraries = Vec::new();
+    for entry in WalkDir::new(dir).max_depth(1).into_iter().flatten() {
+        let path = entry.path();
+        if path.extension().and_then(|ext| ext.to_str()) != Some("libsonnet") {
+            continue;
+        }
+        let (Some(stem), Some(file_name)) = (
+            path.file_stem().and_then(|s| s.to_str()),
+            path.file_name().and_then(|s| s.to_str()),
+        ) else {
+            continue;
+        };
+
+        let name: String = stem
+            .chars()
+            .map(|c| if c.is_alphanumeric() { c } else { '_' })
+            .collect();
+        if is_identifier(&name) {
+            libraries.push((name, file_name.to_string()));
+        }
+    }
+    libraries
+}
+
+/// Whether an input is complete, with all brackets and strings closed
+///
+/// Incomplete input is continued on the next line.
+pub fn is_complete(input: &str) -> bool {
+    let chars: Vec<char> = input.chars().collect();
+    let mut depth: i32 = 0;
+    let mut i = 0;
+
+    while i < chars.len() {
+        let c = chars[i];
+        if c == '#' || (c == '/' && chars.get(i + 1) == Some(&'/')) {
+            while i < chars.len() && chars[i] != '\n' {
+                i += 1;
+            }
+        } else if c == '/' && chars.get(i + 1) == Some(&'*') {
+            i += 2;
+            while !(chars.get(i) == Some(&'*') && chars.get(i + 1) == Some(&'/')) {
+                if i >= chars.len() {
+                    return false;
+                }
+                i += 1;
+            }
+            i += 2;
+        } else if chars[i..].starts_with(&['|', '|', '|']) {
+            i += 3;
+            while !chars[i..].starts_with(&['|', '|', '|']) {
+                if i >= chars.len() {
+                    return false;
+                }
+                i += 1;
+            }
+            i += 3;
+        } else if c == '"' || c == '\'' {
+            i += 1;
+            while chars.get(i) != Some(&c) {
+                if i >= chars.len() {
+                    return false;
+                }
+                if chars[i] == '\\' {
+                    i += 1;
+                }
+                i += 1;
+            }
+            i += 1;
+        } else {
+            match c {
+                '(' | '[' | '{' => depth += 1,
+                ')' | ']' | '}' => depth -= 1,
+                _ => {}
+            }
+            i += 1;
+        }
+    }
+
+    depth <= 0
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_input() {
+        assert_eq!(Input::parse(":q"), Input::Quit);
+        assert_eq!(
+            Input::parse("local a = user.new('alice')"),
+            Input::Local("local a = user.new('alice');".to_string())
+        );
+        assert_eq!(
+            Input::parse("  a.withEmail('a@example.com') "),
+            Input::Expression("a.withEmail('a@example.com')".to_string())
+        );
+    }
+
+    #[test]
+    fn test_session_program() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        std::fs::write(temp_dir.path().join("user.libsonnet"), "{}").unwrap();
+        std::fs::write(temp_dir.path().join("index.libsonnet"), "{}").unwrap();
+        std::fs::write(temp_dir.path().join("index.json"), "{}").unwrap();
+
+        let mut session = Session::new(&[temp_dir.path().to_path_buf()]);
+        assert_eq!(
+            session.bindings(),
+            &[
+                ("index".to_string(), "index.libsonnet".to_string()),
+                ("user".to_string(), "user.libsonnet".to_string()),
+            ]
+        );
+
+        session.add_local("local a = user.new('alice');".to_string());
+        assert_eq!(
+            session.program("a"),
+            concat!(
+                "local index = import \"index.libsonnet\";\n",
+                "local user = import \"user.libsonnet\";\n",
+                "local a = user.new('alice');\n",
+                "a\n",
+            )
+        );
+
+        session.reset();
+        assert!(session.locals().is_empty());
+    }
+
+    #[test]
+    fn test_is_complete() {
+        assert!(is_complete("user.new('alice')"));
+        assert!(!is_complete("user.new('alice')\n  .withLabels({"));
+        assert!(is_complete("{ a: '}' }"));
+        assert!(!is_complete("'unterminated"));
+        assert!(!is_complete("|||\n  text"));
+        assert!(is_complete("[1, 2] // ]"));
+    }
+}
@@ -0,0 +1,361 @@
+//! Machine-readable report of a run
+//!
+//! The report lists, for each processed source, every type generated, the
+//! types and fields left out, the struct tags that could not be
+//! translated and the warnings of the run, so generation quality can be
+//! audited programmatically across many repositories instead of by reading
+//! logs.
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::path::{Path, PathBuf};
+
+use crate::plugin::ast::unsupported::{Unsupported, UnsupportedKind};
+use crate::plugin::ExtractedSchema;
+
+/// Version of the report layout
+pub const REPORT_VERSION: u32 = 1;
+
+/// Schema type of the skipped types returned by the parser
+///
+/// These are not generated and are removed by `take_skipped_types`.
+pub const SKIPPED_SCHEMA_TYPE: &str = "go_skipped";
+
+/// Report of a run over every processed source
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct RunReport {
+    /// Layout version, increased on incompatible changes
+    pub version: u32,
+
+    pub sources: Vec<SourceReport>,
+}
+
+/// Report of one source
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SourceReport {
+    pub source: String,
+    pub types: Vec<TypeRecord>,
+    pub skipped_types: Vec<SkippedType>,
+    pub skipped_fields: Vec<SkippedField>,
+    pub untranslated_tags: Vec<UntranslatedTag>,
+    pub warnings: Vec<String>,
+    pub errors: Vec<String>,
+
+    /// Files of the output directories the run created, changed, left
+    /// unchanged and deleted
+    #[serde(default)]
+    pub files: crate::output::WriteCounts,
+}
+
+/// A generated type
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TypeRecord {
+    pub name: String,
+    pub package: String,
+
+    /// Go file, relative to the repository
+    pub file: PathBuf,
+
+    pub line: u64,
+}
+
+/// A type that is not generated
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SkippedType {
+    pub name: String,
+    pub package: String,
+    pub reason: String,
+    pub file: PathBuf,
+    pub line: u64,
+}
+
+/// A struct field left out of the schema of its type
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SkippedField {
+    #[serde(rename = "type")]
+    pub type_name: String,
+
+    pub field: String,
+    pub reason: String,
+    pub file: PathBuf,
+}
+
+/// A struct tag without an equivalent in the generated library
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct UntranslatedTag {
+    /// Stable identifier of the construct, as in the unsupported report
+    pub id: String,
+
+    #[serde(rename = "type")]
+    pub type_name: String,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub field: Option<String>,
+
+    pub tag: String,
+    pub message: String,
+    pub file: PathBuf,
+    pub line: usize,
+}
+
+impl Default for RunReport {
+    fn default() -> Self {
+        Self {
+            version: REPORT_VERSION,
+            sources: Vec::new(),
+        }
+    }
+}
+
+/// Remove the skipped types from `schemas`
+///
+/// Files are made relative to `repo_path`. The types are sorted by file and
+/// line.
+pub fn take_skipped_types(
+    schemas: &mut Vec<ExtractedSchema>,
+    repo_path: &Path,
+) -> Vec<SkippedType> {
+    let mut skipped: Vec<SkippedType> = schemas
+        .iter()
+        .filter(|schema| schema.schema_type == SKIPPED_SCHEMA_TYPE)
+        .map(|schema| SkippedType {
+            name: schema.name.clone(),
+            package: schema
+                .metadata
+                .get("package")
+                .and_then(|package| package.as_str())
+                .unwrap_or_default()
+                .to_string(),
+            reason: schema
+                .content
+                .get("reason")
+                .and_then(|reason| reason.as_str())
+                .unwrap_or_default()
+                .to_string(),
+            file: schema
+                .source_file
+                .strip_prefix(repo_path)
+                .unwrap_or(&schema.source_file)
+                .to_path_buf(),
+            line: schema
+                .metadata
+                .get("line")
+                .and_then(|line| line.as_u64())
+                .unwrap_or_default(),
+        })
+        .collect();
+    schemas.retain(|schema| schema.schema_type != SKIPPED_SCHEMA_TYPE);
+
+    skipped.sort_by(|a, b| (&a.file, a.line, &a.name).cmp(&(&b.file, b.line, &b.name)));
+    skipped.dedup();
+    skipped
+}
+
+impl RunReport {
+    /// Add the report of a source, replacing an earlier one of the same source
+    pub fn record(&mut self, report: SourceReport) {
+        self.sources.retain(|source| source.source != report.source);
+        self.sources.push(report);
+        self.sources.sort_by(|a, b| a.source.cmp(&b.source));
+    }
+
+    /// Render the report as JSON
+    pub fn to_json(&self) -> Result<String> {
+        Ok(serde_json::to_string_pretty(self)? + "\n")
+    }
+}
+
+impl SourceReport {
+    /// Build the report of a source from its types and unsupported constructs
+    ///
+    /// Files are made relative to `repo_path`; the constructs are expected
+    /// to be relative already, as returned by `take_unsupported`.
+    pub fn new(
+        source: &str,
+        repo_path: &Path,
+        schemas: &[ExtractedSchema],
+        unsupported: &[Unsupported],
+    ) -> Self {
+        let relative = |file: &Path| {
+            file.strip_prefix(repo_path)
+                .map(Path::to_path_buf)
+                .unwrap_or_else(|_| file.to_path_buf())
+        };
+
+        let mut types = Vec::new();
+        let mut skipped_fields = Vec::new();
+        for schema in schemas {
+            let metadata_str = |key: &str| {
+                schema
+                    .metadata
+                    .get(key)
+                    .and_then(|value| value.as_str())
+                    .unwrap_or_default()
+                    .to_string()
+            };
+            types.push(TypeRecord {
+                name: schema.name.clone(),
+                package: metadata_str("package"),
+                file: relative(&schema.source_file),
+                line: schema
+                    .metadata
+                    .get("line")
+                    .and_then(|line| line.as_u64())
+                    .unwrap_or_default(),
+            });
+
+            let skipped = schema
+                .metadata
+                .get("skipped_fields")
+                .and_then(|skipped| skipped.as_sequence());
+            for entry in skipped.into_iter().flatten() {
+                let field = |key: &str| entry.get(key).and_then(|v| v.as_str());
+                if let (Some(field), Some(reason)) = (field("field"), field("reason")) {
+                    skipped_fields.push(SkippedField {
+                        type_name: schema.name.clone(),
+                        field: field.to_string(),
+                        reason: reason.to_string(),
+                        file: relative(&schema.source_file),
+                    });
+                }
+            }
+        }
+        types.sort_by(|a, b| (&a.file, a.line, &a.name).cmp(&(&b.file, b.line, &b.name)));
+
+        // Tag options and `validate` rules are the tags without a translation
+        let untranslated_tags = unsupported
+            .iter()
+            .filter(|construct| {
+                matches!(
+                    construct.kind,
+                    UnsupportedKind::TagOption | UnsupportedKind::ValidateRule
+                )
+            })
+            .map(|construct| UntranslatedTag {
+                id: construct.id.clone(),
+                type_name: construct.type_name.clone(),
+                field: construct.field.clone(),
+                tag: construct.construct.clone(),
+                message: construct.message.clone(),
+                file: construct.file.clone(),
+                line: construct.line,
+            })
+            .collect();
+
+        Self {
+            source: source.to_string(),
+            types,
+            skipped_fields,
+            untranslated_tags,
+            ..Default::default()
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_take_skipped_types() {
+        let mut metadata = HashMap::new();
+        metadata.insert("package".to_string(), "api".into());
+        metadata.insert("line".to_string(), 30u64.into());
+        let mut schemas = vec![
+            ExtractedSchema {
+                name: "Internal".to_string(),
+                schema_type: SKIPPED_SCHEMA_TYPE.to_string(),
+                content: serde_yaml::from_str("{reason: marked +gensonnet:exclude}").unwrap(),
+                source_file: "/repo/api/user.go".into(),
+                metadata,
+            },
+            ExtractedSchema {
+                name: "User".to_string(),
+                schema_type: "go_struct".to_string(),
+                content: serde_yaml::Value::Null,
+                source_file: "/repo/api/user.go".into(),
+                metadata: HashMap::new(),
+            },
+        ];
+
+        let skipped = take_skipped_types(&mut schemas, Path::new("/repo"));
+        assert_eq!(
+            skipped,
+            vec![SkippedType {
+                name: "Internal".to_string(),
+                package: "api".to_string(),
+                reason: "marked +gensonnet:exclude".to_string(),
+                file: "api/user.go".into(),
+                line: 30,
+            }]
+        );
+        assert_eq!(schemas.len(), 1);
+        assert_eq!(schemas[0].name, "User");
+    }
+
+    #[test]
+    fn test_source_report() {
+        let mut metadata = HashMap::new();
+        metadata.insert("package".to_string(), "api".into());
+        metadata.insert("line".to_string(), 12u64.into());
+        metadata.insert(
+            "skipped_fields".to_string(),
+            serde_yaml::from_str("[{field: cache, reason: unexported}]").unwrap(),
+        );
+        let schemas = vec![ExtractedSchema {
+            name: "User".to_string(),
+            schema_type: "go_struct".to_string(),
+            content: serde_yaml::Value::Null,
+            source_file: "/repo/api/user.go".into(),
+            metadata,
+        }];
+        let unsupported = vec![
+            Unsupported {
+                id: "GS-1".to_string(),
+                kind: UnsupportedKind::TagOption,
+                source: "api".to_string(),
+                file: "api/user.go".into(),
+                line: 14,
+                type_name: "User".to_string(),
+                field: Some("Age".to_string()),
+                construct: "json:\",string\"".to_string(),
+                message: "User.Age: json tag option 'string' is not supported and is ignored"
+                    .to_string(),
+            },
+            Unsupported {
+                id: "GS-2".to_string(),
+                kind: UnsupportedKind::Generic,
+                source: "api".to_string(),
+                file: "api/user.go".into(),
+                line: 15,
+                type_name: "User".to_string(),
+                field: Some("Tags".to_string()),
+                construct: "Set[string]".to_string(),
+                message: String::new(),
+            },
+        ];
+
+        let report = SourceReport::new("api", Path::new("/repo"), &schemas, &unsupported);
+        assert_eq!(
+            report.types,
+            vec![TypeRecord {
+                name: "User".to_string(),
+                package: "api".to_string(),
+                file: "api/user.go".into(),
+                line: 12,
+            }]
+        );
+        assert_eq!(report.skipped_fields[0].field, "cache");
+        assert_eq!(report.skipped_fields[0].reason, "unexported");
+        assert_eq!(report.untranslated_tags.len(), 1);
+        assert_eq!(report.untranslated_tags[0].tag, "json:\",string\"");
+
+        let mut run = RunReport::default();
+        run.record(report.clone());
+        run.record(report);
+        assert_eq!(run.sources.len(), 1);
+        let json: serde_json::Value = serde_json::from_str(&run.to_json().unwrap()).unwrap();
+        assert_eq!(json["sources"][0]["skipped_fields"][0]["type"], "User");
+    }
+}
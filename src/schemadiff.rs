@@ -0,0 +1,641 @@
+//! Semantic differences between versions of the generated types
+//!
+//! Next to the libraries, the generator writes `types.json`, a snapshot of
+//! the fields of every type with their type, whether they are required,
+//! their default and their checks. Comparing two snapshots, of two output
+//! directories or of two revisions of a source, gives a report of the
+//! changes that matter to the consumers of the libraries: fields that were
+//! added, removed or retyped, defaults that changed and checks that were
+//! tightened or loosened. Changes that can make an existing use fail are
+//! marked as breaking.
+//!
+//! An allowlist records intentional breaks, so a CI gate only fails on the
+//! ones nobody accepted:
+//!
+//! ```yaml
+//! - subject: User.email
+//!   kind: removed
+//!   reason: replaced by contacts in v2
+//! - subject: Legacy*
+//! ```
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::fmt;
+use std::path::Path;
+
+use crate::plugin::ast::aliases::STRUCT_REF_KEY;
+use crate::plugin::ast::GoJsonnetGenerator;
+use crate::plugin::ExtractedSchema;
+
+/// File name of the snapshot written next to the generated libraries
+pub const SNAPSHOT_FILE: &str = "types.json";
+
+/// Default file of the intentional breaks, in the working directory
+pub const ALLOWLIST_FILE: &str = "gensonnet-breaking.yaml";
+
+/// Version of the snapshot layout
+pub const SNAPSHOT_VERSION: u32 = 1;
+
+/// Checks that reject more values as they grow
+const LOWER_BOUNDS: &[&str] = &[
+    "minimum",
+    "exclusiveMinimum",
+    "minLength",
+    "minItems",
+    "minProperties",
+];
+
+/// Checks that reject more values as they shrink
+const UPPER_BOUNDS: &[&str] = &[
+    "maximum",
+    "exclusiveMaximum",
+    "maxLength",
+    "maxItems",
+    "maxProperties",
+];
+
+/// Checks whose any change may reject values
+const EXACT_CHECKS: &[&str] = &["pattern", "format", "multipleOf", "uniqueItems"];
+
+/// Fields of the generated types
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Snapshot {
+    /// Layout version, increased on incompatible changes
+    pub version: u32,
+
+    /// Types by name
+    pub types: BTreeMap<String, TypeShape>,
+}
+
+/// Fields of a type
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct TypeShape {
+    /// Library of the type
+    pub file: String,
+
+    /// Fields by path, with nested objects as `spec.replicas`
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub fields: BTreeMap<String, FieldShape>,
+
+    /// Values of an enum, or variants of a union
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub values: Vec<Value>,
+}
+
+/// Type, default and checks of a field
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct FieldShape {
+    /// JSON Schema type, or the referenced type (`array<User>`)
+    #[serde(rename = "type")]
+    pub field_type: String,
+
+    /// Whether the field is required, as a parameter of `new()`
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub required: bool,
+
+    /// Whether the field may be null
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub nullable: bool,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub default: Option<Value>,
+
+    /// Validation keywords with their value
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub checks: BTreeMap<String, Value>,
+}
+
+impl Default for Snapshot {
+    fn default() -> Self {
+        Self {
+            version: SNAPSHOT_VERSION,
+            types: BTreeMap::new(),
+        }
+    }
+}
+
+impl Snapshot {
+    /// Take the snapshot of a set of types
+    pub fn from_schemas(schemas: &[ExtractedSchema]) -> Self {
+        let generator = GoJsonnetGenerator::new();
+        let mut snapshot = Snapshot::default();
+        for schema in schemas {
+            let mut shape = TypeShape {
+                file: generator.file_name(schema),
+                ..Default::default()
+            };
+            let content = &schema.content;
+            if let Some(variants) = content
+                .get("x-go-union")
+                .and_then(|u| u.get("variants"))
+                .and_then(|v| v.as_sequence())
+            {
+                shape.values = variants
+                    .iter()
+                    .filter_map(|variant| variant.get("name").and_then(json))
+                    .collect();
+            } else if let Some(values) = content.get("enum").and_then(|e| e.as_sequence()) {
+                shape.values = values.iter().filter_map(json).collect();
+            }
+            add_fields(&mut shape.fields, "", content);
+            snapshot.types.insert(schema.name.clone(), shape);
+        }
+        snapshot
+    }
+
+    /// Read a snapshot file, or the snapshot of an output directory
+    pub fn load(path: &Path) -> Result<Self> {
+        let file = match path.is_dir() {
+            true => path.join(SNAPSHOT_FILE),
+            false => path.to_path_buf(),
+        };
+        if !file.is_file() {
+            return Err(anyhow!(
+                "No {} in {}; regenerate the libraries to write it",
+                SNAPSHOT_FILE,
+                path.display()
+            ));
+        }
+        let content = std::fs::read_to_string(&file)?;
+        Self::from_json(&content).map_err(|e| anyhow!("Snapshot file {:?}: {}", file, e))
+    }
+
+    /// Parse a snapshot, as written by `to_json`
+    pub fn from_json(content: &str) -> Result<Self> {
+        let snapshot: Snapshot =
+            serde_json::from_str(content).map_err(|e| anyhow!("Invalid snapshot: {}", e))?;
+        if snapshot.version > SNAPSHOT_VERSION {
+            return Err(anyhow!(
+                "Snapshot has version {}, newer than the supported {}",
+                snapshot.version,
+                SNAPSHOT_VERSION
+            ));
+        }
+        Ok(snapshot)
+    }
+
+    /// Serialize the snapshot as pretty-printed JSON
+    pub fn to_json(&self) -> Result<String> {
+        Ok(serde_json::to_string_pretty(self)? + "\n")
+    }
+
+    /// Compare with a newer snapshot
+    pub fn diff(&self, new: &Snapshot) -> Report {
+        let mut changes = Vec::new();
+        for (name, shape) in &self.types {
+            let Some(new_shape) = new.types.get(name) else {
+                changes.push(Change::new(name, ChangeKind::Removed, "type removed"));
+                continue;
+            };
+            diff_values(name, &shape.values, &new_shape.values, &mut changes);
+            for (path, field) in &shape.fields {
+                let subject = format!("{name}.{path}");
+                match new_shape.fields.get(path) {
+                    None => {
+                        changes.push(Change::new(&subject, ChangeKind::Removed, "field removed"))
+                    }
+                    Some(new_field) => diff_field(&subject, field, new_field, &mut changes),
+                }
+            }
+            for (path, field) in &new_shape.fields {
+                if !shape.fields.contains_key(path) {
+                    let (kind, detail) = match field.required {
+                        true => (ChangeKind::Required, "required field added"),
+                        false => (ChangeKind::Added, "optional field added"),
+                    };
+                    changes.push(Change::new(&format!("{name}.{path}"), kind, detail));
+                }
+            }
+        }
+        for name in new.types.keys() {
+            if !self.types.contains_key(name) {
+                changes.push(Change::new(name, ChangeKind::Added, "type added"));
+            }
+        }
+
+        Report { changes }
+    }
+}
+
+/// Kind of a change between two snapshots
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum ChangeKind {
+    /// A type, field or value was added
+    Added,
+
+    /// A type, field or value was removed
+    Removed,
+
+    /// A field changed type
+    Retyped,
+
+    /// A required field was added, or a field became required
+    Required,
+
+    /// A field no longer has a default, or has another one
+    Default,
+
+    /// A check rejects values it accepted before
+    Tightened,
+
+    /// A check accepts values it rejected before
+    Loosened,
+}
+
+impl ChangeKind {
+    /// Name of the kind, as in reports and allowlists
+    pub fn as_str(self) -> &'static str {
+        match self {
+            ChangeKind::Added => "added",
+            ChangeKind::Removed => "removed",
+            ChangeKind::Retyped => "retyped",
+            ChangeKind::Required => "required",
+            ChangeKind::Default => "default",
+            ChangeKind::Tightened => "tightened",
+            ChangeKind::Loosened => "loosened",
+        }
+    }
+
+    /// Whether the change can make an existing use of the libraries fail
+    pub fn is_breaking(self) -> bool {
+        matches!(
+            self,
+            ChangeKind::Removed
+                | ChangeKind::Retyped
+                | ChangeKind::Required
+                | ChangeKind::Tightened
+        )
+    }
+}
+
+/// A change of a type or field
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Change {
+    /// Type, or field as `Type.field`
+    pub subject: String,
+
+    pub kind: ChangeKind,
+
+    /// Description of the change
+    pub detail: String,
+
+    pub breaking: bool,
+}
+
+impl Change {
+    fn new(subject: &str, kind: ChangeKind, detail: impl Into<String>) -> Self {
+        Self {
+            subject: subject.to_string(),
+            kind,
+            detail: detail.into(),
+            breaking: kind.is_breaking(),
+        }
+    }
+}
+
+impl fmt::Display for Change {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let marker = match self.breaking {
+            true => "breaking",
+            false => "compatible",
+        };
+        write!(f, "[{marker}] {}: {}", self.subject, self.detail)
+    }
+}
+
+/// Changes between two snapshots
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct Report {
+    pub changes: Vec<Change>,
+}
+
+impl Report {
+    /// Whether any change can make an existing use fail
+    pub fn is_breaking(&self) -> bool {
+        self.changes.iter().any(|change| change.breaking)
+    }
+
+    /// Serialize the report as pretty-printed JSON
+    pub fn to_json(&self) -> Result<String> {
+        let breaking = self.changes.iter().filter(|c| c.breaking).count();
+        let report = serde_json::json!({
+            "breaking": breaking,
+            "changes": self.changes,
+        });
+        Ok(serde_json::to_string_pretty(&report)? + "\n")
+    }
+}
+
+/// An intentional break
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct AllowedBreak {
+    /// Type or field, as a glob (`User.email`, `Legacy*`)
+    pub subject: String,
+
+    /// Kind of the change, any when unset
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub kind: Option<ChangeKind>,
+
+    /// Why the break is accepted
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub reason: Option<String>,
+}
+
+impl AllowedBreak {
+    /// Check whether the entry accepts a change
+    pub fn allows(&self, change: &Change) -> bool {
+        if self.kind.is_some_and(|kind| kind != change.kind) {
+            return false;
+        }
+        match glob::Pattern::new(&self.subject) {
+            Ok(pattern) => pattern.matches(&change.subject),
+            Err(_) => self.subject == change.subject,
+        }
+    }
+}
+
+/// Read a file of intentional breaks
+pub fn load_allowlist(path: &Path) -> Result<Vec<AllowedBreak>> {
+    let content = std::fs::read_to_string(path)
+        .map_err(|e| anyhow!("Failed to read allowlist {:?}: {}", path, e))?;
+    if content.trim().is_empty() {
+        return Ok(Vec::new());
+    }
+    serde_yaml::from_str(&content).map_err(|e| anyhow!("Invalid allowlist {:?}: {}", path, e))
+}
+
+/// Add the fields of an object schema, recursing into inline objects
+fn add_fields(fields: &mut BTreeMap<String, FieldShape>, prefix: &str, schema: &serde_yaml::Value) {
+    let Some(properties) = schema.get("properties").and_then(|p| p.as_mapping()) else {
+        return;
+    };
+    let required: Vec<&str> = schema
+        .get("required")
+        .and_then(|r| r.as_sequence())
+        .into_iter()
+        .flatten()
+        .filter_map(|name| name.as_str())
+        .collect();
+
+    for (name, property) in properties {
+        let Some(name) = name.as_str() else {
+            continue;
+        };
+        let path = format!("{prefix}{name}");
+        let flag = |key: &str| property.get(key).and_then(|v| v.as_bool()) == Some(true);
+        let checks = LOWER_BOUNDS
+            .iter()
+            .chain(UPPER_BOUNDS)
+            .chain(EXACT_CHECKS)
+            .chain(&["enum"])
+            .filter_map(|key| Some((key.to_string(), json(property.get(*key)?)?)))
+            .collect();
+        fields.insert(
+            path.clone(),
+            FieldShape {
+                field_type: field_type(property),
+                required: required.contains(&name) || flag("x-go-constructor-param"),
+                nullable: flag("nullable"),
+                default: property.get("default").and_then(json),
+                checks,
+            },
+        );
+        if property.get(STRUCT_REF_KEY).is_none() {
+            add_fields(fields, &format!("{path}."), property);
+        }
+    }
+}
+
+/// Name the type of a field, with references by type name
+fn field_type(property: &serde_yaml::Value) -> String {
+    for key in [STRUCT_REF_KEY, "x-go-interface-ref"] {
+        if let Some(name) = property.get(key).and_then(|n| n.as_str()) {
+            return name.to_string();
+        }
+    }
+    if let Some(name) = property
+        .get("x-go-ref")
+        .and_then(|r| r.get("type"))
+        .and_then(|t| t.as_str())
+    {
+        return name.to_string();
+    }
+
+    let schema_type = property
+        .get("type")
+        .and_then(|t| t.as_str())
+        .unwrap_or("any");
+    let element = match schema_type {
+        "array" => property.get("items"),
+        "object" => property
+            .get("additionalProperties")
+            .filter(|p| p.is_mapping()),
+        _ => None,
+    };
+    match element {
+        Some(element) => format!("{schema_type}<{}>", field_type(element)),
+        None => schema_type.to_string(),
+    }
+}
+
+/// Compare the values of an enum or the variants of a union
+fn diff_values(name: &str, old: &[Value], new: &[Value], changes: &mut Vec<Change>) {
+    for value in old.iter().filter(|value| !new.contains(value)) {
+        changes.push(Change::new(
+            name,
+            ChangeKind::Removed,
+            format!("value {value} removed"),
+        ));
+    }
+    for value in new.iter().filter(|value| !old.contains(value)) {
+        changes.push(Change::new(
+            name,
+            ChangeKind::Added,
+            format!("value {value} added"),
+        ));
+    }
+}
+
+/// Compare two versions of a field
+fn diff_field(subject: &str, old: &FieldShape, new: &FieldShape, changes: &mut Vec<Change>) {
+    if old.field_type != new.field_type {
+        changes.push(Change::new(
+            subject,
+            ChangeKind::Retyped,
+            format!("type changed from {} to {}", old.field_type, new.field_type),
+        ));
+    }
+    if !old.required && new.required {
+        changes.push(Change::new(
+            subject,
+            ChangeKind::Required,
+            "field became required",
+        ));
+    } else if old.required && !new.required {
+        changes.push(Change::new(
+            subject,
+            ChangeKind::Loosened,
+            "field became optional",
+        ));
+    }
+    if old.nullable && !new.nullable {
+        changes.push(Change::new(
+            subject,
+            ChangeKind::Tightened,
+            "field is no longer nullable",
+        ));
+    } else if !old.nullable && new.nullable {
+        changes.push(Change::new(
+            subject,
+            ChangeKind::Loosened,
+            "field became nullable",
+        ));
+    }
+    if old.default != new.default {
+        let describe = |value: &Option<Value>| match value {
+            Some(value) => value.to_string(),
+            None => "none".to_string(),
+        };
+        changes.push(Change::new(
+            subject,
+            ChangeKind::Default,
+            format!(
+                "default changed from {} to {}",
+                describe(&old.default),
+                describe(&new.default)
+            ),
+        ));
+    }
+
+    let keys: std::collections::BTreeSet<&String> =
+        old.checks.keys().chain(new.checks.keys()).collect();
+    for key in keys {
+        let (before, after) = (old.checks.get(key), new.checks.get(key));
+        if before == after {
+            continue;
+        }
+        let tightened = match (before, after) {
+            (None, Some(_)) => true,
+            (Some(_), None) => false,
+            (Some(before), Some(after)) if key == "enum" => {
+                let values = |v: &Value| v.as_array().cloned().unwrap_or_default();
+                let after = values(after);
+                values(before).iter().any(|value| !after.contains(value))
+            }
+            (Some(before), Some(after)) => match (before.as_f64(), after.as_f64()) {
+                (Some(before), Some(after)) if LOWER_BOUNDS.contains(&key.as_str()) => {
+                    after > before
+                }
+                (Some(before), Some(after)) if UPPER_BOUNDS.contains(&key.as_str()) => {
+                    after < before
+                }
+                _ => true,
+            },
+            (None, None) => continue,
+        };
+        let describe = |value: Option<&Value>| match value {
+            Some(value) => value.to_string(),
+            None => "none".to_string(),
+        };
+        let (kind, verb) = match tightened {
+            true => (ChangeKind::Tightened, "tightened"),
+            false => (ChangeKind::Loosened, "loosened"),
+        };
+        changes.push(Change::new(
+            subject,
+            kind,
+            format!(
+                "{key} {verb} from {} to {}",
+                describe(before),
+                describe(after)
+            ),
+        ));
+    }
+}
+
+/// Convert a YAML value to JSON
+fn json(value: &serde_yaml::Value) -> Option<Value> {
+    serde_json::to_value(value).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::plugin::test_schema;
+
+    #[test]
+    fn test_snapshot() {
+        let snapshot = Snapshot::from_schemas(&[
+            test_schema("User", "go_struct", "types.go", "{properties: {address: {properties: {city: {type: string}}, type: object}, groups: {items: {type: object, x-go-struct-ref: Group}, type: array}, name: {minLength: 1, type: string, x-go-constructor-param: true}}, type: object}"),
+            test_schema("Role", "go_struct", "types.go", "{enum: [admin, member], type: string}"),
+        ]);
+
+        let user = &snapshot.types["User"];
+        assert_eq!(user.file, "user.libsonnet");
+        assert_eq!(
+            user.fields.keys().collect::<Vec<_>>(),
+            vec!["address", "address.city", "groups", "name"]
+        );
+        assert_eq!(user.fields["groups"].field_type, "array<Group>");
+        assert!(user.fields["name"].required);
+        assert_eq!(user.fields["name"].checks["minLength"], 1);
+        assert_eq!(snapshot.types["Role"].values, vec!["admin", "member"]);
+
+        let json = snapshot.to_json().unwrap();
+        assert_eq!(serde_json::from_str::<Snapshot>(&json).unwrap(), snapshot);
+    }
+
+    #[test]
+    fn test_diff() {
+        let old = Snapshot::from_schemas(&[
+            test_schema("User", "go_struct", "types.go", "{properties: {age: {maximum: 150, type: integer}, email: {type: string}, name: {minLength: 1, type: string}, role: {default: member, type: string}}, type: object}"),
+            test_schema("Role", "go_struct", "types.go", "{enum: [admin, member, guest], type: string}"),
+            test_schema("Legacy", "go_struct", "types.go", "{properties: {}, type: object}"),
+        ]);
+        let new = Snapshot::from_schemas(&[
+            test_schema("User", "go_struct", "types.go", "{properties: {age: {maximum: 120, type: string}, name: {minLength: 3, type: string}, nick: {type: string}, org: {type: string, x-go-constructor-param: true}, role: {default: admin, type: string}}, type: object}"),
+            test_schema("Role", "go_struct", "types.go", "{enum: [admin, member, owner], type: string}"),
+        ]);
+
+        let report = old.diff(&new);
+        let lines: Vec<String> = report.changes.iter().map(|c| c.to_string()).collect();
+        assert_eq!(
+            lines,
+            vec![
+                "[breaking] Legacy: type removed",
+                "[breaking] Role: value \"guest\" removed",
+                "[compatible] Role: value \"owner\" added",
+                "[breaking] User.age: type changed from integer to string",
+                "[breaking] User.age: maximum tightened from 150 to 120",
+                "[breaking] User.email: field removed",
+                "[breaking] User.name: minLength tightened from 1 to 3",
+                "[compatible] User.role: default changed from \"member\" to \"admin\"",
+                "[compatible] User.nick: optional field added",
+                "[breaking] User.org: required field added",
+            ]
+        );
+        assert!(report.is_breaking());
+        assert!(report.to_json().unwrap().contains("\"breaking\": 7"));
+        assert!(!old.diff(&old).is_breaking());
+
+        let allowed: Vec<AllowedBreak> = serde_yaml::from_str(
+            "[{kind: removed, reason: merged into contacts, subject: User.email}, {subject: Legacy*}, {kind: removed, subject: User.age}]",
+        )
+        .unwrap();
+        let unaccepted: Vec<&str> = report
+            .changes
+            .iter()
+            .filter(|change| change.breaking)
+            .filter(|change| !allowed.iter().any(|entry| entry.allows(change)))
+            .map(|change| change.subject.as_str())
+            .collect();
+        assert_eq!(
+            unaccepted,
+            vec!["Role", "User.age", "User.age", "User.name", "User.org"]
+        );
+    }
+}
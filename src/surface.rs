@@ -0,0 +1,425 @@
+//! API surface of generated libraries
+//!
+//! The surface lists the public functions of every library with their
+//! parameters, in a stable JSON layout meant to be checked in by the teams
+//! using the libraries. Comparing two surfaces shows which functions a
+//! regeneration removed or changed, so contract tests can assert that the
+//! functions they depend on still exist.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::Path;
+use walkdir::WalkDir;
+
+use crate::compat::{function_paths, tokenize};
+use crate::plugin::ast::generator::tombstone_names;
+
+/// File name of the surface written next to the generated libraries
+pub const SURFACE_FILE: &str = "surface.json";
+
+/// Version of the surface layout
+pub const SURFACE_VERSION: u32 = 1;
+
+/// Type of parameters whose type is not known
+pub const ANY_TYPE: &str = "any";
+
+/// Public functions of a set of libraries
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Surface {
+    /// Layout version, increased on incompatible changes
+    pub version: u32,
+
+    /// Functions of each library, keyed by file name
+    pub libraries: BTreeMap<String, BTreeMap<String, Vec<Param>>>,
+}
+
+/// A function parameter
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Param {
+    pub name: String,
+
+    /// JSON Schema type, with the format when there is one (`string/email`)
+    #[serde(rename = "type")]
+    pub param_type: String,
+
+    /// Whether the parameter has a default value
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub optional: bool,
+}
+
+impl Default for Surface {
+    fn default() -> Self {
+        Self {
+            version: SURFACE_VERSION,
+            libraries: BTreeMap::new(),
+        }
+    }
+}
+
+impl Surface {
+    /// Add the functions of a library
+    ///
+    /// Functions nested in fields are keyed by their path (`spec.withImage`).
+    /// `types` gives the parameter types by function, in order; other
+    /// parameters are typed `any`. Tombstones are not part of the surface.
+    pub fn add_library(&mut self, file: &str, code: &str, types: &BTreeMap<String, Vec<String>>) {
+        let tombstones = tombstone_names(code);
+        let mut functions = BTreeMap::new();
+
+        for (name, params) in function_paths(&tokenize(code)) {
+            if tombstones.contains(&name) {
+                continue;
+            }
+            let params = split_params(&params)
+                .into_iter()
+                .enumerate()
+                .map(|(idx, param)| {
+                    let (param_name, optional) = match param.split_once('=') {
+                        Some((param_name, _)) => (param_name.trim().to_string(), true),
+                        None => (param.trim().to_string(), false),
+                    };
+                    let param_type = types
+                        .get(&name)
+                        .and_then(|t| t.get(idx))
+                        .cloned()
+                        .unwrap_or_else(|| ANY_TYPE.to_string());
+                    Param {
+                        name: param_name,
+                        param_type,
+                        optional,
+                    }
+                })
+                .collect();
+            functions.insert(name, params);
+        }
+
+        if !functions.is_empty() {
+            self.libraries.insert(file.to_string(), functions);
+        }
+    }
+
+    /// Derive the surface of the libraries in an output directory
+    ///
+    /// A `surface.json` written by the generator is used when present, since
+    /// it knows the parameter types.
+    pub fn from_directory(dir: &Path) -> Result<Self> {
+        let surface_file = dir.join(SURFACE_FILE);
+        if surface_file.is_file() {
+            return Self::from_file(&surface_file);
+        }
+
+        let mut surface = Surface::default();
+        for entry in WalkDir::new(dir).max_depth(1).sort_by_file_name() {
+            let entry = entry?;
+            let path = entry.path();
+            if !entry.file_type().is_file()
+                || path.extension().and_then(|ext| ext.to_str()) != Some("libsonnet")
+            {
+                continue;
+            }
+            let code = std::fs::read_to_string(path)?;
+            let file = entry.file_name().to_string_lossy();
+            surface.add_library(&file, &code, &BTreeMap::new());
+        }
+        Ok(surface)
+    }
+
+    /// Read a surface file
+    pub fn from_file(path: &Path) -> Result<Self> {
+        let content = std::fs::read_to_string(path)?;
+        let surface: Surface = serde_json::from_str(&content)
+            .map_err(|e| anyhow!("Invalid surface file {:?}: {}", path, e))?;
+        if surface.version > SURFACE_VERSION {
+            return Err(anyhow!(
+                "Surface file {:?} has version {}, newer than the supported {}",
+                path,
+                surface.version,
+                SURFACE_VERSION
+            ));
+        }
+        Ok(surface)
+    }
+
+    /// Read a surface file, or derive the surface of an output directory
+    pub fn load(path: &Path) -> Result<Self> {
+        if path.is_dir() {
+            Self::from_directory(path)
+        } else {
+            Self::from_file(path)
+        }
+    }
+
+    /// Serialize the surface as pretty-printed JSON
+    pub fn to_json(&self) -> Result<String> {
+        Ok(serde_json::to_string_pretty(self)? + "\n")
+    }
+
+    /// Compare with a newer surface
+    pub fn diff(&self, new: &Surface) -> SurfaceDiff {
+        let mut diff = SurfaceDiff::default();
+
+        for (file, functions) in &self.libraries {
+            let Some(new_functions) = new.libraries.get(file) else {
+                diff.removed.push(file.clone());
+                continue;
+            };
+            for (name, params) in functions {
+                let function = format!("{file}: {name}");
+                match new_functions.get(name) {
+                    None => diff.removed.push(function),
+                    Some(new_params) => {
+                        if let Some(change) = param_change(params, new_params) {
+                            diff.changed.push(format!("{function}: {change}"));
+                        } else if new_params.len() > params.len() {
+                            diff.added
+                                .push(format!("{function}: optional parameters added"));
+                        }
+                    }
+                }
+            }
+            for name in new_functions.keys() {
+                if !functions.contains_key(name) {
+                    diff.added.push(format!("{file}: {name}"));
+                }
+            }
+        }
+        for file in new.libraries.keys() {
+            if !self.libraries.contains_key(file) {
+                diff.added.push(file.clone());
+            }
+        }
+
+        diff
+    }
+}
+
+/// Differences between two surfaces
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct SurfaceDiff {
+    /// Libraries and functions that no longer exist
+    pub removed: Vec<String>,
+
+    /// Functions whose existing calls may no longer work
+    pub changed: Vec<String>,
+
+    /// Libraries, functions and optional parameters that were added
+    pub added: Vec<String>,
+}
+
+impl SurfaceDiff {
+    /// Whether calls valid for the old surface may fail with the new one
+    pub fn is_breaking(&self) -> bool {
+        !self.removed.is_empty() || !self.changed.is_empty()
+    }
+}
+
+/// Describe the change of a parameter list that breaks existing calls
+///
+/// Calls may pass arguments by position or by name, so renaming or retyping
+/// a parameter breaks them, as does adding a required one.
+fn param_change(old: &[Param], new: &[Param]) -> Option<String> {
+    for (idx, param) in old.iter().enumerate() {
+        let Some(new_param) = new.get(idx) else {
+            return Some(format!("parameter {} was removed", param.name));
+        };
+        if new_param.name != param.name {
+            return Some(format!(
+                "parameter {} was renamed to {}",
+                param.name, new_param.name
+            ));
+        }
+        let typed = param.param_type != ANY_TYPE && new_param.param_type != ANY_TYPE;
+        // Element types were added to `array` and `object` after the type
+        let refined = new_param
+            .param_type
+            .strip_prefix(&param.param_type)
+            .is_some_and(|rest| rest.starts_with('<'));
+        if typed && !refined && new_param.param_type != param.param_type {
+            return Some(format!(
+                "parameter {} changed from {} to {}",
+                param.name, param.param_type, new_param.param_type
+            ));
+        }
+        if param.optional && !new_param.optional {
+            return Some(format!("parameter {} is now required", param.name));
+        }
+    }
+
+    new[old.len().min(new.len())..]
+        .iter()
+        .find(|param| !param.optional)
+        .map(|param| format!("required parameter {} was added", param.name))
+}
+
+/// Split a parameter list at its top-level commas
+fn split_params(params: &str) -> Vec<String> {
+    let mut result = Vec::new();
+    let mut current = String::new();
+    let mut depth = 0i32;
+    let mut quote: Option<char> = None;
+
+    for c in params.chars() {
+        match quote {
+            Some(q) => {
+                if c == q {
+                    quote = None;
+                }
+                current.push(c);
+            }
+            None => match c {
+                '\'' | '"' => {
+                    quote = Some(c);
+                    current.push(c);
+                }
+                '(' | '[' | '{' => {
+                    depth += 1;
+                    current.push(c);
+                }
+                ')' | ']' | '}' => {
+                    depth -= 1;
+                    current.push(c);
+                }
+                ',' if depth == 0 => result.push(std::mem::take(&mut current)),
+                _ => current.push(c),
+            },
+        }
+    }
+    result.push(current);
+
+    result
+        .into_iter()
+        .map(|param| param.trim().to_string())
+        .filter(|param| !param.is_empty())
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const USER: &str = r#"{
+  new(name, namespace='default'):: self + { name: name },
+  withEmail(email):: self + { email: email },
+  // Tombstone for v2: withNick was removed
+  withNick(nick):: error "withNick was removed in v2",
+}
+"#;
+
+    #[test]
+    fn test_add_library() {
+        let types = BTreeMap::from([("withEmail".to_string(), vec!["string/email".to_string()])]);
+        let mut surface = Surface::default();
+        surface.add_library("user.libsonnet", USER, &types);
+
+        let functions = &surface.libraries["user.libsonnet"];
+        assert_eq!(
+            functions.keys().collect::<Vec<_>>(),
+            vec!["new", "withEmail"]
+        );
+        assert_eq!(
+            functions["new"],
+            vec![
+                Param {
+                    name: "name".to_string(),
+                    param_type: ANY_TYPE.to_string(),
+                    optional: false,
+                },
+                Param {
+                    name: "namespace".to_string(),
+                    param_type: ANY_TYPE.to_string(),
+                    optional: true,
+                },
+            ]
+        );
+        assert_eq!(functions["withEmail"][0].param_type, "string/email");
+
+        let json = surface.to_json().unwrap();
+        assert!(json.contains("\"type\": \"string/email\""));
+        assert_eq!(serde_json::from_str::<Surface>(&json).unwrap(), surface);
+    }
+
+    #[test]
+    fn test_add_library_nested() {
+        let code = "{ new(name):: {}, spec:: { withReplicas(replicas):: {}, validate(obj):: obj }, validate(obj):: obj }";
+        let types =
+            BTreeMap::from([("spec.withReplicas".to_string(), vec!["integer".to_string()])]);
+        let mut surface = Surface::default();
+        surface.add_library("widget.libsonnet", code, &types);
+
+        let functions = &surface.libraries["widget.libsonnet"];
+        assert_eq!(
+            functions.keys().collect::<Vec<_>>(),
+            vec!["new", "spec.validate", "spec.withReplicas", "validate"]
+        );
+        assert_eq!(functions["spec.withReplicas"][0].param_type, "integer");
+    }
+
+    #[test]
+    fn test_diff() {
+        let mut old = Surface::default();
+        old.add_library("user.libsonnet", USER, &BTreeMap::new());
+        old.add_library("group.libsonnet", "{ new():: {} }", &BTreeMap::new());
+
+        let mut new = Surface::default();
+        new.add_library(
+            "user.libsonnet",
+            "{ new(name, namespace='default', labels={}):: {}, withPhone(phone):: {} }",
+            &BTreeMap::new(),
+        );
+        let diff = old.diff(&new);
+        assert_eq!(
+            diff.removed,
+            vec!["group.libsonnet", "user.libsonnet: withEmail"]
+        );
+        assert_eq!(
+            diff.added,
+            vec![
+                "user.libsonnet: new: optional parameters added",
+                "user.libsonnet: withPhone",
+            ]
+        );
+        assert!(diff.is_breaking());
+        assert!(!old.diff(&old).is_breaking());
+    }
+
+    #[test]
+    fn test_param_change() {
+        let param = |name: &str, param_type: &str, optional: bool| Param {
+            name: name.to_string(),
+            param_type: param_type.to_string(),
+            optional,
+        };
+
+        let old = vec![param("name", "string", false)];
+        assert_eq!(
+            param_change(&old, &[param("name", "integer", false)]),
+            Some("parameter name changed from string to integer".to_string())
+        );
+        assert_eq!(
+            param_change(&old, &[param("title", "string", false)]),
+            Some("parameter name was renamed to title".to_string())
+        );
+        assert_eq!(
+            param_change(
+                &old,
+                &[
+                    param("name", "string", false),
+                    param("age", "integer", false)
+                ]
+            ),
+            Some("required parameter age was added".to_string())
+        );
+        assert_eq!(param_change(&old, &[param("name", ANY_TYPE, false)]), None);
+
+        let old = vec![param("tags", "array", false)];
+        assert_eq!(
+            param_change(&old, &[param("tags", "array<string>", false)]),
+            None
+        );
+        assert_eq!(
+            param_change(&[param("tags", "array<string>", false)], &old),
+            Some("parameter tags changed from array<string> to array".to_string())
+        );
+    }
+}
@@ -0,0 +1,226 @@
+//! Packaging of output directories as Tanka libraries
+//!
+//! Libraries of the jsonnet-libs generators keep generated code apart from
+//! hand-written code, so regenerating never loses customizations:
+//!
+//! ```text
+//! widgets/
+//!   main.libsonnet      entry point, generated
+//!   gen/                generated libraries, replaced on every run
+//!   _custom/            mixins applied to main.libsonnet, never written
+//! ```
+//!
+//! `main.libsonnet` holds every library of `gen/` keyed by name, followed
+//! by each file of `_custom/` in name order as a mixin, e.g.
+//! `{ user+: { withDefaults():: self.withRole('member') } }`.
+
+use anyhow::Result;
+use std::path::Path;
+
+use crate::overrides::OVERRIDES_FILE;
+use crate::plugin::ast::generator::{field_key, quote_string};
+
+/// Entry point of the library
+pub const MAIN_FILE: &str = "main.libsonnet";
+
+/// Directory of the generated libraries
+pub const GEN_DIR: &str = "gen";
+
+/// Directory of the hand-written mixins
+pub const CUSTOM_DIR: &str = "_custom";
+
+/// Entries of an output directory that are not moved to `gen/`
+const KEPT: &[&str] = &[
+    MAIN_FILE,
+    GEN_DIR,
+    CUSTOM_DIR,
+    OVERRIDES_FILE,
+    "jsonnetfile.json",
+    "jsonnetfile.lock.json",
+    "vendor",
+];
+
+/// Lay out a freshly generated output directory as a Tanka library
+///
+/// The generated files are moved to `gen/`, replacing its previous
+/// contents, `_custom/` is created when missing and `main.libsonnet` is
+/// rewritten. Files of `gen/` with the same content as the generated ones
+/// are left in place.
+pub fn package_directory(dir: &Path) -> Result<()> {
+    let gen_dir = dir.join(GEN_DIR);
+    std::fs::create_dir_all(&gen_dir)?;
+    std::fs::create_dir_all(dir.join(CUSTOM_DIR))?;
+
+    let mut generated = Vec::new();
+    for entry in std::fs::read_dir(dir)? {
+        let entry = entry?;
+        let name = entry.file_name();
+        if KEPT.contains(&name.to_string_lossy().as_ref()) {
+            continue;
+        }
+        generated.push(name);
+    }
+    for entry in std::fs::read_dir(&gen_dir)? {
+        let entry = entry?;
+        if !generated.contains(&entry.file_name()) {
+            remove_entry(&entry.path())?;
+        }
+    }
+    for name in &generated {
+        move_entry(&dir.join(name), &gen_dir.join(name))?;
+    }
+
+    crate::output::write_if_changed(&dir.join(MAIN_FILE), main_library(dir)?)?;
+    Ok(())
+}
+
+/// Move a file or directory over another, leaving the files of `to` with
+/// the same content as those of `from` untouched
+fn move_entry(from: &Path, to: &Path) -> Result<()> {
+    if !from.is_dir() {
+        if to.is_file() && std::fs::read(from)? == std::fs::read(to)? {
+            std::fs::remove_file(from)?;
+            return Ok(());
+        }
+        if to.exists() {
+            remove_entry(to)?;
+        }
+        std::fs::rename(from, to)?;
+        return Ok(());
+    }
+
+    if to.exists() && !to.is_dir() {
+        std::fs::remove_file(to)?;
+    }
+    std::fs::create_dir_all(to)?;
+    let mut names = Vec::new();
+    for entry in std::fs::read_dir(from)? {
+        names.push(entry?.file_name());
+    }
+    for entry in std::fs::read_dir(to)? {
+        let entry = entry?;
+        if !names.contains(&entry.file_name()) {
+            remove_entry(&entry.path())?;
+        }
+    }
+    for name in &names {
+        move_entry(&from.join(name), &to.join(name))?;
+    }
+    std::fs::remove_dir(from)?;
+    Ok(())
+}
+
+/// Remove a file or a directory with its contents
+fn remove_entry(path: &Path) -> Result<()> {
+    match path.is_dir() {
+        true => std::fs::remove_dir_all(path)?,
+        false => std::fs::remove_file(path)?,
+    }
+    Ok(())
+}
+
+/// Render `main.libsonnet` from the files of `gen/` and `_custom/`
+fn main_library(dir: &Path) -> Result<String> {
+    let mut libraries = libsonnet_stems(&dir.join(GEN_DIR))?;
+    libraries.sort();
+    let mut mixins = libsonnet_stems(&dir.join(CUSTOM_DIR))?;
+    mixins.sort();
+
+    let mut code = String::from(
+        "// Generated by gensonnet: libraries of gen/ with the mixins of _custom/\n// Do not edit; customizations go to _custom/, which is never overwritten\n{\n",
+    );
+    for library in &libraries {
+        code.push_str(&format!(
+            "  {}: import {},\n",
+            field_key(library),
+            quote_string(&format!("{GEN_DIR}/{library}.libsonnet"))
+        ));
+    }
+    code.push('}');
+    for mixin in &mixins {
+        code.push_str(&format!(
+            "\n+ (import {})",
+            quote_string(&format!("{CUSTOM_DIR}/{mixin}.libsonnet"))
+        ));
+    }
+    code.push('\n');
+    Ok(code)
+}
+
+/// Get the names of the `.libsonnet` files of a directory
+fn libsonnet_stems(dir: &Path) -> Result<Vec<String>> {
+    let mut stems = Vec::new();
+    if !dir.is_dir() {
+        return Ok(stems);
+    }
+    for entry in std::fs::read_dir(dir)? {
+        let path = entry?.path();
+        if path.is_file() && path.extension().is_some_and(|ext| ext == "libsonnet") {
+            if let Some(stem) = path.file_stem() {
+                stems.push(stem.to_string_lossy().to_string());
+            }
+        }
+    }
+    Ok(stems)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_package_directory() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        std::fs::create_dir_all(dir.join("tests")).unwrap();
+        std::fs::create_dir_all(dir.join(CUSTOM_DIR)).unwrap();
+        std::fs::write(dir.join("user.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("index.json"), "{}\n").unwrap();
+        std::fs::write(dir.join("tests/user_test.jsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("jsonnetfile.json"), "{}\n").unwrap();
+        std::fs::write(
+            dir.join(CUSTOM_DIR).join("user.libsonnet"),
+            "{ user+: { admin():: self.new() } }\n",
+        )
+        .unwrap();
+
+        package_directory(dir).unwrap();
+        assert!(dir.join("gen/user.libsonnet").is_file());
+        assert!(dir.join("gen/index.json").is_file());
+        assert!(dir.join("gen/tests/user_test.jsonnet").is_file());
+        assert!(dir.join("jsonnetfile.json").is_file());
+        assert!(!dir.join("user.libsonnet").exists());
+
+        let main = std::fs::read_to_string(dir.join(MAIN_FILE)).unwrap();
+        assert!(main.contains(
+            "{\n  user: import \"gen/user.libsonnet\",\n}\n+ (import \"_custom/user.libsonnet\")\n"
+        ));
+
+        // Regenerating replaces gen/ and keeps _custom/
+        std::fs::write(dir.join("group.libsonnet"), "{}\n").unwrap();
+        package_directory(dir).unwrap();
+        assert!(dir.join("gen/group.libsonnet").is_file());
+        assert!(!dir.join("gen/user.libsonnet").exists());
+        assert!(!dir.join("gen/tests").exists());
+        assert!(dir.join(CUSTOM_DIR).join("user.libsonnet").is_file());
+
+        // Unchanged libraries keep their modification time
+        let modified = |file: &str| {
+            std::fs::metadata(dir.join(file))
+                .unwrap()
+                .modified()
+                .unwrap()
+        };
+        let before = modified("gen/group.libsonnet");
+        std::thread::sleep(std::time::Duration::from_millis(20));
+        std::fs::write(dir.join("group.libsonnet"), "{}\n").unwrap();
+        std::fs::write(dir.join("index.json"), "{ \"group\": {} }\n").unwrap();
+        package_directory(dir).unwrap();
+        assert_eq!(modified("gen/group.libsonnet"), before);
+        assert_eq!(
+            std::fs::read_to_string(dir.join("gen/index.json")).unwrap(),
+            "{ \"group\": {} }\n"
+        );
+        assert!(!dir.join("group.libsonnet").exists());
+    }
+}
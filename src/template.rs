@@ -0,0 +1,442 @@
+//! Custom code generation templates and their golden tests
+//!
+//! A template is a Jsonnet program evaluating to a function of a type graph:
+//! the list of types a source yields, as `name`, `schema_type` and `content`.
+//! It returns the generated code as a string, or an object of generated file
+//! names to their code. The `templates` of a Go AST source are rendered with
+//! the types of the source into its output directory, a string as
+//! `<template>.libsonnet`. Teams keeping their own templates check them
+//! against fixtures of type graphs and the output expected for each, so an
+//! upgrade of gensonnet or of the templates that changes the output is
+//! noticed.
+//!
+//! For a template `<templates>/<name>.jsonnet` and a fixture
+//! `<fixtures>/<case>.yaml` (or `.json`), the expected output is
+//! `<fixtures>/<case>/<name>.golden` for a string, and the files of the
+//! directory `<fixtures>/<case>/<name>/` for an object.
+
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::evaluate::Evaluator;
+use crate::plugin::ExtractedSchema;
+
+/// Extension of the golden file of a template returning a string
+pub const GOLDEN_EXTENSION: &str = "golden";
+
+/// Output of a template for a fixture
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Rendered {
+    /// A single piece of code
+    Code(String),
+
+    /// Generated files keyed by path
+    Files(BTreeMap<String, String>),
+}
+
+impl Rendered {
+    /// Get the files written for the output of a template
+    pub fn into_files(self, template: &Path) -> BTreeMap<String, String> {
+        match self {
+            Rendered::Code(code) => [(format!("{}.libsonnet", stem(template)), code)].into(),
+            Rendered::Files(files) => files,
+        }
+    }
+}
+
+/// A difference between the output of a template and its golden files
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Mismatch {
+    /// An expected file was not generated
+    Missing(String),
+
+    /// A file was generated without a golden file
+    Unexpected(String),
+
+    /// A file differs from its golden file, from the given line on
+    Changed {
+        file: String,
+        line: usize,
+        expected: String,
+        actual: String,
+    },
+}
+
+impl std::fmt::Display for Mismatch {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Mismatch::Missing(file) => write!(f, "{file}: expected but not generated"),
+            Mismatch::Unexpected(file) => write!(f, "{file}: generated but not expected"),
+            Mismatch::Changed {
+                file,
+                line,
+                expected,
+                actual,
+            } => write!(f, "{file}:{line}: expected {expected:?}, got {actual:?}"),
+        }
+    }
+}
+
+/// Result of rendering a template against a fixture
+#[derive(Debug, Clone)]
+pub struct CaseResult {
+    pub template: String,
+    pub fixture: String,
+
+    /// Differences to the golden files; empty when the case passes
+    pub mismatches: Vec<Mismatch>,
+}
+
+impl CaseResult {
+    /// Check whether the output matches the golden files
+    pub fn passed(&self) -> bool {
+        self.mismatches.is_empty()
+    }
+}
+
+/// Renders templates against fixtures and compares them to golden files
+pub struct TemplateTester {
+    evaluator: Evaluator,
+    templates_dir: PathBuf,
+    fixtures_dir: PathBuf,
+}
+
+impl TemplateTester {
+    /// Create a tester for the templates and fixtures of two directories
+    pub fn new(evaluator: Evaluator, templates_dir: &Path, fixtures_dir: &Path) -> Self {
+        Self {
+            evaluator,
+            templates_dir: templates_dir.to_path_buf(),
+            fixtures_dir: fixtures_dir.to_path_buf(),
+        }
+    }
+
+    /// Render every template against every fixture
+    ///
+    /// With `update`, the golden files are rewritten from the output instead
+    /// and every case passes.
+    pub fn run(&self, update: bool) -> Result<Vec<CaseResult>> {
+        let templates = files_with_extension(&self.templates_dir, &["jsonnet"])?;
+        if templates.is_empty() {
+            return Err(anyhow!(
+                "No templates (*.jsonnet) in {}",
+                self.templates_dir.display()
+            ));
+        }
+        let fixtures = files_with_extension(&self.fixtures_dir, &["yaml", "yml", "json"])?;
+        if fixtures.is_empty() {
+            return Err(anyhow!(
+                "No fixtures (*.yaml, *.json) in {}",
+                self.fixtures_dir.display()
+            ));
+        }
+
+        let mut results = Vec::new();
+        for fixture in &fixtures {
+            let types = load_fixture(fixture)?;
+            for template in &templates {
+                let rendered = self.render(template, &types)?;
+                let golden = self.golden_path(fixture, template, &rendered);
+                let mismatches = if update {
+                    write_golden(&golden, &rendered)?;
+                    Vec::new()
+                } else {
+                    compare(&golden, &rendered)?
+                };
+                results.push(CaseResult {
+                    template: stem(template),
+                    fixture: stem(fixture),
+                    mismatches,
+                });
+            }
+        }
+        Ok(results)
+    }
+
+    /// Evaluate a template with a type graph
+    pub fn render(&self, template: &Path, types: &Value) -> Result<Rendered> {
+        render(&self.evaluator, template, types)
+    }
+
+    /// Get the golden file or directory of a case
+    fn golden_path(&self, fixture: &Path, template: &Path, rendered: &Rendered) -> PathBuf {
+        let dir = self.fixtures_dir.join(stem(fixture));
+        match rendered {
+            Rendered::Code(_) => dir.join(format!("{}.{}", stem(template), GOLDEN_EXTENSION)),
+            Rendered::Files(_) => dir.join(stem(template)),
+        }
+    }
+}
+
+/// Evaluate a template with a type graph
+pub fn render(evaluator: &Evaluator, template: &Path, types: &Value) -> Result<Rendered> {
+    let path = template
+        .canonicalize()
+        .unwrap_or_else(|_| template.to_path_buf());
+    let output = evaluator
+        .evaluate(&format!(
+            "(import {})({})",
+            serde_json::to_string(&path.to_string_lossy())?,
+            serde_json::to_string(types)?
+        ))
+        .map_err(|e| anyhow!("Failed to render {}: {}", template.display(), e))?;
+    let value: Value = serde_json::from_str(&output)
+        .map_err(|e| anyhow!("Invalid output of {}: {}", template.display(), e))?;
+    rendered(value).map_err(|e| anyhow!("Invalid output of {}: {}", template.display(), e))
+}
+
+/// Get the type graph of the types of a source, as templates receive it
+pub fn type_graph(schemas: &[ExtractedSchema]) -> Result<Value> {
+    schemas
+        .iter()
+        .map(|schema| {
+            Ok(serde_json::json!({
+                "name": schema.name,
+                "schema_type": schema.schema_type,
+                "content": serde_json::to_value(&schema.content)?,
+            }))
+        })
+        .collect::<Result<Vec<_>>>()
+        .map(Value::Array)
+}
+
+/// Interpret the evaluated output of a template
+fn rendered(value: Value) -> Result<Rendered> {
+    match value {
+        Value::String(code) => Ok(Rendered::Code(code)),
+        Value::Object(files) => files
+            .into_iter()
+            .map(|(file, code)| match code {
+                Value::String(code) => Ok((file, code)),
+                other => Err(anyhow!("file {} should be a string, got {}", file, other)),
+            })
+            .collect::<Result<_>>()
+            .map(Rendered::Files),
+        other => Err(anyhow!(
+            "expected a string or an object of files, got {}",
+            other
+        )),
+    }
+}
+
+/// Read a fixture type graph
+fn load_fixture(path: &Path) -> Result<Value> {
+    let content = std::fs::read_to_string(path)
+        .map_err(|e| anyhow!("Failed to read fixture {}: {}", path.display(), e))?;
+    serde_yaml::from_str(&content).map_err(|e| anyhow!("Invalid fixture {}: {}", path.display(), e))
+}
+
+/// Compare the output of a template to its golden file or directory
+fn compare(golden: &Path, rendered: &Rendered) -> Result<Vec<Mismatch>> {
+    let mut mismatches = Vec::new();
+    match rendered {
+        Rendered::Code(code) => {
+            let name = golden.to_string_lossy().to_string();
+            match std::fs::read_to_string(golden) {
+                Ok(expected) => mismatches.extend(compare_code(&name, &expected, code)),
+                Err(_) => mismatches.push(Mismatch::Unexpected(name)),
+            }
+        }
+        Rendered::Files(files) => {
+            let expected: BTreeMap<String, PathBuf> = if golden.is_dir() {
+                WalkDir::new(golden)
+                    .into_iter()
+                    .collect::<std::result::Result<Vec<_>, _>>()?
+                    .into_iter()
+                    .filter(|entry| entry.file_type().is_file())
+                    .filter_map(|entry| {
+                        let relative = entry.path().strip_prefix(golden).ok()?;
+                        Some((
+                            relative.to_string_lossy().replace('\\', "/"),
+                            entry.path().to_path_buf(),
+                        ))
+                    })
+                    .collect()
+            } else {
+                BTreeMap::new()
+            };
+
+            for (file, path) in &expected {
+                match files.get(file) {
+                    Some(code) => {
+                        let expected = std::fs::read_to_string(path)?;
+                        mismatches.extend(compare_code(file, &expected, code));
+                    }
+                    None => mismatches.push(Mismatch::Missing(file.clone())),
+                }
+            }
+            for file in files.keys() {
+                if !expected.contains_key(file) {
+                    mismatches.push(Mismatch::Unexpected(file.clone()));
+                }
+            }
+        }
+    }
+    Ok(mismatches)
+}
+
+/// Compare generated code to its golden file, reporting the first changed line
+fn compare_code(file: &str, expected: &str, actual: &str) -> Option<Mismatch> {
+    if expected == actual {
+        return None;
+    }
+    let mut expected_lines = expected.lines();
+    let mut actual_lines = actual.lines();
+    let mut line = 1;
+    loop {
+        match (expected_lines.next(), actual_lines.next()) {
+            (Some(e), Some(a)) if e == a => line += 1,
+            (e, a) => {
+                return Some(Mismatch::Changed {
+                    file: file.to_string(),
+                    line,
+                    expected: e.unwrap_or("<end of file>").to_string(),
+                    actual: a.unwrap_or("<end of file>").to_string(),
+                })
+            }
+        }
+    }
+}
+
+/// Write the output of a template as its golden file or directory
+fn write_golden(golden: &Path, rendered: &Rendered) -> Result<()> {
+    match rendered {
+        Rendered::Code(code) => {
+            if let Some(parent) = golden.parent() {
+                std::fs::create_dir_all(parent)?;
+            }
+            std::fs::write(golden, code)?;
+        }
+        Rendered::Files(files) => {
+            if golden.is_dir() {
+                std::fs::remove_dir_all(golden)?;
+            }
+            for (file, code) in files {
+                let path = golden.join(file);
+                if let Some(parent) = path.parent() {
+                    std::fs::create_dir_all(parent)?;
+                }
+                std::fs::write(path, code)?;
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Get the files of a directory with one of the given extensions, sorted
+fn files_with_extension(dir: &Path, extensions: &[&str]) -> Result<Vec<PathBuf>> {
+    if !dir.is_dir() {
+        return Err(anyhow!("Not a directory: {}", dir.display()));
+    }
+    let mut files: Vec<PathBuf> = WalkDir::new(dir)
+        .max_depth(1)
+        .into_iter()
+        .collect::<std::result::Result<Vec<_>, _>>()?
+        .into_iter()
+        .map(|entry| entry.into_path())
+        .filter(|path| path.is_file())
+        .filter(|path| {
+            path.extension()
+                .and_then(|ext| ext.to_str())
+                .is_some_and(|ext| extensions.contains(&ext))
+        })
+        .collect();
+    files.sort();
+    Ok(files)
+}
+
+/// Get the file name of a path without its extension
+fn stem(path: &Path) -> String {
+    path.file_stem()
+        .map(|stem| stem.to_string_lossy().to_string())
+        .unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_rendered() {
+        assert_eq!(
+            rendered(json!("local a = 1;\n")).unwrap(),
+            Rendered::Code("local a = 1;\n".to_string())
+        );
+        let files = rendered(json!({ "user.libsonnet": "{}\n" })).unwrap();
+        assert!(matches!(files, Rendered::Files(f) if f["user.libsonnet"] == "{}\n"));
+        assert!(rendered(json!({ "user.libsonnet": 1 })).is_err());
+        assert!(rendered(json!([])).is_err());
+    }
+
+    #[test]
+    fn test_type_graph() {
+        let user = crate::plugin::test_schema(
+            "User",
+            "go_struct",
+            "api/user.go",
+            "properties: {name: {type: string}}",
+        );
+        assert_eq!(
+            type_graph(&[user]).unwrap(),
+            json!([{
+                "name": "User",
+                "schema_type": "go_struct",
+                "content": {"properties": {"name": {"type": "string"}}},
+            }])
+        );
+        assert_eq!(
+            Rendered::Code("{}\n".to_string()).into_files(Path::new("templates/service.jsonnet")),
+            BTreeMap::from([("service.libsonnet".to_string(), "{}\n".to_string())])
+        );
+    }
+
+    #[test]
+    fn test_compare_code() {
+        assert_eq!(compare_code("a", "x\ny\n", "x\ny\n"), None);
+        assert_eq!(
+            compare_code("a", "x\ny\n", "x\nz\n"),
+            Some(Mismatch::Changed {
+                file: "a".to_string(),
+                line: 2,
+                expected: "y".to_string(),
+                actual: "z".to_string(),
+            })
+        );
+        let shorter = compare_code("a", "x\ny\n", "x\n").unwrap();
+        assert_eq!(
+            shorter.to_string(),
+            "a:2: expected \"y\", got \"<end of file>\""
+        );
+    }
+
+    #[test]
+    fn test_compare_files() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let golden = temp_dir.path().join("service/library");
+        let files = Rendered::Files(
+            [
+                ("user.libsonnet".to_string(), "{}\n".to_string()),
+                ("group.libsonnet".to_string(), "{}\n".to_string()),
+            ]
+            .into(),
+        );
+
+        write_golden(&golden, &files).unwrap();
+        assert!(compare(&golden, &files).unwrap().is_empty());
+
+        std::fs::write(golden.join("index.libsonnet"), "{}\n").unwrap();
+        std::fs::remove_file(golden.join("group.libsonnet")).unwrap();
+        let mismatches = compare(&golden, &files).unwrap();
+        assert_eq!(
+            mismatches,
+            vec![
+                Mismatch::Missing("index.libsonnet".to_string()),
+                Mismatch::Unexpected("group.libsonnet".to_string()),
+            ]
+        );
+    }
+}
@@ -0,0 +1,526 @@
+//! Terraform provider schemas as a source
+//!
+//! `terraform providers schema -json` prints the schemas of the resources
+//! and data sources of the providers a configuration requires. A
+//! `terraform` source reads files holding that output and generates a
+//! library per resource and data source, with the constructors, setters and
+//! validators of the Go AST libraries: required attributes are parameters
+//! of `new()`, nested blocks are objects or arrays of objects, and
+//! attributes only computed by the provider are left out.
+//!
+//! The libraries build the body of a block; `terraform.libsonnet` places
+//! bodies in the Terraform JSON layout, so configurations can be written
+//! as Jsonnet and rendered to `main.tf.json`:
+//!
+//! ```jsonnet
+//! local tf = import 'terraform.libsonnet';
+//! local instance = import 'aws_instance.libsonnet';
+//!
+//! tf.resource.aws_instance('web', instance.new(ami, 't3.micro'))
+//! + tf.output('ip', tf.ref('aws_instance', 'web', 'public_ip'))
+//! ```
+//!
+//! Expressions are strings in Terraform JSON, so references type-check only
+//! where the attribute is a string.
+
+use anyhow::{anyhow, Result};
+use serde::Deserialize;
+use std::collections::{BTreeMap, HashMap};
+use std::path::Path;
+
+use crate::plugin::ast::generator::field_key;
+use crate::plugin::ExtractedSchema;
+
+/// File of the helpers placing blocks in the Terraform JSON layout
+pub const BLOCKS_FILE: &str = "terraform.libsonnet";
+
+/// Schema type of a resource
+pub const RESOURCE_SCHEMA_TYPE: &str = "terraform_resource";
+
+/// Schema type of a data source
+pub const DATA_SOURCE_SCHEMA_TYPE: &str = "terraform_data_source";
+
+/// Prefix of the libraries of data sources, which may share the type of a
+/// resource
+const DATA_SOURCE_PREFIX: &str = "data_";
+
+/// Output of `terraform providers schema -json`
+#[derive(Debug, Deserialize)]
+struct ProviderSchemas {
+    #[serde(default)]
+    provider_schemas: BTreeMap<String, ProviderSchema>,
+}
+
+#[derive(Debug, Deserialize)]
+struct ProviderSchema {
+    #[serde(default)]
+    resource_schemas: BTreeMap<String, Schema>,
+
+    #[serde(default)]
+    data_source_schemas: BTreeMap<String, Schema>,
+}
+
+#[derive(Debug, Deserialize)]
+struct Schema {
+    block: Block,
+}
+
+#[derive(Debug, Default, Deserialize)]
+struct Block {
+    #[serde(default)]
+    attributes: BTreeMap<String, Attribute>,
+
+    #[serde(default)]
+    block_types: BTreeMap<String, BlockType>,
+
+    description: Option<String>,
+
+    #[serde(default)]
+    deprecated: bool,
+}
+
+#[derive(Debug, Deserialize)]
+struct Attribute {
+    /// Type of the attribute in the JSON encoding of cty types
+    #[serde(rename = "type")]
+    attribute_type: Option<serde_json::Value>,
+
+    /// Attributes of a nested object, instead of a type (protocol 6)
+    nested_type: Option<NestedType>,
+
+    description: Option<String>,
+
+    #[serde(default)]
+    required: bool,
+
+    #[serde(default)]
+    optional: bool,
+
+    #[serde(default)]
+    computed: bool,
+
+    #[serde(default)]
+    deprecated: bool,
+}
+
+#[derive(Debug, Deserialize)]
+struct NestedType {
+    #[serde(default)]
+    attributes: BTreeMap<String, Attribute>,
+
+    nesting_mode: NestingMode,
+}
+
+#[derive(Debug, Deserialize)]
+struct BlockType {
+    nesting_mode: NestingMode,
+
+    #[serde(default)]
+    block: Block,
+
+    #[serde(default)]
+    min_items: u64,
+
+    max_items: Option<u64>,
+}
+
+#[derive(Debug, Clone, Copy, Deserialize)]
+#[serde(rename_all = "snake_case")]
+enum NestingMode {
+    Single,
+    Group,
+    List,
+    Set,
+    Map,
+}
+
+/// Parse the resources and data sources of a provider schema file
+///
+/// `providers` names the providers to take, by source address
+/// (`hashicorp/aws`, `registry.terraform.io/hashicorp/aws`) or name
+/// (`aws`); every provider is taken when it is empty.
+pub fn parse_provider_schemas(
+    json: &str,
+    file: &Path,
+    providers: &[String],
+) -> Result<Vec<ExtractedSchema>> {
+    let document: ProviderSchemas = serde_json::from_str(json)
+        .map_err(|e| anyhow!("Invalid Terraform provider schema: {}", e))?;
+
+    let mut schemas = Vec::new();
+    for (address, provider) in &document.provider_schemas {
+        if !providers.is_empty() && !providers.iter().any(|name| is_provider(address, name)) {
+            continue;
+        }
+        let kinds = [
+            (
+                RESOURCE_SCHEMA_TYPE,
+                "resources",
+                &provider.resource_schemas,
+            ),
+            (
+                DATA_SOURCE_SCHEMA_TYPE,
+                "data_sources",
+                &provider.data_source_schemas,
+            ),
+        ];
+        for (schema_type, category, types) in kinds {
+            for (type_name, schema) in types {
+                let name = match schema_type {
+                    DATA_SOURCE_SCHEMA_TYPE => format!("{DATA_SOURCE_PREFIX}{type_name}"),
+                    _ => type_name.clone(),
+                };
+                let content = block_schema(&schema.block)
+                    .map_err(|e| anyhow!("{} of provider {}: {}", type_name, address, e))?;
+
+                let mut metadata = HashMap::new();
+                metadata.insert("package".to_string(), provider_name(address).into());
+                metadata.insert("import_path".to_string(), address.as_str().into());
+                metadata.insert("categories".to_string(), vec![category].into());
+                metadata.insert("terraform_type".to_string(), type_name.as_str().into());
+                if let Some(summary) = schema
+                    .block
+                    .description
+                    .as_deref()
+                    .and_then(|d| d.lines().next())
+                {
+                    metadata.insert("summary".to_string(), summary.into());
+                }
+
+                schemas.push(ExtractedSchema {
+                    name,
+                    schema_type: schema_type.to_string(),
+                    content,
+                    source_file: file.to_path_buf(),
+                    metadata,
+                });
+            }
+        }
+    }
+    Ok(schemas)
+}
+
+/// Whether a provider filter names the provider at `address`
+fn is_provider(address: &str, name: &str) -> bool {
+    address == name || address.ends_with(&format!("/{name}")) || provider_name(address) == name
+}
+
+/// Get the name of a provider from its source address
+fn provider_name(address: &str) -> &str {
+    address.rsplit('/').next().unwrap_or(address)
+}
+
+/// Convert a block to an object schema
+fn block_schema(block: &Block) -> Result<serde_yaml::Value> {
+    object_schema(
+        &block.attributes,
+        &block.block_types,
+        block.description.as_deref(),
+        block.deprecated,
+    )
+}
+
+/// Convert attributes and nested blocks to an object schema
+fn object_schema(
+    attributes: &BTreeMap<String, Attribute>,
+    block_types: &BTreeMap<String, BlockType>,
+    description: Option<&str>,
+    deprecated: bool,
+) -> Result<serde_yaml::Value> {
+    let mut properties = serde_yaml::Mapping::new();
+    let mut required = Vec::new();
+
+    for (name, attribute) in attributes {
+        // Attributes set only by the provider cannot be configured
+        if attribute.computed && !attribute.optional && !attribute.required {
+            continue;
+        }
+        let mut property = match (&attribute.nested_type, &attribute.attribute_type) {
+            (Some(nested), _) => nested_schema(nested)?,
+            (None, Some(attribute_type)) => type_schema(attribute_type)?,
+            (None, None) => return Err(anyhow!("attribute {} has no type", name)),
+        };
+        describe(
+            &mut property,
+            attribute.description.as_deref(),
+            attribute.deprecated,
+        );
+        if attribute.required {
+            property.insert("x-go-constructor-param".into(), true.into());
+            required.push(serde_yaml::Value::from(name.as_str()));
+        }
+        properties.insert(name.as_str().into(), property.into());
+    }
+
+    for (name, block_type) in block_types {
+        let nested = block_schema(&block_type.block)?;
+        let mut property = nesting(block_type.nesting_mode, nested);
+        if matches!(
+            block_type.nesting_mode,
+            NestingMode::List | NestingMode::Set
+        ) {
+            if block_type.min_items > 0 {
+                property.insert("minItems".into(), block_type.min_items.into());
+            }
+            if let Some(max_items) = block_type.max_items {
+                property.insert("maxItems".into(), max_items.into());
+            }
+        }
+        describe(
+            &mut property,
+            block_type.block.description.as_deref(),
+            block_type.block.deprecated,
+        );
+        if block_type.min_items > 0 {
+            required.push(serde_yaml::Value::from(name.as_str()));
+        }
+        properties.insert(name.as_str().into(), property.into());
+    }
+
+    let mut schema = serde_yaml::Mapping::new();
+    schema.insert("type".into(), "object".into());
+    describe(&mut schema, description, deprecated);
+    if !required.is_empty() {
+        schema.insert("required".into(), required.into());
+    }
+    schema.insert("properties".into(), properties.into());
+    Ok(schema.into())
+}
+
+/// Convert the attributes of a nested object to a schema
+fn nested_schema(nested: &NestedType) -> Result<serde_yaml::Mapping> {
+    let object = object_schema(&nested.attributes, &BTreeMap::new(), None, false)?;
+    Ok(nesting(nested.nesting_mode, object))
+}
+
+/// Wrap an object schema according to how its block nests
+fn nesting(mode: NestingMode, object: serde_yaml::Value) -> serde_yaml::Mapping {
+    let mut schema = serde_yaml::Mapping::new();
+    match mode {
+        NestingMode::Single | NestingMode::Group => return object_mapping(object),
+        NestingMode::List | NestingMode::Set => {
+            schema.insert("type".into(), "array".into());
+            schema.insert("items".into(), object);
+        }
+        NestingMode::Map => {
+            schema.insert("type".into(), "object".into());
+            schema.insert("additionalProperties".into(), object);
+        }
+    }
+    schema
+}
+
+fn object_mapping(object: serde_yaml::Value) -> serde_yaml::Mapping {
+    match object {
+        serde_yaml::Value::Mapping(mapping) => mapping,
+        _ => serde_yaml::Mapping::new(),
+    }
+}
+
+/// Convert a cty type to a schema
+///
+/// `dynamic` attributes take any value and have no type.
+fn type_schema(attribute_type: &serde_json::Value) -> Result<serde_yaml::Mapping> {
+    let mut schema = serde_yaml::Mapping::new();
+    match attribute_type {
+        serde_json::Value::String(primitive) => match primitive.as_str() {
+            "string" | "number" => {
+                schema.insert("type".into(), primitive.as_str().into());
+            }
+            "bool" => {
+                schema.insert("type".into(), "boolean".into());
+            }
+            "dynamic" => {}
+            other => return Err(anyhow!("unknown type {}", other)),
+        },
+        serde_json::Value::Array(parts) => match parts.as_slice() {
+            [kind, element] if kind == "list" || kind == "set" => {
+                schema.insert("type".into(), "array".into());
+                schema.insert("items".into(), type_schema(element)?.into());
+            }
+            [kind, element] if kind == "map" => {
+                schema.insert("type".into(), "object".into());
+                schema.insert("additionalProperties".into(), type_schema(element)?.into());
+            }
+            [kind, serde_json::Value::Object(attributes), ..] if kind == "object" => {
+                let mut properties = serde_yaml::Mapping::new();
+                for (name, attribute_type) in attributes {
+                    properties.insert(name.as_str().into(), type_schema(attribute_type)?.into());
+                }
+                schema.insert("type".into(), "object".into());
+                schema.insert("properties".into(), properties.into());
+            }
+            [kind, ..] if kind == "tuple" => {
+                schema.insert("type".into(), "array".into());
+            }
+            _ => return Err(anyhow!("unknown type {}", attribute_type)),
+        },
+        _ => return Err(anyhow!("unknown type {}", attribute_type)),
+    }
+    Ok(schema)
+}
+
+/// Add the description and deprecation of an attribute or block
+fn describe(schema: &mut serde_yaml::Mapping, description: Option<&str>, deprecated: bool) {
+    if let Some(description) = description.filter(|d| !d.is_empty()) {
+        schema.insert("description".into(), description.into());
+    }
+    if deprecated {
+        schema.insert(
+            "x-go-deprecated".into(),
+            "Deprecated by the provider".into(),
+        );
+    }
+}
+
+/// Generate the helpers placing blocks in the Terraform JSON layout
+pub fn generate_blocks(schemas: &[ExtractedSchema]) -> String {
+    let mut code = String::from("// Generated from Terraform provider schema: blocks\n{\n");
+    for (block, schema_type) in [
+        ("resource", RESOURCE_SCHEMA_TYPE),
+        ("data", DATA_SOURCE_SCHEMA_TYPE),
+    ] {
+        code.push_str(&format!("  {block}: {{\n"));
+        for schema in schemas.iter().filter(|s| s.schema_type == schema_type) {
+            let Some(type_name) = schema
+                .metadata
+                .get("terraform_type")
+                .and_then(|t| t.as_str())
+            else {
+                continue;
+            };
+            code.push_str(&format!(
+                "    {key}(name, body):: {{ {block}+: {{ {key}+: {{ [name]: body }} }} }},\n",
+                key = field_key(type_name)
+            ));
+        }
+        code.push_str("  },\n");
+    }
+    code.push_str("\n  // Declare a variable, an output or a local value\n");
+    code.push_str("  variable(name, body={}):: { variable+: { [name]: body } },\n");
+    code.push_str(
+        "  output(name, value, body={}):: { output+: { [name]: body { value: value } } },\n",
+    );
+    code.push_str("  locals(values):: { locals+: values },\n");
+    code.push_str("\n  // Reference an attribute of a block, as an expression;\n");
+    code.push_str("  // data sources are referenced with the type data.<type>\n");
+    code.push_str("  ref(type, name, attribute):: '${%s.%s.%s}' % [type, name, attribute],\n");
+    code.push_str("}\n");
+    code
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SCHEMA: &str = r#"{
+  "format_version": "1.0",
+  "provider_schemas": {
+    "registry.terraform.io/hashicorp/aws": {
+      "resource_schemas": {
+        "aws_instance": {
+          "version": 1,
+          "block": {
+            "attributes": {
+              "ami": {"type": "string", "required": true, "description": "AMI to use"},
+              "arn": {"type": "string", "computed": true},
+              "cpu_count": {"type": "number", "optional": true, "deprecated": true},
+              "tags": {"type": ["map", "string"], "optional": true},
+              "timeouts": {"nested_type": {"nesting_mode": "single", "attributes": {"create": {"type": "string", "optional": true}}}, "optional": true}
+            },
+            "block_types": {
+              "ebs_block_device": {
+                "nesting_mode": "set",
+                "block": {"attributes": {"device_name": {"type": "string", "required": true}}},
+                "min_items": 1
+              }
+            },
+            "description": "Provides an EC2 instance."
+          }
+        }
+      },
+      "data_source_schemas": {
+        "aws_ami": {
+          "block": {"attributes": {"owners": {"type": ["list", "string"], "optional": true}}}
+        }
+      }
+    },
+    "registry.terraform.io/hashicorp/random": {
+      "resource_schemas": {
+        "random_id": {"block": {"attributes": {"byte_length": {"type": "number", "required": true}}}}
+      }
+    }
+  }
+}"#;
+
+    #[test]
+    fn test_parse_provider_schemas() {
+        let schemas = parse_provider_schemas(
+            SCHEMA,
+            Path::new("aws.json"),
+            &["hashicorp/aws".to_string()],
+        )
+        .unwrap();
+        let names: Vec<&str> = schemas.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(names, vec!["aws_instance", "data_aws_ami"]);
+
+        let instance = &schemas[0];
+        assert_eq!(instance.metadata["package"], serde_yaml::Value::from("aws"));
+        assert_eq!(
+            instance.metadata["summary"],
+            serde_yaml::Value::from("Provides an EC2 instance.")
+        );
+        let properties = &instance.content["properties"];
+        assert!(properties.get("arn").is_none());
+        assert_eq!(
+            properties["ami"]["x-go-constructor-param"],
+            serde_yaml::Value::from(true)
+        );
+        assert!(properties["cpu_count"].get("x-go-deprecated").is_some());
+        assert_eq!(
+            properties["tags"]["additionalProperties"]["type"],
+            serde_yaml::Value::from("string")
+        );
+        assert_eq!(
+            properties["timeouts"]["properties"]["create"]["type"],
+            serde_yaml::Value::from("string")
+        );
+        assert_eq!(
+            properties["ebs_block_device"]["items"]["required"][0],
+            serde_yaml::Value::from("device_name")
+        );
+        assert_eq!(
+            properties["ebs_block_device"]["minItems"],
+            serde_yaml::Value::from(1)
+        );
+        let required: Vec<&str> = instance.content["required"]
+            .as_sequence()
+            .unwrap()
+            .iter()
+            .filter_map(|r| r.as_str())
+            .collect();
+        assert_eq!(required, vec!["ami", "ebs_block_device"]);
+
+        assert_eq!(
+            parse_provider_schemas(SCHEMA, Path::new("aws.json"), &[])
+                .unwrap()
+                .len(),
+            3
+        );
+        assert!(parse_provider_schemas("{", Path::new("aws.json"), &[]).is_err());
+    }
+
+    #[test]
+    fn test_generate_blocks() {
+        let schemas =
+            parse_provider_schemas(SCHEMA, Path::new("aws.json"), &["aws".to_string()]).unwrap();
+        let code = generate_blocks(&schemas);
+        assert!(code.contains(
+            "    aws_instance(name, body):: { resource+: { aws_instance+: { [name]: body } } },\n"
+        ));
+        assert!(
+            code.contains("    aws_ami(name, body):: { data+: { aws_ami+: { [name]: body } } },\n")
+        );
+        assert!(!code.contains("random_id"));
+    }
+}
@@ -33,6 +33,12 @@ pub fn get_cache_dir() -> Result<PathBuf> {
     Ok(cache_dir)
 }
 
+/// Whether a file is Jsonnet code, a `.jsonnet` program or `.libsonnet` library
+pub fn is_jsonnet(path: &Path) -> bool {
+    path.extension()
+        .is_some_and(|ext| ext == "jsonnet" || ext == "libsonnet")
+}
+
 /// Find all YAML files in a directory recursively
 pub fn find_yaml_files(dir: &Path) -> Result<Vec<PathBuf>> {
     let mut yaml_files = Vec::new();
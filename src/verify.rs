@@ -0,0 +1,141 @@
+//! Evaluation of generated libraries
+//!
+//! Every generated file is imported and fully evaluated with `jsonnet`, and
+//! the `new()` of each type library is called, so syntax errors and broken
+//! imports between generated files fail the generation run instead of being
+//! found by the users of the libraries.
+
+use anyhow::Result;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use walkdir::WalkDir;
+
+use crate::compat::{function_surface, tokenize};
+use crate::evaluate::Evaluator;
+use crate::plugin::ast::generator::{quote_string, TESTS_DIR};
+use crate::utils::is_jsonnet;
+
+/// A generated file that failed to evaluate
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Failure {
+    pub file: PathBuf,
+    pub message: String,
+}
+
+/// Evaluate every Jsonnet file below `dir`
+///
+/// `samples` holds the `new()` arguments to use for a file, as Jsonnet
+/// literals; files without samples get `null` for each parameter.
+pub fn verify_directory(
+    evaluator: &Evaluator,
+    dir: &Path,
+    samples: &HashMap<PathBuf, Vec<String>>,
+) -> Result<Vec<Failure>> {
+    if !dir.is_dir() {
+        return Ok(Vec::new());
+    }
+
+    // Generated tests import jsonnetunit and include programs meant to fail
+    let tests_dir = dir.join(TESTS_DIR);
+    let entries = WalkDir::new(dir)
+        .sort_by_file_name()
+        .into_iter()
+        .filter_entry(|e| e.path() != tests_dir);
+
+    let mut failures = Vec::new();
+    for entry in entries {
+        let entry = entry?;
+        let path = entry.path();
+        if !entry.file_type().is_file() || !is_jsonnet(path) {
+            continue;
+        }
+
+        let args = match samples.get(path) {
+            Some(args) => args.clone(),
+            None => placeholder_args(&std::fs::read_to_string(path)?),
+        };
+        let import = path.canonicalize().unwrap_or_else(|_| path.to_path_buf());
+        if let Err(e) = evaluator.evaluate(&verify_program(&import, &args)) {
+            failures.push(Failure {
+                file: path.to_path_buf(),
+                message: e.to_string(),
+            });
+        }
+    }
+
+    Ok(failures)
+}
+
+/// Build the program evaluating a file and its constructor
+///
+/// Hidden fields are evaluated too, since the setters and imported libraries
+/// of generated code are hidden.
+pub fn verify_program(file: &Path, args: &[String]) -> String {
+    format!(
+        concat!(
+            "local force(value) =\n",
+            "  if std.isObject(value) then std.all([force(value[k]) for k in std.objectFieldsAll(value)])\n",
+            "  else if std.isArray(value) then std.all([force(e) for e in value])\n",
+            "  else true;\n",
+            "local lib = import {};\n",
+            "force(lib) && (!std.isObject(lib) || !std.objectHasAll(lib, 'new') || force(lib.new({})))\n",
+        ),
+        quote_string(&file.to_string_lossy()),
+        args.join(", ")
+    )
+}
+
+/// Get a `null` argument for each required parameter of `new()` in `code`
+fn placeholder_args(code: &str) -> Vec<String> {
+    let surface = function_surface(&tokenize(code));
+    let params = surface.get("new").map(String::as_str).unwrap_or_default();
+    params
+        .split(',')
+        .map(str::trim)
+        .filter(|param| !param.is_empty() && !param.contains('='))
+        .map(|_| "null".to_string())
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_placeholder_args() {
+        assert_eq!(
+            placeholder_args("{ new(name, namespace='default'):: {} }"),
+            vec!["null"]
+        );
+        assert!(placeholder_args("{ new():: {} }").is_empty());
+        assert!(placeholder_args("{ packages: {} }").is_empty());
+    }
+
+    #[test]
+    fn test_verify_program() {
+        let program = verify_program(
+            Path::new("/out/user.libsonnet"),
+            &["\"alice\"".to_string(), "1".to_string()],
+        );
+        assert!(program.contains("local lib = import \"/out/user.libsonnet\";\n"));
+        assert!(program.contains("force(lib.new(\"alice\", 1))"));
+    }
+
+    #[test]
+    fn test_missing_evaluator_fails_files() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        std::fs::write(temp_dir.path().join("user.libsonnet"), "{}").unwrap();
+        std::fs::write(temp_dir.path().join("index.json"), "{}").unwrap();
+        std::fs::create_dir(temp_dir.path().join(TESTS_DIR)).unwrap();
+        std::fs::write(temp_dir.path().join("tests/user_test.jsonnet"), "{}").unwrap();
+
+        let evaluator = Evaluator::new(
+            Some(Path::new("/nonexistent/jsonnet")),
+            vec![temp_dir.path().to_path_buf()],
+        );
+        let failures = verify_directory(&evaluator, temp_dir.path(), &HashMap::new()).unwrap();
+        assert_eq!(failures.len(), 1);
+        assert_eq!(failures[0].file, temp_dir.path().join("user.libsonnet"));
+        assert!(failures[0].message.contains("Failed to run"));
+    }
+}
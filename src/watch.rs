@@ -0,0 +1,669 @@
+//! Source file watching
+//!
+//! Detects changes by polling and comparing content hashes rather than relying
+//! on file system events, which are not delivered reliably on NFS and SMB
+//! mounts. Editors saving through a temporary file and a rename briefly remove
+//! the target file; changes are only reported once the tree has stopped
+//! changing for the debounce period, so such saves are seen as a single
+//! modification.
+//!
+//! The configuration file is watched as well: a new configuration replaces
+//! the running one, and only the sources it affects are regenerated. So are
+//! the inputs written by hand outside the checkout, the overrides of the
+//! output directories and the templates of the sources.
+
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, Instant};
+use walkdir::WalkDir;
+
+use crate::config::{Config, Source};
+use crate::overrides::OVERRIDES_FILE;
+use crate::tanka::CUSTOM_DIR;
+use crate::utils::calculate_file_hash;
+
+/// Content hashes of the files below a directory
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Snapshot {
+    /// Content hash of each file, keyed by path
+    pub files: BTreeMap<PathBuf, String>,
+
+    /// Files that could not be read, usually because they are being written
+    pub unreadable: Vec<PathBuf>,
+}
+
+impl Snapshot {
+    /// Take a snapshot of the files below `root`
+    ///
+    /// Hidden directories, editor temporary files and everything below the
+    /// `ignored` paths are skipped.
+    pub fn take(root: &Path, ignored: &[PathBuf]) -> Result<Self> {
+        if !root.is_dir() {
+            return Err(anyhow!("Watched path is not a directory: {:?}", root));
+        }
+
+        let mut snapshot = Snapshot::default();
+        let entries = WalkDir::new(root).into_iter().filter_entry(|e| {
+            e.depth() == 0
+                || !(is_hidden(e.file_name().to_str())
+                    || ignored.iter().any(|i| e.path().starts_with(i)))
+        });
+
+        for entry in entries {
+            let entry = match entry {
+                Ok(entry) => entry,
+                // The entry was removed while walking; the next poll sees the result
+                Err(_) => continue,
+            };
+            if !entry.file_type().is_file() || is_temporary(entry.file_name().to_str()) {
+                continue;
+            }
+
+            let path = entry.into_path();
+            match crate::utils::calculate_file_hash(&path) {
+                Ok(hash) => {
+                    snapshot.files.insert(path, hash);
+                }
+                Err(_) => snapshot.unreadable.push(path),
+            }
+        }
+
+        Ok(snapshot)
+    }
+
+    /// Whether every file could be read
+    pub fn is_complete(&self) -> bool {
+        self.unreadable.is_empty()
+    }
+
+    /// Get the changes from `previous` to this snapshot
+    pub fn changes_since(&self, previous: &Snapshot) -> ChangeSet {
+        let mut changes = ChangeSet::default();
+
+        for (path, hash) in &self.files {
+            match previous.files.get(path) {
+                None => changes.added.push(path.clone()),
+                Some(previous_hash) if previous_hash != hash => changes.modified.push(path.clone()),
+                Some(_) => {}
+            }
+        }
+        for path in previous.files.keys() {
+            if !self.files.contains_key(path) {
+                changes.removed.push(path.clone());
+            }
+        }
+
+        changes
+    }
+}
+
+/// Files changed between two snapshots
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ChangeSet {
+    pub added: Vec<PathBuf>,
+    pub modified: Vec<PathBuf>,
+    pub removed: Vec<PathBuf>,
+}
+
+impl ChangeSet {
+    /// Whether no file changed
+    pub fn is_empty(&self) -> bool {
+        self.added.is_empty() && self.modified.is_empty() && self.removed.is_empty()
+    }
+
+    /// Total number of changed files
+    pub fn len(&self) -> usize {
+        self.added.len() + self.modified.len() + self.removed.len()
+    }
+}
+
+/// Polls a directory for changes
+#[derive(Debug)]
+pub struct Watcher {
+    root: PathBuf,
+    ignored: Vec<PathBuf>,
+    interval: Duration,
+    debounce: Duration,
+    last: Snapshot,
+}
+
+impl Watcher {
+    /// Create a watcher, taking the initial snapshot
+    ///
+    /// Paths in `ignored`, such as output directories inside the watched
+    /// directory, are not watched.
+    pub fn new(
+        root: impl Into<PathBuf>,
+        ignored: &[PathBuf],
+        interval: Duration,
+        debounce: Duration,
+    ) -> Result<Self> {
+        let root = absolute(&root.into());
+        let ignored: Vec<PathBuf> = ignored.iter().map(|path| absolute(path)).collect();
+        let last = Snapshot::take(&root, &ignored)?;
+        Ok(Self {
+            root,
+            ignored,
+            interval,
+            debounce,
+            last,
+        })
+    }
+
+    /// Get the watched directory
+    pub fn root(&self) -> &Path {
+        &self.root
+    }
+
+    /// Wait until files change and the tree has settled
+    ///
+    /// Changes made while the caller handles the result are reported by the
+    /// next call, since they are compared against the snapshot taken here.
+    pub async fn wait_for_changes(&mut self) -> Result<ChangeSet> {
+        loop {
+            tokio::time::sleep(self.interval).await;
+            let current = Snapshot::take(&self.root, &self.ignored)?;
+            if current == self.last {
+                continue;
+            }
+
+            let settled = self.settle(current).await?;
+            let changes = settled.changes_since(&self.last);
+            self.last = settled;
+            if !changes.is_empty() {
+                return Ok(changes);
+            }
+        }
+    }
+
+    /// Poll until the tree has not changed for the debounce period
+    async fn settle(&self, mut current: Snapshot) -> Result<Snapshot> {
+        let mut stable_since = Instant::now();
+        loop {
+            if current.is_complete() && stable_since.elapsed() >= self.debounce {
+                return Ok(current);
+            }
+
+            tokio::time::sleep(self.interval).await;
+            let next = Snapshot::take(&self.root, &self.ignored)?;
+            if next != current {
+                current = next;
+                stable_since = Instant::now();
+            }
+        }
+    }
+}
+
+/// Content hashes of the inputs of each source written by hand outside its checkout
+///
+/// These are the overrides and `_custom/` mixins of the output directories,
+/// and the templates of Go AST sources with the files next to them, which
+/// they may import. A missing file has no hash, so creating an overrides
+/// file is a change.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Inputs {
+    /// Content hash of each file, keyed by source name and path
+    pub sources: BTreeMap<String, BTreeMap<PathBuf, String>>,
+}
+
+impl Inputs {
+    /// Hash the inputs of the sources of a configuration
+    pub fn take(config: &Config) -> Self {
+        let mut inputs = Inputs::default();
+        for source in &config.sources {
+            let files = inputs.sources.entry(source.name().to_string()).or_default();
+            for path in input_files(source) {
+                if let Ok(hash) = calculate_file_hash(&path) {
+                    files.insert(path, hash);
+                }
+            }
+        }
+        inputs
+    }
+
+    /// Get the files of every source
+    pub fn files(&self) -> impl Iterator<Item = &PathBuf> {
+        self.sources.values().flat_map(|files| files.keys())
+    }
+
+    /// Get the sources whose inputs changed since `previous`, with the changed files
+    ///
+    /// Sources missing from `previous` are left out, since the configuration
+    /// added them.
+    pub fn changes_since(&self, previous: &Inputs) -> Vec<(String, Vec<PathBuf>)> {
+        let mut changes = Vec::new();
+        for (name, files) in &self.sources {
+            let Some(previous_files) = previous.sources.get(name) else {
+                continue;
+            };
+            let mut changed: Vec<PathBuf> = files
+                .iter()
+                .filter(|(path, hash)| previous_files.get(*path) != Some(*hash))
+                .map(|(path, _)| path.clone())
+                .collect();
+            changed.extend(
+                previous_files
+                    .keys()
+                    .filter(|path| !files.contains_key(*path))
+                    .cloned(),
+            );
+            if !changed.is_empty() {
+                changed.sort();
+                changes.push((name.clone(), changed));
+            }
+        }
+        changes
+    }
+}
+
+/// Get the files of a source written by hand outside its checkout
+fn input_files(source: &Source) -> Vec<PathBuf> {
+    let mut files = Vec::new();
+    for dir in crate::source_output_paths(source) {
+        files.push(dir.join(OVERRIDES_FILE));
+        let custom_dir = dir.join(CUSTOM_DIR);
+        if custom_dir.is_dir() {
+            files.extend(
+                WalkDir::new(custom_dir)
+                    .into_iter()
+                    .filter_map(|entry| entry.ok())
+                    .filter(|entry| entry.file_type().is_file())
+                    .map(|entry| entry.into_path()),
+            );
+        }
+    }
+    if let Source::GoAst(go_ast) = source {
+        for template in &go_ast.options.templates {
+            let dir = match template.parent() {
+                Some(dir) if !dir.as_os_str().is_empty() => dir,
+                _ => Path::new("."),
+            };
+            let Ok(entries) = std::fs::read_dir(dir) else {
+                continue;
+            };
+            files.extend(
+                entries
+                    .filter_map(|entry| entry.ok())
+                    .filter(|entry| entry.file_type().is_ok_and(|file_type| file_type.is_file()))
+                    .filter(|entry| {
+                        let name = entry.file_name();
+                        !is_hidden(name.to_str()) && !is_temporary(name.to_str())
+                    })
+                    .map(|entry| entry.path()),
+            );
+        }
+    }
+    files.sort();
+    files.dedup();
+    files
+}
+
+/// Reload a configuration file and compare it, and the inputs of its
+/// sources, with the running configuration and its inputs
+///
+/// Fails for an invalid file; the running configuration stays in use.
+pub fn reload_config(
+    path: &Path,
+    running: &Config,
+    inputs: &Inputs,
+) -> Result<(Config, Inputs, ConfigDiff)> {
+    let config = Config::from_file(&path.to_path_buf())?;
+    let new_inputs = Inputs::take(&config);
+    let mut diff = ConfigDiff::between(running, &config)?;
+    diff.inputs = new_inputs.changes_since(inputs);
+    Ok((config, new_inputs, diff))
+}
+
+/// Changes between two configurations
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ConfigDiff {
+    /// Changed settings shared by every source (`output.base_path`, ...)
+    pub global: Vec<String>,
+
+    /// Sources that were added
+    pub added: Vec<String>,
+
+    /// Changed sources, with their changed settings (`api: options.type_overrides`)
+    pub changed: Vec<(String, Vec<String>)>,
+
+    /// Sources that were removed; their outputs are left in place
+    pub removed: Vec<String>,
+
+    /// Sources whose inputs written by hand changed, with the changed files
+    pub inputs: Vec<(String, Vec<PathBuf>)>,
+}
+
+impl ConfigDiff {
+    /// Compare a configuration with the one replacing it
+    pub fn between(old: &Config, new: &Config) -> Result<Self> {
+        let mut diff = ConfigDiff::default();
+
+        for section in ["version", "output", "generation", "plugins"] {
+            let old_value = serde_json::to_value(old)?[section].take();
+            let new_value = serde_json::to_value(new)?[section].take();
+            diff.global
+                .extend(changed_settings(section, &old_value, &new_value, 1));
+        }
+
+        let old_sources: BTreeMap<&str, Value> = old
+            .sources
+            .iter()
+            .map(|source| Ok((source.name(), serde_json::to_value(source)?)))
+            .collect::<Result<_>>()?;
+        for source in &new.sources {
+            let new_value = serde_json::to_value(source)?;
+            match old_sources.get(source.name()) {
+                None => diff.added.push(source.name().to_string()),
+                Some(old_value) => {
+                    let settings = changed_settings("", old_value, &new_value, 2);
+                    if !settings.is_empty() {
+                        diff.changed.push((source.name().to_string(), settings));
+                    }
+                }
+            }
+        }
+        for name in old_sources.keys() {
+            if !new.sources.iter().any(|source| source.name() == *name) {
+                diff.removed.push(name.to_string());
+            }
+        }
+
+        Ok(diff)
+    }
+
+    /// Whether the configurations are equivalent
+    pub fn is_empty(&self) -> bool {
+        self.global.is_empty()
+            && self.added.is_empty()
+            && self.changed.is_empty()
+            && self.removed.is_empty()
+            && self.inputs.is_empty()
+    }
+
+    /// Get the names of the sources of `new` whose outputs must be regenerated
+    ///
+    /// Every source is regenerated when a shared setting changed.
+    pub fn invalidated<'a>(&self, new: &'a Config) -> Vec<&'a str> {
+        new.sources
+            .iter()
+            .map(|source| source.name())
+            .filter(|name| {
+                !self.global.is_empty()
+                    || self.added.iter().any(|added| added == name)
+                    || self.changed.iter().any(|(changed, _)| changed == name)
+                    || self.inputs.iter().any(|(changed, _)| changed == name)
+            })
+            .collect()
+    }
+
+    /// Describe the changes, one line each
+    pub fn summary(&self) -> Vec<String> {
+        let mut lines = Vec::new();
+        if !self.global.is_empty() {
+            lines.push(format!("changed {}", self.global.join(", ")));
+        }
+        for name in &self.added {
+            lines.push(format!("source {name} added"));
+        }
+        for (name, settings) in &self.changed {
+            lines.push(format!("source {name}: changed {}", settings.join(", ")));
+        }
+        for name in &self.removed {
+            lines.push(format!(
+                "source {name} removed; its generated files were left in place"
+            ));
+        }
+        for (name, files) in &self.inputs {
+            let files: Vec<String> = files
+                .iter()
+                .map(|file| file.display().to_string())
+                .collect();
+            lines.push(format!("source {name}: changed {}", files.join(", ")));
+        }
+        lines
+    }
+}
+
+/// List the settings that differ between two values
+///
+/// Objects are compared key by key down to `depth` levels, so a change is
+/// reported as `options.type_overrides` rather than as the whole source.
+fn changed_settings(prefix: &str, old: &Value, new: &Value, depth: usize) -> Vec<String> {
+    if old == new {
+        return Vec::new();
+    }
+    let (Value::Object(old_map), Value::Object(new_map), true) = (old, new, depth > 0) else {
+        return vec![prefix.to_string()];
+    };
+
+    let mut keys: Vec<&String> = old_map.keys().chain(new_map.keys()).collect();
+    keys.sort();
+    keys.dedup();
+    keys.into_iter()
+        .flat_map(|key| {
+            let path = if prefix.is_empty() {
+                key.clone()
+            } else {
+                format!("{prefix}.{key}")
+            };
+            changed_settings(
+                &path,
+                old_map.get(key).unwrap_or(&Value::Null),
+                new_map.get(key).unwrap_or(&Value::Null),
+                depth - 1,
+            )
+        })
+        .collect()
+}
+
+/// Get the absolute form of a path, resolving symbolic links when it exists
+fn absolute(path: &Path) -> PathBuf {
+    path.canonicalize().unwrap_or_else(|_| {
+        std::env::current_dir()
+            .map(|dir| dir.join(path))
+            .unwrap_or_else(|_| path.to_path_buf())
+    })
+}
+
+/// Whether a directory or file name is hidden, such as `.git`
+fn is_hidden(name: Option<&str>) -> bool {
+    name.is_some_and(|name| name.starts_with('.'))
+}
+
+/// Whether a file name belongs to an editor's temporary or backup file
+fn is_temporary(name: Option<&str>) -> bool {
+    let Some(name) = name else {
+        return true;
+    };
+
+    name.ends_with('~')
+        || name.ends_with(".swp")
+        || name.ends_with(".swx")
+        || name.ends_with(".tmp")
+        || (name.starts_with('#') && name.ends_with('#'))
+        // Vim checks whether a directory is writable by creating this file
+        || name == "4913"
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_snapshot_changes() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        std::fs::write(root.join("user.go"), "package api").unwrap();
+        std::fs::write(root.join("group.go"), "package api").unwrap();
+        let before = Snapshot::take(root, &[]).unwrap();
+
+        std::fs::write(root.join("user.go"), "package api // changed").unwrap();
+        std::fs::remove_file(root.join("group.go")).unwrap();
+        std::fs::write(root.join("role.go"), "package api").unwrap();
+        let after = Snapshot::take(root, &[]).unwrap();
+
+        let changes = after.changes_since(&before);
+        assert_eq!(changes.modified, vec![root.join("user.go")]);
+        assert_eq!(changes.removed, vec![root.join("group.go")]);
+        assert_eq!(changes.added, vec![root.join("role.go")]);
+        assert_eq!(changes.len(), 3);
+    }
+
+    #[test]
+    fn test_snapshot_ignores_temporary_files() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        std::fs::write(root.join("user.go"), "package api").unwrap();
+        let before = Snapshot::take(root, &[]).unwrap();
+
+        std::fs::create_dir(root.join(".git")).unwrap();
+        std::fs::write(root.join(".git/index"), "").unwrap();
+        std::fs::write(root.join("user.go~"), "package api").unwrap();
+        std::fs::write(root.join(".user.go.swp"), "").unwrap();
+        std::fs::write(root.join("4913"), "").unwrap();
+
+        assert!(Snapshot::take(root, &[])
+            .unwrap()
+            .changes_since(&before)
+            .is_empty());
+    }
+
+    #[test]
+    fn test_snapshot_skips_ignored_paths() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        std::fs::create_dir(root.join("lib")).unwrap();
+        std::fs::write(root.join("user.go"), "package api").unwrap();
+        std::fs::write(root.join("lib/user.libsonnet"), "{}").unwrap();
+
+        let snapshot = Snapshot::take(root, &[root.join("lib")]).unwrap();
+        assert_eq!(
+            snapshot.files.keys().collect::<Vec<_>>(),
+            vec![&root.join("user.go")]
+        );
+    }
+
+    #[test]
+    fn test_rename_replace_save() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path();
+        std::fs::write(root.join("user.go"), "package api").unwrap();
+        let before = Snapshot::take(root, &[]).unwrap();
+
+        // Write the new content next to the file and rename it over the original
+        std::fs::write(root.join("user.go.tmp"), "package api // saved").unwrap();
+        std::fs::rename(root.join("user.go.tmp"), root.join("user.go")).unwrap();
+
+        let changes = Snapshot::take(root, &[]).unwrap().changes_since(&before);
+        assert_eq!(changes.modified, vec![root.join("user.go")]);
+        assert!(changes.added.is_empty());
+        assert!(changes.removed.is_empty());
+    }
+
+    #[test]
+    fn test_config_diff() {
+        let config = |sources: &str| Config {
+            sources: serde_yaml::from_str(sources).unwrap(),
+            ..Config::default()
+        };
+        let source = |name: &str, options: &str| {
+            format!(
+                "{{type: go_ast, name: {name}, git: {{url: 'https://github.com/example/api.git'}}, include_patterns: ['**/*.go'], exclude_patterns: [], output_path: ./gen/{name}, options: {options}}}"
+            )
+        };
+
+        let old = config(&format!(
+            "[{}, {}]",
+            source("api", "{}"),
+            source("batch", "{}")
+        ));
+        let new = config(&format!(
+            "[{}, {}]",
+            source("api", "{any_policy: require}"),
+            source("jobs", "{}")
+        ));
+
+        let diff = ConfigDiff::between(&old, &new).unwrap();
+        assert!(diff.global.is_empty());
+        assert_eq!(diff.added, vec!["jobs"]);
+        assert_eq!(
+            diff.changed,
+            vec![("api".to_string(), vec!["options.any_policy".to_string()])]
+        );
+        assert_eq!(diff.removed, vec!["batch"]);
+        assert_eq!(diff.invalidated(&new), vec!["api", "jobs"]);
+        assert_eq!(diff.summary()[1], "source api: changed options.any_policy");
+
+        assert!(ConfigDiff::between(&old, &old).unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_input_changes() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let dir = temp_dir.path();
+        std::fs::create_dir_all(dir.join("templates")).unwrap();
+        std::fs::write(dir.join("templates/service.jsonnet"), "{}").unwrap();
+        std::fs::write(dir.join("templates/helpers.libsonnet"), "{}").unwrap();
+        let config = Config {
+            sources: serde_yaml::from_str(&format!(
+                "[{{type: go_ast, name: api, git: {{url: 'https://github.com/example/api.git'}}, include_patterns: ['**/*.go'], exclude_patterns: [], output_path: {0}/gen/api, options: {{templates: [{0}/templates/service.jsonnet]}}}}]",
+                dir.display()
+            ))
+            .unwrap(),
+            ..Config::default()
+        };
+        let before = Inputs::take(&config);
+        assert_eq!(before.files().count(), 2);
+
+        // A new overrides file and an edited helper of a template
+        std::fs::create_dir_all(dir.join("gen/api")).unwrap();
+        std::fs::write(dir.join("gen/api").join(OVERRIDES_FILE), "{}").unwrap();
+        std::fs::write(dir.join("templates/helpers.libsonnet"), "{ a: 1 }").unwrap();
+        let diff = ConfigDiff {
+            inputs: Inputs::take(&config).changes_since(&before),
+            ..ConfigDiff::default()
+        };
+        assert_eq!(
+            diff.inputs,
+            vec![(
+                "api".to_string(),
+                vec![
+                    dir.join("gen/api").join(OVERRIDES_FILE),
+                    dir.join("templates/helpers.libsonnet"),
+                ]
+            )]
+        );
+        assert_eq!(diff.invalidated(&config), vec!["api"]);
+        assert!(diff.summary()[0].starts_with("source api: changed "));
+    }
+
+    #[tokio::test]
+    async fn test_watcher_debounces_changes() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let root = temp_dir.path().to_path_buf();
+        std::fs::write(root.join("user.go"), "package api").unwrap();
+        let mut watcher = Watcher::new(
+            &root,
+            &[],
+            Duration::from_millis(10),
+            Duration::from_millis(50),
+        )
+        .unwrap();
+
+        let writer_root = root.clone();
+        let writer = tokio::spawn(async move {
+            for i in 0..3 {
+                std::fs::write(writer_root.join("user.go"), format!("package api // {i}")).unwrap();
+                tokio::time::sleep(Duration::from_millis(15)).await;
+            }
+        });
+
+        let changes = watcher.wait_for_changes().await.unwrap();
+        writer.await.unwrap();
+        assert_eq!(changes.modified, vec![watcher.root().join("user.go")]);
+        // Only reported once the last write was picked up
+        assert_eq!(watcher.last, Snapshot::take(watcher.root(), &[]).unwrap());
+    }
+}
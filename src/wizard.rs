@@ -0,0 +1,647 @@
+//! Starter configurations for Go modules
+//!
+//! `gensonnet init --scan` looks through the packages of a Go module and
+//! proposes a Go AST source for those declaring exported struct types, so
+//! large codebases get a first working configuration without going through
+//! the option reference. The scan reads the files as text and notes:
+//!
+//! - kubebuilder markers; when every package chosen is a kubebuilder API, the
+//!   types marked `+kubebuilder:object:root=true` become the `roots` of the
+//!   source
+//! - well-known types of other modules with an encoding of their own, such as
+//!   `uuid.UUID`, which get a `type_mappings` entry (the standard library and
+//!   Kubernetes types the parser maps by itself need none)
+//! - struct tag conventions, turning on `omit_empty` when most `json` tags
+//!   carry `omitempty`
+
+use anyhow::{anyhow, Result};
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::Path;
+use walkdir::WalkDir;
+
+use crate::config::{GitSource, GoAstSource};
+use crate::plugin::ast::options::{
+    builtin_type_mapping, default_package_name, GoAstOptions, TypeMapping,
+};
+use crate::plugin::ast::tags::StructTag;
+
+/// Marker of the root types of kubebuilder APIs
+const ROOT_MARKER: &str = "+kubebuilder:object:root=true";
+
+/// Types of other modules encoded as strings, with the format they map to
+const WELL_KNOWN_TYPES: &[(&str, &str, Option<&str>)] = &[
+    ("github.com/google/uuid", "UUID", Some("uuid")),
+    ("github.com/gofrs/uuid", "UUID", Some("uuid")),
+    ("github.com/shopspring/decimal", "Decimal", None),
+    ("net/netip", "Addr", None),
+    ("net/netip", "Prefix", None),
+];
+
+/// Hosts whose repositories are the first two segments of the path
+const FORGES: &[&str] = &["github.com", "gitlab.com", "bitbucket.org"];
+
+/// What the scan found in the directory of a package
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct PackageScan {
+    /// Directory relative to the module, `/`-separated; empty for its root
+    pub dir: String,
+
+    /// Name of the Go package
+    pub package: String,
+
+    /// Exported struct types, sorted
+    pub types: Vec<String>,
+
+    /// Types marked as kubebuilder roots, sorted
+    pub roots: Vec<String>,
+
+    /// Whether the package carries kubebuilder markers
+    pub kubebuilder: bool,
+
+    /// Types of imported packages the files refer to, as (import path, name)
+    pub imported_types: BTreeSet<(String, String)>,
+
+    /// Number of struct fields tagged with each key
+    pub tags: BTreeMap<String, usize>,
+
+    /// Number of struct fields whose `json` tag carries `omitempty`
+    pub omitempty: usize,
+}
+
+impl PackageScan {
+    /// One-line summary, as shown when proposing the package
+    pub fn summary(&self) -> String {
+        let dir = if self.dir.is_empty() { "." } else { &self.dir };
+        let mut summary = format!(
+            "{} (package {}): {} type{}: {}",
+            dir,
+            self.package,
+            self.types.len(),
+            if self.types.len() == 1 { "" } else { "s" },
+            self.types.join(", ")
+        );
+        if self.kubebuilder {
+            summary.push_str("; kubebuilder API");
+            if !self.roots.is_empty() {
+                summary.push_str(&format!(" with roots {}", self.roots.join(", ")));
+            }
+        }
+        summary
+    }
+}
+
+/// Packages of a Go module declaring exported struct types
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ModuleScan {
+    /// Module path declared by `go.mod`
+    pub module: Option<String>,
+
+    /// Packages, sorted by directory
+    pub packages: Vec<PackageScan>,
+}
+
+/// Scan the packages of the Go module in `dir`
+///
+/// Tests, `vendor`, `testdata`, hidden directories and nested modules are
+/// skipped, as the Go tool does.
+pub fn scan_module(dir: &Path) -> Result<ModuleScan> {
+    if !dir.is_dir() {
+        return Err(anyhow!("{} is not a directory", dir.display()));
+    }
+    let module = std::fs::read_to_string(dir.join("go.mod"))
+        .ok()
+        .and_then(|go_mod| {
+            go_mod.lines().find_map(|line| {
+                let module = line.trim().strip_prefix("module ")?;
+                Some(module.trim().trim_matches('"').to_string())
+            })
+        });
+
+    let mut packages: BTreeMap<String, PackageScan> = BTreeMap::new();
+    let entries = WalkDir::new(dir).into_iter().filter_entry(|entry| {
+        let name = entry.file_name().to_string_lossy();
+        entry.depth() == 0
+            || !entry.file_type().is_dir()
+            || !(name.starts_with('.')
+                || name.starts_with('_')
+                || name == "vendor"
+                || name == "testdata"
+                || entry.path().join("go.mod").is_file())
+    });
+    for entry in entries {
+        let entry = entry?;
+        let name = entry.file_name().to_string_lossy();
+        if !entry.file_type().is_file() || !name.ends_with(".go") || name.ends_with("_test.go") {
+            continue;
+        }
+        let Ok(content) = std::fs::read_to_string(entry.path()) else {
+            continue;
+        };
+        let relative = entry
+            .path()
+            .parent()
+            .unwrap_or(dir)
+            .strip_prefix(dir)?
+            .to_string_lossy()
+            .replace('\\', "/");
+        let package = packages
+            .entry(relative.clone())
+            .or_insert_with(|| PackageScan {
+                dir: relative,
+                ..Default::default()
+            });
+        scan_file(&content, package);
+    }
+
+    let packages = packages
+        .into_values()
+        .filter(|package| !package.types.is_empty())
+        .map(|mut package| {
+            package.types.sort();
+            package.roots.sort();
+            package
+        })
+        .collect();
+    Ok(ModuleScan { module, packages })
+}
+
+/// Add what a file declares to the scan of its package
+fn scan_file(content: &str, package: &mut PackageScan) {
+    let mut imports: BTreeMap<String, String> = BTreeMap::new();
+    let mut comments: Vec<&str> = Vec::new();
+    let mut in_imports = false;
+    let mut in_types = false;
+    let mut depth = 0usize;
+
+    for line in content.lines().map(str::trim) {
+        if let Some(comment) = line.strip_prefix("//") {
+            let comment = comment.trim();
+            if comment.starts_with("+kubebuilder:") || comment.starts_with("+groupName=") {
+                package.kubebuilder = true;
+            }
+            comments.push(comment);
+            continue;
+        }
+
+        // Fields of a struct, up to its closing brace
+        if depth > 0 {
+            comments.clear();
+            let (code, tag) = match (line.find('`'), line.rfind('`')) {
+                (Some(start), Some(end)) if start < end => (
+                    &line[..start],
+                    Some(StructTag::parse(&line[start + 1..end])),
+                ),
+                _ => (line, None),
+            };
+            if let Some(tag) = tag {
+                for key in tag.keys() {
+                    *package.tags.entry(key.to_string()).or_default() += 1;
+                }
+                if tag.has_option("json", "omitempty") {
+                    package.omitempty += 1;
+                }
+            }
+            depth = (depth + code.matches('{').count()).saturating_sub(code.matches('}').count());
+            continue;
+        }
+
+        if in_imports {
+            match line.starts_with(')') {
+                true => in_imports = false,
+                false => add_import(line, &mut imports),
+            }
+        } else if let Some(name) = line.strip_prefix("package ") {
+            if package.package.is_empty() {
+                package.package = name.split_whitespace().next().unwrap_or(name).to_string();
+            }
+        } else if line.starts_with("import (") {
+            in_imports = true;
+        } else if let Some(spec) = line.strip_prefix("import ") {
+            add_import(spec, &mut imports);
+        } else if line.starts_with("type (") {
+            in_types = true;
+        } else if in_types && line.starts_with(')') {
+            in_types = false;
+        } else {
+            let spec = match line.strip_prefix("type ") {
+                Some(spec) => Some(spec),
+                None if in_types => Some(line),
+                None => None,
+            };
+            if let Some((name, body)) = spec.and_then(struct_declaration) {
+                if name.starts_with(|c: char| c.is_ascii_uppercase()) {
+                    package.types.push(name.to_string());
+                    if comments.contains(&ROOT_MARKER) {
+                        package.roots.push(name.to_string());
+                    }
+                }
+                depth = body
+                    .matches('{')
+                    .count()
+                    .saturating_sub(body.matches('}').count());
+            }
+        }
+        comments.clear();
+    }
+
+    // References to the exported types of imported packages (`uuid.UUID`)
+    for (name, path) in &imports {
+        let prefix = format!("{name}.");
+        for (idx, _) in content.match_indices(&prefix) {
+            let qualified = content[..idx]
+                .chars()
+                .next_back()
+                .is_some_and(|c| c.is_alphanumeric() || c == '_' || c == '.');
+            let member: String = content[idx + prefix.len()..]
+                .chars()
+                .take_while(|c| c.is_alphanumeric() || *c == '_')
+                .collect();
+            if !qualified && member.starts_with(|c: char| c.is_ascii_uppercase()) {
+                package.imported_types.insert((path.clone(), member));
+            }
+        }
+    }
+}
+
+/// Record an import spec (`"time"`, `uuid "github.com/google/uuid"`) by the
+/// name files refer to it with
+fn add_import(spec: &str, imports: &mut BTreeMap<String, String>) {
+    let Some((alias, rest)) = spec.split_once('"') else {
+        return;
+    };
+    let Some((path, _)) = rest.split_once('"') else {
+        return;
+    };
+    let name = match alias.trim() {
+        "" => default_package_name(path),
+        "_" | "." => return,
+        alias => alias,
+    };
+    imports.insert(name.to_string(), path.to_string());
+}
+
+/// Get the name of a struct declared by a type spec, and the rest of the line
+/// from its `struct` keyword
+fn struct_declaration(spec: &str) -> Option<(&str, &str)> {
+    let name_end = spec.find(|c: char| !(c.is_alphanumeric() || c == '_'))?;
+    let name = &spec[..name_end];
+    let mut rest = spec[name_end..].trim_start();
+    // Type parameters of generic structs
+    if rest.starts_with('[') {
+        rest = &rest[rest.find(']')? + 1..];
+    }
+    let body = rest.trim_start();
+    (!name.is_empty() && body.starts_with("struct")).then_some((name, body))
+}
+
+/// Guess the repository and directory of a module from its path
+///
+/// Modules of GitHub, GitLab and Bitbucket live in the repository named by
+/// the first two segments after the host; for other hosts the module path is
+/// taken as the repository. Returns `None` for paths without a host.
+pub fn module_repository(module: &str) -> Option<(String, String)> {
+    let segments: Vec<&str> = module.split('/').collect();
+    if !segments[0].contains('.') {
+        return None;
+    }
+    if !FORGES.contains(&segments[0]) || segments.len() < 3 {
+        return Some((format!("https://{module}"), String::new()));
+    }
+    let mut dir = &segments[3..];
+    // Major version suffixes (`.../v2`) are not directories
+    if let Some((last, rest)) = dir.split_last() {
+        if last.len() > 1 && last.starts_with('v') && last[1..].chars().all(|c| c.is_ascii_digit())
+        {
+            dir = rest;
+        }
+    }
+    Some((
+        format!("https://{}", segments[..3].join("/")),
+        dir.join("/"),
+    ))
+}
+
+/// Find the repository of a directory from the `origin` remote of its Git
+/// checkout, with the directory's path inside the checkout
+pub fn checkout_repository(dir: &Path) -> Option<(String, String)> {
+    let git = |args: &[&str]| {
+        let output = std::process::Command::new("git")
+            .args(args)
+            .current_dir(dir)
+            .output()
+            .ok()?;
+        output
+            .status
+            .success()
+            .then(|| String::from_utf8_lossy(&output.stdout).trim().to_string())
+    };
+    let url = git(&["remote", "get-url", "origin"])?;
+    let prefix = git(&["rev-parse", "--show-prefix"])?;
+    Some((url, prefix.trim_end_matches('/').to_string()))
+}
+
+/// Propose a Go AST source generating the chosen packages of a scan
+///
+/// `dir` is the directory of the module inside the repository of `git`, and
+/// the source is written below `output_base` under its name.
+pub fn propose_source(
+    scan: &ModuleScan,
+    packages: &[&PackageScan],
+    git: GitSource,
+    dir: &str,
+    output_base: &Path,
+) -> GoAstSource {
+    let name = scan
+        .module
+        .as_deref()
+        .map(|module| default_package_name(module).to_string())
+        .or_else(|| packages.first().map(|package| package.package.clone()))
+        .unwrap_or_else(|| "go-types".to_string());
+    let include_patterns = packages
+        .iter()
+        .map(|package| {
+            let dirs: Vec<&str> = [dir, package.dir.as_str()]
+                .into_iter()
+                .filter(|dir| !dir.is_empty())
+                .collect();
+            match dirs.is_empty() {
+                true => "*.go".to_string(),
+                false => format!("{}/*.go", dirs.join("/")),
+            }
+        })
+        .collect();
+
+    let mut options = GoAstOptions::default();
+    if packages.iter().all(|package| !package.roots.is_empty()) {
+        options.roots = packages
+            .iter()
+            .flat_map(|package| {
+                package
+                    .roots
+                    .iter()
+                    .map(|root| format!("{}.{}", package.package, root))
+            })
+            .collect::<BTreeSet<_>>()
+            .into_iter()
+            .collect();
+    }
+    for (path, type_name) in packages.iter().flat_map(|package| &package.imported_types) {
+        if let Some((_, _, format)) = WELL_KNOWN_TYPES
+            .iter()
+            .find(|(known_path, known_name, _)| known_path == path && known_name == type_name)
+        {
+            options.type_mappings.insert(
+                format!("{path}.{type_name}"),
+                TypeMapping {
+                    schema_type: "string".to_string(),
+                    format: format.map(str::to_string),
+                    pattern: None,
+                    description: None,
+                },
+            );
+        }
+    }
+    let json_fields: usize = packages
+        .iter()
+        .map(|package| package.tags.get("json").copied().unwrap_or_default())
+        .sum();
+    let omitempty: usize = packages.iter().map(|package| package.omitempty).sum();
+    options.omit_empty = json_fields > 0 && omitempty * 2 > json_fields;
+
+    GoAstSource {
+        output_path: output_base.join(&name),
+        name,
+        git,
+        include_patterns,
+        exclude_patterns: vec!["**/*_test.go".to_string(), "vendor/**".to_string()],
+        package_filters: None,
+        options,
+    }
+}
+
+/// Describe what the proposal of the chosen packages is based on
+pub fn notes(packages: &[&PackageScan]) -> Vec<String> {
+    let mut builtin = BTreeSet::new();
+    let mut mapped = BTreeSet::new();
+    let mut tags: BTreeMap<&str, usize> = BTreeMap::new();
+    for package in packages {
+        for (path, name) in &package.imported_types {
+            let qualified = format!("{path}.{name}");
+            if builtin_type_mapping(path, name).is_some() {
+                builtin.insert(qualified);
+            } else if WELL_KNOWN_TYPES
+                .iter()
+                .any(|(known_path, known_name, _)| known_path == path && known_name == name)
+            {
+                mapped.insert(qualified);
+            }
+        }
+        for (key, count) in &package.tags {
+            *tags.entry(key).or_default() += count;
+        }
+    }
+    let omitempty: usize = packages.iter().map(|package| package.omitempty).sum();
+
+    let mut notes = Vec::new();
+    if !builtin.is_empty() {
+        notes.push(format!(
+            "Mapped by the parser: {}",
+            builtin.into_iter().collect::<Vec<_>>().join(", ")
+        ));
+    }
+    if !mapped.is_empty() {
+        notes.push(format!(
+            "Type mappings added for: {}",
+            mapped.into_iter().collect::<Vec<_>>().join(", ")
+        ));
+    }
+    if !tags.is_empty() {
+        let mut counts: Vec<String> = tags
+            .iter()
+            .map(|(key, count)| format!("{key} on {count}"))
+            .collect();
+        counts[0].push_str(" fields");
+        if omitempty > 0 {
+            counts.push(format!("omitempty on {omitempty}"));
+        }
+        notes.push(format!("Struct tags: {}", counts.join(", ")));
+    }
+    if tags.contains_key("validate") {
+        notes.push("validate tags become schema checks of the generated libraries".to_string());
+    }
+    notes
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn git() -> GitSource {
+        GitSource {
+            url: "https://github.com/acme/operator".to_string(),
+            ref_name: None,
+            auth: None,
+        }
+    }
+
+    fn write_module(dir: &Path) {
+        let files = [
+            ("go.mod", "module github.com/acme/operator\n\ngo 1.22\n"),
+            (
+                "api/v1/groupversion_info.go",
+                "// +groupName=acme.io\npackage v1\n",
+            ),
+            (
+                "api/v1/widget_types.go",
+                r#"package v1
+
+import (
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "github.com/google/uuid"
+)
+
+type WidgetSpec struct {
+    ID uuid.UUID `json:"id"`
+    Size int `json:"size,omitempty" validate:"min=1"`
+    Labels map[string]string `json:"labels,omitempty"`
+    Nested struct {
+        Name string `json:"name,omitempty"`
+    } `json:"nested,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type Widget struct {
+    metav1.TypeMeta `json:",inline"`
+    metav1.ObjectMeta `json:"metadata,omitempty"`
+    Spec WidgetSpec `json:"spec"`
+    Created metav1.Time `json:"created,omitempty"`
+}
+
+type (
+    // +kubebuilder:object:root=true
+    WidgetList struct {
+        Items []Widget `json:"items"`
+    }
+    widgetCache struct{}
+)
+"#,
+            ),
+            (
+                "api/v1/widget_test.go",
+                "package v1\n\ntype Fixture struct{}\n",
+            ),
+            (
+                "internal/util/util.go",
+                "package util\n\nfunc Max(a, b int) int { return a }\n",
+            ),
+            ("vendor/x/x.go", "package x\n\ntype Vendored struct{}\n"),
+            ("tools/go.mod", "module github.com/acme/operator/tools\n"),
+            ("tools/tools.go", "package tools\n\ntype Tool struct{}\n"),
+            (
+                "pkg/config/config.go",
+                "package config\n\ntype Config[T any] struct {\n    Value T\n}\n",
+            ),
+        ];
+        for (path, content) in files {
+            let path = dir.join(path);
+            std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+            std::fs::write(path, content).unwrap();
+        }
+    }
+
+    #[test]
+    fn test_scan_module() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        write_module(temp_dir.path());
+
+        let scan = scan_module(temp_dir.path()).unwrap();
+        assert_eq!(scan.module.as_deref(), Some("github.com/acme/operator"));
+        let dirs: Vec<&str> = scan.packages.iter().map(|p| p.dir.as_str()).collect();
+        assert_eq!(dirs, vec!["api/v1", "pkg/config"]);
+
+        let api = &scan.packages[0];
+        assert_eq!(api.package, "v1");
+        assert_eq!(api.types, vec!["Widget", "WidgetList", "WidgetSpec"]);
+        assert_eq!(api.roots, vec!["Widget", "WidgetList"]);
+        assert!(api.kubebuilder);
+        assert!(api
+            .imported_types
+            .contains(&("github.com/google/uuid".to_string(), "UUID".to_string())));
+        assert!(api.imported_types.contains(&(
+            "k8s.io/apimachinery/pkg/apis/meta/v1".to_string(),
+            "Time".to_string()
+        )));
+        assert_eq!(api.tags["json"], 10);
+        assert_eq!(api.tags["validate"], 1);
+        assert_eq!(api.omitempty, 6);
+        assert_eq!(
+            api.summary(),
+            "api/v1 (package v1): 3 types: Widget, WidgetList, WidgetSpec; kubebuilder API with roots Widget, WidgetList"
+        );
+
+        assert_eq!(scan.packages[1].types, vec!["Config"]);
+        assert!(!scan.packages[1].kubebuilder);
+    }
+
+    #[test]
+    fn test_propose_source() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        write_module(temp_dir.path());
+        let scan = scan_module(temp_dir.path()).unwrap();
+
+        let api = [&scan.packages[0]];
+        let source = propose_source(&scan, &api, git(), "", Path::new("./generated"));
+        source.validate().unwrap();
+        assert_eq!(source.name, "operator");
+        assert_eq!(source.output_path, Path::new("./generated/operator"));
+        assert_eq!(source.include_patterns, vec!["api/v1/*.go"]);
+        assert_eq!(source.options.roots, vec!["v1.Widget", "v1.WidgetList"]);
+        assert_eq!(
+            source.options.type_mappings["github.com/google/uuid.UUID"].format,
+            Some("uuid".to_string())
+        );
+        assert!(source.options.omit_empty);
+
+        // Roots would leave out the types of packages without any
+        let all: Vec<&PackageScan> = scan.packages.iter().collect();
+        let source = propose_source(&scan, &all, git(), "operator", Path::new("./generated"));
+        assert!(source.options.roots.is_empty());
+        assert_eq!(
+            source.include_patterns,
+            vec!["operator/api/v1/*.go", "operator/pkg/config/*.go"]
+        );
+
+        assert_eq!(
+            notes(&api),
+            vec![
+                "Mapped by the parser: k8s.io/apimachinery/pkg/apis/meta/v1.Time",
+                "Type mappings added for: github.com/google/uuid.UUID",
+                "Struct tags: json on 10 fields, validate on 1, omitempty on 6",
+                "validate tags become schema checks of the generated libraries",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_module_repository() {
+        assert_eq!(
+            module_repository("github.com/acme/operator/v2"),
+            Some((
+                "https://github.com/acme/operator".to_string(),
+                String::new()
+            ))
+        );
+        assert_eq!(
+            module_repository("github.com/acme/mono/services/users"),
+            Some((
+                "https://github.com/acme/mono".to_string(),
+                "services/users".to_string()
+            ))
+        );
+        assert_eq!(
+            module_repository("go.acme.dev/operator"),
+            Some(("https://go.acme.dev/operator".to_string(), String::new()))
+        );
+        assert_eq!(module_repository("operator"), None);
+    }
+}
@@ -0,0 +1,203 @@
+//! Bazel persistent worker protocol
+//!
+//! Started with `--persistent_worker`, the generator stays up between build
+//! actions and reads one `WorkRequest` per line of stdin, in the JSON
+//! protocol of Bazel workers (`requires-worker-protocol: json`). Each request
+//! holds the arguments of one action; its `WorkResponse` is written as one
+//! line of stdout:
+//!
+//! ```text
+//! {"arguments":["--name","api","--src","api/user.go","--output","out/api"],"requestId":0}
+//! {"exitCode":0,"output":"Generated 3 files for api\n","requestId":0}
+//! ```
+//!
+//! Requests are handled one at a time, in the order they arrive.
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::future::Future;
+use tokio::io::{AsyncBufRead, AsyncBufReadExt, AsyncWrite, AsyncWriteExt};
+
+/// An input file of a request, with the digest computed by Bazel
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct WorkInput {
+    pub path: String,
+
+    #[serde(default)]
+    pub digest: String,
+}
+
+/// The arguments of one build action
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct WorkRequest {
+    #[serde(default)]
+    pub arguments: Vec<String>,
+
+    #[serde(default)]
+    pub inputs: Vec<WorkInput>,
+
+    /// Zero for workers handling one request at a time
+    #[serde(default)]
+    pub request_id: i64,
+
+    /// Whether Bazel cancels an earlier request of this id
+    #[serde(default)]
+    pub cancel: bool,
+}
+
+/// The result of one build action
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct WorkResponse {
+    pub exit_code: i32,
+
+    /// Messages shown to the user by Bazel
+    pub output: String,
+
+    pub request_id: i64,
+
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub was_cancelled: bool,
+}
+
+/// Answer the requests of `reader` on `writer` until `reader` is closed
+///
+/// `handler` runs the arguments of a request and returns its output; an
+/// error fails the action with the error as output. Requests are finished
+/// before they can be cancelled, so cancellations are acknowledged without
+/// effect.
+pub async fn serve<R, W, F, Fut>(reader: R, mut writer: W, mut handler: F) -> Result<()>
+where
+    R: AsyncBufRead + Unpin,
+    W: AsyncWrite + Unpin,
+    F: FnMut(Vec<String>) -> Fut,
+    Fut: Future<Output = Result<String>>,
+{
+    let mut lines = reader.lines();
+    while let Some(line) = lines.next_line().await? {
+        if line.trim().is_empty() {
+            continue;
+        }
+        let request: WorkRequest = serde_json::from_str(&line)
+            .map_err(|e| anyhow::anyhow!("Invalid work request: {}", e))?;
+
+        let response = if request.cancel {
+            WorkResponse {
+                request_id: request.request_id,
+                was_cancelled: true,
+                ..Default::default()
+            }
+        } else {
+            match handler(request.arguments).await {
+                Ok(output) => WorkResponse {
+                    exit_code: 0,
+                    output,
+                    request_id: request.request_id,
+                    was_cancelled: false,
+                },
+                Err(e) => WorkResponse {
+                    exit_code: 1,
+                    output: format!("{e:#}\n"),
+                    request_id: request.request_id,
+                    was_cancelled: false,
+                },
+            }
+        };
+
+        writer
+            .write_all((serde_json::to_string(&response)? + "\n").as_bytes())
+            .await?;
+        writer.flush().await?;
+    }
+    Ok(())
+}
+
+/// Expand `@flagfile` arguments into the arguments of the file, one per line
+///
+/// Bazel passes the arguments of actions that may run in a worker in a flag
+/// file.
+pub fn expand_flagfiles(arguments: Vec<String>) -> Result<Vec<String>> {
+    let mut expanded = Vec::new();
+    for argument in arguments {
+        match argument.strip_prefix('@') {
+            Some(path) => {
+                let content = std::fs::read_to_string(path)
+                    .map_err(|e| anyhow::anyhow!("Failed to read flag file {}: {}", path, e))?;
+                expanded.extend(
+                    content
+                        .lines()
+                        .filter(|line| !line.is_empty())
+                        .map(str::to_string),
+                );
+            }
+            None => expanded.push(argument),
+        }
+    }
+    Ok(expanded)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_serve() {
+        let input = concat!(
+            r#"{"arguments":["--name","api"],"inputs":[{"path":"api/user.go","digest":"ab"}],"requestId":0}"#,
+            "\n",
+            r#"{"arguments":["--fail"],"requestId":0}"#,
+            "\n",
+        );
+        let mut output = Vec::new();
+        serve(input.as_bytes(), &mut output, |arguments| async move {
+            if arguments.contains(&"--fail".to_string()) {
+                return Err(anyhow::anyhow!("no sources"));
+            }
+            Ok(format!("ran {}\n", arguments.join(" ")))
+        })
+        .await
+        .unwrap();
+
+        let responses: Vec<WorkResponse> = String::from_utf8(output)
+            .unwrap()
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+        assert_eq!(
+            responses,
+            vec![
+                WorkResponse {
+                    exit_code: 0,
+                    output: "ran --name api\n".to_string(),
+                    request_id: 0,
+                    was_cancelled: false,
+                },
+                WorkResponse {
+                    exit_code: 1,
+                    output: "no sources\n".to_string(),
+                    request_id: 0,
+                    was_cancelled: false,
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_expand_flagfiles() {
+        let temp_dir = tempfile::tempdir().unwrap();
+        let flagfile = temp_dir.path().join("args");
+        std::fs::write(&flagfile, "--src\napi/user.go\n\n--name\napi\n").unwrap();
+
+        let expanded = expand_flagfiles(vec![
+            "--output".to_string(),
+            "out".to_string(),
+            format!("@{}", flagfile.display()),
+        ])
+        .unwrap();
+        assert_eq!(
+            expanded,
+            vec!["--output", "out", "--src", "api/user.go", "--name", "api"]
+        );
+    }
+}